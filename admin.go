@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	ADMIN commands, available to the bot owner only.
+*/
+
+var startTime = time.Now()
+
+// readOnlyCommands are the commands still served while maintenance
+// (read-only) mode is on; false marks those that only read without
+// arguments, their arguments changing something. Every other command is
+// refused, and so are plain text, documents and the buttons outside
+// readOnlyCallbackPrefixes.
+var readOnlyCommands = map[string]bool{
+	"maintenance":                 true,
+	"start":                       true,
+	"help":                        true,
+	"menu":                        true,
+	"cancel":                      true,
+	"streaks":                     true,
+	"ref":                         false,
+	"refs":                        false,
+	"stats":                       true,
+	"users":                       true,
+	"backup":                      true,
+	"export_all":                  true,
+	"diskusage":                   true,
+	"viewers":                     true,
+	"doctor":                      false,
+	"webhook":                     false,
+	"webhooks":                    false,
+	"list":                        true,
+	"settings":                    true,
+	"summary":                     true,
+	"get_latest_report":           true,
+	"get_weekly_expense":          true,
+	"get_weekly_expense_piechart": true,
+	"export_csv":                  true,
+	"export":                      true,
+	"forecast":                    true,
+	"networth":                    true,
+	"payments":                    true,
+	"reimbursements":              true,
+	"week":                        true,
+	"view":                        true,
+	"flow":                        true,
+	"trend":                       true,
+	"heatmap":                     true,
+	"top":                         true,
+	"insights":                    true,
+	"taxreport":                   true,
+	"ask":                         true,
+	"burnrate":                    true,
+	"calendar":                    true,
+	"search":                      true,
+	"map":                         true,
+	"suggestbudgets":              true,
+	"balances":                    true,
+	"reports":                     true,
+	"report":                      true, // see maintenanceMayRun
+	"categories":                  true, // see maintenanceMayRun
+	"category":                    true, // see maintenanceMayRun
+	"budget":                      false,
+	"allocate":                    false,
+	"bill":                        false,
+	"bills":                       false,
+	"subscription":                false,
+	"subscriptions":               false,
+	"invoice":                     false,
+	"invoices":                    false,
+	"goals":                       false,
+	"roundup":                     false,
+	"savingsrate":                 false,
+	"rules":                       false,
+	"ledger":                      false,
+	"portfolio":                   false,
+	"fx":                          false,
+	"receipts":                    false,
+	"schedules":                   false,
+	"schedule":                    false,
+	"close":                       false,
+	"reconcile":                   false,
+	"wallet":                      false,
+	"payment":                     false,
+	"eod":                         false,
+	"pin":                         false,
+	"weekstart":                   false,
+	"charts":                      false,
+	"indicators":                  false,
+	"notify":                      false,
+	"notifications":               false,
+	"apitoken":                    false,
+	"apitokens":                   false,
+}
+
+// readOnlyCallbackPrefixes are the buttons still served in maintenance mode.
+var readOnlyCallbackPrefixes = []string{"latest:"}
+
+const maintenanceRefusal = "The bot is in read-only maintenance mode. Please try again later."
+
+func isMaintenanceMode() bool {
+	return getBoolSetting("maintenance_mode", false)
+}
+
+// maintenanceMayRun tells whether command with args only reads, and may
+// run in maintenance mode.
+func maintenanceMayRun(command string, args string) bool {
+	withArgs, ok := readOnlyCommands[command]
+	if !ok {
+		return false
+	}
+	switch command {
+	case "report":
+		// running saved reports, as a viewer may
+		return viewerMayRun(command, args)
+	case "categories", "category":
+		return viewerMayRun("categories", args)
+	}
+	return withArgs || len(strings.Fields(args)) == 0
+}
+
+// maintenanceMayPress tells whether a button with data may be pressed in
+// maintenance mode.
+func maintenanceMayPress(data string) bool {
+	for _, prefix := range readOnlyCallbackPrefixes {
+		if strings.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordUserActivity keeps track of who talks to the bot and when.
+func recordUserActivity(user *TGUser, chatID int64) {
+	if user == nil {
+		return
+	}
+	_, err := db.Exec(`INSERT INTO user_activity (user_id, name, chat_id, last_seen, message_count) VALUES (?, ?, ?, CURRENT_TIMESTAMP, 1)
+		ON CONFLICT(user_id) DO UPDATE SET name = excluded.name, chat_id = excluded.chat_id, last_seen = CURRENT_TIMESTAMP, message_count = message_count + 1`,
+		user.ID, displayName(user), chatID)
+	if err != nil {
+		log.Printf("Failed to record user activity: %v", err)
+	}
+}
+
+// handleAdminCommand runs an owner-only command. It returns false when the
+// command is not an admin command.
+func handleAdminCommand(chatID int64, userID int64, command string, args string) bool {
+	switch command {
+	case "stats", "users", "broadcast", "maintenance", "backup", "doctor", "export_all", "import_all", "diskusage", "reload", "webhook", "webhooks", "grantviewer", "revokeviewer", "viewers", "mergecategories":
+	default:
+		return false
+	}
+	if userID != ownerID() {
+		sendMessage(chatID, "This command is only available to the bot owner.")
+		return true
+	}
+
+	switch command {
+	case "stats":
+		showStats(chatID)
+	case "users":
+		showUsers(chatID)
+	case "broadcast":
+		broadcast(chatID, strings.TrimSpace(args))
+	case "maintenance":
+		toggleMaintenance(chatID, strings.TrimSpace(args))
+	case "backup":
+		if strings.EqualFold(strings.TrimSpace(args), "offsite") {
+			sendOffsiteBackup(chatID)
+			return true
+		}
+		sendBackup(chatID)
+	case "doctor":
+		handleDoctorCommand(chatID, args)
+	case "export_all":
+		sendBundle(chatID)
+	case "import_all":
+		startImportBundle(chatID, userID)
+	case "reload":
+		handleReloadCommand(chatID)
+	case "diskusage":
+		showDiskUsage(chatID)
+	case "webhook", "webhooks":
+		handleWebhookCommand(chatID, args)
+	case "grantviewer":
+		handleGrantViewerCommand(chatID, userID, args)
+	case "revokeviewer":
+		handleRevokeViewerCommand(chatID, args)
+	case "viewers":
+		showViewers(chatID)
+	case "mergecategories":
+		handleMergeCategoriesCommand(chatID, userID, args)
+	}
+	return true
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatUptime(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		return fmt.Sprintf("%dd %s", days, d)
+	}
+	return d.String()
+}
+
+func showStats(chatID int64) {
+	var total, income, expense, thisMonth, categoryCount int
+	err := db.QueryRow(`SELECT COUNT(*),
+			COALESCE(SUM(CASE WHEN type = 'income' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN type = 'expense' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN strftime('%Y-%m', created_at) = ? THEN 1 ELSE 0 END), 0)
+		FROM transactions`, appClock.Now().Format("2006-01")).Scan(&total, &income, &expense, &thisMonth)
+	if err != nil {
+		log.Printf("Failed to count transactions: %v", err)
+		sendMessage(chatID, "Failed to collect stats.")
+		return
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM categories").Scan(&categoryCount); err != nil {
+		log.Printf("Failed to count categories: %v", err)
+	}
+
+	dbSize := fileSize(DB_PATH) + fileSize(DB_PATH+"-wal")
+	maintenance := "off"
+	if isMaintenanceMode() {
+		maintenance = "ON (read-only)"
+	}
+
+	msg := fmt.Sprintf("Bot stats\n\nUptime: %s\nDatabase size: %s\nTransactions: %d (income %d, expense %d)\nThis month: %d\nCategories: %d\nActive sessions: %d\nMaintenance mode: %s",
+		formatUptime(time.Since(startTime)), formatBytes(dbSize), total, income, expense, thisMonth, categoryCount, len(userStates), maintenance)
+	sendMessage(chatID, msg)
+}
+
+func showUsers(chatID int64) {
+	rows, err := db.Query("SELECT user_id, name, last_seen, message_count, blocked_at IS NOT NULL, user_id IN (SELECT user_id FROM viewers) FROM user_activity ORDER BY last_seen DESC")
+	if err != nil {
+		log.Printf("Failed to query user activity: %v", err)
+		sendMessage(chatID, "Failed to list users.")
+		return
+	}
+	defer rows.Close()
+
+	var sb strings.Builder
+	sb.WriteString("Users\n\n")
+	count := 0
+	for rows.Next() {
+		var (
+			id       int64
+			name     string
+			lastSeen time.Time
+			messages int
+			blocked  bool
+			viewer   bool
+		)
+		if err := rows.Scan(&id, &name, &lastSeen, &messages, &blocked, &viewer); err != nil {
+			log.Printf("Row scan error: %v", err)
+			continue
+		}
+		role := "not allowed"
+		if id == ownerID() {
+			role = "owner"
+		} else if isAllowedUser(id) {
+			role = "allowed"
+		} else if viewer {
+			role = "viewer"
+		}
+		if blocked {
+			role += ", blocked the bot"
+		}
+		sb.WriteString(fmt.Sprintf("%s (%d) - %s\nLast seen: %s UTC, %d messages\n\n", name, id, role, lastSeen.UTC().Format("2006-01-02 15:04"), messages))
+		count++
+	}
+	if count == 0 {
+		sendMessage(chatID, "No user activity recorded yet.")
+		return
+	}
+	sendMessage(chatID, sb.String())
+}
+
+// broadcastTargets returns the chats of all allowed users plus every group
+// with group mode enabled.
+func broadcastTargets() ([]int64, error) {
+	cfg := currentConfig()
+	users := []int64{ownerID()}
+	if cfg != nil {
+		users = cfg.AllowedUsers
+	}
+	var targets []int64
+	for _, id := range users {
+		// users who blocked the bot cannot receive it
+		var blocked int
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_activity WHERE user_id = ? AND blocked_at IS NOT NULL", id).Scan(&blocked); err != nil {
+			return nil, err
+		}
+		if blocked == 0 {
+			targets = append(targets, id)
+		}
+	}
+	rows, err := db.Query("SELECT chat_id FROM split_groups")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		targets = append(targets, id)
+	}
+	return targets, rows.Err()
+}
+
+func broadcast(chatID int64, text string) {
+	if text == "" {
+		sendMessage(chatID, "Usage: /broadcast <message>")
+		return
+	}
+	targets, err := broadcastTargets()
+	if err != nil {
+		log.Printf("Failed to load broadcast targets: %v", err)
+		sendMessage(chatID, "Failed to load broadcast recipients.")
+		return
+	}
+	queued := 0
+	for _, target := range targets {
+		if err := enqueueNotification(target, "broadcast", "", "📢 "+text, nil); err != nil {
+			log.Printf("Failed to queue broadcast to %d: %v", target, err)
+			continue
+		}
+		queued++
+	}
+	sendMessage(chatID, fmt.Sprintf("Broadcast queued for %d of %d chats.", queued, len(targets)))
+}
+
+func toggleMaintenance(chatID int64, arg string) {
+	var on bool
+	switch strings.ToLower(arg) {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	case "":
+		on = !isMaintenanceMode()
+	default:
+		sendMessage(chatID, "Usage: /maintenance [on|off]")
+		return
+	}
+	if err := setSetting("maintenance_mode", fmt.Sprintf("%t", on)); err != nil {
+		log.Printf("Failed to save maintenance mode: %v", err)
+		sendMessage(chatID, "Failed to change maintenance mode.")
+		return
+	}
+	if on {
+		sendMessage(chatID, "Maintenance mode ON: the bot is read-only until you run /maintenance off.")
+	} else {
+		sendMessage(chatID, "Maintenance mode OFF: changes are allowed again.")
+	}
+}
+
+// sendBackup sends a fresh database backup to the owner. It is encrypted
+// when SQLCipher and/or BACKUP_KEY are configured.
+func sendBackup(chatID int64) {
+	backupKey := os.Getenv("BACKUP_KEY")
+	name := fmt.Sprintf("ayunda-backup-%s.db", time.Now().Format("20060102-150405"))
+	if backupKey != "" {
+		name += encryptedBackupSuffix
+	}
+	dir, err := os.MkdirTemp(dataSubdir("backups"), "ayunda-backup-")
+	if err != nil {
+		log.Printf("Failed to create backup dir: %v", err)
+		sendMessage(chatID, "Failed to create backup.")
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, name)
+	if err := backupDB(path, backupKey); err != nil {
+		log.Printf("Backup failed: %v", err)
+		sendMessage(chatID, "Failed to create backup. See server logs.")
+		return
+	}
+
+	caption := "Database backup"
+	switch {
+	case backupKey != "":
+		caption += " (AES-256-GCM encrypted, restore with --decrypt-backup)"
+	case dbKey != "":
+		caption += " (SQLCipher encrypted with the database key)"
+	default:
+		caption += " (unencrypted - set BACKUP_KEY to encrypt backups)"
+	}
+	if err := messenger.SendFile(chatID, path, caption); err != nil {
+		log.Printf("Failed to send backup: %v", err)
+		sendMessage(chatID, "Failed to send backup file.")
+	}
+}
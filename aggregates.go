@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+/*
+	MONTHLY AGGREGATES
+
+	monthly_aggregates holds the total and count of transactions per ledger,
+	month, type and category, the total summing line totals (lineTotal). Triggers on the transactions table keep it up to date
+	on every insert, update and delete, whichever code path made the change,
+	so monthly reports read a handful of rows instead of scanning the whole
+	table. verifyAggregates compares it with a full scan and rebuildAggregates
+	recomputes it from scratch.
+*/
+
+// aggregatesVersion changes with the layout of the table or its
+// triggers; an instance with another version rebuilds both.
+const aggregatesVersion = "4"
+
+const monthlyAggregatesTable = `CREATE TABLE IF NOT EXISTS monthly_aggregates (
+	ledger_id INTEGER NOT NULL DEFAULT 1,
+	month TEXT NOT NULL,
+	type TEXT NOT NULL,
+	category TEXT NOT NULL,
+	total INTEGER NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (ledger_id, month, type, category)
+)`
+
+var aggregateTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS transactions_aggregate_insert AFTER INSERT ON transactions BEGIN
+		INSERT INTO monthly_aggregates (ledger_id, month, type, category, total, count)
+		VALUES (NEW.ledger_id, strftime('%Y-%m', NEW.created_at), NEW.type, NEW.category, CAST(ROUND(NEW.quantity * NEW.amount) AS INTEGER), 1)
+		ON CONFLICT(ledger_id, month, type, category) DO UPDATE SET total = total + excluded.total, count = count + 1;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_aggregate_delete AFTER DELETE ON transactions BEGIN
+		UPDATE monthly_aggregates SET total = total - CAST(ROUND(OLD.quantity * OLD.amount) AS INTEGER), count = count - 1
+		WHERE ledger_id = OLD.ledger_id AND month = strftime('%Y-%m', OLD.created_at) AND type = OLD.type AND category = OLD.category;
+		DELETE FROM monthly_aggregates WHERE count <= 0;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_aggregate_update AFTER UPDATE OF ledger_id, type, category, quantity, amount, created_at ON transactions BEGIN
+		UPDATE monthly_aggregates SET total = total - CAST(ROUND(OLD.quantity * OLD.amount) AS INTEGER), count = count - 1
+		WHERE ledger_id = OLD.ledger_id AND month = strftime('%Y-%m', OLD.created_at) AND type = OLD.type AND category = OLD.category;
+		DELETE FROM monthly_aggregates WHERE count <= 0;
+		INSERT INTO monthly_aggregates (ledger_id, month, type, category, total, count)
+		VALUES (NEW.ledger_id, strftime('%Y-%m', NEW.created_at), NEW.type, NEW.category, CAST(ROUND(NEW.quantity * NEW.amount) AS INTEGER), 1)
+		ON CONFLICT(ledger_id, month, type, category) DO UPDATE SET total = total + excluded.total, count = count + 1;
+	END`,
+}
+
+// initAggregates creates the triggers and fills the table the first time,
+// for databases that already had transactions.
+func initAggregates() error {
+	current := getSetting("aggregates_version", "") == aggregatesVersion
+	if !current {
+		for _, q := range []string{
+			"DROP TRIGGER IF EXISTS transactions_aggregate_insert",
+			"DROP TRIGGER IF EXISTS transactions_aggregate_delete",
+			"DROP TRIGGER IF EXISTS transactions_aggregate_update",
+			"DROP TABLE IF EXISTS monthly_aggregates",
+			monthlyAggregatesTable,
+		} {
+			if _, err := db.Exec(q); err != nil {
+				return err
+			}
+		}
+	}
+	for _, q := range aggregateTriggers {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	if current {
+		return nil
+	}
+	if err := rebuildAggregates(); err != nil {
+		return err
+	}
+	return setSetting("aggregates_version", aggregatesVersion)
+}
+
+// rebuildAggregates recomputes monthly_aggregates from the transactions.
+func rebuildAggregates() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM monthly_aggregates"); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO monthly_aggregates (ledger_id, month, type, category, total, count)
+		SELECT ledger_id, strftime('%Y-%m', created_at), type, category, SUM(` + lineTotal + `), COUNT(*) FROM transactions GROUP BY 1, 2, 3, 4`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type aggregateKey struct {
+	Ledger                int64
+	Month, Type, Category string
+}
+
+type aggregateValue struct {
+	Total Money
+	Count int
+}
+
+func scanAggregates(query string) (map[aggregateKey]aggregateValue, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[aggregateKey]aggregateValue)
+	for rows.Next() {
+		var k aggregateKey
+		var v aggregateValue
+		if err := rows.Scan(&k.Ledger, &k.Month, &k.Type, &k.Category, &v.Total, &v.Count); err != nil {
+			return nil, err
+		}
+		result[k] = v
+	}
+	return result, rows.Err()
+}
+
+// verifyAggregates compares the materialized aggregates with a full scan
+// and returns one line per difference.
+func verifyAggregates() ([]string, error) {
+	stored, err := scanAggregates("SELECT ledger_id, month, type, category, total, count FROM monthly_aggregates")
+	if err != nil {
+		return nil, err
+	}
+	actual, err := scanAggregates("SELECT ledger_id, strftime('%Y-%m', created_at), type, category, SUM(" + lineTotal + "), COUNT(*) FROM transactions GROUP BY 1, 2, 3, 4")
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for k, want := range actual {
+		got, ok := stored[k]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s %s %s: missing (want %.2f in %d)", k.Month, k.Type, k.Category, want.Total, want.Count))
+		case got.Count != want.Count || got.Total != want.Total:
+			problems = append(problems, fmt.Sprintf("%s %s %s: %.2f in %d, want %.2f in %d", k.Month, k.Type, k.Category, got.Total, got.Count, want.Total, want.Count))
+		}
+	}
+	for k, got := range stored {
+		if _, ok := actual[k]; !ok {
+			problems = append(problems, fmt.Sprintf("%s %s %s: stale (%.2f in %d)", k.Month, k.Type, k.Category, got.Total, got.Count))
+		}
+	}
+	return problems, nil
+}
+
+// monthTotals returns the income and expense totals of t's month.
+func monthTotals(t time.Time) (Money, Money, error) {
+	var income, expense Money
+	err := db.QueryRow(`SELECT COALESCE(SUM(CASE WHEN type = 'income' THEN total END), 0),
+		COALESCE(SUM(CASE WHEN type = 'expense' THEN total END), 0)
+		FROM monthly_aggregates WHERE month = ? AND `+ledgerScope(), t.Format("2006-01")).Scan(&income, &expense)
+	return income, expense, err
+}
+
+func cmdAggregates(args []string) error {
+	fs := newCommandFlags("aggregates", "[-rebuild]")
+	rebuild := fs.Bool("rebuild", false, "Recompute the monthly aggregates from the transactions")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *rebuild {
+		if err := rebuildAggregates(); err != nil {
+			return err
+		}
+		fmt.Println("Monthly aggregates rebuilt.")
+	}
+	problems, err := verifyAggregates()
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		log.Printf("%d aggregate(s) out of date; run with -rebuild to fix", len(problems))
+		return fmt.Errorf("monthly aggregates are inconsistent")
+	}
+	fmt.Println("Monthly aggregates match the transactions.")
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestTotalsCountLineTotals(t *testing.T) {
+	newHarness(t)
+	now := appClock.Now()
+	// 3 × 7,500 of snacks counts as 22,500
+	id, _, err := insertTransaction("expense", "Food", 3, 7500*moneyScale, "snacks", now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monthStart, monthEnd := monthBounds(now)
+
+	check := func(want Money) {
+		t.Helper()
+		if total, err := store.Total("expense", "Food", monthStart, monthEnd); err != nil || total != want {
+			t.Errorf("Total is %s (%v), want %s", formatMoney(total), err, formatMoney(want))
+		}
+		if _, expense, err := monthTotals(now); err != nil || expense != want {
+			t.Errorf("monthTotals expense is %s (%v), want %s", formatMoney(expense), err, formatMoney(want))
+		}
+		if spent, err := categorySpending(now); err != nil || spent["Food"] != want {
+			t.Errorf("categorySpending is %s (%v), want %s", formatMoney(spent["Food"]), err, formatMoney(want))
+		}
+		if problems, err := verifyAggregates(); err != nil || len(problems) > 0 {
+			t.Errorf("aggregates differ from a full scan: %v %v", problems, err)
+		}
+	}
+	check(22500 * moneyScale)
+
+	// changing the quantity alone moves the monthly aggregates too
+	if _, err := store.SetTransactionField(id, "quantity", 2.0); err != nil {
+		t.Fatal(err)
+	}
+	check(15000 * moneyScale)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+	AI CATEGORIZATION (optional)
+
+	With an OpenAI-compatible chat completions endpoint configured
+	([ai] url, key and model in the config file, or AI_API_URL, AI_API_KEY
+	and AI_MODEL), the bot asks it for help where the rules (rules.go)
+	cannot:
+
+	- a quick add that no rule matches gets a suggested category with its
+	  confidence, shown first on the category buttons;
+	- a free-text message that is not a quick add, e.g. "paid 25k for
+	  lunch at the warteg", is read into a transaction.
+	- a question /ask cannot read with its templates (ask.go) is turned
+	  into one of them.
+
+	Nothing is saved without the user's tap. Without an endpoint both fall
+	back to the manual flow, and so does any error of the provider.
+*/
+
+// AIProvider classifies and parses transaction descriptions.
+type AIProvider interface {
+	// Classify picks one of categories for a description, with a
+	// confidence between 0 and 1.
+	Classify(typ string, description string, categories []string) (string, float64, error)
+	// ParseEntry reads a free-text message into a transaction. A message
+	// that records none gives an entry with a zero amount.
+	ParseEntry(text string, categories []string) (aiEntry, error)
+	// ParseQuestion reads a question about the ledger (/ask) asked at now.
+	ParseQuestion(question string, categories []string, now time.Time) (askQuery, error)
+}
+
+type aiEntry struct {
+	Type        string  `json:"type"`
+	Amount      Money   `json:"amount"`
+	Category    string  `json:"category"`
+	Description string  `json:"description"`
+	Confidence  float64 `json:"confidence"`
+}
+
+// aiProvider returns the provider configured for this instance, or nil.
+func aiProvider() AIProvider {
+	cfg := currentConfig()
+	if cfg == nil || cfg.AIURL == "" {
+		return nil
+	}
+	return &openAIProvider{
+		endpoint: strings.TrimRight(cfg.AIURL, "/") + "/chat/completions",
+		key:      cfg.AIKey,
+		model:    cfg.AIModel,
+		client:   &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+// openAIProvider talks to a chat completions endpoint with plain net/http.
+type openAIProvider struct {
+	endpoint string
+	key      string
+	model    string
+	client   *http.Client
+}
+
+const aiClassifyPrompt = `You sort personal finance transactions into categories. Answer with JSON only, like {"category": "Food", "confidence": 0.9}. The category must be one of the given categories; confidence is between 0 and 1.`
+
+const aiParsePrompt = `You read a chat message that records a personal finance transaction. Answer with JSON only, like {"type": "expense", "amount": 25000, "category": "Food", "description": "lunch at the warteg", "confidence": 0.8}. type is "income" or "expense"; amount is a positive number, so "25k" is 25000 and "1.5jt" is 1500000; category must be one of the given categories; description is short and leaves out the amount; confidence is between 0 and 1. If the message records no transaction, answer {"amount": 0}.`
+
+const aiQuestionPrompt = `You translate questions about a personal finance ledger into JSON only, like {"intent": "sum", "type": "expense", "category": "Food", "merchant": "", "from": "2026-09-01", "to": "2026-09-30"}. intent is "sum", "count", "average" or "largest"; type is "expense" or "income"; category is one of the given categories or ""; merchant is a word of the transaction descriptions or ""; from and to are the first and last day of the period asked about, inclusive, this month when none is given. If the question is not about the ledger, answer {"intent": ""}.`
+
+func (p *openAIProvider) ParseQuestion(question string, categories []string, now time.Time) (askQuery, error) {
+	var answer struct {
+		Intent   string `json:"intent"`
+		Type     string `json:"type"`
+		Category string `json:"category"`
+		Merchant string `json:"merchant"`
+		From     string `json:"from"`
+		To       string `json:"to"`
+	}
+	user := fmt.Sprintf("Today: %s\nCategories: %s\nQuestion: %s", now.Format(dateLayout), strings.Join(categories, ", "), question)
+	if err := p.complete(aiQuestionPrompt, user, &answer); err != nil {
+		return askQuery{}, err
+	}
+	a := askQuery{Intent: answer.Intent, Type: answer.Type, Merchant: truncateRunes(strings.TrimSpace(answer.Merchant), 100)}
+	switch a.Intent {
+	case "sum", "count", "average", "largest":
+	default:
+		return askQuery{}, fmt.Errorf("AI provider answered an unknown intent %q", answer.Intent)
+	}
+	if a.Type != "income" {
+		a.Type = "expense"
+	}
+	if answer.Category != "" {
+		category, ok := findCategory(answer.Category)
+		if !ok {
+			return askQuery{}, fmt.Errorf("AI provider answered an unknown category %q", answer.Category)
+		}
+		a.Category = category
+	}
+	from, err1 := time.ParseInLocation(dateLayout, answer.From, appLocation())
+	to, err2 := time.ParseInLocation(dateLayout, answer.To, appLocation())
+	if err1 != nil || err2 != nil || to.Before(from) {
+		return askQuery{}, fmt.Errorf("AI provider answered an invalid period %q to %q", answer.From, answer.To)
+	}
+	a.From, a.To = from, to.AddDate(0, 0, 1)
+	a.Label = from.Format("2 Jan 2006") + " – " + to.Format("2 Jan 2006")
+	return a, nil
+}
+
+func (p *openAIProvider) Classify(typ string, description string, categories []string) (string, float64, error) {
+	var answer struct {
+		Category   string  `json:"category"`
+		Confidence float64 `json:"confidence"`
+	}
+	user := fmt.Sprintf("Categories: %s\nType: %s\nDescription: %s", strings.Join(categories, ", "), typ, description)
+	if err := p.complete(aiClassifyPrompt, user, &answer); err != nil {
+		return "", 0, err
+	}
+	category, ok := findCategory(answer.Category)
+	if !ok {
+		return "", 0, fmt.Errorf("AI provider answered an unknown category %q", answer.Category)
+	}
+	return category, clampConfidence(answer.Confidence), nil
+}
+
+func (p *openAIProvider) ParseEntry(text string, categories []string) (aiEntry, error) {
+	var entry aiEntry
+	user := fmt.Sprintf("Categories: %s\nMessage: %s", strings.Join(categories, ", "), text)
+	if err := p.complete(aiParsePrompt, user, &entry); err != nil {
+		return aiEntry{}, err
+	}
+	if entry.Amount <= 0 {
+		return aiEntry{}, nil
+	}
+	category, ok := findCategory(entry.Category)
+	if !ok {
+		return aiEntry{}, fmt.Errorf("AI provider answered an unknown category %q", entry.Category)
+	}
+	entry.Category = category
+	entry.Type = strings.ToLower(entry.Type)
+	if entry.Type != "income" {
+		entry.Type = "expense"
+	}
+	entry.Description = strings.TrimSpace(entry.Description)
+	if entry.Description == "" || len(entry.Description) > 100 {
+		entry.Description = truncateRunes(strings.TrimSpace(text), 100)
+	}
+	entry.Confidence = clampConfidence(entry.Confidence)
+	return entry, nil
+}
+
+// complete sends one system and one user message and decodes the JSON
+// answer into v.
+func (p *openAIProvider) complete(system string, user string, v interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":       p.model,
+		"temperature": 0,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": user},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.key != "" {
+		req.Header.Set("Authorization", "Bearer "+p.key)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("AI provider returned %s", resp.Status)
+	}
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(raw, &completion); err != nil {
+		return fmt.Errorf("decode AI provider response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return fmt.Errorf("AI provider returned no choices")
+	}
+	content := strings.TrimSpace(completion.Choices[0].Message.Content)
+	// some models wrap JSON in a Markdown code block
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.Trim(content, "`\n ")
+	if err := json.Unmarshal([]byte(content), v); err != nil {
+		return fmt.Errorf("decode AI provider answer %q: %w", truncateRunes(content, 200), err)
+	}
+	return nil
+}
+
+func clampConfidence(c float64) float64 {
+	if math.IsNaN(c) {
+		return 0
+	}
+	return math.Max(0, math.Min(1, c))
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+// handleAIEntry reads a free-text message with the AI provider and asks
+// the user to confirm the transaction it found. It returns false when no
+// provider is configured or the message records no transaction.
+func handleAIEntry(message *TGMessage) bool {
+	provider := aiProvider()
+	text := strings.TrimSpace(message.Text)
+	if provider == nil || text == "" || strings.HasPrefix(text, "/") {
+		return false
+	}
+	chatID := message.Chat.ID
+	if isMaintenanceMode() {
+		return false
+	}
+	entry, err := provider.ParseEntry(text, activeCategories())
+	if err != nil {
+		reportError("reading a message with the AI provider", err)
+		return false
+	}
+	if entry.Amount == 0 {
+		return false
+	}
+
+	userStates[message.From.ID] = &TransactionState{
+		UserID:          message.From.ID,
+		Step:            "AI_CONFIRM",
+		TransactionType: entry.Type,
+		Category:        entry.Category,
+		Amount:          entry.Amount,
+		Description:     entry.Description,
+		Quantity:        1,
+		AIConfidence:    entry.Confidence,
+	}
+	keyboard := buildKeyboard([][]InlineKeyboardButton{{
+		{Text: "✅ Save", CallbackData: "ai:save"},
+		{Text: "Cancel", CallbackData: "ai:cancel"},
+	}})
+	sendMessageWithKeyboard(chatID, fmt.Sprintf("🤖 I read this as:\n%s of %s in %s: %s\nConfidence: %.0f%%\n\nSave it? To change something, cancel and use /add.",
+		entry.Type, formatMoney(entry.Amount), entry.Category, entry.Description, entry.Confidence*100), keyboard)
+	return true
+}
+
+// processAIConfirm saves or drops the transaction read by handleAIEntry.
+func processAIConfirm(callback *CallbackQuery, state *TransactionState) {
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	if callback.Data != "ai:save" {
+		delete(userStates, state.UserID)
+		editMessage(chatID, messageID, "Canceled; nothing was saved.")
+		return
+	}
+	editMessage(chatID, messageID, fmt.Sprintf("%s of %s in %s: %s", state.TransactionType, formatMoney(state.Amount), state.Category, state.Description))
+	saveTransaction(chatID, state, 0, fmt.Sprintf("Read by the AI provider (confidence %.0f%%).", state.AIConfidence*100))
+}
+
+// suggestCategory asks the AI provider for the category of a quick add.
+// It returns false without a provider or on any error.
+func suggestCategory(typ string, description string) (string, float64, bool) {
+	provider := aiProvider()
+	if provider == nil {
+		return "", 0, false
+	}
+	category, confidence, err := provider.Classify(typ, description, activeCategories())
+	if err != nil {
+		reportError("classifying a description with the AI provider", err)
+		return "", 0, false
+	}
+	return category, confidence, true
+}
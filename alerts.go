@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+	ERROR ALERTS
+
+	reportError logs an unexpected error (a failed query, a report script
+	that crashed; not bad user input) and queues a short alert for the
+	owner. The same error from the same place is sent at most once per
+	errorAlertWindow, with a count of the repeats in the next alert, and no
+	more than errorAlertLimit alerts go out per window in total. The
+	error_alerts feature flag turns the alerts off.
+*/
+
+const (
+	errorAlertWindow = time.Hour
+	errorAlertLimit  = 10
+)
+
+var errorAlerts = struct {
+	sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+	windowFrom time.Time
+	sent       int
+}{last: make(map[string]time.Time), suppressed: make(map[string]int)}
+
+// reportError logs err, which happened while doing what, and alerts the
+// owner unless the same alert went out recently. The alert is queued in the
+// database, so a caller iterating over rows must close them first (db.go).
+func reportError(what string, err error) {
+	reportErrorTagged(what, err, updateTags())
+}
+
+// reportErrorTagged is reportError outside of an update, with the error
+// tracking tags given by the caller.
+func reportErrorTagged(what string, err error, tags map[string]string) {
+	log.Printf("Error %s: %v", what, err)
+	tags["where"] = what
+	errorReporter.CaptureError(err, tags)
+	if !featureEnabled("error_alerts") || ownerID() == 0 {
+		return
+	}
+
+	key := what + ": " + err.Error()
+	now := time.Now()
+	errorAlerts.Lock()
+	if now.Sub(errorAlerts.windowFrom) >= errorAlertWindow {
+		errorAlerts.windowFrom, errorAlerts.sent = now, 0
+	}
+	if last, ok := errorAlerts.last[key]; (ok && now.Sub(last) < errorAlertWindow) || errorAlerts.sent >= errorAlertLimit {
+		errorAlerts.suppressed[key]++
+		errorAlerts.Unlock()
+		return
+	}
+	repeats := errorAlerts.suppressed[key]
+	delete(errorAlerts.suppressed, key)
+	errorAlerts.last[key] = now
+	errorAlerts.sent++
+	errorAlerts.Unlock()
+
+	text := fmt.Sprintf("⚠️ Error %s: %v", what, err)
+	if repeats > 0 {
+		text += fmt.Sprintf("\n(%d more time(s) since the last alert)", repeats)
+	}
+	dedupeKey := fmt.Sprintf("error:%s:%d", key, now.UnixNano())
+	if err := enqueueNotification(ownerID(), "error_alert", dedupeKey, text, nil); err != nil {
+		// the database itself may be the problem
+		log.Printf("Failed to queue error alert: %v", err)
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	ZERO-BASED PLAN (/allocate)
+
+	Every month the expected income is given a job: it is split across the
+	expense categories until nothing is left to allocate.
+
+	/allocate start              walk through the categories, one
+	                             question per category
+	/allocate                    this month's plan: allocated against
+	                             spent, per category
+	/allocate income 8000000     the expected income
+	/allocate Food 2500000       allocate to a category (off removes it)
+	/allocate clear              drop this month's plan
+
+	The walk-through asks for the income first, offering last month's,
+	then for each category spent on in the last allocationLookback months
+	or with a budget, the largest first, offering its current allocation,
+	its budget or its average. It ends by itself once the income is fully
+	allocated. The plan flags income left without a job, an allocation
+	above the income, spending without an allocation and categories over
+	their allocation; the scheduler sends an alert when a category goes
+	over its allocation or more income came in than expected, once a month
+	each.
+
+	Plans are kept per month in allocation_plans and allocations, next to
+	the budgets, which they leave alone.
+*/
+
+const (
+	allocationLookback = 3
+	allocateUsage      = "Usage:\n/allocate start - plan this month category by category\n/allocate - show the plan\n/allocate income <amount> - the expected income\n/allocate <category> <amount|off> - allocate to a category\n/allocate clear - drop this month's plan"
+)
+
+// allocationState is the walk-through in progress.
+type allocationState struct {
+	Month     string   // YYYY-MM
+	Queue     []string // categories still to ask about, the current one first
+	Asked     int      // categories answered or skipped
+	Suggested Money    // offered on the button of the current question
+}
+
+// allocationPlan is the plan of a month.
+type allocationPlan struct {
+	Income      Money
+	Allocations map[string]Money
+}
+
+func (p allocationPlan) allocated() Money {
+	var total Money
+	for _, amount := range p.Allocations {
+		total += amount
+	}
+	return total
+}
+
+// loadAllocationPlan returns the plan of month (YYYY-MM) and whether it
+// exists.
+func loadAllocationPlan(month string) (allocationPlan, bool, error) {
+	plan := allocationPlan{Allocations: make(map[string]Money)}
+	err := db.QueryRow("SELECT income FROM allocation_plans WHERE month = ?", month).Scan(&plan.Income)
+	if err == sql.ErrNoRows {
+		return plan, false, nil
+	}
+	if err != nil {
+		return plan, false, err
+	}
+	rows, err := db.Query("SELECT category, amount FROM allocations WHERE month = ?", month)
+	if err != nil {
+		return plan, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		var amount Money
+		if err := rows.Scan(&category, &amount); err != nil {
+			return plan, false, err
+		}
+		plan.Allocations[category] = amount
+	}
+	return plan, true, rows.Err()
+}
+
+func setAllocationIncome(month string, income Money) error {
+	_, err := db.Exec(`INSERT INTO allocation_plans (month, income) VALUES (?, ?)
+		ON CONFLICT(month) DO UPDATE SET income = excluded.income, updated_at = CURRENT_TIMESTAMP`, month, income)
+	return err
+}
+
+// setAllocation allocates amount to category in month, or removes the
+// allocation when amount is 0.
+func setAllocation(month string, category string, amount Money) error {
+	if amount == 0 {
+		_, err := db.Exec("DELETE FROM allocations WHERE month = ? AND category = ?", month, category)
+		return err
+	}
+	_, err := db.Exec(`INSERT INTO allocations (month, category, amount) VALUES (?, ?, ?)
+		ON CONFLICT(month, category) DO UPDATE SET amount = excluded.amount`, month, category, amount)
+	return err
+}
+
+func clearAllocationPlan(month string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM allocations WHERE month = ?", month); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM allocation_plans WHERE month = ?", month); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// averageCategorySpending returns the average monthly expenses of each
+// category over the allocationLookback months before now's month, rounded
+// up to a whole unit of the currency.
+func averageCategorySpending(now time.Time) (map[string]Money, error) {
+	thisMonth, _ := monthBounds(now)
+	rows, err := db.Query("SELECT category, SUM(total) FROM monthly_aggregates WHERE type = 'expense' AND month >= ? AND month < ? AND "+ledgerScope()+" GROUP BY category",
+		thisMonth.AddDate(0, -allocationLookback, 0).Format("2006-01"), thisMonth.Format("2006-01"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	averages := make(map[string]Money)
+	for rows.Next() {
+		var category string
+		var total Money
+		if err := rows.Scan(&category, &total); err != nil {
+			return nil, err
+		}
+		average := total.Div(allocationLookback)
+		averages[category] = (average + moneyScale - 1) / moneyScale * moneyScale
+	}
+	return averages, rows.Err()
+}
+
+func handleAllocateCommand(chatID int64, userID int64, args string) {
+	now := appClock.Now()
+	month := now.Format("2006-01")
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		showAllocationPlan(chatID, now)
+	case len(fields) == 1 && strings.EqualFold(fields[0], "start"):
+		startAllocation(chatID, userID, now)
+	case len(fields) == 1 && strings.EqualFold(fields[0], "clear"):
+		if err := clearAllocationPlan(month); err != nil {
+			sendMessage(chatID, "Failed to clear the plan.")
+			reportError("clearing the allocation plan", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("The plan of %s is cleared.", now.Format("January 2006")))
+	case len(fields) == 2 && strings.EqualFold(fields[0], "income"):
+		amount, err := parseMoney(fields[1])
+		if err != nil || amount <= 0 {
+			sendMessage(chatID, "Invalid amount. Usage: /allocate income <amount>")
+			return
+		}
+		if err := setAllocationIncome(month, amount); err != nil {
+			sendMessage(chatID, "Failed to save the plan.")
+			reportError("setting the expected income", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Expected income for %s set to %s.\n%s", now.Format("January"), formatMoney(amount), allocationBalanceLine(month)))
+	case len(fields) >= 2:
+		category, ok := findCategory(strings.Join(fields[:len(fields)-1], " "))
+		if !ok {
+			sendMessage(chatID, fmt.Sprintf("Unknown category. Available: %s", strings.Join(activeCategories(), ", ")))
+			return
+		}
+		value := fields[len(fields)-1]
+		var amount Money
+		if !strings.EqualFold(value, "off") {
+			var err error
+			if amount, err = parseMoney(value); err != nil || amount <= 0 {
+				sendMessage(chatID, "Invalid amount. Usage: /allocate <category> <amount|off>")
+				return
+			}
+		}
+		_, exists, err := loadAllocationPlan(month)
+		if err != nil {
+			sendMessage(chatID, "Failed to load the plan.")
+			reportError("loading the allocation plan", err)
+			return
+		}
+		if !exists {
+			sendMessage(chatID, "Set the expected income first: /allocate income <amount>, or walk through the plan with /allocate start.")
+			return
+		}
+		if err := setAllocation(month, category, amount); err != nil {
+			sendMessage(chatID, "Failed to save the plan.")
+			reportError("allocating to "+category, err)
+			return
+		}
+		text := fmt.Sprintf("Allocated %s to %s.", formatMoney(amount), category)
+		if amount == 0 {
+			text = fmt.Sprintf("Allocation of %s removed.", category)
+		}
+		sendMessage(chatID, text+"\n"+allocationBalanceLine(month))
+	default:
+		sendMessage(chatID, allocateUsage)
+	}
+}
+
+// allocationBalanceLine tells how much of the income of month is left to
+// allocate, or "" without a plan.
+func allocationBalanceLine(month string) string {
+	plan, exists, err := loadAllocationPlan(month)
+	if err != nil || !exists {
+		return ""
+	}
+	return allocationBalance(plan)
+}
+
+// allocationBalance flags income left to allocate or an allocation above
+// the income.
+func allocationBalance(plan allocationPlan) string {
+	switch left := plan.Income - plan.allocated(); {
+	case left == 0:
+		return "✅ Every bit of the income has a job."
+	case left > 0:
+		return fmt.Sprintf("🟡 %s left to allocate.", formatMoney(left))
+	default:
+		return fmt.Sprintf("🔴 Over-allocated by %s: lower some categories or raise the income.", formatMoney(-left))
+	}
+}
+
+func showAllocationPlan(chatID int64, now time.Time) {
+	text, err := allocationPlanText(now)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the plan.")
+		reportError("building the allocation plan", err)
+		return
+	}
+	if text == "" {
+		sendMessageWithKeyboard(chatID, fmt.Sprintf("No plan for %s yet. Give every bit of your income a job:", now.Format("January")), buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "🧮 Plan this month", CallbackData: "alloc:start"},
+		}}))
+		return
+	}
+	sendHTML(chatID, text)
+}
+
+// allocationPlanText writes this month's plan against the spending so far
+// in HTML, or "" without a plan.
+func allocationPlanText(now time.Time) (string, error) {
+	plan, exists, err := loadAllocationPlan(now.Format("2006-01"))
+	if err != nil || !exists {
+		return "", err
+	}
+	spent, err := categorySpending(now)
+	if err != nil {
+		return "", err
+	}
+	monthStart, monthEnd := monthBounds(now)
+	received, err := totalBetween("income", monthStart, monthEnd)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🧮 " + bold("Zero-based plan, "+now.Format("January 2006")) + "\n\n")
+	sb.WriteString(fmt.Sprintf("Expected income: %s (received %s)\n", moneyHTML(plan.Income), moneyHTML(received)))
+	sb.WriteString(fmt.Sprintf("Allocated: %s\n", moneyHTML(plan.allocated())))
+	sb.WriteString(escapeHTML(allocationBalance(plan)) + "\n")
+	if received > plan.Income {
+		sb.WriteString(escapeHTML(fmt.Sprintf("💰 %s more income than expected: raise it with /allocate income and give it a job.", formatMoney(received-plan.Income))) + "\n")
+	}
+
+	categories := make([]string, 0, len(plan.Allocations))
+	for category := range plan.Allocations {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if a, b := plan.Allocations[categories[i]], plan.Allocations[categories[j]]; a != b {
+			return a > b
+		}
+		return categories[i] < categories[j]
+	})
+	if len(categories) > 0 {
+		sb.WriteString("\n" + bold("Allocated / spent:") + "\n")
+	}
+	for _, category := range categories {
+		allocated := plan.Allocations[category]
+		line := fmt.Sprintf("• %s: %s / %s", escapeHTML(category), moneyHTML(allocated), moneyHTML(spent[category]))
+		if left := allocated - spent[category]; left >= 0 {
+			line += fmt.Sprintf(" (%s left)", formatMoney(left))
+		} else {
+			line += fmt.Sprintf(" (%s over)", formatMoney(-left))
+		}
+		sb.WriteString(line + "\n" + escapeHTML(budgetProgress(float64(spent[category])/float64(allocated))) + "\n")
+	}
+
+	var unplanned []string
+	for category, amount := range spent {
+		if _, ok := plan.Allocations[category]; !ok && amount > 0 {
+			unplanned = append(unplanned, category)
+		}
+	}
+	if len(unplanned) > 0 {
+		sort.Slice(unplanned, func(i, j int) bool { return spent[unplanned[i]] > spent[unplanned[j]] })
+		sb.WriteString("\n" + bold("Spent without an allocation:") + "\n")
+		for _, category := range unplanned {
+			sb.WriteString(fmt.Sprintf("• %s: %s\n", escapeHTML(category), moneyHTML(spent[category])))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// allocationCategories returns the categories the walk-through asks
+// about: those spent on lately or with a budget, by average spending.
+func allocationCategories(averages map[string]Money, budgets map[string]Money) []string {
+	var categories []string
+	for _, category := range activeCategories() {
+		if averages[category] > 0 || budgets[category] > 0 {
+			categories = append(categories, category)
+		}
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		return max(averages[categories[i]], budgets[categories[i]]) > max(averages[categories[j]], budgets[categories[j]])
+	})
+	return categories
+}
+
+// startAllocation begins the walk-through with the expected income.
+func startAllocation(chatID int64, userID int64, now time.Time) {
+	month := now.Format("2006-01")
+	plan, exists, err := loadAllocationPlan(month)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the plan.")
+		reportError("loading the allocation plan", err)
+		return
+	}
+	suggested := plan.Income
+	hint := ""
+	if !exists {
+		monthStart, _ := monthBounds(now)
+		if suggested, err = totalBetween("income", monthStart.AddDate(0, -1, 0), monthStart); err != nil {
+			reportError("summing last month's income", err)
+		}
+		if suggested > 0 {
+			hint = fmt.Sprintf("\nLast month you received %s.", formatMoney(suggested))
+		}
+	} else {
+		hint = fmt.Sprintf("\nThe plan expects %s now.", formatMoney(suggested))
+	}
+	state := &TransactionState{UserID: userID, Step: "ALLOCATE_INCOME", Allocation: &allocationState{Month: month, Suggested: suggested}}
+	userStates[userID] = state
+	sendMessageWithKeyboard(chatID, fmt.Sprintf("🧮 Plan for %s\n\nHow much income do you expect this month? Send the amount.%s", now.Format("January 2006"), hint), allocationKeyboard(state))
+}
+
+// allocationKeyboard offers the suggested amount, and skipping or
+// finishing early on the category questions.
+func allocationKeyboard(state *TransactionState) InlineKeyboardMarkup {
+	var rows [][]InlineKeyboardButton
+	if state.Allocation.Suggested > 0 {
+		rows = append(rows, []InlineKeyboardButton{{Text: "Use " + formatMoney(state.Allocation.Suggested), CallbackData: "alloc:use"}})
+	}
+	if state.Step == "ALLOCATE_CATEGORY" {
+		rows = append(rows, []InlineKeyboardButton{
+			{Text: "Skip ▶", CallbackData: "alloc:skip"},
+			{Text: "✅ Finish", CallbackData: "alloc:finish"},
+		})
+	}
+	rows = append(rows, []InlineKeyboardButton{{Text: "Cancel", CallbackData: "alloc:cancel"}})
+	return buildKeyboard(rows)
+}
+
+// askNextAllocation asks about the next category in the queue, or ends
+// the walk-through when none is left or the income is fully allocated.
+func askNextAllocation(chatID int64, messageID int, state *TransactionState) {
+	a := state.Allocation
+	plan, _, err := loadAllocationPlan(a.Month)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the plan.")
+		reportError("loading the allocation plan", err)
+		return
+	}
+	if len(a.Queue) == 0 || (a.Asked > 0 && plan.allocated() == plan.Income) {
+		finishAllocation(chatID, messageID, state)
+		return
+	}
+	category := a.Queue[0]
+	averages, err := averageCategorySpending(appClock.Now())
+	if err != nil {
+		reportError("averaging spending per category", err)
+	}
+	budget, err := store.CategoryBudget(category)
+	if err != nil {
+		reportError("loading the budget of "+category, err)
+	}
+
+	lines := []string{fmt.Sprintf("🧮 %s (%d/%d)", category, a.Asked+1, a.Asked+len(a.Queue)), allocationBalance(plan)}
+	a.Suggested = averages[category]
+	if allocated, ok := plan.Allocations[category]; ok {
+		a.Suggested = allocated
+		lines = append(lines, fmt.Sprintf("Allocated now: %s", formatMoney(allocated)))
+	} else if budget > 0 {
+		a.Suggested = budget
+	}
+	if budget > 0 {
+		lines = append(lines, fmt.Sprintf("Budget: %s", formatMoney(budget)))
+	}
+	if averages[category] > 0 {
+		lines = append(lines, fmt.Sprintf("Spent on average: %s a month", formatMoney(averages[category])))
+	}
+	text := strings.Join(lines, "\n") + fmt.Sprintf("\n\nHow much for %s? Send the amount, 0 for nothing.", category)
+	state.Step = "ALLOCATE_CATEGORY"
+	if messageID == 0 {
+		sendMessageWithKeyboard(chatID, text, allocationKeyboard(state))
+		return
+	}
+	editMessageWithKeyboard(chatID, messageID, text, allocationKeyboard(state))
+}
+
+// finishAllocation ends the walk-through with the plan.
+func finishAllocation(chatID int64, messageID int, state *TransactionState) {
+	delete(userStates, state.UserID)
+	if messageID != 0 {
+		editMessage(chatID, messageID, "🧮 Plan saved.")
+	}
+	showAllocationPlan(chatID, appClock.Now())
+}
+
+// answerAllocation records the answer to the current question and moves
+// on.
+func answerAllocation(chatID int64, messageID int, state *TransactionState, amount Money) {
+	a := state.Allocation
+	if state.Step == "ALLOCATE_INCOME" {
+		if err := setAllocationIncome(a.Month, amount); err != nil {
+			sendMessage(chatID, "Failed to save the plan.")
+			reportError("setting the expected income", err)
+			return
+		}
+		averages, err := averageCategorySpending(appClock.Now())
+		if err != nil {
+			reportError("averaging spending per category", err)
+		}
+		budgets := make(map[string]Money)
+		if loaded, err := loadCategoryBudgets(); err == nil {
+			for _, b := range loaded {
+				budgets[b.Category] = b.Amount
+			}
+		}
+		a.Queue = allocationCategories(averages, budgets)
+		if len(a.Queue) == 0 {
+			a.Queue = activeCategories()
+		}
+		askNextAllocation(chatID, messageID, state)
+		return
+	}
+	if err := setAllocation(a.Month, a.Queue[0], amount); err != nil {
+		sendMessage(chatID, "Failed to save the plan.")
+		reportError("allocating to "+a.Queue[0], err)
+		return
+	}
+	a.Queue, a.Asked = a.Queue[1:], a.Asked+1
+	askNextAllocation(chatID, messageID, state)
+}
+
+// processAllocationText takes a typed amount.
+func processAllocationText(message *TGMessage, state *TransactionState) {
+	amount, err := parseMoney(strings.TrimSpace(message.Text))
+	if err != nil || amount < 0 || (amount == 0 && state.Step == "ALLOCATE_INCOME") {
+		sendMessage(message.Chat.ID, "Invalid amount. Send a number such as 2500000, or use the buttons.")
+		return
+	}
+	answerAllocation(message.Chat.ID, 0, state, amount)
+}
+
+// handleAllocationCallback handles the buttons of the walk-through:
+// alloc:<action>.
+func handleAllocationCallback(callback *CallbackQuery) {
+	action := strings.TrimPrefix(callback.Data, "alloc:")
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	if isMaintenanceMode() && action != "cancel" {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	if action == "start" {
+		_ = messenger.AnswerCallback(callback.ID, "")
+		startAllocation(chatID, callback.From.ID, appClock.Now())
+		return
+	}
+	state, exists := userStates[callback.From.ID]
+	if !exists || state.Allocation == nil {
+		_ = messenger.AnswerCallback(callback.ID, "This plan has expired. Start again with /allocate start.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	switch action {
+	case "cancel":
+		delete(userStates, state.UserID)
+		editMessage(chatID, messageID, "Planning canceled. What was already answered is kept; see it with /allocate.")
+	case "use":
+		answerAllocation(chatID, messageID, state, state.Allocation.Suggested)
+	case "skip":
+		if state.Step == "ALLOCATE_CATEGORY" {
+			state.Allocation.Queue, state.Allocation.Asked = state.Allocation.Queue[1:], state.Allocation.Asked+1
+			askNextAllocation(chatID, messageID, state)
+		}
+	case "finish":
+		finishAllocation(chatID, messageID, state)
+	}
+}
+
+// checkAllocationAlerts queues an alert, once a month each, for every
+// category spent past its allocation and for income received above the
+// plan.
+func checkAllocationAlerts(now time.Time) error {
+	month := now.Format("2006-01")
+	plan, exists, err := loadAllocationPlan(month)
+	if err != nil || !exists {
+		return err
+	}
+	spent, err := categorySpending(now)
+	if err != nil {
+		return err
+	}
+	for category, allocated := range plan.Allocations {
+		if spent[category] <= allocated {
+			continue
+		}
+		text := fmt.Sprintf("🔴 %s is over its allocation: %s spent of %s. Move money from another category with /allocate.", category, formatMoney(spent[category]), formatMoney(allocated))
+		if err := enqueueNotification(ownerID(), "budget_alert", fmt.Sprintf("allocation:%s:%s", category, month), text, nil); err != nil {
+			return err
+		}
+	}
+	monthStart, monthEnd := monthBounds(now)
+	received, err := totalBetween("income", monthStart, monthEnd)
+	if err != nil {
+		return err
+	}
+	if received > plan.Income {
+		text := fmt.Sprintf("💰 You received %s this month, %s more than planned. Give it a job: /allocate income %s, then /allocate.", formatMoney(received), formatMoney(received-plan.Income), formatMoneyPlain(received))
+		if err := enqueueNotification(ownerID(), "budget_alert", "allocation:income:"+month, text, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+	MILEAGE AND PER DIEM (/mileage, /perdiem)
+
+	Quick calculators for business expenses paid at a fixed rate:
+
+	/mileage rate 2500          set the rate per km
+	/mileage 42 client visit    records 42 × 2500 under Transportation
+	/perdiem rate 350000        set the daily allowance
+	/perdiem 3 Surabaya trip    records 3 × 350000 under Travel
+
+	The expense is saved like one added with /add, with a description
+	showing how it was computed.
+*/
+
+type allowance struct {
+	Command  string
+	Setting  string
+	Unit     string // "km" or "day"
+	Units    string // as counted in descriptions, e.g. "day(s)"
+	Arg      string // as named in the usage, e.g. "days"
+	Label    string
+	Category string // used when it exists, otherwise Fallback
+	Fallback string
+	Max      float64 // most units in one entry
+}
+
+var (
+	mileageAllowance = allowance{Command: "mileage", Setting: "mileage_rate", Unit: "km", Units: "km", Arg: "km", Label: "Mileage", Category: "Transportation", Fallback: "Needs", Max: 10000}
+	perDiemAllowance = allowance{Command: "perdiem", Setting: "perdiem_rate", Unit: "day", Units: "day(s)", Arg: "days", Label: "Per diem", Category: "Travel", Fallback: "Food", Max: 366}
+)
+
+func (a allowance) usage() string {
+	return fmt.Sprintf("Usage:\n/%s <%s> [note] - record the expense\n/%s rate <amount> - set the rate per %s\n/%s - show the rate",
+		a.Command, a.Arg, a.Command, a.Unit, a.Command)
+}
+
+func (a allowance) category() string {
+	if category, ok := findCategory(a.Category); ok {
+		return category
+	}
+	return a.Fallback
+}
+
+func handleAllowanceCommand(message *TGMessage, args string, a allowance) {
+	chatID := message.Chat.ID
+	fields := strings.Fields(args)
+	rate := getFloatSetting(a.Setting, 0)
+	switch {
+	case len(fields) == 0:
+		if rate <= 0 {
+			sendMessage(chatID, fmt.Sprintf("No %s rate set yet.\n\n%s", strings.ToLower(a.Label), a.usage()))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("%s rate: %s per %s.\n\n%s", a.Label, formatMoney(moneyFromFloat(rate)), a.Unit, a.usage()))
+	case strings.EqualFold(fields[0], "rate"):
+		if len(fields) != 2 {
+			sendMessage(chatID, a.usage())
+			return
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || value <= 0 || math.IsInf(value, 0) {
+			sendMessage(chatID, "The rate must be a positive number.")
+			return
+		}
+		if err := setSetting(a.Setting, strconv.FormatFloat(value, 'f', -1, 64)); err != nil {
+			sendMessage(chatID, "Failed to save the rate.")
+			reportError("setting the "+strings.ToLower(a.Label)+" rate", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("%s rate set to %s per %s.", a.Label, formatMoney(moneyFromFloat(value)), a.Unit))
+	default:
+		units, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || units <= 0 || units > a.Max {
+			sendMessage(chatID, fmt.Sprintf("Invalid number of %s.\n\n%s", a.Arg, a.usage()))
+			return
+		}
+		if rate <= 0 {
+			sendMessage(chatID, fmt.Sprintf("Set the rate first with /%s rate <amount>.", a.Command))
+			return
+		}
+		description := fmt.Sprintf("%s: %s %s × %s", a.Label, strconv.FormatFloat(units, 'f', -1, 64), a.Units, strconv.FormatFloat(rate, 'f', -1, 64))
+		if note := strings.Join(fields[1:], " "); note != "" {
+			description += " · " + note
+		}
+		state := &TransactionState{
+			UserID:          message.From.ID,
+			TransactionType: "expense",
+			Category:        a.category(),
+			Amount:          moneyFromFloat(units * rate),
+			Description:     truncateRunes(description, 100),
+			Quantity:        1,
+		}
+		saveTransaction(chatID, state, 0, fmt.Sprintf("%s recorded under %s: %s", formatMoney(state.Amount), state.Category, state.Description))
+	}
+}
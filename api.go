@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	HTTP API
+
+	With [api] listen (API_LISTEN) set, e.g. to "127.0.0.1:8080", the bot
+	also serves a small JSON API for scripts, authenticated with the
+	personal access tokens of /apitoken (apitokens.go):
+
+		curl -H "Authorization: Bearer ayk_..." http://127.0.0.1:8080/api/transactions?limit=5
+
+	GET    /api/transactions         newest first; type, category, since
+	                                 (YYYY-MM-DD) and limit (default 50)
+	GET    /api/transactions/{id}
+	POST   /api/transactions         {"type": "expense", "category": "Food",
+	                                 "amount": 25000, "description": "lunch"},
+	                                 quantity and date optional
+	DELETE /api/transactions/{id}
+	POST   /api/ingest               a form or JSON from a phone shortcut,
+	                                 see ingest.go
+	GET    /api/categories
+	GET    /api/summary?month=YYYY-MM  income and expenses of a month
+	GET    /api/calendar.ics         the calendar feed, see calendar.go
+
+	Every token can read; POST and DELETE need a write token, are refused
+	in maintenance mode and, like in chat, in a closed period unless the
+	token belongs to the owner. The API works on the active ledger and
+	serves plain HTTP: put it behind a reverse proxy with TLS to reach it
+	from outside the host.
+*/
+
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 1000
+	apiMaxBody      = 64 << 10
+)
+
+func apiConfigured() bool {
+	cfg := currentConfig()
+	return cfg != nil && cfg.APIListen != ""
+}
+
+// apiTransaction is a transaction as the API reads and writes it.
+type apiTransaction struct {
+	ID          int64   `json:"id"`
+	Type        string  `json:"type"`
+	Category    string  `json:"category"`
+	Quantity    float64 `json:"quantity"`
+	Amount      Money   `json:"amount"`
+	Description string  `json:"description"`
+	Date        string  `json:"date"` // "2006-01-02 15:04:05", local time
+}
+
+func toAPITransaction(t Transaction) apiTransaction {
+	return apiTransaction{
+		ID:          t.ID,
+		Type:        t.Type,
+		Category:    t.Category,
+		Quantity:    t.Quantity,
+		Amount:      t.Amount,
+		Description: t.Description,
+		Date:        t.CreatedAt.Format(dbTimeLayout),
+	}
+}
+
+type apiTokenKey struct{}
+
+// apiHandler routes the API requests; every route needs a token.
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/transactions", apiListTransactions)
+	mux.HandleFunc("GET /api/transactions/{id}", apiGetTransaction)
+	mux.HandleFunc("POST /api/transactions", requireWriteToken(apiAddTransaction))
+	mux.HandleFunc("DELETE /api/transactions/{id}", requireWriteToken(apiDeleteTransaction))
+	mux.HandleFunc("POST /api/ingest", requireWriteToken(apiIngest))
+	mux.HandleFunc("GET /api/categories", apiListCategories)
+	mux.HandleFunc("GET /api/summary", apiSummary)
+	mux.HandleFunc("GET "+calendarFeedPath, apiCalendar)
+	return requireToken(mux)
+}
+
+// requireToken refuses requests without a valid token and passes the
+// token on in the request context.
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok && r.Method == http.MethodPost && isFormRequest(r) {
+			// for apps that can only send form fields (ingest.go)
+			r.Body = http.MaxBytesReader(w, r.Body, apiMaxBody)
+			secret = r.PostFormValue("token")
+			ok = secret != ""
+		}
+		if !ok && r.Method == http.MethodGet && r.URL.Path == calendarFeedPath {
+			// calendar apps only know the URL (calendar.go)
+			secret = r.URL.Query().Get("token")
+			ok = secret != ""
+		}
+		if !ok {
+			writeAPIError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		token, err := lookupAPIToken(strings.TrimSpace(secret))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				reportError("checking an API token", err)
+				writeAPIError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			writeAPIError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		if !isAllowedUser(token.UserID) {
+			writeAPIError(w, http.StatusUnauthorized, "the owner of this token is no longer allowed")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, token)))
+	})
+}
+
+// requireWriteToken refuses read-only tokens and writes during maintenance.
+func requireWriteToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requestToken(r).canWrite() {
+			writeAPIError(w, http.StatusForbidden, "this token is read-only")
+			return
+		}
+		if isMaintenanceMode() {
+			writeAPIError(w, http.StatusServiceUnavailable, "the bot is in read-only maintenance mode")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func requestToken(r *http.Request) apiToken {
+	token, _ := r.Context().Value(apiTokenKey{}).(apiToken)
+	return token
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write an API response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// apiInternalError reports err and answers with a generic error.
+func apiInternalError(w http.ResponseWriter, context string, err error) {
+	reportError(context, err)
+	writeAPIError(w, http.StatusInternalServerError, "internal error")
+}
+
+func apiListTransactions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := TransactionFilter{Type: strings.ToLower(q.Get("type")), Category: q.Get("category"), Limit: apiDefaultLimit}
+	if filter.Type != "" && filter.Type != "income" && filter.Type != "expense" {
+		writeAPIError(w, http.StatusBadRequest, "type must be income or expense")
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxLimit {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", apiMaxLimit))
+			return
+		}
+		filter.Limit = n
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := parseDateFlag(v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.Since = since
+	}
+	transactions, err := store.Transactions(filter)
+	if err != nil {
+		apiInternalError(w, "listing transactions for the API", err)
+		return
+	}
+	list := make([]apiTransaction, len(transactions))
+	for i, t := range transactions {
+		list[i] = toAPITransaction(t)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"transactions": list})
+}
+
+// apiTransactionByID loads the transaction of the {id} path value, or
+// answers with an error and returns false.
+func apiTransactionByID(w http.ResponseWriter, r *http.Request) (Transaction, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid transaction id")
+		return Transaction{}, false
+	}
+	t, err := store.Transaction(id)
+	if err == sql.ErrNoRows {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no transaction %d", id))
+		return t, false
+	}
+	if err != nil {
+		apiInternalError(w, "loading a transaction for the API", err)
+		return t, false
+	}
+	return t, true
+}
+
+func apiGetTransaction(w http.ResponseWriter, r *http.Request) {
+	if t, ok := apiTransactionByID(w, r); ok {
+		writeJSON(w, http.StatusOK, toAPITransaction(t))
+	}
+}
+
+// apiNewTransaction is the body of POST /api/transactions.
+type apiNewTransaction struct {
+	Type        string  `json:"type"`
+	Category    string  `json:"category"`
+	Quantity    float64 `json:"quantity"`
+	Amount      Money   `json:"amount"`
+	Description string  `json:"description"`
+	Date        string  `json:"date"` // YYYY-MM-DD or "YYYY-MM-DD HH:MM:SS", now when empty
+}
+
+// validate checks the fields the way /add and "ayunda add" do and
+// returns the transaction to store.
+func (n apiNewTransaction) validate(now time.Time) (Transaction, error) {
+	t := Transaction{Type: strings.ToLower(n.Type), Quantity: n.Quantity, Amount: n.Amount, Description: n.Description, CreatedAt: now}
+	if t.Type == "" {
+		t.Type = "expense"
+	}
+	if t.Type != "income" && t.Type != "expense" {
+		return t, fmt.Errorf("invalid type %q (must be income or expense)", n.Type)
+	}
+	category, ok := findCategory(n.Category)
+	if !ok {
+		return t, fmt.Errorf("unknown category %q", n.Category)
+	}
+	t.Category = category
+	if t.Amount <= 0 {
+		return t, errors.New("amount must be a positive number")
+	}
+	if t.Quantity == 0 {
+		t.Quantity = 1
+	}
+	if t.Quantity < 0 {
+		return t, errors.New("quantity must be a positive number")
+	}
+	if len(t.Description) > 100 {
+		return t, errors.New("description too long, keep it under 100 characters")
+	}
+	if n.Date != "" {
+		date, err := parseDateFlag(n.Date)
+		if err != nil {
+			return t, err
+		}
+		t.CreatedAt = date
+	}
+	return t, nil
+}
+
+func apiAddTransaction(w http.ResponseWriter, r *http.Request) {
+	var body apiNewTransaction
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	t, err := body.validate(appClock.Now())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, ok := apiSaveTransaction(w, r, t)
+	if !ok {
+		return
+	}
+	t.ID = id
+	writeJSON(w, http.StatusCreated, toAPITransaction(t))
+}
+
+// apiSaveTransaction stores a validated transaction and returns its id,
+// or answers with an error and returns false.
+func apiSaveTransaction(w http.ResponseWriter, r *http.Request, t Transaction) (int64, bool) {
+	if periodClosed(t.CreatedAt.Format(dbTimeLayout)) && requestToken(r).UserID != ownerID() {
+		writeAPIError(w, http.StatusForbidden, fmt.Sprintf("the books are closed through %s", closedThrough(closedBefore())))
+		return 0, false
+	}
+	id, _, err := insertTransaction(t.Type, t.Category, t.Quantity, t.Amount, t.Description, t.CreatedAt, false)
+	if err != nil {
+		apiInternalError(w, "saving a transaction from the API", err)
+		return 0, false
+	}
+	log.Printf("api token=%d added transaction %d", requestToken(r).ID, id)
+	transactionsChanged()
+	return id, true
+}
+
+func apiDeleteTransaction(w http.ResponseWriter, r *http.Request) {
+	t, ok := apiTransactionByID(w, r)
+	if !ok {
+		return
+	}
+	if periodClosed(t.CreatedAt.Format(dbTimeLayout)) && requestToken(r).UserID != ownerID() {
+		writeAPIError(w, http.StatusForbidden, fmt.Sprintf("transaction %d is in a closed period (through %s)", t.ID, closedThrough(closedBefore())))
+		return
+	}
+	if _, err := store.DeleteTransaction(t.ID); err != nil {
+		apiInternalError(w, "deleting a transaction from the API", err)
+		return
+	}
+	log.Printf("api token=%d deleted transaction %d", requestToken(r).ID, t.ID)
+	transactionsChanged()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListCategories(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"categories": activeCategories()})
+}
+
+func apiSummary(w http.ResponseWriter, r *http.Request) {
+	month := appClock.Now()
+	if v := r.URL.Query().Get("month"); v != "" {
+		parsed, err := time.ParseInLocation("2006-01", v, appLocation())
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "month must be YYYY-MM")
+			return
+		}
+		month = parsed
+	}
+	income, expense, err := monthTotals(month)
+	if err != nil {
+		apiInternalError(w, "summing a month for the API", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"month":   month.Format("2006-01"),
+		"income":  income,
+		"expense": expense,
+		"net":     income - expense,
+	})
+}
+
+// runAPIServer serves the API until the process exits, when configured.
+func runAPIServer() {
+	if !apiConfigured() {
+		return
+	}
+	server := &http.Server{
+		Addr:              currentConfig().APIListen,
+		Handler:           apiHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	log.Printf("Serving the HTTP API on %s", currentConfig().APIListen)
+	if err := server.ListenAndServe(); err != nil {
+		reportErrorTagged("serving the HTTP API", err, map[string]string{"job": "api"})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIExpenseQueuesBudgetAlert(t *testing.T) {
+	newHarness(t)
+	if err := setCategoryBudget("Food", 100000*moneyScale); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := createAPIToken(harnessUserID, "script", "write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/transactions", strings.NewReader(`{"category":"Food","amount":"120000","description":"groceries"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	apiHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var text string
+	if err := db.QueryRow("SELECT text FROM outbox WHERE kind = 'budget_alert' AND dedupe_key LIKE 'budget:Food:%'").Scan(&text); err != nil {
+		t.Fatalf("no budget alert queued: %v", err)
+	}
+	if !strings.Contains(text, "Food is over budget at 120%") {
+		t.Errorf("alert is %q, want Food over budget at 120%%", text)
+	}
+}
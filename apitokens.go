@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	API TOKENS (/apitoken)
+
+	Scripts talk to the HTTP API (api.go) with a personal access token
+	instead of the Telegram bot token:
+
+	/apitoken                       list your tokens
+	/apitoken new backup-script     create a read-only token
+	/apitoken new shortcuts write   create a token that can also add and
+	                                delete transactions
+	/apitoken revoke 3
+
+	A token is shown once, when it is created, and only its SHA-256 hash is
+	stored; a lost token is revoked and replaced. Tokens belong to the user
+	who created them and stop working when that user is no longer allowed.
+	They are created in a private chat only, so they never end up in a
+	group's history.
+*/
+
+const (
+	apiTokenPrefix = "ayk_"
+	apiTokenUsage  = "Usage:\n/apitoken - list your tokens\n/apitoken new <name> [read|write] - create a token, read-only by default\n/apitoken revoke <id> - revoke a token"
+)
+
+// apiToken is a stored token, without its secret.
+type apiToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Scope      string // "read" or "write"
+	Hint       string // the last characters of the token, to tell them apart
+	CreatedAt  string
+	LastUsedAt sql.NullString
+}
+
+// canWrite tells whether the token may change the ledger.
+func (t apiToken) canWrite() bool {
+	return t.Scope == "write"
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIToken stores a new token for userID and returns its secret.
+func createAPIToken(userID int64, name string, scope string) (string, int64, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", 0, err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	res, err := db.Exec("INSERT INTO api_tokens (user_id, name, scope, token_hash, hint, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, name, scope, hashAPIToken(token), token[len(token)-4:], appClock.Now().Format(dbTimeLayout))
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := res.LastInsertId()
+	return token, id, err
+}
+
+// lookupAPIToken finds the token a request presented and records its
+// use, or returns sql.ErrNoRows.
+func lookupAPIToken(token string) (apiToken, error) {
+	var t apiToken
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return t, sql.ErrNoRows
+	}
+	err := db.QueryRow("SELECT id, user_id, name, scope, hint, created_at, last_used_at FROM api_tokens WHERE token_hash = ?", hashAPIToken(token)).
+		Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Hint, &t.CreatedAt, &t.LastUsedAt)
+	if err != nil {
+		return t, err
+	}
+	if _, err := db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", appClock.Now().Format(dbTimeLayout), t.ID); err != nil {
+		reportError("recording the use of an API token", err)
+	}
+	return t, nil
+}
+
+func loadAPITokens(userID int64) ([]apiToken, error) {
+	rows, err := db.Query("SELECT id, user_id, name, scope, hint, created_at, last_used_at FROM api_tokens WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []apiToken
+	for rows.Next() {
+		var t apiToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Hint, &t.CreatedAt, &t.LastUsedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func handleAPITokenCommand(chatID int64, userID int64, inGroup bool, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		showAPITokens(chatID, userID)
+		return
+	}
+	switch strings.ToLower(fields[0]) {
+	case "new", "create":
+		if inGroup {
+			sendMessage(chatID, "Create API tokens in a private chat with the bot, so the token does not stay in the group's history.")
+			return
+		}
+		scope := "read"
+		if n := len(fields); n > 2 && (strings.EqualFold(fields[n-1], "read") || strings.EqualFold(fields[n-1], "write")) {
+			scope = strings.ToLower(fields[n-1])
+			fields = fields[:n-1]
+		}
+		name := strings.Join(fields[1:], " ")
+		if name == "" || len(name) > 50 {
+			sendMessage(chatID, "Give the token a name of up to 50 characters.\n\n"+apiTokenUsage)
+			return
+		}
+		token, id, err := createAPIToken(userID, name, scope)
+		if err != nil {
+			sendMessage(chatID, "Failed to create the token.")
+			reportError("creating an API token", err)
+			return
+		}
+		access := "read-only"
+		if scope == "write" {
+			access = "read and write"
+		}
+		sendHTML(chatID, fmt.Sprintf("🔑 Token %d %s (%s):\n\n%s\n\nCopy it now, it will not be shown again. Scripts send it in the %s header.",
+			id, bold(name), access, mono(token), mono("Authorization: Bearer <token>")))
+	case "revoke", "delete":
+		if len(fields) != 2 {
+			sendMessage(chatID, apiTokenUsage)
+			return
+		}
+		id, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			sendMessage(chatID, apiTokenUsage)
+			return
+		}
+		res, err := db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+		if err != nil {
+			sendMessage(chatID, "Failed to revoke the token.")
+			reportError("revoking an API token", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("You have no token %d. See them with /apitoken.", id))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Token %d revoked.", id))
+	default:
+		sendMessage(chatID, apiTokenUsage)
+	}
+}
+
+func showAPITokens(chatID int64, userID int64) {
+	tokens, err := loadAPITokens(userID)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the tokens.")
+		reportError("loading API tokens", err)
+		return
+	}
+	if len(tokens) == 0 {
+		sendMessage(chatID, "You have no API tokens.\n\n"+apiTokenUsage)
+		return
+	}
+	lines := []string{bold("🔑 API tokens:")}
+	for _, t := range tokens {
+		used := "never used"
+		if t.LastUsedAt.Valid {
+			used = "last used " + formatCreatedAt(t.LastUsedAt.String)
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s (%s) …%s, created %s, %s", t.ID, escapeHTML(t.Name), t.Scope, escapeHTML(t.Hint), formatCreatedAt(t.CreatedAt), used))
+	}
+	if !apiConfigured() {
+		lines = append(lines, "", "The HTTP API is off: set [api] listen to use them.")
+	}
+	sendHTML(chatID, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	ARCHIVE (/archive)
+
+	Transactions older than N years can be moved to transactions_archive,
+	keeping the hot table (and every report that scans it) small. Archived
+	rows keep their id and are left out of reports, except /summary with
+	the "archive" option. Archiving by hand asks for a confirmation code
+	(confirm.go); with an auto policy set, the archive job moves old
+	transactions every night.
+*/
+
+const maxArchiveYears = 50
+
+// archiveCutoff returns the first instant that stays in the hot table when
+// archiving transactions older than years.
+func archiveCutoff(now time.Time, years int) time.Time {
+	start, _ := dayBounds(now)
+	return start.AddDate(-years, 0, 0)
+}
+
+// archiveBefore moves the transactions created before cutoff to the
+// archive table and returns how many were moved.
+func archiveBefore(cutoff time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before := cutoff.Format(dbTimeLayout)
+	if _, err := tx.Exec(`INSERT INTO transactions_archive (id, type, category, quantity, amount, description, created_at, is_outlier, notes, latitude, longitude, ledger_id, deductible, tax_amount, reimbursable_by, reimbursement_id, payment_method, original_amount, original_currency, fx_rate)
+		SELECT id, type, category, quantity, amount, description, created_at, is_outlier, notes, latitude, longitude, ledger_id, deductible, tax_amount, reimbursable_by, reimbursement_id, payment_method, original_amount, original_currency, fx_rate FROM transactions WHERE created_at < ?`, before); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM transactions WHERE created_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	moved, _ := res.RowsAffected()
+	// archiving is not deleting: drop the audit entries the move created
+	if _, err := tx.Exec("DELETE FROM transaction_audit WHERE action = 'delete' AND transaction_id IN (SELECT id FROM transactions_archive)"); err != nil {
+		return 0, err
+	}
+	// replies can only amend live transactions
+	if _, err := tx.Exec("DELETE FROM transaction_messages WHERE transaction_id NOT IN (SELECT id FROM transactions)"); err != nil {
+		return 0, err
+	}
+	return moved, tx.Commit()
+}
+
+// archiveOldTransactions is the scheduled job for the auto policy.
+func archiveOldTransactions(now time.Time) error {
+	years := int(getFloatSetting("archive_after_years", 0))
+	if years <= 0 {
+		return nil
+	}
+	moved, err := archiveBefore(archiveCutoff(now, years))
+	if err != nil {
+		return err
+	}
+	if moved > 0 {
+		log.Printf("Archived %d transaction(s) older than %d year(s)", moved, years)
+	}
+	return nil
+}
+
+func parseArchiveYears(s string) (int, error) {
+	years, err := strconv.Atoi(s)
+	if err != nil || years < 1 || years > maxArchiveYears {
+		return 0, fmt.Errorf("invalid number of years %q (1-%d)", s, maxArchiveYears)
+	}
+	return years, nil
+}
+
+const archiveUsage = "Usage:\n/archive - show archive status\n/archive <years> - archive transactions older than <years> years\n/archive auto <years> - archive them automatically every night\n/archive auto off - stop archiving automatically"
+
+// handleArchiveCommand implements /archive.
+func handleArchiveCommand(chatID int64, userID int64, args string) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		showArchiveStatus(chatID)
+	case len(fields) == 1:
+		years, err := parseArchiveYears(fields[0])
+		if err != nil {
+			sendMessage(chatID, archiveUsage)
+			return
+		}
+		cutoff := archiveCutoff(appClock.Now(), years)
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE created_at < ?", cutoff.Format(dbTimeLayout)).Scan(&count); err != nil {
+			sendMessage(chatID, "Failed to count the transactions to archive.")
+			reportError("counting transactions to archive", err)
+			return
+		}
+		if count == 0 {
+			sendMessage(chatID, fmt.Sprintf("No transactions from before %s to archive.", cutoff.Format("2 Jan 2006")))
+			return
+		}
+		action := fmt.Sprintf("move %d transaction(s) from before %s to the archive", count, cutoff.Format("2 Jan 2006"))
+		requireConfirmation(chatID, userID, action, func() {
+			moved, err := archiveBefore(cutoff)
+			if err != nil {
+				sendMessage(chatID, "Failed to archive transactions.")
+				reportError("archiving transactions", err)
+				return
+			}
+			sendMessage(chatID, fmt.Sprintf("🗄️ Archived %d transaction(s) from before %s.", moved, cutoff.Format("2 Jan 2006")))
+		})
+	case len(fields) == 2 && strings.EqualFold(fields[0], "auto"):
+		value := "0"
+		reply := "Automatic archiving turned off."
+		if !strings.EqualFold(fields[1], "off") {
+			years, err := parseArchiveYears(fields[1])
+			if err != nil {
+				sendMessage(chatID, archiveUsage)
+				return
+			}
+			value = strconv.Itoa(years)
+			reply = fmt.Sprintf("Transactions older than %d year(s) will be archived every night.", years)
+		}
+		if err := setSetting("archive_after_years", value); err != nil {
+			sendMessage(chatID, "Failed to save the setting.")
+			reportError("setting the archive policy", err)
+			return
+		}
+		sendMessage(chatID, reply)
+	default:
+		sendMessage(chatID, archiveUsage)
+	}
+}
+
+func showArchiveStatus(chatID int64) {
+	var count int
+	var oldest, newest string
+	err := db.QueryRow("SELECT COUNT(*), COALESCE(MIN(created_at), ''), COALESCE(MAX(created_at), '') FROM transactions_archive").Scan(&count, &oldest, &newest)
+	if err != nil {
+		sendMessage(chatID, "Failed to read the archive.")
+		reportError("reading the archive", err)
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🗄️ Archive\n\n")
+	if count == 0 {
+		sb.WriteString("No archived transactions.\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("%d transaction(s) from %s to %s.\n", count, oldest[:10], newest[:10]))
+	}
+	if years := int(getFloatSetting("archive_after_years", 0)); years > 0 {
+		sb.WriteString(fmt.Sprintf("Automatic: transactions older than %d year(s).\n", years))
+	} else {
+		sb.WriteString("Automatic archiving is off.\n")
+	}
+	sb.WriteString("\n" + archiveUsage)
+	sendMessage(chatID, sb.String())
+}
+
+// archivedMonthTotals returns the income and expense totals of t's month
+// in the archive.
+func archivedMonthTotals(t time.Time) (Money, Money, error) {
+	start, end := monthBounds(t)
+	var income, expense Money
+	err := db.QueryRow(`SELECT COALESCE(SUM(CASE WHEN type = 'income' THEN `+lineTotal+` END), 0),
+		COALESCE(SUM(CASE WHEN type = 'expense' THEN `+lineTotal+` END), 0)
+		FROM transactions_archive WHERE created_at >= ? AND created_at < ? AND `+ledgerScope(),
+		start.Format(dbTimeLayout), end.Format(dbTimeLayout)).Scan(&income, &expense)
+	return income, expense, err
+}
+
+func archivedCountBetween(from time.Time, to time.Time) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM transactions_archive WHERE created_at >= ? AND created_at < ? AND "+ledgerScope(),
+		from.Format(dbTimeLayout), to.Format(dbTimeLayout)).Scan(&n)
+	return n, err
+}
+
+var summaryMonthPattern = regexp.MustCompile(`^\d{4}-\d{2}$`)
+
+// parseSummaryArgs parses "/summary [YYYY-MM] [archive]".
+func parseSummaryArgs(args string, now time.Time) (time.Time, bool, error) {
+	month := now
+	withArchive := false
+	for _, f := range strings.Fields(args) {
+		switch {
+		case strings.EqualFold(f, "archive"), strings.EqualFold(f, "all"):
+			withArchive = true
+		case summaryMonthPattern.MatchString(f):
+			t, err := time.ParseInLocation("2006-01", f, appLocation())
+			if err != nil {
+				return time.Time{}, false, fmt.Errorf("invalid month %q", f)
+			}
+			month = t
+		default:
+			return time.Time{}, false, fmt.Errorf("invalid argument %q", f)
+		}
+	}
+	return month, withArchive, nil
+}
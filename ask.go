@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	QUESTIONS ABOUT THE LEDGER (/ask <question>)
+
+	/ask how much did I spend on food last month?
+	/ask biggest expense in March?
+	/ask how many times did I pay for grab this week?
+	/ask average expense this year
+
+	A question is turned into an askQuery: what to compute (a total, a
+	count, an average or the largest transaction), of which type, for which
+	category or merchant (words of the description), over which period.
+	Each kind of askQuery runs one fixed SQL template with parameters, so
+	no text of the question ever becomes SQL. Questions the templates
+	cannot read go to the AI provider (ai.go), when one is configured, which
+	answers with an askQuery too. The answer shows the period it used.
+*/
+
+const askUsage = "Usage: /ask <question>, e.g.\n/ask how much did I spend on food last month?\n/ask biggest expense in March?\n/ask how many times did I pay for grab this week?"
+
+type askQuery struct {
+	Intent   string // "sum", "count", "average" or "largest"
+	Type     string // "expense" or "income"
+	Category string
+	Merchant string // matched against descriptions
+	From, To time.Time
+	Label    string
+}
+
+var askMonths = map[string]time.Month{
+	"january": time.January, "february": time.February, "march": time.March, "april": time.April,
+	"may": time.May, "june": time.June, "july": time.July, "august": time.August,
+	"september": time.September, "october": time.October, "november": time.November, "december": time.December,
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April, "jun": time.June,
+	"jul": time.July, "aug": time.August, "sep": time.September, "sept": time.September, "oct": time.October,
+	"nov": time.November, "dec": time.December,
+}
+
+var (
+	askMonthPattern    = regexp.MustCompile(`\b(?:in |during |for |of )?(january|february|march|april|may|june|july|august|september|october|november|december|jan|feb|mar|apr|jun|jul|aug|sept|sep|oct|nov|dec)(?: (\d{4}))?\b`)
+	askRelativePattern = regexp.MustCompile(`\b(?:in |during |for |of )?(today|yesterday|this week|last week|this month|last month|this year|last year)\b`)
+	askLastDaysPattern = regexp.MustCompile(`\b(?:in |during |for |over )?(?:the )?(?:last|past) (\d{1,3}) days\b`)
+	askISOMonthPattern = regexp.MustCompile(`\b(?:in |during |for |of )?(\d{4})-(\d{2})\b`)
+	askYearPattern     = regexp.MustCompile(`\b(?:in |during |for |of )?(\d{4})\b`)
+	askMerchantPattern = regexp.MustCompile(`\b(?:on|at|for|to) (?:the )?([\p{L}\p{N}][\p{L}\p{N} '&.-]*)$`)
+)
+
+// askPeriod finds the period in a lowercase question and returns it with
+// the question minus the period words. Without one it is this month.
+func askPeriod(q string, now time.Time) (time.Time, time.Time, string, string) {
+	if m := askRelativePattern.FindStringSubmatchIndex(q); m != nil {
+		rest := q[:m[0]] + q[m[1]:]
+		day, _ := dayBounds(now)
+		month, _ := monthBounds(now)
+		year := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+		switch q[m[2]:m[3]] {
+		case "today":
+			return day, day.AddDate(0, 0, 1), day.Format("2 Jan 2006"), rest
+		case "yesterday":
+			return day.AddDate(0, 0, -1), day, day.AddDate(0, 0, -1).Format("2 Jan 2006"), rest
+		case "this week":
+			from, to := weekBounds(now, weekStartDay())
+			return from, to, "the week of " + from.Format("2 Jan 2006"), rest
+		case "last week":
+			from, to := weekBounds(now, weekStartDay())
+			from = from.AddDate(0, 0, -7)
+			return from, to.AddDate(0, 0, -7), "the week of " + from.Format("2 Jan 2006"), rest
+		case "this month":
+			return month, month.AddDate(0, 1, 0), month.Format("January 2006"), rest
+		case "last month":
+			return month.AddDate(0, -1, 0), month, month.AddDate(0, -1, 0).Format("January 2006"), rest
+		case "this year":
+			return year, year.AddDate(1, 0, 0), year.Format("2006"), rest
+		case "last year":
+			return year.AddDate(-1, 0, 0), year, year.AddDate(-1, 0, 0).Format("2006"), rest
+		}
+	}
+	if m := askLastDaysPattern.FindStringSubmatch(q); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > 0 {
+			_, end := dayBounds(now)
+			return end.AddDate(0, 0, -n), end, fmt.Sprintf("the last %d days", n), strings.Replace(q, m[0], "", 1)
+		}
+	}
+	if m := askISOMonthPattern.FindStringSubmatch(q); m != nil {
+		if t, err := time.ParseInLocation("2006-01", m[1]+"-"+m[2], appLocation()); err == nil {
+			from, to := monthBounds(t)
+			return from, to, from.Format("January 2006"), strings.Replace(q, m[0], "", 1)
+		}
+	}
+	if m := askMonthPattern.FindStringSubmatch(q); m != nil {
+		year := now.Year()
+		if m[2] != "" {
+			year, _ = strconv.Atoi(m[2])
+		} else if askMonths[m[1]] > now.Month() {
+			year-- // "in December" asked in March is last December
+		}
+		from, to := monthBounds(time.Date(year, askMonths[m[1]], 1, 0, 0, 0, 0, appLocation()))
+		return from, to, from.Format("January 2006"), strings.Replace(q, m[0], "", 1)
+	}
+	if m := askYearPattern.FindStringSubmatch(q); m != nil {
+		if year, _ := strconv.Atoi(m[1]); year >= 1970 && year <= 2100 {
+			from := time.Date(year, 1, 1, 0, 0, 0, 0, appLocation())
+			return from, from.AddDate(1, 0, 0), m[1], strings.Replace(q, m[0], "", 1)
+		}
+	}
+	from, to := monthBounds(now)
+	return from, to, from.Format("January 2006"), q
+}
+
+// containsWord tells whether any of words is a word of q.
+func containsWord(q string, words ...string) bool {
+	for _, w := range words {
+		if regexp.MustCompile(`\b` + regexp.QuoteMeta(w) + `\b`).MatchString(q) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAskQuestion reads a question with the templates. It returns false
+// when it cannot tell what is asked.
+func parseAskQuestion(question string, now time.Time) (askQuery, bool) {
+	q := strings.ToLower(strings.TrimSpace(question))
+	q = strings.TrimRight(q, "?!. ")
+	q = strings.Join(strings.Fields(q), " ")
+
+	var a askQuery
+	a.From, a.To, a.Label, q = askPeriod(q, now)
+	q = strings.Join(strings.Fields(q), " ")
+
+	a.Type = "expense"
+	if containsWord(q, "earn", "earned", "income", "received", "receive", "salary", "made") {
+		a.Type = "income"
+	}
+	switch {
+	case containsWord(q, "biggest", "largest", "most expensive", "highest", "top"):
+		a.Intent = "largest"
+	case containsWord(q, "how many", "count", "number of"):
+		a.Intent = "count"
+	case containsWord(q, "average", "avg", "mean"):
+		a.Intent = "average"
+	case containsWord(q, "how much", "total", "spend", "spent", "spending", "paid", "pay", "cost", "earn", "earned", "income"):
+		a.Intent = "sum"
+	default:
+		return askQuery{}, false
+	}
+
+	for _, c := range getCategories() {
+		if containsWord(q, strings.ToLower(c)) {
+			a.Category = c
+			return a, true
+		}
+	}
+	if m := askMerchantPattern.FindStringSubmatch(q); m != nil {
+		merchant := strings.TrimSpace(m[1])
+		if !containsWord(merchant, "it", "them", "everything", "all", "me", "i", "total") {
+			a.Merchant = merchant
+		}
+	}
+	return a, true
+}
+
+// askAI asks the AI provider to read a question the templates could not.
+func askAI(question string, now time.Time) (askQuery, bool) {
+	provider := aiProvider()
+	if provider == nil {
+		return askQuery{}, false
+	}
+	a, err := provider.ParseQuestion(question, getCategories(), now)
+	if err != nil {
+		reportError("reading a question with the AI provider", err)
+		return askQuery{}, false
+	}
+	return a, true
+}
+
+// answerAsk runs the template of a and formats the answer.
+func answerAsk(a askQuery) (string, error) {
+	where := "type = ? AND created_at >= ? AND created_at < ? AND " + ledgerScope()
+	args := []interface{}{a.Type, a.From.Format(dbTimeLayout), a.To.Format(dbTimeLayout)}
+	filter := ""
+	if a.Category != "" {
+		where += " AND category = ?"
+		args = append(args, a.Category)
+		filter += " in " + a.Category
+	}
+	if a.Merchant != "" {
+		where += ` AND description LIKE ? ESCAPE '\'`
+		args = append(args, "%"+strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(a.Merchant)+"%")
+		filter += fmt.Sprintf(" at %q", a.Merchant)
+	}
+
+	var text string
+	if a.Intent == "largest" {
+		var (
+			id          int64
+			category    string
+			amount      Money
+			description sql.NullString
+			createdAt   string
+		)
+		err := db.QueryRow("SELECT id, category, "+lineTotal+" AS total, description, created_at FROM transactions WHERE "+where+
+			" ORDER BY total DESC, created_at LIMIT 1", args...).Scan(&id, &category, &amount, &description, &createdAt)
+		if err == sql.ErrNoRows {
+			text = fmt.Sprintf("No %s%s in %s.", a.Type, filter, a.Label)
+		} else if err != nil {
+			return "", err
+		} else {
+			text = fmt.Sprintf("Largest %s%s in %s: %s · %s · %s · #%d", a.Type, filter, a.Label, formatMoney(amount), category, formatCreatedAt(createdAt), id)
+			if description.String != "" {
+				text += "\n    " + description.String
+			}
+		}
+	} else {
+		var total Money
+		var count int
+		if err := db.QueryRow("SELECT COALESCE(SUM("+lineTotal+"), 0), COUNT(*) FROM transactions WHERE "+where, args...).Scan(&total, &count); err != nil {
+			return "", err
+		}
+		switch {
+		case count == 0:
+			text = fmt.Sprintf("No %s%s in %s.", a.Type, filter, a.Label)
+		case a.Intent == "count":
+			text = fmt.Sprintf("%d %s transaction(s)%s in %s, %s in total.", count, a.Type, filter, a.Label, formatMoney(total))
+		case a.Intent == "average":
+			text = fmt.Sprintf("Average %s%s in %s: %s (%d transaction(s), %s in total).", a.Type, filter, a.Label, formatMoney(total.Div(count)), count, formatMoney(total))
+		default:
+			text = fmt.Sprintf("Total %s%s in %s: %s (%d transaction(s)).", a.Type, filter, a.Label, formatMoney(total), count)
+		}
+	}
+	period := a.From.Format("2 Jan 2006") + " – " + a.To.AddDate(0, 0, -1).Format("2 Jan 2006")
+	return fmt.Sprintf("💬 %s\n\nPeriod used: %s", text, period), nil
+}
+
+func handleAskCommand(chatID int64, args string) {
+	question := strings.TrimSpace(args)
+	if question == "" {
+		sendMessage(chatID, askUsage)
+		return
+	}
+	now := appClock.Now()
+	a, ok := parseAskQuestion(question, now)
+	if !ok {
+		a, ok = askAI(question, now)
+	}
+	if !ok {
+		sendMessage(chatID, "Sorry, I can't answer that yet. Ask about totals, counts, averages or the biggest transaction.\n\n"+askUsage)
+		return
+	}
+	text, err := answerAsk(a)
+	if err != nil {
+		sendMessage(chatID, "Failed to answer the question.")
+		reportError("answering a question", err)
+		return
+	}
+	sendMessage(chatID, text)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+	BENCHMARKS
+
+	go test -run '^$' -bench HotPaths [-benchrows 100000]
+
+	times the hot paths against an in-memory database holding a large
+	ledger made up by the demo generator (demo.go), three years of as many
+	households as it takes to reach -benchrows, and fails when one is
+	slower than its budget:
+
+		add          insert one transaction, with the aggregate and
+		             audit triggers                               10ms
+		summary      month totals from the aggregates, with the
+		             change against the month before              50ms
+		week         a week of entries against the week before    50ms
+		list_50      the newest transactions                      50ms
+		top_10       the largest expenses of the month            50ms
+		budget       the budget bars of the month                 50ms
+		burnrate     daily spend this month and before           100ms
+		insights     merchants, weekdays and unusual categories  250ms
+		pinned       the month-to-date summary, after every add   50ms
+		split        a 10,000 character message cut in parts      10ms
+
+	Each is judged on its time per operation. The budgets hold on a modest
+	VPS with room to spare, so a run over budget points at a missing index,
+	a query that stopped using the aggregates or a cache gone cold, not at
+	a slow machine. Run it before and after touching the queries, indexes
+	or caches.
+*/
+
+var benchRows = flag.Int("benchrows", 100000, "Transactions in the ledger generated for BenchmarkHotPaths")
+
+type benchmark struct {
+	name   string
+	budget time.Duration
+	run    func(h *harness) error
+}
+
+// benchCommand runs a chat command and fails if the reply reports an
+// error.
+func benchCommand(command string) func(h *harness) error {
+	return func(h *harness) error {
+		if reply := h.send(command); strings.Contains(reply, "Failed") || strings.Contains(reply, "Error") {
+			return fmt.Errorf("%s: %s", command, strings.TrimSpace(reply))
+		}
+		return nil
+	}
+}
+
+var benchmarks = []benchmark{
+	{"add", 10 * time.Millisecond, func(h *harness) error {
+		_, _, err := insertTransaction("expense", "Food", 1, 25000*moneyScale, "bench", appClock.Now(), false)
+		return err
+	}},
+	{"summary", 50 * time.Millisecond, benchCommand("/summary")},
+	{"week", 50 * time.Millisecond, benchCommand("/week")},
+	{"list 50", 50 * time.Millisecond, benchCommand("/list 50")},
+	{"top 10", 50 * time.Millisecond, benchCommand("/top 10")},
+	{"budget", 50 * time.Millisecond, benchCommand("/budget")},
+	{"burnrate", 100 * time.Millisecond, benchCommand("/burnrate")},
+	{"insights", 250 * time.Millisecond, benchCommand("/insights")},
+	{"pinned", 50 * time.Millisecond, func(h *harness) error {
+		_, err := pinnedSummaryText(appClock.Now())
+		return err
+	}},
+	{"split", 10 * time.Millisecond, func(h *harness) error {
+		if parts := splitMessage(benchLongText, messageLimit-64); len(parts) < 3 {
+			return fmt.Errorf("split in %d part(s)", len(parts))
+		}
+		return nil
+	}},
+}
+
+// benchLongText is a report of 10,000 characters, in lines like /list's.
+var benchLongText = strings.Repeat("💸 <code>#1234</code> 2026-01-31 <b>Transportation</b> <code>Rp 125.000</code> Ojek\n", 120)[:10000]
+
+// fillBenchLedger adds about rows transactions over the three years before
+// now, layering demo households until there are enough.
+func fillBenchLedger(rows int, seed int64) (int, error) {
+	now := appClock.Now()
+	from := now.AddDate(-3, 0, 0)
+	rng := rand.New(rand.NewSource(seed))
+	var transactions []Transaction
+	households := 0
+	for len(transactions) < rows {
+		transactions = append(transactions, generateDemoTransactions(from, now, getCategories(), rng)...)
+		households++
+	}
+	transactions = transactions[:rows]
+	if err := insertTransactions(transactions); err != nil {
+		return 0, err
+	}
+	for _, b := range demoBudgets {
+		if err := store.SetCategoryBudget(b.Category, b.Amount.Mul(float64(households))); err != nil {
+			return 0, err
+		}
+	}
+	return len(transactions), nil
+}
+
+func BenchmarkHotPaths(b *testing.B) {
+	// the handlers log every report they send
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	h := newHarness(b)
+	if _, err := fillBenchLedger(*benchRows, 1); err != nil {
+		b.Fatalf("generate the ledger: %v", err)
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := bm.run(h); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if perOp := b.Elapsed() / time.Duration(b.N); perOp > bm.budget {
+				b.Errorf("%s per operation, over its %s budget", perOp, bm.budget)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	BILL REMINDERS (/bill)
+
+	A bill has an amount and a due day of the month. Starting remind_days
+	before the due date, a daily reminder with a "Mark paid" button is
+	queued until the bill is paid. Paying records the expense and moves the
+	due date to the next month.
+*/
+
+const (
+	defaultBillCategory   = "Bills"
+	defaultBillRemindDays = 3
+	dateLayout            = "2006-01-02"
+)
+
+type bill struct {
+	ID         int64
+	Name       string
+	Amount     Money
+	DueDay     int
+	Category   string
+	RemindDays int
+	NextDue    time.Time
+}
+
+// dueDateIn returns the due date for dueDay in the month of t, clamped to
+// the last day of short months.
+func dueDateIn(t time.Time, dueDay int) time.Time {
+	if last := daysInMonth(t); dueDay > last {
+		dueDay = last
+	}
+	return time.Date(t.Year(), t.Month(), dueDay, 0, 0, 0, 0, appLocation())
+}
+
+// firstDueDate returns the next due date on or after today.
+func firstDueDate(now time.Time, dueDay int) time.Time {
+	today, _ := dayBounds(now)
+	due := dueDateIn(now, dueDay)
+	if due.Before(today) {
+		due = dueDateIn(time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, appLocation()), dueDay)
+	}
+	return due
+}
+
+func loadBills() ([]bill, error) {
+	rows, err := db.Query("SELECT id, name, amount, due_day, category, remind_days, next_due FROM bills ORDER BY next_due, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bills []bill
+	for rows.Next() {
+		var b bill
+		var nextDue string
+		if err := rows.Scan(&b.ID, &b.Name, &b.Amount, &b.DueDay, &b.Category, &b.RemindDays, &nextDue); err != nil {
+			return nil, err
+		}
+		if b.NextDue, err = time.ParseInLocation(dateLayout, nextDue, appLocation()); err != nil {
+			return nil, fmt.Errorf("bill %d: invalid next_due %q", b.ID, nextDue)
+		}
+		bills = append(bills, b)
+	}
+	return bills, rows.Err()
+}
+
+func findBill(name string) (*bill, error) {
+	bills, err := loadBills()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bills {
+		if strings.EqualFold(b.Name, name) {
+			return &b, nil
+		}
+	}
+	return nil, nil
+}
+
+const billUsage = "Usage:\n/bill - list bills\n/bill add <name> <amount> <due day> [remind days before]\n/bill paid <name>\n/bill remove <name>"
+
+// handleBillCommand implements /bill and /bills.
+func handleBillCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || strings.EqualFold(fields[0], "list") {
+		showBills(chatID)
+		return
+	}
+
+	action := strings.ToLower(fields[0])
+	name := strings.Join(fields[1:], " ")
+	switch action {
+	case "add":
+		addBill(chatID, fields[1:])
+	case "paid", "remove":
+		if name == "" {
+			sendMessage(chatID, billUsage)
+			return
+		}
+		b, err := findBill(name)
+		if err != nil {
+			sendMessage(chatID, "Failed to load bills.")
+			reportError("loading bills", err)
+			return
+		}
+		if b == nil {
+			sendMessage(chatID, fmt.Sprintf("No bill named %q.", name))
+			return
+		}
+		if action == "paid" {
+			text, err := payBill(b.ID, b.NextDue.Format(dateLayout))
+			if err != nil {
+				sendMessage(chatID, "Failed to record the payment.")
+				reportError(fmt.Sprintf("paying bill %d", b.ID), err)
+				return
+			}
+			sendMessage(chatID, text)
+			return
+		}
+		if _, err := db.Exec("DELETE FROM bills WHERE id = ?", b.ID); err != nil {
+			sendMessage(chatID, "Failed to remove the bill.")
+			reportError(fmt.Sprintf("removing bill %d", b.ID), err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Bill %s removed.", b.Name))
+	default:
+		sendMessage(chatID, billUsage)
+	}
+}
+
+// addBill parses "<name...> <amount> <due day> [remind days]".
+func addBill(chatID int64, fields []string) {
+	remindDays := defaultBillRemindDays
+	numbers := 0
+	for i := len(fields) - 1; i >= 0 && numbers < 3; i-- {
+		if _, err := strconv.ParseFloat(fields[i], 64); err != nil {
+			break
+		}
+		numbers++
+	}
+	if numbers < 2 || len(fields)-numbers < 1 {
+		sendMessage(chatID, billUsage)
+		return
+	}
+	name := strings.Join(fields[:len(fields)-numbers], " ")
+	nums := fields[len(fields)-numbers:]
+
+	amount, amountErr := parseMoney(nums[0])
+	dueDay, err := strconv.Atoi(nums[1])
+	if amount <= 0 || amountErr != nil || err != nil || dueDay < 1 || dueDay > 31 {
+		sendMessage(chatID, "Invalid bill. The amount must be positive and the due day between 1 and 31.")
+		return
+	}
+	if len(nums) == 3 {
+		remindDays, err = strconv.Atoi(nums[2])
+		if err != nil || remindDays < 0 || remindDays > 28 {
+			sendMessage(chatID, "Invalid number of reminder days (0-28).")
+			return
+		}
+	}
+	// a bill named after a category ("Rent") is booked there, others under Bills
+	category, ok := findCategory(name)
+	if !ok {
+		category = defaultBillCategory
+	}
+
+	nextDue := firstDueDate(appClock.Now(), dueDay)
+	_, err = db.Exec("INSERT INTO bills (name, amount, due_day, category, remind_days, next_due) VALUES (?, ?, ?, ?, ?, ?)",
+		name, amount, dueDay, category, remindDays, nextDue.Format(dateLayout))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			sendMessage(chatID, fmt.Sprintf("A bill named %q already exists.", name))
+			return
+		}
+		sendMessage(chatID, "Failed to save the bill.")
+		reportError("adding a bill", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Bill %s added: %s due on day %d, next due %s. Reminders start %d day(s) before.",
+		name, formatMoney(amount), dueDay, nextDue.Format("2 Jan 2006"), remindDays))
+}
+
+func showBills(chatID int64) {
+	bills, err := loadBills()
+	if err != nil {
+		sendMessage(chatID, "Failed to load bills.")
+		reportError("loading bills", err)
+		return
+	}
+	if len(bills) == 0 {
+		sendMessage(chatID, "No bills yet.\n\n"+billUsage)
+		return
+	}
+
+	today, _ := dayBounds(appClock.Now())
+	var sb strings.Builder
+	sb.WriteString("🧾 Bills\n\n")
+	var total Money
+	for _, b := range bills {
+		days := int(b.NextDue.Sub(today).Hours() / 24)
+		when := fmt.Sprintf("in %d day(s)", days)
+		switch {
+		case days == 0:
+			when = "today"
+		case days < 0:
+			when = fmt.Sprintf("overdue by %d day(s)", -days)
+		}
+		sb.WriteString(fmt.Sprintf("• %s: %s, due %s (%s)\n", b.Name, formatMoney(b.Amount), b.NextDue.Format("2 Jan"), when))
+		total += b.Amount
+	}
+	sb.WriteString(fmt.Sprintf("\nTotal per month: %s", formatMoney(total)))
+	sendMessage(chatID, sb.String())
+}
+
+// payBill records the payment for the cycle due on dueDate and moves the
+// bill to its next cycle. A second payment for the same cycle is ignored.
+func payBill(id int64, dueDate string) (string, error) {
+	ledgerID := activeLedgerID()
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var b bill
+	var nextDue string
+	err = tx.QueryRow("SELECT id, name, amount, due_day, category, next_due FROM bills WHERE id = ?", id).
+		Scan(&b.ID, &b.Name, &b.Amount, &b.DueDay, &b.Category, &nextDue)
+	if err != nil {
+		return "This bill no longer exists.", nil
+	}
+	due, err := time.ParseInLocation(dateLayout, nextDue, appLocation())
+	if err != nil {
+		return "", err
+	}
+	if nextDue != dueDate {
+		return fmt.Sprintf("%s is already paid; next due %s.", b.Name, due.Format("2 Jan 2006")), nil
+	}
+	next := dueDateIn(time.Date(due.Year(), due.Month()+1, 1, 0, 0, 0, 0, appLocation()), b.DueDay)
+
+	t := Transaction{Type: "expense", Category: b.Category, Quantity: 1, Amount: b.Amount, Description: b.Name, CreatedAt: appClock.Now(), LedgerID: ledgerID}
+	transactionID, err := addTransactionTx(tx, t)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE bills SET next_due = ? WHERE id = ?", next.Format(dateLayout), b.ID); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	text := fmt.Sprintf("✅ %s paid: %s recorded under %s. Next due %s.", b.Name, formatMoney(b.Amount), b.Category, next.Format("2 Jan 2006"))
+	if roundup := transactionAdded(transactionID, t); roundup != "" {
+		text += "\n" + roundup
+	}
+	return text, nil
+}
+
+// handleBillCallback handles the "Mark paid" button: bill:paid:<id>:<due date>.
+func handleBillCallback(callback *CallbackQuery) {
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 4 || parts[1] != "paid" {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	text, err := payBill(id, parts[3])
+	if err != nil {
+		reportError(fmt.Sprintf("paying bill %d", id), err)
+		sendMessage(callback.Message.Chat.ID, "Failed to record the payment.")
+		return
+	}
+	editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text)
+}
+
+// sendBillReminders queues a reminder for every unpaid bill within its
+// reminder window, at most once per bill per day.
+func sendBillReminders(now time.Time) error {
+	bills, err := loadBills()
+	if err != nil {
+		return err
+	}
+	today, _ := dayBounds(now)
+	for _, b := range bills {
+		days := int(b.NextDue.Sub(today).Hours() / 24)
+		if days > b.RemindDays {
+			continue
+		}
+		var text string
+		switch {
+		case days > 0:
+			text = fmt.Sprintf("🧾 %s (%s) is due in %d day(s), on %s.", b.Name, formatMoney(b.Amount), days, b.NextDue.Format("2 Jan"))
+		case days == 0:
+			text = fmt.Sprintf("🧾 %s (%s) is due today.", b.Name, formatMoney(b.Amount))
+		default:
+			text = fmt.Sprintf("⚠️ %s (%s) is overdue since %s.", b.Name, formatMoney(b.Amount), b.NextDue.Format("2 Jan"))
+		}
+		due := b.NextDue.Format(dateLayout)
+		keyboard := buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "✅ Mark paid", CallbackData: fmt.Sprintf("bill:paid:%d:%s", b.ID, due)},
+		}})
+		key := fmt.Sprintf("bill:%d:%s:%s", b.ID, due, now.Format(dateLayout))
+		if err := enqueueNotification(ownerID(), "bill_reminder", key, text, keyboard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upcomingBills feeds unpaid bills due this month into the forecast.
+func upcomingBills(now time.Time) ([]upcomingEntry, error) {
+	bills, err := loadBills()
+	if err != nil {
+		return nil, err
+	}
+	var upcoming []upcomingEntry
+	for _, b := range bills {
+		if b.NextDue.Year() != now.Year() || b.NextDue.Month() != now.Month() {
+			continue
+		}
+		day := b.NextDue.Day()
+		if day < now.Day() {
+			day = now.Day()
+		}
+		upcoming = append(upcoming, upcomingEntry{Label: b.Name + " (bill)", Type: "expense", Amount: b.Amount, Day: day})
+	}
+	return upcoming, nil
+}
+
+// addBillKeys adds the recurring-pattern keys of bill payments.
+func addBillKeys(keys map[string]bool) {
+	bills, err := loadBills()
+	if err != nil {
+		reportError("loading bills", err)
+		return
+	}
+	for _, b := range bills {
+		keys[recurringPattern{Type: "expense", Category: b.Category, Description: b.Name}.key()] = true
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"strings"
+	"time"
+)
+
+/*
+	BUDGETS
+
+	The monthly budget is stored in the monthly_budget setting; per-category
+	budgets live in the budgets table. When no overall budget is set, the
+	sum of the category budgets is used instead.
+*/
+
+const dbTimeLayout = "2006-01-02 15:04:05"
+
+// monthBounds returns the first instant of t's month and of the next month.
+func monthBounds(t time.Time) (time.Time, time.Time) {
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 1, 0)
+}
+
+// dayBounds returns the first instant of t's day and of the next day.
+func dayBounds(t time.Time) (time.Time, time.Time) {
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+func daysInMonth(t time.Time) int {
+	start, end := monthBounds(t)
+	return int(end.Sub(start).Hours()/24 + 0.5)
+}
+
+// totalBetween sums the amounts of one transaction type in [from, to).
+func totalBetween(typ string, from time.Time, to time.Time) (Money, error) {
+	return store.Total(typ, "", from, to)
+}
+
+// monthlyBudget returns the overall monthly budget, or 0 when none is set.
+func monthlyBudget() Money {
+	if budget := getMoneySetting("monthly_budget", 0); budget > 0 {
+		return budget
+	}
+	budgets, err := loadCategoryBudgets()
+	if err != nil {
+		log.Printf("Failed to sum category budgets: %v", err)
+	}
+	var total Money
+	for _, b := range budgets {
+		total += b.Amount
+	}
+	return total
+}
+
+// budgetTier returns the warning emoji for spending at ratio of a budget.
+func budgetTier(ratio float64) string {
+	switch {
+	case ratio > 1:
+		return "🔴"
+	case ratio >= 0.9:
+		return "🟠"
+	case ratio >= 0.75:
+		return "🟡"
+	default:
+		return "🟢"
+	}
+}
+
+// categoryBudgetStatus describes month-to-date spending in category against
+// its budget, e.g. "Food: 420.00/600.00 this month (180.00 left)" above
+// "🟢 ▰▰▰▰▰▰▰▱▱▱ 70%". It returns "" when the category has no budget.
+func categoryBudgetStatus(category string, now time.Time) (string, error) {
+	budget, err := store.CategoryBudget(category)
+	if err != nil || budget == 0 {
+		return "", err
+	}
+
+	monthStart, monthEnd := monthBounds(now)
+	spent, err := store.Total("expense", category, monthStart, monthEnd)
+	if err != nil {
+		return "", err
+	}
+
+	status := fmt.Sprintf("%s: %s/%s this month", category, formatMoney(spent), formatMoney(budget))
+	if left := budget - spent; left >= 0 {
+		status += fmt.Sprintf(" (%s left)", formatMoney(left))
+	} else {
+		status += fmt.Sprintf(" (%s over)", formatMoney(-left))
+	}
+	return status + "\n" + budgetProgress(float64(spent)/float64(budget)), nil
+}
+
+// budgetAlertTiers are the tiers that send a budget alert, once a month
+// per budget and tier.
+var budgetAlertTiers = map[string]string{
+	"🟠": "is at %.0f%% of its budget",
+	"🔴": "is over budget at %.0f%%",
+}
+
+// checkBudgetAlerts queues an alert for every budget that reached a new
+// warning tier this month, whatever added the spending. It runs after
+// every new expense (transactionAdded) and on every scheduler tick, for
+// the edits and imports.
+func checkBudgetAlerts(now time.Time) error {
+	budgets, err := loadCategoryBudgets()
+	if err != nil {
+		return err
+	}
+	spent, err := categorySpending(now)
+	if err != nil {
+		return err
+	}
+	type check struct {
+		name          string
+		spent, budget Money
+	}
+	var checks []check
+	if overall := monthlyBudget(); overall > 0 {
+		var total Money
+		for _, amount := range spent {
+			total += amount
+		}
+		checks = append(checks, check{"Monthly spending", total, overall})
+	}
+	for _, b := range budgets {
+		checks = append(checks, check{b.Category, spent[b.Category], b.Amount})
+	}
+
+	month := now.Format("2006-01")
+	for _, c := range checks {
+		ratio := float64(c.spent) / float64(c.budget)
+		tier := budgetTier(ratio)
+		format, ok := budgetAlertTiers[tier]
+		if !ok {
+			continue
+		}
+		text := fmt.Sprintf("%s %s "+format+" (%s/%s).\n%s", tier, c.name, ratio*100, formatMoney(c.spent), formatMoney(c.budget), budgetProgress(ratio))
+		if err := enqueueNotification(ownerID(), "budget_alert", fmt.Sprintf("budget:%s:%s:%s", c.name, month, tier), text, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type categoryBudget struct {
+	Category string
+	Amount   Money
+}
+
+func loadCategoryBudgets() ([]categoryBudget, error) {
+	return store.CategoryBudgets()
+}
+
+func setCategoryBudget(category string, amount Money) error {
+	return store.SetCategoryBudget(category, amount)
+}
+
+// handleBudgetCommand implements /budget:
+//
+//	/budget                     show budgets
+//	/budget 3000000             set the overall monthly budget
+//	/budget off                 remove the overall monthly budget
+//	/budget Food 600000         set a category budget
+//	/budget Food off            remove a category budget
+func handleBudgetCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	switch len(fields) {
+	case 0:
+		showBudgets(chatID)
+	case 1:
+		if strings.EqualFold(fields[0], "off") {
+			if err := setSetting("monthly_budget", "0"); err != nil {
+				sendMessage(chatID, "Failed to update budget.")
+				reportError("clearing the monthly budget", err)
+				return
+			}
+			sendMessage(chatID, "Monthly budget removed.")
+			return
+		}
+		amount, err := parseMoney(fields[0])
+		if err != nil || amount <= 0 {
+			sendMessage(chatID, "Invalid amount. Usage: /budget <amount> or /budget <category> <amount>")
+			return
+		}
+		if err := setSetting("monthly_budget", amount.String()); err != nil {
+			sendMessage(chatID, "Failed to update budget.")
+			reportError("setting the monthly budget", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Monthly budget set to %s.", formatMoney(amount)))
+	default:
+		value := fields[len(fields)-1]
+		category, ok := findCategory(strings.Join(fields[:len(fields)-1], " "))
+		if !ok {
+			sendMessage(chatID, fmt.Sprintf("Unknown category. Available: %s", strings.Join(activeCategories(), ", ")))
+			return
+		}
+		if strings.EqualFold(value, "off") {
+			if err := store.RemoveCategoryBudget(category); err != nil {
+				sendMessage(chatID, "Failed to update budget.")
+				reportError("deleting the budget for "+category, err)
+				return
+			}
+			sendMessage(chatID, fmt.Sprintf("Budget for %s removed.", category))
+			return
+		}
+		amount, err := parseMoney(value)
+		if err != nil || amount <= 0 {
+			sendMessage(chatID, "Invalid amount. Usage: /budget <category> <amount>")
+			return
+		}
+		if err := setCategoryBudget(category, amount); err != nil {
+			sendMessage(chatID, "Failed to update budget.")
+			reportError("setting the budget for "+category, err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Budget for %s set to %s per month.", category, formatMoney(amount)))
+	}
+}
+
+func showBudgets(chatID int64) {
+	budgets, err := loadCategoryBudgets()
+	if err != nil {
+		sendMessage(chatID, "Failed to load budgets.")
+		reportError("loading budgets", err)
+		return
+	}
+	overall := getMoneySetting("monthly_budget", 0)
+	if overall <= 0 && len(budgets) == 0 {
+		sendMessage(chatID, "No budgets set. Use /budget <amount> for a monthly budget or /budget <category> <amount> for a category.")
+		return
+	}
+
+	now := appClock.Now()
+	spent, err := categorySpending(now)
+	if err != nil {
+		sendMessage(chatID, "Failed to load budgets.")
+		reportError("loading spending per category", err)
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(bold("Budgets:") + "\n")
+	if overall > 0 {
+		var total Money
+		for _, amount := range spent {
+			total += amount
+		}
+		sb.WriteString(fmt.Sprintf("Monthly: %s\n%s\n", moneyHTML(overall), escapeHTML(budgetProgress(float64(total)/float64(overall)))))
+	}
+	for _, b := range budgets {
+		sb.WriteString(fmt.Sprintf("• %s: %s\n%s\n", escapeHTML(b.Category), moneyHTML(b.Amount), escapeHTML(budgetProgress(float64(spent[b.Category])/float64(b.Amount)))))
+	}
+	sendHTML(chatID, strings.TrimRight(sb.String(), "\n"))
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	DATA BUNDLE (/export_all, /import_all; owner only)
+
+	The whole ledger as one versioned JSON document, to move an instance to
+	another host or keep a backup that does not depend on the SQLite
+	format:
+
+	{"format": "ayunda-bundle", "version": 2, "exported_at": "...",
+	 "tables": {"transactions": [{"id": 1, "type": "expense", ...}], ...}}
+
+	Each table is a list of rows keyed by column name, with dates written
+	the way they are stored. Derived and runtime tables (monthly totals,
+	outbox, job runs, activity) are left out and rebuilt. Importing is only
+	allowed into an instance without transactions, and only after typing
+	the confirmation code (confirm.go); columns the bundle does not have
+	keep their defaults, so older bundles stay importable.
+
+	Since version 2 amounts are written the way they are stored, in minor
+	units (money.go); version 1 bundles have them in major units and are
+	converted on import.
+*/
+
+const (
+	bundleFormat  = "ayunda-bundle"
+	bundleVersion = 2
+)
+
+// bundleTables are the exported tables, parents before children.
+var bundleTables = []string{
+	"categories", "settings", "ledgers", "transactions", "transactions_archive", "transaction_audit", "transaction_references", "reconciliations",
+	"budgets", "allocation_plans", "allocations", "rules", "bills", "invoices", "subscriptions", "holdings", "prices", "fx_rates", "savings_goals", "goal_contributions", "saved_reports", "report_schedules",
+	"split_groups", "group_members", "split_expenses", "split_shares", "split_settlements",
+}
+
+// bundleSkippedSettings describe this instance rather than the data.
+var bundleSkippedSettings = map[string]bool{
+	"aggregates_version":        true,
+	"maintenance_mode":          true,
+	"money_version":             true,
+	"pinned_summary.chat_id":    true,
+	"pinned_summary.message_id": true,
+	"update_offset":             true,
+}
+
+type dataBundle struct {
+	Format     string                              `json:"format"`
+	Version    int                                 `json:"version"`
+	ExportedAt string                              `json:"exported_at"`
+	Tables     map[string][]map[string]interface{} `json:"tables"`
+}
+
+// bundleValue converts a scanned value to what the bundle stores; times
+// go back to the text they were stored as.
+func bundleValue(v interface{}, dbType string) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if strings.EqualFold(dbType, "DATE") {
+			return v.Format(dateLayout)
+		}
+		return v.Format(dbTimeLayout)
+	case []byte:
+		return string(v)
+	}
+	return v
+}
+
+func exportTable(table string) ([]map[string]interface{}, error) {
+	rows, err := db.Query("SELECT * FROM " + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		record := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			record[c.Name()] = bundleValue(values[i], c.DatabaseTypeName())
+		}
+		if table == "settings" && bundleSkippedSettings[fmt.Sprint(record["key"])] {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func writeBundle(w io.Writer) error {
+	bundle := dataBundle{
+		Format:     bundleFormat,
+		Version:    bundleVersion,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Tables:     make(map[string][]map[string]interface{}),
+	}
+	for _, table := range bundleTables {
+		records, err := exportTable(table)
+		if err != nil {
+			return fmt.Errorf("export %s: %w", table, err)
+		}
+		bundle.Tables[table] = records
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(bundle)
+}
+
+func readBundle(r io.Reader) (*dataBundle, error) {
+	var bundle dataBundle
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("not a valid bundle: %w", err)
+	}
+	if bundle.Format != bundleFormat {
+		return nil, fmt.Errorf("not an %s file", bundleFormat)
+	}
+	if bundle.Version < 1 || bundle.Version > bundleVersion {
+		return nil, fmt.Errorf("bundle version %d is not supported (this version reads up to %d)", bundle.Version, bundleVersion)
+	}
+	if bundle.Version < 2 {
+		if err := upgradeBundleMoney(&bundle); err != nil {
+			return nil, fmt.Errorf("converting the amounts: %w", err)
+		}
+	}
+	return &bundle, nil
+}
+
+// upgradeBundleMoney converts the amounts of a version 1 bundle, in major
+// units, to minor units, including the ones in the audit history.
+func upgradeBundleMoney(bundle *dataBundle) error {
+	toMinor := func(v interface{}) (interface{}, error) {
+		n, ok := v.(json.Number)
+		if !ok {
+			return v, nil
+		}
+		m, err := parseMoney(n.String())
+		if err != nil {
+			f, ferr := n.Float64()
+			if ferr != nil {
+				return nil, err
+			}
+			m = moneyFromFloat(f)
+		}
+		return json.Number(strconv.FormatInt(int64(m), 10)), nil
+	}
+	for _, c := range moneyColumns {
+		for _, record := range bundle.Tables[c.table] {
+			if _, ok := record[c.column]; !ok {
+				continue
+			}
+			v, err := toMinor(record[c.column])
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", c.table, c.column, err)
+			}
+			record[c.column] = v
+		}
+	}
+	for _, record := range bundle.Tables["transaction_audit"] {
+		for _, column := range []string{"old_values", "new_values"} {
+			text, ok := record[column].(string)
+			if !ok || text == "" {
+				continue
+			}
+			var values map[string]interface{}
+			dec := json.NewDecoder(strings.NewReader(text))
+			dec.UseNumber()
+			if err := dec.Decode(&values); err != nil {
+				return fmt.Errorf("transaction_audit.%s: %w", column, err)
+			}
+			amount, err := toMinor(values["amount"])
+			if err != nil {
+				return fmt.Errorf("transaction_audit.%s: %w", column, err)
+			}
+			if amount == nil {
+				continue
+			}
+			values["amount"] = amount
+			converted, err := json.Marshal(values)
+			if err != nil {
+				return err
+			}
+			record[column] = string(converted)
+		}
+	}
+	return nil
+}
+
+// tableColumns returns the columns of table in this database.
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// importValue turns a decoded JSON value into a database value.
+func importValue(v interface{}) interface{} {
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	}
+	return v
+}
+
+var errLedgerNotEmpty = errors.New("this instance already has transactions; a bundle can only be imported into a fresh one")
+
+// importBundle restores a bundle in one database transaction and returns
+// the number of rows per table.
+func importBundle(bundle *dataBundle) (map[string]int, error) {
+	var existing int
+	if err := db.QueryRow("SELECT (SELECT COUNT(*) FROM transactions) + (SELECT COUNT(*) FROM transactions_archive)").Scan(&existing); err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, errLedgerNotEmpty
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	counts := make(map[string]int)
+	for _, table := range bundleTables {
+		records := bundle.Tables[table]
+		if len(records) == 0 {
+			continue
+		}
+		columns, err := tableColumns(tx, table)
+		if err != nil {
+			return nil, err
+		}
+		if table == "categories" {
+			// the defaults of a fresh instance give way to the bundle's
+			if _, err := tx.Exec("DELETE FROM categories"); err != nil {
+				return nil, err
+			}
+		}
+		for i, record := range records {
+			var names, marks []string
+			var values []interface{}
+			for name, v := range record {
+				if !columns[name] {
+					continue
+				}
+				names = append(names, name)
+				marks = append(marks, "?")
+				values = append(values, importValue(v))
+			}
+			if len(names) == 0 {
+				continue
+			}
+			query := fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), strings.Join(marks, ", "))
+			if _, err := tx.Exec(query, values...); err != nil {
+				return nil, fmt.Errorf("%s row %d: %w", table, i+1, err)
+			}
+		}
+		counts[table] = len(records)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// the triggers kept the totals, but the bundle may come from a build with different ones
+	if err := rebuildAggregates(); err != nil {
+		log.Printf("Failed to rebuild aggregates after import: %v", err)
+	}
+	refreshCategories()
+	refreshCurrency()
+	return counts, nil
+}
+
+func formatBundleCounts(counts map[string]int) string {
+	var parts []string
+	for _, table := range bundleTables {
+		if counts[table] > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", table, counts[table]))
+		}
+	}
+	if len(parts) == 0 {
+		return "nothing"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sendBundle(chatID int64) {
+	f, err := os.CreateTemp(dataSubdir("exports"), "ayunda-bundle-*.json")
+	if err != nil {
+		sendMessage(chatID, "Failed to export the data.")
+		reportError("creating the bundle file", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	err = writeBundle(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to export the data.")
+		reportError("writing the bundle", err)
+		return
+	}
+	if err := messenger.SendFile(chatID, f.Name(), fmt.Sprintf("Full data export (bundle version %d). Restore it on another instance with /import_all.", bundleVersion)); err != nil {
+		sendMessage(chatID, "Failed to send the export.")
+		reportError("sending the bundle", err)
+	}
+}
+
+func startImportBundle(chatID int64, userID int64) {
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+	userStates[userID] = &TransactionState{UserID: userID, Step: "AWAIT_BUNDLE"}
+	sendMessage(chatID, "Send the bundle made by /export_all as a .json file, or 'cancel' to abort.\nIt can only be imported into an instance without transactions.")
+}
+
+// importBundleFile restores an uploaded bundle once userID confirms it.
+func importBundleFile(chatID int64, userID int64, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		sendMessage(chatID, "Failed to read the uploaded file.")
+		reportError("opening the uploaded bundle", err)
+		return
+	}
+	defer f.Close()
+	bundle, err := readBundle(f)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Import failed: %v.", err))
+		return
+	}
+	rows := 0
+	for _, records := range bundle.Tables {
+		rows += len(records)
+	}
+	action := fmt.Sprintf("import %d row(s) from the bundle exported %s, replacing the categories and settings", rows, bundle.ExportedAt)
+	requireConfirmation(chatID, userID, action, func() { restoreBundle(chatID, bundle) })
+}
+
+func restoreBundle(chatID int64, bundle *dataBundle) {
+	counts, err := importBundle(bundle)
+	if err == errLedgerNotEmpty {
+		sendMessage(chatID, "Import refused: "+err.Error()+".")
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Import failed, nothing was changed.")
+		reportError("importing a bundle", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Import complete (bundle exported %s).\n%s", bundle.ExportedAt, formatBundleCounts(counts)))
+}
+
+func cmdBundle(args []string) error {
+	fs := newCommandFlags("bundle", "[-o bundle.json] | -restore bundle.json")
+	output := fs.String("o", "-", "Output file, - for stdout")
+	restore := fs.String("restore", "", "Import this bundle into an empty database instead")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *restore != "" {
+		f, err := os.Open(*restore)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		bundle, err := readBundle(f)
+		if err != nil {
+			return err
+		}
+		counts, err := importBundle(bundle)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %s\n", formatBundleCounts(counts))
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return writeBundle(w)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+/*
+	BURN RATE (/burnrate)
+
+	The average daily spend of this month so far, the same for the
+	previous months, and how many days the cash balance (all income minus
+	all expenses, archived ones included) lasts at this month's rate.
+*/
+
+const burnRateMonths = 3
+
+// cashBalance is all income minus all expenses, archived ones included.
+func cashBalance() (Money, error) {
+	var balance Money
+	err := db.QueryRow(`SELECT COALESCE(SUM(CASE WHEN type = 'income' THEN amount ELSE -amount END), 0)
+		FROM ` + transactionSource(true)).Scan(&balance)
+	return balance, err
+}
+
+func burnRateText(now time.Time) (string, error) {
+	monthStart, _ := monthBounds(now)
+	_, spent, err := monthTotals(now)
+	if err != nil {
+		return "", err
+	}
+	balance, err := cashBalance()
+	if err != nil {
+		return "", err
+	}
+	day, days := now.Day(), daysInMonth(now)
+	perDay := spent.Float() / float64(day)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔥 Burn rate, %s (day %d of %d)\n\n", now.Format("January 2006"), day, days))
+	sb.WriteString(fmt.Sprintf("Spent so far: %s\nAverage: %s a day, %s a week\nAt this rate the month ends at %s\n", formatMoney(spent), formatMoney(moneyFromFloat(perDay)), formatMoney(moneyFromFloat(perDay*7)), formatMoney(moneyFromFloat(perDay*float64(days)))))
+
+	sb.WriteString("\nPrevious months:\n")
+	sum, counted := 0.0, 0
+	for i := 1; i <= burnRateMonths; i++ {
+		month := monthStart.AddDate(0, -i, 0)
+		_, expense, err := monthTotals(month)
+		if err != nil {
+			return "", err
+		}
+		rate := expense.Float() / float64(daysInMonth(month))
+		sb.WriteString(fmt.Sprintf("• %s: %s a day\n", month.Format("Jan 2006"), formatMoney(moneyFromFloat(rate))))
+		if expense > 0 {
+			sum += rate
+			counted++
+		}
+	}
+	if counted > 0 {
+		average := sum / float64(counted)
+		sb.WriteString(fmt.Sprintf("Average: %s a day, this month %+.0f%%\n", formatMoney(moneyFromFloat(average)), percentOf(perDay-average, average)))
+	}
+
+	sb.WriteString(fmt.Sprintf("\nBalance: %s\n", formatMoney(balance)))
+	switch {
+	case balance <= 0:
+		sb.WriteString("Runway: none, the balance is not positive.")
+	case perDay == 0:
+		sb.WriteString("Runway: no spending this month yet.")
+	default:
+		runway := balance.Float() / perDay
+		if runway > 3650 {
+			sb.WriteString(fmt.Sprintf("Runway: %.0f days, over ten years.", runway))
+		} else {
+			until := now.AddDate(0, 0, int(math.Floor(runway)))
+			sb.WriteString(fmt.Sprintf("Runway: %.0f days, until about %s.", math.Floor(runway), until.Format("2 Jan 2006")))
+		}
+	}
+	return sb.String(), nil
+}
+
+func showBurnRate(chatID int64) {
+	text, err := burnRateText(appClock.Now())
+	if err != nil {
+		sendMessage(chatID, "Failed to compute the burn rate.")
+		reportError("computing the burn rate", err)
+		return
+	}
+	sendMessage(chatID, text)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+	CALENDAR FEED (/calendar)
+
+	Upcoming bills, subscription renewals, recurring transactions and the
+	deadlines of savings goals as an iCalendar file, for a phone calendar:
+
+	/calendar                              the .ics file, to import once
+	GET /api/calendar.ics?token=ayk_...    the same as a feed the calendar
+	                                       refreshes by itself
+
+	Calendar apps cannot send headers, so this route alone also takes the
+	token in the URL: give it a read-only token of its own (/apitoken new
+	calendar) and revoke that one if the URL leaks.
+
+	Bills and subscriptions are listed for the next 12 months with an alert
+	as many days before as their reminders; recurring transactions, the
+	ones the forecast detects (forecast.go), for the next 3 months. Every
+	event is an all-day event with a UID that stays the same from one
+	refresh to the next.
+*/
+
+const (
+	calendarMonths          = 12
+	calendarRecurringMonths = 3
+	calendarFeedPath        = "/api/calendar.ics"
+)
+
+// calendarEvent is an all-day event of the feed.
+type calendarEvent struct {
+	UID         string
+	Date        time.Time
+	Summary     string
+	Description string
+	AlarmDays   int // days before the event to alert, none when 0
+}
+
+// calendarEvents gathers the events from now on.
+func calendarEvents(now time.Time) ([]calendarEvent, error) {
+	today, _ := dayBounds(now)
+	horizon := today.AddDate(0, calendarMonths, 0)
+	var events []calendarEvent
+
+	bills, err := loadBills()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bills {
+		for due := b.NextDue; due.Before(horizon); due = dueDateIn(time.Date(due.Year(), due.Month()+1, 1, 0, 0, 0, 0, appLocation()), b.DueDay) {
+			events = append(events, calendarEvent{
+				UID:         fmt.Sprintf("bill-%d-%s", b.ID, due.Format("20060102")),
+				Date:        due,
+				Summary:     fmt.Sprintf("🧾 %s %s", b.Name, formatMoney(b.Amount)),
+				Description: fmt.Sprintf("Bill in %s, mark it paid from the reminder.", b.Category),
+				AlarmDays:   b.RemindDays,
+			})
+		}
+	}
+
+	subs, err := loadSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subs {
+		for r := s.NextRenewal; r.Before(horizon); r = nextRenewalAfter(r, s.Cycle) {
+			events = append(events, calendarEvent{
+				UID:         fmt.Sprintf("subscription-%d-%s", s.ID, r.Format("20060102")),
+				Date:        r,
+				Summary:     fmt.Sprintf("🔁 %s renews %s", s.Name, formatMoney(s.Amount)),
+				Description: fmt.Sprintf("Renews %s in %s, charged automatically.", s.Cycle, s.Category),
+				AlarmDays:   s.AlertDays,
+			})
+		}
+	}
+
+	recurring, err := calendarRecurring(now)
+	if err != nil {
+		return nil, err
+	}
+	events = append(events, recurring...)
+
+	goals, err := loadSavingsGoals()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range goals {
+		deadline, err := time.ParseInLocation(dateLayout, g.Deadline.String, appLocation())
+		if !g.Deadline.Valid || err != nil || deadline.Before(today) {
+			continue
+		}
+		events = append(events, calendarEvent{
+			UID:         fmt.Sprintf("goal-%d", g.ID),
+			Date:        deadline,
+			Summary:     "🎯 Goal deadline: " + g.Name,
+			Description: fmt.Sprintf("%s saved of %s.", formatMoney(g.Saved), formatMoney(g.Target)),
+			AlarmDays:   7,
+		})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Date.Before(events[j].Date) })
+	return events, nil
+}
+
+// calendarRecurring projects the detected recurring transactions over
+// the next months, leaving out this month's when they already happened.
+func calendarRecurring(now time.Time) ([]calendarEvent, error) {
+	patterns, err := detectRecurring(now)
+	if err != nil || len(patterns) == 0 {
+		return nil, err
+	}
+	monthStart, monthEnd := monthBounds(now)
+	entries, err := loadEntries(monthStart, monthEnd)
+	if err != nil {
+		return nil, err
+	}
+	seenThisMonth := make(map[string]bool)
+	for _, e := range entries {
+		seenThisMonth[entryKey(e)] = true
+	}
+	// bills and subscriptions have their own events
+	known := knownRecurringKeys()
+	today, _ := dayBounds(now)
+
+	var events []calendarEvent
+	for _, p := range patterns {
+		if known[p.key()] {
+			continue
+		}
+		label := p.Category
+		if p.Description != "" {
+			label += " (" + p.Description + ")"
+		}
+		h := fnv.New32a()
+		h.Write([]byte(p.key()))
+		for m := 0; m < calendarRecurringMonths; m++ {
+			date := dueDateIn(monthStart.AddDate(0, m, 0), p.Day)
+			if m == 0 && (seenThisMonth[p.key()] || date.Before(today)) {
+				continue
+			}
+			events = append(events, calendarEvent{
+				UID:         fmt.Sprintf("recurring-%08x-%s", h.Sum32(), date.Format("20060102")),
+				Date:        date,
+				Summary:     fmt.Sprintf("%s%s ~%s", typeIcons()[p.Type], label, formatMoney(p.Amount)),
+				Description: fmt.Sprintf("Expected %s: it happened once a month in the last months.", p.Type),
+			})
+		}
+	}
+	return events, nil
+}
+
+// writeCalendar writes the events from now on as an iCalendar file.
+func writeCalendar(w io.Writer, now time.Time) error {
+	events, err := calendarEvents(now)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	line := func(format string, args ...interface{}) {
+		bw.WriteString(foldICSLine(fmt.Sprintf(format, args...)) + "\r\n")
+	}
+	stamp := now.UTC().Format("20060102T150405Z")
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//ayunda//calendar//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:Ayunda")
+	line("X-PUBLISHED-TTL:PT6H")
+	for _, e := range events {
+		line("BEGIN:VEVENT")
+		line("UID:%s@ayunda", e.UID)
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART;VALUE=DATE:%s", e.Date.Format("20060102"))
+		line("DTEND;VALUE=DATE:%s", e.Date.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:%s", escapeICSText(e.Summary))
+		line("DESCRIPTION:%s", escapeICSText(e.Description))
+		line("TRANSP:TRANSPARENT")
+		if e.AlarmDays > 0 {
+			line("BEGIN:VALARM")
+			line("ACTION:DISPLAY")
+			line("TRIGGER:-P%dD", e.AlarmDays)
+			line("DESCRIPTION:%s", escapeICSText(e.Summary))
+			line("END:VALARM")
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return bw.Flush()
+}
+
+// escapeICSText escapes a TEXT value (RFC 5545, 3.3.11).
+func escapeICSText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// foldICSLine folds a content line at 75 bytes, without splitting a
+// UTF-8 character.
+func foldICSLine(s string) string {
+	var sb strings.Builder
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		sb.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74 // the leading space counts
+	}
+	sb.WriteString(s)
+	return sb.String()
+}
+
+// apiCalendar serves the feed.
+func apiCalendar(w http.ResponseWriter, r *http.Request) {
+	var sb strings.Builder
+	if err := writeCalendar(&sb, appClock.Now()); err != nil {
+		apiInternalError(w, "building the calendar feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="ayunda.ics"`)
+	io.WriteString(w, sb.String())
+}
+
+func handleCalendarCommand(chatID int64) {
+	caption := "Open it to add the upcoming bills, renewals, recurring transactions and goal deadlines to your calendar."
+	if apiConfigured() {
+		caption += fmt.Sprintf("\n\nTo keep them up to date, subscribe to http://%s%s?token=<token> instead, with a read-only token of its own from /apitoken.", currentConfig().APIListen, calendarFeedPath)
+	}
+	file := exportFile{pattern: "ayunda-*.ics", write: func(w io.Writer) error { return writeCalendar(w, appClock.Now()) }}
+	if err := sendExportFile(chatID, file, caption); err != nil {
+		sendMessage(chatID, "Failed to export the calendar.")
+		reportError("exporting the calendar", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+/*
+	CATEGORY CACHE
+
+	The category names are read on almost every screen (keyboards, quick
+	add, AI prompts, /ask) but only change when something writes to the
+	categories table, so they are kept in memory. The cache is the single
+	source of truth for the rest of the code: read it with getCategories,
+	and call refreshCategories after any write to the table, once the
+	write is committed. Reading it never touches the database, so it is
+	safe while rows or a transaction are open on the single connection.
+
+	Archived categories (categoryinfo.go) stay in the cache: they are still
+	found by name and shown in the reports. Keyboards and pickers list
+	activeCategories instead, which leaves them out.
+
+	The scheduler, the outbox and the update loop all read it, hence the
+	lock.
+*/
+
+type categoryCache struct {
+	mu       sync.RWMutex
+	names    []string
+	archived map[string]bool
+}
+
+var categoriesCache categoryCache
+
+// get returns a copy of the cached names, sorted.
+func (c *categoryCache) get() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.names...)
+}
+
+// active returns a copy of the cached names that are not archived, sorted.
+func (c *categoryCache) active() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var names []string
+	for _, name := range c.names {
+		if !c.archived[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// getArchived returns the names of the archived categories, sorted.
+func (c *categoryCache) getArchived() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var names []string
+	for _, name := range c.names {
+		if c.archived[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isArchived reports whether the category named name is archived.
+func (c *categoryCache) isArchived(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.archived[name]
+}
+
+// set replaces the cached names, archived ones included.
+func (c *categoryCache) set(names []string, archived []string) {
+	c.mu.Lock()
+	c.names = append([]string(nil), names...)
+	c.archived = make(map[string]bool, len(archived))
+	for _, name := range archived {
+		c.archived[name] = true
+	}
+	c.mu.Unlock()
+}
+
+// load reads the names from the store into the cache.
+func (c *categoryCache) load() error {
+	names, err := store.Categories()
+	if err != nil {
+		return err
+	}
+	archived, err := archivedCategories()
+	if err != nil {
+		return err
+	}
+	c.set(names, archived)
+	return nil
+}
+
+// getCategories returns all the category names, archived ones included,
+// sorted: for finding a category by name and for the reports.
+func getCategories() []string {
+	return categoriesCache.get()
+}
+
+// activeCategories returns the names of the categories that are not
+// archived, sorted: for the keyboards and pickers of new entries.
+func activeCategories() []string {
+	return categoriesCache.active()
+}
+
+// categoryArchived reports whether the category named name is archived.
+func categoryArchived(name string) bool {
+	return categoriesCache.isArchived(name)
+}
+
+// refreshCategories reloads the cache after the categories table changed.
+// On failure the previous names stay cached.
+func refreshCategories() {
+	if err := categoriesCache.load(); err != nil {
+		log.Printf("Failed to reload categories: %v", err)
+	}
+}
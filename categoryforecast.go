@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+/*
+	CATEGORY FORECAST (/forecast <category>)
+
+	Predicts the monthly total of one category for this month and the two
+	after, from up to categoryForecastHistory complete months in
+	monthly_aggregates:
+
+	- with a year or more of history, each calendar month gets a seasonal
+	  factor, its average over the overall average (kept between 0.5 and
+	  2), so December can be predicted higher than February;
+	- the level is the average of the last categoryForecastWindow months
+	  with the seasonality taken out, and a prediction is the level times
+	  the factor of its month;
+	- the band is one standard deviation of the seasonally adjusted months,
+	  widening with the square root of how far ahead the month is.
+
+	The history and the prediction are drawn by
+	src/g_category_forecast_chart.py.
+*/
+
+const (
+	categoryForecastScript  = "src/g_category_forecast_chart.py"
+	categoryForecastHistory = 24
+	categoryForecastWindow  = 3
+	categoryForecastMonths  = 3
+	categoryForecastMinData = 3
+)
+
+// categoryForecastChart is the input of the category forecast script.
+type categoryForecastChart struct {
+	Title    string    `json:"title"`
+	Months   []string  `json:"months"`
+	Actual   []float64 `json:"actual"`   // one per history month
+	Forecast []float64 `json:"forecast"` // one per forecast month, after the history
+	Low      []float64 `json:"low"`
+	High     []float64 `json:"high"`
+}
+
+type monthForecast struct {
+	Month     time.Time
+	Value     float64
+	Low, High float64
+}
+
+// categoryHistory returns the monthly totals of category for the complete
+// months before now's month, oldest first, starting at its first month.
+func categoryHistory(category string, now time.Time) ([]time.Time, []float64, error) {
+	thisMonth, _ := monthBounds(now)
+	from := thisMonth.AddDate(0, -categoryForecastHistory, 0)
+	rows, err := db.Query("SELECT month, SUM(total) FROM monthly_aggregates WHERE category = ? AND month >= ? AND month < ? AND "+ledgerScope()+" GROUP BY month",
+		category, from.Format("2006-01"), thisMonth.Format("2006-01"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	totals := make(map[string]float64)
+	for rows.Next() {
+		var month string
+		var total Money
+		if err := rows.Scan(&month, &total); err != nil {
+			return nil, nil, err
+		}
+		totals[month] = total.Float()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var months []time.Time
+	var values []float64
+	for m := from; m.Before(thisMonth); m = m.AddDate(0, 1, 0) {
+		total, ok := totals[m.Format("2006-01")]
+		if !ok && len(months) == 0 {
+			continue // before the category was first used
+		}
+		months = append(months, m)
+		values = append(values, total)
+	}
+	return months, values, nil
+}
+
+// seasonalFactors returns a factor per calendar month (index 1 to 12), all
+// 1 with less than a year of history.
+func seasonalFactors(months []time.Time, values []float64) [13]float64 {
+	var factors [13]float64
+	for i := range factors {
+		factors[i] = 1
+	}
+	overall := mean(values)
+	if len(values) < 12 || overall <= 0 {
+		return factors
+	}
+	var sums [13]float64
+	var counts [13]int
+	for i, m := range months {
+		sums[m.Month()] += values[i]
+		counts[m.Month()]++
+	}
+	for m := 1; m <= 12; m++ {
+		if counts[m] > 0 {
+			factors[m] = math.Max(0.5, math.Min(2, sums[m]/float64(counts[m])/overall))
+		}
+	}
+	return factors
+}
+
+// forecastCategory predicts the next categoryForecastMonths months after
+// the history.
+func forecastCategory(months []time.Time, values []float64) []monthForecast {
+	factors := seasonalFactors(months, values)
+	adjusted := make([]float64, len(values))
+	for i, m := range months {
+		adjusted[i] = values[i] / factors[m.Month()]
+	}
+	window := adjusted
+	if len(window) > categoryForecastWindow {
+		window = window[len(window)-categoryForecastWindow:]
+	}
+	level := mean(window)
+	recent := adjusted
+	if len(recent) > 12 {
+		recent = recent[len(recent)-12:]
+	}
+	spread := stddev(recent)
+
+	next := months[len(months)-1]
+	var forecasts []monthForecast
+	for h := 1; h <= categoryForecastMonths; h++ {
+		m := next.AddDate(0, h, 0)
+		f := factors[m.Month()]
+		band := spread * f * math.Sqrt(float64(h))
+		forecasts = append(forecasts, monthForecast{
+			Month: m,
+			Value: level * f,
+			Low:   math.Max(0, level*f-band),
+			High:  level*f + band,
+		})
+	}
+	return forecasts
+}
+
+func showCategoryForecast(chatID int64, name string) {
+	category, ok := findCategory(name)
+	if !ok {
+		sendMessage(chatID, fmt.Sprintf("Unknown category %q. Usage: /forecast for the cash flow, /forecast <category> for a category.", name))
+		return
+	}
+	now := appClock.Now()
+	months, values, err := categoryHistory(category, now)
+	if err != nil {
+		sendMessage(chatID, "Failed to build the forecast.")
+		reportError("loading the history of "+category, err)
+		return
+	}
+	if len(values) < categoryForecastMinData {
+		sendMessage(chatID, fmt.Sprintf("%s needs at least %d complete months of history for a forecast.", category, categoryForecastMinData))
+		return
+	}
+	forecasts := forecastCategory(months, values)
+
+	seasonal := ""
+	if len(values) >= 12 {
+		seasonal = ", adjusted for the season"
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📈 %s forecast\nFrom %d month(s) of history%s\n\n", category, len(values), seasonal))
+	chart := categoryForecastChart{Title: fmt.Sprintf("%s, monthly total and forecast", category)}
+	for i, m := range months {
+		chart.Months = append(chart.Months, m.Format("Jan 06"))
+		chart.Actual = append(chart.Actual, values[i])
+	}
+	for _, f := range forecasts {
+		chart.Months = append(chart.Months, f.Month.Format("Jan 06"))
+		chart.Forecast = append(chart.Forecast, f.Value)
+		chart.Low = append(chart.Low, f.Low)
+		chart.High = append(chart.High, f.High)
+		sb.WriteString(fmt.Sprintf("• %s: %s (%s to %s)\n", f.Month.Format("January 2006"), formatMoney(moneyFromFloat(f.Value)), formatMoney(moneyFromFloat(f.Low)), formatMoney(moneyFromFloat(f.High))))
+	}
+	last := len(values) - 1
+	sb.WriteString(fmt.Sprintf("\nLast month (%s): %s", months[last].Format("January 2006"), formatMoney(moneyFromFloat(values[last]))))
+	sendMessage(chatID, sb.String())
+	sendChart(chatID, categoryForecastScript, chart, fmt.Sprintf("📈 %s forecast", category))
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+/*
+	CATEGORY NOTES AND ARCHIVE (/categories)
+
+	Each category can carry a note saying what belongs in it, so that
+	everyone logging to a shared ledger files things the same way:
+
+	/categories                                 every category with its note
+	/categories Needs                           one category in detail
+	/categories note Needs toiletries, household items
+	/categories note Needs off                  remove the note
+
+	The detail view adds this month's spending, the budget and when the
+	category was last used. Notes travel with the categories in /export_all.
+
+	A category no longer used can be archived rather than deleted:
+
+	/categories archive Laundry
+	/categories archived                        review the archived ones
+	/categories unarchive Laundry
+
+	An archived category no longer shows in the /add and /edit keyboards
+	nor in the other pickers (activeCategories in categories.go), while it
+	is still found by name, its transactions, budgets and rules stay as
+	they are and the reports still show it.
+*/
+
+const (
+	categoriesUsage  = "Usage:\n/categories - list the categories\n/categories <name> - details of a category\n/categories note <name> <text>|off - describe what belongs in a category\n/categories archive|unarchive <name> - hide a category from the keyboards, or bring it back\n/categories archived - list the archived categories"
+	maxCategoryNote  = 200
+	categoryNoteCrop = 60
+)
+
+// categoryNotes returns the note of every category that has one.
+func categoryNotes() (map[string]string, error) {
+	rows, err := db.Query("SELECT name, note FROM categories WHERE note IS NOT NULL AND note != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notes := make(map[string]string)
+	for rows.Next() {
+		var name, note string
+		if err := rows.Scan(&name, &note); err != nil {
+			return nil, err
+		}
+		notes[name] = note
+	}
+	return notes, rows.Err()
+}
+
+// archivedCategories returns the names of the archived categories.
+func archivedCategories() ([]string, error) {
+	return queryStrings("SELECT name FROM categories WHERE archived = 1 ORDER BY name")
+}
+
+// splitCategoryName finds the category the fields start with, archived or
+// not, trying the longest names first since they may contain spaces, and
+// returns it with the fields left over.
+func splitCategoryName(fields []string) (string, []string, bool) {
+	for i := len(fields); i > 0; i-- {
+		if name, ok := findCategory(strings.Join(fields[:i], " ")); ok {
+			return name, fields[i:], true
+		}
+	}
+	return "", fields, false
+}
+
+func handleCategoriesCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		showCategories(chatID)
+		return
+	}
+	switch {
+	case strings.EqualFold(fields[0], "note") && len(fields) > 1:
+		setCategoryNote(chatID, fields[1:])
+		return
+	case strings.EqualFold(fields[0], "archived") && len(fields) == 1:
+		showArchivedCategories(chatID)
+		return
+	case (strings.EqualFold(fields[0], "archive") || strings.EqualFold(fields[0], "unarchive")) && len(fields) > 1:
+		archiveCategory(chatID, fields[1:], strings.EqualFold(fields[0], "archive"))
+		return
+	}
+	name, rest, ok := splitCategoryName(fields)
+	if !ok || len(rest) > 0 {
+		sendMessage(chatID, fmt.Sprintf("No category named %q.\n\n%s", strings.Join(fields, " "), categoriesUsage))
+		return
+	}
+	showCategoryDetail(chatID, name)
+}
+
+func showCategories(chatID int64) {
+	notes, err := categoryNotes()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the categories.")
+		reportError("loading category notes", err)
+		return
+	}
+	lines := []string{bold("📂 Categories:")}
+	for _, name := range activeCategories() {
+		line := "• " + bold(name)
+		if note := notes[name]; note != "" {
+			if len([]rune(note)) > categoryNoteCrop {
+				note = string([]rune(note)[:categoryNoteCrop-1]) + "…"
+			}
+			line += " - " + escapeHTML(note)
+		}
+		lines = append(lines, line)
+	}
+	if archived := categoriesCache.getArchived(); len(archived) > 0 {
+		lines = append(lines, italic(fmt.Sprintf("and %d archived: /categories archived", len(archived))))
+	}
+	lines = append(lines, "", escapeHTML(categoriesUsage))
+	sendHTML(chatID, strings.Join(lines, "\n"))
+}
+
+func showCategoryDetail(chatID int64, name string) {
+	var note sql.NullString
+	var archived bool
+	if err := db.QueryRow("SELECT note, archived FROM categories WHERE name = ?", name).Scan(&note, &archived); err != nil {
+		sendMessage(chatID, "Failed to load the category.")
+		reportError("loading a category", err)
+		return
+	}
+	now := appClock.Now()
+	monthStart, monthEnd := monthBounds(now)
+	var count int
+	var spent Money
+	var lastUsed sql.NullString
+	err := db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(CASE WHEN type = 'expense' AND created_at >= ? AND created_at < ? THEN `+lineTotal+` END), 0), MAX(created_at)
+		FROM transactions WHERE category = ? AND `+ledgerScope(),
+		monthStart.Format(dbTimeLayout), monthEnd.Format(dbTimeLayout), name).Scan(&count, &spent, &lastUsed)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the category.")
+		reportError("summing a category", err)
+		return
+	}
+
+	lines := []string{bold("📂 " + name)}
+	if archived {
+		lines[0] += " " + italic("(archived)")
+	}
+	if note.String != "" {
+		lines = append(lines, "📝 "+escapeHTML(note.String))
+	} else {
+		lines = append(lines, italic(fmt.Sprintf("No note yet: /categories note %s <what belongs here>", name)))
+	}
+	lines = append(lines, "", fmt.Sprintf("This month: %s spent", moneyHTML(spent)))
+	if status, err := categoryBudgetStatus(name, now); err != nil {
+		reportError("computing the budget status", err)
+	} else if status != "" {
+		lines = append(lines, escapeHTML(status))
+	}
+	used := "never used"
+	if lastUsed.Valid {
+		used = "last used " + formatCreatedAt(lastUsed.String)
+	}
+	lines = append(lines, fmt.Sprintf("%d transaction(s), %s", count, used))
+	sendHTML(chatID, strings.Join(lines, "\n"))
+}
+
+func setCategoryNote(chatID int64, fields []string) {
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+	name, rest, ok := splitCategoryName(fields)
+	if !ok {
+		sendMessage(chatID, fmt.Sprintf("No category named %q.\n\n%s", strings.Join(fields, " "), categoriesUsage))
+		return
+	}
+	text := strings.Join(rest, " ")
+	if text == "" {
+		sendMessage(chatID, categoriesUsage)
+		return
+	}
+	var note sql.NullString
+	if !strings.EqualFold(text, "off") {
+		if len([]rune(text)) > maxCategoryNote {
+			sendMessage(chatID, fmt.Sprintf("Keep the note under %d characters.", maxCategoryNote))
+			return
+		}
+		note = sql.NullString{String: text, Valid: true}
+	}
+	if _, err := db.Exec("UPDATE categories SET note = ? WHERE name = ?", note, name); err != nil {
+		sendMessage(chatID, "Failed to save the note.")
+		reportError("saving a category note", err)
+		return
+	}
+	if !note.Valid {
+		sendMessage(chatID, fmt.Sprintf("Note of %s removed.", name))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("📝 %s: %s", name, text))
+}
+
+func showArchivedCategories(chatID int64) {
+	rows, err := db.Query(`SELECT c.name, COUNT(t.id), MAX(t.created_at) FROM categories c
+		LEFT JOIN transactions t ON t.category = c.name AND t.` + ledgerScope() + `
+		WHERE c.archived = 1 GROUP BY c.name ORDER BY c.name`)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the archived categories.")
+		reportError("loading the archived categories", err)
+		return
+	}
+	defer rows.Close()
+	lines := []string{"🗄 Archived categories:"}
+	for rows.Next() {
+		var name string
+		var count int
+		var lastUsed sql.NullString
+		if err := rows.Scan(&name, &count, &lastUsed); err != nil {
+			rows.Close()
+			sendMessage(chatID, "Failed to load the archived categories.")
+			reportError("loading the archived categories", err)
+			return
+		}
+		line := fmt.Sprintf("• %s, %d transaction(s)", name, count)
+		if lastUsed.Valid {
+			line += ", last used " + formatCreatedAt(lastUsed.String)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		sendMessage(chatID, "Failed to load the archived categories.")
+		reportError("loading the archived categories", err)
+		return
+	}
+	rows.Close()
+	if len(lines) == 1 {
+		sendMessage(chatID, "No archived categories. Hide one from the keyboards with /categories archive <name>.")
+		return
+	}
+	lines = append(lines, "", "Bring one back with /categories unarchive <name>.")
+	sendMessage(chatID, strings.Join(lines, "\n"))
+}
+
+// archiveCategory archives or brings back the category named by fields.
+func archiveCategory(chatID int64, fields []string, archive bool) {
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+	name, rest, ok := splitCategoryName(fields)
+	if !ok || len(rest) > 0 {
+		sendMessage(chatID, fmt.Sprintf("No category named %q.\n\n%s", strings.Join(fields, " "), categoriesUsage))
+		return
+	}
+	active := !categoryArchived(name)
+	switch {
+	case archive && !active:
+		sendMessage(chatID, fmt.Sprintf("%s is already archived.", name))
+		return
+	case !archive && active:
+		sendMessage(chatID, fmt.Sprintf("%s is not archived.", name))
+		return
+	case archive && len(activeCategories()) == 1:
+		sendMessage(chatID, fmt.Sprintf("%s is the last category in use, it cannot be archived.", name))
+		return
+	}
+	if _, err := db.Exec("UPDATE categories SET archived = ? WHERE name = ?", archive, name); err != nil {
+		sendMessage(chatID, "Failed to update the category.")
+		reportError("archiving a category", err)
+		return
+	}
+	refreshCategories()
+	if archive {
+		sendMessage(chatID, fmt.Sprintf("🗄 %s archived: it no longer shows in the keyboards, and its transactions stay in the reports. Bring it back with /categories unarchive %s.", name, name))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("%s is back in the keyboards.", name))
+}
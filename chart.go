@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	CHARTS
+
+	Images are drawn by the Python scripts in src/ with matplotlib. The bot
+	queries the data itself (so the clock, the archive and an encrypted
+	database all apply) and hands it to the script as JSON on stdin; the
+	script writes a PNG to the path given as its argument, which is then
+	sent with the messenger.
+
+	The look of the charts follows the chart theme, passed to the scripts
+	as JSON in the CHART_THEME environment variable and applied by
+	src/chart_theme.py: a light or dark background (dark matches
+	Telegram's night mode and stays legible when forwarded), a palette for
+	the series, and the label font. The theme comes from /charts, else
+	from chart_theme, chart_palette and chart_font in the [display]
+	section of the config file:
+
+		/charts dark
+		/charts palette colorblind       (or #4A90D9,#F5A623,...)
+		/charts font DejaVu Serif        (default for matplotlib's)
+		/charts reset                    back to the config file
+
+	A palette is named or a list of #RRGGBB colors; "default" keeps each
+	chart's own colors. A font that is not installed falls back to
+	matplotlib's default.
+*/
+
+const chartTimeout = time.Minute
+
+// chartTheme is how the scripts draw, as they read it from CHART_THEME.
+type chartTheme struct {
+	Background string   `json:"background"` // "light" or "dark"
+	Palette    []string `json:"palette"`    // series colors, the chart's own when empty
+	Font       string   `json:"font"`       // label font family, matplotlib's default when empty
+}
+
+// chartBackgrounds are the backgrounds a theme can have.
+var chartBackgrounds = map[string]bool{"light": true, "dark": true}
+
+// chartPalettes are the named palettes.
+var chartPalettes = map[string][]string{
+	"default":    nil,
+	"pastel":     {"#FFB3BA", "#FFDFBA", "#FFFFBA", "#BAFFC9", "#BAE1FF", "#D7BAFF", "#FFC6E5", "#C6FFF3"},
+	"vivid":      {"#4A90D9", "#F5A623", "#7ED321", "#D0021B", "#9013FE", "#50E3C2", "#F8E71C", "#B8E986"},
+	"colorblind": {"#0072B2", "#E69F00", "#009E73", "#D55E00", "#CC79A7", "#56B4E9", "#F0E442", "#999999"},
+}
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// parseChartPalette reads a palette name or a comma separated list of
+// #RRGGBB colors.
+func parseChartPalette(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if colors, ok := chartPalettes[strings.ToLower(s)]; ok {
+		return colors, nil
+	}
+	var colors []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if !hexColorPattern.MatchString(c) {
+			return nil, fmt.Errorf("%q is neither a palette (%s) nor a list of #RRGGBB colors", s, strings.Join(chartPaletteNames(), ", "))
+		}
+		colors = append(colors, strings.ToUpper(c))
+	}
+	return colors, nil
+}
+
+func chartPaletteNames() []string {
+	names := make([]string, 0, len(chartPalettes))
+	for name := range chartPalettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// chartThemeSettings returns the background, palette and font of the
+// chart theme as set: from /charts, else from the config file.
+func chartThemeSettings() (background, palette, font string) {
+	cfg := currentConfig()
+	if cfg != nil {
+		background, palette, font = cfg.ChartTheme, cfg.ChartPalette, cfg.ChartFont
+	}
+	background = getSetting("chart_theme", background)
+	palette = getSetting("chart_palette", palette)
+	font = getSetting("chart_font", font)
+	if background == "" {
+		background = "light"
+	}
+	if palette == "" {
+		palette = "default"
+	}
+	return background, palette, font
+}
+
+// currentChartTheme returns the theme the charts are drawn with.
+func currentChartTheme() chartTheme {
+	background, palette, font := chartThemeSettings()
+	theme := chartTheme{Background: background, Font: font}
+	if !chartBackgrounds[background] {
+		theme.Background = "light"
+	}
+	// the settings are checked when saved, so a bad palette can only come
+	// from an edited database; the chart's own colors are used then
+	if colors, err := parseChartPalette(palette); err == nil {
+		theme.Palette = colors
+	}
+	return theme
+}
+
+// renderChart runs script with data and returns the path of the PNG it
+// wrote. The caller removes the file.
+func renderChart(script string, data interface{}) (string, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	theme, err := json.Marshal(currentChartTheme())
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dataSubdir("exports"), "chart-*.png")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), chartTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "python3", script, path)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), "CHART_THEME="+string(theme))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return path, nil
+}
+
+// sendChart renders a chart and sends it to chatID.
+func sendChart(chatID int64, script string, data interface{}, caption string) {
+	path, err := renderChart(script, data)
+	if err != nil {
+		sendMessage(chatID, "Failed to draw the chart.")
+		reportError("running "+script, err)
+		return
+	}
+	defer os.Remove(path)
+	if err := messenger.SendFile(chatID, path, caption); err != nil {
+		reportError("sending a chart", err)
+	}
+}
+
+const chartsUsage = `Usage:
+/charts - show the chart theme
+/charts light or /charts dark - the background
+/charts palette <name or #RRGGBB,...> - the series colors
+/charts font <family> or /charts font default - the label font
+/charts reset - back to the config file`
+
+// handleChartsCommand implements /charts.
+func handleChartsCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		background, palette, font := chartThemeSettings()
+		if font == "" {
+			font = "default"
+		}
+		sendMessage(chatID, fmt.Sprintf("🎨 Chart theme\n\nBackground: %s\nPalette: %s\nFont: %s\n\nPalettes: %s, or a list of #RRGGBB colors.\n\n%s",
+			background, palette, font, strings.Join(chartPaletteNames(), ", "), chartsUsage))
+		return
+	}
+	key, value := "", ""
+	switch sub := strings.ToLower(fields[0]); {
+	case chartBackgrounds[sub] && len(fields) == 1:
+		key, value = "chart_theme", sub
+	case sub == "palette" && len(fields) > 1:
+		value = strings.Join(fields[1:], "")
+		if _, err := parseChartPalette(value); err != nil {
+			sendMessage(chatID, "Unknown palette: "+err.Error())
+			return
+		}
+		if _, named := chartPalettes[strings.ToLower(value)]; named {
+			value = strings.ToLower(value)
+		}
+		key = "chart_palette"
+	case sub == "font" && len(fields) > 1:
+		key, value = "chart_font", strings.Join(fields[1:], " ")
+		if strings.EqualFold(value, "default") {
+			value = ""
+		}
+	case sub == "reset" && len(fields) == 1:
+		if _, err := db.Exec("DELETE FROM settings WHERE key IN ('chart_theme', 'chart_palette', 'chart_font')"); err != nil {
+			sendMessage(chatID, "Failed to save the setting.")
+			reportError("resetting the chart theme", err)
+			return
+		}
+		sendMessage(chatID, "🎨 The charts follow the config file again.")
+		return
+	default:
+		sendMessage(chatID, chartsUsage)
+		return
+	}
+	if err := setSetting(key, value); err != nil {
+		sendMessage(chatID, "Failed to save the setting.")
+		reportError("setting the chart theme", err)
+		return
+	}
+	background, palette, font := chartThemeSettings()
+	if font == "" {
+		font = "default"
+	}
+	sendMessage(chatID, fmt.Sprintf("🎨 Charts are now drawn on a %s background, with the %s palette and the %s font.", background, palette, font))
+}
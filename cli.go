@@ -0,0 +1,319 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+	HEADLESS SUBCOMMANDS
+
+	ayunda [global flags] <command> [flags]
+
+	"serve" (the default) runs the bot; the other commands work directly on
+	the database so entries and reports can be scripted from cron or a shell.
+	With --dry-run, add and list work on an in-memory copy of the records
+	(storage.go), e.g. to check what an add would do to a budget.
+*/
+
+type subcommand struct {
+	summary string
+	run     func(args []string) error
+}
+
+var subcommands = map[string]subcommand{
+	"serve":       {"Run the Telegram bot (default)", nil},
+	"add":         {"Add a transaction", cmdAdd},
+	"list":        {"List recent transactions", cmdList},
+	"export":      {"Export all transactions as CSV, Firefly III CSV or QIF", cmdExport},
+	"report":      {"Print a report: summary (default), weekly, latest or a saved one", cmdReport},
+	"aggregates":  {"Check (or -rebuild) the cached monthly totals", cmdAggregates},
+	"doctor":      {"Check the database for problems (-fix repairs them)", cmdDoctor},
+	"maintenance": {"Purge expired rows, then VACUUM and ANALYZE the database", cmdMaintenance},
+	"bundle":      {"Export everything as a JSON bundle (-restore imports one)", cmdBundle},
+	"replica":     {"Show (or -o download) the database replica in object storage", cmdReplica},
+	"seed-demo":   {"Fill an empty database with months of made-up transactions", cmdSeedDemo},
+}
+
+// dryRunCommands are the commands --dry-run works with: they only touch
+// the records through the Storage, which is then an in-memory copy.
+var dryRunCommands = map[string]bool{"add": true, "list": true}
+
+// errUsage is returned after a subcommand has printed its own usage.
+var errUsage = errors.New("invalid usage")
+
+// runSubcommand runs a headless command against the open database and
+// returns the process exit code.
+func runSubcommand(name string, args []string) int {
+	err := subcommands[name].run(args)
+	switch {
+	case err == nil, err == flag.ErrHelp:
+		return 0
+	case err == errUsage:
+		return 2
+	default:
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [command flags]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, subcommands[name].summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func newCommandFlags(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n", os.Args[0], name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func parseCommandFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// findCategory matches name case-insensitively against the known categories.
+func findCategory(name string) (string, bool) {
+	for _, c := range getCategories() {
+		if strings.EqualFold(c, name) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+func cmdAdd(args []string) error {
+	fs := newCommandFlags("add", "-type expense -category Food -amount 25000 [-desc text]")
+	typ := fs.String("type", "expense", "income or expense")
+	category := fs.String("category", "", "Category name")
+	var amount Money
+	fs.Var(&amount, "amount", "Amount (positive)")
+	quantity := fs.Float64("qty", 1, "Quantity")
+	desc := fs.String("desc", "", "Description (max 100 characters)")
+	date := fs.String("date", "", "Date as YYYY-MM-DD or \"YYYY-MM-DD HH:MM:SS\" (default now)")
+	outlier := fs.Bool("outlier", false, "Mark the transaction as an outlier")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+
+	*typ = strings.ToLower(*typ)
+	if *typ != "income" && *typ != "expense" {
+		return fmt.Errorf("invalid type %q (must be income or expense)", *typ)
+	}
+	name, ok := findCategory(*category)
+	if !ok {
+		return fmt.Errorf("unknown category %q (known: %s)", *category, strings.Join(activeCategories(), ", "))
+	}
+	if amount <= 0 {
+		return errors.New("amount must be a positive number")
+	}
+	if *quantity <= 0 {
+		return errors.New("quantity must be a positive number")
+	}
+	if len(*desc) > 100 {
+		return errors.New("description too long, keep it under 100 characters")
+	}
+
+	createdAt := appClock.Now()
+	if *date != "" {
+		var err error
+		createdAt, err = parseDateFlag(*date)
+		if err != nil {
+			return err
+		}
+	}
+	if cutoff := closedBefore(); closedAt(createdAt.Format(dbTimeLayout), cutoff) {
+		return fmt.Errorf("%s is in a closed period (through %s)", createdAt.Format(dateLayout), closedThrough(cutoff))
+	}
+
+	id, roundup, err := insertTransaction(*typ, name, *quantity, amount, *desc, createdAt, *outlier)
+	if err != nil {
+		return fmt.Errorf("save transaction: %w", err)
+	}
+	fmt.Printf("Added transaction #%d: %s %s %s\n", id, *typ, name, formatMoney(amount))
+	if *typ == "expense" {
+		if status, err := categoryBudgetStatus(name, createdAt); err == nil && status != "" {
+			fmt.Println(status)
+		}
+	}
+	if roundup != "" {
+		fmt.Println(roundup)
+	}
+	return nil
+}
+
+func parseDateFlag(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, appLocation()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD or \"YYYY-MM-DD HH:MM:SS\")", s)
+}
+
+func cmdList(args []string) error {
+	fs := newCommandFlags("list", "[-n 20] [-type expense] [-category Food] [-since YYYY-MM-DD]")
+	limit := fs.Int("n", 20, "Number of transactions to show")
+	typ := fs.String("type", "", "Only income or expense")
+	category := fs.String("category", "", "Only this category")
+	since := fs.String("since", "", "Only transactions on or after this date")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+
+	filter := TransactionFilter{Type: strings.ToLower(*typ), Category: *category, Limit: *limit}
+	if *since != "" {
+		t, err := parseDateFlag(*since)
+		if err != nil {
+			return err
+		}
+		filter.Since = t
+	}
+	transactions, err := store.Transactions(filter)
+	if err != nil {
+		return fmt.Errorf("query transactions: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDATE\tTYPE\tCATEGORY\tQTY\tAMOUNT\tDESCRIPTION")
+	for _, t := range transactions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%g\t%s\t%s\n", t.ID, t.CreatedAt.Format("2006-01-02 15:04"), t.Type, t.Category, t.Quantity, formatMoneyPlain(t.Amount), t.Description)
+	}
+	return w.Flush()
+}
+
+func cmdExport(args []string) error {
+	fs := newCommandFlags("export", "[-format csv|firefly|qif] [-o transactions.csv]")
+	output := fs.String("o", "-", "Output file, - for stdout")
+	format := fs.String("format", "csv", "csv, firefly (Firefly III Data Importer) or qif (GnuCash)")
+	fireflyConfig := fs.String("firefly-config", "", "With -format firefly, also write the Data Importer configuration here")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+	exportFormat, ok := exportFormats[*format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+	if *fireflyConfig != "" {
+		f, err := os.Create(*fireflyConfig)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := writeFireflyConfig(f); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return exportFormat.files[0].write(w)
+}
+
+func cmdReport(args []string) error {
+	kind := "summary"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		kind, args = args[0], args[1:]
+	}
+	fs := newCommandFlags("report", "[summary|weekly|latest|<saved report>]")
+	month := fs.String("month", "", "Month of the summary (YYYY-MM, default: this month)")
+	withArchive := fs.Bool("archive", false, "Include archived transactions in the summary")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+
+	if kind == "summary" {
+		t, _, err := parseSummaryArgs(*month, appClock.Now())
+		if err != nil {
+			return err
+		}
+		text, err := monthlySummaryText(t, *withArchive)
+		if err != nil {
+			return err
+		}
+		fmt.Println(htmlToPlain(text))
+		return nil
+	}
+	if kind == "weekly" {
+		text, err := lastSevenDaysText(appClock.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Println(htmlToPlain(text))
+		return nil
+	}
+	if kind == "latest" {
+		text, _, err := latestReportText(latestPageSize)
+		if err != nil {
+			return err
+		}
+		if text == "" {
+			text = "No transactions yet."
+		}
+		fmt.Println(htmlToPlain(text))
+		return nil
+	}
+	return printSavedReport(kind, fs)
+}
+
+// printSavedReport prints a report saved with /report, as CSV if that is
+// its format and as text otherwise.
+func printSavedReport(name string, fs *flag.FlagSet) error {
+	spec, err := loadSavedReport(strings.ToLower(name))
+	if err == sql.ErrNoRows {
+		fmt.Fprintf(fs.Output(), "unknown report %q\n", name)
+		fs.Usage()
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	r, err := runReport(strings.ToLower(name), spec, appClock.Now())
+	if err != nil {
+		return err
+	}
+	if spec.Format == "csv" {
+		return writeReportCSV(os.Stdout, r)
+	}
+	fmt.Println(reportText(r))
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+	CLOCK
+
+	Handlers, reports and scheduled jobs read the current time from appClock
+	instead of calling time.Now, so the date boundaries they depend on (end
+	of day, of the week, of the month) can be tried at a pinned time, e.g.
+	./ayunda --now "2026-01-31 23:59" --repl. Infrastructure such as the
+	outbox and the retry backoff keeps using the real time.
+*/
+
+// Clock tells the current time in the bot's time zone.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now().In(appLocation())
+}
+
+// offsetClock runs from a pinned starting time at the normal pace.
+type offsetClock struct {
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time {
+	return time.Now().Add(c.offset).In(appLocation())
+}
+
+var appClock Clock = systemClock{}
+
+// clockStartingAt returns a clock that reads start ("YYYY-MM-DD HH:MM" or
+// "YYYY-MM-DD", in the bot's time zone) now and advances from there.
+func clockStartingAt(start string) (Clock, error) {
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", dateLayout} {
+		if t, err := time.ParseInLocation(layout, start, appLocation()); err == nil {
+			return offsetClock{offset: time.Until(t)}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time %q, use YYYY-MM-DD HH:MM", start)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	CLOSED PERIODS (/close)
+
+	Closing a month locks every transaction dated up to its end, so history
+	that was reconciled and reported on cannot change silently:
+
+	/close              show up to when the books are closed
+	/close 2026-09      close September 2026 and everything before it
+	/close off          reopen everything (owner only)
+
+	Locked transactions cannot be edited, deleted or amended, and the bulk
+	import refuses rows dated in a closed period. The owner can still change
+	a locked transaction, after a warning, and is the only one who can move
+	the cutoff back. The cutoff is stored in the closed_before setting as
+	the first day that is still open.
+*/
+
+const closeUsage = "Usage:\n/close - show the closed period\n/close YYYY-MM - close that month and everything before it\n/close off - reopen everything (owner only)"
+
+// closedBefore returns the first open day as "2006-01-02", or "" when no
+// period is closed.
+func closedBefore() string {
+	return getSetting("closed_before", "")
+}
+
+// periodClosed tells whether a transaction created at createdAt, as read
+// from the database, is in a closed period.
+func periodClosed(createdAt string) bool {
+	return closedAt(createdAt, closedBefore())
+}
+
+// closedAt is periodClosed with the cutoff already read, for use while
+// rows are open.
+func closedAt(createdAt, cutoff string) bool {
+	if cutoff == "" {
+		return false
+	}
+	if t, err := parseCreatedAt(createdAt); err == nil {
+		createdAt = t.Format(dbTimeLayout)
+	}
+	return createdAt < cutoff
+}
+
+// closedThrough formats the last closed day of cutoff, e.g. "30 Sep 2026".
+func closedThrough(cutoff string) string {
+	t, err := time.Parse(dateLayout, cutoff)
+	if err != nil {
+		return cutoff
+	}
+	return t.AddDate(0, 0, -1).Format("2 Jan 2006")
+}
+
+// allowChange tells whether userID may change or delete transaction id,
+// created at createdAt. In a closed period other users are refused and
+// the owner is warned.
+func allowChange(chatID int64, userID int64, id int64, createdAt string) bool {
+	if !periodClosed(createdAt) {
+		return true
+	}
+	through := closedThrough(closedBefore())
+	if userID == ownerID() {
+		sendMessage(chatID, fmt.Sprintf("🔓 Transaction #%d is in a closed period (through %s). You can still change it as the owner.", id, through))
+		return true
+	}
+	sendMessage(chatID, fmt.Sprintf("🔒 Transaction #%d is in a closed period (through %s) and cannot be changed. Ask the owner to reopen it.", id, through))
+	return false
+}
+
+// handleCloseCommand implements /close.
+func handleCloseCommand(chatID int64, userID int64, args string) {
+	arg := strings.ToLower(strings.TrimSpace(args))
+	current := closedBefore()
+
+	if arg == "" {
+		if current == "" {
+			sendMessage(chatID, "No period is closed.\n\n"+closeUsage)
+			return
+		}
+		var locked int
+		if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE created_at < ?", current).Scan(&locked); err != nil {
+			sendMessage(chatID, "Failed to count the locked transactions.")
+			reportError("counting locked transactions", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("🔒 The books are closed through %s; %d transaction(s) are locked.", closedThrough(current), locked))
+		return
+	}
+
+	cutoff := ""
+	if arg != "off" {
+		month, err := time.ParseInLocation("2006-01", arg, appLocation())
+		if err != nil {
+			sendMessage(chatID, closeUsage)
+			return
+		}
+		_, end := monthBounds(month)
+		if end.After(appClock.Now()) {
+			sendMessage(chatID, fmt.Sprintf("%s has not ended yet, so it cannot be closed.", month.Format("January 2006")))
+			return
+		}
+		cutoff = end.Format(dateLayout)
+	}
+	if cutoff == current {
+		sendMessage(chatID, "Nothing changed.")
+		return
+	}
+	// moving the cutoff back reopens closed transactions
+	if cutoff < current && userID != ownerID() {
+		sendMessage(chatID, "Only the bot owner can reopen a closed period.")
+		return
+	}
+	if err := setSetting("closed_before", cutoff); err != nil {
+		sendMessage(chatID, "Failed to save the closed period.")
+		reportError("closing a period", err)
+		return
+	}
+	if cutoff == "" {
+		sendMessage(chatID, "🔓 Every period is open again.")
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("🔒 The books are closed through %s. Transactions up to then can no longer be changed.", closedThrough(cutoff)))
+}
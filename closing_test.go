@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportRefusesClosedPeriod(t *testing.T) {
+	newHarness(t)
+	if err := setSetting("closed_before", "2026-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "import.csv")
+	csv := "type,category,amount,description,created_at\n" +
+		"expense,Food,25000,december lunch,2025-12-31\n" +
+		"expense,Food,30000,january lunch,2026-01-15\n"
+	if err := os.WriteFile(path, []byte(csv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	inserted, errs := bulkInsertFromCSV(path)
+	if inserted != 1 {
+		t.Errorf("inserted %d row(s), want 1", inserted)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "row 2: 2025-12-31 is in a closed period (through 31 Dec 2025)") {
+		t.Errorf("errors are %v, want row 2 refused as closed", errs)
+	}
+	if err := expectTransaction(1, "expense", "Food", 30000, "january lunch"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCLIAddRefusesClosedPeriod(t *testing.T) {
+	newHarness(t)
+	if err := setSetting("closed_before", "2026-01-01"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := cmdAdd([]string{"-category", "Food", "-amount", "25000", "-date", "2025-12-31"})
+	if err == nil || !strings.Contains(err.Error(), "2025-12-31 is in a closed period (through 31 Dec 2025)") {
+		t.Errorf("error is %v, want the closed period refused", err)
+	}
+	if err := expectTransactionCount(0); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,806 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	_ "time/tzdata" // time zones must resolve even in minimal containers
+)
+
+/*
+	CONFIGURATION
+
+	Settings come from an optional TOML file (--config), then environment
+	variables, then command line flags, each overriding the previous one.
+	Only the subset of TOML needed here is supported: [sections], comments,
+	strings, integers, booleans and single-line arrays.
+
+	Example:
+
+		token = "123456:ABC..."
+		allowed_users = [11111111, 22222222]  # the first one is the owner
+		data_dir = "/data"                    # or [db] path alone
+		locale = "en-US"
+		currency = "IDR"                      # a ledger can have its own
+		timezone = "Asia/Jakarta"
+
+		[db]
+		path = "/var/lib/ayunda/ayunda.db"
+		key = ""
+
+		[features]
+		group_mode = true
+
+		[s3]
+		bucket = "my-backups"
+		endpoint = "https://s3.us-west-002.backblazeb2.com"
+*/
+
+type Config struct {
+	Token             string
+	AllowedUsers      []int64 // the first user is the owner
+	DBPath            string
+	DataDir           string // holds db/, backups/, exports/ and attachments/ when set
+	DBKey             string
+	Locale            string
+	Currency          string // ISO 4217 code of the amounts, "" for plain numbers
+	Timezone          string
+	Schedules         map[string]string // schedule name -> "HH:MM"
+	Features          map[string]bool
+	PriceURL          string // quote endpoint for /portfolio, {ticker} is replaced
+	FXRateURL         string // exchange rate endpoint, {from}, {to} and {date} are replaced
+	WeekStart         string // "monday" or "sunday"
+	SentryDSN         string // error tracking, off when empty
+	SentryEnv         string // environment tag of Sentry events
+	BarWidth          int    // squares in progress bars, 0 for the default
+	BarStyle          string // progress bar style, "blocks" or "emoji"
+	ChartTheme        string // chart background, "light" or "dark"
+	ChartPalette      string // palette name or #RRGGBB list of the chart series
+	ChartFont         string // label font family of the charts
+	AIURL             string // OpenAI-compatible endpoint for categorization, off when empty
+	AIKey             string // bearer token of the AI endpoint
+	AIModel           string // model name sent to the AI endpoint
+	S3Endpoint        string // S3-compatible endpoint of the off-site backups, AWS when empty
+	S3Bucket          string // off-site backups are off when empty
+	S3Region          string
+	S3Prefix          string // prepended to the object keys, e.g. "ayunda/"
+	S3AccessKey       string // from the environment only
+	S3SecretKey       string // from the environment only
+	S3Retention       int    // days the off-site backups are kept
+	S3ReplicaInterval int    // seconds between replica uploads, off when 0
+	APIListen         string // address of the HTTP API, e.g. "127.0.0.1:8080"; off when empty
+	IMAPHost          string // host:port of the e-receipt mailbox (implicit TLS), off when empty
+	IMAPUser          string
+	IMAPPassword      string // from the environment only
+	IMAPMailbox       string
+	IMAPFrom          []string // senders whose mails are receipts, every sender when empty
+	IMAPInterval      int      // seconds between checks of the mailbox
+}
+
+// knownFeatures lists the feature flags that may appear in [features],
+// with their default value.
+var knownFeatures = map[string]bool{
+	"group_mode":   true,
+	"error_alerts": true,
+	"systemd":      false,
+	"indicators":   true,
+}
+
+// knownSchedules lists the schedule names accepted in [schedules].
+var knownSchedules = map[string]bool{
+	"end_of_day":        true,
+	"bill_reminders":    true,
+	"invoice_reminders": true,
+	"subscriptions":     true,
+	"archive":           true,
+	"maintenance":       true,
+	"offsite_backup":    true,
+	"roundup_summary":   true,
+}
+
+// ConfigError collects every problem found while loading the configuration,
+// so they can all be fixed in one go.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("startup aborted, %d configuration problem(s) found:\n  - %s\nFix the settings above (config file, environment or .env) and start the bot again.",
+		len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+func (e *ConfigError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+func defaultConfig() *Config {
+	cfg := &Config{
+		Locale:       "en-US",
+		Schedules:    make(map[string]string),
+		Features:     make(map[string]bool),
+		S3Region:     "us-east-1",
+		S3Retention:  30,
+		IMAPMailbox:  "INBOX",
+		IMAPInterval: 300,
+	}
+	for name, on := range knownFeatures {
+		cfg.Features[name] = on
+	}
+	return cfg
+}
+
+// runningConfig is the configuration in use, with the owner and the time
+// zone worked out from it. The update loop, the API server and the
+// background workers all read it; startup and a reload (reload.go) replace
+// it as a whole, so no reader ever sees half of one.
+type runningConfig struct {
+	config   *Config
+	owner    int64
+	location *time.Location
+}
+
+var running atomic.Pointer[runningConfig]
+
+// setRunningConfig puts cfg in use, with owner as the owner and loc as the
+// time zone.
+func setRunningConfig(cfg *Config, owner int64, loc *time.Location) {
+	running.Store(&runningConfig{config: cfg, owner: owner, location: loc})
+}
+
+// setAppLocation changes the time zone in use, keeping the rest.
+func setAppLocation(loc *time.Location) {
+	for {
+		old := running.Load()
+		next := &runningConfig{location: loc}
+		if old != nil {
+			next.config, next.owner = old.config, old.owner
+		}
+		if running.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// currentConfig returns the configuration in use, nil before startup has
+// loaded one.
+func currentConfig() *Config {
+	if r := running.Load(); r != nil {
+		return r.config
+	}
+	return nil
+}
+
+// ownerID returns the owner: the first of the allowed users, who gets the
+// notifications and may run the admin commands.
+func ownerID() int64 {
+	if r := running.Load(); r != nil {
+		return r.owner
+	}
+	return 0
+}
+
+// appLocation returns the time zone in use.
+func appLocation() *time.Location {
+	if r := running.Load(); r != nil && r.location != nil {
+		return r.location
+	}
+	return defaultLocation
+}
+
+// isAllowedUser reports whether userID may use the bot.
+func isAllowedUser(userID int64) bool {
+	r := running.Load()
+	if r == nil {
+		return false
+	}
+	if r.config == nil {
+		return userID == r.owner
+	}
+	for _, id := range r.config.AllowedUsers {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// featureEnabled reports whether a feature flag is on in the active config.
+func featureEnabled(name string) bool {
+	cfg := currentConfig()
+	if cfg == nil {
+		return knownFeatures[name]
+	}
+	return cfg.Features[name]
+}
+
+// loadConfig builds the configuration from the file at path (if any) and the
+// environment. dataFlag overrides the database path when set. All problems
+// are collected in the returned *ConfigError so they can be reported together.
+func loadConfig(path string, dataFlag string) (*Config, *ConfigError) {
+	cfg := defaultConfig()
+	problems := &ConfigError{}
+
+	if path != "" {
+		values, err := parseTOMLFile(path)
+		if err != nil {
+			problems.add("config file %s: %v", path, err)
+		} else {
+			applyConfigValues(cfg, values, problems)
+		}
+	}
+
+	applyConfigEnv(cfg, problems)
+	if dataFlag != "" {
+		cfg.DBPath = dataFlag
+	}
+	if cfg.DataDir != "" {
+		prepareDataDir(cfg.DataDir, problems)
+		if cfg.DBPath == "" {
+			cfg.DBPath = filepath.Join(cfg.DataDir, "db", "ayunda.db")
+		}
+	}
+
+	if cfg.DBPath == "" {
+		problems.add("db.path is missing: set [db] path or data_dir in the config file, DB_PATH or DATA_DIR in the environment, or pass --data")
+	}
+	checkDBPath(cfg.DBPath, problems)
+	if cfg.SentryDSN != "" {
+		if _, _, err := parseSentryDSN(cfg.SentryDSN); err != nil {
+			problems.add("sentry.dsn: %v", err)
+		}
+	}
+	if cfg.AIURL != "" {
+		if u, err := url.Parse(cfg.AIURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems.add("ai.url %q is not an http(s) URL (e.g. \"https://api.openai.com/v1\")", cfg.AIURL)
+		}
+		if cfg.AIModel == "" {
+			problems.add("ai.model is missing: set [ai] model in the config file or AI_MODEL in the environment")
+		}
+	}
+	if cfg.S3Bucket != "" {
+		if u, err := url.Parse(cfg.S3Endpoint); cfg.S3Endpoint != "" && (err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "") {
+			problems.add("s3.endpoint %q is not an http(s) URL (e.g. \"https://s3.eu-central-1.amazonaws.com\")", cfg.S3Endpoint)
+		}
+		if cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+			problems.add("s3.bucket is set but the credentials are missing: set S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY in the environment")
+		}
+		if cfg.S3Retention < 1 {
+			problems.add("s3.retention_days must be at least 1")
+		}
+		if cfg.S3ReplicaInterval < 0 {
+			problems.add("s3.replica_interval must be a number of seconds, 0 to turn the replica off")
+		}
+	}
+	if cfg.APIListen != "" {
+		if _, port, err := net.SplitHostPort(cfg.APIListen); err != nil || port == "" {
+			problems.add("api.listen %q is not a host:port address (e.g. \"127.0.0.1:8080\")", cfg.APIListen)
+		}
+	}
+	if cfg.IMAPHost != "" {
+		if _, port, err := net.SplitHostPort(cfg.IMAPHost); err != nil || port == "" {
+			problems.add("imap.host %q is not a host:port address (e.g. \"imap.gmail.com:993\")", cfg.IMAPHost)
+		}
+		if cfg.IMAPUser == "" || cfg.IMAPPassword == "" {
+			problems.add("imap.host is set but the credentials are missing: set [imap] user and IMAP_PASSWORD in the environment")
+		}
+		if cfg.IMAPInterval < 60 {
+			problems.add("imap.interval must be at least 60 seconds")
+		}
+	}
+	if cfg.Timezone != "" {
+		if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+			problems.add("timezone %q is not a valid IANA time zone (e.g. \"Asia/Jakarta\")", cfg.Timezone)
+		}
+	}
+	if !localePattern.MatchString(cfg.Locale) {
+		problems.add("locale %q is not a valid locale (e.g. \"en-US\" or \"id-ID\")", cfg.Locale)
+	}
+	if cfg.Currency != "" && !currencyCodePattern.MatchString(cfg.Currency) {
+		problems.add("currency %q is not an ISO 4217 code (e.g. \"IDR\" or \"USD\")", cfg.Currency)
+	}
+	return cfg, problems
+}
+
+// validateServeConfig adds the checks that only matter when running the bot,
+// as opposed to maintenance tasks like --backup.
+func validateServeConfig(cfg *Config, problems *ConfigError) {
+	if cfg.Token == "" {
+		problems.add("token is missing: set token in the config file or API_TOKEN in the environment")
+	} else if !botTokenPattern.MatchString(cfg.Token) {
+		problems.add("token does not look like a Telegram bot token (expected <bot id>:<secret>, as given by @BotFather)")
+	}
+	if len(cfg.AllowedUsers) == 0 {
+		problems.add("allowed_users is missing: set allowed_users in the config file or ALLOWED_USER_ID in the environment (ask @userinfobot for your id)")
+	}
+}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}([-_][A-Za-z]{2,4})?$`)
+
+var botTokenPattern = regexp.MustCompile(`^[0-9]{5,}:[A-Za-z0-9_-]{30,}$`)
+
+// checkDBPath verifies that the database file can be created or opened for
+// writing, since SQLite only reports this on the first write.
+func checkDBPath(path string, problems *ConfigError) {
+	if path == "" || path == ":memory:" || strings.HasPrefix(path, "file:") {
+		return
+	}
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		problems.add("db.path %s: directory %s does not exist (create it or choose another path)", path, dir)
+		return
+	}
+	if !info.IsDir() {
+		problems.add("db.path %s: %s is not a directory", path, dir)
+		return
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			problems.add("db.path %s is a directory, expected a database file", path)
+			return
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			problems.add("db.path %s is not writable: %v", path, err)
+			return
+		}
+		f.Close()
+	}
+
+	// SQLite also needs to create the -wal and -shm files next to the database
+	probe, err := os.CreateTemp(dir, ".ayunda-write-test-*")
+	if err != nil {
+		problems.add("db.path %s: directory %s is not writable: %v", path, dir, err)
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+}
+
+// applyConfigValues maps parsed TOML keys onto cfg, reporting unknown keys
+// and values of the wrong type.
+func applyConfigValues(cfg *Config, values map[string]tomlValue, problems *ConfigError) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return values[keys[i]].line < values[keys[j]].line })
+
+	for _, key := range keys {
+		v := values[key]
+		switch {
+		case key == "token":
+			cfg.Token = v.stringValue(key, problems)
+		case key == "allowed_users":
+			cfg.AllowedUsers = v.intListValue(key, problems)
+		case key == "locale":
+			cfg.Locale = v.stringValue(key, problems)
+		case key == "currency":
+			cfg.Currency = strings.ToUpper(strings.TrimSpace(v.stringValue(key, problems)))
+		case key == "timezone":
+			cfg.Timezone = v.stringValue(key, problems)
+		case key == "week_start":
+			cfg.WeekStart = strings.ToLower(v.stringValue(key, problems))
+			if cfg.WeekStart != "monday" && cfg.WeekStart != "sunday" {
+				problems.add("line %d: week_start must be \"monday\" or \"sunday\"", v.line)
+			}
+		case key == "data_dir":
+			cfg.DataDir = v.stringValue(key, problems)
+		case key == "db.path":
+			cfg.DBPath = v.stringValue(key, problems)
+		case key == "db.key":
+			cfg.DBKey = v.stringValue(key, problems)
+		case key == "portfolio.price_url":
+			cfg.PriceURL = v.stringValue(key, problems)
+		case key == "fx.rate_url":
+			cfg.FXRateURL = v.stringValue(key, problems)
+		case key == "sentry.dsn":
+			cfg.SentryDSN = v.stringValue(key, problems)
+		case key == "sentry.environment":
+			cfg.SentryEnv = v.stringValue(key, problems)
+		case key == "ai.url":
+			cfg.AIURL = v.stringValue(key, problems)
+		case key == "ai.key":
+			cfg.AIKey = v.stringValue(key, problems)
+		case key == "ai.model":
+			cfg.AIModel = v.stringValue(key, problems)
+		case key == "s3.endpoint":
+			cfg.S3Endpoint = v.stringValue(key, problems)
+		case key == "s3.bucket":
+			cfg.S3Bucket = v.stringValue(key, problems)
+		case key == "s3.region":
+			cfg.S3Region = v.stringValue(key, problems)
+		case key == "s3.prefix":
+			cfg.S3Prefix = v.stringValue(key, problems)
+		case key == "s3.retention_days":
+			cfg.S3Retention = v.intValue(key, problems)
+		case key == "s3.replica_interval":
+			cfg.S3ReplicaInterval = v.intValue(key, problems)
+		case key == "api.listen":
+			cfg.APIListen = v.stringValue(key, problems)
+		case key == "imap.host":
+			cfg.IMAPHost = v.stringValue(key, problems)
+		case key == "imap.user":
+			cfg.IMAPUser = v.stringValue(key, problems)
+		case key == "imap.mailbox":
+			cfg.IMAPMailbox = v.stringValue(key, problems)
+		case key == "imap.from":
+			cfg.IMAPFrom = v.stringListValue(key, problems)
+		case key == "imap.interval":
+			cfg.IMAPInterval = v.intValue(key, problems)
+		case key == "display.bar_width":
+			cfg.BarWidth = v.intValue(key, problems)
+			if cfg.BarWidth < minBarWidth || cfg.BarWidth > maxBarWidth {
+				problems.add("line %d: display.bar_width must be between %d and %d", v.line, minBarWidth, maxBarWidth)
+			}
+		case key == "display.bar_style":
+			cfg.BarStyle = strings.ToLower(v.stringValue(key, problems))
+			if _, ok := barStyles[cfg.BarStyle]; !ok {
+				problems.add("line %d: display.bar_style must be \"blocks\" or \"emoji\"", v.line)
+			}
+		case key == "display.chart_theme":
+			cfg.ChartTheme = strings.ToLower(v.stringValue(key, problems))
+			if !chartBackgrounds[cfg.ChartTheme] {
+				problems.add("line %d: display.chart_theme must be \"light\" or \"dark\"", v.line)
+			}
+		case key == "display.chart_palette":
+			cfg.ChartPalette = v.stringValue(key, problems)
+			if _, err := parseChartPalette(cfg.ChartPalette); cfg.ChartPalette != "" && err != nil {
+				problems.add("line %d: display.chart_palette: %v", v.line, err)
+			}
+		case key == "display.chart_font":
+			cfg.ChartFont = v.stringValue(key, problems)
+		case strings.HasPrefix(key, "schedules."):
+			name := strings.TrimPrefix(key, "schedules.")
+			if !knownSchedules[name] {
+				problems.add("line %d: unknown schedule %q", v.line, name)
+				continue
+			}
+			at := v.stringValue(key, problems)
+			if _, err := time.Parse("15:04", at); at != "" && err != nil {
+				problems.add("line %d: %s must be a time of day like \"21:00\"", v.line, key)
+				continue
+			}
+			cfg.Schedules[name] = at
+		case strings.HasPrefix(key, "features."):
+			name := strings.TrimPrefix(key, "features.")
+			if _, ok := knownFeatures[name]; !ok {
+				problems.add("line %d: unknown feature flag %q", v.line, name)
+				continue
+			}
+			cfg.Features[name] = v.boolValue(key, problems)
+		default:
+			problems.add("line %d: unknown setting %q", v.line, key)
+		}
+	}
+}
+
+// applyConfigEnv lets environment variables override the config file.
+func applyConfigEnv(cfg *Config, problems *ConfigError) {
+	if v := os.Getenv("API_TOKEN"); v != "" {
+		cfg.Token = v
+	}
+	if v := os.Getenv("ALLOWED_USER_ID"); v != "" {
+		ids, err := parseUserIDList(v)
+		if err != nil {
+			problems.add("ALLOWED_USER_ID: %v", err)
+		} else {
+			cfg.AllowedUsers = ids
+		}
+	}
+	if v := os.Getenv("DATA_DIR"); v != "" {
+		cfg.DataDir = v
+	}
+	if v := os.Getenv("DB_PATH"); v != "" {
+		cfg.DBPath = v
+	}
+	if v := os.Getenv("DB_KEY"); v != "" {
+		cfg.DBKey = v
+	}
+	if v := os.Getenv("TIMEZONE"); v != "" {
+		cfg.Timezone = v
+	}
+	if v := os.Getenv("LOCALE"); v != "" {
+		cfg.Locale = v
+	}
+	if v := os.Getenv("CURRENCY"); v != "" {
+		cfg.Currency = strings.ToUpper(strings.TrimSpace(v))
+	}
+	if v := os.Getenv("WEEK_START"); v != "" {
+		cfg.WeekStart = strings.ToLower(v)
+		if cfg.WeekStart != "monday" && cfg.WeekStart != "sunday" {
+			problems.add("WEEK_START must be \"monday\" or \"sunday\"")
+		}
+	}
+	if v := os.Getenv("PRICE_API_URL"); v != "" {
+		cfg.PriceURL = v
+	}
+	if v := os.Getenv("FX_RATE_URL"); v != "" {
+		cfg.FXRateURL = v
+	}
+	if v := os.Getenv("SENTRY_DSN"); v != "" {
+		cfg.SentryDSN = v
+	}
+	if v := os.Getenv("SENTRY_ENVIRONMENT"); v != "" {
+		cfg.SentryEnv = v
+	}
+	if v := os.Getenv("AI_API_URL"); v != "" {
+		cfg.AIURL = v
+	}
+	if v := os.Getenv("AI_API_KEY"); v != "" {
+		cfg.AIKey = v
+	}
+	if v := os.Getenv("AI_MODEL"); v != "" {
+		cfg.AIModel = v
+	}
+	if v := os.Getenv("S3_ENDPOINT"); v != "" {
+		cfg.S3Endpoint = v
+	}
+	if v := os.Getenv("S3_BUCKET"); v != "" {
+		cfg.S3Bucket = v
+	}
+	if v := os.Getenv("S3_REGION"); v != "" {
+		cfg.S3Region = v
+	}
+	if v := os.Getenv("S3_PREFIX"); v != "" {
+		cfg.S3Prefix = v
+	}
+	if v := os.Getenv("S3_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			problems.add("S3_RETENTION_DAYS must be a number of days")
+		}
+		cfg.S3Retention = days
+	}
+	if v := os.Getenv("S3_REPLICA_INTERVAL"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			problems.add("S3_REPLICA_INTERVAL must be a number of seconds")
+		}
+		cfg.S3ReplicaInterval = seconds
+	}
+	if v := os.Getenv("API_LISTEN"); v != "" {
+		cfg.APIListen = v
+	}
+	if v := os.Getenv("IMAP_HOST"); v != "" {
+		cfg.IMAPHost = v
+	}
+	if v := os.Getenv("IMAP_USER"); v != "" {
+		cfg.IMAPUser = v
+	}
+	if v := os.Getenv("IMAP_MAILBOX"); v != "" {
+		cfg.IMAPMailbox = v
+	}
+	cfg.IMAPPassword = os.Getenv("IMAP_PASSWORD")
+	cfg.S3AccessKey = firstEnv("S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID")
+	cfg.S3SecretKey = firstEnv("S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY")
+}
+
+// firstEnv returns the first of the environment variables that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseUserIDList parses a comma separated list of Telegram user ids.
+func parseUserIDList(s string) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("%q is not a valid Telegram user id (expected a positive number)", part)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no user ids given")
+	}
+	return ids, nil
+}
+
+// --- Minimal TOML reader ---
+
+type tomlValue struct {
+	line  int
+	str   *string
+	num   *int64
+	flag  *bool
+	items []tomlValue
+	isArr bool
+}
+
+func (v tomlValue) stringValue(key string, problems *ConfigError) string {
+	if v.str == nil {
+		problems.add("line %d: %s must be a string", v.line, key)
+		return ""
+	}
+	return *v.str
+}
+
+func (v tomlValue) boolValue(key string, problems *ConfigError) bool {
+	if v.flag == nil {
+		problems.add("line %d: %s must be true or false", v.line, key)
+		return false
+	}
+	return *v.flag
+}
+
+func (v tomlValue) intValue(key string, problems *ConfigError) int {
+	if v.num == nil || v.isArr {
+		problems.add("line %d: %s must be an integer", v.line, key)
+		return 0
+	}
+	return int(*v.num)
+}
+
+func (v tomlValue) intListValue(key string, problems *ConfigError) []int64 {
+	items := v.items
+	if !v.isArr {
+		items = []tomlValue{v}
+	}
+	var result []int64
+	for _, item := range items {
+		if item.num == nil || *item.num <= 0 {
+			problems.add("line %d: %s must contain positive integers", v.line, key)
+			return nil
+		}
+		result = append(result, *item.num)
+	}
+	return result
+}
+
+func (v tomlValue) stringListValue(key string, problems *ConfigError) []string {
+	items := v.items
+	if !v.isArr {
+		items = []tomlValue{v}
+	}
+	var result []string
+	for _, item := range items {
+		if item.str == nil {
+			problems.add("line %d: %s must contain strings", v.line, key)
+			return nil
+		}
+		result = append(result, *item.str)
+	}
+	return result
+}
+
+var tomlKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func parseTOMLFile(path string) (map[string]tomlValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]tomlValue)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed section header", lineNo)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if !tomlKeyPattern.MatchString(section) {
+				return nil, fmt.Errorf("line %d: invalid section name %q", lineNo, section)
+			}
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !tomlKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineNo, key)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNo, key)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(line[eq+1:]), lineNo)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// stripTOMLComment removes a trailing # comment that is not inside a string.
+func stripTOMLComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case '#':
+			if !inString {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+func parseTOMLValue(raw string, lineNo int) (tomlValue, error) {
+	v := tomlValue{line: lineNo}
+	switch {
+	case raw == "":
+		return v, fmt.Errorf("line %d: missing value", lineNo)
+	case strings.HasPrefix(raw, "\""):
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return v, fmt.Errorf("line %d: invalid string %s", lineNo, raw)
+		}
+		v.str = &s
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return v, fmt.Errorf("line %d: invalid string %s", lineNo, raw)
+		}
+		s := raw[1 : len(raw)-1]
+		v.str = &s
+	case raw == "true" || raw == "false":
+		b := raw == "true"
+		v.flag = &b
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return v, fmt.Errorf("line %d: arrays must be on a single line", lineNo)
+		}
+		v.isArr = true
+		inner := strings.TrimSpace(raw[1 : len(raw)-1])
+		if inner == "" {
+			return v, nil
+		}
+		for _, part := range strings.Split(inner, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue // trailing comma
+			}
+			item, err := parseTOMLValue(part, lineNo)
+			if err != nil {
+				return v, err
+			}
+			v.items = append(v.items, item)
+		}
+	default:
+		n, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 10, 64)
+		if err != nil {
+			return v, fmt.Errorf("line %d: unsupported value %s", lineNo, raw)
+		}
+		v.num = &n
+	}
+	return v, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+/*
+	CONFIRMING DESTRUCTIVE ACTIONS
+
+	Actions that change a large part of the data at once and cannot be
+	undone from the chat (/archive <years>, importing a bundle, merging
+	categories) wait for a second confirmation. The bot sends a short code:
+	typing it back runs the action, and so does tapping Confirm twice within
+	confirmTapWindow. The code expires after confirmCodeTTL; typing anything
+	else cancels.
+*/
+
+const (
+	confirmCodeTTL   = 2 * time.Minute
+	confirmTapWindow = 30 * time.Second
+)
+
+// pendingConfirmation is a destructive action waiting for its code.
+type pendingConfirmation struct {
+	Code     string
+	Action   string // what run does, e.g. "archive 120 transaction(s)"
+	Expires  time.Time
+	TappedAt time.Time
+	run      func()
+}
+
+var pendingConfirmations = make(map[int64]*pendingConfirmation)
+
+// requireConfirmation asks userID to confirm action before calling run.
+func requireConfirmation(chatID int64, userID int64, action string, run func()) {
+	code := fmt.Sprintf("%04d", rand.Intn(10000))
+	pendingConfirmations[userID] = &pendingConfirmation{
+		Code:    code,
+		Action:  action,
+		Expires: appClock.Now().Add(confirmCodeTTL),
+		run:     run,
+	}
+	keyboard := buildKeyboard([][]InlineKeyboardButton{{
+		{Text: "⚠️ Confirm", CallbackData: "confirm:" + code},
+		{Text: "Cancel", CallbackData: "confirm:cancel"},
+	}})
+	sendMessageWithKeyboard(chatID, fmt.Sprintf("⚠️ This will %s and cannot be undone from the chat.\n\nType %s to go ahead, or tap Confirm twice within %d seconds. The code expires in %d minutes.",
+		action, code, int(confirmTapWindow.Seconds()), int(confirmCodeTTL.Minutes())), keyboard)
+}
+
+// runConfirmed runs a confirmed action, unless the bot went read-only
+// while it was waiting.
+func runConfirmed(chatID int64, p *pendingConfirmation) {
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+	p.run()
+}
+
+// handleConfirmationCode treats text sent while a confirmation is pending
+// as the code. It returns false when nothing is pending.
+func handleConfirmationCode(message *TGMessage) bool {
+	userID := message.From.ID
+	p, ok := pendingConfirmations[userID]
+	if !ok {
+		return false
+	}
+	delete(pendingConfirmations, userID)
+	if appClock.Now().After(p.Expires) {
+		return false
+	}
+	if strings.TrimSpace(message.Text) != p.Code {
+		sendMessage(message.Chat.ID, "That is not the confirmation code; nothing was changed.")
+		return true
+	}
+	runConfirmed(message.Chat.ID, p)
+	return true
+}
+
+// handleConfirmCallback handles the Confirm and Cancel buttons of
+// requireConfirmation.
+func handleConfirmCallback(callback *CallbackQuery) {
+	userID := callback.From.ID
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	data := strings.TrimPrefix(callback.Data, "confirm:")
+	now := appClock.Now()
+
+	p, ok := pendingConfirmations[userID]
+	if !ok || now.After(p.Expires) || (data != p.Code && data != "cancel") {
+		_ = messenger.AnswerCallback(callback.ID, "This confirmation has expired.")
+		return
+	}
+	if data == "cancel" {
+		delete(pendingConfirmations, userID)
+		_ = messenger.AnswerCallback(callback.ID, "")
+		editMessage(chatID, messageID, "Canceled; nothing was changed.")
+		return
+	}
+	if p.TappedAt.IsZero() || now.Sub(p.TappedAt) > confirmTapWindow {
+		p.TappedAt = now
+		_ = messenger.AnswerCallback(callback.ID, fmt.Sprintf("Tap Confirm again within %d seconds to %s.", int(confirmTapWindow.Seconds()), p.Action))
+		return
+	}
+	delete(pendingConfirmations, userID)
+	_ = messenger.AnswerCallback(callback.ID, "")
+	editMessage(chatID, messageID, "⚠️ Confirmed: "+p.Action+".")
+	runConfirmed(chatID, p)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+	CURRENCY FORMATTING
+
+	Amounts shown in messages go through formatMoney, which writes them in
+	the currency of the ledger the commands see: its symbol, its number of
+	decimals (none for IDR or JPY), thousands separators, and a minus in
+	front for negative amounts, e.g. "Rp 1.250.000", "$1,250.50" or
+	"-€12.00". formatSignedMoney also marks positive amounts, for
+	differences and gains.
+
+	The currency is the ledger's own (/ledger currency EUR), else the
+	instance's (currency in the config file, CURRENCY); with neither,
+	amounts have two decimals and no symbol. While "/ledger all" combines
+	the ledgers, the instance's currency is used. The separators follow
+	the locale chosen with /start, else the one of the configuration:
+	"id-ID" writes 1.250.000,50 and "en-US" 1,250,000.50.
+
+	The display currency is cached like the categories (categories.go):
+	formatting never touches the database, so it is safe while rows are
+	open. Call refreshCurrency after anything that changes the ledger the
+	commands see, its currency or the configuration.
+
+	Files meant for other programs (CSV, QIF, Firefly III) keep plain
+	numbers, with the decimals of the currency but no symbol or separators
+	(formatMoneyPlain).
+*/
+
+// currency describes how amounts of one currency are written.
+type currency struct {
+	Code     string
+	Prefix   string // written before the number, with its space if any
+	Suffix   string // written after the number
+	Decimals int    // 0 to 2, as amounts are kept in hundredths
+}
+
+// knownCurrencies are the currencies with a symbol; other ISO codes are
+// written "CODE 1,250.00".
+var knownCurrencies = map[string]currency{
+	"AUD": {Prefix: "A$", Decimals: 2},
+	"BRL": {Prefix: "R$", Decimals: 2},
+	"CAD": {Prefix: "CA$", Decimals: 2},
+	"CHF": {Prefix: "CHF ", Decimals: 2},
+	"CNY": {Prefix: "CN¥", Decimals: 2},
+	"DKK": {Suffix: " kr", Decimals: 2},
+	"EUR": {Prefix: "€", Decimals: 2},
+	"GBP": {Prefix: "£", Decimals: 2},
+	"HKD": {Prefix: "HK$", Decimals: 2},
+	"IDR": {Prefix: "Rp ", Decimals: 0},
+	"INR": {Prefix: "₹", Decimals: 2},
+	"JPY": {Prefix: "¥", Decimals: 0},
+	"KRW": {Prefix: "₩", Decimals: 0},
+	"MXN": {Prefix: "MX$", Decimals: 2},
+	"MYR": {Prefix: "RM", Decimals: 2},
+	"NOK": {Suffix: " kr", Decimals: 2},
+	"NZD": {Prefix: "NZ$", Decimals: 2},
+	"PHP": {Prefix: "₱", Decimals: 2},
+	"SEK": {Suffix: " kr", Decimals: 2},
+	"SGD": {Prefix: "S$", Decimals: 2},
+	"THB": {Prefix: "฿", Decimals: 2},
+	"TRY": {Prefix: "₺", Decimals: 2},
+	"USD": {Prefix: "$", Decimals: 2},
+	"VND": {Suffix: " ₫", Decimals: 0},
+	"ZAR": {Prefix: "R ", Decimals: 2},
+}
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// lookupCurrency returns the format of an ISO 4217 code, or the plain
+// two-decimal format for "".
+func lookupCurrency(code string) currency {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return currency{Decimals: 2}
+	}
+	if c, ok := knownCurrencies[code]; ok {
+		c.Code = code
+		return c
+	}
+	return currency{Code: code, Prefix: code + " ", Decimals: 2}
+}
+
+// numberSeparators are the thousands and decimal separators of a locale.
+type numberSeparators struct {
+	group, decimal string
+}
+
+// localeSeparators lists the languages that do not write 1,250.50.
+var localeSeparators = map[string]numberSeparators{
+	"da": {".", ","},
+	"de": {".", ","},
+	"es": {".", ","},
+	"id": {".", ","},
+	"it": {".", ","},
+	"nl": {".", ","},
+	"pt": {".", ","},
+	"tr": {".", ","},
+	"vi": {".", ","},
+	"cs": {" ", ","},
+	"fi": {" ", ","},
+	"fr": {" ", ","},
+	"nb": {" ", ","},
+	"pl": {" ", ","},
+	"ru": {" ", ","},
+	"sv": {" ", ","},
+}
+
+// separatorsFor returns the separators of a locale such as "id-ID".
+func separatorsFor(locale string) numberSeparators {
+	lang, _, _ := strings.Cut(strings.ReplaceAll(strings.ToLower(locale), "_", "-"), "-")
+	if s, ok := localeSeparators[lang]; ok {
+		return s
+	}
+	return numberSeparators{",", "."}
+}
+
+// digits splits an amount into whole units and the fraction written with
+// decimals digits, rounded half away from zero.
+func (c currency) digits(m Money) (whole int64, frac int64) {
+	units := int64(m.Abs())
+	step := int64(1)
+	for i := c.Decimals; i < 2; i++ {
+		step *= 10
+	}
+	units = (units + step/2) / step
+	perUnit := int64(moneyScale) / step
+	return units / perUnit, units % perUnit
+}
+
+// format writes m with the symbol and separators; signed adds a "+" to
+// positive amounts.
+func (c currency) format(m Money, sep numberSeparators, signed bool) string {
+	whole, frac := c.digits(m)
+	number := groupThousands(strconv.FormatInt(whole, 10), sep.group)
+	if c.Decimals > 0 {
+		number += sep.decimal + fmt.Sprintf("%0*d", c.Decimals, frac)
+	}
+	sign := ""
+	switch {
+	case whole == 0 && frac == 0:
+	case m < 0:
+		sign = "-"
+	case signed:
+		sign = "+"
+	}
+	return sign + c.Prefix + number + c.Suffix
+}
+
+// plain writes m as other programs read it: "-1250.5" becomes "-1250.50",
+// or "-1251" without decimals.
+func (c currency) plain(m Money) string {
+	whole, frac := c.digits(m)
+	sign := ""
+	if m < 0 && (whole != 0 || frac != 0) {
+		sign = "-"
+	}
+	if c.Decimals == 0 {
+		return sign + strconv.FormatInt(whole, 10)
+	}
+	return sign + strconv.FormatInt(whole, 10) + "." + fmt.Sprintf("%0*d", c.Decimals, frac)
+}
+
+// groupThousands inserts sep every three digits from the right.
+func groupThousands(digits string, sep string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	var sb strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		sb.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if sb.Len() > 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteString(digits[i : i+3])
+	}
+	return sb.String()
+}
+
+// moneyFormat is a currency with the separators of the locale.
+type moneyFormat struct {
+	currency
+	sep numberSeparators
+}
+
+type currencyCache struct {
+	mu      sync.RWMutex
+	current moneyFormat
+}
+
+var displayCurrency = currencyCache{current: moneyFormat{lookupCurrency(""), separatorsFor("")}}
+
+func (c *currencyCache) get() moneyFormat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+func (c *currencyCache) set(f moneyFormat) {
+	c.mu.Lock()
+	c.current = f
+	c.mu.Unlock()
+}
+
+// load works out the currency of the ledger the commands see, and the
+// separators of the locale.
+func (c *currencyCache) load() error {
+	cfg := currentConfig()
+	code, locale := "", ""
+	if cfg != nil {
+		code, locale = cfg.Currency, cfg.Locale
+	}
+	locale = getSetting("locale", locale)
+	if !ledgersCombined() {
+		own, err := ledgerCurrency(activeLedgerID())
+		if err != nil {
+			return err
+		}
+		if own != "" {
+			code = own
+		}
+	}
+	c.set(moneyFormat{lookupCurrency(code), separatorsFor(locale)})
+	return nil
+}
+
+// refreshCurrency reloads the cache after the ledger the commands see,
+// its currency, the locale or the configuration changed. On failure the previous
+// currency stays cached.
+func refreshCurrency() {
+	if err := displayCurrency.load(); err != nil {
+		log.Printf("Failed to load the ledger currency: %v", err)
+	}
+}
+
+// ledgerCurrency returns the currency set on a ledger, or "".
+func ledgerCurrency(id int64) (string, error) {
+	var code string
+	err := db.QueryRow("SELECT currency FROM ledgers WHERE id = ?", id).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return code, err
+}
+
+// formatMoney writes an amount for a message, e.g. "Rp 25.000".
+func formatMoney(m Money) string {
+	f := displayCurrency.get()
+	return f.format(m, f.sep, false)
+}
+
+// formatSignedMoney is formatMoney with a "+" on positive amounts.
+func formatSignedMoney(m Money) string {
+	f := displayCurrency.get()
+	return f.format(m, f.sep, true)
+}
+
+// formatMoneyPlain writes an amount for a file meant for other programs,
+// e.g. "25000" in IDR or "25.50" in USD.
+func formatMoneyPlain(m Money) string {
+	return displayCurrency.get().plain(m)
+}
+
+// currencyCode returns the ISO code of the display currency, or "" when
+// none is set.
+func currencyCode() string {
+	return displayCurrency.get().Code
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	DATA DIRECTORY
+
+	With data_dir in the config file (DATA_DIR in the environment) every
+	file the bot writes lives under one directory, so a container only
+	has to mount one volume:
+
+		db/            the database, unless db.path points elsewhere
+		backups/       backups and replica snapshots while they are sent
+		exports/       exports, reports and charts while they are sent
+		attachments/   files downloaded from Telegram, e.g. CSV imports
+
+	The subdirectories are created on startup and each must be writable,
+	or the bot refuses to start like for any other configuration problem.
+	Files are only kept there while in use; the maintenance job deletes
+	leftovers older than a day, e.g. after a crash. Without a data
+	directory the system temporary directory is used as before.
+
+	/diskusage (owner only) reports the space used by each part and the
+	space left on the volume.
+*/
+
+// dataSubdirs are the subdirectories of the data directory.
+var dataSubdirs = []string{"db", "backups", "exports", "attachments"}
+
+// staleFileAge is when the maintenance job deletes files left behind.
+const staleFileAge = 24 * time.Hour
+
+// prepareDataDir creates the data directory and its subdirectories and
+// checks they are writable.
+func prepareDataDir(dir string, problems *ConfigError) {
+	for _, sub := range dataSubdirs {
+		path := filepath.Join(dir, sub)
+		if err := os.MkdirAll(path, 0o750); err != nil {
+			problems.add("data_dir %s: cannot create %s: %v", dir, path, err)
+			continue
+		}
+		probe, err := os.CreateTemp(path, ".ayunda-write-test-*")
+		if err != nil {
+			problems.add("data_dir %s: %s is not writable: %v (check the owner of the mounted volume)", dir, path, err)
+			continue
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+}
+
+// dataSubdir returns the directory for temporary files of one kind, or ""
+// (the system temporary directory) without a data directory.
+func dataSubdir(name string) string {
+	cfg := currentConfig()
+	if cfg == nil || cfg.DataDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.DataDir, name)
+}
+
+// dirUsage returns the size and number of the files under dir.
+func dirUsage(dir string) (int64, int, error) {
+	var size int64
+	var files int
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		files++
+		return nil
+	})
+	return size, files, err
+}
+
+// purgeStaleFiles deletes the files older than staleFileAge from the
+// temporary subdirectories of the data directory.
+func purgeStaleFiles(now time.Time) (int64, error) {
+	var removed int64
+	for _, sub := range []string{"backups", "exports", "attachments"} {
+		dir := dataSubdir(sub)
+		if dir == "" {
+			return 0, nil
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return removed, err
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil || e.IsDir() || now.Sub(info.ModTime()) < staleFileAge {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// showDiskUsage implements /diskusage.
+func showDiskUsage(chatID int64) {
+	cfg := currentConfig()
+	var sb strings.Builder
+	sb.WriteString("💽 Disk usage\n\n")
+
+	dbSize := fileSize(DB_PATH) + fileSize(DB_PATH+"-wal") + fileSize(DB_PATH+"-shm")
+	sb.WriteString(fmt.Sprintf("Database: %s (%s)\n", formatBytes(dbSize), DB_PATH))
+	var pageSize, freePages int64
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err == nil {
+		if err := db.QueryRow("PRAGMA freelist_count").Scan(&freePages); err == nil && freePages > 0 {
+			sb.WriteString(fmt.Sprintf("• free pages: %s, reclaimed by the nightly VACUUM\n", formatBytes(pageSize*freePages)))
+		}
+	}
+	var archived int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions_archive").Scan(&archived); err == nil && archived > 0 {
+		sb.WriteString(fmt.Sprintf("• archived transactions: %d\n", archived))
+	}
+
+	volume := filepath.Dir(DB_PATH)
+	if cfg != nil && cfg.DataDir != "" {
+		volume = cfg.DataDir
+		sb.WriteString(fmt.Sprintf("\nData directory: %s\n", cfg.DataDir))
+		for _, sub := range dataSubdirs {
+			size, files, err := dirUsage(dataSubdir(sub))
+			if err != nil {
+				sb.WriteString(fmt.Sprintf("• %s/: %v\n", sub, err))
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("• %s/: %s in %d file(s)\n", sub, formatBytes(size), files))
+		}
+	}
+
+	if free, total, err := diskSpace(volume); err != nil {
+		sb.WriteString(fmt.Sprintf("\nFree space: unknown (%v)", err))
+	} else {
+		sb.WriteString(fmt.Sprintf("\nFree space: %s of %s (%.0f%%)", formatBytes(free), formatBytes(total), percentOf(float64(free), float64(total))))
+		if total > 0 && float64(free)/float64(total) < 0.1 {
+			sb.WriteString("\n⚠️ The volume is almost full.")
+		}
+	}
+	sendMessage(chatID, sb.String())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const busyTimeoutMillis = 5000
+
+// openDB opens the SQLite database in WAL mode with foreign keys enforced and
+// a busy timeout (see configureConn). The pool is limited to a single
+// connection: SQLite allows only one writer at a time, and funnelling
+// everything through one connection avoids SQLITE_BUSY between our own
+// goroutines. Callers must therefore never run a query while iterating over
+// another query's rows.
+func openDB(path string) (*sql.DB, error) {
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&_txlock=immediate"
+	} else {
+		dsn += "?_txlock=immediate"
+	}
+
+	conn, err := sql.Open(sqliteDriverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(1)
+	conn.SetConnMaxLifetime(0)
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := checkEncryption(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// stmtCache keeps prepared statements for hot queries so they are not
+// re-prepared on every insert and edit.
+var stmtCache = struct {
+	sync.Mutex
+	stmts map[string]*sql.Stmt
+}{stmts: make(map[string]*sql.Stmt)}
+
+// cachedStmt returns a prepared statement for query, preparing it on first use.
+func cachedStmt(query string) (*sql.Stmt, error) {
+	stmtCache.Lock()
+	defer stmtCache.Unlock()
+
+	if stmt, ok := stmtCache.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	stmtCache.stmts[query] = stmt
+	return stmt, nil
+}
+
+// execCached runs a write statement through the statement cache.
+func execCached(query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := cachedStmt(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Exec(args...)
+}
+
+// queryRowCached runs a single-row query through the statement cache.
+func queryRowCached(query string, args ...interface{}) *sql.Row {
+	stmt, err := cachedStmt(query)
+	if err != nil {
+		// db.QueryRow reports the same prepare error through Scan
+		return db.QueryRow(query, args...)
+	}
+	return stmt.QueryRow(args...)
+}
+
+// closeStmtCache closes every cached statement; used before closing the DB.
+func closeStmtCache() {
+	stmtCache.Lock()
+	defer stmtCache.Unlock()
+	for query, stmt := range stmtCache.stmts {
+		stmt.Close()
+		delete(stmtCache.stmts, query)
+	}
+}
+
+// addColumnIfMissing adds a column to an existing table; CREATE TABLE IF
+// NOT EXISTS leaves tables created by older versions unchanged.
+func addColumnIfMissing(db *sql.DB, table string, column string, decl string) error {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
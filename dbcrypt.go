@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+/*
+	ENCRYPTION AT REST
+
+	When DB_KEY (or --db-key) is set, every connection is keyed with
+	PRAGMA key, which requires the binary to be linked against SQLCipher
+	instead of stock SQLite. Backups can additionally be encrypted with
+	BACKUP_KEY using AES-256-GCM, which works with any build.
+*/
+
+const sqliteDriverName = "sqlite3_ayunda"
+
+// dbKey is the SQLCipher key applied to every new connection.
+var dbKey string
+
+func init() {
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{ConnectHook: configureConn})
+}
+
+// configureConn keys the connection (the key must come before any other
+// statement) and then applies the connection pragmas.
+func configureConn(conn *sqlite3.SQLiteConn) error {
+	pragmas := []string{}
+	if dbKey != "" {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA key = %s", quoteSQLString(dbKey)))
+	}
+	pragmas = append(pragmas,
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA foreign_keys = ON",
+		fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeoutMillis),
+	)
+	for _, p := range pragmas {
+		if _, err := conn.Exec(p, nil); err != nil {
+			if strings.HasPrefix(p, "PRAGMA key") {
+				return errors.New("failed to apply database key")
+			}
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func quoteSQLString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// cipherVersion returns the SQLCipher version, or "" when the driver was
+// built against stock SQLite.
+func cipherVersion(conn *sql.DB) string {
+	var version string
+	if err := conn.QueryRow("PRAGMA cipher_version").Scan(&version); err != nil {
+		return ""
+	}
+	return version
+}
+
+var errNoSQLCipher = errors.New("DB_KEY is set but this binary is not linked against SQLCipher; rebuild with a SQLCipher-enabled SQLite (see README) or unset DB_KEY")
+
+// checkEncryption verifies that a configured key is actually in effect.
+func checkEncryption(conn *sql.DB) error {
+	if dbKey == "" {
+		return nil
+	}
+	if cipherVersion(conn) == "" {
+		return errNoSQLCipher
+	}
+	// Reading the schema fails with "file is not a database" on a wrong key
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&n); err != nil {
+		return fmt.Errorf("cannot read database with the configured DB_KEY (wrong key?): %w", err)
+	}
+	return nil
+}
+
+// rekeyDB re-encrypts the open database with newKey.
+func rekeyDB(newKey string) error {
+	if cipherVersion(db) == "" {
+		return errNoSQLCipher
+	}
+	if newKey == "" {
+		return errors.New("new key must not be empty")
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA rekey = %s", quoteSQLString(newKey))); err != nil {
+		return err
+	}
+	dbKey = newKey
+	return nil
+}
+
+// backupDB writes a consistent copy of the database to dest. With SQLCipher
+// the copy is encrypted with the database key; when backupKey is set the
+// resulting file is additionally sealed with AES-256-GCM.
+func backupDB(dest string, backupKey string) error {
+	tmp := dest + ".tmp"
+	_ = os.Remove(tmp)
+	defer os.Remove(tmp)
+
+	if dbKey != "" {
+		// sqlcipher_export copies into an attached database keyed with the same key
+		if _, err := db.Exec("ATTACH DATABASE ? AS backup KEY ?", tmp, dbKey); err != nil {
+			return fmt.Errorf("attach backup: %w", err)
+		}
+		_, exportErr := db.Exec("SELECT sqlcipher_export('backup')")
+		if _, err := db.Exec("DETACH DATABASE backup"); err != nil && exportErr == nil {
+			exportErr = err
+		}
+		if exportErr != nil {
+			return fmt.Errorf("export backup: %w", exportErr)
+		}
+	} else {
+		if _, err := db.Exec("VACUUM INTO ?", tmp); err != nil {
+			return fmt.Errorf("vacuum into backup: %w", err)
+		}
+	}
+
+	if backupKey == "" {
+		return os.Rename(tmp, dest)
+	}
+	return encryptFile(tmp, dest, backupKey)
+}
+
+// Encrypted backup file layout: magic | salt (16) | nonce (12) | AES-GCM ciphertext.
+const (
+	backupMagic     = "AYUNDABK1"
+	backupSaltSize  = 16
+	backupKDFRounds = 600000
+	backupKeyLength = 32
+
+	encryptedBackupSuffix = ".enc"
+)
+
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, backupKDFRounds, backupKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptFile(src string, dest string, passphrase string) error {
+	plain, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	out := make([]byte, 0, len(backupMagic)+len(salt)+len(nonce)+len(plain)+aead.Overhead())
+	out = append(out, backupMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, plain, []byte(backupMagic))
+	return os.WriteFile(dest, out, 0600)
+}
+
+// decryptBackup restores the plain SQLite file from an encrypted backup.
+func decryptBackup(src string, dest string, passphrase string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(string(data), backupMagic) {
+		return errors.New("not an encrypted backup file")
+	}
+	data = data[len(backupMagic):]
+	if len(data) < backupSaltSize+12 {
+		return errors.New("encrypted backup is truncated")
+	}
+	salt := data[:backupSaltSize]
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := data[backupSaltSize : backupSaltSize+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[backupSaltSize+aead.NonceSize():], []byte(backupMagic))
+	if err != nil {
+		return errors.New("failed to decrypt backup (wrong BACKUP_KEY?)")
+	}
+	return os.WriteFile(dest, plain, 0600)
+}
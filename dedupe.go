@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+)
+
+/*
+	DUPLICATE UPDATES
+
+	Telegram delivers an update again until a later getUpdates confirms
+	it, so an update handled just before a crash or a restart comes back
+	when the bot starts, and a webhook is retried when it answers too late.
+	Handled twice, a description sent right before the crash would insert
+	its transaction a second time.
+
+	Every update is claimed in processed_updates before it is handled, by
+	its update_id and, for a button, by the id of the callback query too,
+	and one already claimed is skipped with a log line. The claim comes
+	first, so an update the bot crashes on is not handled again: a lost
+	step of a conversation can be typed again, a duplicate entry in the
+	ledger may go unnoticed. The offset of the next update is kept in the
+	settings, so a restart resumes where the loop stopped.
+
+	Telegram keeps updates for 24 hours; the claims are purged by the
+	nightly maintenance after updateRetention.
+*/
+
+const updateRetention = 7 * 24 * time.Hour
+
+// claimUpdate records update as processed and reports whether it is new.
+// If the claim cannot be recorded the update is handled anyway.
+func claimUpdate(update Update) bool {
+	var callbackID sql.NullString
+	if update.CallbackQuery != nil && update.CallbackQuery.ID != "" {
+		callbackID = sql.NullString{String: update.CallbackQuery.ID, Valid: true}
+	}
+	claimed, err := execAffected(`INSERT OR IGNORE INTO processed_updates (update_id, callback_id)
+		SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM processed_updates WHERE callback_id = ?)`,
+		update.UpdateID, callbackID, callbackID)
+	if err != nil {
+		reportError("recording a processed update", err)
+		return true
+	}
+	if claimed == 0 {
+		log.Printf("Skipping update %d: already processed", update.UpdateID)
+		return false
+	}
+	return true
+}
+
+// savedUpdateOffset returns the offset the last run stopped at, or 0.
+func savedUpdateOffset() int {
+	offset, err := strconv.Atoi(getSetting("update_offset", "0"))
+	if err != nil {
+		return 0
+	}
+	return offset
+}
+
+// saveUpdateOffset remembers the offset of the next update to fetch.
+func saveUpdateOffset(offset int) {
+	if err := setSetting("update_offset", strconv.Itoa(offset)); err != nil {
+		log.Printf("Saving the update offset: %v", err)
+	}
+}
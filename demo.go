@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+	DEMO DATA
+
+	ayunda seed-demo [-months 6] [-seed S]
+
+	fills an empty database with months of made-up but plausible
+	transactions, so the reports, charts and budgets can be tried (and
+	their speed judged) before real data builds up: a salary on the 25th,
+	the rent on the 1st, bills early in the month, and meals, transport
+	and shopping on most days at waking hours, with amounts spread around
+	a typical price. Only the categories that exist are used, and the
+	Food and Transportation budgets are set when they have none.
+
+	Amounts are in rupiah; in another currency they are divided by 15,000,
+	which makes them about US dollars. The same seed gives the same data.
+*/
+
+// demoCategory describes the transactions of one category.
+type demoCategory struct {
+	Name         string
+	Type         string
+	PerMonth     float64 // expected number per month
+	Day          int     // day of the month of a monthly payment, 0 for any day
+	Min, Max     float64 // amount range, in rupiah
+	Descriptions []string
+}
+
+var demoCategories = []demoCategory{
+	{"Salary", "income", 1, 25, 8000000, 8500000, []string{"Monthly salary"}},
+	{"Rent", "expense", 1, 1, 2500000, 2500000, []string{"Monthly rent"}},
+	{"Utilities", "expense", 1, 5, 350000, 650000, []string{"Electricity", "Internet"}},
+	{"Bills", "expense", 1, 10, 150000, 300000, []string{"Phone plan", "Streaming subscription"}},
+	{"Food", "expense", 45, 0, 15000, 120000, []string{"Nasi goreng", "Coffee", "Lunch with the team", "Groceries", "Bakso", "Dinner out", "Martabak"}},
+	{"Transportation", "expense", 20, 0, 10000, 80000, []string{"Ojek", "Fuel", "Train ticket", "Parking", "Taxi"}},
+	{"Needs", "expense", 6, 0, 30000, 400000, []string{"Toiletries", "Household supplies", "Pharmacy"}},
+	{"Water", "expense", 4, 0, 20000, 25000, []string{"Gallon water"}},
+	{"Laundry", "expense", 3, 0, 35000, 70000, []string{"Laundry"}},
+}
+
+// demoBudgets are set for the categories that have no budget.
+var demoBudgets = []categoryBudget{
+	{Category: "Food", Amount: 2500000 * moneyScale},
+	{Category: "Transportation", Amount: 800000 * moneyScale},
+}
+
+// demoAmount converts a rupiah amount to the configured currency.
+func demoAmount(rupiah float64) Money {
+	if code := displayCurrency.get().Code; code != "" && code != "IDR" {
+		return moneyFromFloat(math.Round(rupiah/15000*100) / 100)
+	}
+	return moneyFromFloat(math.Round(rupiah/500) * 500)
+}
+
+// generateDemoTransactions makes up the transactions of [from, to) in the
+// given categories, oldest first.
+func generateDemoTransactions(from time.Time, to time.Time, categories []string, rng *rand.Rand) []Transaction {
+	known := make(map[string]bool, len(categories))
+	for _, c := range categories {
+		known[c] = true
+	}
+	ledgerID := activeLedgerID()
+	var transactions []Transaction
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, c := range demoCategories {
+			if !known[c.Name] {
+				continue
+			}
+			count := 0
+			if c.Day != 0 {
+				if day.Day() == c.Day {
+					count = 1
+				}
+			} else {
+				// the daily rate split into three chances, so a busy
+				// category can have a few entries on the same day
+				rate := c.PerMonth / 30
+				for i := 0; i < 3; i++ {
+					if rng.Float64() < rate/3 {
+						count++
+					}
+				}
+			}
+			for i := 0; i < count; i++ {
+				at := day.Add(9 * time.Hour)
+				if c.Day == 0 {
+					at = day.Add(time.Duration(7*60+rng.Intn(15*60)) * time.Minute)
+				}
+				if !at.Before(to) {
+					continue
+				}
+				// amounts lean towards the low end, like real prices
+				spread := math.Pow(rng.Float64(), 2)
+				transactions = append(transactions, Transaction{
+					Type:        c.Type,
+					Category:    c.Name,
+					Quantity:    1,
+					Amount:      demoAmount(c.Min + (c.Max-c.Min)*spread),
+					Description: c.Descriptions[rng.Intn(len(c.Descriptions))],
+					CreatedAt:   at,
+					LedgerID:    ledgerID,
+				})
+			}
+		}
+	}
+	return transactions
+}
+
+// insertTransactions stores many transactions in one database transaction.
+func insertTransactions(transactions []Transaction) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range transactions {
+		if _, err := addTransactionTx(tx, t); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func cmdSeedDemo(args []string) error {
+	fs := newCommandFlags("seed-demo", "[-months 6] [-seed S]")
+	months := fs.Int("months", 6, "Months of transactions, up to today")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "Random seed")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+	if *months < 1 || *months > 120 {
+		return fmt.Errorf("months must be between 1 and 120")
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("the database already has %d transaction(s); seed-demo only fills an empty one", count)
+	}
+
+	now := appClock.Now()
+	today, _ := dayBounds(now)
+	from := today.AddDate(0, -*months, 0)
+	transactions := generateDemoTransactions(from, now, activeCategories(), rand.New(rand.NewSource(*seed)))
+	if len(transactions) == 0 {
+		return fmt.Errorf("none of the demo categories exist")
+	}
+	if err := insertTransactions(transactions); err != nil {
+		return fmt.Errorf("save transactions: %w", err)
+	}
+	fmt.Printf("Added %d demo transactions from %s to %s (seed %d).\n", len(transactions), from.Format("2006-01-02"), now.Format("2006-01-02"), *seed)
+
+	for _, b := range demoBudgets {
+		if _, ok := findCategory(b.Category); !ok {
+			continue
+		}
+		if budget, err := store.CategoryBudget(b.Category); err != nil || budget != 0 {
+			continue
+		}
+		if err := store.SetCategoryBudget(b.Category, demoAmount(b.Amount.Float())); err != nil {
+			return fmt.Errorf("set the %s budget: %w", b.Category, err)
+		}
+		fmt.Printf("Set a %s budget of %s.\n", b.Category, formatMoneyPlain(demoAmount(b.Amount.Float())))
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// diskSpace is not available on this platform.
+func diskSpace(path string) (free int64, total int64, err error) {
+	return 0, 0, errors.New("not supported on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// diskSpace returns the free and total bytes of the volume holding path.
+func diskSpace(path string) (free int64, total int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
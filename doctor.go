@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	DOCTOR (/doctor)
+
+	Checks the database for corruption and for data the bot would not have
+	written itself: category references without a category, transactions
+	whose type is neither income nor expense, negative amounts and stale
+	monthly aggregates. "/doctor fix" repairs what can be repaired without
+	guessing (missing categories are created, types that only differ in
+	case or spacing are normalized, aggregates are rebuilt); the rest is
+	listed for /edit or /delete.
+*/
+
+const doctorMaxIDs = 20
+
+// categoryRefs lists the tables that refer to categories by name.
+var categoryRefs = []string{"transactions", "budgets", "allocations", "bills", "subscriptions", "receipt_drafts"}
+
+type doctorReport struct {
+	sb       strings.Builder
+	problems int
+}
+
+func (r *doctorReport) ok(format string, args ...interface{}) {
+	r.sb.WriteString("✅ " + fmt.Sprintf(format, args...) + "\n")
+}
+
+func (r *doctorReport) problem(format string, args ...interface{}) {
+	r.problems++
+	r.sb.WriteString("⚠️ " + fmt.Sprintf(format, args...) + "\n")
+}
+
+func (r *doctorReport) fixed(format string, args ...interface{}) {
+	r.sb.WriteString("🔧 " + fmt.Sprintf(format, args...) + "\n")
+}
+
+// queryStrings returns the first column of every row of query.
+func queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func formatIDs(ids []string) string {
+	if len(ids) > doctorMaxIDs {
+		return strings.Join(ids[:doctorMaxIDs], ", ") + fmt.Sprintf(" and %d more", len(ids)-doctorMaxIDs)
+	}
+	return strings.Join(ids, ", ")
+}
+
+// runDoctor checks the database, repairing what it can when fix is set,
+// and returns the report and the number of problems left.
+func runDoctor(fix bool) (string, int, error) {
+	r := &doctorReport{}
+
+	// a corrupt file makes every other check unreliable
+	results, err := queryStrings("PRAGMA integrity_check")
+	if err != nil {
+		return "", 0, err
+	}
+	if len(results) == 1 && results[0] == "ok" {
+		r.ok("SQLite integrity check passed")
+	} else {
+		r.problem("SQLite integrity check failed: %s. Restore a backup (/backup) or dump and reload the database.", strings.Join(results, "; "))
+		return r.sb.String(), r.problems, nil
+	}
+
+	violations, err := queryStrings("SELECT \"table\" FROM pragma_foreign_key_check")
+	if err != nil {
+		return "", 0, err
+	}
+	if len(violations) == 0 {
+		r.ok("No foreign key violations")
+	} else {
+		r.problem("%d foreign key violation(s) in: %s", len(violations), strings.Join(violations, ", "))
+	}
+
+	if err := checkCategoryRefs(r, fix); err != nil {
+		return "", 0, err
+	}
+	if err := checkTransactionTypes(r, fix); err != nil {
+		return "", 0, err
+	}
+
+	negative, err := queryStrings("SELECT id FROM transactions WHERE amount < 0 ORDER BY id")
+	if err != nil {
+		return "", 0, err
+	}
+	if len(negative) == 0 {
+		r.ok("No negative amounts")
+	} else {
+		r.problem("%d transaction(s) with a negative amount, check them with /edit: %s", len(negative), formatIDs(negative))
+	}
+
+	stale, err := verifyAggregates()
+	if err != nil {
+		return "", 0, err
+	}
+	switch {
+	case len(stale) == 0:
+		r.ok("Monthly aggregates are up to date")
+	case fix:
+		if err := rebuildAggregates(); err != nil {
+			return "", 0, err
+		}
+		r.fixed("Rebuilt %d stale monthly aggregate(s)", len(stale))
+	default:
+		r.problem("%d stale monthly aggregate(s)", len(stale))
+	}
+
+	return strings.TrimRight(r.sb.String(), "\n"), r.problems, nil
+}
+
+// checkCategoryRefs finds category names used by transactions, budgets,
+// bills or subscriptions that are missing from the categories table.
+func checkCategoryRefs(r *doctorReport, fix bool) error {
+	var selects []string
+	for _, table := range categoryRefs {
+		selects = append(selects, "SELECT category FROM "+table)
+	}
+	missing, err := queryStrings("SELECT DISTINCT category FROM (" + strings.Join(selects, " UNION ") + ") WHERE category NOT IN (SELECT name FROM categories) ORDER BY category")
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		r.ok("Every category reference exists")
+		return nil
+	}
+	if !fix {
+		r.problem("%d unknown category name(s) referenced: %s", len(missing), strings.Join(missing, ", "))
+		return nil
+	}
+
+	for _, name := range missing {
+		if _, err := db.Exec("INSERT OR IGNORE INTO categories (name) VALUES (?)", name); err != nil {
+			return err
+		}
+	}
+	refreshCategories()
+	r.fixed("Created missing category name(s): %s", strings.Join(missing, ", "))
+	return nil
+}
+
+// checkTransactionTypes finds transactions whose type is not "income" or
+// "expense". Types that only differ in case or surrounding spaces are
+// normalized by fix.
+func checkTransactionTypes(r *doctorReport, fix bool) error {
+	const fixable = "LOWER(TRIM(type)) IN ('income', 'expense') AND type NOT IN ('income', 'expense')"
+
+	if fix {
+		res, err := db.Exec("UPDATE transactions SET type = LOWER(TRIM(type)) WHERE " + fixable)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			r.fixed("Normalized the type of %d transaction(s)", n)
+		}
+	}
+
+	invalid, err := queryStrings("SELECT id FROM transactions WHERE type NOT IN ('income', 'expense') ORDER BY id")
+	if err != nil {
+		return err
+	}
+	if len(invalid) == 0 {
+		r.ok("Every transaction is an income or an expense")
+		return nil
+	}
+	hint := "check them with /edit"
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE " + fixable).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		hint = fmt.Sprintf("/doctor fix normalizes %d of them", n)
+	}
+	r.problem("%d transaction(s) with an invalid type (%s): %s", len(invalid), hint, formatIDs(invalid))
+	return nil
+}
+
+// handleDoctorCommand implements /doctor [fix].
+func handleDoctorCommand(chatID int64, args string) {
+	arg := strings.ToLower(strings.TrimSpace(args))
+	if arg != "" && arg != "fix" {
+		sendMessage(chatID, "Usage: /doctor to check the database, /doctor fix to repair what can be repaired")
+		return
+	}
+	fix := arg == "fix"
+	if fix && isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+
+	report, problems, err := runDoctor(fix)
+	if err != nil {
+		sendMessage(chatID, "Failed to check the database.")
+		reportError("checking the database", err)
+		return
+	}
+	if problems == 0 {
+		report += "\n\nNo problems found."
+	} else {
+		report += fmt.Sprintf("\n\n%d problem(s) need attention.", problems)
+	}
+	sendMessage(chatID, "🩺 Database check\n\n"+report)
+}
+
+func cmdDoctor(args []string) error {
+	fs := newCommandFlags("doctor", "[-fix]")
+	fix := fs.Bool("fix", false, "Repair what can be repaired without guessing")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+
+	report, problems, err := runDoctor(*fix)
+	if err != nil {
+		return err
+	}
+	fmt.Println(report)
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) need attention", problems)
+	}
+	return nil
+}
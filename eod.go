@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	END-OF-DAY summary: today's transactions, spending against the daily
+	budget (monthly budget / days in month), what is left for the month and
+	the streaks (streaks.go).
+	Enabled with /eod on [HH:MM] or [schedules] end_of_day in the config.
+*/
+
+const defaultEndOfDayTime = "21:00"
+
+// endOfDayText builds the summary for the day containing now, in HTML.
+func endOfDayText(now time.Time) (string, error) {
+	dayStart, dayEnd := dayBounds(now)
+	icons := typeIcons()
+	rows, err := db.Query("SELECT id, type, category, amount, description FROM transactions WHERE created_at >= ? AND created_at < ? AND "+ledgerScope()+" ORDER BY created_at, id",
+		dayStart.Format(dbTimeLayout), dayEnd.Format(dbTimeLayout))
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for rows.Next() {
+		var (
+			id          int64
+			typ         string
+			category    string
+			amount      Money
+			description sql.NullString
+		)
+		if err := rows.Scan(&id, &typ, &category, &amount, &description); err != nil {
+			rows.Close()
+			return "", err
+		}
+		sign := "-"
+		if typ == "income" {
+			sign = "+"
+		}
+		bullet := "• "
+		if icon := icons[typ]; icon != "" {
+			bullet = icon
+		}
+		line := fmt.Sprintf("%s%s %s %s", bullet, idHTML(id), escapeHTML(category), mono(sign+formatMoney(amount)))
+		if description.String != "" {
+			line += " — " + escapeHTML(description.String)
+		}
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	spentToday, err := totalBetween("expense", dayStart, dayEnd)
+	if err != nil {
+		return "", err
+	}
+	monthStart, monthEnd := monthBounds(now)
+	spentMonth, err := totalBetween("expense", monthStart, dayEnd)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🌙 " + bold("End of day — "+now.Format("Monday, 2 January 2006")) + "\n\n")
+	if len(lines) == 0 {
+		sb.WriteString("No transactions today.\n")
+	} else {
+		sb.WriteString("Today's transactions:\n")
+		sb.WriteString(strings.Join(lines, "\n"))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+
+	budget := monthlyBudget()
+	if budget <= 0 {
+		sb.WriteString(fmt.Sprintf("Spent today: %s\n", bold(formatMoney(spentToday))))
+		sb.WriteString(escapeHTML("Set a monthly budget with /budget <amount> to track your daily allowance."))
+		return withStreaks(sb.String(), now), nil
+	}
+
+	daily := budget.Div(daysInMonth(now))
+	status := "✅"
+	if spentToday > daily {
+		status = "⚠️"
+	}
+	sb.WriteString(fmt.Sprintf("%s Spent today: %s / daily budget %s\n", status, bold(formatMoney(spentToday)), moneyHTML(daily)))
+
+	remaining := budget - spentMonth
+	daysLeft := int(monthEnd.Sub(dayEnd).Hours()/24 + 0.5)
+	sb.WriteString(fmt.Sprintf("Remaining for %s: %s of %s", now.Format("January"), bold(formatMoney(remaining)), moneyHTML(budget)))
+	if remaining > 0 && daysLeft > 0 {
+		sb.WriteString(fmt.Sprintf(" (%s/day for the %d days left)", moneyHTML(remaining.Div(daysLeft)), daysLeft))
+	} else if remaining < 0 {
+		sb.WriteString(" — over budget")
+	}
+	sb.WriteString("\n" + escapeHTML(budgetProgress(float64(spentMonth)/float64(budget))))
+	return withStreaks(sb.String(), now), nil
+}
+
+// sendEndOfDaySummary queues the end-of-day summary for the owner.
+func sendEndOfDaySummary(now time.Time) error {
+	text, err := endOfDayText(now)
+	if err != nil {
+		return err
+	}
+	return enqueueHTMLNotification(ownerID(), "end_of_day", "end_of_day:"+now.Format("2006-01-02"), text, nil)
+}
+
+// handleEndOfDayCommand implements /eod [on [HH:MM]|off|now].
+func handleEndOfDayCommand(chatID int64, args string) {
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields) == 0 {
+		if at, ok := scheduleTime("end_of_day"); ok {
+			sendMessage(chatID, fmt.Sprintf("End-of-day summary is sent daily at %s. Use /eod off to disable or /eod now to see it.", at))
+		} else {
+			sendMessage(chatID, "End-of-day summary is off. Use /eod on [HH:MM] to enable it.")
+		}
+		return
+	}
+
+	switch fields[0] {
+	case "on":
+		at := defaultEndOfDayTime
+		if len(fields) > 1 {
+			if _, err := time.Parse("15:04", fields[1]); err != nil {
+				sendMessage(chatID, "Invalid time. Usage: /eod on 21:00")
+				return
+			}
+			at = fields[1]
+		}
+		if err := setSetting("schedule.end_of_day", at); err != nil {
+			sendMessage(chatID, "Failed to update schedule.")
+			reportError("enabling the end-of-day summary", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("End-of-day summary will be sent daily at %s.", at))
+	case "off":
+		if err := setSetting("schedule.end_of_day", "off"); err != nil {
+			sendMessage(chatID, "Failed to update schedule.")
+			reportError("disabling the end-of-day summary", err)
+			return
+		}
+		sendMessage(chatID, "End-of-day summary disabled.")
+	case "now":
+		text, err := endOfDayText(appClock.Now())
+		if err != nil {
+			sendMessage(chatID, "Failed to build the end-of-day summary.")
+			reportError("building the end-of-day summary", err)
+			return
+		}
+		sendHTML(chatID, text)
+	default:
+		sendMessage(chatID, "Usage: /eod [on [HH:MM]|off|now]")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+	EXPORT FORMATS for other finance applications (/export firefly|qif)
+
+	firefly: a CSV for the Firefly III Data Importer plus its import
+	configuration (the column roles), so the file imports without mapping
+	columns by hand. Amounts are signed from the point of view of the asset
+	account chosen during the import: expenses are negative. The currency
+	column holds the code of the ledger's currency, empty when none is set;
+	an amount entered in another currency (fx.go) also fills the foreign
+	amount and currency columns.
+
+	qif: a Quicken Interchange Format file as GnuCash and most desktop
+	applications import it, one bank account with the categories as
+	Income:<category> and Expenses:<category> accounts. The memo notes the
+	original amount of a transaction entered in another currency.
+*/
+
+// exportFormats maps the /export argument to its writers; the first file
+// is the export itself, the others come with it.
+var exportFormats = map[string]struct {
+	caption string
+	files   []exportFile
+}{
+	"csv":     {"Transactions export (CSV)", []exportFile{{"transactions-*.csv", writeTransactionsCSV}}},
+	"firefly": {"Firefly III export: import the CSV with the Data Importer and upload the JSON as its configuration", []exportFile{{"firefly-transactions-*.csv", writeFireflyCSV}, {"firefly-import-config-*.json", writeFireflyConfig}}},
+	"qif":     {"QIF export for GnuCash and other finance applications", []exportFile{{"transactions-*.qif", writeQIF}}},
+}
+
+type exportFile struct {
+	pattern string
+	write   func(io.Writer) error
+}
+
+type exportRow struct {
+	ID          int64
+	Type        string
+	Category    string
+	Amount      Money
+	Description string
+	CreatedAt   string
+	Notes       string
+	Original    NullMoney // amount entered in another currency
+	Currency    string    // currency of Original
+}
+
+// forEachExportRow calls fn for every transaction in id order.
+func forEachExportRow(fn func(exportRow) error) error {
+	rows, err := db.Query("SELECT id, type, category, amount, description, created_at, COALESCE(notes, ''), original_amount, COALESCE(original_currency, '') FROM transactions WHERE " + ledgerScope() + " ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("query transactions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r exportRow
+		var description sql.NullString
+		if err := rows.Scan(&r.ID, &r.Type, &r.Category, &r.Amount, &description, &r.CreatedAt, &r.Notes, &r.Original, &r.Currency); err != nil {
+			return err
+		}
+		r.Description = description.String
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// fireflyColumns are the CSV columns with their Data Importer roles.
+var fireflyColumns = []struct{ header, role string }{
+	{"external_id", "external-id"},
+	{"date", "date_transaction"},
+	{"description", "description"},
+	{"amount", "amount"},
+	{"currency", "currency-code"},
+	{"category", "category-name"},
+	{"payee", "opposing-name"},
+	{"notes", "note"},
+	{"foreign_amount", "amount_foreign"},
+	{"foreign_currency", "foreign-currency-code"},
+}
+
+func writeFireflyCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(fireflyColumns))
+	for i, c := range fireflyColumns {
+		header[i] = c.header
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	err := forEachExportRow(func(r exportRow) error {
+		t, err := parseCreatedAt(r.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("transaction %d: %w", r.ID, err)
+		}
+		amount, foreign := r.Amount, r.Original.Money
+		if r.Type == "expense" {
+			amount, foreign = -amount, -foreign
+		}
+		foreignPlain, foreignCurrency := "", ""
+		if r.Original.Valid {
+			foreignPlain, foreignCurrency = lookupCurrency(r.Currency).plain(foreign), r.Currency
+		}
+		description := r.Description
+		if description == "" {
+			description = r.Category
+		}
+		return cw.Write([]string{
+			fmt.Sprintf("ayunda-%d", r.ID),
+			t.Format(dateLayout),
+			description,
+			formatMoneyPlain(amount),
+			currencyCode(),
+			r.Category,
+			r.Description,
+			r.Notes,
+			foreignPlain,
+			foreignCurrency,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeFireflyConfig writes a Data Importer configuration for the CSV of
+// writeFireflyCSV; the asset account is chosen during the import.
+func writeFireflyConfig(w io.Writer) error {
+	roles := make([]string, len(fireflyColumns))
+	doMapping := make([]bool, len(fireflyColumns))
+	for i, c := range fireflyColumns {
+		roles[i] = c.role
+	}
+	config := map[string]interface{}{
+		"version":                    3,
+		"flow":                       "file",
+		"content_type":               "csv",
+		"date":                       "Y-m-d",
+		"delimiter":                  "comma",
+		"headers":                    true,
+		"rules":                      true,
+		"add_import_tag":             true,
+		"roles":                      roles,
+		"do_mapping":                 doMapping,
+		"mapping":                    []interface{}{},
+		"duplicate_detection_method": "cell",
+		"unique_column_index":        0,
+		"unique_column_type":         "external-id",
+		"ignore_duplicate_lines":     true,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
+
+// qifText removes the line breaks that would end a QIF field early.
+func qifText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func writeQIF(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "!Account\nNAyunda\nTBank\n^\n!Type:Bank\n")
+	err := forEachExportRow(func(r exportRow) error {
+		t, err := parseCreatedAt(r.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("transaction %d: %w", r.ID, err)
+		}
+		amount, account := -r.Amount, "Expenses:"+r.Category
+		if r.Type == "income" {
+			amount, account = r.Amount, "Income:"+r.Category
+		}
+		fmt.Fprintf(bw, "D%s\nT%s\nN%d\n", t.Format("01/02/2006"), formatMoneyPlain(amount), r.ID)
+		if r.Description != "" {
+			fmt.Fprintf(bw, "P%s\n", qifText(r.Description))
+		}
+		memo := r.Notes
+		if r.Original.Valid {
+			memo = strings.TrimSpace(memo + " (" + lookupCurrency(r.Currency).plain(r.Original.Money) + " " + r.Currency + ")")
+		}
+		if memo != "" {
+			fmt.Fprintf(bw, "M%s\n", qifText(memo))
+		}
+		fmt.Fprintf(bw, "L%s\n^\n", qifText(account))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// handleExportCommand implements /export [csv|firefly|qif].
+func handleExportCommand(chatID int64, args string) {
+	name := strings.ToLower(strings.TrimSpace(args))
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		sendMessage(chatID, "Usage: /export [csv|firefly|qif]")
+		return
+	}
+	for i, file := range format.files {
+		caption := ""
+		if i == 0 {
+			caption = format.caption
+		}
+		if err := sendExportFile(chatID, file, caption); err != nil {
+			sendMessage(chatID, "Failed to export transactions.")
+			reportError("exporting transactions as "+name, err)
+			return
+		}
+	}
+}
+
+func sendExportFile(chatID int64, file exportFile, caption string) error {
+	f, err := os.CreateTemp(dataSubdir("exports"), file.pattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = file.write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return messenger.SendFile(chatID, f.Name(), caption)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+	FLOW DIAGRAM (/flow [period] [archive])
+
+	Draws where the money of a period came from and went: income categories
+	on the left flow into one budget pool, which flows out to the expense
+	categories on the right. What was not spent flows to "Saved"; spending
+	beyond the income comes in from "Savings" so both sides balance.
+*/
+
+const flowScript = "src/g_flow_chart.py"
+
+var (
+	periodYearPattern = regexp.MustCompile(`^\d{4}$`)
+	periodDatePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// parsePeriod parses a report period: nothing (this month), YYYY-MM, YYYY,
+// or a range of two dates "YYYY-MM-DD YYYY-MM-DD" (both included). It
+// returns the first instant of the period, the first instant after it and
+// a label for it.
+func parsePeriod(args string, now time.Time) (time.Time, time.Time, string, error) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		start, end := monthBounds(now)
+		return start, end, start.Format("January 2006"), nil
+	case len(fields) == 1 && summaryMonthPattern.MatchString(fields[0]):
+		t, err := time.ParseInLocation("2006-01", fields[0], appLocation())
+		if err != nil {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("invalid month %q", fields[0])
+		}
+		start, end := monthBounds(t)
+		return start, end, start.Format("January 2006"), nil
+	case len(fields) == 1 && periodYearPattern.MatchString(fields[0]):
+		t, err := time.ParseInLocation("2006", fields[0], appLocation())
+		if err != nil {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("invalid year %q", fields[0])
+		}
+		return t, t.AddDate(1, 0, 0), t.Format("2006"), nil
+	case len(fields) == 2 && periodDatePattern.MatchString(fields[0]) && periodDatePattern.MatchString(fields[1]):
+		from, err := time.ParseInLocation(dateLayout, fields[0], appLocation())
+		if err != nil {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("invalid date %q", fields[0])
+		}
+		to, err := time.ParseInLocation(dateLayout, fields[1], appLocation())
+		if err != nil {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("invalid date %q", fields[1])
+		}
+		if to.Before(from) {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("%s is before %s", fields[1], fields[0])
+		}
+		return from, to.AddDate(0, 0, 1), from.Format("2 Jan 2006") + " – " + to.Format("2 Jan 2006"), nil
+	}
+	return time.Time{}, time.Time{}, "", fmt.Errorf("invalid period %q", args)
+}
+
+// splitArchiveArg removes an "archive" (or "all") argument, which asks for
+// the archived transactions to be included.
+func splitArchiveArg(args string) (string, bool) {
+	withArchive := false
+	var rest []string
+	for _, f := range strings.Fields(args) {
+		if strings.EqualFold(f, "archive") || strings.EqualFold(f, "all") {
+			withArchive = true
+			continue
+		}
+		rest = append(rest, f)
+	}
+	return strings.Join(rest, " "), withArchive
+}
+
+type flowNode struct {
+	Name   string `json:"name"`
+	Amount Money  `json:"amount"`
+}
+
+// flowChart is the input of the flow chart script; sources and sinks both
+// add up to total.
+type flowChart struct {
+	Title   string     `json:"title"`
+	Pool    string     `json:"pool"`
+	Total   Money      `json:"total"`
+	Sources []flowNode `json:"sources"`
+	Sinks   []flowNode `json:"sinks"`
+}
+
+// transactionSource is the table to select type, category, amount and
+// created_at from, with the archived transactions or without, limited to
+// the active ledger (ledgers.go). Its amount is the line total
+// (lineTotal).
+func transactionSource(withArchive bool) string {
+	scope := ledgerScope()
+	columns := "type, category, " + lineTotal + " AS amount, created_at"
+	if withArchive {
+		return "(SELECT " + columns + " FROM transactions WHERE " + scope +
+			" UNION ALL SELECT " + columns + " FROM transactions_archive WHERE " + scope + ")"
+	}
+	return "(SELECT " + columns + " FROM transactions WHERE " + scope + ")"
+}
+
+// categoryTotals sums the amounts of one type by category in [from, to),
+// largest first.
+func categoryTotals(typ string, from time.Time, to time.Time, withArchive bool) ([]flowNode, error) {
+	rows, err := db.Query(`SELECT category, SUM(amount) AS total FROM `+transactionSource(withArchive)+`
+		WHERE type = ? AND created_at >= ? AND created_at < ?
+		GROUP BY category HAVING total > 0 ORDER BY total DESC, category`,
+		typ, from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []flowNode
+	for rows.Next() {
+		var n flowNode
+		if err := rows.Scan(&n.Name, &n.Amount); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, rows.Err()
+}
+
+// buildFlowChart collects the flows of [from, to).
+func buildFlowChart(from time.Time, to time.Time, withArchive bool) (flowChart, Money, Money, error) {
+	var chart flowChart
+	sources, err := categoryTotals("income", from, to, withArchive)
+	if err != nil {
+		return chart, 0, 0, err
+	}
+	sinks, err := categoryTotals("expense", from, to, withArchive)
+	if err != nil {
+		return chart, 0, 0, err
+	}
+	var income, expense Money
+	for _, n := range sources {
+		income += n.Amount
+	}
+	for _, n := range sinks {
+		expense += n.Amount
+	}
+
+	chart.Pool = "Budget"
+	chart.Sources, chart.Sinks = sources, sinks
+	chart.Total = income
+	if income > expense {
+		chart.Sinks = append(chart.Sinks, flowNode{Name: "Saved", Amount: income - expense})
+	} else if expense > income {
+		chart.Sources = append(chart.Sources, flowNode{Name: "Savings", Amount: expense - income})
+		chart.Total = expense
+	}
+	return chart, income, expense, nil
+}
+
+func showFlow(chatID int64, args string) {
+	period, withArchive := splitArchiveArg(args)
+	from, to, label, err := parsePeriod(period, appClock.Now())
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v.\nUsage: /flow [YYYY-MM | YYYY | YYYY-MM-DD YYYY-MM-DD] [archive]", err))
+		return
+	}
+
+	chart, income, expense, err := buildFlowChart(from, to, withArchive)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the transactions.")
+		reportError("loading the flow chart", err)
+		return
+	}
+	if chart.Total == 0 {
+		sendMessage(chatID, fmt.Sprintf("No transactions in %s.", label))
+		return
+	}
+	chart.Title = "Money flow, " + label
+	caption := fmt.Sprintf("💸 %s\nIncome: %s\nExpense: %s\nBalance: %s", label, formatMoney(income), formatMoney(expense), formatMoney(income-expense))
+	sendChart(chatID, flowScript, chart, caption)
+}
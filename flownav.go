@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	BACK NAVIGATION
+
+	The steps of /add and /edit have a ⬅️ Back button that returns to the
+	previous step, so a mis-tap does not need /cancel and a fresh start:
+
+		/add:  type → category → amount → description
+		/edit: field → new value
+
+	Back edits the message holding the button into the previous step, with
+	its keyboard, and what was chosen there can be chosen again. Only the
+	message of the current step goes back (state.PromptMessageID); the
+	buttons of a step already left do nothing.
+*/
+
+// flowBack is the callback data of the Back buttons.
+const flowBack = "flow:back"
+
+func backRow() []InlineKeyboardButton {
+	return []InlineKeyboardButton{{Text: "⬅️ Back", CallbackData: flowBack}}
+}
+
+func backKeyboard() InlineKeyboardMarkup {
+	return buildKeyboard([][]InlineKeyboardButton{backRow()})
+}
+
+const typePrompt = "Please choose the type of transaction:"
+
+func typeKeyboard() InlineKeyboardMarkup {
+	return buildKeyboard([][]InlineKeyboardButton{{
+		{Text: "Income", CallbackData: "income"},
+		{Text: "Expense", CallbackData: "expense"},
+	}})
+}
+
+func categoryPrompt(state *TransactionState) string {
+	return fmt.Sprintf("You selected %s. Choose a category:", state.TransactionType)
+}
+
+// categoryKeyboard lists the categories, one per row, then the Back button.
+func categoryKeyboard() InlineKeyboardMarkup {
+	buttons := make([][]InlineKeyboardButton, 0)
+	for _, category := range activeCategories() {
+		buttons = append(buttons, []InlineKeyboardButton{
+			{Text: category, CallbackData: category},
+		})
+	}
+	return buildKeyboard(append(buttons, backRow()))
+}
+
+func amountPrompt(state *TransactionState) string {
+	return fmt.Sprintf("Selected category: %s. Enter the transaction amount.", state.Category)
+}
+
+const descriptionPrompt = "Enter a description for the transaction (max 100 characters)."
+
+// editValueSteps maps the fields of the /edit menu to the step asking for
+// their new value.
+var editValueSteps = map[string]string{
+	"type":        "SELECT_EDIT_TYPE",
+	"category":    "SELECT_EDIT_CATEGORY",
+	"amount":      "ENTER_EDIT_AMOUNT",
+	"quantity":    "ENTER_EDIT_QUANTITY",
+	"description": "ENTER_EDIT_DESCRIPTION",
+	"notes":       "ENTER_EDIT_NOTES",
+	"tax":         "ENTER_EDIT_TAX",
+	"deductible":  "SELECT_EDIT_DEDUCTIBLE",
+	"is_outlier":  "SELECT_EDIT_IS_OUTLIER",
+}
+
+// editValuePrompt returns the question of an /edit value step and its
+// keyboard: the choices, if any, then Back and Cancel.
+func editValuePrompt(step string) (string, InlineKeyboardMarkup) {
+	navigation := []InlineKeyboardButton{
+		{Text: "⬅️ Back", CallbackData: flowBack},
+		{Text: "Cancel", CallbackData: "edit_cancel"},
+	}
+	switch step {
+	case "SELECT_EDIT_TYPE":
+		return "Select new type:", buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "Income", CallbackData: "income"},
+			{Text: "Expense", CallbackData: "expense"},
+		}, navigation})
+	case "SELECT_EDIT_CATEGORY":
+		buttons := make([][]InlineKeyboardButton, 0)
+		for _, category := range activeCategories() {
+			buttons = append(buttons, []InlineKeyboardButton{
+				{Text: category, CallbackData: category},
+			})
+		}
+		return "Select new category:", buildKeyboard(append(buttons, navigation))
+	case "ENTER_EDIT_AMOUNT":
+		return "Enter new amount (positive number):", backKeyboard()
+	case "ENTER_EDIT_QUANTITY":
+		return "Enter new quantity (positive number):", backKeyboard()
+	case "ENTER_EDIT_DESCRIPTION":
+		return "Enter new description (max 100 characters):", backKeyboard()
+	case "ENTER_EDIT_NOTES":
+		return fmt.Sprintf("Enter the notes (max %d characters), e.g. warranty info, an order number or a link. Send - to clear them.", maxNotesLength), backKeyboard()
+	case "ENTER_EDIT_TAX":
+		return "Enter the VAT or other tax of this transaction, as an amount or a rate of its amount such as 11%. Send - to clear it.", backKeyboard()
+	case "SELECT_EDIT_DEDUCTIBLE":
+		return "Tax-deductible?", buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "Yes", CallbackData: "deductible:true"},
+			{Text: "No", CallbackData: "deductible:false"},
+		}, navigation})
+	default: // SELECT_EDIT_IS_OUTLIER
+		return "Mark as outlier?", buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "Yes", CallbackData: "is_outlier:true"},
+			{Text: "No", CallbackData: "is_outlier:false"},
+		}, navigation})
+	}
+}
+
+// isEditValueStep reports whether step asks for the new value of a field
+// in /edit.
+func isEditValueStep(step string) bool {
+	return (strings.HasPrefix(step, "SELECT_EDIT_") || strings.HasPrefix(step, "ENTER_EDIT_")) &&
+		step != "SELECT_EDIT_FIELD" && step != "ENTER_EDIT_ID"
+}
+
+// processFlowBack handles a Back button: the message shows the previous
+// step again.
+func processFlowBack(callback *CallbackQuery, state *TransactionState) {
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	if state.PromptMessageID != 0 && messageID != state.PromptMessageID {
+		return
+	}
+	switch {
+	case state.Step == "SELECT_CATEGORY":
+		state.Step = "SELECT_TYPE"
+		editMessageWithKeyboard(chatID, messageID, typePrompt, typeKeyboard())
+	case state.Step == "ENTER_AMOUNT":
+		state.Step = "SELECT_CATEGORY"
+		editMessageWithKeyboard(chatID, messageID, categoryPrompt(state), categoryKeyboard())
+	case state.Step == "ENTER_DESCRIPTION":
+		state.Step = "ENTER_AMOUNT"
+		editMessageWithKeyboard(chatID, messageID, amountPrompt(state), backKeyboard())
+	case isEditValueStep(state.Step):
+		state.Step = "SELECT_EDIT_FIELD"
+		details, keyboard := editFieldMenu(state)
+		editMessageWithKeyboard(chatID, messageID, details, keyboard)
+	default:
+		return
+	}
+	state.PromptMessageID = messageID
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	CASH FLOW FORECAST (/forecast)
+
+	The end-of-month balance is projected from this month's balance so far,
+	the upcoming entries that have not happened yet this month, and the
+	average daily variable spending. The optimistic/pessimistic band is one
+	standard deviation of daily spending over the last 30 days, scaled to
+	the days left.
+*/
+
+// upcomingEntry is a transaction expected later this month.
+type upcomingEntry struct {
+	Label  string
+	Type   string // "income" or "expense"
+	Amount Money
+	Day    int // day of month
+}
+
+// forecastSources list where upcoming entries come from. Each source gets
+// the current time and returns the entries still expected this month.
+var forecastSources = []func(now time.Time) ([]upcomingEntry, error){
+	upcomingRecurring,
+	upcomingBills,
+	upcomingInvoices,
+	upcomingSubscriptions,
+}
+
+// knownRecurringKeys returns the keys of transactions that a forecast
+// source already projects (bills, subscriptions), so they are neither
+// detected as recurring nor counted as variable spending.
+func knownRecurringKeys() map[string]bool {
+	keys := make(map[string]bool)
+	addBillKeys(keys)
+	addSubscriptionKeys(keys)
+	return keys
+}
+
+// recurringPattern is a transaction that happened exactly once a month in
+// most of the previous months.
+type recurringPattern struct {
+	Type        string
+	Category    string
+	Description string
+	Amount      Money
+	Day         int
+}
+
+func (p recurringPattern) key() string {
+	return p.Type + "|" + p.Category + "|" + strings.ToLower(strings.TrimSpace(p.Description))
+}
+
+const recurringLookbackMonths = 3
+
+type ledgerEntry struct {
+	ID          int64
+	Type        string
+	Category    string
+	Amount      Money
+	Description string
+	CreatedAt   time.Time
+}
+
+// loadEntries returns the transactions in [from, to) ordered by time, each
+// with its line total as the amount.
+func loadEntries(from time.Time, to time.Time) ([]ledgerEntry, error) {
+	rows, err := db.Query("SELECT id, type, category, "+lineTotal+", description, created_at FROM transactions WHERE created_at >= ? AND created_at < ? AND "+ledgerScope()+" ORDER BY created_at, id",
+		from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ledgerEntry
+	for rows.Next() {
+		var e ledgerEntry
+		var description sql.NullString
+		if err := rows.Scan(&e.ID, &e.Type, &e.Category, &e.Amount, &description, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Description = description.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func entryKey(e ledgerEntry) string {
+	return recurringPattern{Type: e.Type, Category: e.Category, Description: e.Description}.key()
+}
+
+// detectRecurring finds transactions that occurred exactly once in at least
+// two of the months before now's month.
+func detectRecurring(now time.Time) ([]recurringPattern, error) {
+	monthStart, _ := monthBounds(now)
+	entries, err := loadEntries(monthStart.AddDate(0, -recurringLookbackMonths, 0), monthStart)
+	if err != nil {
+		return nil, err
+	}
+
+	type occurrence struct {
+		amounts []float64
+		days    []int
+		perMon  map[string]int
+		sample  ledgerEntry
+	}
+	byKey := make(map[string]*occurrence)
+	for _, e := range entries {
+		k := entryKey(e)
+		o, ok := byKey[k]
+		if !ok {
+			o = &occurrence{perMon: make(map[string]int), sample: e}
+			byKey[k] = o
+		}
+		o.amounts = append(o.amounts, e.Amount.Float())
+		o.days = append(o.days, e.CreatedAt.Day())
+		o.perMon[e.CreatedAt.Format("2006-01")]++
+	}
+
+	var patterns []recurringPattern
+	for _, o := range byKey {
+		if len(o.perMon) < 2 {
+			continue
+		}
+		once := true
+		for _, n := range o.perMon {
+			if n != 1 {
+				once = false
+				break
+			}
+		}
+		if !once {
+			continue
+		}
+		sort.Ints(o.days)
+		patterns = append(patterns, recurringPattern{
+			Type:        o.sample.Type,
+			Category:    o.sample.Category,
+			Description: o.sample.Description,
+			Amount:      moneyFromFloat(mean(o.amounts)),
+			Day:         o.days[len(o.days)/2],
+		})
+	}
+	sort.Slice(patterns, func(i, j int) bool { return patterns[i].Day < patterns[j].Day })
+	return patterns, nil
+}
+
+// upcomingRecurring returns the recurring transactions not yet seen this month.
+func upcomingRecurring(now time.Time) ([]upcomingEntry, error) {
+	patterns, err := detectRecurring(now)
+	if err != nil || len(patterns) == 0 {
+		return nil, err
+	}
+	monthStart, monthEnd := monthBounds(now)
+	entries, err := loadEntries(monthStart, monthEnd)
+	if err != nil {
+		return nil, err
+	}
+	// bills and subscriptions are forecast from their own due dates
+	seen := knownRecurringKeys()
+	for _, e := range entries {
+		seen[entryKey(e)] = true
+	}
+
+	var upcoming []upcomingEntry
+	for _, p := range patterns {
+		if seen[p.key()] {
+			continue
+		}
+		label := p.Category
+		if p.Description != "" {
+			label += " (" + p.Description + ")"
+		}
+		day := p.Day
+		if day < now.Day() {
+			day = now.Day() // overdue, still expected
+		}
+		if last := daysInMonth(now); day > last {
+			day = last
+		}
+		upcoming = append(upcoming, upcomingEntry{Label: label, Type: p.Type, Amount: p.Amount, Day: day})
+	}
+	return upcoming, nil
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func stddev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := mean(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - m) * (v - m)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
+
+// cashForecast is the projected end-of-month position.
+type cashForecast struct {
+	Income, Expense Money // month to date
+	DailySpend      Money // average variable spending per day this month
+	DaysLeft        int
+	Upcoming        []upcomingEntry
+	Projected       Money
+	Optimistic      Money
+	Pessimistic     Money
+}
+
+func buildForecast(now time.Time) (*cashForecast, error) {
+	monthStart, monthEnd := monthBounds(now)
+	_, dayEnd := dayBounds(now)
+	f := &cashForecast{DaysLeft: int(monthEnd.Sub(dayEnd).Hours()/24 + 0.5)}
+
+	var err error
+	if f.Income, err = totalBetween("income", monthStart, monthEnd); err != nil {
+		return nil, err
+	}
+	if f.Expense, err = totalBetween("expense", monthStart, monthEnd); err != nil {
+		return nil, err
+	}
+
+	for _, source := range forecastSources {
+		entries, err := source(now)
+		if err != nil {
+			return nil, err
+		}
+		f.Upcoming = append(f.Upcoming, entries...)
+	}
+	sort.SliceStable(f.Upcoming, func(i, j int) bool { return f.Upcoming[i].Day < f.Upcoming[j].Day })
+
+	// Variable spending leaves out the recurring expenses, bills and
+	// subscriptions already paid, so they are not projected again for every remaining day.
+	patterns, err := detectRecurring(now)
+	if err != nil {
+		return nil, err
+	}
+	recurring := knownRecurringKeys()
+	for _, p := range patterns {
+		recurring[p.key()] = true
+	}
+	history, err := loadEntries(dayEnd.AddDate(0, 0, -30), dayEnd)
+	if err != nil {
+		return nil, err
+	}
+	daily := make(map[string]float64)
+	var monthVariable Money
+	for _, e := range history {
+		if e.Type != "expense" || recurring[entryKey(e)] {
+			continue
+		}
+		daily[e.CreatedAt.Format("2006-01-02")] += e.Amount.Float()
+		if e.CreatedAt.Format("2006-01") == now.Format("2006-01") {
+			monthVariable += e.Amount
+		}
+	}
+	samples := make([]float64, 0, 30)
+	for d := dayEnd.AddDate(0, 0, -30); d.Before(dayEnd); d = d.AddDate(0, 0, 1) {
+		samples = append(samples, daily[d.Format("2006-01-02")])
+	}
+	f.DailySpend = monthVariable.Div(now.Day())
+
+	var upcomingNet Money
+	for _, u := range f.Upcoming {
+		if u.Type == "income" {
+			upcomingNet += u.Amount
+		} else {
+			upcomingNet -= u.Amount
+		}
+	}
+	left := float64(f.DaysLeft)
+	variable := monthVariable.Float() / float64(now.Day()) * left
+	band := stddev(samples) * math.Sqrt(left)
+
+	base := (f.Income - f.Expense + upcomingNet).Float()
+	f.Projected = moneyFromFloat(base - variable)
+	f.Optimistic = moneyFromFloat(base - math.Max(variable-band, 0))
+	f.Pessimistic = moneyFromFloat(base - (variable + band))
+	return f, nil
+}
+
+func showForecast(chatID int64) {
+	now := appClock.Now()
+	f, err := buildForecast(now)
+	if err != nil {
+		sendMessage(chatID, "Failed to build the forecast.")
+		reportError("building the forecast", err)
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📈 Forecast for %s (day %d of %d)\n\n", now.Format("January 2006"), now.Day(), daysInMonth(now)))
+	sb.WriteString(fmt.Sprintf("Balance so far: %s (income %s, expenses %s)\n", formatMoney(f.Income-f.Expense), formatMoney(f.Income), formatMoney(f.Expense)))
+	sb.WriteString(fmt.Sprintf("Average daily spending: %s\n", formatMoney(f.DailySpend)))
+	if len(f.Upcoming) > 0 {
+		sb.WriteString("\nStill expected this month:\n")
+		for _, u := range f.Upcoming {
+			sign := "-"
+			if u.Type == "income" {
+				sign = "+"
+			}
+			sb.WriteString(fmt.Sprintf("• %d %s %s %s%s\n", u.Day, now.Format("Jan"), u.Label, sign, formatMoney(u.Amount)))
+		}
+	}
+	sb.WriteString(fmt.Sprintf("\nProjected end-of-month balance: %s\n", formatMoney(f.Projected)))
+	sb.WriteString(fmt.Sprintf("Range: %s (pessimistic) to %s (optimistic)", formatMoney(f.Pessimistic), formatMoney(f.Optimistic)))
+	sendMessage(chatID, sb.String())
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+	FUZZING of user input.
+
+	Everything a chat can send ends up in a parser: command arguments,
+	amounts and dates typed during a flow, and the data of pressed buttons
+	(which a modified client can set to anything). Each Fuzz function below
+	feeds one kind of input to the parsers and, through a harness, to the
+	handlers. "go test" runs the seeds; fuzz one target with e.g.
+
+	go test -run '^$' -fuzz FuzzButtonData -fuzztime 30s
+*/
+
+// fuzzCommands are the commands whose arguments are fuzzed through the
+// chat; admin commands and those that talk to other services are left out.
+var fuzzCommands = []string{
+	"summary", "edit", "delete", "budget", "eod", "portfolio", "bill", "subscription",
+	"week", "weekstart", "charts", "indicators", "archive", "view", "top", "report", "schedules", "close", "reconcile", "ref", "search", "suggestbudgets", "rules", "recategorize", "locate", "map", "insights", "ask", "streaks", "ledger", "taxreport", "invoice", "mileage", "perdiem", "reimburse", "reimbursements", "payment", "payments", "wallet",
+}
+
+// newFuzzHarness adds the seeds and returns a harness for the whole fuzz
+// run, with the handlers' logging silenced.
+func newFuzzHarness(f *testing.F, seeds ...string) *harness {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	// the handlers log every rejected input
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	return newHarness(f)
+}
+
+// fuzzInput runs one input; a panic can leave a flow half done, so the
+// next input starts clean.
+func fuzzInput(f *testing.F, run func(input string)) {
+	f.Fuzz(func(t *testing.T, input string) {
+		defer delete(userStates, harnessUserID)
+		run(input)
+	})
+}
+
+func FuzzDateParsers(f *testing.F) {
+	newFuzzHarness(f, "2026-10-01", "2026-10-01 08:30:00", "2026-W41", "-1", "2026-01 archive", "3", "2026-01-01 2026-01-31", "2026")
+	fuzzInput(f, func(input string) {
+		now := appClock.Now()
+		parseDateFlag(input)
+		parseWeekArg(input, now, time.Monday)
+		parseWeekArg(input, now, time.Sunday)
+		parseSummaryArgs(input, now)
+		parseArchiveYears(input)
+		clockStartingAt(input)
+	})
+}
+
+func FuzzCommandArguments(f *testing.F) {
+	h := newFuzzHarness(f, "", "1", "off", "Food 600000", "add Internet 350000 15 Utilities 3", "add Netflix 54000 monthly 2026-02-01", "buy BBCA 100 9000", "-1", "2026-W05", "on 21:00", "auto 2", "5 2026-01", "add food every sunday 20:00", "pause 1", "2026-09")
+	seedLunch()
+	fuzzInput(f, func(input string) {
+		for _, command := range fuzzCommands {
+			h.send("/" + command + " " + input)
+		}
+	})
+}
+
+func FuzzAmountsAndDescriptions(f *testing.F) {
+	h := newFuzzHarness(f, "25000", "25000.50", "1e3", "-5", "0", "lunch with friends", "+25000 grab")
+	fuzzInput(f, func(input string) {
+		h.send("/add")
+		h.press("Expense")
+		h.press("Food")
+		h.send(input)
+		h.send(input)
+		h.send("/edit 1")
+		h.press("Edit Quantity")
+		h.send(input)
+		delete(userStates, h.user.ID)
+		h.send(input)
+	})
+}
+
+func FuzzButtonData(f *testing.F) {
+	h := newFuzzHarness(f, "income", "expense", "Food", "edit_field:amount", "delete_confirm", "bill:paid:1:2026-02-01", "sub:cancel:1", "view:duplicate:1", "amend:yes:1", "confirm:0000", "sugg:all:3", "sugg:500000:Food", "recat:apply:20260101:20260201", "rec:adjust:1000", "rec:review:0", "report:period:this_month", "report:cat:Food", "split:toggle:2", "split:settle:1:2", "true")
+	seedLunch()
+	fuzzInput(f, func(input string) {
+		for _, start := range []string{"/add", "/edit 1", "/delete 1", ""} {
+			if start != "" {
+				h.send(start)
+			}
+			h.pressData(input)
+		}
+		h.send("/add")
+		h.press("Income")
+		h.pressData(input)
+	})
+}
+
+func FuzzRawUpdates(f *testing.F) {
+	newFuzzHarness(f,
+		`{"update_id":1,"edited_message":{"message_id":1,"from":{"id":1},"chat":{"id":1,"type":"private"},"text":"lunch"}}`,
+		`{"update_id":2,"my_chat_member":{"chat":{"id":1,"type":"private"},"from":{"id":1},"new_chat_member":{"status":"kicked"}}}`,
+		`{"update_id":3,"message":{"message_id":2,"from":{"id":1},"chat":{"id":1,"type":"private"},"successful_payment":{"currency":"XTR","total_amount":1}}}`,
+		`{"update_id":4,"message_reaction":{}}`,
+	)
+	fuzzInput(f, func(input string) {
+		var update Update
+		// dispatched without handleUpdate, whose recovery would hide panics
+		if json.Unmarshal([]byte(input), &update) == nil {
+			dispatchUpdate(update)
+		}
+	})
+}
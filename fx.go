@@ -0,0 +1,344 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	FOREIGN CURRENCY ENTRIES (/fx)
+
+	An amount can be entered in another currency wherever an amount is
+	typed to log a transaction: "20USD lunch", "/add 20USD Food" or
+	"20 USD" at the amount step of /add. The bot converts it at the day's
+	rate into the currency of the ledger, stores the converted amount as
+	the transaction's amount, and keeps the original amount, its currency
+	and the rate next to it, shown by /view and the CSV and Firefly III
+	exports.
+
+	Rates come from the endpoint in fx.rate_url / FX_RATE_URL when
+	configured, {from}, {to} and {date} (YYYY-MM-DD) being replaced and
+	the answer being {"rate": 16250.5}, and are kept in fx_rates, so each
+	pair is fetched once a day. Without an endpoint, or while it fails, a
+	rate entered by hand is used:
+
+	/fx                  the latest rates
+	/fx USD 16250        1 USD is worth 16,250 in the ledger's currency today
+
+	falling back to the latest earlier rate of the pair, the reply saying
+	from which day it is. Changing the amount of a converted transaction
+	afterwards drops the original amount, which no longer matches it.
+*/
+
+const fxUsage = "Usage:\n/fx - show the latest exchange rates\n/fx <currency> <rate> - set today's rate of a currency into the ledger's, e.g. /fx USD 16250\n\nEnter an amount in another currency as 20USD or 20 USD."
+
+// fxAmountTrigger forgets the original amount of a transaction whose
+// amount changed; migrateMoney drops it while converting the amounts.
+const fxAmountTrigger = `CREATE TRIGGER IF NOT EXISTS transactions_fx_amount_update AFTER UPDATE OF amount ON transactions
+	WHEN OLD.amount IS NOT NEW.amount AND NEW.original_currency IS NOT NULL
+	BEGIN
+		UPDATE transactions SET original_amount = NULL, original_currency = NULL, fx_rate = NULL WHERE id = NEW.id;
+	END`
+
+// foreignAmount is an amount entered in another currency and the rate
+// it was converted at.
+type foreignAmount struct {
+	Amount   Money
+	Currency string
+	Rate     float64
+	RateDay  string // day of the rate, "2006-01-02"
+}
+
+var (
+	foreignAmountPattern       = regexp.MustCompile(`^\+?(\d+(?:\.\d+)?)\s*([A-Za-z]{3})$`)
+	foreignAmountPrefixPattern = regexp.MustCompile(`^\+?([A-Za-z]{3})\s*(\d+(?:\.\d+)?)$`)
+)
+
+// parseForeignAmount reads an amount followed or preceded by a currency
+// code, e.g. "20USD", "20.5 eur" or "USD 20". The code must be one of
+// knownCurrencies or have a rate set with /fx, so "3pcs" is not taken
+// for an amount.
+func parseForeignAmount(s string) (Money, string, bool) {
+	s = strings.TrimSpace(s)
+	var number, code string
+	if m := foreignAmountPattern.FindStringSubmatch(s); m != nil {
+		number, code = m[1], m[2]
+	} else if m := foreignAmountPrefixPattern.FindStringSubmatch(s); m != nil {
+		code, number = m[1], m[2]
+	} else {
+		return 0, "", false
+	}
+	amount, err := parseMoney(number)
+	if err != nil || amount <= 0 {
+		return 0, "", false
+	}
+	code = strings.ToUpper(code)
+	if _, known := knownCurrencies[code]; !known {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM fx_rates WHERE currency = ?", code).Scan(&n); err != nil || n == 0 {
+			return 0, "", false
+		}
+	}
+	return amount, code, true
+}
+
+// errNotAmount is returned by parseEntryAmount for text that is not an
+// amount at all, as opposed to one that cannot be converted.
+var errNotAmount = errors.New("not an amount")
+
+// parseEntryAmount reads the amount of a new transaction, converting it
+// when it is in another currency.
+func parseEntryAmount(text string, now time.Time) (Money, *foreignAmount, error) {
+	if amount, err := parseMoney(text); err == nil {
+		if amount <= 0 {
+			return 0, nil, errNotAmount
+		}
+		return amount, nil, nil
+	}
+	amount, code, ok := parseForeignAmount(text)
+	if !ok {
+		return 0, nil, errNotAmount
+	}
+	return convertForeign(amount, code, now)
+}
+
+// entryCurrency returns the currency new transactions are kept in: the
+// active ledger's, else the instance's, or "" when neither is set.
+func entryCurrency() (string, error) {
+	cfg := currentConfig()
+	code, err := ledgerCurrency(activeLedgerID())
+	if err != nil || code != "" {
+		return code, err
+	}
+	if cfg != nil {
+		return cfg.Currency, nil
+	}
+	return "", nil
+}
+
+// convertForeign converts amount in code into the currency of the
+// ledger at the rate of day. It returns a nil foreignAmount when code is
+// the ledger's currency already.
+func convertForeign(amount Money, code string, day time.Time) (Money, *foreignAmount, error) {
+	base, err := entryCurrency()
+	if err != nil {
+		return 0, nil, err
+	}
+	if strings.EqualFold(code, base) {
+		return amount, nil, nil
+	}
+	if base == "" {
+		return 0, nil, fmt.Errorf("the ledger has no currency to convert %s into, set one with /ledger currency <code>", code)
+	}
+	rate, rateDay, err := exchangeRate(code, base, day)
+	if err != nil {
+		return 0, nil, err
+	}
+	return amount.Mul(rate), &foreignAmount{Amount: amount, Currency: code, Rate: rate, RateDay: rateDay}, nil
+}
+
+// exchangeRate returns the rate of from into to on day and the day the
+// rate is from: the stored rate of that day, else the endpoint's, else
+// the latest stored one before it.
+func exchangeRate(from, to string, day time.Time) (float64, string, error) {
+	cfg := currentConfig()
+	date := day.Format(dateLayout)
+	var rate float64
+	err := db.QueryRow("SELECT rate FROM fx_rates WHERE currency = ? AND base = ? AND day = ?", from, to, date).Scan(&rate)
+	if err == nil {
+		return rate, date, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, "", err
+	}
+	if cfg != nil && cfg.FXRateURL != "" {
+		rate, err := fetchRate(cfg.FXRateURL, from, to, date)
+		if err == nil {
+			if err := saveRate(from, to, date, rate, "fetched"); err != nil {
+				reportError("saving an exchange rate", err)
+			}
+			return rate, date, nil
+		}
+		log.Printf("Failed to fetch the %s/%s rate: %v", from, to, err)
+	}
+	var rateDay string
+	err = db.QueryRow("SELECT rate, day FROM fx_rates WHERE currency = ? AND base = ? AND day < ? ORDER BY day DESC LIMIT 1", from, to, date).Scan(&rate, &rateDay)
+	if err == sql.ErrNoRows {
+		return 0, "", fmt.Errorf("no rate of %s into %s yet, set today's with /fx %s <rate>", from, to, from)
+	}
+	return rate, rateDay, err
+}
+
+var fxClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchRate asks the rate endpoint, which answers {"rate": 16250.5}.
+func fetchRate(urlTemplate, from, to, date string) (float64, error) {
+	u := strings.NewReplacer("{from}", url.QueryEscape(from), "{to}", url.QueryEscape(to), "{date}", date).Replace(urlTemplate)
+	resp, err := fxClient.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("rate request for %s/%s: HTTP %d", from, to, resp.StatusCode)
+	}
+	var quote struct {
+		Rate float64 `json:"rate"`
+	}
+	if err := json.Unmarshal(body, &quote); err != nil || quote.Rate <= 0 {
+		return 0, fmt.Errorf("invalid rate response for %s/%s", from, to)
+	}
+	return quote.Rate, nil
+}
+
+func saveRate(from, to, date string, rate float64, source string) error {
+	_, err := db.Exec(`INSERT INTO fx_rates (currency, base, day, rate, source) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(currency, base, day) DO UPDATE SET rate = excluded.rate, source = excluded.source`, from, to, date, rate, source)
+	return err
+}
+
+// setTransactionForeign records the original amount of transaction id.
+func setTransactionForeign(id int64, f *foreignAmount) error {
+	_, err := execCached("UPDATE transactions SET original_amount = ?, original_currency = ?, fx_rate = ? WHERE id = ?", f.Amount, f.Currency, f.Rate, id)
+	return err
+}
+
+// formatForeign writes an amount in its own currency, e.g. "$20.00".
+func formatForeign(amount Money, code string) string {
+	f := lookupCurrency(code)
+	return f.format(amount, displayCurrency.get().sep, false)
+}
+
+// foreignNote describes a conversion for the confirmation of an entry.
+func foreignNote(f *foreignAmount, day time.Time) string {
+	note := fmt.Sprintf("💱 Entered as %s at %s", formatForeign(f.Amount, f.Currency), formatRate(f.Rate))
+	if f.RateDay != day.Format(dateLayout) {
+		note += fmt.Sprintf(", the rate of %s", f.RateDay)
+	}
+	return note + "."
+}
+
+// formatRate writes a rate with the separators of the locale and the
+// decimals it needs, e.g. "16,250" or "0.0000615".
+func formatRate(rate float64) string {
+	sep := displayCurrency.get().sep
+	whole, frac, _ := strings.Cut(strconv.FormatFloat(rate, 'f', -1, 64), ".")
+	if rate >= 1 && len(frac) > 4 {
+		frac = strings.TrimRight(frac[:4], "0")
+	}
+	if frac == "" {
+		return groupThousands(whole, sep.group)
+	}
+	return groupThousands(whole, sep.group) + sep.decimal + frac
+}
+
+// originalAmountLine describes the original amount of transaction id for
+// /view, or returns "" when it was entered in the ledger's currency.
+func originalAmountLine(table string, id int64) string {
+	var amount NullMoney
+	var code sql.NullString
+	var rate sql.NullFloat64
+	err := db.QueryRow("SELECT original_amount, original_currency, fx_rate FROM "+table+" WHERE id = ?", id).Scan(&amount, &code, &rate)
+	if err != nil || !amount.Valid || !code.Valid {
+		return ""
+	}
+	return fmt.Sprintf("Original: %s at %s", formatForeign(amount.Money, code.String), formatRate(rate.Float64))
+}
+
+func handleFXCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	switch len(fields) {
+	case 0:
+		showRates(chatID)
+	case 2:
+		setManualRate(chatID, fields[0], fields[1])
+	default:
+		sendMessage(chatID, fxUsage)
+	}
+}
+
+func setManualRate(chatID int64, codeArg, rateArg string) {
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+	code := strings.ToUpper(codeArg)
+	if !currencyCodePattern.MatchString(code) {
+		sendMessage(chatID, fmt.Sprintf("%q is not a currency code such as USD.\n\n%s", codeArg, fxUsage))
+		return
+	}
+	rate, err := strconv.ParseFloat(strings.ReplaceAll(rateArg, ",", ""), 64)
+	if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+		sendMessage(chatID, fmt.Sprintf("Invalid rate %q.\n\n%s", rateArg, fxUsage))
+		return
+	}
+	base, err := entryCurrency()
+	if err != nil {
+		sendMessage(chatID, "Failed to read the ledger currency.")
+		reportError("reading the ledger currency", err)
+		return
+	}
+	if base == "" {
+		sendMessage(chatID, "The ledger has no currency to convert into. Set one with /ledger currency <code> first.")
+		return
+	}
+	if code == base {
+		sendMessage(chatID, fmt.Sprintf("%s is the ledger's currency already.", code))
+		return
+	}
+	today := appClock.Now().Format(dateLayout)
+	if err := saveRate(code, base, today, rate, "manual"); err != nil {
+		sendMessage(chatID, "Failed to save the rate.")
+		reportError("saving an exchange rate", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("💱 1 %s = %s %s today. Enter amounts as 20%s.", code, formatRate(rate), base, code))
+}
+
+func showRates(chatID int64) {
+	rows, err := db.Query(`SELECT r.currency, r.base, r.day, r.rate, r.source FROM fx_rates r
+		WHERE r.day = (SELECT MAX(day) FROM fx_rates WHERE currency = r.currency AND base = r.base)
+		ORDER BY r.base, r.currency`)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the exchange rates.")
+		reportError("loading exchange rates", err)
+		return
+	}
+	defer rows.Close()
+	lines := []string{"💱 Latest exchange rates:"}
+	for rows.Next() {
+		var code, base, day, source string
+		var rate float64
+		if err := rows.Scan(&code, &base, &day, &rate, &source); err != nil {
+			rows.Close()
+			sendMessage(chatID, "Failed to load the exchange rates.")
+			reportError("loading exchange rates", err)
+			return
+		}
+		lines = append(lines, fmt.Sprintf("1 %s = %s %s (%s, %s)", code, formatRate(rate), base, day, source))
+	}
+	if err := rows.Err(); err != nil {
+		sendMessage(chatID, "Failed to load the exchange rates.")
+		reportError("loading exchange rates", err)
+		return
+	}
+	if len(lines) == 1 {
+		lines = []string{"No exchange rates yet."}
+	}
+	sendMessage(chatID, strings.Join(lines, "\n")+"\n\n"+fxUsage)
+}
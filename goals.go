@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	SAVINGS GOALS (/goals)
+
+	A goal is a name and a target amount; money is put towards it with
+	contributions, typed or made by the round-up rule (roundup.go):
+
+	/goals                              every goal with its progress
+	/goals add Emergency fund 10000000  create a goal
+	/goals add Laptop 15000000 by 2026-12-31
+	                                    create a goal with a deadline
+	/goals deadline Laptop 2027-03-31   set or change the deadline, or "off"
+	/goals save Emergency fund 500000   put money towards it
+	/goals remove Emergency fund        delete it and its contributions
+
+	Contributions are kept apart from the transactions: setting money
+	aside is not spending it, so the reports and budgets ignore them.
+	Deadlines also show up in the calendar feed (calendar.go).
+*/
+
+const goalsUsage = "Usage:\n/goals - show the goals\n/goals add <name> <target> [by YYYY-MM-DD] - create a goal\n/goals deadline <name> YYYY-MM-DD|off - set the deadline\n/goals save <name> <amount> - put money towards a goal\n/goals remove <name> - delete a goal"
+
+// savingsGoal is a goal with the sum of its contributions.
+type savingsGoal struct {
+	ID       int64
+	Name     string
+	Target   Money
+	Saved    Money
+	Deadline sql.NullString // YYYY-MM-DD
+}
+
+func loadSavingsGoals() ([]savingsGoal, error) {
+	rows, err := db.Query(`SELECT g.id, g.name, g.target, COALESCE(SUM(c.amount), 0), g.deadline FROM savings_goals g
+		LEFT JOIN goal_contributions c ON c.goal_id = g.id GROUP BY g.id ORDER BY g.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var goals []savingsGoal
+	for rows.Next() {
+		var g savingsGoal
+		if err := rows.Scan(&g.ID, &g.Name, &g.Target, &g.Saved, &g.Deadline); err != nil {
+			return nil, err
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+// findSavingsGoal looks a goal up by id, or sql.ErrNoRows.
+func findSavingsGoal(id int64) (savingsGoal, error) {
+	g := savingsGoal{ID: id}
+	err := db.QueryRow(`SELECT name, target, (SELECT COALESCE(SUM(amount), 0) FROM goal_contributions WHERE goal_id = ?), deadline
+		FROM savings_goals WHERE id = ?`, id, id).Scan(&g.Name, &g.Target, &g.Saved, &g.Deadline)
+	return g, err
+}
+
+// findSavingsGoalByName looks a goal up by name, ignoring case, or
+// sql.ErrNoRows.
+func findSavingsGoalByName(name string) (savingsGoal, error) {
+	var id int64
+	if err := db.QueryRow("SELECT id FROM savings_goals WHERE name = ? COLLATE NOCASE", strings.TrimSpace(name)).Scan(&id); err != nil {
+		return savingsGoal{}, err
+	}
+	return findSavingsGoal(id)
+}
+
+// addGoalContribution puts amount towards a goal; transactionID is the
+// expense a round-up came from, 0 otherwise.
+func addGoalContribution(goalID int64, amount Money, source string, transactionID int64) error {
+	_, err := db.Exec("INSERT INTO goal_contributions (goal_id, amount, source, transaction_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		goalID, amount, source, sql.NullInt64{Int64: transactionID, Valid: transactionID != 0}, appClock.Now().Format(dbTimeLayout))
+	return err
+}
+
+// goalProgress writes a goal as "Emergency fund: 1,250,000.00/10,000,000.00"
+// above its progress bar, with its deadline if it has one.
+func goalProgress(g savingsGoal) string {
+	text := fmt.Sprintf("%s: %s/%s", g.Name, formatMoney(g.Saved), formatMoney(g.Target))
+	if deadline, err := time.ParseInLocation(dateLayout, g.Deadline.String, appLocation()); g.Deadline.Valid && err == nil {
+		text += ", by " + deadline.Format("2 Jan 2006")
+	}
+	return text + "\n" + progressBar(float64(g.Saved)/float64(g.Target))
+}
+
+// parseGoalDeadline reads a deadline, which must be in the future.
+func parseGoalDeadline(s string) (string, error) {
+	deadline, err := time.ParseInLocation(dateLayout, s, appLocation())
+	if err != nil {
+		return "", fmt.Errorf("invalid date %q, use YYYY-MM-DD", s)
+	}
+	if today, _ := dayBounds(appClock.Now()); deadline.Before(today) {
+		return "", fmt.Errorf("%s has passed", deadline.Format("2 Jan 2006"))
+	}
+	return deadline.Format(dateLayout), nil
+}
+
+// splitNameAmount splits "Emergency fund 500000" into the name and the
+// amount.
+func splitNameAmount(fields []string) (string, Money, error) {
+	if len(fields) < 2 {
+		return "", 0, fmt.Errorf("missing name or amount")
+	}
+	amount, err := parseMoney(fields[len(fields)-1])
+	if err != nil || amount <= 0 {
+		return "", 0, fmt.Errorf("invalid amount %q", fields[len(fields)-1])
+	}
+	return strings.Join(fields[:len(fields)-1], " "), amount, nil
+}
+
+func handleGoalsCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		showSavingsGoals(chatID)
+		return
+	}
+	switch strings.ToLower(fields[0]) {
+	case "add":
+		var deadline sql.NullString
+		if n := len(fields); n > 3 && strings.EqualFold(fields[n-2], "by") {
+			date, err := parseGoalDeadline(fields[n-1])
+			if err != nil {
+				sendMessage(chatID, fmt.Sprintf("Cannot set the deadline: %v.", err))
+				return
+			}
+			deadline = sql.NullString{String: date, Valid: true}
+			fields = fields[:n-2]
+		}
+		name, target, err := splitNameAmount(fields[1:])
+		if err != nil {
+			sendMessage(chatID, goalsUsage)
+			return
+		}
+		if _, err := db.Exec("INSERT INTO savings_goals (name, target, deadline) VALUES (?, ?, ?)", name, target, deadline); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				sendMessage(chatID, fmt.Sprintf("There is already a goal named %q.", name))
+				return
+			}
+			sendMessage(chatID, "Failed to create the goal.")
+			reportError("creating a savings goal", err)
+			return
+		}
+		reply := fmt.Sprintf("🎯 Goal %q created: %s to save", name, formatMoney(target))
+		if deadline.Valid {
+			date, _ := time.ParseInLocation(dateLayout, deadline.String, appLocation())
+			reply += " by " + date.Format("2 Jan 2006")
+		}
+		sendMessage(chatID, reply+".")
+	case "deadline":
+		if len(fields) < 3 {
+			sendMessage(chatID, goalsUsage)
+			return
+		}
+		name := strings.Join(fields[1:len(fields)-1], " ")
+		var deadline sql.NullString
+		if value := fields[len(fields)-1]; !strings.EqualFold(value, "off") {
+			date, err := parseGoalDeadline(value)
+			if err != nil {
+				sendMessage(chatID, fmt.Sprintf("Cannot set the deadline: %v.", err))
+				return
+			}
+			deadline = sql.NullString{String: date, Valid: true}
+		}
+		g, err := findSavingsGoalByName(name)
+		if err == sql.ErrNoRows {
+			sendMessage(chatID, fmt.Sprintf("No goal named %q. See them with /goals.", name))
+			return
+		}
+		if err == nil {
+			_, err = db.Exec("UPDATE savings_goals SET deadline = ? WHERE id = ?", deadline, g.ID)
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to set the deadline.")
+			reportError("setting the deadline of a savings goal", err)
+			return
+		}
+		g.Deadline = deadline
+		sendMessage(chatID, goalProgress(g))
+	case "save":
+		name, amount, err := splitNameAmount(fields[1:])
+		if err != nil {
+			sendMessage(chatID, goalsUsage)
+			return
+		}
+		g, err := findSavingsGoalByName(name)
+		if err == sql.ErrNoRows {
+			sendMessage(chatID, fmt.Sprintf("No goal named %q. See them with /goals.", name))
+			return
+		}
+		if err == nil {
+			err = addGoalContribution(g.ID, amount, "manual", 0)
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to save the contribution.")
+			reportError("adding to a savings goal", err)
+			return
+		}
+		g.Saved += amount
+		sendMessage(chatID, fmt.Sprintf("Put %s towards the goal.\n%s", formatMoney(amount), goalProgress(g)))
+	case "remove":
+		name := strings.Join(fields[1:], " ")
+		res, err := db.Exec("DELETE FROM savings_goals WHERE name = ? COLLATE NOCASE", name)
+		if err != nil {
+			sendMessage(chatID, "Failed to remove the goal.")
+			reportError("removing a savings goal", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("No goal named %q. See them with /goals.", name))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Goal %q removed.", name))
+	default:
+		sendMessage(chatID, goalsUsage)
+	}
+}
+
+func showSavingsGoals(chatID int64) {
+	goals, err := loadSavingsGoals()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the goals.")
+		reportError("loading savings goals", err)
+		return
+	}
+	if len(goals) == 0 {
+		sendMessage(chatID, "No savings goals yet. Create one with /goals add <name> <target>.")
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(bold("🎯 Savings goals:") + "\n")
+	for _, g := range goals {
+		sb.WriteString(escapeHTML(goalProgress(g)) + "\n")
+	}
+	sendHTML(chatID, strings.TrimRight(sb.String(), "\n"))
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+	HARNESS for end-to-end checks of the chat flows.
+
+	A harness points the bot at a fresh in-memory database, a cliMessenger
+	writing to a buffer and a clock pinned at a known time, then plays the
+	owner: steps send messages or press buttons by label, and each step's
+	reply is checked. TestScenarios plays the scenarios below; nothing
+	touches the real database or Telegram.
+*/
+
+const harnessUserID = 1
+
+// harnessStart is the time every scenario starts at: a month end, so flows
+// that cross into the next month can be tried.
+const harnessStart = "2026-01-31 23:50"
+
+type harness struct {
+	tb         testing.TB
+	cli        *cliMessenger
+	out        *bytes.Buffer
+	user       *TGUser
+	chat       *TGChat
+	callbackID int
+	seen       int
+
+	saved struct {
+		db        *sql.DB
+		messenger Messenger
+		clock     Clock
+		running   *runningConfig
+		cats      []string
+		archived  []string
+		currency  moneyFormat
+		states    map[int64]*TransactionState
+	}
+}
+
+// newHarness replaces the bot's global state with a fresh one, and puts
+// the original back when tb ends.
+func newHarness(tb testing.TB) *harness {
+	tb.Helper()
+	h := &harness{tb: tb, out: &bytes.Buffer{}}
+	h.saved.db, h.saved.messenger, h.saved.clock, h.saved.running = db, messenger, appClock, running.Load()
+	h.saved.cats, h.saved.currency, h.saved.states = getCategories(), displayCurrency.get(), userStates
+	h.saved.archived = categoriesCache.getArchived()
+
+	conn, err := openDB(":memory:")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	closeStmtCache()
+	db = conn
+	tb.Cleanup(h.close)
+	h.cli = newCLIMessenger(h.out)
+	messenger = h.cli
+	if appClock, err = clockStartingAt(harnessStart); err != nil {
+		tb.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.AllowedUsers = []int64{harnessUserID}
+	setRunningConfig(cfg, harnessUserID, appLocation())
+	userStates = make(map[int64]*TransactionState)
+
+	for _, step := range []func() error{
+		func() error { return initDB(db) },
+		initAggregates,
+		func() error { return seedCategories(db) },
+		categoriesCache.load,
+		displayCurrency.load,
+	} {
+		if err := step(); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	h.user = &TGUser{ID: harnessUserID, FirstName: "harness"}
+	h.chat = &TGChat{ID: harnessUserID, Type: "private"}
+	return h
+}
+
+func (h *harness) close() {
+	closeStmtCache()
+	db.Close()
+	db, messenger, appClock = h.saved.db, h.saved.messenger, h.saved.clock
+	running.Store(h.saved.running)
+	categoriesCache.set(h.saved.cats, h.saved.archived)
+	displayCurrency.set(h.saved.currency)
+	userStates = h.saved.states
+}
+
+// output returns what the bot wrote since the last call.
+func (h *harness) output() string {
+	text := h.out.String()[h.seen:]
+	h.seen = h.out.Len()
+	return text
+}
+
+// send delivers text as a message from the owner and returns the reply.
+// The harness calls the handlers directly, without the panic recovery of
+// handleUpdate, so that fuzzing sees panics.
+func (h *harness) send(text string) string {
+	handleMessage(&TGMessage{From: h.user, Chat: h.chat, Text: text})
+	return h.output()
+}
+
+// press presses the button labelled label on the last keyboard and
+// returns the reply; ok is false if there is no such button.
+func (h *harness) press(label string) (reply string, ok bool) {
+	data, messageID, ok := h.cli.findButton(label)
+	if !ok {
+		return "", false
+	}
+	h.callbackID++
+	handleCallbackQuery(&CallbackQuery{
+		ID:      strconv.Itoa(h.callbackID),
+		From:    h.user,
+		Message: &TGMessage{MessageID: messageID, Chat: h.chat},
+		Data:    data,
+	})
+	return h.output(), true
+}
+
+// pressData sends a button press with arbitrary callback data, as a
+// modified client could, on the last message with a keyboard.
+func (h *harness) pressData(data string) string {
+	h.callbackID++
+	handleCallbackQuery(&CallbackQuery{
+		ID:      strconv.Itoa(h.callbackID),
+		From:    h.user,
+		Message: &TGMessage{MessageID: h.cli.lastMsgID, Chat: h.chat},
+		Data:    data,
+	})
+	return h.output()
+}
+
+// harnessStep sends a message or presses a button, then expects want in
+// the reply.
+type harnessStep struct {
+	send  string
+	press string
+	want  string
+}
+
+type harnessScenario struct {
+	name  string
+	seed  func() error
+	steps []harnessStep
+	check func() error
+}
+
+// run plays one scenario on a fresh harness.
+func (s harnessScenario) run(t *testing.T) {
+	h := newHarness(t)
+	if s.seed != nil {
+		if err := s.seed(); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	for i, step := range s.steps {
+		action, reply := "send "+strconv.Quote(step.send), ""
+		if step.press != "" {
+			action = "press " + strconv.Quote(step.press)
+			var ok bool
+			if reply, ok = h.press(step.press); !ok {
+				t.Fatalf("step %d: no button %q on the last keyboard", i+1, step.press)
+			}
+		} else {
+			reply = h.send(step.send)
+		}
+		if !strings.Contains(reply, step.want) {
+			t.Fatalf("step %d (%s): want %q in reply, got %q", i+1, action, step.want, strings.TrimSpace(reply))
+		}
+	}
+	if s.check != nil {
+		if err := s.check(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectTransaction checks the stored type, category, amount (in major
+// units) and description of transaction id.
+func expectTransaction(id int64, typ string, category string, amount float64, description string) error {
+	var gotType, gotCategory, gotDescription string
+	var gotAmount Money
+	err := db.QueryRow("SELECT type, category, amount, COALESCE(description, '') FROM transactions WHERE id = ?", id).
+		Scan(&gotType, &gotCategory, &gotAmount, &gotDescription)
+	if err != nil {
+		return fmt.Errorf("transaction %d: %w", id, err)
+	}
+	if gotType != typ || gotCategory != category || gotAmount != moneyFromFloat(amount) || gotDescription != description {
+		return fmt.Errorf("transaction %d is %s %s %.2f %q, want %s %s %.2f %q",
+			id, gotType, gotCategory, gotAmount, gotDescription, typ, category, amount, description)
+	}
+	return nil
+}
+
+func expectTransactionCount(want int) error {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&n); err != nil {
+		return err
+	}
+	if n != want {
+		return fmt.Errorf("%d transaction(s) stored, want %d", n, want)
+	}
+	return nil
+}
+
+func seedLunch() error {
+	_, _, err := insertTransaction("expense", "Food", 1, 25000*moneyScale, "lunch", appClock.Now(), false)
+	return err
+}
+
+var harnessScenarios = []harnessScenario{
+	{
+		name: "add an expense",
+		steps: []harnessStep{
+			{send: "/add", want: "Please choose the type of transaction"},
+			{press: "Expense", want: "Choose a category"},
+			{press: "Food", want: "Enter the transaction amount"},
+			{send: "abc", want: "Invalid amount"},
+			{send: "25000", want: "Enter a description"},
+			{send: "lunch", want: "#1 expense Food 25,000.00 'lunch'"},
+		},
+		check: func() error { return expectTransaction(1, "expense", "Food", 25000, "lunch") },
+	},
+	{
+		name: "add an income and see it in the summary",
+		steps: []harnessStep{
+			{send: "/add", want: "Please choose the type of transaction"},
+			{press: "Income", want: "Choose a category"},
+			{press: "Salary", want: "Enter the transaction amount"},
+			{send: "5000000", want: "Enter a description"},
+			{send: "January salary", want: "Transaction added successfully!"},
+			{send: "/summary", want: "Total Income: 5,000,000.00"},
+		},
+		check: func() error { return expectTransaction(1, "income", "Salary", 5000000, "January salary") },
+	},
+	{
+		name: "go back while adding",
+		steps: []harnessStep{
+			{send: "/add", want: "Please choose the type of transaction"},
+			{press: "Expense", want: "Choose a category"},
+			{press: "Food", want: "Enter the transaction amount"},
+			{press: "⬅️ Back", want: "Choose a category"},
+			{press: "⬅️ Back", want: "Please choose the type of transaction"},
+			{press: "Income", want: "You selected income"},
+			{press: "Salary", want: "Enter the transaction amount"},
+			{send: "100", want: "Enter a description"},
+			{press: "⬅️ Back", want: "Selected category: Salary. Enter the transaction amount"},
+			{send: "200", want: "Enter a description"},
+			{send: "refund", want: "#1 income Salary 200.00 'refund'"},
+		},
+		check: func() error { return expectTransaction(1, "income", "Salary", 200, "refund") },
+	},
+	{
+		name: "edit an amount",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/edit 1", want: "Choose field to edit"},
+			{press: "Edit Category", want: "Select new category"},
+			{press: "⬅️ Back", want: "Choose field to edit"},
+			{press: "Edit Amount", want: "Enter new amount"},
+			{send: "30000", want: "amount set to 30,000.00"},
+		},
+		check: func() error { return expectTransaction(1, "expense", "Food", 30000, "lunch") },
+	},
+	{
+		name: "add and clear notes",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/edit 1", want: "Choose field to edit"},
+			{press: "Edit Notes", want: "Enter the notes"},
+			{send: "Order #A-1234, 1 year warranty", want: "notes set"},
+			{send: "/edit 1", want: "Notes: Order #A-1234, 1 year warranty"},
+			{press: "Edit Notes", want: "Send - to clear them"},
+			{send: "-", want: "notes cleared"},
+		},
+		check: func() error {
+			if notes := transactionNotes(1); notes != "" {
+				return fmt.Errorf("notes are %q, want none", notes)
+			}
+			return nil
+		},
+	},
+	{
+		name: "view, duplicate and see the history",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/edit 1", want: "Choose field to edit"},
+			{press: "Edit Category", want: "Select new category"},
+			{press: "⬅️ Back", want: "Choose field to edit"},
+			{press: "Edit Amount", want: "Enter new amount"},
+			{send: "30000", want: "amount set to 30,000.00"},
+			{send: "/view 1", want: "amount 25,000.00 → 30,000.00"},
+			{press: "📄 Duplicate", want: "duplicated as #2"},
+			{send: "/view 2", want: "Amount: 30,000.00"},
+		},
+		check: func() error { return expectTransaction(2, "expense", "Food", 30000, "lunch") },
+	},
+	{
+		name: "build, save and rerun a report",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/report", want: "Choose the period"},
+			{press: "This month", want: "Choose the categories"},
+			{press: "⬜ Food", want: "Choose the categories"},
+			{press: "Next ▶", want: "Include which transactions"},
+			{press: "Expenses", want: "Group the totals by"},
+			{press: "Category", want: "Send it as"},
+			{press: "Text", want: "• Food: 25,000.00 (100%)"},
+			{press: "💾 Save", want: "Send a name"},
+			{send: "food", want: "Report saved"},
+			{send: "/report food", want: "Total: 25,000.00"},
+		},
+		check: func() error {
+			spec, err := loadSavedReport("food")
+			if err != nil {
+				return err
+			}
+			if spec.Period != "this_month" || len(spec.Categories) != 1 || spec.GroupBy != "category" {
+				return fmt.Errorf("saved report is %+v", spec)
+			}
+			return nil
+		},
+	},
+	{
+		name: "delete a transaction",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/delete 1", want: "Are you sure you want to DELETE"},
+			{press: "Confirm Delete", want: "Transaction 1 has been deleted."},
+			{send: "/summary", want: "Total Expense: 0.00"},
+		},
+		check: func() error { return expectTransactionCount(0) },
+	},
+	{
+		name: "cancel a delete",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/delete 1", want: "Are you sure you want to DELETE"},
+			{press: "Cancel", want: "Deletion canceled."},
+		},
+		check: func() error { return expectTransactionCount(1) },
+	},
+	{
+		name: "archive only after tapping Confirm twice",
+		seed: func() error {
+			_, _, err := insertTransaction("expense", "Food", 1, 25000*moneyScale, "old lunch", appClock.Now().AddDate(-3, 0, 0), false)
+			return err
+		},
+		steps: []harnessStep{
+			{send: "/archive 2", want: "move 1 transaction(s)"},
+			{press: "⚠️ Confirm", want: "Tap Confirm again"},
+			{press: "⚠️ Confirm", want: "Archived 1 transaction(s)"},
+		},
+		check: func() error { return expectTransactionCount(0) },
+	},
+	{
+		name: "quick add categorized by a rule",
+		steps: []harnessStep{
+			{send: "/rules add description contains grab -> Transportation", want: "Rule 1 saved"},
+			{send: "30000 grab home", want: "Categorized as Transportation by rule 1."},
+		},
+		check: func() error { return expectTransaction(1, "expense", "Transportation", 30000, "grab home") },
+	},
+	{
+		name: "refuse writes in maintenance mode",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/maintenance on", want: "Maintenance mode ON"},
+			{send: "/budget Food 600000", want: "read-only maintenance mode"},
+			{send: "/mergecategories Food into Other", want: "read-only maintenance mode"},
+			{send: "30000 grab home", want: "read-only maintenance mode"},
+			{send: "/budget", want: "No budgets set"},
+			{send: "/summary", want: "Total Expense: 25,000.00"},
+			{send: "/help", want: "Here is a quick tour"},
+			{send: "/streaks", want: "Streaks"},
+			{send: "/cancel", want: "Nothing to cancel"},
+			{send: "/ref 1 INV-7", want: "read-only maintenance mode"},
+			{send: "/maintenance off", want: "Maintenance mode OFF"},
+		},
+		check: func() error { return expectTransactionCount(1) },
+	},
+	{
+		name: "archive a category and keep reporting it",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/categories archive Food", want: "Food archived"},
+			{send: "/categories Food", want: "(archived)"},
+			{send: "/forecast Food", want: "Food needs at least 3 complete months"},
+			{send: "/ask how much did I spend on food this month", want: "Total expense in Food in January 2026: 25,000.00"},
+		},
+		check: func() error {
+			for _, name := range activeCategories() {
+				if name == "Food" {
+					return fmt.Errorf("archived Food still offered in the keyboards")
+				}
+			}
+			return nil
+		},
+	},
+	{
+		name: "edit a missing transaction",
+		steps: []harnessStep{
+			{send: "/edit 42", want: "Transaction with ID 42 not found."},
+		},
+	},
+	{
+		name: "operate from the menu keyboard",
+		seed: seedLunch,
+		steps: []harnessStep{
+			{send: "/menu", want: "<📋 List>"},
+			{send: "📋 List", want: "#1 · "},
+			{send: "📊 Summary", want: "Total Expense: 25,000.00"},
+			{send: "⚙️ Settings", want: "Time zone:"},
+			{send: "/menu", want: "[keyboard removed]"},
+		},
+	},
+	{
+		name: "set up with /start",
+		steps: []harnessStep{
+			{send: "/start", want: "1/5 Language"},
+			{press: "Bahasa Indonesia", want: "2/5 Currency"},
+			{press: "IDR", want: "3/5 Time zone"},
+			{press: "Jakarta (WIB)", want: "4/5 Categories"},
+			{press: "⬜ Groceries", want: "✅ Groceries"},
+			{press: "Next ▶", want: "5/5 Monthly budget"},
+			{send: "5000000", want: "Monthly budget set to Rp 5.000.000."},
+			{send: "/start", want: "Welcome back!"},
+		},
+		check: func() error {
+			if _, ok := findCategory("Groceries"); !ok {
+				return fmt.Errorf("starter category Groceries was not added")
+			}
+			if got := getSetting("timezone", ""); got != "Asia/Jakarta" {
+				return fmt.Errorf("time zone is %q, want Asia/Jakarta", got)
+			}
+			return nil
+		},
+	},
+	{
+		name: "list saved reports past an unreadable one",
+		seed: func() error {
+			_, err := db.Exec(`INSERT INTO saved_reports (name, spec) VALUES ('broken', '{"period":'), ('food', '{"period":"this_month","type":"expense","group_by":"category","format":"text"}')`)
+			return err
+		},
+		steps: []harnessStep{
+			{send: "/report list", want: "• broken: unreadable, remove it with /report delete broken\n• food: This month · Expenses"},
+		},
+	},
+}
+
+func TestScenarios(t *testing.T) {
+	for _, s := range harnessScenarios {
+		t.Run(s.name, s.run)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	SPENDING HEATMAP (/heatmap [YYYY] [archive])
+
+	A calendar image with one square per day colored by that day's
+	expenses, one column per week like a GitHub contribution graph, rows in
+	the configured week order. Without a year it covers the weeks up to
+	today, a year back. The caption adds the busiest day and the average
+	spend per weekday.
+*/
+
+const (
+	heatmapScript = "src/g_heatmap_chart.py"
+	heatmapWeeks  = 53
+	heatmapUsage  = "Usage: /heatmap [YYYY] [archive]"
+)
+
+// heatmapChart is the input of the heatmap script.
+type heatmapChart struct {
+	Title string `json:"title"`
+	// Start is the first day of the first column.
+	Start    string   `json:"start"`
+	Weekdays []string `json:"weekdays"`
+	// Days holds the expenses of each day from Start, nil for the days
+	// outside the period.
+	Days []*float64 `json:"days"`
+}
+
+// dailyExpenses sums the expenses in [from, to) by day (YYYY-MM-DD).
+func dailyExpenses(from time.Time, to time.Time, withArchive bool) (map[string]Money, error) {
+	rows, err := db.Query(`SELECT date(created_at), SUM(amount) FROM `+transactionSource(withArchive)+`
+		WHERE type = 'expense' AND created_at >= ? AND created_at < ? GROUP BY 1`,
+		from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]Money)
+	for rows.Next() {
+		var day string
+		var total Money
+		if err := rows.Scan(&day, &total); err != nil {
+			return nil, err
+		}
+		totals[day] = total
+	}
+	return totals, rows.Err()
+}
+
+func showHeatmap(chatID int64, args string) {
+	arg, withArchive := splitArchiveArg(args)
+	now := appClock.Now()
+	today, tomorrow := dayBounds(now)
+	first := weekStartDay()
+
+	var from, to time.Time
+	var label string
+	switch {
+	case arg == "":
+		thisWeek, _ := weekBounds(now, first)
+		from, to = thisWeek.AddDate(0, 0, -7*(heatmapWeeks-1)), tomorrow
+		label = "last 12 months"
+	case periodYearPattern.MatchString(arg):
+		year, err := time.ParseInLocation("2006", arg, appLocation())
+		if err != nil {
+			sendMessage(chatID, heatmapUsage)
+			return
+		}
+		from, to = year, year.AddDate(1, 0, 0)
+		label = arg
+	default:
+		sendMessage(chatID, heatmapUsage)
+		return
+	}
+	if !from.Before(tomorrow) {
+		sendMessage(chatID, fmt.Sprintf("%s has not started yet.", label))
+		return
+	}
+	if to.After(tomorrow) {
+		to = tomorrow
+	}
+
+	totals, err := dailyExpenses(from, to, withArchive)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the transactions.")
+		reportError("loading daily expenses", err)
+		return
+	}
+	if len(totals) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No expenses in %s.", label))
+		return
+	}
+
+	start, _ := weekBounds(from, first)
+	_, end := weekBounds(to.AddDate(0, 0, -1), first)
+	chart := heatmapChart{Title: "Daily spending, " + label, Start: start.Format(dateLayout)}
+	for d := start; d.Before(start.AddDate(0, 0, 7)); d = d.AddDate(0, 0, 1) {
+		chart.Weekdays = append(chart.Weekdays, d.Format("Mon"))
+	}
+
+	var total, busiest Money
+	var busiestDay time.Time
+	var byWeekday [7]Money
+	var weekdayCount [7]int
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if d.Before(from) || !d.Before(to) {
+			chart.Days = append(chart.Days, nil)
+			continue
+		}
+		amount := totals[d.Format(dateLayout)]
+		value := amount.Float()
+		chart.Days = append(chart.Days, &value)
+		total += amount
+		byWeekday[d.Weekday()] += amount
+		weekdayCount[d.Weekday()]++
+		if amount > busiest {
+			busiest, busiestDay = amount, d
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔥 Daily spending, %s\nTotal: %s over %d day(s) with expenses\n", label, formatMoney(total), len(totals)))
+	sb.WriteString(fmt.Sprintf("Busiest day: %s (%s)\n", busiestDay.Format("Mon 2 Jan 2006"), formatMoney(busiest)))
+	if today.Before(to) {
+		sb.WriteString(fmt.Sprintf("Today so far: %s\n", formatMoney(totals[today.Format(dateLayout)])))
+	}
+	sb.WriteString("\nAverage by weekday:\n")
+	for i := 0; i < 7; i++ {
+		wd := (first + time.Weekday(i)) % 7
+		if weekdayCount[wd] > 0 {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", wd.String()[:3], formatMoney(byWeekday[wd].Div(weekdayCount[wd]))))
+		}
+	}
+	sendChart(chatID, heatmapScript, chart, strings.TrimRight(sb.String(), "\n"))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+/*
+	MESSAGE FORMATTING
+
+	Messages are sent with Telegram's HTML parse mode. The Messenger takes
+	HTML; sendMessage and the other plain text helpers escape their text,
+	so a description like "<3 & co" is shown as typed and never breaks the
+	formatting. Messages meant to be formatted are built with the helpers
+	below, which escape what they wrap, and sent with sendHTML and
+	friends:
+
+		sendHTML(chatID, bold("Total")+" "+moneyHTML(total))
+
+	The conventions: titles and totals in bold, amounts and transaction
+	ids in monospace (they line up, and a tap copies them), user input
+	always through escapeHTML or a helper.
+
+	Only the tags below are used, and a formatted span never crosses a
+	line, except <pre> blocks, so long messages can be split at line
+	breaks (longmessages.go). The REPL shows the text without the tags.
+*/
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeHTML makes text safe to put in a formatted message.
+func escapeHTML(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+// bold writes text in bold.
+func bold(text string) string {
+	return "<b>" + escapeHTML(text) + "</b>"
+}
+
+// italic writes text in italics.
+func italic(text string) string {
+	return "<i>" + escapeHTML(text) + "</i>"
+}
+
+// mono writes text in monospace.
+func mono(text string) string {
+	return "<code>" + escapeHTML(text) + "</code>"
+}
+
+// preformatted writes a block of monospace text, e.g. a table.
+func preformatted(text string) string {
+	return "<pre>" + escapeHTML(text) + "</pre>"
+}
+
+// moneyHTML writes an amount in monospace, e.g. "<code>Rp 25.000</code>".
+func moneyHTML(m Money) string {
+	return mono(formatMoney(m))
+}
+
+// signedMoneyHTML is moneyHTML with a "+" on positive amounts.
+func signedMoneyHTML(m Money) string {
+	return mono(formatSignedMoney(m))
+}
+
+// idHTML writes a transaction id in monospace, e.g. "<code>#214</code>".
+func idHTML(id int64) string {
+	return mono(fmt.Sprintf("#%d", id))
+}
+
+var htmlTagPattern = regexp.MustCompile(`<(/?)([a-z]+)[^>]*>`)
+
+// htmlToPlain removes the formatting of a message, for the REPL and for
+// text files.
+func htmlToPlain(text string) string {
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(text, ""))
+}
+
+// balanceTags closes the tags left open at the end of a part of a split
+// message, and returns them to be opened again in the next part.
+func balanceTags(part string) (closed string, reopen string) {
+	var open []string
+	for _, m := range htmlTagPattern.FindAllStringSubmatch(part, -1) {
+		if m[1] == "/" {
+			for i := len(open) - 1; i >= 0; i-- {
+				if strings.HasPrefix(open[i], "<"+m[2]) {
+					open = append(open[:i], open[i+1:]...)
+					break
+				}
+			}
+			continue
+		}
+		open = append(open, m[0])
+	}
+	closed = part
+	for i := len(open) - 1; i >= 0; i-- {
+		name := htmlTagPattern.FindStringSubmatch(open[i])[2]
+		closed += "</" + name + ">"
+	}
+	return closed, strings.Join(open, "")
+}
+
+// safeCut moves a cut back out of a tag or an entity such as "&amp;".
+func safeCut(text string, cut int) int {
+	if i := strings.LastIndexAny(text[:cut], "<>"); i >= 0 && text[i] == '<' {
+		cut = i
+	}
+	if i := strings.LastIndexAny(text[:cut], "&;"); i >= 0 && text[i] == '&' && cut-i <= 10 {
+		cut = i
+	}
+	return cut
+}
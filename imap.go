@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	MINIMAL IMAP CLIENT
+
+	Just what the e-receipt poller (receipts.go) needs, over implicit TLS
+	(port 993): LOGIN, EXAMINE (read-only, so no mail is marked read),
+	UID SEARCH and UID FETCH of the start of a message, LOGOUT. Responses
+	are read line by line, with the {n} literals that carry the messages.
+*/
+
+const (
+	imapTimeout  = 60 * time.Second
+	imapMaxFetch = 256 << 10 // bytes of a message read, enough for a receipt
+)
+
+// imapDial opens the connection; tests replace it.
+var imapDial = func(addr string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	return tls.DialWithDialer(&net.Dialer{Timeout: imapTimeout}, "tcp", addr, &tls.Config{ServerName: host})
+}
+
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is an untagged response line, with the literals it
+// carried in place of their {n} markers.
+type imapResponse struct {
+	Text     string
+	Literals [][]byte
+}
+
+func dialIMAP(addr string) (*imapClient, error) {
+	conn, err := imapDial(addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(imapTimeout))
+	greeting, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting %q", greeting)
+	}
+	return c, nil
+}
+
+func (c *imapClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *imapClient) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+var imapLiteralPattern = regexp.MustCompile(`\{(\d+)\}$`)
+
+// command sends one command and returns its untagged responses, or the
+// server's refusal as an error.
+func (c *imapClient) command(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+	var responses []imapResponse
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if rest, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(rest, "OK") {
+				return nil, fmt.Errorf("IMAP %s", rest)
+			}
+			return responses, nil
+		}
+		resp := imapResponse{Text: line}
+		for {
+			m := imapLiteralPattern.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			n, _ := strconv.Atoi(m[1])
+			literal := make([]byte, n)
+			if _, err := io.ReadFull(c.r, literal); err != nil {
+				return nil, err
+			}
+			resp.Literals = append(resp.Literals, literal)
+			if line, err = c.readLine(); err != nil {
+				return nil, err
+			}
+			resp.Text += line
+		}
+		responses = append(responses, resp)
+	}
+}
+
+// imapQuote writes s as an IMAP quoted string.
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (c *imapClient) Login(user, password string) error {
+	_, err := c.command("LOGIN %s %s", imapQuote(user), imapQuote(password))
+	return err
+}
+
+var imapUIDValidityPattern = regexp.MustCompile(`\[UIDVALIDITY (\d+)\]`)
+
+// Examine opens a mailbox read-only and returns its UIDVALIDITY.
+func (c *imapClient) Examine(mailbox string) (int64, error) {
+	responses, err := c.command("EXAMINE %s", imapQuote(mailbox))
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range responses {
+		if m := imapUIDValidityPattern.FindStringSubmatch(r.Text); m != nil {
+			return strconv.ParseInt(m[1], 10, 64)
+		}
+	}
+	return 0, nil
+}
+
+// SearchUIDs runs UID SEARCH with criteria and returns the UIDs found,
+// in increasing order.
+func (c *imapClient) SearchUIDs(criteria string) ([]int64, error) {
+	responses, err := c.command("UID SEARCH %s", criteria)
+	if err != nil {
+		return nil, err
+	}
+	var uids []int64
+	for _, r := range responses {
+		rest, ok := strings.CutPrefix(r.Text, "* SEARCH")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(rest) {
+			if uid, err := strconv.ParseInt(field, 10, 64); err == nil {
+				uids = append(uids, uid)
+			}
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
+// FetchMessage returns the start of the raw message with uid, up to
+// imapMaxFetch bytes, without setting its \Seen flag.
+func (c *imapClient) FetchMessage(uid int64) ([]byte, error) {
+	responses, err := c.command("UID FETCH %d (BODY.PEEK[]<0.%d>)", uid, imapMaxFetch)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range responses {
+		if len(r.Literals) > 0 && strings.Contains(r.Text, "FETCH") {
+			return r.Literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("message %d not found", uid)
+}
+
+func (c *imapClient) Logout() error {
+	_, err := c.command("LOGOUT")
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+	INDICATORS
+
+	Reports mark what matters at a glance: 💸 expenses and 💰 income,
+	🔺 or 🔻 with the change against the previous period (/summary against
+	the month before, /week against the week before), and the 🟢🟡🟠🔴
+	state of the budget bars:
+
+		💸 Total Expense: 1,250.00 (🔺 12% vs last month)
+
+	Users who prefer plain text turn them off with /indicators off, or for
+	the whole instance with indicators = false in [features]; the reports
+	then read "Total Expense: 1,250.00 (+12% vs last month)" and the
+	budget bars have no colors.
+*/
+
+// indicatorsOn reports whether reports are annotated with emoji.
+func indicatorsOn() bool {
+	return getBoolSetting("indicators", featureEnabled("indicators"))
+}
+
+// typeIcons returns the emoji of each transaction type followed by a
+// space, none with the indicators off. It reads the setting, so get it
+// before querying rows.
+func typeIcons() map[string]string {
+	if !indicatorsOn() {
+		return map[string]string{}
+	}
+	return map[string]string{"expense": "💸 ", "income": "💰 "}
+}
+
+// trendIndicator describes the change from previous to current, e.g.
+// "🔺 12% vs last month", or "" when there is nothing to compare with.
+func trendIndicator(current, previous Money, period string) string {
+	if previous == 0 {
+		return ""
+	}
+	change := (current - previous).Float() / math.Abs(previous.Float()) * 100
+	if !indicatorsOn() {
+		return fmt.Sprintf("%+.0f%% vs %s", change, period)
+	}
+	switch {
+	case math.Round(change) == 0:
+		return "➖ same as " + period
+	case change > 0:
+		return fmt.Sprintf("🔺 %.0f%% vs %s", change, period)
+	default:
+		return fmt.Sprintf("🔻 %.0f%% vs %s", -change, period)
+	}
+}
+
+// withTrend appends the trend to a line of a report in parentheses.
+func withTrend(line string, current, previous Money, period string) string {
+	if trend := trendIndicator(current, previous, period); trend != "" {
+		return line + " (" + escapeHTML(trend) + ")"
+	}
+	return line
+}
+
+// handleIndicatorsCommand implements /indicators [on|off].
+func handleIndicatorsCommand(chatID int64, args string) {
+	switch arg := strings.ToLower(strings.TrimSpace(args)); arg {
+	case "":
+		state := "on"
+		if !indicatorsOn() {
+			state = "off"
+		}
+		sendMessage(chatID, fmt.Sprintf("Indicators are %s: 💸/💰 for expenses and income, 🔺/🔻 for changes against the previous period and colors on budget bars. Use /indicators on or /indicators off to change it.", state))
+	case "on", "off":
+		if err := setSetting("indicators", strconv.FormatBool(arg == "on")); err != nil {
+			sendMessage(chatID, "Failed to save the setting.")
+			reportError("setting the indicators", err)
+			return
+		}
+		if arg == "on" {
+			sendMessage(chatID, "🔺 Indicators on: reports mark types, trends and budget states with emoji.")
+		} else {
+			sendMessage(chatID, "Indicators off: reports are plain text.")
+		}
+	default:
+		sendMessage(chatID, "Usage: /indicators on or /indicators off")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+	QUICK INGEST (POST /api/ingest)
+
+	A forgiving way into the HTTP API (api.go) for iOS Shortcuts, Tasker or
+	IFTTT, which send a few fields more easily than a full transaction:
+
+		curl -H "Authorization: Bearer ayk_..." -d amount=25000 -d description="lunch" \
+			http://127.0.0.1:8080/api/ingest
+
+	The fields come as a form (urlencoded or multipart) or as JSON: amount,
+	and optionally category, description, type (expense by default) and
+	date. Without a category the categorization rules (rules.go) pick one
+	from the description and amount. Forms may carry the write token in a
+	"token" field when the app cannot set headers.
+
+	The answer is {"id": 214, "message": "#214 expense Food 25,000.00
+	'lunch'"}, the message followed by the budget status of the category,
+	ready for a notification on the phone.
+*/
+
+// ingestFields are the fields of an ingest request, as typed.
+type ingestFields struct {
+	Amount      string `json:"amount"`
+	Category    string `json:"category"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Date        string `json:"date"`
+}
+
+// isFormRequest tells whether the body of r is a form.
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+// readIngestFields reads the fields from a form or a JSON body.
+func readIngestFields(r *http.Request) (ingestFields, error) {
+	var f ingestFields
+	if isFormRequest(r) {
+		// requireToken has parsed the form already when it read the token
+		if err := r.ParseMultipartForm(apiMaxBody); err != nil && err != http.ErrNotMultipart {
+			return f, fmt.Errorf("invalid form: %w", err)
+		}
+		f = ingestFields{
+			Amount:      r.PostFormValue("amount"),
+			Category:    r.PostFormValue("category"),
+			Description: r.PostFormValue("description"),
+			Type:        r.PostFormValue("type"),
+			Date:        r.PostFormValue("date"),
+		}
+		return f, nil
+	}
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return f, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	// Shortcuts sends numbers as numbers or as text, depending on the action
+	text := func(key string) string {
+		switch v := raw[key].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return ""
+	}
+	f = ingestFields{Amount: text("amount"), Category: text("category"), Description: text("description"), Type: text("type"), Date: text("date")}
+	return f, nil
+}
+
+func apiIngest(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBody)
+	f, err := readIngestFields(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	amount, err := parseMoney(strings.TrimSpace(f.Amount))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid amount %q", f.Amount))
+		return
+	}
+	n := apiNewTransaction{Type: strings.TrimSpace(f.Type), Category: strings.TrimSpace(f.Category), Amount: amount, Description: strings.TrimSpace(f.Description), Date: strings.TrimSpace(f.Date)}
+	if n.Type == "" {
+		n.Type = "expense"
+	}
+	if n.Category == "" {
+		rules, err := loadRules()
+		if err != nil {
+			apiInternalError(w, "loading the rules for an ingest", err)
+			return
+		}
+		rule, ok := matchRule(rules, strings.ToLower(n.Type), amount, n.Description)
+		if !ok {
+			writeAPIError(w, http.StatusBadRequest, "no category given and no rule matches, send a category")
+			return
+		}
+		n.Category = rule.Category
+	}
+	t, err := n.validate(appClock.Now())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, ok := apiSaveTransaction(w, r, t)
+	if !ok {
+		return
+	}
+
+	message := fmt.Sprintf("#%d %s %s %s", id, t.Type, t.Category, formatMoney(t.Amount))
+	if t.Description != "" {
+		message += fmt.Sprintf(" '%s'", t.Description)
+	}
+	if t.Type == "expense" {
+		if status, err := categoryBudgetStatus(t.Category, t.CreatedAt); err != nil {
+			reportError("computing the budget status", err)
+		} else if status != "" {
+			message += "\n" + status
+		}
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "message": message})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	SPENDING INSIGHTS (/insights [period])
+
+	Patterns in the expenses of a period (parsePeriod: this month by
+	default):
+
+	- the most frequent merchants, with their total and average ticket. A
+	  merchant is the description of an expense, compared without case and
+	  extra spaces, so "Starbucks" and "starbucks " are the same;
+	- how the spending spreads over the days of the week;
+	- categories and merchants whose spending differs by insightChange or
+	  more from usual, e.g. "You've spent 450000.00 on Coffee this month,
+	  23% more than usual". Usual is the daily average of the
+	  insightBaselineMonths before the period, outliers left out, over as
+	  many days as the period has had so far.
+*/
+
+const (
+	insightBaselineMonths = 3
+	insightMerchantCount  = 5
+	insightChangeCount    = 5
+	insightChange         = 0.20
+)
+
+type insightExpense struct {
+	Category    string
+	Amount      Money
+	Description string
+	CreatedAt   time.Time
+	IsOutlier   bool
+}
+
+type merchantStats struct {
+	Name   string
+	Visits int
+	Total  Money
+}
+
+type spendingChange struct {
+	Name    string
+	Spent   float64
+	Usual   float64
+	Percent float64
+}
+
+// merchantKey normalizes a description to compare merchants.
+func merchantKey(description string) string {
+	return strings.Join(strings.Fields(strings.ToLower(description)), " ")
+}
+
+// insightExpenses loads the expenses created in [from, to).
+func insightExpenses(from time.Time, to time.Time) ([]insightExpense, error) {
+	rows, err := db.Query(`SELECT category, `+lineTotal+`, description, created_at, COALESCE(is_outlier, 0) FROM transactions
+		WHERE type = 'expense' AND created_at >= ? AND created_at < ? AND `+ledgerScope()+` ORDER BY created_at, id`,
+		from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var expenses []insightExpense
+	for rows.Next() {
+		var e insightExpense
+		var description sql.NullString
+		var createdAt string
+		if err := rows.Scan(&e.Category, &e.Amount, &description, &createdAt, &e.IsOutlier); err != nil {
+			return nil, err
+		}
+		t, err := parseCreatedAt(createdAt)
+		if err != nil {
+			continue
+		}
+		e.Description, e.CreatedAt = strings.TrimSpace(description.String), t
+		expenses = append(expenses, e)
+	}
+	return expenses, rows.Err()
+}
+
+// frequentMerchants returns the merchants visited more than once, most
+// visited first.
+func frequentMerchants(expenses []insightExpense) []merchantStats {
+	byKey := make(map[string]*merchantStats)
+	for _, e := range expenses {
+		key := merchantKey(e.Description)
+		if key == "" {
+			continue
+		}
+		m, ok := byKey[key]
+		if !ok {
+			m = &merchantStats{Name: e.Description}
+			byKey[key] = m
+		}
+		m.Visits++
+		m.Total += e.Amount
+	}
+	var merchants []merchantStats
+	for _, m := range byKey {
+		if m.Visits > 1 {
+			merchants = append(merchants, *m)
+		}
+	}
+	sort.Slice(merchants, func(i, j int) bool {
+		if merchants[i].Visits != merchants[j].Visits {
+			return merchants[i].Visits > merchants[j].Visits
+		}
+		if merchants[i].Total != merchants[j].Total {
+			return merchants[i].Total > merchants[j].Total
+		}
+		return merchants[i].Name < merchants[j].Name
+	})
+	if len(merchants) > insightMerchantCount {
+		merchants = merchants[:insightMerchantCount]
+	}
+	return merchants
+}
+
+// spendingChanges compares the spending per category and per merchant of
+// current, over days days, with their daily average in baseline, over
+// baselineDays days. The largest differences in amount come first.
+func spendingChanges(current, baseline []insightExpense, days, baselineDays float64) []spendingChange {
+	spent := make(map[string]float64)
+	usual := make(map[string]float64)
+	names := make(map[string]string)
+	add := func(totals map[string]float64, e insightExpense) {
+		totals["c:"+e.Category] += e.Amount.Float()
+		names["c:"+e.Category] = e.Category
+		if key := merchantKey(e.Description); key != "" && merchantKey(e.Category) != key {
+			totals["m:"+key] += e.Amount.Float()
+			if _, ok := names["m:"+key]; !ok {
+				names["m:"+key] = e.Description
+			}
+		}
+	}
+	for _, e := range current {
+		add(spent, e)
+	}
+	for _, e := range baseline {
+		if !e.IsOutlier {
+			add(usual, e)
+		}
+	}
+
+	// a merchant that is all of its category would repeat the same line
+	sameAsCategory := func(key string) bool {
+		for other := range spent {
+			if strings.HasPrefix(other, "c:") && spent[other] == spent[key] && usual[other] == usual[key] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var changes []spendingChange
+	for key, amount := range spent {
+		expected := usual[key] / baselineDays * days
+		if expected <= 0 || (strings.HasPrefix(key, "m:") && sameAsCategory(key)) {
+			continue
+		}
+		ratio := amount/expected - 1
+		if math.Abs(ratio) < insightChange {
+			continue
+		}
+		changes = append(changes, spendingChange{Name: names[key], Spent: amount, Usual: expected, Percent: ratio * 100})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		di, dj := math.Abs(changes[i].Spent-changes[i].Usual), math.Abs(changes[j].Spent-changes[j].Usual)
+		if di != dj {
+			return di > dj
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	if len(changes) > insightChangeCount {
+		changes = changes[:insightChangeCount]
+	}
+	return changes
+}
+
+func insightsText(from time.Time, to time.Time, label string, now time.Time) (string, error) {
+	first := weekStartDay()
+	end := to
+	if now.Before(end) {
+		end = now
+	}
+	if !end.After(from) {
+		return fmt.Sprintf("No expenses in %s.", label), nil
+	}
+	baselineFrom := from.AddDate(0, -insightBaselineMonths, 0)
+	expenses, err := insightExpenses(baselineFrom, end)
+	if err != nil {
+		return "", err
+	}
+	var current, baseline []insightExpense
+	for _, e := range expenses {
+		if e.CreatedAt.Before(from) {
+			baseline = append(baseline, e)
+		} else {
+			current = append(current, e)
+		}
+	}
+	if len(current) == 0 {
+		return fmt.Sprintf("No expenses in %s.", label), nil
+	}
+	var total Money
+	for _, e := range current {
+		total += e.Amount
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("💡 Insights, %s\nSpent: %s in %d expense(s)\n", label, formatMoney(total), len(current)))
+
+	sb.WriteString("\n🏪 Most frequent merchants:\n")
+	merchants := frequentMerchants(current)
+	if len(merchants) == 0 {
+		sb.WriteString("No merchant appears more than once.\n")
+	}
+	for i, m := range merchants {
+		sb.WriteString(fmt.Sprintf("%d. %s: %d times, %s in total, %s on average\n", i+1, m.Name, m.Visits, formatMoney(m.Total), formatMoney(m.Total.Div(m.Visits))))
+	}
+
+	var byDay [7]Money
+	for _, e := range current {
+		byDay[e.CreatedAt.Weekday()] += e.Amount
+	}
+	sb.WriteString("\n📅 By day of the week:\n")
+	for i := 0; i < 7; i++ {
+		day := time.Weekday((int(first) + i) % 7)
+		sb.WriteString(fmt.Sprintf("%s %s (%s)\n", day.String()[:3], progressBar(float64(byDay[day])/float64(total)), formatMoney(byDay[day])))
+	}
+
+	days := end.Sub(from).Hours() / 24
+	baselineDays := from.Sub(baselineFrom).Hours() / 24
+	if len(baseline) > 0 {
+		changes := spendingChanges(current, baseline, days, baselineDays)
+		when := "in " + label
+		if monthStart, monthEnd := monthBounds(now); from.Equal(monthStart) && to.Equal(monthEnd) {
+			when = "this month"
+		}
+		sb.WriteString(fmt.Sprintf("\n📈 Compared with the %d months before:\n", insightBaselineMonths))
+		if len(changes) == 0 {
+			sb.WriteString(fmt.Sprintf("Nothing differs from usual by %.0f%% or more.\n", insightChange*100))
+		}
+		for _, c := range changes {
+			direction := "more"
+			if c.Percent < 0 {
+				direction = "less"
+			}
+			sb.WriteString(fmt.Sprintf("• You've spent %s on %s %s, %.0f%% %s than usual (%s).\n", formatMoney(moneyFromFloat(c.Spent)), c.Name, when, math.Abs(c.Percent), direction, formatMoney(moneyFromFloat(c.Usual))))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+func showInsights(chatID int64, args string) {
+	now := appClock.Now()
+	from, to, label, err := parsePeriod(args, now)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v. Usage: /insights [YYYY-MM | YYYY | YYYY-MM-DD YYYY-MM-DD]", err))
+		return
+	}
+	text, err := insightsText(from, to, label, now)
+	if err != nil {
+		sendMessage(chatID, "Failed to compute the insights.")
+		reportError("computing insights", err)
+		return
+	}
+	sendMessage(chatID, text)
+}
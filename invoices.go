@@ -0,0 +1,365 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	INVOICES (/invoice)
+
+	For freelancers: an invoice has a number, a client, an amount and a due
+	date, and is "invoiced" until it is paid. Past its due date it shows as
+	overdue, and a reminder with a "Mark paid" button is queued the day
+	after and then once a week until it is paid.
+
+	/invoice add INV-001 2500000 Acme Corp due 2026-11-30
+	/invoice paid INV-001
+	/invoices
+
+	Paying records the income, with the client and number in the
+	description and the number as a reference (/ref), so /search finds it.
+	An invoice belongs to the ledger it was added in (ledgers.go), and its
+	income goes there too.
+*/
+
+const (
+	invoiceUsage           = "Usage:\n/invoices - list outstanding invoices\n/invoice add <number> <amount> <client> [due YYYY-MM-DD | due <days>]\n/invoice paid <number>\n/invoice remove <number>"
+	defaultInvoiceTermDays = 30
+	defaultInvoiceCategory = "Freelance"
+)
+
+type invoice struct {
+	ID       int64
+	Number   string
+	Client   string
+	Amount   Money
+	Category string
+	IssuedOn time.Time
+	DueOn    time.Time
+	Status   string // "invoiced" or "paid"
+	LedgerID int64
+}
+
+// invoiceCategory is the income category of paid invoices: Freelance
+// when it exists, otherwise Salary.
+func invoiceCategory() string {
+	if category, ok := findCategory(defaultInvoiceCategory); ok {
+		return category
+	}
+	return "Salary"
+}
+
+// loadInvoices returns the outstanding invoices of the active ledger,
+// earliest due first.
+func loadInvoices() ([]invoice, error) {
+	rows, err := db.Query("SELECT id, number, client, amount, category, issued_on, due_on, status, ledger_id FROM invoices WHERE status = 'invoiced' AND " +
+		ledgerScope() + " ORDER BY due_on, number")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []invoice
+	for rows.Next() {
+		var inv invoice
+		var issued, due string
+		if err := rows.Scan(&inv.ID, &inv.Number, &inv.Client, &inv.Amount, &inv.Category, &issued, &due, &inv.Status, &inv.LedgerID); err != nil {
+			return nil, err
+		}
+		if inv.IssuedOn, err = time.ParseInLocation(dateLayout, issued, appLocation()); err != nil {
+			return nil, fmt.Errorf("invoice %d: invalid issued_on %q", inv.ID, issued)
+		}
+		if inv.DueOn, err = time.ParseInLocation(dateLayout, due, appLocation()); err != nil {
+			return nil, fmt.Errorf("invoice %d: invalid due_on %q", inv.ID, due)
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
+
+func handleInvoiceCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || strings.EqualFold(fields[0], "list") {
+		showInvoices(chatID)
+		return
+	}
+	action := strings.ToLower(fields[0])
+	switch action {
+	case "add":
+		addInvoice(chatID, fields[1:])
+	case "paid", "remove":
+		if len(fields) != 2 {
+			sendMessage(chatID, invoiceUsage)
+			return
+		}
+		var id int64
+		err := db.QueryRow("SELECT id FROM invoices WHERE number = ? AND "+ledgerScope(), fields[1]).Scan(&id)
+		if err == sql.ErrNoRows {
+			sendMessage(chatID, fmt.Sprintf("No invoice numbered %q.", fields[1]))
+			return
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to load the invoice.")
+			reportError("loading an invoice", err)
+			return
+		}
+		if action == "paid" {
+			text, err := payInvoice(id)
+			if err != nil {
+				sendMessage(chatID, "Failed to record the payment.")
+				reportError(fmt.Sprintf("paying invoice %d", id), err)
+				return
+			}
+			sendMessage(chatID, text)
+			return
+		}
+		if _, err := db.Exec("DELETE FROM invoices WHERE id = ?", id); err != nil {
+			sendMessage(chatID, "Failed to remove the invoice.")
+			reportError(fmt.Sprintf("removing invoice %d", id), err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Invoice %s removed. The income of a paid invoice stays recorded.", fields[1]))
+	default:
+		sendMessage(chatID, invoiceUsage)
+	}
+}
+
+// addInvoice parses "<number> <amount> <client...> [due <date or days>]".
+func addInvoice(chatID int64, fields []string) {
+	if len(fields) < 3 {
+		sendMessage(chatID, invoiceUsage)
+		return
+	}
+	number := fields[0]
+	amount, err := parseMoney(fields[1])
+	if err != nil || amount <= 0 {
+		sendMessage(chatID, "Invalid amount. It must be a positive number.\n"+invoiceUsage)
+		return
+	}
+	client := fields[2:]
+	today, _ := dayBounds(appClock.Now())
+	due := today.AddDate(0, 0, defaultInvoiceTermDays)
+	if n := len(client); n >= 3 && strings.EqualFold(client[n-2], "due") {
+		when := client[n-1]
+		if days, err := strconv.Atoi(when); err == nil && days >= 0 && days <= 365 {
+			due = today.AddDate(0, 0, days)
+		} else if t, err := time.ParseInLocation(dateLayout, when, appLocation()); err == nil {
+			due = t
+		} else {
+			sendMessage(chatID, fmt.Sprintf("Invalid due date %q. Use YYYY-MM-DD or a number of days.", when))
+			return
+		}
+		client = client[:n-2]
+	}
+	name := strings.Join(client, " ")
+	if len([]rune(number)) > 50 || len([]rune(name)) > 100 {
+		sendMessage(chatID, "The number has at most 50 characters and the client at most 100.")
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO invoices (number, client, amount, category, issued_on, due_on, ledger_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		number, name, amount, invoiceCategory(), today.Format(dateLayout), due.Format(dateLayout), activeLedgerID())
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			sendMessage(chatID, fmt.Sprintf("An invoice numbered %q already exists.", number))
+			return
+		}
+		sendMessage(chatID, "Failed to save the invoice.")
+		reportError("adding an invoice", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("🧾 Invoice %s to %s added: %s, due %s. Mark it paid with /invoice paid %s.",
+		number, name, formatMoney(amount), due.Format("2 Jan 2006"), number))
+}
+
+func showInvoices(chatID int64) {
+	invoices, err := loadInvoices()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the invoices.")
+		reportError("loading invoices", err)
+		return
+	}
+	if len(invoices) == 0 {
+		sendMessage(chatID, "No outstanding invoices.\n\n"+invoiceUsage)
+		return
+	}
+
+	today, _ := dayBounds(appClock.Now())
+	var sb strings.Builder
+	sb.WriteString("🧾 Outstanding invoices\n\n")
+	var total, overdue Money
+	byClient := make(map[string]Money)
+	var buttons [][]InlineKeyboardButton
+	for _, inv := range invoices {
+		days := int(inv.DueOn.Sub(today).Hours() / 24)
+		when := fmt.Sprintf("due in %d day(s)", days)
+		switch {
+		case days == 0:
+			when = "due today"
+		case days < 0:
+			when = fmt.Sprintf("⚠️ overdue by %d day(s)", -days)
+			overdue += inv.Amount
+		}
+		sb.WriteString(fmt.Sprintf("• %s · %s: %s, %s (%s)\n", inv.Number, inv.Client, formatMoney(inv.Amount), inv.DueOn.Format("2 Jan"), when))
+		total += inv.Amount
+		byClient[inv.Client] += inv.Amount
+		buttons = append(buttons, []InlineKeyboardButton{
+			{Text: "✅ " + inv.Number + " paid", CallbackData: fmt.Sprintf("invoice:paid:%d", inv.ID)},
+		})
+	}
+	sb.WriteString(fmt.Sprintf("\nReceivable: %s", formatMoney(total)))
+	if overdue > 0 {
+		sb.WriteString(fmt.Sprintf(", of which %s overdue", formatMoney(overdue)))
+	}
+	if len(byClient) > 1 {
+		clients := make([]string, 0, len(byClient))
+		for c := range byClient {
+			clients = append(clients, c)
+		}
+		sort.Slice(clients, func(i, j int) bool {
+			if byClient[clients[i]] != byClient[clients[j]] {
+				return byClient[clients[i]] > byClient[clients[j]]
+			}
+			return clients[i] < clients[j]
+		})
+		sb.WriteString("\n\nBy client:\n")
+		for _, c := range clients {
+			sb.WriteString(fmt.Sprintf("• %s: %s\n", c, formatMoney(byClient[c])))
+		}
+	}
+	sendMessageWithKeyboard(chatID, strings.TrimRight(sb.String(), "\n"), buildKeyboard(buttons))
+}
+
+// payInvoice marks an invoice paid and records its income in the ledger
+// of the invoice. A second payment is ignored.
+func payInvoice(id int64) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var inv invoice
+	err = tx.QueryRow("SELECT id, number, client, amount, category, status, ledger_id FROM invoices WHERE id = ?", id).
+		Scan(&inv.ID, &inv.Number, &inv.Client, &inv.Amount, &inv.Category, &inv.Status, &inv.LedgerID)
+	if err != nil {
+		return "This invoice no longer exists.", nil
+	}
+	if inv.Status == "paid" {
+		return fmt.Sprintf("Invoice %s is already paid.", inv.Number), nil
+	}
+
+	now := appClock.Now()
+	transactionID, err := addTransactionTx(tx, Transaction{Type: "income", Category: inv.Category, Quantity: 1, Amount: inv.Amount, Description: fmt.Sprintf("Invoice %s · %s", inv.Number, inv.Client), CreatedAt: now, LedgerID: inv.LedgerID})
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("INSERT INTO transaction_references (transaction_id, kind, value) VALUES (?, 'number', ?)", transactionID, inv.Number); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE invoices SET status = 'paid', paid_on = ?, transaction_id = ? WHERE id = ?",
+		now.Format(dateLayout), transactionID, inv.ID); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	transactionsChanged()
+	return fmt.Sprintf("✅ Invoice %s from %s paid: %s recorded under %s (#%d).", inv.Number, inv.Client, formatMoney(inv.Amount), inv.Category, transactionID), nil
+}
+
+// handleInvoiceCallback handles the "Mark paid" buttons: invoice:paid:<id>.
+func handleInvoiceCallback(callback *CallbackQuery) {
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 || parts[1] != "paid" {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	text, err := payInvoice(id)
+	if err != nil {
+		reportError(fmt.Sprintf("paying invoice %d", id), err)
+		sendMessage(callback.Message.Chat.ID, "Failed to record the payment.")
+		return
+	}
+	sendMessage(callback.Message.Chat.ID, text)
+}
+
+// sendInvoiceReminders queues a reminder for every overdue invoice, on the
+// day after its due date and then once a week, in every ledger.
+func sendInvoiceReminders(now time.Time) error {
+	rows, err := db.Query("SELECT id, number, client, amount, due_on FROM invoices WHERE status = 'invoiced' AND due_on < ? ORDER BY due_on, number",
+		now.Format(dateLayout))
+	if err != nil {
+		return err
+	}
+	var overdue []invoice
+	for rows.Next() {
+		var inv invoice
+		var due string
+		if err := rows.Scan(&inv.ID, &inv.Number, &inv.Client, &inv.Amount, &due); err != nil {
+			rows.Close()
+			return err
+		}
+		if inv.DueOn, err = time.ParseInLocation(dateLayout, due, appLocation()); err != nil {
+			rows.Close()
+			return fmt.Errorf("invoice %d: invalid due_on %q", inv.ID, due)
+		}
+		overdue = append(overdue, inv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	today, _ := dayBounds(now)
+	for _, inv := range overdue {
+		days := int(today.Sub(inv.DueOn).Hours() / 24)
+		text := fmt.Sprintf("⚠️ Invoice %s to %s (%s) is overdue by %d day(s), since %s.",
+			inv.Number, inv.Client, formatMoney(inv.Amount), days, inv.DueOn.Format("2 Jan"))
+		keyboard := buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "✅ Mark paid", CallbackData: fmt.Sprintf("invoice:paid:%d", inv.ID)},
+		}})
+		key := fmt.Sprintf("invoice:%d:%d", inv.ID, (days-1)/7)
+		if err := enqueueNotification(ownerID(), "invoice_reminder", key, text, keyboard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upcomingInvoices feeds outstanding invoices due this month into the
+// forecast as expected income.
+func upcomingInvoices(now time.Time) ([]upcomingEntry, error) {
+	invoices, err := loadInvoices()
+	if err != nil {
+		return nil, err
+	}
+	var upcoming []upcomingEntry
+	for _, inv := range invoices {
+		if inv.DueOn.Year() != now.Year() || inv.DueOn.Month() != now.Month() {
+			continue
+		}
+		day := inv.DueOn.Day()
+		if day < now.Day() {
+			day = now.Day()
+		}
+		upcoming = append(upcoming, upcomingEntry{Label: fmt.Sprintf("%s %s (invoice)", inv.Client, inv.Number), Type: "income", Amount: inv.Amount, Day: day})
+	}
+	return upcoming, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+/*
+	LATEST TRANSACTIONS (/get_latest_report)
+
+	The newest transactions as a table, ten at first:
+
+		ID    DATE        CATEGORY        AMOUNT
+		#214  2026-01-31  Food            -25,000.00
+		#213  2026-01-30  Salary       +8,000,000.00
+
+	Amounts are signed, income positive and expenses negative, and right
+	aligned. "Show more" extends the same message by ten rows, up to
+	listMax; /list and /view show the descriptions.
+*/
+
+const latestPageSize = 10
+
+// latestReportText returns the newest limit transactions as a table in
+// HTML, and whether there are more.
+func latestReportText(limit int) (string, bool, error) {
+	transactions, err := store.Transactions(TransactionFilter{Limit: limit + 1})
+	if err != nil {
+		return "", false, err
+	}
+	if len(transactions) == 0 {
+		return "", false, nil
+	}
+	more := len(transactions) > limit
+	if more {
+		transactions = transactions[:limit]
+	}
+
+	// tabwriter pads on the right, so the amounts are right aligned by hand
+	amounts := make([]string, len(transactions))
+	width := len("AMOUNT")
+	for i, t := range transactions {
+		amount := t.Amount
+		if t.Type == "expense" {
+			amount = -amount
+		}
+		amounts[i] = formatSignedMoney(amount)
+		width = max(width, len([]rune(amounts[i])))
+	}
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tDATE\tCATEGORY\t%*s\n", width, "AMOUNT")
+	for i, t := range transactions {
+		fmt.Fprintf(w, "#%d\t%s\t%s\t%*s\n", t.ID, t.CreatedAt.Format(dateLayout), t.Category, width, amounts[i])
+	}
+	if err := w.Flush(); err != nil {
+		return "", false, err
+	}
+
+	title := fmt.Sprintf("The %d newest transaction(s)", len(transactions))
+	return "🧾 " + bold(title) + "\n" + preformatted(strings.TrimRight(table.String(), "\n")), more && limit < listMax, nil
+}
+
+// latestKeyboard offers to extend a list of limit transactions.
+func latestKeyboard(limit int) InlineKeyboardMarkup {
+	next := min(limit+latestPageSize, listMax)
+	return buildKeyboard([][]InlineKeyboardButton{{
+		{Text: "⬇️ Show more", CallbackData: fmt.Sprintf("latest:%d", next)},
+	}})
+}
+
+func get_latest_report(chatID int64) {
+	text, more, err := latestReportText(latestPageSize)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the latest transactions.")
+		reportError("building the latest transactions report", err)
+		return
+	}
+	if text == "" {
+		sendMessage(chatID, "No transactions yet. Add one with /add, or send \"25000 lunch\".")
+		return
+	}
+	if more {
+		sendHTMLWithKeyboard(chatID, text, latestKeyboard(latestPageSize))
+		return
+	}
+	sendHTML(chatID, text)
+}
+
+// handleLatestCallback extends the latest transactions report in place.
+func handleLatestCallback(callback *CallbackQuery) {
+	limit, err := strconv.Atoi(strings.TrimPrefix(callback.Data, "latest:"))
+	if err != nil || limit < 1 || limit > listMax {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	text, more, err := latestReportText(limit)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the latest transactions.")
+		reportError("building the latest transactions report", err)
+		return
+	}
+	if text == "" {
+		editMessage(chatID, messageID, "No transactions yet.")
+		return
+	}
+	if more {
+		editHTMLWithKeyboard(chatID, messageID, text, latestKeyboard(limit))
+		return
+	}
+	editHTML(chatID, messageID, text)
+}
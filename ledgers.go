@@ -0,0 +1,313 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	LEDGERS (/ledger)
+
+	Transactions belong to a ledger, e.g. "personal" and "freelance", kept
+	apart on the same instance. One ledger is active: new transactions go
+	to it, and every command, report and digest only sees its transactions.
+	"/ledger all" combines the ledgers in what the commands see, while new
+	transactions still go to the active one; switching to a ledger separates
+	them again.
+
+	The ledger of a transaction is its ledger_id column; queries add
+	ledgerScope() to their conditions. Existing transactions and instances
+	that never create a second ledger stay in "personal" (id 1). The active
+	ledger is a setting of the instance, shared by its allowed users.
+
+	A ledger can keep its amounts in its own currency ("/ledger currency
+	EUR"), shown instead of the instance's one while it is active; see
+	currency.go.
+*/
+
+const ledgerUsage = "Usage:\n/ledger - list the ledgers\n/ledger <name> - switch to a ledger\n/ledger add <name> - create a ledger\n/ledger all - combine all ledgers in the reports\n/ledger currency <code|default> - set the currency of the active ledger"
+
+// defaultLedgerID is the ledger of the transactions made before ledgers
+// existed.
+const defaultLedgerID = 1
+
+type ledger struct {
+	ID       int64
+	Name     string
+	Currency string // ISO 4217 code, "" for the instance's currency
+}
+
+// activeLedgerID returns the ledger new transactions go to.
+func activeLedgerID() int64 {
+	id, err := strconv.ParseInt(getSetting("ledger", ""), 10, 64)
+	if err != nil || id <= 0 {
+		return defaultLedgerID
+	}
+	return id
+}
+
+// ledgersCombined tells whether the commands see every ledger.
+func ledgersCombined() bool {
+	return getBoolSetting("ledger_combined", false)
+}
+
+// ledgerScope returns the SQL condition, on an unqualified ledger_id
+// column, that limits a query to the transactions the commands see.
+func ledgerScope() string {
+	if ledgersCombined() {
+		return "1 = 1"
+	}
+	return fmt.Sprintf("ledger_id = %d", activeLedgerID())
+}
+
+func loadLedgers() ([]ledger, error) {
+	rows, err := db.Query("SELECT id, name, currency FROM ledgers ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ledgers []ledger
+	for rows.Next() {
+		var l ledger
+		if err := rows.Scan(&l.ID, &l.Name, &l.Currency); err != nil {
+			return nil, err
+		}
+		ledgers = append(ledgers, l)
+	}
+	return ledgers, rows.Err()
+}
+
+// findLedger looks a ledger up by name, ignoring case.
+func findLedger(name string) (ledger, bool, error) {
+	var l ledger
+	err := db.QueryRow("SELECT id, name FROM ledgers WHERE name = ? COLLATE NOCASE", strings.TrimSpace(name)).Scan(&l.ID, &l.Name)
+	if err == sql.ErrNoRows {
+		return ledger{}, false, nil
+	}
+	return l, err == nil, err
+}
+
+// ledgerName returns the name of a ledger, or its id when it is gone.
+func ledgerName(id int64) string {
+	var name string
+	if err := db.QueryRow("SELECT name FROM ledgers WHERE id = ?", id).Scan(&name); err != nil {
+		return fmt.Sprintf("#%d", id)
+	}
+	return name
+}
+
+// ledgerLabel describes what the commands see, e.g. "freelance", or ""
+// while the instance has a single ledger.
+func ledgerLabel() string {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM ledgers").Scan(&n); err != nil || n < 2 {
+		return ""
+	}
+	if ledgersCombined() {
+		return "all ledgers"
+	}
+	return ledgerName(activeLedgerID())
+}
+
+func switchLedger(id int64) error {
+	if err := setSetting("ledger", strconv.FormatInt(id, 10)); err != nil {
+		return err
+	}
+	if err := setSetting("ledger_combined", "false"); err != nil {
+		return err
+	}
+	refreshCurrency()
+	return nil
+}
+
+// combineLedgers makes the commands see every ledger.
+func combineLedgers() error {
+	if err := setSetting("ledger_combined", "true"); err != nil {
+		return err
+	}
+	refreshCurrency()
+	return nil
+}
+
+// updateLedgerCurrency stores the currency of a ledger, "" for the
+// instance's one.
+func updateLedgerCurrency(id int64, code string) error {
+	if _, err := db.Exec("UPDATE ledgers SET currency = ? WHERE id = ?", code, id); err != nil {
+		return err
+	}
+	refreshCurrency()
+	return nil
+}
+
+// setLedgerCurrency sets the currency of the active ledger, "" for the
+// instance's one.
+func setLedgerCurrency(chatID int64, code string) {
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	code = strings.ToUpper(code)
+	if strings.EqualFold(code, "default") {
+		code = ""
+	}
+	if code != "" && !currencyCodePattern.MatchString(code) {
+		sendMessage(chatID, fmt.Sprintf("%q is not an ISO 4217 currency code, e.g. IDR, USD or EUR.", code))
+		return
+	}
+	id := activeLedgerID()
+	if err := updateLedgerCurrency(id, code); err != nil {
+		sendMessage(chatID, "Failed to set the currency.")
+		reportError("setting the ledger currency", err)
+		return
+	}
+	name := ledgerName(id)
+	if code == "" {
+		sendMessage(chatID, fmt.Sprintf("📒 The %q ledger now uses the default currency, e.g. %s.", name, formatMoney(123456789)))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("📒 The %q ledger is now in %s, e.g. %s.", name, code, formatMoney(123456789)))
+}
+
+func handleLedgerCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		showLedgers(chatID)
+	case len(fields) >= 2 && strings.EqualFold(fields[0], "add"):
+		name := strings.Join(fields[1:], " ")
+		if isMaintenanceMode() {
+			sendMessage(chatID, "The bot is in read-only maintenance mode.")
+			return
+		}
+		if len([]rune(name)) > 32 {
+			sendMessage(chatID, "A ledger name has at most 32 characters.")
+			return
+		}
+		if _, ok, err := findLedger(name); err != nil || ok {
+			if err != nil {
+				reportError("looking up a ledger", err)
+			}
+			sendMessage(chatID, fmt.Sprintf("There is already a ledger named %q.", name))
+			return
+		}
+		res, err := db.Exec("INSERT INTO ledgers (name) VALUES (?)", name)
+		var id int64
+		if err == nil {
+			id, err = res.LastInsertId()
+		}
+		if err == nil {
+			err = switchLedger(id)
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to create the ledger.")
+			reportError("creating a ledger", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("📒 Ledger %q created and active. New transactions go to it.", name))
+	case len(fields) == 2 && strings.EqualFold(fields[0], "currency"):
+		setLedgerCurrency(chatID, fields[1])
+	case len(fields) == 1 && strings.EqualFold(fields[0], "all"):
+		if err := combineLedgers(); err != nil {
+			sendMessage(chatID, "Failed to combine the ledgers.")
+			reportError("combining the ledgers", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("📒 Reports now combine all ledgers. New transactions still go to %q; /ledger <name> separates them again.", ledgerName(activeLedgerID())))
+	default:
+		name := strings.Join(fields, " ")
+		l, ok, err := findLedger(name)
+		if err != nil {
+			sendMessage(chatID, "Failed to switch the ledger.")
+			reportError("looking up a ledger", err)
+			return
+		}
+		if !ok {
+			sendMessage(chatID, fmt.Sprintf("No ledger named %q. Create it with /ledger add %s.", name, name))
+			return
+		}
+		if err := switchLedger(l.ID); err != nil {
+			sendMessage(chatID, "Failed to switch the ledger.")
+			reportError("switching the ledger", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("📒 Switched to the %q ledger.", l.Name))
+	}
+}
+
+func showLedgers(chatID int64) {
+	ledgers, err := loadLedgers()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the ledgers.")
+		reportError("loading ledgers", err)
+		return
+	}
+	active, combined := activeLedgerID(), ledgersCombined()
+	var sb strings.Builder
+	sb.WriteString("📒 Ledgers\n\n")
+	var buttons []InlineKeyboardButton
+	for _, l := range ledgers {
+		mark := "•"
+		if l.ID == active {
+			mark = "✅"
+		}
+		if l.Currency != "" {
+			sb.WriteString(fmt.Sprintf("%s %s (%s)\n", mark, l.Name, l.Currency))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s %s\n", mark, l.Name))
+		}
+		if l.ID != active || combined {
+			buttons = append(buttons, InlineKeyboardButton{Text: l.Name, CallbackData: fmt.Sprintf("ledger:%d", l.ID)})
+		}
+	}
+	if combined {
+		sb.WriteString("\nReports combine all ledgers; new transactions go to the active one.\n")
+	} else if len(ledgers) > 1 {
+		buttons = append(buttons, InlineKeyboardButton{Text: "All ledgers", CallbackData: "ledger:all"})
+	}
+	sb.WriteString("\n" + ledgerUsage)
+
+	var rows [][]InlineKeyboardButton
+	for i := 0; i < len(buttons); i += 3 {
+		rows = append(rows, buttons[i:min(i+3, len(buttons))])
+	}
+	if len(rows) == 0 {
+		sendMessage(chatID, sb.String())
+		return
+	}
+	sendMessageWithKeyboard(chatID, sb.String(), buildKeyboard(rows))
+}
+
+// handleLedgerCallback switches the ledger from the /ledger buttons.
+func handleLedgerCallback(callback *CallbackQuery) {
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	arg := strings.TrimPrefix(callback.Data, "ledger:")
+	if arg == "all" {
+		if err := combineLedgers(); err != nil {
+			_ = messenger.AnswerCallback(callback.ID, "Failed to combine the ledgers.")
+			reportError("combining the ledgers", err)
+			return
+		}
+		_ = messenger.AnswerCallback(callback.ID, "")
+		editMessage(chatID, messageID, fmt.Sprintf("📒 Reports now combine all ledgers. New transactions still go to %q.", ledgerName(activeLedgerID())))
+		return
+	}
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM ledgers WHERE id = ?", id).Scan(&name); err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "That ledger no longer exists.")
+		return
+	}
+	if err := switchLedger(id); err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Failed to switch the ledger.")
+		reportError("switching the ledger", err)
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+	editMessage(chatID, messageID, fmt.Sprintf("📒 Switched to the %q ledger.", name))
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+	LOCATIONS (/locate, /map)
+
+	A transaction can remember where it happened. Share a location (📎 →
+	Location) while adding a transaction with /add, as a reply to the
+	confirmation of a saved one, or after /locate <id>; /locate <id> off
+	forgets it. /view shows the location, and /map [period] sends the
+	expenses of the period that have one as GeoJSON and as an HTML map.
+*/
+
+const locateUsage = "Usage:\n/locate <id> - then share a location to attach it\n/locate <id> off - remove the location"
+
+// mapTileURL is the OpenStreetMap tile server used by the HTML map.
+const mapTileURL = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+
+// addFlowSteps are the steps of /add and quick add during which a shared
+// location is kept for the transaction being added.
+var addFlowSteps = map[string]bool{
+	"SELECT_TYPE":       true,
+	"SELECT_CATEGORY":   true,
+	"ENTER_AMOUNT":      true,
+	"ENTER_DESCRIPTION": true,
+	"QUICK_CATEGORY":    true,
+}
+
+func validLocation(loc *TGLocation) bool {
+	return loc.Latitude >= -90 && loc.Latitude <= 90 && loc.Longitude >= -180 && loc.Longitude <= 180
+}
+
+func formatLocation(lat, lon float64) string {
+	return fmt.Sprintf("%.5f, %.5f", lat, lon)
+}
+
+// setTransactionLocation stores loc on transaction id, or removes its
+// location when loc is nil.
+func setTransactionLocation(id int64, loc *TGLocation) error {
+	if loc == nil {
+		_, err := db.Exec("UPDATE transactions SET latitude = NULL, longitude = NULL WHERE id = ?", id)
+		return err
+	}
+	_, err := db.Exec("UPDATE transactions SET latitude = ?, longitude = ? WHERE id = ?", loc.Latitude, loc.Longitude, id)
+	return err
+}
+
+// transactionLocation returns the location of a live or archived
+// transaction, if it has one.
+func transactionLocation(id int64) (lat, lon float64, ok bool, err error) {
+	var la, lo sql.NullFloat64
+	err = db.QueryRow(`SELECT latitude, longitude FROM transactions WHERE id = ?
+		UNION ALL SELECT latitude, longitude FROM transactions_archive WHERE id = ? LIMIT 1`, id, id).Scan(&la, &lo)
+	if err == sql.ErrNoRows {
+		return 0, 0, false, nil
+	}
+	if err != nil || !la.Valid || !lo.Valid {
+		return 0, 0, false, err
+	}
+	return la.Float64, lo.Float64, true, nil
+}
+
+// handleLocation handles a shared location: it belongs to the transaction
+// being added, to the one /locate waits for, or to the one whose
+// confirmation it replies to.
+func handleLocation(message *TGMessage) {
+	chatID, userID := message.Chat.ID, message.From.ID
+	loc := message.Location
+	if !validLocation(loc) {
+		sendMessage(chatID, "That location is out of range; nothing was saved.")
+		return
+	}
+
+	if state, ok := userStates[userID]; ok {
+		switch {
+		case addFlowSteps[state.Step]:
+			state.Location = loc
+			sendMessage(chatID, fmt.Sprintf("📍 Location %s noted; it will be saved with the transaction.", formatLocation(loc.Latitude, loc.Longitude)))
+			return
+		case state.Step == "AWAIT_LOCATION":
+			delete(userStates, userID)
+			attachLocation(chatID, userID, state.EditID, loc)
+			return
+		}
+	}
+
+	if message.ReplyToMessage != nil {
+		var id int64
+		err := db.QueryRow("SELECT transaction_id FROM transaction_messages WHERE chat_id = ? AND message_id = ?",
+			chatID, message.ReplyToMessage.MessageID).Scan(&id)
+		if err == nil {
+			if isMaintenanceMode() {
+				sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+				return
+			}
+			attachLocation(chatID, userID, id, loc)
+			return
+		}
+		if err != sql.ErrNoRows {
+			reportError("looking up a replied message", err)
+		}
+	}
+	sendMessage(chatID, "Share a location while adding a transaction, as a reply to the confirmation of a saved one, or after /locate <id>.")
+}
+
+// attachLocation stores loc on transaction id after the closed-period check.
+func attachLocation(chatID int64, userID int64, id int64, loc *TGLocation) {
+	var createdAt string
+	err := db.QueryRow("SELECT created_at FROM transactions WHERE id = ? AND "+ledgerScope(), id).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d no longer exists; nothing was saved.", id))
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+	if !allowChange(chatID, userID, id, createdAt) {
+		return
+	}
+	if err := setTransactionLocation(id, loc); err != nil {
+		sendMessage(chatID, "Failed to save the location.")
+		reportError("saving the location of a transaction", err)
+		return
+	}
+	if loc == nil {
+		sendMessage(chatID, fmt.Sprintf("Location removed from transaction #%d.", id))
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("📍 Location %s saved for transaction #%d.", formatLocation(loc.Latitude, loc.Longitude), id))
+}
+
+// handleLocateCommand implements /locate <id> [off].
+func handleLocateCommand(chatID int64, userID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 || (len(fields) == 2 && !strings.EqualFold(fields[1], "off")) {
+		sendMessage(chatID, locateUsage)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(fields[0], "#"), 10, 64)
+	if err != nil || id <= 0 {
+		sendMessage(chatID, locateUsage)
+		return
+	}
+	if len(fields) == 2 {
+		attachLocation(chatID, userID, id, nil)
+		return
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE id = ? AND "+ledgerScope(), id).Scan(&n); err != nil {
+		sendMessage(chatID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+	if n == 0 {
+		sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
+		return
+	}
+	userStates[userID] = &TransactionState{UserID: userID, Step: "AWAIT_LOCATION", EditID: id}
+	sendMessage(chatID, fmt.Sprintf("Share the location of transaction #%d (📎 → Location), or send 'cancel' to abort.", id))
+}
+
+type mapPoint struct {
+	ID          int64
+	Category    string
+	Amount      Money
+	Description string
+	CreatedAt   string
+	Lat, Lon    float64
+}
+
+// mapPoints returns the expenses created in [from, to) that have a
+// location, oldest first.
+func mapPoints(from, to string) ([]mapPoint, error) {
+	rows, err := db.Query(`SELECT id, category, `+lineTotal+`, description, created_at, latitude, longitude FROM transactions
+		WHERE type = 'expense' AND latitude IS NOT NULL AND longitude IS NOT NULL AND created_at >= ? AND created_at < ? AND `+ledgerScope()+`
+		ORDER BY created_at, id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []mapPoint
+	for rows.Next() {
+		var p mapPoint
+		var description sql.NullString
+		if err := rows.Scan(&p.ID, &p.Category, &p.Amount, &description, &p.CreatedAt, &p.Lat, &p.Lon); err != nil {
+			return nil, err
+		}
+		p.Description = description.String
+		p.CreatedAt = formatCreatedAt(p.CreatedAt)
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// geoJSON builds a FeatureCollection with one point per expense.
+func geoJSON(points []mapPoint) map[string]interface{} {
+	features := make([]interface{}, 0, len(points))
+	for _, p := range points {
+		features = append(features, map[string]interface{}{
+			"type":     "Feature",
+			"geometry": map[string]interface{}{"type": "Point", "coordinates": []float64{p.Lon, p.Lat}},
+			"properties": map[string]interface{}{
+				"id":          p.ID,
+				"category":    p.Category,
+				"amount":      p.Amount,
+				"description": p.Description,
+				"date":        p.CreatedAt,
+			},
+		})
+	}
+	return map[string]interface{}{"type": "FeatureCollection", "features": features}
+}
+
+func writeGeoJSON(w io.Writer, points []mapPoint) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(geoJSON(points))
+}
+
+// writeMapHTML writes a standalone Leaflet page with the points. The JSON
+// encoder escapes <, > and &, so the data is safe inside the script tag.
+func writeMapHTML(w io.Writer, title string, points []mapPoint) error {
+	data, err := json.Marshal(geoJSON(points))
+	if err != nil {
+		return err
+	}
+	titleJSON, _ := json.Marshal(title)
+	_, err = fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Spending map</title>
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+<style>html, body, #map { height: 100%%; margin: 0; }</style>
+</head>
+<body>
+<div id="map"></div>
+<script>
+var data = %s;
+document.title = %s;
+var map = L.map('map');
+L.tileLayer('%s', { maxZoom: 19, attribution: '&copy; OpenStreetMap contributors' }).addTo(map);
+var layer = L.geoJSON(data, {
+  onEachFeature: function (f, l) {
+    var p = f.properties, div = document.createElement('div');
+    div.textContent = '#' + p.id + ' · ' + p.date + ' · ' + p.category + ' ' + p.amount.toFixed(2) + (p.description ? ' · ' + p.description : '');
+    l.bindPopup(div);
+  }
+}).addTo(map);
+map.fitBounds(layer.getBounds(), { maxZoom: 15, padding: [20, 20] });
+</script>
+</body>
+</html>
+`, data, titleJSON, mapTileURL)
+	return err
+}
+
+// handleMapCommand implements /map [period].
+func handleMapCommand(chatID int64, args string) {
+	from, to, label, err := parsePeriod(args, appClock.Now())
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v. Usage: /map [YYYY-MM | YYYY | YYYY-MM-DD YYYY-MM-DD]", err))
+		return
+	}
+	points, err := mapPoints(from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		sendMessage(chatID, "Failed to load the locations.")
+		reportError("loading transaction locations", err)
+		return
+	}
+	if len(points) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No expenses with a location in %s. Share a location while adding one, or use /locate <id>.", label))
+		return
+	}
+	var total Money
+	for _, p := range points {
+		total += p.Amount
+	}
+	title := "Spending map, " + label
+	files := []struct {
+		file    exportFile
+		caption string
+	}{
+		{exportFile{"spending-map-*.html", func(w io.Writer) error { return writeMapHTML(w, title, points) }},
+			fmt.Sprintf("🗺️ %d expense(s) with a location in %s, %s in total. Open the HTML file in a browser.", len(points), label, formatMoney(total))},
+		{exportFile{"spending-map-*.geojson", func(w io.Writer) error { return writeGeoJSON(w, points) }}, ""},
+	}
+	for _, f := range files {
+		if err := sendExportFile(chatID, f.file, f.caption); err != nil {
+			sendMessage(chatID, "Failed to send the map.")
+			reportError("sending the spending map", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+/*
+	LONG MESSAGES
+
+	Telegram refuses messages over 4096 characters, so a long report (a
+	year of /list, a big /report, the audit history of a transaction)
+	would not arrive at all. The messengers send every text through
+	deliverText (the length counts the HTML markup too, so parts are a
+	little shorter than they could be):
+
+	  - up to messageLimit characters, it is sent as is;
+	  - up to longMessageDocument characters, it is split at the last blank
+	    line that fits, else the last line break, else the last space, and
+	    the parts are sent in order, numbered "(1/3)", "(2/3)"...;
+	  - longer, it is attached as a .txt document, followed by a message
+	    with its first lines, as a long run of messages is harder to read
+	    (and to forward) than one file.
+
+	A keyboard goes with the last message, whose id is returned so the
+	keyboard can be edited later. An edit cannot add messages, so a text
+	too long for an edit is cut and ends with a note. Cuts never fall
+	inside a tag or an entity, and formatting open at a cut is closed
+	there and opened again in the next part (html.go).
+*/
+
+const (
+	messageLimit        = 4096  // characters of a Telegram message, counted in UTF-16 units
+	longMessageDocument = 12000 // longer texts are sent as a document
+	longMessagePreview  = 1000  // characters of the message that goes with the document
+)
+
+// textLength counts characters the way Telegram does, in UTF-16 units.
+func textLength(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// fittingPrefix returns the byte length of the longest prefix of s that is
+// at most limit characters long.
+func fittingPrefix(s string, limit int) int {
+	n := 0
+	for i, r := range s {
+		n += utf16.RuneLen(r)
+		if n > limit {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// splitMessage cuts text in parts of at most limit characters, at blank
+// lines, line breaks or spaces where possible. A break is only used in the
+// second half of a part, so no part is much shorter than it has to be.
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	for textLength(text) > limit {
+		cut := safeCut(text, fittingPrefix(text, limit))
+		part, rest := text[:cut], text[cut:]
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			if i := strings.LastIndex(text[:cut], sep); i >= cut/2 {
+				part, rest = text[:safeCut(text, i)], text[i+len(sep):]
+				break
+			}
+		}
+		part, reopen := balanceTags(part)
+		parts = append(parts, part)
+		text = reopen + rest
+	}
+	return append(parts, text)
+}
+
+// deliverText sends text through send, which sends one message and adds
+// the keyboard, if any, when last is true. It returns the id of the last
+// message.
+func deliverText(chatID int64, text string, sendFile func(chatID int64, path string, caption string) error, send func(text string, last bool) (int, error)) (int, error) {
+	length := textLength(text)
+	if length <= messageLimit {
+		return send(text, true)
+	}
+	if length > longMessageDocument {
+		if err := sendTextDocument(chatID, text, sendFile); err != nil {
+			return 0, err
+		}
+		preview := text[:safeCut(text, fittingPrefix(text, longMessagePreview))]
+		if i := strings.LastIndex(preview, "\n"); i > 0 {
+			preview = preview[:safeCut(preview, i)]
+		}
+		preview, _ = balanceTags(preview)
+		return send(fmt.Sprintf("%s\n…\n\n📎 Too long for a message (%d characters): the full text is in the file above.", preview, length), true)
+	}
+	// room for the "\n\n(12/12)" marker and closing tags
+	parts := splitMessage(text, messageLimit-64)
+	var id int
+	for i, part := range parts {
+		var err error
+		id, err = send(fmt.Sprintf("%s\n\n(%d/%d)", strings.TrimRight(part, "\n"), i+1, len(parts)), i == len(parts)-1)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// sendTextDocument attaches text as a .txt file, without the formatting.
+func sendTextDocument(chatID int64, text string, sendFile func(chatID int64, path string, caption string) error) error {
+	f, err := os.CreateTemp(dataSubdir("exports"), "message-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	text = htmlToPlain(text)
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	caption, _, _ := strings.Cut(text, "\n")
+	return sendFile(chatID, f.Name(), caption[:fittingPrefix(caption, 200)])
+}
+
+// fitEdit cuts a text too long for an edit, with a note.
+func fitEdit(text string) string {
+	if textLength(text) <= messageLimit {
+		return text
+	}
+	note := "\n…\n\n✂️ Cut to fit in a message."
+	text = text[:safeCut(text, fittingPrefix(text, messageLimit-64))]
+	if i := strings.LastIndex(text, "\n"); i >= len(text)/2 {
+		text = text[:safeCut(text, i)]
+	}
+	text, _ = balanceTags(text)
+	return text + note
+}
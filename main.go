@@ -1,1783 +1,2804 @@
-package main
-
-import (
-	"bytes"
-	"database/sql"
-	"encoding/csv"
-	"encoding/json"
-	"flag"
-	"fmt"
-	"io"
-	"log"
-	"mime/multipart"
-	"net/http"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"strconv"
-	"strings"
-	"time"
-
-	"github.com/joho/godotenv"
-	_ "github.com/mattn/go-sqlite3"
-)
-
-// --- Minimal Telegram client using only stdlib ---
-// Types mirror only the fields we need.
-type Update struct {
-	UpdateID      int            `json:"update_id"`
-	Message       *TGMessage     `json:"message,omitempty"`
-	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"`
-}
-
-type TGMessage struct {
-	MessageID int         `json:"message_id"`
-	From      *TGUser     `json:"from,omitempty"`
-	Chat      *TGChat     `json:"chat,omitempty"`
-	Text      string      `json:"text,omitempty"`
-	Date      int64       `json:"date,omitempty"`
-	Document  *TGDocument `json:"document,omitempty"`
-}
-
-type TGDocument struct {
-	FileID   string `json:"file_id"`
-	FileName string `json:"file_name,omitempty"`
-	MimeType string `json:"mime_type,omitempty"`
-	FileSize int    `json:"file_size,omitempty"`
-}
-
-type TGUser struct {
-	ID        int64  `json:"id"`
-	FirstName string `json:"first_name"`
-	UserName  string `json:"username"`
-}
-
-type TGChat struct {
-	ID int64 `json:"id"`
-}
-
-type CallbackQuery struct {
-	ID      string     `json:"id"`
-	From    *TGUser    `json:"from"`
-	Message *TGMessage `json:"message,omitempty"`
-	Data    string     `json:"data,omitempty"`
-}
-
-type InlineKeyboardButton struct {
-	Text         string `json:"text"`
-	CallbackData string `json:"callback_data,omitempty"`
-}
-
-type InlineKeyboardMarkup struct {
-	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
-}
-
-type BotClient struct {
-	token      string
-	baseURL    string
-	httpClient *http.Client
-}
-
-func NewBotClient(token string) *BotClient {
-	return &BotClient{
-		token:      token,
-		baseURL:    fmt.Sprintf("https://api.telegram.org/bot%s", token),
-		httpClient: &http.Client{Timeout: 60 * time.Second},
-	}
-}
-
-func (b *BotClient) apiPost(path string, body interface{}, contentType string) ([]byte, error) {
-	url := b.baseURL + "/" + path
-	var bodyReader io.Reader
-	var ct string
-
-	if contentType == "application/json" {
-		var buf bytes.Buffer
-		if err := json.NewEncoder(&buf).Encode(body); err != nil {
-			return nil, err
-		}
-		bodyReader = &buf
-		ct = "application/json"
-	} else {
-		// body is already an io.Reader for multipart (handled by caller)
-		if rdr, ok := body.(io.Reader); ok {
-			bodyReader = rdr
-			ct = contentType
-		} else {
-			return nil, fmt.Errorf("unsupported body type for contentType %s", contentType)
-		}
-	}
-
-	req, err := http.NewRequest("POST", url, bodyReader)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", ct)
-
-	resp, err := b.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	return io.ReadAll(resp.Body)
-}
-
-func (b *BotClient) apiGet(path string, params map[string]string) ([]byte, error) {
-	url := b.baseURL + "/" + path
-	if params != nil && len(params) > 0 {
-		q := "?"
-		first := true
-		for k, v := range params {
-			if !first {
-				q += "&"
-			}
-			q += fmt.Sprintf("%s=%s", k, v)
-			first = false
-		}
-		url += q
-	}
-	resp, err := b.httpClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	return io.ReadAll(resp.Body)
-}
-
-// DownloadFile downloads a Telegram file (by file_id) to a temporary local file.
-// Returns the path to the temp file (caller should remove it when done).
-func (b *BotClient) DownloadFile(fileID string) (string, error) {
-	// Call getFile to obtain file_path
-	data, err := b.apiGet("getFile", map[string]string{"file_id": fileID})
-	if err != nil {
-		return "", fmt.Errorf("getFile failed: %w", err)
-	}
-	var gf struct {
-		OK     bool `json:"ok"`
-		Result struct {
-			FileID   string `json:"file_id"`
-			FileSize int    `json:"file_size"`
-			FilePath string `json:"file_path"`
-		} `json:"result"`
-	}
-	if err := json.Unmarshal(data, &gf); err != nil {
-		return "", fmt.Errorf("failed to parse getFile response: %w", err)
-	}
-	if gf.Result.FilePath == "" {
-		return "", fmt.Errorf("file_path not present in getFile response")
-	}
-
-	downloadURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", b.token, gf.Result.FilePath)
-	resp, err := http.Get(downloadURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to download file from %s: %w", downloadURL, err)
-	}
-	defer resp.Body.Close()
-
-	ext := filepath.Ext(gf.Result.FilePath)
-	if ext == "" {
-		ext = ".bin"
-	}
-	tmpFile, err := os.CreateTemp("", "tgfile-*"+ext)
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer tmpFile.Close()
-
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		_ = os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("failed to write file to temp: %w", err)
-	}
-
-	return tmpFile.Name(), nil
-}
-
-func (b *BotClient) GetUpdates(offset int, timeout int) ([]Update, error) {
-	params := map[string]string{
-		"timeout": strconv.Itoa(timeout),
-	}
-	if offset > 0 {
-		params["offset"] = strconv.Itoa(offset)
-	}
-	data, err := b.apiGet("getUpdates", params)
-	if err != nil {
-		return nil, err
-	}
-	var result struct {
-		OK     bool     `json:"ok"`
-		Result []Update `json:"result"`
-	}
-	if err := json.Unmarshal(data, &result); err != nil {
-		return nil, err
-	}
-	return result.Result, nil
-}
-
-func (b *BotClient) SendMessage(chatID int64, text string, replyMarkup interface{}) (*TGMessage, error) {
-	payload := map[string]interface{}{
-		"chat_id": chatID,
-		"text":    text,
-	}
-	if replyMarkup != nil {
-		payload["reply_markup"] = replyMarkup
-	}
-	data, err := b.apiPost("sendMessage", payload, "application/json")
-	if err != nil {
-		return nil, err
-	}
-	var result struct {
-		OK     bool       `json:"ok"`
-		Result *TGMessage `json:"result"`
-	}
-	if err := json.Unmarshal(data, &result); err != nil {
-		return nil, err
-	}
-	return result.Result, nil
-}
-
-func (b *BotClient) EditMessageText(chatID int64, messageID int, text string, replyMarkup interface{}) (*TGMessage, error) {
-	payload := map[string]interface{}{
-		"chat_id":    chatID,
-		"message_id": messageID,
-		"text":       text,
-	}
-	if replyMarkup != nil {
-		payload["reply_markup"] = replyMarkup
-	}
-	data, err := b.apiPost("editMessageText", payload, "application/json")
-	if err != nil {
-		return nil, err
-	}
-	var result struct {
-		OK     bool       `json:"ok"`
-		Result *TGMessage `json:"result"`
-	}
-	if err := json.Unmarshal(data, &result); err != nil {
-		return nil, err
-	}
-	return result.Result, nil
-}
-
-func (b *BotClient) AnswerCallbackQuery(callbackID string, text string) error {
-	payload := map[string]interface{}{
-		"callback_query_id": callbackID,
-		"text":              text,
-	}
-	_, err := b.apiPost("answerCallbackQuery", payload, "application/json")
-	return err
-}
-
-// SendPhoto uploads a local file (photoPath) and sends it to chatID with optional caption
-func (b *BotClient) SendPhoto(chatID int64, photoPath string, caption string) (*TGMessage, error) {
-	url := b.baseURL + "/sendPhoto"
-	var buf bytes.Buffer
-	w := multipart.NewWriter(&buf)
-
-	_ = w.WriteField("chat_id", strconv.FormatInt(chatID, 10))
-	if caption != "" {
-		_ = w.WriteField("caption", caption)
-	}
-
-	fw, err := w.CreateFormFile("photo", filepath.Base(photoPath))
-	if err != nil {
-		return nil, err
-	}
-	file, err := os.Open(photoPath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	if _, err := io.Copy(fw, file); err != nil {
-		return nil, err
-	}
-	w.Close()
-
-	returned, err := b.apiPost(url[len(b.baseURL)+1:], &buf, w.FormDataContentType())
-	if err != nil {
-		return nil, err
-	}
-	var result struct {
-		OK     bool       `json:"ok"`
-		Result *TGMessage `json:"result"`
-	}
-	if err := json.Unmarshal(returned, &result); err != nil {
-		return nil, err
-	}
-	return result.Result, nil
-}
-
-// SendDocument uploads a local file (documentPath) and sends it to chatID with optional caption
-func (b *BotClient) SendDocument(chatID int64, documentPath string, caption string) (*TGMessage, error) {
-	url := b.baseURL + "/sendDocument"
-	var buf bytes.Buffer
-	w := multipart.NewWriter(&buf)
-
-	_ = w.WriteField("chat_id", strconv.FormatInt(chatID, 10))
-	if caption != "" {
-		_ = w.WriteField("caption", caption)
-	}
-
-	fw, err := w.CreateFormFile("document", filepath.Base(documentPath))
-	if err != nil {
-		return nil, err
-	}
-	file, err := os.Open(documentPath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	if _, err := io.Copy(fw, file); err != nil {
-		return nil, err
-	}
-	w.Close()
-
-	returned, err := b.apiPost(url[len(b.baseURL)+1:], &buf, w.FormDataContentType())
-	if err != nil {
-		return nil, err
-	}
-	var result struct {
-		OK     bool       `json:"ok"`
-		Result *TGMessage `json:"result"`
-	}
-	if err := json.Unmarshal(returned, &result); err != nil {
-		return nil, err
-	}
-	return result.Result, nil
-}
-
-// --- End minimal telegram client ---
-
-var (
-	API_TOKEN       string
-	ALLOWED_USER_ID int64
-	DB_PATH         string
-	categories      []string
-	botClient       *BotClient
-	db              *sql.DB
-)
-
-type TransactionState struct {
-	UserID          int64
-	Step            string // Tracks current state step
-	TransactionType string // "income" or "expense"
-	Category        string
-	Amount          float64
-	Quantity        float64
-	Description     string
-	EditID          int64 // ID of transaction being edited/deleted
-	PromptMessageID int   // message id that was edited to prompt user (used to remove keyboard / show confirmation)
-	IsOutlier       bool
-}
-
-var userStates = make(map[int64]*TransactionState)
-
-func main() {
-	var err error
-
-	// Load env vars (optional)
-	if err = godotenv.Load(); err != nil {
-		log.Println("No .env file found, continuing")
-	}
-
-	// Flags
-	dataPath := flag.String("data", "", "Path to database file")
-	flag.Parse()
-
-	API_TOKEN = os.Getenv("API_TOKEN")
-	ALLOWED_USER_ID, _ = strconv.ParseInt(os.Getenv("ALLOWED_USER_ID"), 10, 64)
-
-	if *dataPath != "" {
-		DB_PATH = *dataPath
-	} else {
-		DB_PATH = os.Getenv("DB_PATH")
-	}
-
-	if DB_PATH == "" {
-		log.Fatal("DB path must be provided via --data or DB_PATH env var")
-	}
-
-	// Init bot client (stdlib)
-	botClient = NewBotClient(API_TOKEN)
-	// Try to get bot info (optional)
-	if info, err := botClient.apiGet("getMe", nil); err == nil {
-		var me struct {
-			OK     bool            `json:"ok"`
-			Result json.RawMessage `json:"result"`
-		}
-		_ = json.Unmarshal(info, &me)
-		// We don't strictly need it; just log success
-		log.Println("Telegram client initialized (getMe ok)")
-	} else {
-		log.Printf("Failed to call getMe: %v", err)
-	}
-
-	// Init DB
-	db, err = sql.Open("sqlite3", DB_PATH)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer db.Close()
-
-	if err := initDB(db); err != nil {
-		log.Panic(err)
-	}
-
-	if err := seedCategories(db); err != nil {
-		log.Panic(err)
-	}
-
-	categories, err = loadCategories(db)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	log.Printf("Loaded categories: %s", strings.Join(categories, ", "))
-
-	// Long-polling loop
-	offset := 0
-	for {
-		updates, err := botClient.GetUpdates(offset, 60)
-		if err != nil {
-			log.Printf("GetUpdates error: %v", err)
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		for _, update := range updates {
-			if update.Message != nil {
-				handleMessage(update.Message)
-			} else if update.CallbackQuery != nil {
-				handleCallbackQuery(update.CallbackQuery)
-			}
-			offset = update.UpdateID + 1
-		}
-	}
-}
-
-// Helper to build keyboard in our InlineKeyboardMarkup shape
-func buildKeyboard(rows [][]InlineKeyboardButton) InlineKeyboardMarkup {
-	return InlineKeyboardMarkup{InlineKeyboard: rows}
-}
-
-func getCategories() ([]string, error) {
-	return loadCategories(db)
-}
-
-func initDB(db *sql.DB) error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS categories (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL UNIQUE,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS transactions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			type TEXT NOT NULL,
-			category TEXT NOT NULL,
-			quantity REAL NOT NULL DEFAULT 1,
-			amount REAL NOT NULL,
-			description TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			is_outlier BOOLEAN
-		)`,
-	}
-
-	for _, q := range queries {
-		if _, err := db.Exec(q); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func seedCategories(db *sql.DB) error {
-	defaultCategories := []string{
-		"Food",
-		"Salary",
-		"Needs",
-		"Water",
-		"Laundry",
-		"Transportation",
-		"Utilities",
-		"Rent",
-		"Bills",
-	}
-
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO categories (name) VALUES (?)`)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
-	for _, cat := range defaultCategories {
-		if _, err := stmt.Exec(cat); err != nil {
-			return err
-		}
-	}
-
-	return tx.Commit()
-}
-
-func loadCategories(db *sql.DB) ([]string, error) {
-	rows, err := db.Query(`SELECT name FROM categories ORDER BY name`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var result []string
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			return nil, err
-		}
-		result = append(result, name)
-	}
-	return result, nil
-}
-
-// Message handlers adapted to stdlib types
-func handleMessage(message *TGMessage) {
-	if message.From == nil {
-		return
-	}
-	userID := message.From.ID
-	if userID != ALLOWED_USER_ID {
-		sendMessage(message.Chat.ID, "You are not authorized to use this bot.")
-		return
-	}
-
-	// If document is present, handle document upload flow first
-	if message.Document != nil {
-		handleDocument(message)
-		return
-	}
-
-	// Detect commands: Telegram sends text like "/add" in message.Text
-	text := strings.TrimSpace(message.Text)
-	command := ""
-	args := ""
-	if text != "" && strings.HasPrefix(text, "/") {
-		parts := strings.SplitN(text, " ", 2)
-		command = strings.TrimPrefix(parts[0], "/")
-		if len(parts) > 1 {
-			args = parts[1]
-		}
-	}
-
-	switch command {
-	case "add":
-		startTransaction(message.Chat.ID, userID)
-	case "summary":
-		showSummary(message.Chat.ID)
-	case "get_latest_report":
-		get_latest_report(message.Chat.ID)
-	case "get_weekly_expense":
-		get_weekly_expense_report(message.Chat.ID)
-	case "get_weekly_expense_piechart":
-		get_weekly_expense_piechart(message.Chat.ID)
-	case "edit":
-		args = strings.TrimSpace(args)
-		if args != "" {
-			id, err := strconv.ParseInt(args, 10, 64)
-			if err != nil {
-				sendMessage(message.Chat.ID, "Invalid ID provided. Usage: /edit <id>")
-				return
-			}
-			startEditWithID(message.Chat.ID, userID, id)
-		} else {
-			startEdit(message.Chat.ID, userID)
-		}
-	case "delete":
-		args = strings.TrimSpace(args)
-		if args != "" {
-			id, err := strconv.ParseInt(args, 10, 64)
-			if err != nil {
-				sendMessage(message.Chat.ID, "Invalid ID provided. Usage: /delete <id>")
-				return
-			}
-			startDeleteWithID(message.Chat.ID, userID, id)
-		} else {
-			startDelete(message.Chat.ID, userID)
-		}
-	case "export_csv", "export":
-		exportCSV(message.Chat.ID)
-	case "bulk_transactions":
-		startBulkTransactions(message.Chat.ID, userID)
-	default:
-		if state, exists := userStates[userID]; exists {
-			switch state.Step {
-			case "ENTER_AMOUNT":
-				processAmount(message, state)
-			case "ENTER_DESCRIPTION":
-				processDescription(message, state)
-			case "ENTER_EDIT_ID":
-				processEditId(message, state)
-			case "ENTER_EDIT_AMOUNT":
-				processEditAmountEdit(message, state)
-			case "ENTER_EDIT_DESCRIPTION":
-				processEditDescriptionEdit(message, state)
-			case "ENTER_DELETE_ID":
-				processDeleteId(message, state)
-			case "AWAIT_CSV":
-				// If the user typed something while awaiting CSV, allow text "cancel"
-				if strings.ToLower(strings.TrimSpace(message.Text)) == "cancel" {
-					delete(userStates, userID)
-					sendMessage(message.Chat.ID, "Bulk import canceled.")
-					return
-				}
-				sendMessage(message.Chat.ID, "Awaiting CSV file. Please send it as a document, or send 'cancel' to abort.")
-			case "ENTER_EDIT_QUANTITY":
-				processEditQuantityEdit(message, state)
-			default:
-				sendMessage(message.Chat.ID, "I don't understand that command.")
-			}
-		} else {
-			sendMessage(message.Chat.ID, "I don't understand that command.")
-		}
-	}
-}
-
-func handleCallbackQuery(callback *CallbackQuery) {
-	userID := callback.From.ID
-	if userID != ALLOWED_USER_ID {
-		sendMessage(callback.Message.Chat.ID, "You are not authorized to use this bot.")
-		return
-	}
-
-	state, exists := userStates[userID]
-	if !exists {
-		// If there's no state but callback comes from edit/delete menu, ignore
-		_ = botClient.AnswerCallbackQuery(callback.ID, "")
-		return
-	}
-
-	// Remove "loading" state in client
-	_ = botClient.AnswerCallbackQuery(callback.ID, "")
-
-	switch state.Step {
-	case "SELECT_TYPE":
-		processTransactionType(callback, state)
-	case "SELECT_CATEGORY":
-		processCategory(callback, state)
-	case "SELECT_EDIT_FIELD":
-		processEditField(callback, state)
-	case "SELECT_EDIT_TYPE":
-		processEditTransactionType(callback, state)
-	case "SELECT_EDIT_CATEGORY":
-		processEditCategory(callback, state)
-	case "SELECT_EDIT_IS_OUTLIER":
-		processEditIsOutlier(callback, state)
-	case "CONFIRM_DELETE":
-		processDeleteConfirmation(callback, state)
-	default:
-		// no-op
-	}
-}
-
-func startTransaction(chatID int64, userID int64) {
-	state := &TransactionState{
-		UserID:   userID,
-		Step:     "SELECT_TYPE",
-		Quantity: 1,
-	}
-	userStates[userID] = state
-
-	buttons := [][]InlineKeyboardButton{
-		{
-			InlineKeyboardButton{Text: "Income", CallbackData: "income"},
-			InlineKeyboardButton{Text: "Expense", CallbackData: "expense"},
-		},
-	}
-	keyboard := buildKeyboard(buttons)
-	sendMessageWithKeyboard(chatID, "Please choose the type of transaction:", keyboard)
-}
-
-// startBulkTransactions starts the two-step flow for CSV upload via Telegram.
-// User will be prompted to send the CSV file as a document.
-func startBulkTransactions(chatID int64, userID int64) {
-	state := &TransactionState{
-		UserID: userID,
-		Step:   "AWAIT_CSV",
-	}
-	userStates[userID] = state
-	sendMessage(chatID, "Please send the CSV file as a document now. Supported CSV columns (header-based) include: type,category,quantity,amount,description,created_at,is_outlier. Legacy positional files (type,category,amount,description,created_at) are also supported. Send 'cancel' to abort.")
-}
-
-// handleDocument handles incoming document messages: used for bulk CSV import
-func handleDocument(message *TGMessage) {
-	if message.From == nil || message.Chat == nil || message.Document == nil {
-		return
-	}
-	userID := message.From.ID
-	chatID := message.Chat.ID
-
-	if userID != ALLOWED_USER_ID {
-		sendMessage(chatID, "You are not authorized to use this bot.")
-		return
-	}
-
-	state, exists := userStates[userID]
-	if !exists || state.Step != "AWAIT_CSV" {
-		sendMessage(chatID, "No bulk import in progress. Start with /bulk_transactions")
-		return
-	}
-
-	// Basic check: prefer file name extension, fallback to mime type
-	lowerName := strings.ToLower(message.Document.FileName)
-	if lowerName == "" {
-		lowerName = strings.ToLower(message.Document.MimeType)
-	}
-	if !strings.Contains(lowerName, "csv") && !strings.HasSuffix(lowerName, ".csv") {
-		sendMessage(chatID, "Please upload a CSV file (filename must end with .csv or mime type should indicate CSV).")
-		return
-	}
-
-	// Download file
-	tmpPath, err := botClient.DownloadFile(message.Document.FileID)
-	if err != nil {
-		log.Printf("Failed to download document: %v", err)
-		sendMessage(chatID, "Failed to download the uploaded file. See server logs.")
-		delete(userStates, userID)
-		return
-	}
-	// Ensure cleanup
-	defer func() {
-		_ = os.Remove(tmpPath)
-	}()
-
-	sendMessage(chatID, "File received. Processing...")
-
-	// Run import
-	inserted, errs := bulkInsertFromCSV(tmpPath)
-
-	if len(errs) == 0 {
-		sendMessage(chatID, fmt.Sprintf("Import complete: %d rows inserted.", inserted))
-	} else {
-		sendMessage(chatID, fmt.Sprintf("Import finished: %d rows inserted. There were %d errors (see server logs).", inserted, len(errs)))
-		for _, e := range errs {
-			log.Printf("CSV import error: %v", e)
-		}
-	}
-
-	// refresh categories cache (in case new categories were inserted)
-	if cats, err := loadCategories(db); err == nil {
-		categories = cats
-	}
-
-	// Clear state
-	delete(userStates, userID)
-}
-
-func processTransactionType(callback *CallbackQuery, state *TransactionState) {
-	state.TransactionType = callback.Data
-	state.Step = "SELECT_CATEGORY"
-
-	buttons := make([][]InlineKeyboardButton, 0)
-	for _, category := range categories {
-		buttons = append(buttons, []InlineKeyboardButton{
-			{Text: category, CallbackData: category},
-		})
-	}
-	keyboard := buildKeyboard(buttons)
-	editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, fmt.Sprintf("You selected %s. Choose a category:", state.TransactionType), keyboard)
-}
-
-func processCategory(callback *CallbackQuery, state *TransactionState) {
-	state.Category = callback.Data
-	state.Step = "ENTER_AMOUNT"
-
-	editMessage(callback.Message.Chat.ID, callback.Message.MessageID, fmt.Sprintf("Selected category: %s. Enter the transaction amount.", state.Category))
-}
-
-func processAmount(message *TGMessage, state *TransactionState) {
-	amount, err := strconv.ParseFloat(message.Text, 64)
-	if err != nil || amount <= 0 {
-		sendMessage(message.Chat.ID, "Invalid amount. Please enter a positive number.")
-		return
-	}
-
-	state.Amount = amount
-	state.Step = "ENTER_DESCRIPTION"
-	sendMessage(message.Chat.ID, "Enter a description for the transaction (max 100 characters).")
-}
-
-func processDescription(message *TGMessage, state *TransactionState) {
-	if len(message.Text) > 100 {
-		sendMessage(message.Chat.ID, "Description too long. Please keep it under 100 characters.")
-		return
-	}
-
-	state.Description = message.Text
-
-	// Get current time in GMT+7
-	currentTime := time.Now().In(time.FixedZone("GMT+7", 7*60*60))
-
-	stmt, err := db.Prepare("INSERT INTO transactions (type, category, quantity, amount, description, created_at, is_outlier) VALUES (?, ?, ?, ?, ?, ?, ?)")
-	if err != nil {
-		sendMessage(message.Chat.ID, "Failed to prepare transaction.")
-		log.Printf("Database prepare error: %v", err)
-		return
-	}
-	defer stmt.Close()
-
-	quantity := state.Quantity
-	if quantity == 0 {
-		quantity = 1
-	}
-	isOutlierVal := 0
-	if state.IsOutlier {
-		isOutlierVal = 1
-	}
-
-	_, err = stmt.Exec(state.TransactionType, state.Category, quantity, state.Amount, state.Description, currentTime.Format("2006-01-02 15:04:05"), isOutlierVal)
-	if err != nil {
-		sendMessage(message.Chat.ID, "Failed to save transaction.")
-		log.Printf("Database exec error: %v", err)
-		return
-	}
-
-	delete(userStates, state.UserID)
-	sendMessage(message.Chat.ID, "Transaction added successfully!")
-}
-
-func showSummary(chatID int64) {
-	currentMonth := time.Now().UTC().Format("01")
-	rows, err := db.Query("SELECT type, SUM(amount) as total FROM transactions WHERE strftime('%m', created_at) = ? GROUP BY type", currentMonth)
-	if err != nil {
-		sendMessage(chatID, "Error retrieving transactions.")
-		log.Printf("Database query error: %v", err)
-		return
-	}
-	defer rows.Close()
-
-	incomeTotal := 0.0
-	expenseTotal := 0.0
-	for rows.Next() {
-		var transactionType string
-		var total float64
-		err := rows.Scan(&transactionType, &total)
-		if err != nil {
-			log.Printf("Row scan error: %v", err)
-			continue
-		}
-		if transactionType == "income" {
-			incomeTotal = total
-		} else if transactionType == "expense" {
-			expenseTotal = total
-		}
-	}
-
-	if err = rows.Err(); err != nil {
-		log.Printf("Rows error: %v", err)
-	}
-
-	balance := incomeTotal - expenseTotal
-	summaryMessage := fmt.Sprintf("Monthly Summary Report for %s:\n\n", time.Now().Format("January 2006"))
-	summaryMessage += fmt.Sprintf("Total Income: %.2f\nTotal Expense: %.2f\n\nBalance: %.2f",
-		incomeTotal, expenseTotal, balance)
-	sendMessage(chatID, summaryMessage)
-}
-
-// sendMessage wrapper to use botClient
-func sendMessage(chatID int64, text string) {
-	_, err := botClient.SendMessage(chatID, text, nil)
-	if err != nil {
-		log.Printf("Error sending message: %v", err)
-	}
-}
-
-func sendMessageWithKeyboard(chatID int64, text string, keyboard InlineKeyboardMarkup) {
-	_, err := botClient.SendMessage(chatID, text, keyboard)
-	if err != nil {
-		log.Printf("Error sending message with keyboard: %v", err)
-	}
-}
-
-func editMessage(chatID int64, messageID int, text string) {
-	_, err := botClient.EditMessageText(chatID, messageID, text, nil)
-	if err != nil {
-		log.Printf("Error editing message: %v", err)
-	}
-}
-
-func editMessageWithKeyboard(chatID int64, messageID int, text string, keyboard InlineKeyboardMarkup) {
-	_, err := botClient.EditMessageText(chatID, messageID, text, keyboard)
-	if err != nil {
-		log.Printf("Error editing message with keyboard: %v", err)
-	}
-}
-
-func get_latest_report(chatID int64) {
-	cmd := exec.Command("python3", "src/g_latest_r.py") // Path to your Python script
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Error executing Python script: %s", err)
-		sendMessage(chatID, "Failed to execute the report.")
-		return
-	}
-
-	sendMessage(chatID, string(output))
-}
-
-func get_weekly_expense_report(chatID int64) {
-	cmd := exec.Command("python3", "src/g_weekly_e_r.py")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Error executing Python script: %s", err)
-		sendMessage(chatID, "Failed to execute the report.")
-		return
-	}
-
-	sendMessage(chatID, string(output))
-}
-
-func get_weekly_expense_piechart(chatID int64) {
-	// Keep same behavior as before: run external python script with API_TOKEN env.
-	// The Python may send image using API_TOKEN, or print path/output; we relay output.
-	cmd := exec.Command("python3", "src/g_w_e_piechart.py", fmt.Sprintf("%d", chatID))
-	cmd.Env = append(os.Environ(), fmt.Sprintf("API_TOKEN=%s", API_TOKEN))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Error executing piechart script: %v, output: %s", err, string(output))
-		sendMessage(chatID, "Failed to run piechart script. Check logs.")
-		return
-	}
-	// If script prints something useful, send it
-	if len(output) > 0 {
-		sendMessage(chatID, string(output))
-	}
-}
-
-// exportCSV exports transactions table to a CSV file and sends it to chatID
-func exportCSV(chatID int64) {
-	rows, err := db.Query("SELECT id, type, category, quantity, amount, description, created_at, is_outlier FROM transactions ORDER BY id")
-	if err != nil {
-		sendMessage(chatID, "Failed to query transactions for export.")
-		log.Printf("Database query error for export: %v", err)
-		return
-	}
-	defer rows.Close()
-
-	tmpFile, err := os.CreateTemp("", "transactions-*.csv")
-	if err != nil {
-		sendMessage(chatID, "Failed to create temporary file for export.")
-		log.Printf("Temp file creation error: %v", err)
-		return
-	}
-	tmpPath := tmpFile.Name()
-	// Ensure cleanup
-	defer func() {
-		tmpFile.Close()
-		_ = os.Remove(tmpPath)
-	}()
-
-	writer := csv.NewWriter(tmpFile)
-	// write header
-	if err := writer.Write([]string{"id", "type", "category", "quantity", "amount", "description", "created_at", "is_outlier"}); err != nil {
-		sendMessage(chatID, "Failed to write CSV header.")
-		log.Printf("CSV write header error: %v", err)
-		return
-	}
-
-	for rows.Next() {
-		var (
-			id          int64
-			typ         string
-			category    string
-			quantity    float64
-			amount      float64
-			description sql.NullString
-			createdAt   string
-			isOutlier   sql.NullBool
-		)
-		if err := rows.Scan(&id, &typ, &category, &quantity, &amount, &description, &createdAt, &isOutlier); err != nil {
-			log.Printf("Row scan error while exporting CSV: %v", err)
-			continue
-		}
-		desc := ""
-		if description.Valid {
-			desc = description.String
-		}
-		outlierStr := ""
-		if isOutlier.Valid && isOutlier.Bool {
-			outlierStr = "true"
-		} else if isOutlier.Valid && !isOutlier.Bool {
-			outlierStr = "false"
-		}
-		record := []string{
-			strconv.FormatInt(id, 10),
-			typ,
-			category,
-			fmt.Sprintf("%.2f", quantity),
-			fmt.Sprintf("%.2f", amount),
-			desc,
-			createdAt,
-			outlierStr,
-		}
-		if err := writer.Write(record); err != nil {
-			log.Printf("CSV write row error: %v", err)
-		}
-	}
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		sendMessage(chatID, "Failed to finalize CSV export.")
-		log.Printf("CSV writer error: %v", err)
-		return
-	}
-
-	// Close before sending
-	if err := tmpFile.Close(); err != nil {
-		log.Printf("Error closing temp file before send: %v", err)
-	}
-
-	_, err = botClient.SendDocument(chatID, tmpPath, "Transactions export (CSV)")
-	if err != nil {
-		sendMessage(chatID, "Failed to send CSV file.")
-		log.Printf("Failed to send CSV file: %v", err)
-		return
-	}
-}
-
-/*
-	Bulk CSV import: read CSV file and insert rows into the DB.
-	Supported CSV columns (header-based):
-	type, category, quantity, amount, description (optional), created_at (optional), is_outlier (optional)
-
-	Legacy positional format supported (no header):
-	type,category,amount,description (optional),created_at (optional)
-
-	created_at supports RFC3339, "2006-01-02 15:04:05", or "2006-01-02".
-	Category names that don't exist will be added to categories table.
-*/
-
-// bulkInsertFromCSV reads CSV file at filePath and inserts rows into the DB.
-// Returns number of successfully inserted rows and a slice of errors encountered per row.
-func bulkInsertFromCSV(filePath string) (int, []error) {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return 0, []error{fmt.Errorf("failed to open file: %w", err)}
-	}
-	defer f.Close()
-
-	r := csv.NewReader(f)
-	r.FieldsPerRecord = -1 // allow variable number of fields per row
-
-	rows, err := r.ReadAll()
-	if err != nil {
-		return 0, []error{fmt.Errorf("failed to read CSV: %w", err)}
-	}
-
-	startIdx := 0
-	hasHeader := false
-	var headerMap map[string]int
-	if len(rows) > 0 {
-		firstLower := strings.ToLower(strings.Join(rows[0], ","))
-		if strings.Contains(firstLower, "type") && strings.Contains(firstLower, "amount") {
-			startIdx = 1 // skip header
-			hasHeader = true
-			headerMap = make(map[string]int)
-			for i, h := range rows[0] {
-				headerMap[strings.ToLower(strings.TrimSpace(h))] = i
-			}
-		}
-	}
-
-	tx, err := db.Begin()
-	if err != nil {
-		return 0, []error{fmt.Errorf("failed to begin transaction: %w", err)}
-	}
-	// Ensure we rollback on error if commit doesn't happen
-	defer func() {
-		_ = tx.Rollback()
-	}()
-
-	stmtInsert, err := tx.Prepare("INSERT INTO transactions (type, category, quantity, amount, description, created_at, is_outlier) VALUES (?, ?, ?, ?, ?, ?, ?)")
-	if err != nil {
-		return 0, []error{fmt.Errorf("failed to prepare insert statement: %w", err)}
-	}
-	defer stmtInsert.Close()
-
-	stmtCat, err := tx.Prepare("INSERT OR IGNORE INTO categories (name) VALUES (?)")
-	if err != nil {
-		return 0, []error{fmt.Errorf("failed to prepare category statement: %w", err)}
-	}
-	defer stmtCat.Close()
-
-	inserted := 0
-	var errs []error
-	for i := startIdx; i < len(rows); i++ {
-		row := rows[i]
-		// Expect at least 3 columns: type, category, amount (legacy) or type,category,quantity,amount (new)
-		if len(row) < 3 {
-			errs = append(errs, fmt.Errorf("row %d: not enough columns (need at least type, category, amount)", i+1))
-			continue
-		}
-
-		var typ, category, amountStr, desc, createdAtStr, quantityStr, isOutlierStr string
-		var quantity float64 = 1
-		var isOutlier bool = false
-
-		if hasHeader {
-			// use header map to obtain values
-			get := func(name string) string {
-				if idx, ok := headerMap[name]; ok && idx < len(row) {
-					return strings.TrimSpace(row[idx])
-				}
-				return ""
-			}
-			typ = strings.ToLower(get("type"))
-			category = get("category")
-			quantityStr = get("quantity")
-			amountStr = get("amount")
-			desc = get("description")
-			createdAtStr = get("created_at")
-			isOutlierStr = get("is_outlier")
-			if quantityStr != "" {
-				if q, err := strconv.ParseFloat(quantityStr, 64); err == nil {
-					quantity = q
-				}
-			}
-			if isOutlierStr != "" {
-				isOutlier = parseBool(isOutlierStr)
-			}
-		} else {
-			// No header: support legacy and new positional formats
-			typ = strings.ToLower(strings.TrimSpace(row[0]))
-			category = strings.TrimSpace(row[1])
-
-			// Try to detect if row[2] is quantity or amount:
-			// If row has >=4 columns and row[3] parses as float, assume row[2]=quantity, row[3]=amount.
-			if len(row) >= 4 {
-				if _, errA := strconv.ParseFloat(strings.TrimSpace(row[3]), 64); errA == nil {
-					// treat as quantity + amount
-					quantityStr = strings.TrimSpace(row[2])
-					amountStr = strings.TrimSpace(row[3])
-					if q, err := strconv.ParseFloat(quantityStr, 64); err == nil {
-						quantity = q
-					}
-					if len(row) > 4 {
-						desc = strings.TrimSpace(row[4])
-					}
-					if len(row) > 5 {
-						createdAtStr = strings.TrimSpace(row[5])
-					}
-					if len(row) > 6 {
-						isOutlierStr = strings.TrimSpace(row[6])
-						isOutlier = parseBool(isOutlierStr)
-					}
-				} else {
-					// treat as legacy: amount at row[2]
-					amountStr = strings.TrimSpace(row[2])
-					if len(row) > 3 {
-						desc = strings.TrimSpace(row[3])
-					}
-					if len(row) > 4 {
-						createdAtStr = strings.TrimSpace(row[4])
-					}
-					if len(row) > 5 {
-						isOutlierStr = strings.TrimSpace(row[5])
-						isOutlier = parseBool(isOutlierStr)
-					}
-				}
-			} else {
-				// Only three columns: assume amount is row[2]
-				amountStr = strings.TrimSpace(row[2])
-			}
-		}
-
-		if typ != "income" && typ != "expense" {
-			errs = append(errs, fmt.Errorf("row %d: invalid type '%s' (must be 'income' or 'expense')", i+1, row[0]))
-			continue
-		}
-		amount, err := strconv.ParseFloat(amountStr, 64)
-		if err != nil || amount <= 0 {
-			errs = append(errs, fmt.Errorf("row %d: invalid amount '%s'", i+1, amountStr))
-			continue
-		}
-		if category == "" {
-			category = "Uncategorized"
-		}
-
-		// ensure category exists
-		if _, err := stmtCat.Exec(category); err != nil {
-			// log but continue; failure to insert category shouldn't block row insertion
-			log.Printf("failed to ensure category %s: %v", category, err)
-		}
-
-		// parse createdAt if provided
-		var createdAt time.Time
-		if createdAtStr == "" {
-			createdAt = time.Now().In(time.FixedZone("GMT+7", 7*60*60))
-		} else {
-			layouts := []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
-			var pErr error
-			for _, lay := range layouts {
-				createdAt, pErr = time.Parse(lay, createdAtStr)
-				if pErr == nil {
-					break
-				}
-			}
-			if createdAt.IsZero() {
-				// fallback to now in GMT+7
-				createdAt = time.Now().In(time.FixedZone("GMT+7", 7*60*60))
-			}
-		}
-
-		isOutlierVal := 0
-		if isOutlier {
-			isOutlierVal = 1
-		}
-
-		if _, err := stmtInsert.Exec(typ, category, quantity, amount, desc, createdAt.Format("2006-01-02 15:04:05"), isOutlierVal); err != nil {
-			errs = append(errs, fmt.Errorf("row %d: db insert error: %v", i+1, err))
-			continue
-		}
-		inserted++
-	}
-
-	if err := tx.Commit(); err != nil {
-		errs = append(errs, fmt.Errorf("failed to commit transaction: %w", err))
-		return inserted, errs
-	}
-
-	return inserted, errs
-}
-
-func parseBool(s string) bool {
-	switch strings.ToLower(strings.TrimSpace(s)) {
-	case "1", "true", "yes", "y", "t":
-		return true
-	default:
-		return false
-	}
-}
-
-/*
-	EDIT / UPDATE feature
-*/
-
-// startEdit initiates the interactive edit flow asking for ID
-func startEdit(chatID int64, userID int64) {
-	state := &TransactionState{
-		UserID: userID,
-		Step:   "ENTER_EDIT_ID",
-	}
-	userStates[userID] = state
-	sendMessage(chatID, "Please enter the transaction ID you want to edit.")
-}
-
-// startEditWithID begins edit flow immediately when ID is already provided
-func startEditWithID(chatID int64, userID int64, id int64) {
-	row := db.QueryRow("SELECT id, type, category, quantity, amount, description, created_at, is_outlier FROM transactions WHERE id = ?", id)
-	var (
-		rid         int64
-		typ         string
-		category    string
-		quantity    float64
-		amount      float64
-		description sql.NullString
-		createdAt   string
-		isOutlier   sql.NullBool
-	)
-	err := row.Scan(&rid, &typ, &category, &quantity, &amount, &description, &createdAt, &isOutlier)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
-			return
-		}
-		sendMessage(chatID, "Failed to retrieve transaction.")
-		log.Printf("DB scan error: %v", err)
-		return
-	}
-
-	state := &TransactionState{
-		UserID:          userID,
-		Step:            "SELECT_EDIT_FIELD",
-		EditID:          id,
-		TransactionType: typ,
-		Category:        category,
-		Amount:          amount,
-		Quantity:        quantity,
-	}
-	if description.Valid {
-		state.Description = description.String
-	}
-	if isOutlier.Valid {
-		state.IsOutlier = isOutlier.Bool
-	}
-	userStates[userID] = state
-
-	details := fmt.Sprintf("Transaction ID: %d\nType: %s\nCategory: %s\nQuantity: %.2f\nAmount: %.2f\nDescription: %s\nIs Outlier: %v\n\nChoose field to edit:",
-		id, typ, category, quantity, amount, state.Description, state.IsOutlier)
-	buttons := [][]InlineKeyboardButton{
-		{
-			{Text: "Edit Type", CallbackData: "edit_field:type"},
-			{Text: "Edit Category", CallbackData: "edit_field:category"},
-		},
-		{
-			{Text: "Edit Amount", CallbackData: "edit_field:amount"},
-			{Text: "Edit Quantity", CallbackData: "edit_field:quantity"},
-		},
-		{
-			{Text: "Edit Description", CallbackData: "edit_field:description"},
-			{Text: "Toggle Outlier", CallbackData: "edit_field:is_outlier"},
-		},
-	}
-	keyboard := buildKeyboard(buttons)
-	sendMessageWithKeyboard(chatID, details, keyboard)
-}
-
-// processEditId handles user input for the ID to edit
-func processEditId(message *TGMessage, state *TransactionState) {
-	id, err := strconv.ParseInt(strings.TrimSpace(message.Text), 10, 64)
-	if err != nil || id <= 0 {
-		sendMessage(message.Chat.ID, "Invalid ID. Please enter a valid transaction ID number.")
-		return
-	}
-
-	row := db.QueryRow("SELECT id, type, category, quantity, amount, description, created_at, is_outlier FROM transactions WHERE id = ?", id)
-	var (
-		rid         int64
-		typ         string
-		category    string
-		quantity    float64
-		amount      float64
-		description sql.NullString
-		createdAt   string
-		isOutlier   sql.NullBool
-	)
-	err = row.Scan(&rid, &typ, &category, &quantity, &amount, &description, &createdAt, &isOutlier)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			sendMessage(message.Chat.ID, fmt.Sprintf("Transaction with ID %d not found.", id))
-			return
-		}
-		sendMessage(message.Chat.ID, "Failed to retrieve transaction.")
-		log.Printf("DB scan error: %v", err)
-		return
-	}
-
-	state.EditID = id
-	state.TransactionType = typ
-	state.Category = category
-	state.Amount = amount
-	state.Quantity = quantity
-	if description.Valid {
-		state.Description = description.String
-	}
-	if isOutlier.Valid {
-		state.IsOutlier = isOutlier.Bool
-	}
-	state.Step = "SELECT_EDIT_FIELD"
-
-	details := fmt.Sprintf("Transaction ID: %d\nType: %s\nCategory: %s\nQuantity: %.2f\nAmount: %.2f\nDescription: %s\nIs Outlier: %v\n\nChoose field to edit:",
-		id, typ, category, quantity, amount, state.Description, state.IsOutlier)
-	buttons := [][]InlineKeyboardButton{
-		{
-			{Text: "Edit Type", CallbackData: "edit_field:type"},
-			{Text: "Edit Category", CallbackData: "edit_field:category"},
-		},
-		{
-			{Text: "Edit Amount", CallbackData: "edit_field:amount"},
-			{Text: "Edit Quantity", CallbackData: "edit_field:quantity"},
-		},
-		{
-			{Text: "Edit Description", CallbackData: "edit_field:description"},
-			{Text: "Toggle Outlier", CallbackData: "edit_field:is_outlier"},
-		},
-	}
-	keyboard := buildKeyboard(buttons)
-	sendMessageWithKeyboard(message.Chat.ID, details, keyboard)
-}
-
-// processEditField handles the callback when user selects which field to edit
-func processEditField(callback *CallbackQuery, state *TransactionState) {
-	parts := strings.SplitN(callback.Data, ":", 2)
-	if len(parts) != 2 {
-		sendMessage(callback.Message.Chat.ID, "Invalid selection.")
-		return
-	}
-	field := parts[1]
-
-	switch field {
-	case "type":
-		state.Step = "SELECT_EDIT_TYPE"
-		state.PromptMessageID = callback.Message.MessageID
-		buttons := [][]InlineKeyboardButton{
-			{
-				{Text: "Income", CallbackData: "income"},
-				{Text: "Expense", CallbackData: "expense"},
-			},
-			{
-				{Text: "Cancel", CallbackData: "edit_cancel"},
-			},
-		}
-		keyboard := buildKeyboard(buttons)
-		editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, "Select new type:", keyboard)
-	case "category":
-		state.Step = "SELECT_EDIT_CATEGORY"
-		state.PromptMessageID = callback.Message.MessageID
-		buttons := make([][]InlineKeyboardButton, 0)
-		for _, category := range categories {
-			buttons = append(buttons, []InlineKeyboardButton{
-				{Text: category, CallbackData: category},
-			})
-		}
-		buttons = append(buttons, []InlineKeyboardButton{
-			{Text: "Cancel", CallbackData: "edit_cancel"},
-		})
-		keyboard := buildKeyboard(buttons)
-		editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, "Select new category:", keyboard)
-	case "amount":
-		state.Step = "ENTER_EDIT_AMOUNT"
-		state.PromptMessageID = callback.Message.MessageID
-		editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "Enter new amount (positive number):")
-	case "quantity":
-		state.Step = "ENTER_EDIT_QUANTITY"
-		state.PromptMessageID = callback.Message.MessageID
-		editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "Enter new quantity (positive number):")
-	case "description":
-		state.Step = "ENTER_EDIT_DESCRIPTION"
-		state.PromptMessageID = callback.Message.MessageID
-		editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "Enter new description (max 100 characters):")
-	case "is_outlier":
-		state.Step = "SELECT_EDIT_IS_OUTLIER"
-		state.PromptMessageID = callback.Message.MessageID
-		buttons := [][]InlineKeyboardButton{
-			{
-				{Text: "Yes", CallbackData: "is_outlier:true"},
-				{Text: "No", CallbackData: "is_outlier:false"},
-			},
-			{
-				{Text: "Cancel", CallbackData: "edit_cancel"},
-			},
-		}
-		keyboard := buildKeyboard(buttons)
-		editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, "Mark as outlier?", keyboard)
-	default:
-		sendMessage(callback.Message.Chat.ID, "Unknown field selected.")
-	}
-}
-
-// processEditTransactionType handles callback when user selects new type for edit
-func processEditTransactionType(callback *CallbackQuery, state *TransactionState) {
-	newType := callback.Data
-	chatID := callback.Message.Chat.ID
-	msgID := callback.Message.MessageID
-
-	if newType == "edit_cancel" {
-		editMessage(chatID, msgID, "Edit canceled.")
-		delete(userStates, state.UserID)
-		return
-	}
-
-	_, err := db.Exec("UPDATE transactions SET type = ? WHERE id = ?", newType, state.EditID)
-	if err != nil {
-		log.Printf("Failed to update type: %v", err)
-		editMessage(chatID, msgID, "Failed to update transaction type.")
-		delete(userStates, state.UserID)
-		return
-	}
-	editMessage(chatID, msgID, fmt.Sprintf("Transaction %d updated: type set to %s", state.EditID, newType))
-	delete(userStates, state.UserID)
-}
-
-// processEditCategory handles callback when user selects new category for edit
-func processEditCategory(callback *CallbackQuery, state *TransactionState) {
-	newCategory := callback.Data
-	chatID := callback.Message.Chat.ID
-	msgID := callback.Message.MessageID
-
-	if newCategory == "edit_cancel" {
-		editMessage(chatID, msgID, "Edit canceled.")
-		delete(userStates, state.UserID)
-		return
-	}
-
-	_, err := db.Exec("UPDATE transactions SET category = ? WHERE id = ?", newCategory, state.EditID)
-	if err != nil {
-		log.Printf("Failed to update category: %v", err)
-		editMessage(chatID, msgID, "Failed to update transaction category.")
-		delete(userStates, state.UserID)
-		return
-	}
-	editMessage(chatID, msgID, fmt.Sprintf("Transaction %d updated: category set to %s", state.EditID, newCategory))
-	delete(userStates, state.UserID)
-}
-
-// processEditAmountEdit handles updating amount after user inputs it
-func processEditAmountEdit(message *TGMessage, state *TransactionState) {
-	amount, err := strconv.ParseFloat(message.Text, 64)
-	if err != nil || amount <= 0 {
-		sendMessage(message.Chat.ID, "Invalid amount. Please enter a positive number.")
-		return
-	}
-	_, err = db.Exec("UPDATE transactions SET amount = ? WHERE id = ?", amount, state.EditID)
-	if err != nil {
-		log.Printf("Failed to update amount: %v", err)
-		if state.PromptMessageID != 0 {
-			editMessage(message.Chat.ID, state.PromptMessageID, "Failed to update transaction amount.")
-		} else {
-			sendMessage(message.Chat.ID, "Failed to update transaction amount.")
-		}
-		delete(userStates, state.UserID)
-		return
-	}
-
-	if state.PromptMessageID != 0 {
-		editMessage(message.Chat.ID, state.PromptMessageID, fmt.Sprintf("Transaction %d updated: amount set to %.2f", state.EditID, amount))
-	} else {
-		sendMessage(message.Chat.ID, fmt.Sprintf("Transaction %d updated: amount set to %.2f", state.EditID, amount))
-	}
-
-	delete(userStates, state.UserID)
-}
-
-// processEditQuantityEdit handles updating quantity after user inputs it
-func processEditQuantityEdit(message *TGMessage, state *TransactionState) {
-	quantity, err := strconv.ParseFloat(message.Text, 64)
-	if err != nil || quantity <= 0 {
-		sendMessage(message.Chat.ID, "Invalid quantity. Please enter a positive number.")
-		return
-	}
-	_, err = db.Exec("UPDATE transactions SET quantity = ? WHERE id = ?", quantity, state.EditID)
-	if err != nil {
-		log.Printf("Failed to update quantity: %v", err)
-		if state.PromptMessageID != 0 {
-			editMessage(message.Chat.ID, state.PromptMessageID, "Failed to update transaction quantity.")
-		} else {
-			sendMessage(message.Chat.ID, "Failed to update transaction quantity.")
-		}
-		delete(userStates, state.UserID)
-		return
-	}
-
-	if state.PromptMessageID != 0 {
-		editMessage(message.Chat.ID, state.PromptMessageID, fmt.Sprintf("Transaction %d updated: quantity set to %.2f", state.EditID, quantity))
-	} else {
-		sendMessage(message.Chat.ID, fmt.Sprintf("Transaction %d updated: quantity set to %.2f", state.EditID, quantity))
-	}
-
-	delete(userStates, state.UserID)
-}
-
-// processEditDescriptionEdit handles updating description after user inputs it
-func processEditDescriptionEdit(message *TGMessage, state *TransactionState) {
-	if len(message.Text) > 100 {
-		sendMessage(message.Chat.ID, "Description too long. Please keep it under 100 characters.")
-		return
-	}
-	_, err := db.Exec("UPDATE transactions SET description = ? WHERE id = ?", message.Text, state.EditID)
-	if err != nil {
-		log.Printf("Failed to update description: %v", err)
-		if state.PromptMessageID != 0 {
-			editMessage(message.Chat.ID, state.PromptMessageID, "Failed to update transaction description.")
-		} else {
-			sendMessage(message.Chat.ID, "Failed to update transaction description.")
-		}
-		delete(userStates, state.UserID)
-		return
-	}
-
-	if state.PromptMessageID != 0 {
-		editMessage(message.Chat.ID, state.PromptMessageID, fmt.Sprintf("Transaction %d updated: description set.", state.EditID))
-	} else {
-		sendMessage(message.Chat.ID, fmt.Sprintf("Transaction %d updated: description set.", state.EditID))
-	}
-
-	delete(userStates, state.UserID)
-}
-
-// processEditIsOutlier handles callback to set/unset is_outlier
-func processEditIsOutlier(callback *CallbackQuery, state *TransactionState) {
-	data := callback.Data
-	chatID := callback.Message.Chat.ID
-	msgID := callback.Message.MessageID
-
-	if data == "edit_cancel" {
-		editMessage(chatID, msgID, "Edit canceled.")
-		delete(userStates, state.UserID)
-		return
-	}
-
-	parts := strings.SplitN(data, ":", 2)
-	if len(parts) != 2 || parts[0] != "is_outlier" {
-		editMessage(chatID, msgID, "Invalid selection.")
-		delete(userStates, state.UserID)
-		return
-	}
-	val := parts[1]
-	var outlierVal int
-	if val == "true" || val == "1" {
-		outlierVal = 1
-	} else {
-		outlierVal = 0
-	}
-
-	_, err := db.Exec("UPDATE transactions SET is_outlier = ? WHERE id = ?", outlierVal, state.EditID)
-	if err != nil {
-		log.Printf("Failed to update is_outlier: %v", err)
-		editMessage(chatID, msgID, "Failed to update transaction outlier flag.")
-		delete(userStates, state.UserID)
-		return
-	}
-	editMessage(chatID, msgID, fmt.Sprintf("Transaction %d updated: is_outlier set to %v", state.EditID, outlierVal == 1))
-	delete(userStates, state.UserID)
-}
-
-/*
-	DELETE feature with confirmation
-*/
-
-// startDelete asks for an ID to delete
-func startDelete(chatID int64, userID int64) {
-	state := &TransactionState{
-		UserID: userID,
-		Step:   "ENTER_DELETE_ID",
-	}
-	userStates[userID] = state
-	sendMessage(chatID, "Please enter the transaction ID you want to delete.")
-}
-
-// startDeleteWithID begins delete flow immediately when ID is already provided
-func startDeleteWithID(chatID int64, userID int64, id int64) {
-	row := db.QueryRow("SELECT id, type, category, quantity, amount, description, created_at, is_outlier FROM transactions WHERE id = ?", id)
-	var (
-		rid         int64
-		typ         string
-		category    string
-		quantity    float64
-		amount      float64
-		description sql.NullString
-		createdAt   string
-		isOutlier   sql.NullBool
-	)
-	err := row.Scan(&rid, &typ, &category, &quantity, &amount, &description, &createdAt, &isOutlier)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
-			return
-		}
-		sendMessage(chatID, "Failed to retrieve transaction.")
-		log.Printf("DB scan error: %v", err)
-		return
-	}
-
-	state := &TransactionState{
-		UserID:          userID,
-		Step:            "CONFIRM_DELETE",
-		EditID:          id,
-		TransactionType: typ,
-		Category:        category,
-		Amount:          amount,
-		Quantity:        quantity,
-	}
-	if description.Valid {
-		state.Description = description.String
-	}
-	if isOutlier.Valid {
-		state.IsOutlier = isOutlier.Bool
-	}
-	userStates[userID] = state
-
-	details := fmt.Sprintf("Transaction ID: %d\nType: %s\nCategory: %s\nQuantity: %.2f\nAmount: %.2f\nDescription: %s\nIs Outlier: %v\n\nAre you sure you want to DELETE this transaction?",
-		id, typ, category, quantity, amount, state.Description, state.IsOutlier)
-	buttons := [][]InlineKeyboardButton{
-		{
-			{Text: "Confirm Delete", CallbackData: "delete_confirm"},
-			{Text: "Cancel", CallbackData: "delete_cancel"},
-		},
-	}
-	keyboard := buildKeyboard(buttons)
-	sendMessageWithKeyboard(chatID, details, keyboard)
-}
-
-// processDeleteId handles user input for the ID to delete
-func processDeleteId(message *TGMessage, state *TransactionState) {
-	id, err := strconv.ParseInt(strings.TrimSpace(message.Text), 10, 64)
-	if err != nil || id <= 0 {
-		sendMessage(message.Chat.ID, "Invalid ID. Please enter a valid transaction ID number.")
-		return
-	}
-
-	row := db.QueryRow("SELECT id, type, category, quantity, amount, description, created_at, is_outlier FROM transactions WHERE id = ?", id)
-	var (
-		rid         int64
-		typ         string
-		category    string
-		quantity    float64
-		amount      float64
-		description sql.NullString
-		createdAt   string
-		isOutlier   sql.NullBool
-	)
-	err = row.Scan(&rid, &typ, &category, &quantity, &amount, &description, &createdAt, &isOutlier)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			sendMessage(message.Chat.ID, fmt.Sprintf("Transaction with ID %d not found.", id))
-			return
-		}
-		sendMessage(message.Chat.ID, "Failed to retrieve transaction.")
-		log.Printf("DB scan error: %v", err)
-		return
-	}
-
-	state.EditID = id
-	state.TransactionType = typ
-	state.Category = category
-	state.Amount = amount
-	state.Quantity = quantity
-	if description.Valid {
-		state.Description = description.String
-	}
-	if isOutlier.Valid {
-		state.IsOutlier = isOutlier.Bool
-	}
-	state.Step = "CONFIRM_DELETE"
-
-	details := fmt.Sprintf("Transaction ID: %d\nType: %s\nCategory: %s\nQuantity: %.2f\nAmount: %.2f\nDescription: %s\nIs Outlier: %v\n\nAre you sure you want to DELETE this transaction?",
-		id, typ, category, quantity, amount, state.Description, state.IsOutlier)
-	buttons := [][]InlineKeyboardButton{
-		{
-			{Text: "Confirm Delete", CallbackData: "delete_confirm"},
-			{Text: "Cancel", CallbackData: "delete_cancel"},
-		},
-	}
-	keyboard := buildKeyboard(buttons)
-	sendMessageWithKeyboard(message.Chat.ID, details, keyboard)
-}
-
-// processDeleteConfirmation handles callback when user confirms or cancels deletion
-func processDeleteConfirmation(callback *CallbackQuery, state *TransactionState) {
-	chatID := callback.Message.Chat.ID
-	msgID := callback.Message.MessageID
-
-	switch callback.Data {
-	case "delete_confirm":
-		res, err := db.Exec("DELETE FROM transactions WHERE id = ?", state.EditID)
-		if err != nil {
-			log.Printf("Failed to delete transaction %d: %v", state.EditID, err)
-			editMessage(chatID, msgID, fmt.Sprintf("Failed to delete transaction %d.", state.EditID))
-			delete(userStates, state.UserID)
-			return
-		}
-		rowsAffected, _ := res.RowsAffected()
-		if rowsAffected == 0 {
-			editMessage(chatID, msgID, fmt.Sprintf("No transaction deleted. ID %d may not exist.", state.EditID))
-		} else {
-			editMessage(chatID, msgID, fmt.Sprintf("Transaction %d has been deleted.", state.EditID))
-		}
-		delete(userStates, state.UserID)
-	case "delete_cancel":
-		editMessage(chatID, msgID, "Deletion canceled.")
-		delete(userStates, state.UserID)
-	default:
-		editMessage(chatID, msgID, "Unknown selection. No action taken.")
-	}
-}
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// --- Minimal Telegram client using only stdlib ---
+// Types mirror only the fields we need.
+type Update struct {
+	UpdateID          int                  `json:"update_id"`
+	Message           *TGMessage           `json:"message,omitempty"`
+	EditedMessage     *TGMessage           `json:"edited_message,omitempty"`
+	ChannelPost       *TGMessage           `json:"channel_post,omitempty"`
+	EditedChannelPost *TGMessage           `json:"edited_channel_post,omitempty"`
+	CallbackQuery     *CallbackQuery       `json:"callback_query,omitempty"`
+	MyChatMember      *TGChatMemberUpdated `json:"my_chat_member,omitempty"`
+	PreCheckoutQuery  *TGPreCheckoutQuery  `json:"pre_checkout_query,omitempty"`
+	Kind              string               `json:"-"` // the update type, e.g. "message" or "poll"
+}
+
+// UnmarshalJSON also records the update type, so types without a field
+// here can still be named in the log.
+func (u *Update) UnmarshalJSON(data []byte) error {
+	type plain Update
+	if err := json.Unmarshal(data, (*plain)(u)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name := range fields {
+		if name != "update_id" {
+			u.Kind = name
+		}
+	}
+	return nil
+}
+
+type TGMessage struct {
+	MessageID         int                  `json:"message_id"`
+	From              *TGUser              `json:"from,omitempty"`
+	Chat              *TGChat              `json:"chat,omitempty"`
+	Text              string               `json:"text,omitempty"`
+	Date              int64                `json:"date,omitempty"`
+	EditDate          int64                `json:"edit_date,omitempty"`
+	ReplyToMessage    *TGMessage           `json:"reply_to_message,omitempty"`
+	Document          *TGDocument          `json:"document,omitempty"`
+	Location          *TGLocation          `json:"location,omitempty"`
+	SuccessfulPayment *TGSuccessfulPayment `json:"successful_payment,omitempty"`
+}
+
+type TGChatMemberUpdated struct {
+	Chat          *TGChat      `json:"chat"`
+	From          *TGUser      `json:"from"`
+	Date          int64        `json:"date"`
+	OldChatMember TGChatMember `json:"old_chat_member"`
+	NewChatMember TGChatMember `json:"new_chat_member"`
+}
+
+type TGChatMember struct {
+	Status string  `json:"status"` // creator, administrator, member, restricted, left or kicked
+	User   *TGUser `json:"user"`
+}
+
+type TGPreCheckoutQuery struct {
+	ID             string  `json:"id"`
+	From           *TGUser `json:"from"`
+	Currency       string  `json:"currency"`
+	TotalAmount    int     `json:"total_amount"`
+	InvoicePayload string  `json:"invoice_payload"`
+}
+
+type TGSuccessfulPayment struct {
+	Currency                string `json:"currency"`
+	TotalAmount             int    `json:"total_amount"`
+	TelegramPaymentChargeID string `json:"telegram_payment_charge_id"`
+}
+
+type TGDocument struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	FileSize int    `json:"file_size,omitempty"`
+}
+
+type TGLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type TGUser struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	UserName  string `json:"username"`
+}
+
+type TGChat struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+type CallbackQuery struct {
+	ID      string     `json:"id"`
+	From    *TGUser    `json:"from"`
+	Message *TGMessage `json:"message,omitempty"`
+	Data    string     `json:"data,omitempty"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// ReplyKeyboardMarkup is a keyboard shown in place of the phone's one;
+// a button sends its text as a message.
+type ReplyKeyboardMarkup struct {
+	Keyboard       [][]KeyboardButton `json:"keyboard"`
+	ResizeKeyboard bool               `json:"resize_keyboard,omitempty"`
+	IsPersistent   bool               `json:"is_persistent,omitempty"`
+}
+
+type KeyboardButton struct {
+	Text string `json:"text"`
+}
+
+// ReplyKeyboardRemove hides a reply keyboard.
+type ReplyKeyboardRemove struct {
+	RemoveKeyboard bool `json:"remove_keyboard"`
+}
+
+// pollTimeout is the long polling timeout of getUpdates, in seconds. It
+// stays under the HTTP client timeout so an idle poll is not an error.
+const pollTimeout = 50
+
+type BotClient struct {
+	token      string
+	baseURL    string
+	httpClient *http.Client
+	limiter    *sendLimiter
+}
+
+func NewBotClient(token string) *BotClient {
+	return &BotClient{
+		token:      token,
+		baseURL:    fmt.Sprintf("https://api.telegram.org/bot%s", token),
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		limiter:    newSendLimiter(),
+	}
+}
+
+// apiPost calls a Bot API method, retrying network errors, 429 (honoring
+// retry_after) and 5xx responses with exponential backoff. Permanent failures
+// are returned to the caller as *APIError.
+func (b *BotClient) apiPost(path string, body interface{}, contentType string) ([]byte, error) {
+	url := b.baseURL + "/" + path
+	var payload []byte
+	var ct string
+
+	if contentType == "application/json" {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return nil, err
+		}
+		payload = buf.Bytes()
+		ct = "application/json"
+	} else {
+		// body is already an io.Reader for multipart (handled by caller)
+		if rdr, ok := body.(io.Reader); ok {
+			data, err := io.ReadAll(rdr)
+			if err != nil {
+				return nil, err
+			}
+			payload = data
+			ct = contentType
+		} else {
+			return nil, fmt.Errorf("unsupported body type for contentType %s", contentType)
+		}
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		if attempt > 1 {
+			delay := retryDelay(attempt-1, lastErr)
+			log.Printf("Retrying %s in %s (attempt %d/%d): %v", path, delay, attempt, maxSendAttempts, lastErr)
+			time.Sleep(delay)
+		}
+
+		data, err := b.doPost(url, payload, ct)
+		if err == nil {
+			return data, nil
+		}
+		lastErr = err
+		if !isRetryable(err) {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (b *BotClient) doPost(url string, payload []byte, contentType string) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAPIResponse(data, resp.StatusCode); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (b *BotClient) apiGet(path string, params map[string]string) ([]byte, error) {
+	url := b.baseURL + "/" + path
+	if params != nil && len(params) > 0 {
+		q := "?"
+		first := true
+		for k, v := range params {
+			if !first {
+				q += "&"
+			}
+			q += fmt.Sprintf("%s=%s", k, v)
+			first = false
+		}
+		url += q
+	}
+	resp, err := b.httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAPIResponse(data, resp.StatusCode); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DownloadFile downloads a Telegram file (by file_id) to a temporary local file.
+// Returns the path to the temp file (caller should remove it when done).
+func (b *BotClient) DownloadFile(fileID string) (string, error) {
+	// Call getFile to obtain file_path
+	data, err := b.apiGet("getFile", map[string]string{"file_id": fileID})
+	if err != nil {
+		return "", fmt.Errorf("getFile failed: %w", err)
+	}
+	var gf struct {
+		OK     bool `json:"ok"`
+		Result struct {
+			FileID   string `json:"file_id"`
+			FileSize int    `json:"file_size"`
+			FilePath string `json:"file_path"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &gf); err != nil {
+		return "", fmt.Errorf("failed to parse getFile response: %w", err)
+	}
+	if gf.Result.FilePath == "" {
+		return "", fmt.Errorf("file_path not present in getFile response")
+	}
+
+	downloadURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", b.token, gf.Result.FilePath)
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to download file from %s: %w", downloadURL, err)
+	}
+	defer resp.Body.Close()
+
+	ext := filepath.Ext(gf.Result.FilePath)
+	if ext == "" {
+		ext = ".bin"
+	}
+	tmpFile, err := os.CreateTemp(dataSubdir("attachments"), "tgfile-*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tmpFile.Close()
+
+	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write file to temp: %w", err)
+	}
+
+	return tmpFile.Name(), nil
+}
+
+func (b *BotClient) GetUpdates(offset int, timeout int) ([]Update, error) {
+	params := map[string]string{
+		"timeout": strconv.Itoa(timeout),
+	}
+	if offset > 0 {
+		params["offset"] = strconv.Itoa(offset)
+	}
+	data, err := b.apiGet("getUpdates", params)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		OK     bool     `json:"ok"`
+		Result []Update `json:"result"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result.Result, nil
+}
+
+func (b *BotClient) SendMessage(chatID int64, text string, replyMarkup interface{}) (*TGMessage, error) {
+	payload := map[string]interface{}{
+		"chat_id":    chatID,
+		"text":       text,
+		"parse_mode": "HTML",
+	}
+	if replyMarkup != nil {
+		payload["reply_markup"] = replyMarkup
+	}
+	b.limiter.wait(chatID)
+	data, err := b.apiPost("sendMessage", payload, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		OK     bool       `json:"ok"`
+		Result *TGMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result.Result, nil
+}
+
+func (b *BotClient) EditMessageText(chatID int64, messageID int, text string, replyMarkup interface{}) (*TGMessage, error) {
+	payload := map[string]interface{}{
+		"chat_id":    chatID,
+		"message_id": messageID,
+		"text":       text,
+		"parse_mode": "HTML",
+	}
+	if replyMarkup != nil {
+		payload["reply_markup"] = replyMarkup
+	}
+	b.limiter.wait(chatID)
+	data, err := b.apiPost("editMessageText", payload, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		OK     bool       `json:"ok"`
+		Result *TGMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result.Result, nil
+}
+
+func (b *BotClient) AnswerCallbackQuery(callbackID string, text string) error {
+	payload := map[string]interface{}{
+		"callback_query_id": callbackID,
+		"text":              text,
+	}
+	_, err := b.apiPost("answerCallbackQuery", payload, "application/json")
+	return err
+}
+
+// PinChatMessage pins a message without notifying the chat members.
+func (b *BotClient) PinChatMessage(chatID int64, messageID int) error {
+	payload := map[string]interface{}{
+		"chat_id":              chatID,
+		"message_id":           messageID,
+		"disable_notification": true,
+	}
+	_, err := b.apiPost("pinChatMessage", payload, "application/json")
+	return err
+}
+
+func (b *BotClient) UnpinChatMessage(chatID int64, messageID int) error {
+	payload := map[string]interface{}{
+		"chat_id":    chatID,
+		"message_id": messageID,
+	}
+	_, err := b.apiPost("unpinChatMessage", payload, "application/json")
+	return err
+}
+
+// AnswerPreCheckoutQuery confirms or (with an error message) refuses a
+// payment; Telegram cancels it when there is no answer within 10 seconds.
+func (b *BotClient) AnswerPreCheckoutQuery(queryID string, ok bool, errorMessage string) error {
+	payload := map[string]interface{}{
+		"pre_checkout_query_id": queryID,
+		"ok":                    ok,
+	}
+	if !ok {
+		payload["error_message"] = errorMessage
+	}
+	_, err := b.apiPost("answerPreCheckoutQuery", payload, "application/json")
+	return err
+}
+
+// SendPhoto uploads a local file (photoPath) and sends it to chatID with optional caption
+func (b *BotClient) SendPhoto(chatID int64, photoPath string, caption string) (*TGMessage, error) {
+	url := b.baseURL + "/sendPhoto"
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	_ = w.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	if caption != "" {
+		_ = w.WriteField("caption", caption)
+	}
+
+	fw, err := w.CreateFormFile("photo", filepath.Base(photoPath))
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(photoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.Copy(fw, file); err != nil {
+		return nil, err
+	}
+	w.Close()
+
+	b.limiter.wait(chatID)
+	returned, err := b.apiPost(url[len(b.baseURL)+1:], &buf, w.FormDataContentType())
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		OK     bool       `json:"ok"`
+		Result *TGMessage `json:"result"`
+	}
+	if err := json.Unmarshal(returned, &result); err != nil {
+		return nil, err
+	}
+	return result.Result, nil
+}
+
+// SendDocument uploads a local file (documentPath) and sends it to chatID with optional caption
+func (b *BotClient) SendDocument(chatID int64, documentPath string, caption string) (*TGMessage, error) {
+	url := b.baseURL + "/sendDocument"
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	_ = w.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	if caption != "" {
+		_ = w.WriteField("caption", caption)
+	}
+
+	fw, err := w.CreateFormFile("document", filepath.Base(documentPath))
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(documentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.Copy(fw, file); err != nil {
+		return nil, err
+	}
+	w.Close()
+
+	b.limiter.wait(chatID)
+	returned, err := b.apiPost(url[len(b.baseURL)+1:], &buf, w.FormDataContentType())
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		OK     bool       `json:"ok"`
+		Result *TGMessage `json:"result"`
+	}
+	if err := json.Unmarshal(returned, &result); err != nil {
+		return nil, err
+	}
+	return result.Result, nil
+}
+
+// --- End minimal telegram client ---
+
+var (
+	API_TOKEN string
+	DB_PATH   string
+	botClient *BotClient
+	db        *sql.DB
+)
+
+// defaultLocation is the time zone used when none is configured.
+var defaultLocation = time.FixedZone("GMT+7", 7*60*60)
+
+// configuredLocation returns the time zone chosen with /start, else the
+// one of the configuration, else defaultLocation.
+func configuredLocation(cfg *Config) *time.Location {
+	name := getSetting("timezone", cfg.Timezone)
+	if name == "" {
+		return defaultLocation
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Invalid time zone %q, using %s: %v", name, defaultLocation, err)
+		return defaultLocation
+	}
+	return loc
+}
+
+type TransactionState struct {
+	UserID          int64
+	Step            string // Tracks current state step
+	TransactionType string // "income" or "expense"
+	Category        string
+	Amount          Money
+	Quantity        float64
+	Description     string
+	Notes           string
+	EditID          int64 // ID of transaction being edited/deleted
+	PromptMessageID int   // message id that was edited to prompt user (used to remove keyboard / show confirmation)
+	AmountMessageID int   // message that gave the amount, so editing it can amend the transaction
+	IsOutlier       bool
+	SplitChatID     int64            // group chat of a split in progress
+	SplitMembers    map[int64]bool   // members selected to share a split
+	Report          *reportSpec      // custom report being built
+	Location        *TGLocation      // location shared while adding the transaction
+	AIConfidence    float64          // confidence of the AI provider in a transaction it read
+	Foreign         *foreignAmount   // amount as entered in another currency (fx.go)
+	Onboarding      *onboardingState // /start setup in progress
+	Allocation      *allocationState // /allocate walk-through in progress
+}
+
+var userStates = make(map[int64]*TransactionState)
+
+// selectTransactionByID looks a transaction up among those of the active
+// ledger.
+func selectTransactionByID() string {
+	return "SELECT id, type, category, quantity, amount, description, created_at, is_outlier FROM transactions WHERE id = ? AND " + ledgerScope()
+}
+
+func main() {
+	var err error
+
+	// Load env vars (optional)
+	if err = godotenv.Load(); err != nil {
+		log.Println("No .env file found, continuing")
+	}
+
+	// Flags
+	configPath := flag.String("config", "", "Path to a TOML config file")
+	dataPath := flag.String("data", "", "Path to database file")
+	keyFlag := flag.String("db-key", "", "SQLCipher key for an encrypted database (default $DB_KEY)")
+	rekey := flag.Bool("rekey", false, "Re-encrypt the database with the key in $DB_NEW_KEY and exit")
+	backupPath := flag.String("backup", "", "Write a backup of the database to this path and exit")
+	decryptPath := flag.String("decrypt-backup", "", "Decrypt an encrypted backup (using $BACKUP_KEY) next to it and exit")
+	repl := flag.Bool("repl", false, "Drive the bot from the terminal instead of Telegram (for testing)")
+	dryRun := flag.Bool("dry-run", false, "Run add or list on an in-memory copy of the records, writing nothing")
+	nowFlag := flag.String("now", "", "Pretend the current time is this (YYYY-MM-DD HH:MM), for trying date boundaries")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	command, commandArgs := "serve", []string(nil)
+	if flag.NArg() > 0 {
+		command, commandArgs = flag.Arg(0), flag.Args()[1:]
+	}
+	if _, ok := subcommands[command]; !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", command)
+		printUsage()
+		os.Exit(2)
+	}
+	serve := command == "serve"
+	if *dryRun && !dryRunCommands[command] {
+		fmt.Fprintf(flag.CommandLine.Output(), "--dry-run only works with the add and list commands\n")
+		os.Exit(2)
+	}
+
+	if *decryptPath != "" {
+		out := strings.TrimSuffix(*decryptPath, encryptedBackupSuffix)
+		if out == *decryptPath {
+			out += ".db"
+		}
+		if err := decryptBackup(*decryptPath, out, os.Getenv("BACKUP_KEY")); err != nil {
+			log.Fatalf("Failed to decrypt backup: %v", err)
+		}
+		log.Printf("Decrypted backup written to %s", out)
+		return
+	}
+
+	configSource.path, configSource.dataFlag = *configPath, *dataPath
+	cfg, problems := loadConfig(*configPath, *dataPath)
+	if serve && !*rekey && *backupPath == "" && !*repl {
+		validateServeConfig(cfg, problems)
+	}
+	if len(problems.Problems) > 0 {
+		log.Fatal(problems)
+	}
+	var owner int64
+	if len(cfg.AllowedUsers) > 0 {
+		owner = cfg.AllowedUsers[0]
+	}
+	location := defaultLocation
+	if cfg.Timezone != "" {
+		location, _ = time.LoadLocation(cfg.Timezone) // validated by loadConfig
+	}
+	setRunningConfig(cfg, owner, location)
+
+	API_TOKEN = cfg.Token
+	DB_PATH = cfg.DBPath
+	dbKey = cfg.DBKey
+	if *keyFlag != "" {
+		dbKey = *keyFlag
+	}
+	if *nowFlag != "" {
+		if appClock, err = clockStartingAt(*nowFlag); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Clock set to %s", appClock.Now().Format(dbTimeLayout))
+	}
+
+	if cfg.SentryDSN != "" {
+		// validated by loadConfig
+		reporter, _ := newSentryReporter(cfg.SentryDSN, cfg.SentryEnv)
+		errorReporter = reporter
+		log.Println("Error tracking with Sentry enabled")
+	}
+
+	var cli *cliMessenger
+	if !serve {
+		// headless commands never talk to Telegram
+	} else if *repl {
+		cli = newCLIMessenger(os.Stdout)
+		messenger = cli
+		if owner == 0 {
+			owner = 1
+			cfg.AllowedUsers = []int64{owner}
+			setRunningConfig(cfg, owner, location)
+		}
+	} else {
+		// Init bot client (stdlib)
+		botClient = NewBotClient(API_TOKEN)
+		messenger = &telegramMessenger{client: botClient}
+		// Check the token with getMe before doing anything else
+		if _, err := botClient.apiGet("getMe", nil); err != nil {
+			if apiErr, ok := err.(*APIError); ok && (apiErr.Code == 401 || apiErr.Code == 404) {
+				log.Fatalf("Telegram rejected the bot token (%v). Check token / API_TOKEN against @BotFather.", err)
+			}
+			log.Printf("Failed to call getMe: %v", err)
+		} else {
+			log.Println("Telegram client initialized (getMe ok)")
+		}
+	}
+
+	// Init DB
+	db, err = openDB(DB_PATH)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer db.Close()
+	defer closeStmtCache()
+
+	if *rekey {
+		if err := rekeyDB(os.Getenv("DB_NEW_KEY")); err != nil {
+			log.Fatalf("Failed to rotate database key: %v", err)
+		}
+		log.Println("Database key rotated. Update DB_KEY to the new key before restarting.")
+		return
+	}
+
+	if *backupPath != "" {
+		if err := backupDB(*backupPath, os.Getenv("BACKUP_KEY")); err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		log.Printf("Backup written to %s", *backupPath)
+		return
+	}
+
+	if err := initDB(db); err != nil {
+		log.Panic(err)
+	}
+	if err := initAggregates(); err != nil {
+		log.Panic(err)
+	}
+
+	if err := seedCategories(db); err != nil {
+		log.Panic(err)
+	}
+
+	if err := categoriesCache.load(); err != nil {
+		log.Panic(err)
+	}
+	if err := displayCurrency.load(); err != nil {
+		log.Panic(err)
+	}
+	setAppLocation(configuredLocation(cfg))
+
+	if !serve {
+		if *dryRun {
+			copied, err := copyStorage(store)
+			if err != nil {
+				log.Fatalf("Failed to copy the records for a dry run: %v", err)
+			}
+			store = copied
+		}
+		code := runSubcommand(command, commandArgs)
+		if *dryRun {
+			fmt.Fprintln(os.Stderr, "Dry run: nothing was written to the database.")
+		}
+		closeStmtCache()
+		db.Close()
+		os.Exit(code)
+	}
+
+	log.Printf("Loaded categories: %s", strings.Join(getCategories(), ", "))
+
+	// Deliver queued notifications in the background
+	go runOutbox()
+	// Run daily jobs such as the end-of-day summary
+	go runScheduler()
+	// Keep the replica in object storage up to date, when configured
+	go runReplication()
+	// Serve the HTTP API for scripts, when configured
+	go runAPIServer()
+	// Send transaction changes to the registered webhooks
+	go runWebhooks()
+	// Draft the e-receipts arriving in the mailbox, when configured
+	go runReceiptPoller()
+
+	if cli != nil {
+		runREPL(os.Stdin, cli)
+		return
+	}
+
+	// Tell systemd we are up, when supervised
+	startSystemd()
+	// Reload the configuration on SIGHUP
+	watchReloadSignal()
+
+	// Offer to continue the conversations the last run left unfinished
+	resumeConversations()
+
+	// Long-polling loop, resuming where the last run stopped
+	offset := savedUpdateOffset()
+	for {
+		updates, err := botClient.GetUpdates(offset, pollTimeout)
+		if err != nil {
+			log.Printf("GetUpdates error: %v", err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		markPolled(time.Now())
+		for _, update := range updates {
+			if claimUpdate(update) {
+				handleUpdate(update)
+				saveConversation(update)
+			}
+			offset = update.UpdateID + 1
+			saveUpdateOffset(offset)
+		}
+		sweepStaleStates(appClock.Now())
+		reloadIfRequested()
+	}
+}
+
+// Helper to build keyboard in our InlineKeyboardMarkup shape
+func buildKeyboard(rows [][]InlineKeyboardButton) InlineKeyboardMarkup {
+	return InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+func initDB(db *sql.DB) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			category TEXT NOT NULL,
+			quantity REAL NOT NULL DEFAULT 1,
+			amount INTEGER NOT NULL,
+			description TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			is_outlier BOOLEAN,
+			notes TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS transaction_messages (
+			chat_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			transaction_id INTEGER NOT NULL,
+			PRIMARY KEY (chat_id, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS transaction_references (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			transaction_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			value TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_transaction_references_transaction ON transaction_references (transaction_id)`,
+		`CREATE TABLE IF NOT EXISTS rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conditions TEXT NOT NULL,
+			category TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS reconciliations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reconciled_at TEXT NOT NULL,
+			statement_balance INTEGER NOT NULL,
+			computed_balance INTEGER NOT NULL,
+			adjustment_id INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_activity (
+			user_id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			chat_id INTEGER NOT NULL,
+			last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+			message_count INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			dedupe_key TEXT UNIQUE,
+			text TEXT NOT NULL,
+			reply_markup TEXT,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_error TEXT,
+			sent_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS budgets (
+			category TEXT PRIMARY KEY,
+			amount INTEGER NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS job_runs (
+			job TEXT NOT NULL,
+			run_date TEXT NOT NULL,
+			ran_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (job, run_date)
+		)`,
+		`CREATE TABLE IF NOT EXISTS holdings (
+			ticker TEXT PRIMARY KEY,
+			quantity REAL NOT NULL,
+			cost_basis REAL NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS prices (
+			ticker TEXT PRIMARY KEY,
+			price REAL NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS bills (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			amount INTEGER NOT NULL,
+			due_day INTEGER NOT NULL,
+			category TEXT NOT NULL,
+			remind_days INTEGER NOT NULL DEFAULT 3,
+			next_due TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS invoices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			number TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			client TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			category TEXT NOT NULL,
+			issued_on TEXT NOT NULL,
+			due_on TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'invoiced',
+			paid_on TEXT,
+			transaction_id INTEGER,
+			ledger_id INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			amount INTEGER NOT NULL,
+			cycle TEXT NOT NULL,
+			category TEXT NOT NULL,
+			alert_days INTEGER NOT NULL DEFAULT 3,
+			next_renewal TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		monthlyAggregatesTable,
+		`CREATE TABLE IF NOT EXISTS ledgers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT OR IGNORE INTO ledgers (id, name) VALUES (1, 'personal')`,
+		`CREATE TABLE IF NOT EXISTS transactions_archive (
+			id INTEGER PRIMARY KEY,
+			type TEXT NOT NULL,
+			category TEXT NOT NULL,
+			quantity REAL NOT NULL DEFAULT 1,
+			amount INTEGER NOT NULL,
+			description TEXT,
+			created_at DATETIME,
+			is_outlier BOOLEAN,
+			notes TEXT,
+			archived_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_transactions_archive_created_at ON transactions_archive (created_at)`,
+		`CREATE TABLE IF NOT EXISTS transaction_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			transaction_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			old_values TEXT NOT NULL,
+			new_values TEXT,
+			changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_transaction_audit_transaction ON transaction_audit (transaction_id)`,
+		`CREATE TABLE IF NOT EXISTS saved_reports (
+			name TEXT PRIMARY KEY,
+			spec TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS report_schedules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			report TEXT NOT NULL,
+			frequency TEXT NOT NULL,
+			day INTEGER NOT NULL DEFAULT 0,
+			at TEXT NOT NULL,
+			paused BOOLEAN NOT NULL DEFAULT 0,
+			last_run TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS split_groups (
+			chat_id INTEGER PRIMARY KEY,
+			enabled_by INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS group_members (
+			chat_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (chat_id, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS split_expenses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			payer_id INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			description TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS split_shares (
+			expense_id INTEGER NOT NULL REFERENCES split_expenses(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL,
+			share INTEGER NOT NULL,
+			PRIMARY KEY (expense_id, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS split_settlements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			from_user INTEGER NOT NULL,
+			to_user INTEGER NOT NULL,
+			amount INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS allocation_plans (
+			month TEXT PRIMARY KEY,
+			income INTEGER NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS allocations (
+			month TEXT NOT NULL,
+			category TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			PRIMARY KEY (month, category)
+		)`,
+		`CREATE TABLE IF NOT EXISTS savings_goals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			target INTEGER NOT NULL,
+			deadline TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS goal_contributions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			goal_id INTEGER NOT NULL REFERENCES savings_goals(id) ON DELETE CASCADE,
+			amount INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			transaction_id INTEGER,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			scope TEXT NOT NULL CHECK (scope IN ('read', 'write')),
+			token_hash TEXT NOT NULL UNIQUE,
+			hint TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			last_used_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			event TEXT NOT NULL,
+			old_values TEXT,
+			new_values TEXT,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_status INTEGER,
+			last_error TEXT,
+			delivered_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries (status, next_attempt_at)`,
+		`CREATE TABLE IF NOT EXISTS receipt_drafts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			message_id TEXT UNIQUE,
+			merchant TEXT NOT NULL,
+			subject TEXT NOT NULL DEFAULT '',
+			amount INTEGER NOT NULL,
+			occurred_at DATETIME NOT NULL,
+			category TEXT,
+			status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'added', 'skipped')),
+			transaction_id INTEGER,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS viewers (
+			user_id INTEGER PRIMARY KEY,
+			granted_by INTEGER NOT NULL,
+			granted_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS viewer_queries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			query TEXT NOT NULL,
+			allowed INTEGER NOT NULL,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS fx_rates (
+			currency TEXT NOT NULL,
+			base TEXT NOT NULL,
+			day TEXT NOT NULL,
+			rate REAL NOT NULL,
+			source TEXT NOT NULL,
+			PRIMARY KEY (currency, base, day)
+		)`,
+		`CREATE TABLE IF NOT EXISTS conversation_states (
+			user_id INTEGER PRIMARY KEY,
+			chat_id INTEGER NOT NULL,
+			state TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS processed_updates (
+			update_id INTEGER PRIMARY KEY,
+			callback_id TEXT UNIQUE,
+			processed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	// columns added after the first release
+	for _, c := range []struct{ table, column, decl string }{
+		{"transactions", "notes", "TEXT"},
+		{"transactions_archive", "notes", "TEXT"},
+		{"user_activity", "blocked_at", "DATETIME"},
+		{"transactions", "reconciled", "INTEGER NOT NULL DEFAULT 0"},
+		{"transactions", "latitude", "REAL"},
+		{"transactions", "longitude", "REAL"},
+		{"transactions_archive", "latitude", "REAL"},
+		{"transactions_archive", "longitude", "REAL"},
+		{"transactions", "ledger_id", "INTEGER NOT NULL DEFAULT 1"},
+		{"transactions_archive", "ledger_id", "INTEGER NOT NULL DEFAULT 1"},
+		{"transactions", "deductible", "INTEGER NOT NULL DEFAULT 0"},
+		{"transactions", "tax_amount", "INTEGER"},
+		{"transactions_archive", "deductible", "INTEGER NOT NULL DEFAULT 0"},
+		{"transactions_archive", "tax_amount", "INTEGER"},
+		{"transactions", "reimbursable_by", "TEXT"},
+		{"transactions", "reimbursement_id", "INTEGER"},
+		{"transactions_archive", "reimbursable_by", "TEXT"},
+		{"transactions_archive", "reimbursement_id", "INTEGER"},
+		{"transactions", "payment_method", "TEXT"},
+		{"transactions_archive", "payment_method", "TEXT"},
+		{"ledgers", "currency", "TEXT NOT NULL DEFAULT ''"},
+		{"savings_goals", "deadline", "TEXT"},
+		{"categories", "note", "TEXT"},
+		{"categories", "archived", "INTEGER NOT NULL DEFAULT 0"},
+		{"transactions", "original_amount", "INTEGER"},
+		{"transactions", "original_currency", "TEXT"},
+		{"transactions", "fx_rate", "REAL"},
+		{"transactions_archive", "original_amount", "INTEGER"},
+		{"transactions_archive", "original_currency", "TEXT"},
+		{"transactions_archive", "fx_rate", "REAL"},
+	} {
+		if err := addColumnIfMissing(db, c.table, c.column, c.decl); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_transactions_ledger_created_at ON transactions (ledger_id, created_at)"); err != nil {
+		return err
+	}
+	if err := migrateMoney(db); err != nil {
+		return fmt.Errorf("converting amounts to minor units: %w", err)
+	}
+	for _, q := range auditTriggers {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(reimbursementTrigger); err != nil {
+		return err
+	}
+	if _, err := db.Exec(fxAmountTrigger); err != nil {
+		return err
+	}
+	for _, q := range webhookTriggers {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seedCategories(db *sql.DB) error {
+	defaultCategories := []string{
+		"Food",
+		"Salary",
+		"Needs",
+		"Water",
+		"Laundry",
+		"Transportation",
+		"Utilities",
+		"Rent",
+		"Bills",
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO categories (name) VALUES (?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, cat := range defaultCategories {
+		if _, err := stmt.Exec(cat); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// loadCategories returns the names of all the categories, archived ones
+// included (categoryinfo.go).
+func loadCategories(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM categories ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	return result, nil
+}
+
+// parseCommand splits a command message into the command and its
+// arguments. Telegram sends text like "/add" in message.Text (or
+// "/add@BotName" in group chats); other text has no command.
+func parseCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	command := ""
+	args := ""
+	if text != "" && strings.HasPrefix(text, "/") {
+		parts := strings.SplitN(text, " ", 2)
+		command = strings.TrimPrefix(parts[0], "/")
+		if at := strings.Index(command, "@"); at >= 0 {
+			command = command[:at]
+		}
+		if len(parts) > 1 {
+			args = parts[1]
+		}
+	}
+	return command, args
+}
+
+// Message handlers adapted to stdlib types
+func handleMessage(message *TGMessage) {
+	if message.From == nil || message.Chat == nil {
+		return
+	}
+	userID := message.From.ID
+
+	command, args := parseCommand(message.Text)
+
+	// Group chats only support the expense splitting commands
+	if isGroupChat(message.Chat) {
+		if !featureEnabled("group_mode") {
+			return
+		}
+		handleGroupMessage(message, command, args)
+		return
+	}
+
+	recordUserActivity(message.From, message.Chat.ID)
+
+	if !isAllowedUser(userID) {
+		if !isViewer(userID) {
+			sendMessage(message.Chat.ID, "You are not authorized to use this bot.")
+			return
+		}
+		// viewers reach the report commands only (viewers.go)
+		if !allowViewerMessage(message, command, args) {
+			return
+		}
+	}
+
+	// A main menu button stands for its command
+	if command == "" {
+		if c, ok := menuCommand(message.Text); ok {
+			command = c
+		}
+	}
+
+	// Read-only mode: only the commands that read are served; plain text,
+	// documents and locations would add or change something
+	if isMaintenanceMode() && !maintenanceMayRun(command, args) {
+		delete(userStates, userID)
+		sendMessage(message.Chat.ID, maintenanceRefusal)
+		return
+	}
+
+	if handleAdminCommand(message.Chat.ID, userID, command, args) {
+		return
+	}
+
+	// If document is present, handle document upload flow first
+	if message.Document != nil {
+		handleDocument(message)
+		return
+	}
+
+	if message.Location != nil {
+		handleLocation(message)
+		return
+	}
+
+	// Text sent while a destructive action waits is its confirmation code
+	if command == "" && handleConfirmationCode(message) {
+		return
+	}
+
+	// A reply to the confirmation of a saved transaction amends it
+	if command == "" && message.ReplyToMessage != nil && handleTransactionReply(message) {
+		return
+	}
+
+	switch command {
+	case "start":
+		handleStartCommand(message.Chat.ID, userID)
+	case "menu":
+		handleMenuCommand(message.Chat.ID, userID, args)
+	case "help":
+		sendMessage(message.Chat.ID, onboardingTour)
+	case "cancel":
+		if _, exists := userStates[userID]; !exists {
+			sendMessage(message.Chat.ID, "Nothing to cancel.")
+			return
+		}
+		delete(userStates, userID)
+		sendMessage(message.Chat.ID, "Canceled.")
+	case "list":
+		showList(message.Chat.ID, args)
+	case "settings":
+		showSettings(message.Chat.ID)
+	case "add":
+		if strings.TrimSpace(args) != "" {
+			handleAddArgs(message, args)
+		} else {
+			startTransaction(message.Chat.ID, userID)
+		}
+	case "summary":
+		showSummary(message.Chat.ID, args)
+	case "get_latest_report":
+		get_latest_report(message.Chat.ID)
+	case "get_weekly_expense":
+		get_weekly_expense_report(message.Chat.ID)
+	case "get_weekly_expense_piechart":
+		get_weekly_expense_piechart(message.Chat.ID)
+	case "edit":
+		args = strings.TrimSpace(args)
+		if args != "" {
+			id, err := strconv.ParseInt(args, 10, 64)
+			if err != nil {
+				sendMessage(message.Chat.ID, "Invalid ID provided. Usage: /edit <id>")
+				return
+			}
+			startEditWithID(message.Chat.ID, userID, id)
+		} else {
+			startEdit(message.Chat.ID, userID)
+		}
+	case "delete":
+		args = strings.TrimSpace(args)
+		if args != "" {
+			id, err := strconv.ParseInt(args, 10, 64)
+			if err != nil {
+				sendMessage(message.Chat.ID, "Invalid ID provided. Usage: /delete <id>")
+				return
+			}
+			startDeleteWithID(message.Chat.ID, userID, id)
+		} else {
+			startDelete(message.Chat.ID, userID)
+		}
+	case "export_csv":
+		handleExportCommand(message.Chat.ID, "csv")
+	case "export":
+		handleExportCommand(message.Chat.ID, args)
+	case "bulk_transactions":
+		startBulkTransactions(message.Chat.ID, userID)
+	case "allocate":
+		handleAllocateCommand(message.Chat.ID, userID, args)
+	case "budget":
+		handleBudgetCommand(message.Chat.ID, args)
+	case "suggestbudgets":
+		handleSuggestBudgetsCommand(message.Chat.ID, args)
+	case "eod":
+		handleEndOfDayCommand(message.Chat.ID, args)
+	case "forecast":
+		if args != "" {
+			showCategoryForecast(message.Chat.ID, args)
+		} else {
+			showForecast(message.Chat.ID)
+		}
+	case "portfolio":
+		handlePortfolioCommand(message.Chat.ID, args)
+	case "networth":
+		showNetWorth(message.Chat.ID)
+	case "mileage":
+		handleAllowanceCommand(message, args, mileageAllowance)
+	case "perdiem":
+		handleAllowanceCommand(message, args, perDiemAllowance)
+	case "payment":
+		handlePaymentCommand(message.Chat.ID, userID, args)
+	case "payments":
+		showPaymentMethods(message.Chat.ID, args)
+	case "wallet":
+		handleWalletCommand(message.Chat.ID, args)
+	case "reimburse":
+		handleReimburseCommand(message.Chat.ID, userID, args)
+	case "reimbursements":
+		showReimbursements(message.Chat.ID)
+	case "invoice", "invoices":
+		handleInvoiceCommand(message.Chat.ID, args)
+	case "bill", "bills":
+		handleBillCommand(message.Chat.ID, args)
+	case "subscription", "subscriptions":
+		handleSubscriptionCommand(message.Chat.ID, args)
+	case "week":
+		showWeek(message.Chat.ID, args)
+	case "weekstart":
+		handleWeekStartCommand(message.Chat.ID, args)
+	case "charts":
+		handleChartsCommand(message.Chat.ID, args)
+	case "indicators":
+		handleIndicatorsCommand(message.Chat.ID, args)
+	case "archive":
+		handleArchiveCommand(message.Chat.ID, userID, args)
+	case "view":
+		showTransaction(message.Chat.ID, args)
+	case "flow":
+		showFlow(message.Chat.ID, args)
+	case "trend":
+		showTrend(message.Chat.ID, args)
+	case "heatmap":
+		showHeatmap(message.Chat.ID, args)
+	case "top":
+		showTop(message.Chat.ID, args)
+	case "insights":
+		showInsights(message.Chat.ID, args)
+	case "ledger":
+		handleLedgerCommand(message.Chat.ID, args)
+	case "taxreport":
+		handleTaxReportCommand(message.Chat.ID, args)
+	case "streaks":
+		showStreaks(message.Chat.ID)
+	case "ask":
+		handleAskCommand(message.Chat.ID, args)
+	case "burnrate":
+		showBurnRate(message.Chat.ID)
+	case "goals":
+		handleGoalsCommand(message.Chat.ID, args)
+	case "roundup":
+		handleRoundupCommand(message.Chat.ID, args)
+	case "apitoken", "apitokens":
+		handleAPITokenCommand(message.Chat.ID, userID, isGroupChat(message.Chat), args)
+	case "categories", "category":
+		handleCategoriesCommand(message.Chat.ID, args)
+	case "calendar":
+		handleCalendarCommand(message.Chat.ID)
+	case "fx":
+		handleFXCommand(message.Chat.ID, args)
+	case "receipts":
+		handleReceiptsCommand(message.Chat.ID, args)
+	case "savingsrate":
+		handleSavingsRateCommand(message.Chat.ID, args)
+	case "report", "reports":
+		handleReportCommand(message.Chat.ID, userID, command, args)
+	case "schedules", "schedule":
+		handleSchedulesCommand(message.Chat.ID, args)
+	case "pin":
+		handlePinCommand(message.Chat.ID, args)
+	case "close":
+		handleCloseCommand(message.Chat.ID, userID, args)
+	case "reconcile":
+		handleReconcileCommand(message.Chat.ID, args)
+	case "rules":
+		handleRulesCommand(message.Chat.ID, args)
+	case "recategorize":
+		handleRecategorizeCommand(message.Chat.ID, args)
+	case "ref", "refs":
+		handleRefCommand(message.Chat.ID, args)
+	case "search":
+		handleSearchCommand(message.Chat.ID, args)
+	case "notify", "notifications":
+		handleNotifyCommand(message.Chat.ID, userID, args)
+	case "locate":
+		handleLocateCommand(message.Chat.ID, userID, args)
+	case "map":
+		handleMapCommand(message.Chat.ID, args)
+	default:
+		if state, exists := userStates[userID]; exists {
+			switch state.Step {
+			case "ENTER_AMOUNT":
+				processAmount(message, state)
+			case "ENTER_DESCRIPTION":
+				processDescription(message, state)
+			case "ENTER_EDIT_ID":
+				processEditId(message, state)
+			case "ENTER_EDIT_AMOUNT":
+				processEditAmountEdit(message, state)
+			case "ENTER_EDIT_DESCRIPTION":
+				processEditDescriptionEdit(message, state)
+			case "ENTER_EDIT_NOTES":
+				processEditNotes(message, state)
+			case "ENTER_EDIT_TAX":
+				processEditTax(message, state)
+			case "ENTER_DELETE_ID":
+				processDeleteId(message, state)
+			case "AWAIT_CSV":
+				// If the user typed something while awaiting CSV, allow text "cancel"
+				if strings.ToLower(strings.TrimSpace(message.Text)) == "cancel" {
+					delete(userStates, userID)
+					sendMessage(message.Chat.ID, "Bulk import canceled.")
+					return
+				}
+				sendMessage(message.Chat.ID, "Awaiting CSV file. Please send it as a document, or send 'cancel' to abort.")
+			case "AWAIT_BUNDLE":
+				if strings.ToLower(strings.TrimSpace(message.Text)) == "cancel" {
+					delete(userStates, userID)
+					sendMessage(message.Chat.ID, "Import canceled.")
+					return
+				}
+				sendMessage(message.Chat.ID, "Awaiting the bundle file. Please send it as a document, or send 'cancel' to abort.")
+			case "AWAIT_LOCATION":
+				if strings.ToLower(strings.TrimSpace(message.Text)) == "cancel" {
+					delete(userStates, userID)
+					sendMessage(message.Chat.ID, "Location canceled.")
+					return
+				}
+				sendMessage(message.Chat.ID, "Awaiting a location. Share it with 📎 → Location, or send 'cancel' to abort.")
+			case "ENTER_EDIT_QUANTITY":
+				processEditQuantityEdit(message, state)
+			case "REPORT_PERIOD":
+				processReportPeriodText(message, state)
+			case "ENTER_REPORT_NAME":
+				processReportName(message, state)
+			case "START_LANGUAGE", "START_CURRENCY", "START_TIMEZONE", "START_CATEGORIES", "START_BUDGET":
+				processOnboardingText(message, state)
+			case "ALLOCATE_INCOME", "ALLOCATE_CATEGORY":
+				processAllocationText(message, state)
+			default:
+				sendMessage(message.Chat.ID, "I don't understand that command.")
+			}
+		} else if command != "" || !(handleQuickAdd(message) || handleAIEntry(message)) {
+			sendMessage(message.Chat.ID, "I don't understand that command.")
+		}
+	}
+}
+
+func handleCallbackQuery(callback *CallbackQuery) {
+	userID := callback.From.ID
+
+	// Read-only mode: only the buttons that show something are served
+	if isMaintenanceMode() && !maintenanceMayPress(callback.Data) {
+		delete(userStates, userID)
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+
+	// Split buttons are pressed by group members, not only by the owner
+	if strings.HasPrefix(callback.Data, "split:") {
+		handleSplitCallback(callback)
+		return
+	}
+
+	if !isAllowedUser(userID) && !(viewerMayPress(callback.Data) && isViewer(userID)) {
+		sendMessage(callback.Message.Chat.ID, "You are not authorized to use this bot.")
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, "reimb:") {
+		handleReimbursementCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "invoice:") {
+		handleInvoiceCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "receipt:") {
+		handleReceiptCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "bill:") {
+		handleBillCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "sub:") {
+		handleSubscriptionCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "view:") {
+		handleViewCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "start:") {
+		handleOnboardingCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "report:") {
+		handleReportCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "amend:") {
+		handleAmendCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "sugg:") {
+		handleSuggestionCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "ledger:") {
+		handleLedgerCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "recat:") {
+		handleRecategorizeCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "rec:") {
+		handleReconcileCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "confirm:") {
+		handleConfirmCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "alloc:") {
+		handleAllocationCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "latest:") {
+		handleLatestCallback(callback)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "resume:") {
+		handleResumeCallback(callback)
+		return
+	}
+
+	state, exists := userStates[userID]
+	if !exists {
+		// If there's no state but callback comes from edit/delete menu, ignore
+		_ = messenger.AnswerCallback(callback.ID, "")
+		return
+	}
+
+	// Remove "loading" state in client
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	if callback.Data == flowBack {
+		processFlowBack(callback, state)
+		return
+	}
+
+	switch state.Step {
+	case "SELECT_TYPE":
+		processTransactionType(callback, state)
+	case "SELECT_CATEGORY":
+		processCategory(callback, state)
+	case "AI_CONFIRM":
+		processAIConfirm(callback, state)
+	case "QUICK_CATEGORY":
+		processQuickCategory(callback, state)
+	case "SELECT_EDIT_FIELD":
+		processEditField(callback, state)
+	case "SELECT_EDIT_TYPE":
+		processEditTransactionType(callback, state)
+	case "SELECT_EDIT_CATEGORY":
+		processEditCategory(callback, state)
+	case "SELECT_EDIT_IS_OUTLIER":
+		processEditIsOutlier(callback, state)
+	case "SELECT_EDIT_DEDUCTIBLE":
+		processEditDeductible(callback, state)
+	case "CONFIRM_DELETE":
+		processDeleteConfirmation(callback, state)
+	default:
+		// no-op
+	}
+}
+
+func startTransaction(chatID int64, userID int64) {
+	state := &TransactionState{
+		UserID:   userID,
+		Step:     "SELECT_TYPE",
+		Quantity: 1,
+	}
+	userStates[userID] = state
+
+	messageID, err := messenger.SendKeyboard(chatID, escapeHTML(typePrompt), typeKeyboard())
+	if err != nil {
+		log.Printf("Error sending message with keyboard: %v", err)
+		return
+	}
+	state.PromptMessageID = messageID
+}
+
+// startBulkTransactions starts the two-step flow for CSV upload via Telegram.
+// User will be prompted to send the CSV file as a document.
+func startBulkTransactions(chatID int64, userID int64) {
+	state := &TransactionState{
+		UserID: userID,
+		Step:   "AWAIT_CSV",
+	}
+	userStates[userID] = state
+	sendMessage(chatID, "Please send the CSV file as a document now. Supported CSV columns (header-based) include: type,category,quantity,amount,description,created_at,is_outlier,notes. Legacy positional files (type,category,amount,description,created_at) are also supported. Send 'cancel' to abort.")
+}
+
+// handleDocument handles incoming document messages: used for bulk CSV import
+func handleDocument(message *TGMessage) {
+	if message.From == nil || message.Chat == nil || message.Document == nil {
+		return
+	}
+	userID := message.From.ID
+	chatID := message.Chat.ID
+
+	if !isAllowedUser(userID) {
+		sendMessage(chatID, "You are not authorized to use this bot.")
+		return
+	}
+
+	state, exists := userStates[userID]
+	if exists && state.Step == "AWAIT_BUNDLE" {
+		if !strings.HasSuffix(strings.ToLower(message.Document.FileName), ".json") && !strings.Contains(message.Document.MimeType, "json") {
+			sendMessage(chatID, "Please upload the .json file made by /export_all.")
+			return
+		}
+		tmpPath, err := botClient.DownloadFile(message.Document.FileID)
+		if err != nil {
+			log.Printf("Failed to download document: %v", err)
+			sendMessage(chatID, "Failed to download the uploaded file. See server logs.")
+			delete(userStates, userID)
+			return
+		}
+		defer os.Remove(tmpPath)
+		delete(userStates, userID)
+		importBundleFile(chatID, userID, tmpPath)
+		return
+	}
+	if !exists || state.Step != "AWAIT_CSV" {
+		sendMessage(chatID, "No bulk import in progress. Start with /bulk_transactions")
+		return
+	}
+
+	// Basic check: prefer file name extension, fallback to mime type
+	lowerName := strings.ToLower(message.Document.FileName)
+	if lowerName == "" {
+		lowerName = strings.ToLower(message.Document.MimeType)
+	}
+	if !strings.Contains(lowerName, "csv") && !strings.HasSuffix(lowerName, ".csv") {
+		sendMessage(chatID, "Please upload a CSV file (filename must end with .csv or mime type should indicate CSV).")
+		return
+	}
+
+	// Download file
+	tmpPath, err := botClient.DownloadFile(message.Document.FileID)
+	if err != nil {
+		log.Printf("Failed to download document: %v", err)
+		sendMessage(chatID, "Failed to download the uploaded file. See server logs.")
+		delete(userStates, userID)
+		return
+	}
+	// Ensure cleanup
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	sendMessage(chatID, "File received. Processing...")
+
+	// Run import
+	inserted, errs := bulkInsertFromCSV(tmpPath)
+
+	if len(errs) == 0 {
+		sendMessage(chatID, fmt.Sprintf("Import complete: %d rows inserted.", inserted))
+	} else {
+		sendMessage(chatID, fmt.Sprintf("Import finished: %d rows inserted. There were %d errors (see server logs).", inserted, len(errs)))
+		for _, e := range errs {
+			log.Printf("CSV import error: %v", e)
+		}
+	}
+
+	// refresh categories cache (in case new categories were inserted)
+	refreshCategories()
+
+	// Clear state
+	delete(userStates, userID)
+}
+
+func processTransactionType(callback *CallbackQuery, state *TransactionState) {
+	state.TransactionType = callback.Data
+	state.Step = "SELECT_CATEGORY"
+	state.PromptMessageID = callback.Message.MessageID
+
+	editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, categoryPrompt(state), categoryKeyboard())
+}
+
+func processCategory(callback *CallbackQuery, state *TransactionState) {
+	state.Category = callback.Data
+	state.Step = "ENTER_AMOUNT"
+	state.PromptMessageID = callback.Message.MessageID
+
+	editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, amountPrompt(state), backKeyboard())
+}
+
+func processAmount(message *TGMessage, state *TransactionState) {
+	amount, foreign, err := parseEntryAmount(message.Text, appClock.Now())
+	if err == errNotAmount {
+		sendMessage(message.Chat.ID, "Invalid amount. Please enter a positive number, or one with its currency such as 20USD.")
+		return
+	}
+	if err != nil {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Cannot convert the amount: %v.", err))
+		return
+	}
+
+	state.Amount = amount
+	state.Foreign = foreign
+	state.AmountMessageID = message.MessageID
+	state.Step = "ENTER_DESCRIPTION"
+	// the amount prompt loses its Back button, the description prompt has one
+	if state.PromptMessageID != 0 {
+		editMessage(message.Chat.ID, state.PromptMessageID, fmt.Sprintf("Selected category: %s. Amount: %s.", state.Category, formatMoney(amount)))
+	}
+	messageID, err := messenger.SendKeyboard(message.Chat.ID, escapeHTML(descriptionPrompt), backKeyboard())
+	if err != nil {
+		log.Printf("Error sending message with keyboard: %v", err)
+		return
+	}
+	state.PromptMessageID = messageID
+}
+
+func processDescription(message *TGMessage, state *TransactionState) {
+	if len(message.Text) > 100 {
+		sendMessage(message.Chat.ID, "Description too long. Please keep it under 100 characters.")
+		return
+	}
+
+	state.Description = message.Text
+	if state.PromptMessageID != 0 {
+		editMessage(message.Chat.ID, state.PromptMessageID, "Description: "+state.Description)
+	}
+	saveTransaction(message.Chat.ID, state, message.MessageID, "")
+}
+
+// saveTransaction stores the transaction collected in state, confirms it
+// in chatID with note after the first line, and ends the flow.
+// descriptionMessageID is the message that gave the description.
+func saveTransaction(chatID int64, state *TransactionState, descriptionMessageID int, note string) {
+	quantity := state.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+
+	// Get current time in GMT+7
+	currentTime := appClock.Now()
+
+	id, roundup, err := insertTransaction(state.TransactionType, state.Category, quantity, state.Amount, state.Description, currentTime, state.IsOutlier)
+	if err != nil {
+		sendMessage(chatID, "Failed to save transaction.")
+		reportError("saving a transaction", err)
+		return
+	}
+
+	if state.Location != nil {
+		if err := setTransactionLocation(id, state.Location); err != nil {
+			reportError("saving the location of a transaction", err)
+		}
+	}
+	if state.Foreign != nil {
+		if err := setTransactionForeign(id, state.Foreign); err != nil {
+			reportError("saving the original amount of a transaction", err)
+		}
+	}
+	method := defaultPaymentMethod()
+	if method != "" {
+		if _, err := execCached("UPDATE transactions SET payment_method = ? WHERE id = ?", method, id); err != nil {
+			reportError("saving the payment method of a transaction", err)
+		}
+	}
+
+	delete(userStates, state.UserID)
+	lastLogged[state.UserID] = &loggedEntry{TransactionID: id, AmountMessageID: state.AmountMessageID, DescriptionMessageID: descriptionMessageID}
+	reply := bold("Transaction added successfully!") + "\n" + transactionRecap(id, state.TransactionType, state.Category, quantity, state.Amount, state.Description)
+	if state.Foreign != nil {
+		reply += "\n" + escapeHTML(foreignNote(state.Foreign, currentTime))
+	}
+	if note != "" {
+		reply += "\n" + escapeHTML(note)
+	}
+	if state.TransactionType == "expense" {
+		status, err := categoryBudgetStatus(state.Category, currentTime)
+		if err != nil {
+			reportError("computing the budget status", err)
+		} else if status != "" {
+			reply += "\n\n" + escapeHTML(status)
+		}
+		if method == cashMethod {
+			if warning := walletWarning(); warning != "" {
+				reply += "\n\n" + escapeHTML(warning)
+			}
+		}
+	}
+	if roundup != "" {
+		reply += "\n\n" + escapeHTML(roundup)
+	}
+	keyboard := buildKeyboard([][]InlineKeyboardButton{transactionButtons(id)})
+	replyID, err := messenger.SendKeyboard(chatID, reply+"\n\n"+italic("↩️ Reply to this message to correct the amount or description."), keyboard)
+	if err != nil {
+		log.Printf("Error sending message: %v", err)
+		return
+	}
+	rememberTransactionMessage(chatID, replyID, id)
+	transactionsChanged()
+}
+
+// insertTransaction stores a single transaction and follows it up
+// (transactionAdded). It returns its id and the notes for the
+// confirmation, or "".
+func insertTransaction(typ string, category string, quantity float64, amount Money, description string, createdAt time.Time, isOutlier bool) (int64, string, error) {
+	t := Transaction{
+		Type:        typ,
+		Category:    category,
+		Quantity:    quantity,
+		Amount:      amount,
+		Description: description,
+		CreatedAt:   createdAt,
+		IsOutlier:   isOutlier,
+		LedgerID:    activeLedgerID(),
+	}
+	id, err := store.AddTransaction(t)
+	if err != nil {
+		return 0, "", err
+	}
+	return id, transactionAdded(id, t), nil
+}
+
+// transactionAdded runs what follows every new transaction, once it is
+// committed, whichever way it came in: the chat, receipts, the API, the
+// CLI, a bill paid or a subscription renewed: an expense is rounded up
+// (roundup.go) and the budgets it pushes into a new tier are alerted
+// (budget.go) right away rather than on the next scheduler tick. It
+// returns the notes for the confirmation, or "". Imports and demo data
+// are history, not new spending, and skip it.
+func transactionAdded(id int64, t Transaction) string {
+	if _, ok := store.(sqlStorage); !ok {
+		// a dry run writes nothing
+		return ""
+	}
+	if t.Type != "expense" {
+		return ""
+	}
+	note := applyRoundup(id, t.Amount.Mul(t.Quantity))
+	if err := checkBudgetAlerts(appClock.Now()); err != nil {
+		reportError("checking budget alerts", err)
+	}
+	return note
+}
+
+func showSummary(chatID int64, args string) {
+	month, withArchive, err := parseSummaryArgs(args, appClock.Now())
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v. Usage: /summary [YYYY-MM] [archive]", err))
+		return
+	}
+	summaryMessage, err := monthlySummaryText(month, withArchive)
+	if err != nil {
+		sendMessage(chatID, "Error retrieving transactions.")
+		reportError("building the monthly summary", err)
+		return
+	}
+	sendHTML(chatID, summaryMessage)
+}
+
+// monthlySummaryText builds the /summary report for month, in HTML.
+// Archived transactions are only counted when withArchive is set.
+func monthlySummaryText(month time.Time, withArchive bool) (string, error) {
+	incomeTotal, expenseTotal, err := monthTotals(month)
+	if err != nil {
+		return "", err
+	}
+	monthStart, monthEnd := monthBounds(month)
+	previousIncome, previousExpense, err := monthTotals(monthStart.AddDate(0, -1, 0))
+	if err != nil {
+		return "", err
+	}
+	archived, err := archivedCountBetween(monthStart, monthEnd)
+	if err != nil {
+		return "", err
+	}
+	if withArchive && archived > 0 {
+		archivedIncome, archivedExpense, err := archivedMonthTotals(month)
+		if err != nil {
+			return "", err
+		}
+		incomeTotal += archivedIncome
+		expenseTotal += archivedExpense
+	}
+
+	balance := incomeTotal - expenseTotal
+	title := month.Format("January 2006")
+	if label := ledgerLabel(); label != "" {
+		title += " (" + label + ")"
+	}
+	summaryMessage := bold(fmt.Sprintf("Monthly Summary Report for %s:", title)) + "\n\n"
+	icons := typeIcons()
+	summaryMessage += withTrend(icons["income"]+"Total Income: "+moneyHTML(incomeTotal), incomeTotal, previousIncome, "last month") + "\n"
+	summaryMessage += withTrend(icons["expense"]+"Total Expense: "+moneyHTML(expenseTotal), expenseTotal, previousExpense, "last month") + "\n\n"
+	summaryMessage += "Balance: " + bold(formatMoney(balance))
+	switch {
+	case archived > 0 && withArchive:
+		summaryMessage += fmt.Sprintf("\n\nIncludes %d archived transaction(s).", archived)
+	case archived > 0:
+		summaryMessage += escapeHTML(fmt.Sprintf("\n\n%d archived transaction(s) not included; add \"archive\" to include them.", archived))
+	}
+	return summaryMessage, nil
+}
+
+// sendMessage sends plain text with the active messenger, and sendHTML a
+// formatted message (html.go). Transient failures are retried by the
+// transport; the returned error is permanent and has already been logged.
+func sendMessage(chatID int64, text string) error {
+	return sendHTML(chatID, escapeHTML(text))
+}
+
+func sendMessageWithKeyboard(chatID int64, text string, keyboard InlineKeyboardMarkup) error {
+	return sendHTMLWithKeyboard(chatID, escapeHTML(text), keyboard)
+}
+
+func editMessage(chatID int64, messageID int, text string) error {
+	return editHTML(chatID, messageID, escapeHTML(text))
+}
+
+func editMessageWithKeyboard(chatID int64, messageID int, text string, keyboard InlineKeyboardMarkup) error {
+	return editHTMLWithKeyboard(chatID, messageID, escapeHTML(text), keyboard)
+}
+
+func sendHTML(chatID int64, text string) error {
+	_, err := messenger.SendText(chatID, text)
+	if err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+	return err
+}
+
+func sendHTMLWithKeyboard(chatID int64, text string, keyboard InlineKeyboardMarkup) error {
+	_, err := messenger.SendKeyboard(chatID, text, keyboard)
+	if err != nil {
+		log.Printf("Error sending message with keyboard: %v", err)
+	}
+	return err
+}
+
+func editHTML(chatID int64, messageID int, text string) error {
+	err := messenger.EditMessage(chatID, messageID, text, nil)
+	if err != nil {
+		log.Printf("Error editing message: %v", err)
+	}
+	return err
+}
+
+func editHTMLWithKeyboard(chatID int64, messageID int, text string, keyboard InlineKeyboardMarkup) error {
+	err := messenger.EditMessage(chatID, messageID, text, &keyboard)
+	if err != nil {
+		log.Printf("Error editing message with keyboard: %v", err)
+	}
+	return err
+}
+
+func get_weekly_expense_report(chatID int64) {
+	text, err := lastSevenDaysText(appClock.Now())
+	if err != nil {
+		sendMessage(chatID, "Failed to build the weekly expense report.")
+		reportError("building the weekly expense report", err)
+		return
+	}
+	sendHTML(chatID, text)
+}
+
+func get_weekly_expense_piechart(chatID int64) {
+	chart, err := weeklyPie(appClock.Now())
+	if err != nil {
+		sendMessage(chatID, "Failed to build the weekly expense chart.")
+		reportError("building the weekly expense chart", err)
+		return
+	}
+	if len(chart.Slices) == 0 {
+		sendMessage(chatID, "No expense data for the last 7 days")
+		return
+	}
+	caption := "📊 " + chart.Title
+	var over []string
+	for _, slice := range chart.Slices {
+		if slice.OverBudget {
+			over = append(over, slice.Category)
+		}
+	}
+	if len(over) > 0 {
+		caption += "\n🔴 Over this month's budget: " + strings.Join(over, ", ")
+	}
+	sendChart(chatID, weeklyPieScript, chart, caption)
+}
+
+// writeTransactionsCSV writes every transaction to w in the export format.
+func writeTransactionsCSV(w io.Writer) error {
+	rows, err := db.Query("SELECT id, type, category, quantity, amount, description, created_at, is_outlier, COALESCE(notes, ''), original_amount, COALESCE(original_currency, '') FROM transactions WHERE " + ledgerScope() + " ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("query transactions: %w", err)
+	}
+	defer rows.Close()
+
+	writer := csv.NewWriter(w)
+	// write header; the import reads the columns by name and ignores the
+	// original amount ones
+	if err := writer.Write([]string{"id", "type", "category", "quantity", "amount", "description", "created_at", "is_outlier", "notes", "original_amount", "original_currency"}); err != nil {
+		return fmt.Errorf("write CSV header: %w", err)
+	}
+
+	for rows.Next() {
+		var (
+			id          int64
+			typ         string
+			category    string
+			quantity    float64
+			amount      Money
+			description sql.NullString
+			createdAt   string
+			isOutlier   sql.NullBool
+			notes       string
+			original    NullMoney
+			currency    string
+		)
+		if err := rows.Scan(&id, &typ, &category, &quantity, &amount, &description, &createdAt, &isOutlier, &notes, &original, &currency); err != nil {
+			log.Printf("Row scan error while exporting CSV: %v", err)
+			continue
+		}
+		desc := ""
+		if description.Valid {
+			desc = description.String
+		}
+		outlierStr := ""
+		if isOutlier.Valid && isOutlier.Bool {
+			outlierStr = "true"
+		} else if isOutlier.Valid && !isOutlier.Bool {
+			outlierStr = "false"
+		}
+		record := []string{
+			strconv.FormatInt(id, 10),
+			typ,
+			category,
+			fmt.Sprintf("%.2f", quantity),
+			formatMoneyPlain(amount),
+			desc,
+			createdAt,
+			outlierStr,
+			notes,
+			"",
+			currency,
+		}
+		if original.Valid {
+			record[9] = lookupCurrency(currency).plain(original.Money)
+		}
+		if err := writer.Write(record); err != nil {
+			log.Printf("CSV write row error: %v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+/*
+	Bulk CSV import: read CSV file and insert rows into the DB.
+	Supported CSV columns (header-based):
+	type, category, quantity, amount, description (optional), created_at (optional), is_outlier (optional)
+
+	Legacy positional format supported (no header):
+	type,category,amount,description (optional),created_at (optional)
+
+	created_at supports RFC3339, "2006-01-02 15:04:05", or "2006-01-02".
+	Category names that don't exist will be added to categories table.
+*/
+
+// bulkInsertFromCSV reads CSV file at filePath and inserts rows into the DB.
+// Returns number of successfully inserted rows and a slice of errors encountered per row.
+func bulkInsertFromCSV(filePath string) (int, []error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, []error{fmt.Errorf("failed to open file: %w", err)}
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1 // allow variable number of fields per row
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return 0, []error{fmt.Errorf("failed to read CSV: %w", err)}
+	}
+
+	startIdx := 0
+	hasHeader := false
+	var headerMap map[string]int
+	if len(rows) > 0 {
+		firstLower := strings.ToLower(strings.Join(rows[0], ","))
+		if strings.Contains(firstLower, "type") && strings.Contains(firstLower, "amount") {
+			startIdx = 1 // skip header
+			hasHeader = true
+			headerMap = make(map[string]int)
+			for i, h := range rows[0] {
+				headerMap[strings.ToLower(strings.TrimSpace(h))] = i
+			}
+		}
+	}
+
+	// rows without a category get one from the rules; load them, and the
+	// closed period, before the transaction holds the only connection
+	rules, err := loadRules()
+	if err != nil {
+		return 0, []error{fmt.Errorf("failed to load the rules: %w", err)}
+	}
+	ledgerID := activeLedgerID()
+	cutoff := closedBefore()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, []error{fmt.Errorf("failed to begin transaction: %w", err)}
+	}
+	// Ensure we rollback on error if commit doesn't happen
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmtCat, err := tx.Prepare("INSERT OR IGNORE INTO categories (name) VALUES (?)")
+	if err != nil {
+		return 0, []error{fmt.Errorf("failed to prepare category statement: %w", err)}
+	}
+	defer stmtCat.Close()
+
+	inserted := 0
+	var errs []error
+	for i := startIdx; i < len(rows); i++ {
+		row := rows[i]
+		// Expect at least 3 columns: type, category, amount (legacy) or type,category,quantity,amount (new)
+		if len(row) < 3 {
+			errs = append(errs, fmt.Errorf("row %d: not enough columns (need at least type, category, amount)", i+1))
+			continue
+		}
+
+		var typ, category, amountStr, desc, createdAtStr, quantityStr, isOutlierStr, notes string
+		var quantity float64 = 1
+		var isOutlier bool = false
+
+		if hasHeader {
+			// use header map to obtain values
+			get := func(name string) string {
+				if idx, ok := headerMap[name]; ok && idx < len(row) {
+					return strings.TrimSpace(row[idx])
+				}
+				return ""
+			}
+			typ = strings.ToLower(get("type"))
+			category = get("category")
+			quantityStr = get("quantity")
+			amountStr = get("amount")
+			desc = get("description")
+			createdAtStr = get("created_at")
+			isOutlierStr = get("is_outlier")
+			notes = get("notes")
+			if quantityStr != "" {
+				if q, err := strconv.ParseFloat(quantityStr, 64); err == nil {
+					quantity = q
+				}
+			}
+			if isOutlierStr != "" {
+				isOutlier = parseBool(isOutlierStr)
+			}
+		} else {
+			// No header: support legacy and new positional formats
+			typ = strings.ToLower(strings.TrimSpace(row[0]))
+			category = strings.TrimSpace(row[1])
+
+			// Try to detect if row[2] is quantity or amount:
+			// If row has >=4 columns and row[3] parses as float, assume row[2]=quantity, row[3]=amount.
+			if len(row) >= 4 {
+				if _, errA := strconv.ParseFloat(strings.TrimSpace(row[3]), 64); errA == nil {
+					// treat as quantity + amount
+					quantityStr = strings.TrimSpace(row[2])
+					amountStr = strings.TrimSpace(row[3])
+					if q, err := strconv.ParseFloat(quantityStr, 64); err == nil {
+						quantity = q
+					}
+					if len(row) > 4 {
+						desc = strings.TrimSpace(row[4])
+					}
+					if len(row) > 5 {
+						createdAtStr = strings.TrimSpace(row[5])
+					}
+					if len(row) > 6 {
+						isOutlierStr = strings.TrimSpace(row[6])
+						isOutlier = parseBool(isOutlierStr)
+					}
+				} else {
+					// treat as legacy: amount at row[2]
+					amountStr = strings.TrimSpace(row[2])
+					if len(row) > 3 {
+						desc = strings.TrimSpace(row[3])
+					}
+					if len(row) > 4 {
+						createdAtStr = strings.TrimSpace(row[4])
+					}
+					if len(row) > 5 {
+						isOutlierStr = strings.TrimSpace(row[5])
+						isOutlier = parseBool(isOutlierStr)
+					}
+				}
+			} else {
+				// Only three columns: assume amount is row[2]
+				amountStr = strings.TrimSpace(row[2])
+			}
+		}
+
+		if typ != "income" && typ != "expense" {
+			errs = append(errs, fmt.Errorf("row %d: invalid type '%s' (must be 'income' or 'expense')", i+1, row[0]))
+			continue
+		}
+		amount, err := parseMoney(amountStr)
+		if err != nil || amount <= 0 {
+			errs = append(errs, fmt.Errorf("row %d: invalid amount '%s'", i+1, amountStr))
+			continue
+		}
+		if category == "" {
+			category = "Uncategorized"
+			if r, ok := matchRule(rules, typ, amount, desc); ok {
+				category = r.Category
+			}
+		}
+
+		// ensure category exists
+		if _, err := stmtCat.Exec(category); err != nil {
+			// log but continue; failure to insert category shouldn't block row insertion
+			log.Printf("failed to ensure category %s: %v", category, err)
+		}
+
+		// parse createdAt if provided
+		var createdAt time.Time
+		if createdAtStr == "" {
+			createdAt = appClock.Now()
+		} else {
+			layouts := []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+			var pErr error
+			for _, lay := range layouts {
+				createdAt, pErr = time.Parse(lay, createdAtStr)
+				if pErr == nil {
+					break
+				}
+			}
+			if createdAt.IsZero() {
+				// fallback to now in GMT+7
+				createdAt = appClock.Now()
+			}
+		}
+		if closedAt(createdAt.Format(dbTimeLayout), cutoff) {
+			errs = append(errs, fmt.Errorf("row %d: %s is in a closed period (through %s)", i+1, createdAt.Format(dateLayout), closedThrough(cutoff)))
+			continue
+		}
+
+		t := Transaction{Type: typ, Category: category, Quantity: quantity, Amount: amount, Description: desc, Notes: notes, CreatedAt: createdAt, IsOutlier: isOutlier, LedgerID: ledgerID}
+		if _, err := addTransactionTx(tx, t); err != nil {
+			errs = append(errs, fmt.Errorf("row %d: db insert error: %v", i+1, err))
+			continue
+		}
+		inserted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to commit transaction: %w", err))
+		return inserted, errs
+	}
+
+	return inserted, errs
+}
+
+func parseBool(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "y", "t":
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+	EDIT / UPDATE feature
+*/
+
+// startEdit initiates the interactive edit flow asking for ID
+func startEdit(chatID int64, userID int64) {
+	state := &TransactionState{
+		UserID: userID,
+		Step:   "ENTER_EDIT_ID",
+	}
+	userStates[userID] = state
+	sendMessage(chatID, "Please enter the transaction ID you want to edit.")
+}
+
+// startEditWithID begins edit flow immediately when ID is already provided
+func startEditWithID(chatID int64, userID int64, id int64) {
+	t, err := store.Transaction(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
+			return
+		}
+		sendMessage(chatID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+	if !allowChange(chatID, userID, id, t.CreatedAt.Format(dbTimeLayout)) {
+		return
+	}
+
+	state := &TransactionState{
+		UserID:          userID,
+		Step:            "SELECT_EDIT_FIELD",
+		EditID:          id,
+		TransactionType: t.Type,
+		Category:        t.Category,
+		Amount:          t.Amount,
+		Quantity:        t.Quantity,
+		Description:     t.Description,
+		IsOutlier:       t.IsOutlier,
+	}
+	userStates[userID] = state
+
+	state.Notes = transactionNotes(id)
+	details, keyboard := editFieldMenu(state)
+	sendMessageWithKeyboard(chatID, details, keyboard)
+}
+
+// processEditId handles user input for the ID to edit
+func processEditId(message *TGMessage, state *TransactionState) {
+	id, err := strconv.ParseInt(strings.TrimSpace(message.Text), 10, 64)
+	if err != nil || id <= 0 {
+		sendMessage(message.Chat.ID, "Invalid ID. Please enter a valid transaction ID number.")
+		return
+	}
+
+	t, err := store.Transaction(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendMessage(message.Chat.ID, fmt.Sprintf("Transaction with ID %d not found.", id))
+			return
+		}
+		sendMessage(message.Chat.ID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+
+	state.EditID = id
+	state.TransactionType = t.Type
+	state.Category = t.Category
+	state.Amount = t.Amount
+	state.Quantity = t.Quantity
+	state.Description = t.Description
+	state.IsOutlier = t.IsOutlier
+	state.Step = "SELECT_EDIT_FIELD"
+
+	state.Notes = transactionNotes(id)
+	details, keyboard := editFieldMenu(state)
+	sendMessageWithKeyboard(message.Chat.ID, details, keyboard)
+}
+
+// editFieldMenu is the details of the transaction being edited with the
+// buttons to pick the field to change.
+func editFieldMenu(state *TransactionState) (string, InlineKeyboardMarkup) {
+	details := fmt.Sprintf("Transaction ID: %d\nType: %s\nCategory: %s\nQuantity: %.2f\nAmount: %s\nDescription: %s\nIs Outlier: %v%s%s\n\nChoose field to edit:",
+		state.EditID, state.TransactionType, state.Category, state.Quantity, formatMoney(state.Amount), state.Description, state.IsOutlier, notesLine(state.Notes), taxLine(transactionTax(state.EditID)))
+	buttons := [][]InlineKeyboardButton{
+		{
+			{Text: "Edit Type", CallbackData: "edit_field:type"},
+			{Text: "Edit Category", CallbackData: "edit_field:category"},
+		},
+		{
+			{Text: "Edit Amount", CallbackData: "edit_field:amount"},
+			{Text: "Edit Quantity", CallbackData: "edit_field:quantity"},
+		},
+		{
+			{Text: "Edit Description", CallbackData: "edit_field:description"},
+			{Text: "Toggle Outlier", CallbackData: "edit_field:is_outlier"},
+		},
+		{
+			{Text: "Edit Notes", CallbackData: "edit_field:notes"},
+			{Text: "Edit Tax", CallbackData: "edit_field:tax"},
+		},
+		{
+			{Text: "Toggle Tax-deductible", CallbackData: "edit_field:deductible"},
+		},
+	}
+	return details, buildKeyboard(buttons)
+}
+
+// processEditField handles the callback when user selects which field to edit
+func processEditField(callback *CallbackQuery, state *TransactionState) {
+	parts := strings.SplitN(callback.Data, ":", 2)
+	if len(parts) != 2 {
+		sendMessage(callback.Message.Chat.ID, "Invalid selection.")
+		return
+	}
+	step, ok := editValueSteps[parts[1]]
+	if !ok {
+		sendMessage(callback.Message.Chat.ID, "Unknown field selected.")
+		return
+	}
+	state.Step = step
+	state.PromptMessageID = callback.Message.MessageID
+	prompt, keyboard := editValuePrompt(step)
+	editMessageWithKeyboard(callback.Message.Chat.ID, callback.Message.MessageID, prompt, keyboard)
+}
+
+// processEditTransactionType handles callback when user selects new type for edit
+func processEditTransactionType(callback *CallbackQuery, state *TransactionState) {
+	newType := callback.Data
+	chatID := callback.Message.Chat.ID
+	msgID := callback.Message.MessageID
+
+	if newType == "edit_cancel" {
+		editMessage(chatID, msgID, "Edit canceled.")
+		delete(userStates, state.UserID)
+		return
+	}
+
+	_, err := store.SetTransactionField(state.EditID, "type", newType)
+	if err != nil {
+		reportError("updating a transaction type", err)
+		editMessage(chatID, msgID, "Failed to update transaction type.")
+		delete(userStates, state.UserID)
+		return
+	}
+	editMessage(chatID, msgID, fmt.Sprintf("Transaction %d updated: type set to %s", state.EditID, newType))
+	delete(userStates, state.UserID)
+}
+
+// processEditCategory handles callback when user selects new category for edit
+func processEditCategory(callback *CallbackQuery, state *TransactionState) {
+	newCategory := callback.Data
+	chatID := callback.Message.Chat.ID
+	msgID := callback.Message.MessageID
+
+	if newCategory == "edit_cancel" {
+		editMessage(chatID, msgID, "Edit canceled.")
+		delete(userStates, state.UserID)
+		return
+	}
+
+	_, err := store.SetTransactionField(state.EditID, "category", newCategory)
+	if err != nil {
+		reportError("updating a transaction category", err)
+		editMessage(chatID, msgID, "Failed to update transaction category.")
+		delete(userStates, state.UserID)
+		return
+	}
+	editMessage(chatID, msgID, fmt.Sprintf("Transaction %d updated: category set to %s", state.EditID, newCategory))
+	delete(userStates, state.UserID)
+}
+
+// processEditAmountEdit handles updating amount after user inputs it
+func processEditAmountEdit(message *TGMessage, state *TransactionState) {
+	amount, err := parseMoney(message.Text)
+	if err != nil || amount <= 0 {
+		sendMessage(message.Chat.ID, "Invalid amount. Please enter a positive number.")
+		return
+	}
+	_, err = store.SetTransactionField(state.EditID, "amount", amount)
+	if err != nil {
+		reportError("updating a transaction amount", err)
+		if state.PromptMessageID != 0 {
+			editMessage(message.Chat.ID, state.PromptMessageID, "Failed to update transaction amount.")
+		} else {
+			sendMessage(message.Chat.ID, "Failed to update transaction amount.")
+		}
+		delete(userStates, state.UserID)
+		return
+	}
+
+	if state.PromptMessageID != 0 {
+		editMessage(message.Chat.ID, state.PromptMessageID, fmt.Sprintf("Transaction %d updated: amount set to %s", state.EditID, formatMoney(amount)))
+	} else {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Transaction %d updated: amount set to %s", state.EditID, formatMoney(amount)))
+	}
+
+	delete(userStates, state.UserID)
+}
+
+// processEditQuantityEdit handles updating quantity after user inputs it
+func processEditQuantityEdit(message *TGMessage, state *TransactionState) {
+	quantity, err := strconv.ParseFloat(message.Text, 64)
+	if err != nil || quantity <= 0 {
+		sendMessage(message.Chat.ID, "Invalid quantity. Please enter a positive number.")
+		return
+	}
+	_, err = store.SetTransactionField(state.EditID, "quantity", quantity)
+	if err != nil {
+		reportError("updating a transaction quantity", err)
+		if state.PromptMessageID != 0 {
+			editMessage(message.Chat.ID, state.PromptMessageID, "Failed to update transaction quantity.")
+		} else {
+			sendMessage(message.Chat.ID, "Failed to update transaction quantity.")
+		}
+		delete(userStates, state.UserID)
+		return
+	}
+
+	if state.PromptMessageID != 0 {
+		editMessage(message.Chat.ID, state.PromptMessageID, fmt.Sprintf("Transaction %d updated: quantity set to %.2f", state.EditID, quantity))
+	} else {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Transaction %d updated: quantity set to %.2f", state.EditID, quantity))
+	}
+
+	delete(userStates, state.UserID)
+}
+
+// processEditDescriptionEdit handles updating description after user inputs it
+func processEditDescriptionEdit(message *TGMessage, state *TransactionState) {
+	if len(message.Text) > 100 {
+		sendMessage(message.Chat.ID, "Description too long. Please keep it under 100 characters.")
+		return
+	}
+	_, err := store.SetTransactionField(state.EditID, "description", message.Text)
+	if err != nil {
+		reportError("updating a transaction description", err)
+		if state.PromptMessageID != 0 {
+			editMessage(message.Chat.ID, state.PromptMessageID, "Failed to update transaction description.")
+		} else {
+			sendMessage(message.Chat.ID, "Failed to update transaction description.")
+		}
+		delete(userStates, state.UserID)
+		return
+	}
+
+	if state.PromptMessageID != 0 {
+		editMessage(message.Chat.ID, state.PromptMessageID, fmt.Sprintf("Transaction %d updated: description set.", state.EditID))
+	} else {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Transaction %d updated: description set.", state.EditID))
+	}
+
+	delete(userStates, state.UserID)
+}
+
+// processEditIsOutlier handles callback to set/unset is_outlier
+func processEditIsOutlier(callback *CallbackQuery, state *TransactionState) {
+	data := callback.Data
+	chatID := callback.Message.Chat.ID
+	msgID := callback.Message.MessageID
+
+	if data == "edit_cancel" {
+		editMessage(chatID, msgID, "Edit canceled.")
+		delete(userStates, state.UserID)
+		return
+	}
+
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 || parts[0] != "is_outlier" {
+		editMessage(chatID, msgID, "Invalid selection.")
+		delete(userStates, state.UserID)
+		return
+	}
+	val := parts[1]
+	outlier := val == "true" || val == "1"
+
+	_, err := store.SetTransactionField(state.EditID, "is_outlier", outlier)
+	if err != nil {
+		reportError("updating a transaction outlier flag", err)
+		editMessage(chatID, msgID, "Failed to update transaction outlier flag.")
+		delete(userStates, state.UserID)
+		return
+	}
+	editMessage(chatID, msgID, fmt.Sprintf("Transaction %d updated: is_outlier set to %v", state.EditID, outlier))
+	delete(userStates, state.UserID)
+}
+
+/*
+	DELETE feature with confirmation
+*/
+
+// startDelete asks for an ID to delete
+func startDelete(chatID int64, userID int64) {
+	state := &TransactionState{
+		UserID: userID,
+		Step:   "ENTER_DELETE_ID",
+	}
+	userStates[userID] = state
+	sendMessage(chatID, "Please enter the transaction ID you want to delete.")
+}
+
+// startDeleteWithID begins delete flow immediately when ID is already provided
+func startDeleteWithID(chatID int64, userID int64, id int64) {
+	t, err := store.Transaction(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
+			return
+		}
+		sendMessage(chatID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+	if !allowChange(chatID, userID, id, t.CreatedAt.Format(dbTimeLayout)) {
+		return
+	}
+
+	state := &TransactionState{
+		UserID:          userID,
+		Step:            "CONFIRM_DELETE",
+		EditID:          id,
+		TransactionType: t.Type,
+		Category:        t.Category,
+		Amount:          t.Amount,
+		Quantity:        t.Quantity,
+		Description:     t.Description,
+		IsOutlier:       t.IsOutlier,
+	}
+	userStates[userID] = state
+
+	details := fmt.Sprintf("Transaction ID: %d\nType: %s\nCategory: %s\nQuantity: %.2f\nAmount: %s\nDescription: %s\nIs Outlier: %v\n\nAre you sure you want to DELETE this transaction?",
+		id, t.Type, t.Category, t.Quantity, formatMoney(t.Amount), t.Description, t.IsOutlier)
+	buttons := [][]InlineKeyboardButton{
+		{
+			{Text: "Confirm Delete", CallbackData: "delete_confirm"},
+			{Text: "Cancel", CallbackData: "delete_cancel"},
+		},
+	}
+	keyboard := buildKeyboard(buttons)
+	sendMessageWithKeyboard(chatID, details, keyboard)
+}
+
+// processDeleteId handles user input for the ID to delete
+func processDeleteId(message *TGMessage, state *TransactionState) {
+	id, err := strconv.ParseInt(strings.TrimSpace(message.Text), 10, 64)
+	if err != nil || id <= 0 {
+		sendMessage(message.Chat.ID, "Invalid ID. Please enter a valid transaction ID number.")
+		return
+	}
+
+	t, err := store.Transaction(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendMessage(message.Chat.ID, fmt.Sprintf("Transaction with ID %d not found.", id))
+			return
+		}
+		sendMessage(message.Chat.ID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+
+	state.EditID = id
+	state.TransactionType = t.Type
+	state.Category = t.Category
+	state.Amount = t.Amount
+	state.Quantity = t.Quantity
+	state.Description = t.Description
+	state.IsOutlier = t.IsOutlier
+	state.Step = "CONFIRM_DELETE"
+
+	details := fmt.Sprintf("Transaction ID: %d\nType: %s\nCategory: %s\nQuantity: %.2f\nAmount: %s\nDescription: %s\nIs Outlier: %v\n\nAre you sure you want to DELETE this transaction?",
+		id, t.Type, t.Category, t.Quantity, formatMoney(t.Amount), t.Description, t.IsOutlier)
+	buttons := [][]InlineKeyboardButton{
+		{
+			{Text: "Confirm Delete", CallbackData: "delete_confirm"},
+			{Text: "Cancel", CallbackData: "delete_cancel"},
+		},
+	}
+	keyboard := buildKeyboard(buttons)
+	sendMessageWithKeyboard(message.Chat.ID, details, keyboard)
+}
+
+// processDeleteConfirmation handles callback when user confirms or cancels deletion
+func processDeleteConfirmation(callback *CallbackQuery, state *TransactionState) {
+	chatID := callback.Message.Chat.ID
+	msgID := callback.Message.MessageID
+
+	switch callback.Data {
+	case "delete_confirm":
+		deleted, err := store.DeleteTransaction(state.EditID)
+		if err != nil {
+			reportError(fmt.Sprintf("deleting transaction %d", state.EditID), err)
+			editMessage(chatID, msgID, fmt.Sprintf("Failed to delete transaction %d.", state.EditID))
+			delete(userStates, state.UserID)
+			return
+		}
+		if !deleted {
+			editMessage(chatID, msgID, fmt.Sprintf("No transaction deleted. ID %d may not exist.", state.EditID))
+		} else {
+			editMessage(chatID, msgID, fmt.Sprintf("Transaction %d has been deleted.", state.EditID))
+		}
+		delete(userStates, state.UserID)
+	case "delete_cancel":
+		editMessage(chatID, msgID, "Deletion canceled.")
+		delete(userStates, state.UserID)
+	default:
+		editMessage(chatID, msgID, "Unknown selection. No action taken.")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+/*
+	DATABASE MAINTENANCE
+
+	A nightly job, at 04:00 by default so it runs in the quiet hours after
+	the archive job, that keeps the database small and fast:
+
+	- purges what has expired: notifications and webhook deliveries sent
+	  or given up on, job runs and the viewer log (viewers.go) older than
+	  maintenanceRetention, the history of transactions deleted more than
+	  auditRetention ago, reply links to transactions that no longer exist, the claims of handled updates older than
+	  updateRetention (dedupe.go), and files left behind in the data
+	  directory
+	- drops conversations (a half-finished /add, /edit, ...) left idle
+	  for stateTTL; they live in memory, so the update loop drops them
+	  when the job asks it to
+	- runs VACUUM, then ANALYZE
+
+	Each step is logged as one line of key=value fields. A failing step
+	does not stop the others; the owner gets one notification for the
+	night, and the job is not retried before the next night, so a broken
+	VACUUM does not run every minute. "ayunda maintenance" runs the same
+	steps by hand.
+
+	The bot logs to stderr and leaves rotating the logs to the service
+	manager (journald, docker); there are no log files of its own.
+*/
+
+const (
+	maintenanceRetention = 90 * 24 * time.Hour
+	auditRetention       = 365 * 24 * time.Hour
+	stateTTL             = 24 * time.Hour
+)
+
+type maintenanceStep struct {
+	name string
+	run  func(now time.Time) (int64, error) // returns the rows affected
+}
+
+var maintenanceSteps = []maintenanceStep{
+	{"purge_outbox", func(now time.Time) (int64, error) {
+		return execAffected("DELETE FROM outbox WHERE status IN ('sent', 'failed') AND created_at < ?", utcCutoff(now, maintenanceRetention))
+	}},
+	{"purge_webhook_deliveries", func(now time.Time) (int64, error) {
+		return execAffected("DELETE FROM webhook_deliveries WHERE status IN ('delivered', 'failed') AND created_at < ?", utcCutoff(now, maintenanceRetention))
+	}},
+	{"purge_viewer_queries", func(now time.Time) (int64, error) {
+		return execAffected("DELETE FROM viewer_queries WHERE created_at < ?", now.Add(-maintenanceRetention).Format(dbTimeLayout))
+	}},
+	{"purge_job_runs", func(now time.Time) (int64, error) {
+		return execAffected("DELETE FROM job_runs WHERE run_date < ?", now.Add(-maintenanceRetention).Format(dateLayout))
+	}},
+	{"purge_deleted_history", func(now time.Time) (int64, error) {
+		return execAffected(`DELETE FROM transaction_audit WHERE transaction_id IN (
+				SELECT transaction_id FROM transaction_audit WHERE action = 'delete' AND changed_at < ?)
+			AND transaction_id NOT IN (SELECT id FROM transactions)
+			AND transaction_id NOT IN (SELECT id FROM transactions_archive)`, utcCutoff(now, auditRetention))
+	}},
+	{"purge_stale_files", purgeStaleFiles},
+	{"purge_reply_links", func(now time.Time) (int64, error) {
+		return execAffected("DELETE FROM transaction_messages WHERE transaction_id NOT IN (SELECT id FROM transactions)")
+	}},
+	{"purge_processed_updates", func(now time.Time) (int64, error) {
+		return execAffected("DELETE FROM processed_updates WHERE processed_at < ?", utcCutoff(now, updateRetention))
+	}},
+	{"request_state_sweep", func(now time.Time) (int64, error) {
+		requestStateSweep()
+		return 0, nil
+	}},
+	{"vacuum", func(now time.Time) (int64, error) {
+		_, err := db.Exec("VACUUM")
+		return 0, err
+	}},
+	{"analyze", func(now time.Time) (int64, error) {
+		_, err := db.Exec("ANALYZE")
+		return 0, err
+	}},
+}
+
+// utcCutoff formats now minus age like CURRENT_TIMESTAMP, for the columns
+// that default to it.
+func utcCutoff(now time.Time, age time.Duration) string {
+	return now.Add(-age).UTC().Format(dbTimeLayout)
+}
+
+func execAffected(query string, args ...interface{}) (int64, error) {
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// runMaintenance runs every step and returns the failures joined.
+func runMaintenance(now time.Time) error {
+	var failures []error
+	for _, step := range maintenanceSteps {
+		start := time.Now()
+		rows, err := step.run(now)
+		took := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			log.Printf("maintenance step=%s status=failed duration=%s error=%q", step.name, took, err.Error())
+			failures = append(failures, fmt.Errorf("%s: %w", step.name, err))
+			continue
+		}
+		log.Printf("maintenance step=%s status=ok duration=%s rows=%d", step.name, took, rows)
+	}
+	return errors.Join(failures...)
+}
+
+// runMaintenanceJob is the scheduled job. Failures are reported to the
+// owner rather than returned, so the job is not retried before tomorrow.
+func runMaintenanceJob(now time.Time) error {
+	err := runMaintenance(now)
+	if err == nil {
+		return nil
+	}
+	errorReporter.CaptureError(err, map[string]string{"job": "maintenance", "where": "running database maintenance"})
+	if ownerID() == 0 {
+		return nil
+	}
+	text := "⚠️ Database maintenance failed:\n• " + strings.ReplaceAll(err.Error(), "\n", "\n• ")
+	if err := enqueueNotification(ownerID(), "maintenance", "maintenance:"+now.Format(dateLayout), text, nil); err != nil {
+		log.Printf("Failed to queue the maintenance alert: %v", err)
+	}
+	return nil
+}
+
+// stateSweeps asks the update loop, which owns userStates, to drop idle
+// conversations.
+var stateSweeps = make(chan struct{}, 1)
+
+// lastUpdateAt holds when each user last sent an update, in this run.
+var lastUpdateAt = make(map[int64]time.Time)
+
+func requestStateSweep() {
+	select {
+	case stateSweeps <- struct{}{}:
+	default:
+	}
+}
+
+// sweepStaleStates drops the conversations of users idle for stateTTL, if
+// the maintenance job asked for it. It runs on the update loop.
+func sweepStaleStates(now time.Time) {
+	select {
+	case <-stateSweeps:
+	default:
+		return
+	}
+	dropped := 0
+	for userID := range userStates {
+		if now.Sub(lastUpdateAt[userID]) >= stateTTL {
+			delete(userStates, userID)
+			dropped++
+		}
+	}
+	for userID, at := range lastUpdateAt {
+		if now.Sub(at) >= stateTTL {
+			delete(lastUpdateAt, userID)
+			delete(lastLogged, userID)
+		}
+	}
+	log.Printf("maintenance step=expire_states status=ok states=%d", dropped)
+}
+
+func cmdMaintenance(args []string) error {
+	fs := newCommandFlags("maintenance", "")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+	if err := runMaintenance(appClock.Now()); err != nil {
+		return err
+	}
+	fmt.Println("Maintenance done.")
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStorage is a Storage kept in memory, lost when the process ends.
+// It follows the ledger scope like the database does.
+type memoryStorage struct {
+	mu           sync.Mutex
+	transactions map[int64]Transaction
+	nextID       int64
+	categories   []string
+	budgets      map[string]Money
+}
+
+func newMemoryStorage(categories []string) *memoryStorage {
+	m := &memoryStorage{
+		transactions: make(map[int64]Transaction),
+		nextID:       1,
+		categories:   append([]string(nil), categories...),
+		budgets:      make(map[string]Money),
+	}
+	sort.Strings(m.categories)
+	return m
+}
+
+// copyStorage returns a memoryStorage holding the records of src that are
+// in the ledger scope.
+func copyStorage(src Storage) (*memoryStorage, error) {
+	categories, err := src.Categories()
+	if err != nil {
+		return nil, fmt.Errorf("categories: %w", err)
+	}
+	m := newMemoryStorage(categories)
+	budgets, err := src.CategoryBudgets()
+	if err != nil {
+		return nil, fmt.Errorf("budgets: %w", err)
+	}
+	for _, b := range budgets {
+		m.budgets[b.Category] = b.Amount
+	}
+	transactions, err := src.Transactions(TransactionFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("transactions: %w", err)
+	}
+	for _, t := range transactions {
+		m.transactions[t.ID] = t
+		if t.ID >= m.nextID {
+			m.nextID = t.ID + 1
+		}
+	}
+	return m, nil
+}
+
+// ledgerScopeFilter returns a test for the ledger scope. It reads the
+// settings, so call it before locking.
+func ledgerScopeFilter() func(t Transaction) bool {
+	combined, active := ledgersCombined(), activeLedgerID()
+	return func(t Transaction) bool {
+		return combined || t.LedgerID == active
+	}
+}
+
+func (m *memoryStorage) AddTransaction(t Transaction) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.ID = m.nextID
+	m.nextID++
+	m.transactions[t.ID] = t
+	return t.ID, nil
+}
+
+func (m *memoryStorage) Transaction(id int64) (Transaction, error) {
+	inScope := ledgerScopeFilter()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.transactions[id]
+	if !ok || !inScope(t) {
+		return Transaction{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (m *memoryStorage) SetTransactionField(id int64, field string, value interface{}) (bool, error) {
+	if !editableFields[field] {
+		return false, fmt.Errorf("field %q cannot be changed", field)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.transactions[id]
+	if !ok {
+		return false, nil
+	}
+	var valid bool
+	switch field {
+	case "type":
+		t.Type, valid = value.(string)
+	case "category":
+		t.Category, valid = value.(string)
+	case "description":
+		t.Description, valid = value.(string)
+	case "quantity":
+		t.Quantity, valid = value.(float64)
+	case "amount":
+		t.Amount, valid = value.(Money)
+	case "is_outlier":
+		t.IsOutlier, valid = value.(bool)
+	}
+	if !valid {
+		return false, fmt.Errorf("invalid %s %v", field, value)
+	}
+	m.transactions[id] = t
+	return true, nil
+}
+
+func (m *memoryStorage) DeleteTransaction(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.transactions[id]
+	delete(m.transactions, id)
+	return ok, nil
+}
+
+func (m *memoryStorage) Transactions(filter TransactionFilter) ([]Transaction, error) {
+	inScope := ledgerScopeFilter()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var transactions []Transaction
+	for _, t := range m.transactions {
+		if !inScope(t) ||
+			(filter.Type != "" && t.Type != filter.Type) ||
+			(filter.Category != "" && !strings.EqualFold(t.Category, filter.Category)) ||
+			t.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		transactions = append(transactions, t)
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		a, b := transactions[i], transactions[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	if filter.Limit > 0 && len(transactions) > filter.Limit {
+		transactions = transactions[:filter.Limit]
+	}
+	return transactions, nil
+}
+
+func (m *memoryStorage) Total(typ string, category string, from time.Time, to time.Time) (Money, error) {
+	inScope := ledgerScopeFilter()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total Money
+	for _, t := range m.transactions {
+		if inScope(t) && t.Type == typ && (category == "" || t.Category == category) &&
+			!t.CreatedAt.Before(from) && t.CreatedAt.Before(to) {
+			total += t.Amount.Mul(t.Quantity)
+		}
+	}
+	return total, nil
+}
+
+func (m *memoryStorage) Categories() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.categories...), nil
+}
+
+func (m *memoryStorage) CategoryBudgets() ([]categoryBudget, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	budgets := make([]categoryBudget, 0, len(m.budgets))
+	for category, amount := range m.budgets {
+		budgets = append(budgets, categoryBudget{Category: category, Amount: amount})
+	}
+	sort.Slice(budgets, func(i, j int) bool { return budgets[i].Category < budgets[j].Category })
+	return budgets, nil
+}
+
+func (m *memoryStorage) CategoryBudget(category string) (Money, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.budgets[category], nil
+}
+
+func (m *memoryStorage) SetCategoryBudget(category string, amount Money) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.budgets[category] = amount
+	return nil
+}
+
+func (m *memoryStorage) RemoveCategoryBudget(category string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.budgets, category)
+	return nil
+}
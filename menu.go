@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	MAIN MENU (/menu)
+
+	/menu shows a keyboard in place of the phone's one with the main
+	actions, so the bot can be used without typing a command:
+
+		➕ Add        📋 List
+		📊 Summary    🎯 Budgets
+		⚙️ Settings
+
+	A button sends its label as a message, which is handled as the
+	matching command (/add, /list, /summary, /budget, /settings). The
+	keyboard stays until /menu is sent again (or /menu off); whether it
+	is shown is remembered per user.
+*/
+
+// menuButtons are the rows of the main menu, with the command each
+// button stands for.
+var menuButtons = [][]struct{ label, command string }{
+	{{"➕ Add", "add"}, {"📋 List", "list"}},
+	{{"📊 Summary", "summary"}, {"🎯 Budgets", "budget"}},
+	{{"⚙️ Settings", "settings"}},
+}
+
+// menuCommand returns the command of a main menu button label.
+func menuCommand(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	for _, row := range menuButtons {
+		for _, b := range row {
+			if b.label == text {
+				return b.command, true
+			}
+		}
+	}
+	return "", false
+}
+
+func menuKeyboard() *ReplyKeyboardMarkup {
+	keyboard := &ReplyKeyboardMarkup{ResizeKeyboard: true, IsPersistent: true}
+	for _, row := range menuButtons {
+		var buttons []KeyboardButton
+		for _, b := range row {
+			buttons = append(buttons, KeyboardButton{Text: b.label})
+		}
+		keyboard.Keyboard = append(keyboard.Keyboard, buttons)
+	}
+	return keyboard
+}
+
+func menuSettingKey(userID int64) string {
+	return fmt.Sprintf("menu_keyboard:%d", userID)
+}
+
+// handleMenuCommand implements /menu [on|off]; without an argument it
+// toggles the keyboard.
+func handleMenuCommand(chatID int64, userID int64, args string) {
+	show := !getBoolSetting(menuSettingKey(userID), false)
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+	case "on":
+		show = true
+	case "off":
+		show = false
+	default:
+		sendMessage(chatID, "Usage: /menu to show or hide the menu keyboard, /menu on or /menu off")
+		return
+	}
+	if err := setSetting(menuSettingKey(userID), strconv.FormatBool(show)); err != nil {
+		reportError("saving the menu setting", err)
+	}
+	if !show {
+		if _, err := messenger.SendReplyKeyboard(chatID, "Menu hidden. Send /menu to bring it back.", nil); err != nil {
+			reportError("hiding the menu", err)
+		}
+		return
+	}
+	if _, err := messenger.SendReplyKeyboard(chatID, "📱 Menu shown below the chat. Send /menu again to hide it.", menuKeyboard()); err != nil {
+		reportError("showing the menu", err)
+	}
+}
+
+const (
+	listDefault = 10
+	listMax     = 50
+)
+
+// showList implements /list [n]: the newest transactions of the ledger.
+func showList(chatID int64, args string) {
+	limit := listDefault
+	if arg := strings.TrimSpace(args); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			sendMessage(chatID, fmt.Sprintf("Usage: /list [count], e.g. /list 20 (at most %d)", listMax))
+			return
+		}
+		limit = min(n, listMax)
+	}
+	icons := typeIcons()
+	rows, err := db.Query("SELECT id, type, category, amount, description, created_at FROM transactions WHERE "+ledgerScope()+" ORDER BY created_at DESC, id DESC LIMIT ?", limit)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the transactions.")
+		reportError("listing transactions", err)
+		return
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var (
+			id          int64
+			typ         string
+			category    string
+			amount      Money
+			description sql.NullString
+			createdAt   string
+		)
+		if err := rows.Scan(&id, &typ, &category, &amount, &description, &createdAt); err != nil {
+			rows.Close()
+			sendMessage(chatID, "Failed to load the transactions.")
+			reportError("reading transactions", err)
+			return
+		}
+		line := fmt.Sprintf("%s · %s · %s%s %s %s", idHTML(id), formatCreatedAt(createdAt), icons[typ], escapeHTML(typ), escapeHTML(category), moneyHTML(amount))
+		if description.String != "" {
+			line += " · " + escapeHTML(description.String)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		sendMessage(chatID, "Failed to load the transactions.")
+		reportError("reading transactions", err)
+		return
+	}
+	if len(lines) == 0 {
+		sendMessage(chatID, "No transactions yet. Add one with /add, or send \"25000 lunch\".")
+		return
+	}
+	sendHTML(chatID, fmt.Sprintf("📋 %s\n\n%s\n\nSee one in full with /view &lt;id&gt;.", bold(fmt.Sprintf("The %d newest transaction(s)", len(lines))), strings.Join(lines, "\n")))
+}
+
+// showSettings implements /settings: the settings that can be changed
+// from chat, with the commands that change them.
+func showSettings(chatID int64) {
+	locale := getSetting("locale", currentConfig().Locale)
+	code := currencyCode()
+	if code == "" {
+		code = "none"
+	}
+	budget := "none"
+	if amount := getMoneySetting("monthly_budget", 0); amount > 0 {
+		budget = formatMoney(amount)
+	}
+	background, palette, _ := chartThemeSettings()
+	text := fmt.Sprintf("⚙️ Settings\n\nLanguage and number format: %s\nCurrency: %s (/ledger currency)\nTime zone: %s\nWeeks start on: %s (/weekstart)\nMonthly budget: %s (/budget)\nLedger: %s (/ledger)\nCharts: %s background, %s palette (/charts)",
+		locale, code, appLocation(), weekStartDay(), budget, ledgerName(activeLedgerID()), background, palette)
+	sendMessageWithKeyboard(chatID, text, buildKeyboard([][]InlineKeyboardButton{{
+		{Text: "⚙️ Run the setup again", CallbackData: "start:setup"},
+	}}))
+}
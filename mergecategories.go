@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	MERGING CATEGORIES (/mergecategories, owner only)
+
+	Two categories that turned out to mean the same thing can be merged:
+
+	/mergecategories Eating Out into Food
+
+	Every transaction, archived transaction, budget, allocation, rule,
+	bill, subscription, invoice and receipt draft of the first category
+	moves to the second, and the first is archived (categoryinfo.go). A
+	budget or allocation both categories had is added up. The bot first
+	shows how many rows each table will have changed and waits for the
+	confirmation code (confirm.go); the merge then runs in one database
+	transaction, so it happens entirely or not at all. Transactions in a
+	closed period move as well, which is why only the owner can merge.
+*/
+
+const mergeCategoriesUsage = "Usage: /mergecategories <from> into <to>\nMoves everything in the first category to the second, then archives the first."
+
+// mergeCategoryTables are the tables whose category column is renamed in
+// place; budgets and allocations, keyed by category, are added up instead.
+var mergeCategoryTables = []string{"transactions", "transactions_archive", "rules", "bills", "subscriptions", "invoices", "receipt_drafts"}
+
+// parseMergeCategories reads "<from> [into] <to>".
+func parseMergeCategories(args string) (string, string, error) {
+	fields := strings.Fields(args)
+	from, rest, ok := splitCategoryName(fields)
+	if !ok {
+		return "", "", fmt.Errorf("no category named %q", strings.Join(fields, " "))
+	}
+	if len(rest) > 0 && strings.EqualFold(rest[0], "into") {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return "", "", fmt.Errorf("missing the category to merge %s into", from)
+	}
+	to, extra, ok := splitCategoryName(rest)
+	if !ok || len(extra) > 0 {
+		return "", "", fmt.Errorf("no category named %q", strings.Join(rest, " "))
+	}
+	return from, to, nil
+}
+
+// mergeCategoryCounts returns how many rows of each table refer to the
+// category, leaving out the tables with none.
+func mergeCategoryCounts(category string) ([]string, int, error) {
+	var lines []string
+	total := 0
+	for _, table := range append([]string{"budgets", "allocations"}, mergeCategoryTables...) {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE category = ?", category).Scan(&n); err != nil {
+			return nil, 0, err
+		}
+		if n > 0 {
+			lines = append(lines, fmt.Sprintf("%d in %s", n, strings.ReplaceAll(table, "_", " ")))
+			total += n
+		}
+	}
+	return lines, total, nil
+}
+
+// mergeCategories moves everything in from to to and archives from, all
+// in one database transaction.
+func mergeCategories(from, to string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := appClock.Now().Format(dbTimeLayout)
+	if _, err := tx.Exec(`INSERT INTO budgets (category, amount, updated_at) SELECT ?, amount, ? FROM budgets WHERE category = ?
+		ON CONFLICT(category) DO UPDATE SET amount = amount + excluded.amount, updated_at = excluded.updated_at`, to, now, from); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO allocations (month, category, amount) SELECT month, ?, amount FROM allocations WHERE category = ?
+		ON CONFLICT(month, category) DO UPDATE SET amount = amount + excluded.amount`, to, from); err != nil {
+		return err
+	}
+	for _, table := range []string{"budgets", "allocations"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE category = ?", from); err != nil {
+			return err
+		}
+	}
+	for _, table := range mergeCategoryTables {
+		if _, err := tx.Exec("UPDATE "+table+" SET category = ? WHERE category = ?", to, from); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE categories SET archived = 1 WHERE name = ?", from); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// handleMergeCategoriesCommand previews the merge and asks for the
+// confirmation code before running it.
+func handleMergeCategoriesCommand(chatID int64, userID int64, args string) {
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+	if strings.TrimSpace(args) == "" {
+		sendMessage(chatID, mergeCategoriesUsage)
+		return
+	}
+	from, to, err := parseMergeCategories(args)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Cannot merge: %v.\n\n%s", err, mergeCategoriesUsage))
+		return
+	}
+	if from == to {
+		sendMessage(chatID, "Pick two different categories to merge.")
+		return
+	}
+	if categoryArchived(to) {
+		sendMessage(chatID, fmt.Sprintf("%s is archived. Bring it back with /categories unarchive %s before merging into it.", to, to))
+		return
+	}
+	counts, total, err := mergeCategoryCounts(from)
+	if err != nil {
+		sendMessage(chatID, "Failed to count what the merge would move.")
+		reportError("counting rows to merge", err)
+		return
+	}
+
+	preview := fmt.Sprintf("🔀 Merging %s into %s moves:\n", from, to)
+	if total == 0 {
+		preview += "nothing, " + from + " is not used anywhere"
+	} else {
+		preview += "• " + strings.Join(counts, "\n• ")
+	}
+	preview += fmt.Sprintf("\nthen archives %s.", from)
+	if cutoff := closedBefore(); cutoff != "" {
+		var closed int
+		if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE category = ? AND created_at < ?", from, cutoff).Scan(&closed); err != nil {
+			reportError("counting closed transactions to merge", err)
+		} else if closed > 0 {
+			preview += fmt.Sprintf("\n\n🔓 %d of the transactions are in the closed period (through %s).", closed, closedThrough(cutoff))
+		}
+	}
+	sendMessage(chatID, preview)
+
+	action := fmt.Sprintf("merge %s into %s (%d row(s))", from, to, total)
+	requireConfirmation(chatID, userID, action, func() {
+		if err := mergeCategories(from, to); err != nil {
+			sendMessage(chatID, "Failed to merge the categories, nothing was changed.")
+			reportError("merging categories", err)
+			return
+		}
+		refreshCategories()
+		transactionsChanged()
+		sendMessage(chatID, fmt.Sprintf("🔀 Merged %s into %s: %d row(s) moved and %s archived.", from, to, total, from))
+	})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+	MESSAGING TRANSPORT
+
+	Handlers talk to the user through the Messenger interface only, so the
+	same transaction flows can be driven by Telegram or by another frontend
+	(the CLI REPL below, and later other chat adapters). Incoming events are
+	still expressed as TGMessage / CallbackQuery values.
+*/
+
+// Messenger sends messages to a chat. Texts are HTML (html.go) and file
+// captions plain text. Methods return the id of the message they created
+// so it can be edited later.
+type Messenger interface {
+	SendText(chatID int64, text string) (int, error)
+	SendKeyboard(chatID int64, text string, keyboard InlineKeyboardMarkup) (int, error)
+	EditMessage(chatID int64, messageID int, text string, keyboard *InlineKeyboardMarkup) error
+	SendFile(chatID int64, path string, caption string) error
+	AnswerCallback(callbackID string, text string) error
+	PinMessage(chatID int64, messageID int, pin bool) error
+	// SendReplyKeyboard sends text with a reply keyboard, or removes the
+	// one shown when keyboard is nil.
+	SendReplyKeyboard(chatID int64, text string, keyboard *ReplyKeyboardMarkup) (int, error)
+}
+
+// messenger is the active transport; main sets it to Telegram or the REPL.
+var messenger Messenger
+
+// telegramMessenger sends through the Bot API client.
+type telegramMessenger struct {
+	client *BotClient
+}
+
+func (t *telegramMessenger) SendText(chatID int64, text string) (int, error) {
+	return t.sendText(chatID, text, nil)
+}
+
+func (t *telegramMessenger) SendKeyboard(chatID int64, text string, keyboard InlineKeyboardMarkup) (int, error) {
+	return t.sendText(chatID, text, keyboard)
+}
+
+// sendText sends text, split or attached when it is too long
+// (longmessages.go), with markup on the last message.
+func (t *telegramMessenger) sendText(chatID int64, text string, markup interface{}) (int, error) {
+	return deliverText(chatID, text, t.SendFile, func(part string, last bool) (int, error) {
+		var partMarkup interface{}
+		if last {
+			partMarkup = markup
+		}
+		msg, err := t.client.SendMessage(chatID, part, partMarkup)
+		if err != nil {
+			return 0, err
+		}
+		return msg.MessageID, nil
+	})
+}
+
+func (t *telegramMessenger) EditMessage(chatID int64, messageID int, text string, keyboard *InlineKeyboardMarkup) error {
+	var markup interface{}
+	if keyboard != nil {
+		markup = *keyboard
+	}
+	_, err := t.client.EditMessageText(chatID, messageID, fitEdit(text), markup)
+	return err
+}
+
+// SendFile sends images as photos so Telegram shows them inline, and
+// everything else as a document.
+func (t *telegramMessenger) SendFile(chatID int64, path string, caption string) error {
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg":
+		_, err = t.client.SendPhoto(chatID, path, caption)
+	default:
+		_, err = t.client.SendDocument(chatID, path, caption)
+	}
+	return err
+}
+
+func (t *telegramMessenger) AnswerCallback(callbackID string, text string) error {
+	return t.client.AnswerCallbackQuery(callbackID, text)
+}
+
+// PinMessage pins a message silently, or unpins it.
+func (t *telegramMessenger) PinMessage(chatID int64, messageID int, pin bool) error {
+	if pin {
+		return t.client.PinChatMessage(chatID, messageID)
+	}
+	return t.client.UnpinChatMessage(chatID, messageID)
+}
+
+func (t *telegramMessenger) SendReplyKeyboard(chatID int64, text string, keyboard *ReplyKeyboardMarkup) (int, error) {
+	var markup interface{} = ReplyKeyboardRemove{RemoveKeyboard: true}
+	if keyboard != nil {
+		markup = *keyboard
+	}
+	return t.sendText(chatID, text, markup)
+}
+
+// cliMessenger prints messages to a terminal. It remembers the buttons of
+// the last keyboard so the REPL can press them by number.
+type cliMessenger struct {
+	mu        sync.Mutex
+	out       io.Writer
+	nextID    int
+	lastMsgID int
+	buttons   []InlineKeyboardButton
+}
+
+func newCLIMessenger(out io.Writer) *cliMessenger {
+	return &cliMessenger{out: out}
+}
+
+// The CLI messenger splits long texts like Telegram, so the REPL shows
+// what the chat would, without the formatting.
+func (c *cliMessenger) SendText(chatID int64, text string) (int, error) {
+	return deliverText(chatID, text, c.SendFile, func(part string, last bool) (int, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.nextID++
+		fmt.Fprintf(c.out, "%s\n", htmlToPlain(part))
+		return c.nextID, nil
+	})
+}
+
+func (c *cliMessenger) SendKeyboard(chatID int64, text string, keyboard InlineKeyboardMarkup) (int, error) {
+	return deliverText(chatID, text, c.SendFile, func(part string, last bool) (int, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.nextID++
+		fmt.Fprintf(c.out, "%s\n", htmlToPlain(part))
+		if last {
+			c.printKeyboard(c.nextID, keyboard)
+		}
+		return c.nextID, nil
+	})
+}
+
+func (c *cliMessenger) EditMessage(chatID int64, messageID int, text string, keyboard *InlineKeyboardMarkup) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.out, "%s\n", htmlToPlain(fitEdit(text)))
+	if keyboard != nil {
+		c.printKeyboard(messageID, *keyboard)
+	} else if messageID == c.lastMsgID {
+		c.buttons = nil
+	}
+	return nil
+}
+
+func (c *cliMessenger) SendFile(chatID int64, path string, caption string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.out, "[file] %s\n", path)
+	if caption != "" {
+		fmt.Fprintf(c.out, "%s\n", caption)
+	}
+	return nil
+}
+
+func (c *cliMessenger) AnswerCallback(callbackID string, text string) error {
+	if text != "" {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		fmt.Fprintf(c.out, "(%s)\n", text)
+	}
+	return nil
+}
+
+func (c *cliMessenger) PinMessage(chatID int64, messageID int, pin bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pin {
+		fmt.Fprintf(c.out, "[pinned message %d]\n", messageID)
+	} else {
+		fmt.Fprintf(c.out, "[unpinned message %d]\n", messageID)
+	}
+	return nil
+}
+
+// SendReplyKeyboard prints the reply keyboard; its buttons are typed.
+func (c *cliMessenger) SendReplyKeyboard(chatID int64, text string, keyboard *ReplyKeyboardMarkup) (int, error) {
+	return deliverText(chatID, text, c.SendFile, func(part string, last bool) (int, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.nextID++
+		fmt.Fprintf(c.out, "%s\n", htmlToPlain(part))
+		if !last {
+			return c.nextID, nil
+		}
+		if keyboard == nil {
+			fmt.Fprintln(c.out, "  [keyboard removed]")
+			return c.nextID, nil
+		}
+		for _, row := range keyboard.Keyboard {
+			labels := []string{}
+			for _, button := range row {
+				labels = append(labels, "<"+button.Text+">")
+			}
+			fmt.Fprintf(c.out, "  %s\n", strings.Join(labels, "  "))
+		}
+		return c.nextID, nil
+	})
+}
+
+// printKeyboard lists the buttons numbered from 1; the caller holds c.mu.
+func (c *cliMessenger) printKeyboard(messageID int, keyboard InlineKeyboardMarkup) {
+	c.lastMsgID = messageID
+	c.buttons = nil
+	for _, row := range keyboard.InlineKeyboard {
+		labels := []string{}
+		for _, button := range row {
+			c.buttons = append(c.buttons, button)
+			labels = append(labels, fmt.Sprintf("[%d] %s", len(c.buttons), button.Text))
+		}
+		fmt.Fprintf(c.out, "  %s\n", strings.Join(labels, "  "))
+	}
+}
+
+// pressButton returns the callback data of button n of the last keyboard.
+func (c *cliMessenger) pressButton(n int) (string, int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n < 1 || n > len(c.buttons) {
+		return "", 0, false
+	}
+	return c.buttons[n-1].CallbackData, c.lastMsgID, true
+}
+
+// findButton returns the callback data of the button labelled label on
+// the last keyboard.
+func (c *cliMessenger) findButton(label string) (string, int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, button := range c.buttons {
+		if button.Text == label {
+			return button.CallbackData, c.lastMsgID, true
+		}
+	}
+	return "", 0, false
+}
+
+// runREPL drives the bot from a terminal as the owner: lines are sent as
+// messages, and "#N" presses button N of the last keyboard.
+func runREPL(in io.Reader, cli *cliMessenger) {
+	user := &TGUser{ID: ownerID(), FirstName: "cli"}
+	chat := &TGChat{ID: ownerID(), Type: "private"}
+
+	fmt.Fprintln(cli.out, "Ayunda REPL. Type commands like /add; #N presses button N; Ctrl-D quits.")
+	scanner := bufio.NewScanner(in)
+	callbackID := 0
+	for {
+		fmt.Fprint(cli.out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(cli.out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			n, err := strconv.Atoi(line[1:])
+			data, messageID, ok := cli.pressButton(n)
+			if err != nil || !ok {
+				fmt.Fprintln(cli.out, "No such button.")
+				continue
+			}
+			callbackID++
+			handleUpdate(Update{CallbackQuery: &CallbackQuery{
+				ID:      strconv.Itoa(callbackID),
+				From:    user,
+				Message: &TGMessage{MessageID: messageID, Chat: chat},
+				Data:    data,
+			}})
+			continue
+		}
+
+		handleUpdate(Update{Message: &TGMessage{From: user, Chat: chat, Text: line}})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+	MONEY
+
+	Amounts are kept as whole hundredths (minor units) in an int64, in
+	the database as in memory, so adding them up is exact: a month of
+	0.10 coffees sums to what the receipts say, not 0.30000000000000004.
+	Only ratios (percentages, averages, forecasts) go through float64,
+	with Float.
+
+	Money formats like a float, so "%.2f" and friends keep working, and
+	scans from the database accept both the integers it stores and the
+	floats SQL arithmetic returns (ROUND, AVG, multiplications), rounding
+	the latter to the nearest hundredth.
+
+	Databases from before this layout stored REAL major units; on startup
+	migrateMoney multiplies every money column by moneyScale once, and
+	records it in the money_version setting. Old columns keep their REAL
+	affinity, which stores the whole numbers exactly.
+
+	Portfolio quantities and prices are not money in this sense (prices
+	have more decimals, quantities are fractional) and stay REAL.
+*/
+
+// Money is an amount in minor units, e.g. 2550 for 25.50.
+type Money int64
+
+// moneyScale is the number of minor units in a major one.
+const moneyScale = 100
+
+// moneyFromFloat converts a major-unit amount, rounding to the nearest
+// minor unit.
+func moneyFromFloat(f float64) Money {
+	return Money(math.Round(f * moneyScale))
+}
+
+// Float returns the amount in major units, for ratios and charts.
+func (m Money) Float() float64 {
+	return float64(m) / moneyScale
+}
+
+// Abs returns the amount without its sign.
+func (m Money) Abs() Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Mul returns the amount multiplied by a factor, e.g. a quantity or a
+// rate, rounded to the nearest minor unit.
+func (m Money) Mul(factor float64) Money {
+	return Money(math.Round(float64(m) * factor))
+}
+
+// Div splits the amount in n equal parts, rounded to the nearest minor
+// unit, e.g. a monthly budget into a daily one.
+func (m Money) Div(n int) Money {
+	if n == 0 {
+		return 0
+	}
+	return Money(math.Round(float64(m) / float64(n)))
+}
+
+// String returns the amount with two decimals, e.g. "25.50".
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+	}
+	abs := m.Abs()
+	return fmt.Sprintf("%s%d.%02d", sign, int64(abs)/moneyScale, int64(abs)%moneyScale)
+}
+
+// Format lets the float verbs print the amount in major units, so
+// fmt.Sprintf("%.2f", m) prints "25.50".
+func (m Money) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'f', 'F', 'g', 'G', 'e', 'E':
+		format := "%"
+		for _, flag := range "+-# 0" {
+			if f.Flag(int(flag)) {
+				format += string(flag)
+			}
+		}
+		if w, ok := f.Width(); ok {
+			format += strconv.Itoa(w)
+		}
+		if p, ok := f.Precision(); ok {
+			format += "." + strconv.Itoa(p)
+		}
+		fmt.Fprintf(f, format+string(verb), m.Float())
+	default:
+		fmt.Fprint(f, m.String())
+	}
+}
+
+// parseMoney parses an amount in major units such as "25000", "25.5" or
+// "-3.10" exactly, without going through float64. More than two decimals
+// are rounded half away from zero.
+func parseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	invalid := fmt.Errorf("invalid amount %q", s)
+	negative := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		negative, s = true, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	whole, frac, hasDot := strings.Cut(s, ".")
+	if whole == "" && frac == "" || hasDot && frac == "" && whole == "" {
+		return 0, invalid
+	}
+	for _, part := range []string{whole, frac} {
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return 0, invalid
+			}
+		}
+	}
+	var units int64
+	if whole != "" {
+		n, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil || n > math.MaxInt64/moneyScale-1 {
+			return 0, invalid
+		}
+		units = n * moneyScale
+	}
+	for i, place := 0, int64(moneyScale/10); i < len(frac); i++ {
+		digit := int64(frac[i] - '0')
+		if place == 0 {
+			if digit >= 5 {
+				units++
+			}
+			break
+		}
+		units += digit * place
+		place /= 10
+	}
+	if negative {
+		units = -units
+	}
+	return Money(units), nil
+}
+
+// Set parses a command line flag, so Money is a flag.Value.
+func (m *Money) Set(s string) error {
+	parsed, err := parseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads a money column: an integer as stored, or a float produced
+// by SQL arithmetic on it, both in minor units.
+func (m *Money) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case float64:
+		*m = Money(math.Round(v))
+	case []byte:
+		return m.Scan(string(v))
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("money: cannot scan %q", v)
+		}
+		*m = Money(math.Round(f))
+	default:
+		return fmt.Errorf("money: cannot scan %T", value)
+	}
+	return nil
+}
+
+// Value stores the amount in minor units.
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// Plain returns the amount without trailing zeros, e.g. "25.5" or
+// "1000000", the way it would be typed.
+func (m Money) Plain() string {
+	if m%moneyScale == 0 {
+		return strconv.FormatInt(int64(m/moneyScale), 10)
+	}
+	return strings.TrimRight(m.String(), "0")
+}
+
+// MarshalJSON writes the amount in major units, e.g. 25.5.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.Plain()), nil
+}
+
+// UnmarshalJSON reads an amount in major units, exactly when it is
+// written in plain decimal notation.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "null" {
+		return nil
+	}
+	if parsed, err := parseMoney(text); err == nil {
+		*m = parsed
+		return nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("invalid amount %s", data)
+	}
+	*m = moneyFromFloat(f)
+	return nil
+}
+
+// NullMoney is a money column that may be NULL.
+type NullMoney struct {
+	Money Money
+	Valid bool
+}
+
+func (n *NullMoney) Scan(value interface{}) error {
+	if value == nil {
+		n.Money, n.Valid = 0, false
+		return nil
+	}
+	n.Valid = true
+	return n.Money.Scan(value)
+}
+
+func (n NullMoney) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return int64(n.Money), nil
+}
+
+// moneyColumns lists every column holding money, converted to minor
+// units by migrateMoney.
+var moneyColumns = []struct{ table, column string }{
+	{"transactions", "amount"},
+	{"transactions", "tax_amount"},
+	{"transactions_archive", "amount"},
+	{"transactions_archive", "tax_amount"},
+	{"transactions", "original_amount"},
+	{"transactions_archive", "original_amount"},
+	{"reconciliations", "statement_balance"},
+	{"reconciliations", "computed_balance"},
+	{"budgets", "amount"},
+	{"allocation_plans", "income"},
+	{"allocations", "amount"},
+	{"bills", "amount"},
+	{"invoices", "amount"},
+	{"subscriptions", "amount"},
+	{"savings_goals", "target"},
+	{"goal_contributions", "amount"},
+	{"receipt_drafts", "amount"},
+	{"split_expenses", "amount"},
+	{"split_shares", "share"},
+	{"split_settlements", "amount"},
+}
+
+// moneyVersion is recorded in the money_version setting once the money
+// columns hold minor units.
+const moneyVersion = "minor_units"
+
+// migrateMoney converts the money columns of a database from before the
+// minor units layout, once. It runs from initDB before the audit triggers
+// are created, so the conversion is not recorded as edits; the monthly
+// aggregates are rebuilt by initAggregates, whose version changed with it.
+func migrateMoney(db *sql.DB) error {
+	var version string
+	err := db.QueryRow("SELECT value FROM settings WHERE key = 'money_version'").Scan(&version)
+	if err == nil && version == moneyVersion {
+		return nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, trigger := range []string{"transactions_audit_update", "transactions_aggregate_update", "transactions_fx_amount_update"} {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+			return err
+		}
+	}
+	for _, c := range moneyColumns {
+		query := fmt.Sprintf("UPDATE %s SET %s = CAST(ROUND(%s * %d) AS INTEGER) WHERE %s IS NOT NULL", c.table, c.column, c.column, moneyScale, c.column)
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("converting %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	for _, column := range []string{"old_values", "new_values"} {
+		query := fmt.Sprintf("UPDATE transaction_audit SET %[1]s = json_set(%[1]s, '$.amount', CAST(ROUND(json_extract(%[1]s, '$.amount') * %[2]d) AS INTEGER)) WHERE json_extract(%[1]s, '$.amount') IS NOT NULL", column, moneyScale)
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("converting the audit history: %w", err)
+		}
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES ('money_version', ?)", moneyVersion); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
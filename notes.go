@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+	NOTES
+
+	Besides the short description, a transaction can carry longer notes
+	(warranty info, an order number, a link). They are set from the edit
+	flow and shown with the transaction.
+*/
+
+const maxNotesLength = 2000
+
+// transactionNotes returns the notes of transaction id, or "" if it has none.
+func transactionNotes(id int64) string {
+	var notes string
+	if err := queryRowCached("SELECT COALESCE(notes, '') FROM transactions WHERE id = ?", id).Scan(&notes); err != nil {
+		return ""
+	}
+	return notes
+}
+
+// notesLine formats notes for the transaction details, or "" without notes.
+func notesLine(notes string) string {
+	if notes == "" {
+		return ""
+	}
+	return "\nNotes: " + notes
+}
+
+// processEditNotes stores the notes typed during the edit flow; "-" clears them.
+func processEditNotes(message *TGMessage, state *TransactionState) {
+	notes := strings.TrimSpace(message.Text)
+	if utf8.RuneCountInString(notes) > maxNotesLength {
+		sendMessage(message.Chat.ID, fmt.Sprintf("Notes too long. Please keep them under %d characters.", maxNotesLength))
+		return
+	}
+	reply := fmt.Sprintf("Transaction %d updated: notes set.", state.EditID)
+	if notes == "-" {
+		notes = ""
+		reply = fmt.Sprintf("Transaction %d updated: notes cleared.", state.EditID)
+	}
+
+	if _, err := execCached("UPDATE transactions SET notes = NULLIF(?, '') WHERE id = ?", notes, state.EditID); err != nil {
+		reportError("updating transaction notes", err)
+		reply = "Failed to update transaction notes."
+	}
+	if state.PromptMessageID != 0 {
+		editMessage(message.Chat.ID, state.PromptMessageID, reply)
+	} else {
+		sendMessage(message.Chat.ID, reply)
+	}
+	delete(userStates, state.UserID)
+}
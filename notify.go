@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	NOTIFICATION PREFERENCES (/notify)
+
+	Each user can mute groups of notifications and set quiet hours:
+
+	/notify                         show the preferences
+	/notify reminders off           stop bill and subscription reminders
+	/notify digests on              end-of-day summaries and scheduled reports
+	/notify quiet 22:00-07:00       hold notifications during the night
+	/notify quiet off
+
+	Budget alerts come when a budget reaches 🟠 or goes over, or a category
+	goes over its allocation in the zero-based plan; anomalies are
+	alerts about unusual spending; receipts are the drafts of e-receipts
+	found in the mailbox (receipts.go). The outbox applies the preferences
+	right before sending: a muted notification is marked skipped, one
+	falling in quiet hours waits until they end. Error alerts and broadcasts belong to
+	no group and cannot be muted, but they do respect quiet hours.
+	Preferences are stored in the settings table under notify.<user id>.
+*/
+
+const notifyUsage = "Usage:\n/notify <group> on|off (groups: budgets, anomalies, digests, reminders, receipts)\n/notify quiet HH:MM-HH:MM|off"
+
+// notificationGroups maps each group to the outbox kinds it covers.
+var notificationGroups = map[string][]string{
+	"budgets":   {"budget_alert"},
+	"anomalies": {"anomaly"},
+	"digests":   {"end_of_day", "report", "roundup_summary"},
+	"reminders": {"bill_reminder", "subscription_alert"},
+	"receipts":  {"receipt_draft"},
+}
+
+// notificationGroup returns the group of an outbox kind, or "".
+func notificationGroup(kind string) string {
+	for group, kinds := range notificationGroups {
+		for _, k := range kinds {
+			if k == kind {
+				return group
+			}
+		}
+	}
+	return ""
+}
+
+func mutedGroups(userID int64) map[string]bool {
+	muted := make(map[string]bool)
+	for _, group := range strings.Split(getSetting(fmt.Sprintf("notify.%d.muted", userID), ""), ",") {
+		if group != "" {
+			muted[group] = true
+		}
+	}
+	return muted
+}
+
+// parseQuietHours parses "HH:MM-HH:MM" into minutes after midnight. The
+// window may wrap around midnight.
+func parseQuietHours(s string) (int, int, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid quiet hours %q, use HH:MM-HH:MM", s)
+	}
+	var minutes [2]int
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid time %q, use HH:MM", part)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return 0, 0, fmt.Errorf("quiet hours must start and end at different times")
+	}
+	return minutes[0], minutes[1], nil
+}
+
+// quietUntil returns when the user's quiet hours containing now end, or
+// the zero time when now is outside them.
+func quietUntil(userID int64, now time.Time) time.Time {
+	from, to, err := parseQuietHours(getSetting(fmt.Sprintf("notify.%d.quiet", userID), ""))
+	if err != nil {
+		return time.Time{}
+	}
+	minute := now.Hour()*60 + now.Minute()
+	inside := from <= minute && minute < to
+	if from > to {
+		inside = minute >= from || minute < to
+	}
+	if !inside {
+		return time.Time{}
+	}
+	end := time.Date(now.Year(), now.Month(), now.Day(), to/60, to%60, 0, 0, now.Location())
+	if !end.After(now) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// notificationDecision tells the outbox what to do with a notification of
+// kind for chatID: send it, skip it, or hold it until the returned time.
+func notificationDecision(chatID int64, kind string, now time.Time) (skip bool, holdUntil time.Time) {
+	if group := notificationGroup(kind); group != "" && mutedGroups(chatID)[group] {
+		return true, time.Time{}
+	}
+	return false, quietUntil(chatID, now)
+}
+
+func describeNotificationPrefs(userID int64) string {
+	muted := mutedGroups(userID)
+	groups := make([]string, 0, len(notificationGroups))
+	for group := range notificationGroups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	var sb strings.Builder
+	sb.WriteString("🔔 Notifications\n\n")
+	for _, group := range groups {
+		state := "on"
+		if muted[group] {
+			state = "off"
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s\n", group, state))
+	}
+	if quiet := getSetting(fmt.Sprintf("notify.%d.quiet", userID), ""); quiet != "" {
+		sb.WriteString(fmt.Sprintf("Quiet hours: %s\n", quiet))
+	} else {
+		sb.WriteString("Quiet hours: none\n")
+	}
+	sb.WriteString("\n" + notifyUsage)
+	return sb.String()
+}
+
+func handleNotifyCommand(chatID int64, userID int64, args string) {
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields) == 0 {
+		sendMessage(chatID, describeNotificationPrefs(userID))
+		return
+	}
+	if len(fields) != 2 {
+		sendMessage(chatID, notifyUsage)
+		return
+	}
+
+	if fields[0] == "quiet" {
+		value := fields[1]
+		if value == "off" {
+			value = ""
+		} else if _, _, err := parseQuietHours(value); err != nil {
+			sendMessage(chatID, fmt.Sprintf("%v.\n%s", err, notifyUsage))
+			return
+		}
+		if err := setSetting(fmt.Sprintf("notify.%d.quiet", userID), value); err != nil {
+			sendMessage(chatID, "Failed to update the notification preferences.")
+			reportError("setting quiet hours", err)
+			return
+		}
+		if value == "" {
+			sendMessage(chatID, "Quiet hours removed.")
+		} else {
+			sendMessage(chatID, fmt.Sprintf("Quiet hours set to %s; notifications wait until they end.", value))
+		}
+		return
+	}
+
+	group, state := fields[0], fields[1]
+	if _, ok := notificationGroups[group]; !ok || (state != "on" && state != "off") {
+		sendMessage(chatID, notifyUsage)
+		return
+	}
+	muted := mutedGroups(userID)
+	muted[group] = state == "off"
+	var list []string
+	for g, off := range muted {
+		if off {
+			list = append(list, g)
+		}
+	}
+	sort.Strings(list)
+	if err := setSetting(fmt.Sprintf("notify.%d.muted", userID), strings.Join(list, ",")); err != nil {
+		sendMessage(chatID, "Failed to update the notification preferences.")
+		reportError("muting notifications", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Notifications for %s turned %s.", group, state))
+}
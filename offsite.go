@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	OFF-SITE BACKUPS
+
+	With a bucket configured ([s3] in the config file or S3_BUCKET, and
+	the credentials in S3_ACCESS_KEY_ID / S3_SECRET_ACCESS_KEY), a daily
+	job uploads to S3-compatible storage, under the prefix:
+
+		snapshots/ayunda-YYYYMMDD.db   a copy of the database, as /backup
+		exports/ayunda-YYYYMMDD.json   the full data export, as /export_all
+
+	Both are sealed with BACKUP_KEY when it is set (".enc" is appended).
+	After a successful upload, objects under snapshots/ and exports/ older
+	than the retention (30 days by default) are deleted. This is in
+	addition to the /backup file sent in Telegram. "/backup offsite"
+	(owner only) runs the job right away.
+
+	A failed run is reported like any other error, with an alert to the
+	owner, and is not retried before the next day, like the maintenance
+	job.
+*/
+
+// offsiteFolders are the folders under the prefix the job writes and
+// prunes.
+var offsiteFolders = []string{"snapshots/", "exports/"}
+
+func offsiteConfigured() bool {
+	cfg := currentConfig()
+	return cfg != nil && cfg.S3Bucket != ""
+}
+
+// uploadOffsiteBackup uploads today's snapshot and export and prunes the
+// expired ones. It returns a summary of what it did.
+func uploadOffsiteBackup(now time.Time) (string, error) {
+	client := newS3Client(currentConfig())
+	backupKey := os.Getenv("BACKUP_KEY")
+	suffix := ""
+	if backupKey != "" {
+		suffix = encryptedBackupSuffix
+	}
+	stamp := now.Format("20060102")
+
+	dir, err := os.MkdirTemp(dataSubdir("backups"), "ayunda-offsite-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := filepath.Join(dir, "ayunda-"+stamp+".db"+suffix)
+	if err := backupDB(snapshot, backupKey); err != nil {
+		return "", fmt.Errorf("snapshot: %w", err)
+	}
+	export := filepath.Join(dir, "ayunda-"+stamp+".json")
+	if err := writeBundleFile(export); err != nil {
+		return "", fmt.Errorf("export: %w", err)
+	}
+	if backupKey != "" {
+		if err := encryptFile(export, export+suffix, backupKey); err != nil {
+			return "", fmt.Errorf("export: %w", err)
+		}
+		export += suffix
+	}
+
+	var uploaded []string
+	for i, path := range []string{snapshot, export} {
+		key := currentConfig().S3Prefix + offsiteFolders[i] + filepath.Base(path)
+		if err := client.putFile(key, path); err != nil {
+			return "", fmt.Errorf("upload: %w", err)
+		}
+		uploaded = append(uploaded, key)
+	}
+
+	cutoff := now.AddDate(0, 0, -currentConfig().S3Retention)
+	pruned := 0
+	for _, folder := range offsiteFolders {
+		objects, err := client.listObjects(currentConfig().S3Prefix + folder)
+		if err != nil {
+			return "", fmt.Errorf("retention: %w", err)
+		}
+		for _, o := range objects {
+			// never today's upload, whatever the storage clock says
+			if !o.LastModified.Before(cutoff) || o.Key == uploaded[0] || o.Key == uploaded[1] {
+				continue
+			}
+			if err := client.deleteObject(o.Key); err != nil {
+				return "", fmt.Errorf("retention: %w", err)
+			}
+			pruned++
+		}
+	}
+	log.Printf("offsite_backup bucket=%s uploaded=%q pruned=%d", currentConfig().S3Bucket, strings.Join(uploaded, ","), pruned)
+	return fmt.Sprintf("Uploaded %s to %s; %d backup(s) older than %d days deleted.", strings.Join(uploaded, " and "), currentConfig().S3Bucket, pruned, currentConfig().S3Retention), nil
+}
+
+// writeBundleFile writes the full data export to path.
+func writeBundleFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = writeBundle(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runOffsiteBackup is the scheduled job; it does nothing without a bucket.
+func runOffsiteBackup(now time.Time) error {
+	if !offsiteConfigured() {
+		return nil
+	}
+	if _, err := uploadOffsiteBackup(now); err != nil {
+		reportErrorTagged("uploading the off-site backup", err, map[string]string{"job": "offsite_backup"})
+	}
+	return nil
+}
+
+// sendOffsiteBackup runs the off-site backup for /backup offsite.
+func sendOffsiteBackup(chatID int64) {
+	if !offsiteConfigured() {
+		sendMessage(chatID, "No off-site storage configured. Set S3_BUCKET and the S3_ACCESS_KEY_ID / S3_SECRET_ACCESS_KEY credentials, or [s3] in the config file.")
+		return
+	}
+	summary, err := uploadOffsiteBackup(appClock.Now())
+	if err != nil {
+		sendMessage(chatID, "Off-site backup failed: "+err.Error())
+		log.Printf("Off-site backup failed: %v", err)
+		return
+	}
+	sendMessage(chatID, "☁️ "+summary)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+	ONBOARDING (/start)
+
+	The first /start walks through the settings that matter from day one,
+	one message with buttons per step, each one skippable:
+
+		1. language: sets the locale, i.e. how numbers are written (the
+		   bot itself speaks English for now)
+		2. currency of the active ledger (/ledger currency)
+		3. time zone, from the buttons or typed ("Asia/Jakarta")
+		4. starter categories to add to the defaults
+		5. a monthly budget (/budget)
+
+	and ends with a tour of the main commands. The locale and time zone
+	chosen here are settings of the instance and override the ones of the
+	configuration. Once done, /start shows the tour again with a button to
+	rerun the setup.
+*/
+
+// onboardingState is the setup in progress.
+type onboardingState struct {
+	Picked []string // starter categories selected so far
+}
+
+var onboardingLanguages = []struct{ key, label string }{
+	{"en-US", "English (US)"},
+	{"en-GB", "English (UK)"},
+	{"id-ID", "Bahasa Indonesia"},
+	{"ms-MY", "Bahasa Melayu"},
+	{"de-DE", "Deutsch"},
+	{"fr-FR", "Français"},
+}
+
+var onboardingCurrencies = []string{"IDR", "USD", "EUR", "SGD", "MYR", "GBP", "JPY", "AUD"}
+
+var onboardingTimezones = []struct{ key, label string }{
+	{"Asia/Jakarta", "Jakarta (WIB)"},
+	{"Asia/Makassar", "Makassar (WITA)"},
+	{"Asia/Jayapura", "Jayapura (WIT)"},
+	{"Asia/Singapore", "Singapore"},
+	{"Asia/Kuala_Lumpur", "Kuala Lumpur"},
+	{"Europe/London", "London"},
+	{"America/New_York", "New York"},
+	{"UTC", "UTC"},
+}
+
+// starterCategories are offered on top of the default categories.
+var starterCategories = []string{
+	"Groceries", "Health", "Shopping", "Entertainment", "Education",
+	"Travel", "Gifts", "Insurance", "Subscriptions", "Freelance", "Investments",
+}
+
+// onboardingSteps maps each button action to the step it belongs to.
+var onboardingSteps = map[string]string{
+	"lang": "START_LANGUAGE",
+	"cur":  "START_CURRENCY",
+	"tz":   "START_TIMEZONE",
+	"cat":  "START_CATEGORIES",
+	"cats": "START_CATEGORIES",
+}
+
+const onboardingTour = `Here is a quick tour:
+• /add records a transaction step by step, or just send "25000 lunch" ("+5000000 salary" for an income)
+• /list shows the newest transactions, /summary the month, /week the week, /report builds a custom report
+• /budget sets budgets, checked on every expense
+• /view <id>, /edit and /delete fix a transaction; every confirmation has Edit and Delete buttons
+• /ledger keeps separate ledgers, e.g. personal and freelance
+• /export sends your data as a spreadsheet or for another finance app
+• /menu shows a keyboard with the main actions, to tap instead of typing
+
+Send /start again to change these settings.`
+
+// handleStartCommand implements /start: the setup on first contact, the
+// tour afterwards.
+func handleStartCommand(chatID int64, userID int64) {
+	if getBoolSetting("onboarded", false) {
+		sendMessageWithKeyboard(chatID, "👋 Welcome back!\n\n"+onboardingTour, buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "⚙️ Run the setup again", CallbackData: "start:setup"},
+		}}))
+		return
+	}
+	startOnboarding(chatID, userID, 0)
+}
+
+// startOnboarding shows the first step, in a new message or in place of
+// messageID.
+func startOnboarding(chatID int64, userID int64, messageID int) {
+	userStates[userID] = &TransactionState{UserID: userID, Step: "START_LANGUAGE", Onboarding: &onboardingState{}}
+	var rows [][]InlineKeyboardButton
+	for i := 0; i < len(onboardingLanguages); i += 2 {
+		rows = append(rows, []InlineKeyboardButton{
+			{Text: onboardingLanguages[i].label, CallbackData: "start:lang:" + onboardingLanguages[i].key},
+			{Text: onboardingLanguages[i+1].label, CallbackData: "start:lang:" + onboardingLanguages[i+1].key},
+		})
+	}
+	rows = append(rows, onboardingSkipRow())
+	text := "👋 Welcome to Ayunda! A few questions to set things up, each one can be skipped.\n\n1/5 Language: it sets how numbers are written (messages are in English for now)."
+	showOnboardingStep(chatID, messageID, text, buildKeyboard(rows))
+}
+
+// showOnboardingStep shows a step in place of messageID, or in a new
+// message after a typed answer (messageID 0).
+func showOnboardingStep(chatID int64, messageID int, text string, keyboard InlineKeyboardMarkup) {
+	if messageID == 0 {
+		sendMessageWithKeyboard(chatID, text, keyboard)
+		return
+	}
+	editMessageWithKeyboard(chatID, messageID, text, keyboard)
+}
+
+func onboardingSkipRow() []InlineKeyboardButton {
+	return []InlineKeyboardButton{
+		{Text: "Skip ▶", CallbackData: "start:skip"},
+		{Text: "Cancel", CallbackData: "start:cancel"},
+	}
+}
+
+func showOnboardingCurrency(chatID int64, messageID int) {
+	var rows [][]InlineKeyboardButton
+	for i := 0; i < len(onboardingCurrencies); i += 4 {
+		var row []InlineKeyboardButton
+		for _, code := range onboardingCurrencies[i:min(i+4, len(onboardingCurrencies))] {
+			row = append(row, InlineKeyboardButton{Text: code, CallbackData: "start:cur:" + code})
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, onboardingSkipRow())
+	showOnboardingStep(chatID, messageID, fmt.Sprintf("2/5 Currency of the %q ledger, or type its ISO code (e.g. THB):", ledgerName(activeLedgerID())), buildKeyboard(rows))
+}
+
+func showOnboardingTimezone(chatID int64, messageID int) {
+	var rows [][]InlineKeyboardButton
+	for i := 0; i < len(onboardingTimezones); i += 2 {
+		rows = append(rows, []InlineKeyboardButton{
+			{Text: onboardingTimezones[i].label, CallbackData: "start:tz:" + onboardingTimezones[i].key},
+			{Text: onboardingTimezones[i+1].label, CallbackData: "start:tz:" + onboardingTimezones[i+1].key},
+		})
+	}
+	rows = append(rows, onboardingSkipRow())
+	showOnboardingStep(chatID, messageID, fmt.Sprintf("3/5 Time zone, now %s. Choose one or type its name (e.g. Asia/Tokyo):", appLocation()), buildKeyboard(rows))
+}
+
+// missingStarterCategories returns the starter categories not created yet.
+func missingStarterCategories() []string {
+	var missing []string
+	for _, name := range starterCategories {
+		if _, ok := findCategory(name); !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func onboardingCategoriesKeyboard(state *onboardingState) InlineKeyboardMarkup {
+	var rows [][]InlineKeyboardButton
+	var row []InlineKeyboardButton
+	for _, name := range missingStarterCategories() {
+		mark := "⬜"
+		if slices.Contains(state.Picked, name) {
+			mark = "✅"
+		}
+		row = append(row, InlineKeyboardButton{Text: mark + " " + name, CallbackData: "start:cat:" + name})
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, []InlineKeyboardButton{
+		{Text: "Next ▶", CallbackData: "start:cats:done"},
+		{Text: "Cancel", CallbackData: "start:cancel"},
+	})
+	return buildKeyboard(rows)
+}
+
+// showOnboardingCategories shows the starter categories, or moves on to
+// the budget when they all exist.
+func showOnboardingCategories(chatID int64, messageID int, state *TransactionState) {
+	if len(missingStarterCategories()) == 0 {
+		showOnboardingBudget(chatID, messageID, state)
+		return
+	}
+	state.Step = "START_CATEGORIES"
+	showOnboardingStep(chatID, messageID, onboardingCategoriesPrompt(), onboardingCategoriesKeyboard(state.Onboarding))
+}
+
+func onboardingCategoriesPrompt() string {
+	return fmt.Sprintf("4/5 Categories: you have %s.\nPick the ones to add:", strings.Join(activeCategories(), ", "))
+}
+
+func showOnboardingBudget(chatID int64, messageID int, state *TransactionState) {
+	state.Step = "START_BUDGET"
+	showOnboardingStep(chatID, messageID, "5/5 Monthly budget: send the amount you plan to spend per month (e.g. 5000000), or skip.",
+		buildKeyboard([][]InlineKeyboardButton{onboardingSkipRow()}))
+}
+
+// finishOnboarding ends the setup with the tour.
+func finishOnboarding(chatID int64, state *TransactionState) {
+	delete(userStates, state.UserID)
+	if err := setSetting("onboarded", "true"); err != nil {
+		reportError("finishing the onboarding", err)
+	}
+	sendMessage(chatID, "🎉 All set!\n\n"+onboardingTour)
+}
+
+// handleOnboardingCallback handles the setup buttons: start:<action>[:<value>].
+func handleOnboardingCallback(callback *CallbackQuery) {
+	action, value, _ := strings.Cut(strings.TrimPrefix(callback.Data, "start:"), ":")
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	if action == "setup" {
+		_ = messenger.AnswerCallback(callback.ID, "")
+		startOnboarding(chatID, callback.From.ID, messageID)
+		return
+	}
+	state, exists := userStates[callback.From.ID]
+	if !exists || state.Onboarding == nil || (action != "cancel" && action != "skip" && onboardingSteps[action] != state.Step) {
+		_ = messenger.AnswerCallback(callback.ID, "This setup has expired. Start again with /start.")
+		return
+	}
+	if isMaintenanceMode() && action != "cancel" {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	switch action {
+	case "cancel":
+		delete(userStates, state.UserID)
+		editMessage(chatID, messageID, "Setup canceled. Send /start to run it again.")
+	case "skip":
+		advanceOnboarding(chatID, messageID, state)
+	case "lang":
+		if !hasOption(onboardingLanguages, value) {
+			return
+		}
+		if err := setSetting("locale", value); err != nil {
+			reportError("setting the locale", err)
+		}
+		refreshCurrency()
+		advanceOnboarding(chatID, messageID, state)
+	case "cur":
+		if !slices.Contains(onboardingCurrencies, value) {
+			return
+		}
+		if err := updateLedgerCurrency(activeLedgerID(), value); err != nil {
+			reportError("setting the ledger currency", err)
+		}
+		advanceOnboarding(chatID, messageID, state)
+	case "tz":
+		if !hasOption(onboardingTimezones, value) {
+			return
+		}
+		if err := setTimezone(value); err != nil {
+			reportError("setting the time zone", err)
+		}
+		advanceOnboarding(chatID, messageID, state)
+	case "cat":
+		if !slices.Contains(starterCategories, value) {
+			return
+		}
+		picked := state.Onboarding.Picked
+		if slices.Contains(picked, value) {
+			kept := picked[:0]
+			for _, name := range picked {
+				if name != value {
+					kept = append(kept, name)
+				}
+			}
+			state.Onboarding.Picked = kept
+		} else {
+			state.Onboarding.Picked = append(picked, value)
+		}
+		editMessageWithKeyboard(chatID, messageID, onboardingCategoriesPrompt(), onboardingCategoriesKeyboard(state.Onboarding))
+	case "cats":
+		if err := addCategories(state.Onboarding.Picked); err != nil {
+			reportError("adding the starter categories", err)
+		}
+		showOnboardingBudget(chatID, messageID, state)
+	}
+}
+
+// advanceOnboarding moves to the step after the current one.
+func advanceOnboarding(chatID int64, messageID int, state *TransactionState) {
+	switch state.Step {
+	case "START_LANGUAGE":
+		state.Step = "START_CURRENCY"
+		showOnboardingCurrency(chatID, messageID)
+	case "START_CURRENCY":
+		state.Step = "START_TIMEZONE"
+		showOnboardingTimezone(chatID, messageID)
+	case "START_TIMEZONE":
+		showOnboardingCategories(chatID, messageID, state)
+	case "START_CATEGORIES":
+		showOnboardingBudget(chatID, messageID, state)
+	case "START_BUDGET":
+		editMessage(chatID, messageID, "5/5 Monthly budget: skipped.")
+		finishOnboarding(chatID, state)
+	}
+}
+
+// processOnboardingText takes a typed currency, time zone or budget.
+func processOnboardingText(message *TGMessage, state *TransactionState) {
+	chatID := message.Chat.ID
+	text := strings.TrimSpace(message.Text)
+	switch state.Step {
+	case "START_CURRENCY":
+		code := strings.ToUpper(text)
+		if !currencyCodePattern.MatchString(code) {
+			sendMessage(chatID, "Type an ISO 4217 currency code such as IDR or USD, or choose a button.")
+			return
+		}
+		if err := updateLedgerCurrency(activeLedgerID(), code); err != nil {
+			sendMessage(chatID, "Failed to set the currency.")
+			reportError("setting the ledger currency", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Currency set to %s.", code))
+		state.Step = "START_TIMEZONE"
+		showOnboardingTimezone(chatID, 0)
+	case "START_TIMEZONE":
+		if err := setTimezone(text); err != nil {
+			sendMessage(chatID, fmt.Sprintf("Unknown time zone %q. Type a name like Asia/Jakarta, or choose a button.", text))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Time zone set to %s.", appLocation()))
+		showOnboardingCategories(chatID, 0, state)
+	case "START_BUDGET":
+		amount, err := parseMoney(text)
+		if err != nil || amount <= 0 {
+			sendMessage(chatID, "Invalid amount. Send the monthly budget as a number, e.g. 5000000, or skip.")
+			return
+		}
+		if err := setSetting("monthly_budget", amount.String()); err != nil {
+			sendMessage(chatID, "Failed to set the budget.")
+			reportError("setting the monthly budget", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Monthly budget set to %s.", formatMoney(amount)))
+		finishOnboarding(chatID, state)
+	default:
+		sendMessage(chatID, "Choose one of the buttons above, or cancel the setup.")
+	}
+}
+
+// setTimezone stores the time zone of the instance and applies it.
+func setTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" || name == "Local" {
+		return fmt.Errorf("unknown time zone %q", name)
+	}
+	if err := setSetting("timezone", name); err != nil {
+		return err
+	}
+	setAppLocation(loc)
+	return nil
+}
+
+// addCategories creates the named categories, keeping the existing ones.
+func addCategories(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, name := range names {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO categories (name) VALUES (?)", name); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	refreshCategories()
+	return nil
+}
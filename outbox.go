@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+/*
+	OUTBOX: reliable delivery of notifications.
+
+	Scheduled digests and alerts are written to the outbox table instead of
+	being sent directly. A sender goroutine delivers pending rows with retries
+	and marks them sent, so a Telegram outage or a restart neither drops nor
+	duplicates them. Rows with a dedupe_key are only ever queued once.
+	Muted kinds are marked skipped and quiet hours postpone delivery
+	(notify.go).
+*/
+
+const (
+	outboxPollInterval = 5 * time.Second
+	outboxBatchSize    = 20
+	outboxMaxAttempts  = 10
+	outboxMaxBackoff   = time.Hour
+)
+
+// outboxWake nudges the sender after something is queued.
+var outboxWake = make(chan struct{}, 1)
+
+type outboxMessage struct {
+	ID          int64
+	ChatID      int64
+	Kind        string
+	Text        string
+	ReplyMarkup sql.NullString
+	Attempts    int
+}
+
+// enqueueNotification stores a plain text message for delivery by the
+// outbox sender. When dedupeKey is not empty, a message with the same key is
+// queued at most once, which makes scheduled jobs safe to re-run after a
+// restart.
+func enqueueNotification(chatID int64, kind string, dedupeKey string, text string, replyMarkup interface{}) error {
+	return enqueueHTMLNotification(chatID, kind, dedupeKey, escapeHTML(text), replyMarkup)
+}
+
+// enqueueHTMLNotification is enqueueNotification for a formatted message
+// (html.go); the outbox keeps the HTML the messenger takes.
+func enqueueHTMLNotification(chatID int64, kind string, dedupeKey string, text string, replyMarkup interface{}) error {
+	var markup sql.NullString
+	if replyMarkup != nil {
+		data, err := json.Marshal(replyMarkup)
+		if err != nil {
+			return err
+		}
+		markup = sql.NullString{String: string(data), Valid: true}
+	}
+	var key sql.NullString
+	if dedupeKey != "" {
+		key = sql.NullString{String: dedupeKey, Valid: true}
+	}
+
+	_, err := db.Exec(`INSERT OR IGNORE INTO outbox (chat_id, kind, dedupe_key, text, reply_markup) VALUES (?, ?, ?, ?, ?)`,
+		chatID, kind, key, text, markup)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runOutbox delivers pending outbox messages until the process exits.
+func runOutbox() {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		deliverOutbox()
+		select {
+		case <-ticker.C:
+		case <-outboxWake:
+		}
+	}
+}
+
+func pendingOutbox() ([]outboxMessage, error) {
+	rows, err := db.Query(`SELECT id, chat_id, kind, text, reply_markup, attempts FROM outbox
+		WHERE status = 'pending' AND next_attempt_at <= ? ORDER BY id LIMIT ?`,
+		time.Now().UTC().Format("2006-01-02 15:04:05"), outboxBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []outboxMessage
+	for rows.Next() {
+		var m outboxMessage
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.Kind, &m.Text, &m.ReplyMarkup, &m.Attempts); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+func deliverOutbox() {
+	messages, err := pendingOutbox()
+	if err != nil {
+		log.Printf("Failed to load outbox: %v", err)
+		return
+	}
+	now := appClock.Now()
+	for _, m := range messages {
+		skip, holdUntil := notificationDecision(m.ChatID, m.Kind, now)
+		if skip {
+			if _, err := db.Exec("UPDATE outbox SET status = 'skipped' WHERE id = ?", m.ID); err != nil {
+				log.Printf("Failed to skip outbox message %d: %v", m.ID, err)
+			}
+			continue
+		}
+		if !holdUntil.IsZero() {
+			if _, err := db.Exec("UPDATE outbox SET next_attempt_at = ? WHERE id = ?", holdUntil.UTC().Format("2006-01-02 15:04:05"), m.ID); err != nil {
+				log.Printf("Failed to hold outbox message %d: %v", m.ID, err)
+			}
+			continue
+		}
+
+		var sendErr error
+		if m.ReplyMarkup.Valid {
+			var keyboard InlineKeyboardMarkup
+			if sendErr = json.Unmarshal([]byte(m.ReplyMarkup.String), &keyboard); sendErr == nil {
+				_, sendErr = messenger.SendKeyboard(m.ChatID, m.Text, keyboard)
+			}
+		} else {
+			_, sendErr = messenger.SendText(m.ChatID, m.Text)
+		}
+		if sendErr == nil {
+			if _, err := db.Exec("UPDATE outbox SET status = 'sent', sent_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = NULL WHERE id = ?", m.ID); err != nil {
+				log.Printf("Failed to mark outbox message %d as sent: %v", m.ID, err)
+			}
+			continue
+		}
+
+		attempts := m.Attempts + 1
+		status := "pending"
+		if !isRetryable(sendErr) || attempts >= outboxMaxAttempts {
+			status = "failed"
+			log.Printf("Giving up on outbox message %d (%s) to %d: %v", m.ID, m.Kind, m.ChatID, sendErr)
+		} else {
+			log.Printf("Outbox message %d (%s) to %d failed, will retry: %v", m.ID, m.Kind, m.ChatID, sendErr)
+		}
+		next := time.Now().UTC().Add(outboxBackoff(attempts, sendErr))
+		if _, err := db.Exec("UPDATE outbox SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?",
+			status, attempts, next.Format("2006-01-02 15:04:05"), sendErr.Error(), m.ID); err != nil {
+			log.Printf("Failed to update outbox message %d: %v", m.ID, err)
+		}
+	}
+}
+
+// outboxBackoff grows the delay between delivery attempts, on top of the
+// retries the client already did for this attempt.
+func outboxBackoff(attempts int, err error) time.Duration {
+	if apiErr, ok := err.(*APIError); ok && apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second
+	}
+	delay := 30 * time.Second << (attempts - 1)
+	if delay > outboxMaxBackoff || delay <= 0 {
+		delay = outboxMaxBackoff
+	}
+	return delay
+}
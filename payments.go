@@ -0,0 +1,298 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	PAYMENT METHODS AND THE WALLET (/payment, /payments, /wallet)
+
+	A transaction can record how it was paid: cash, card, transfer,
+	e-wallet or any other name. There are no separate accounts; the method
+	is the payment_method column, set per transaction or, for everything
+	added in the chat from now on, through a default:
+
+	/payment 42 card            transaction #42 was paid by card
+	/payment default cash       new transactions are paid in cash
+	/payments [period]          spending by method, cash vs non-cash
+
+	/wallet tracks the physical cash: "/wallet set 150000" records what is
+	in the wallet now, "/wallet withdraw 500000" adds cash taken out of an
+	ATM, and from then on cash income adds to it and cash expenses take
+	from it. The wallet is one for the instance, across ledgers. A
+	computed balance below zero means a cash expense was recorded that the
+	wallet never held, usually a withdrawal or income not logged, and
+	saving such an expense warns about it.
+*/
+
+const paymentUsage = "Usage:\n/payment <id> <method> - set how a transaction was paid, e.g. cash or card\n/payment <id> off - clear it\n/payment default <method|off> - method of new transactions\n/payments [YYYY-MM | YYYY | YYYY-MM-DD YYYY-MM-DD] - spending by method"
+
+const walletUsage = "Usage:\n/wallet - show the cash in the wallet\n/wallet set <amount> - record what the wallet holds now\n/wallet withdraw <amount> - add cash taken out of an ATM"
+
+// cashMethod is the payment method of physical cash.
+const cashMethod = "cash"
+
+// normalizePaymentMethod lower-cases a method name; ok is false when it is
+// not a usable name.
+func normalizePaymentMethod(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || len([]rune(s)) > 20 || strings.ContainsAny(s, " \n") {
+		return "", false
+	}
+	return s, true
+}
+
+// defaultPaymentMethod returns the method given to new transactions, or "".
+func defaultPaymentMethod() string {
+	return getSetting("payment_default", "")
+}
+
+func handlePaymentCommand(chatID int64, userID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		current := defaultPaymentMethod()
+		if current == "" {
+			current = "none"
+		}
+		sendMessage(chatID, fmt.Sprintf("Default payment method: %s.\n\n%s", current, paymentUsage))
+		return
+	}
+	if len(fields) != 2 {
+		sendMessage(chatID, paymentUsage)
+		return
+	}
+
+	if strings.EqualFold(fields[0], "default") {
+		value := ""
+		if !strings.EqualFold(fields[1], "off") {
+			method, ok := normalizePaymentMethod(fields[1])
+			if !ok {
+				sendMessage(chatID, "A payment method is one word of at most 20 characters.")
+				return
+			}
+			value = method
+		}
+		if err := setSetting("payment_default", value); err != nil {
+			sendMessage(chatID, "Failed to save the default payment method.")
+			reportError("setting the default payment method", err)
+			return
+		}
+		if value == "" {
+			sendMessage(chatID, "New transactions no longer get a payment method.")
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("New transactions are now paid by %s. Change one with /payment <id> <method>.", value))
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(fields[0], "#"), 10, 64)
+	if err != nil || id <= 0 {
+		sendMessage(chatID, paymentUsage)
+		return
+	}
+	var method sql.NullString
+	if !strings.EqualFold(fields[1], "off") {
+		value, ok := normalizePaymentMethod(fields[1])
+		if !ok {
+			sendMessage(chatID, "A payment method is one word of at most 20 characters.")
+			return
+		}
+		method = sql.NullString{String: value, Valid: true}
+	}
+
+	var typ, createdAt string
+	err = db.QueryRow("SELECT type, created_at FROM transactions WHERE id = ? AND "+ledgerScope(), id).Scan(&typ, &createdAt)
+	if err == sql.ErrNoRows {
+		sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+	if !allowChange(chatID, userID, id, createdAt) {
+		return
+	}
+	if _, err := db.Exec("UPDATE transactions SET payment_method = ? WHERE id = ?", method, id); err != nil {
+		sendMessage(chatID, "Failed to update the transaction.")
+		reportError("setting a payment method", err)
+		return
+	}
+	reply := fmt.Sprintf("Transaction #%d: payment method cleared.", id)
+	if method.Valid {
+		reply = fmt.Sprintf("💳 Transaction #%d paid by %s.", id, method.String)
+	}
+	if typ == "expense" {
+		if warning := walletWarning(); warning != "" {
+			reply += "\n\n" + warning
+		}
+	}
+	sendMessage(chatID, reply)
+}
+
+// paymentMethodLine formats the payment method of transaction id for its
+// details, or "" when it has none.
+func paymentMethodLine(id int64) string {
+	var method sql.NullString
+	if err := db.QueryRow("SELECT payment_method FROM transactions WHERE id = ?", id).Scan(&method); err != nil || !method.Valid {
+		return ""
+	}
+	return "Paid by: " + method.String
+}
+
+func showPaymentMethods(chatID int64, args string) {
+	from, to, label, err := parsePeriod(args, appClock.Now())
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v. %s", err, paymentUsage))
+		return
+	}
+	rows, err := db.Query(`SELECT COALESCE(payment_method, ''), SUM(`+lineTotal+`), COUNT(*) FROM transactions
+		WHERE type = 'expense' AND created_at >= ? AND created_at < ? AND `+ledgerScope()+`
+		GROUP BY 1`, from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		sendMessage(chatID, "Failed to build the payment report.")
+		reportError("loading spending by payment method", err)
+		return
+	}
+	type methodTotal struct {
+		Method string
+		Total  Money
+		Count  int
+	}
+	var totals []methodTotal
+	var cash, nonCash, unknown Money
+	for rows.Next() {
+		var m methodTotal
+		if err := rows.Scan(&m.Method, &m.Total, &m.Count); err != nil {
+			rows.Close()
+			sendMessage(chatID, "Failed to build the payment report.")
+			reportError("loading spending by payment method", err)
+			return
+		}
+		switch m.Method {
+		case "":
+			unknown += m.Total
+		case cashMethod:
+			cash += m.Total
+		default:
+			nonCash += m.Total
+		}
+		totals = append(totals, m)
+	}
+	rows.Close()
+	if len(totals) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No expenses in %s.", label))
+		return
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Total > totals[j].Total })
+
+	spent := cash + nonCash + unknown
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("💳 Spending by payment method, %s\n\n", label))
+	for _, m := range totals {
+		name := m.Method
+		if name == "" {
+			name = "not recorded"
+		}
+		sb.WriteString(fmt.Sprintf("• %s: %s (%.0f%%, %d transaction(s))\n", name, formatMoney(m.Total), percentOf(m.Total.Float(), spent.Float()), m.Count))
+	}
+	sb.WriteString(fmt.Sprintf("\nCash: %s (%.0f%%)\nNon-cash: %s (%.0f%%)\n", formatMoney(cash), percentOf(cash.Float(), spent.Float()), formatMoney(nonCash), percentOf(nonCash.Float(), spent.Float())))
+	if unknown > 0 {
+		sb.WriteString(fmt.Sprintf("Not recorded: %s. Set it with /payment <id> <method>, or /payment default for new ones.\n", formatMoney(unknown)))
+	}
+	sendMessage(chatID, strings.TrimSpace(sb.String()))
+}
+
+// walletCash computes the cash in the wallet; ok is false until the
+// wallet has been set.
+func walletCash() (cash Money, since time.Time, ok bool, err error) {
+	asOf := getSetting("wallet_as_of", "")
+	if asOf == "" {
+		return 0, time.Time{}, false, nil
+	}
+	since, err = time.ParseInLocation(dbTimeLayout, asOf, appLocation())
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+	cash = getMoneySetting("wallet_balance", 0)
+	var flow Money
+	err = db.QueryRow(`SELECT COALESCE(SUM(CASE WHEN type = 'income' THEN `+lineTotal+` ELSE -`+lineTotal+` END), 0) FROM transactions
+		WHERE payment_method = ? AND created_at >= ?`, cashMethod, asOf).Scan(&flow)
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+	return cash + flow, since, true, nil
+}
+
+// walletWarning returns a warning when the computed wallet cash is below
+// zero, or "".
+func walletWarning() string {
+	cash, _, ok, err := walletCash()
+	if err != nil {
+		reportError("computing the wallet cash", err)
+		return ""
+	}
+	if !ok || cash >= 0 {
+		return ""
+	}
+	return fmt.Sprintf("⚠️ The wallet would hold %s in cash. A withdrawal or cash income may not be logged: /wallet withdraw <amount>, or /wallet set <amount> after counting it.", formatMoney(cash))
+}
+
+func handleWalletCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		cash, since, ok, err := walletCash()
+		if err != nil {
+			sendMessage(chatID, "Failed to compute the wallet cash.")
+			reportError("computing the wallet cash", err)
+			return
+		}
+		if !ok {
+			sendMessage(chatID, "The wallet is not tracked yet. Count your cash and send /wallet set <amount>.\n\n"+walletUsage)
+			return
+		}
+		text := fmt.Sprintf("👛 Wallet: %s in cash (counted %s, then cash transactions since).", formatMoney(cash), since.Format("2 Jan 2006 15:04"))
+		if cash < 0 {
+			text += "\n\n" + walletWarning()
+		}
+		sendMessage(chatID, text)
+	case len(fields) == 2 && (strings.EqualFold(fields[0], "set") || strings.EqualFold(fields[0], "withdraw")):
+		amount, err := parseMoney(fields[1])
+		if err != nil || amount < 0 || (amount == 0 && strings.EqualFold(fields[0], "withdraw")) {
+			sendMessage(chatID, "Invalid amount.\n\n"+walletUsage)
+			return
+		}
+		if strings.EqualFold(fields[0], "withdraw") {
+			cash, _, ok, err := walletCash()
+			if err != nil {
+				sendMessage(chatID, "Failed to compute the wallet cash.")
+				reportError("computing the wallet cash", err)
+				return
+			}
+			if !ok {
+				sendMessage(chatID, "Count your cash and send /wallet set <amount> first.")
+				return
+			}
+			amount += cash
+		}
+		err = setSetting("wallet_balance", amount.String())
+		if err == nil {
+			err = setSetting("wallet_as_of", appClock.Now().Format(dbTimeLayout))
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to save the wallet.")
+			reportError("saving the wallet", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("👛 Wallet: %s in cash.", formatMoney(amount)))
+	default:
+		sendMessage(chatID, walletUsage)
+	}
+}
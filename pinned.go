@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	PINNED SUMMARY (/pin on|off)
+
+	A "Month to date" message pinned in the chat, edited as transactions
+	come in: income, expenses and balance of the month, and a bar for the
+	monthly budget and every category budget. It is refreshed right after a
+	transaction is added, at most every pinnedSummaryInterval, and checked
+	by the scheduler every minute to catch other changes and the start of a
+	new month. Nothing is edited while the text stays the same.
+*/
+
+const pinnedSummaryInterval = 10 * time.Second
+
+var pinnedSummary struct {
+	sync.Mutex
+	text    string
+	updated time.Time
+}
+
+func pinnedSummaryTarget() (int64, int) {
+	chatID, _ := strconv.ParseInt(getSetting("pinned_summary.chat_id", ""), 10, 64)
+	messageID, _ := strconv.Atoi(getSetting("pinned_summary.message_id", ""))
+	return chatID, messageID
+}
+
+func budgetBarLine(name string, spent, budget Money) string {
+	return fmt.Sprintf("%s\n%s (%s/%s)", name, budgetProgress(float64(spent)/float64(budget)), formatMoney(spent), formatMoney(budget))
+}
+
+// pinnedSummaryText builds the month-to-date message for now's month, in
+// HTML.
+func pinnedSummaryText(now time.Time) (string, error) {
+	income, expense, err := monthTotals(now)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString("📌 " + bold("Month to date, "+now.Format("January 2006")) + "\n\n")
+	sb.WriteString(fmt.Sprintf("Income: %s\nExpenses: %s\nBalance: %s\n", moneyHTML(income), moneyHTML(expense), bold(formatMoney(income-expense))))
+
+	budgets, err := loadCategoryBudgets()
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	if budget := monthlyBudget(); budget > 0 {
+		lines = append(lines, budgetBarLine("Monthly", expense, budget))
+	}
+	if len(budgets) > 0 {
+		spent, err := categorySpending(now)
+		if err != nil {
+			return "", err
+		}
+		for _, b := range budgets {
+			lines = append(lines, budgetBarLine(b.Category, spent[b.Category], b.Amount))
+		}
+	}
+	if len(lines) > 0 {
+		sb.WriteString("\n" + escapeHTML(strings.Join(lines, "\n")))
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// categorySpending returns the expenses of now's month per category.
+func categorySpending(now time.Time) (map[string]Money, error) {
+	monthStart, monthEnd := monthBounds(now)
+	rows, err := db.Query("SELECT category, SUM("+lineTotal+") FROM transactions WHERE type = 'expense' AND created_at >= ? AND created_at < ? AND "+ledgerScope()+" GROUP BY category",
+		monthStart.Format(dbTimeLayout), monthEnd.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	spent := make(map[string]Money)
+	for rows.Next() {
+		var category string
+		var total Money
+		if err := rows.Scan(&category, &total); err != nil {
+			return nil, err
+		}
+		spent[category] = total
+	}
+	return spent, rows.Err()
+}
+
+// refreshPinnedSummary edits the pinned summary when its text changed.
+// Unless force is set, it does nothing within pinnedSummaryInterval of
+// the previous edit; the scheduler catches up later.
+func refreshPinnedSummary(now time.Time, force bool) error {
+	chatID, messageID := pinnedSummaryTarget()
+	if chatID == 0 || messageID == 0 {
+		return nil
+	}
+	pinnedSummary.Lock()
+	defer pinnedSummary.Unlock()
+	if !force && time.Since(pinnedSummary.updated) < pinnedSummaryInterval {
+		return nil
+	}
+	text, err := pinnedSummaryText(now)
+	if err != nil {
+		return err
+	}
+	if text == pinnedSummary.text {
+		return nil
+	}
+
+	err = messenger.EditMessage(chatID, messageID, text, nil)
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "message is not modified"):
+		err = nil
+	case errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "message to edit not found"):
+		// deleted from the chat: start over with a new one
+		log.Printf("Pinned summary %d is gone, sending a new one", messageID)
+		err = pinNewSummary(chatID, text)
+	}
+	if err != nil {
+		return err
+	}
+	pinnedSummary.text, pinnedSummary.updated = text, time.Now()
+	return nil
+}
+
+// pinNewSummary sends text to chatID, pins it and remembers it as the
+// pinned summary.
+func pinNewSummary(chatID int64, text string) error {
+	messageID, err := messenger.SendText(chatID, text)
+	if err != nil {
+		return err
+	}
+	if err := messenger.PinMessage(chatID, messageID, true); err != nil {
+		return err
+	}
+	if err := setSetting("pinned_summary.chat_id", strconv.FormatInt(chatID, 10)); err != nil {
+		return err
+	}
+	return setSetting("pinned_summary.message_id", strconv.Itoa(messageID))
+}
+
+// transactionsChanged is called after a transaction is added or changed
+// from the chat.
+func transactionsChanged() {
+	if err := refreshPinnedSummary(appClock.Now(), false); err != nil {
+		reportError("refreshing the pinned summary", err)
+	}
+}
+
+// handlePinCommand implements /pin [on|off].
+func handlePinCommand(chatID int64, args string) {
+	pinnedChat, messageID := pinnedSummaryTarget()
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		if messageID == 0 {
+			sendMessage(chatID, "No summary is pinned. Use /pin on to pin a live month-to-date summary here.")
+		} else {
+			sendMessage(chatID, "The month-to-date summary is pinned and kept up to date. Use /pin off to stop.")
+		}
+	case "on":
+		text, err := pinnedSummaryText(appClock.Now())
+		if err != nil {
+			sendMessage(chatID, "Failed to build the summary.")
+			reportError("building the pinned summary", err)
+			return
+		}
+		if messageID != 0 {
+			_ = messenger.PinMessage(pinnedChat, messageID, false)
+		}
+		pinnedSummary.Lock()
+		defer pinnedSummary.Unlock()
+		if err := pinNewSummary(chatID, text); err != nil {
+			sendMessage(chatID, "Failed to pin the summary.")
+			reportError("pinning the summary", err)
+			return
+		}
+		pinnedSummary.text, pinnedSummary.updated = text, time.Now()
+	case "off":
+		if messageID == 0 {
+			sendMessage(chatID, "No summary is pinned.")
+			return
+		}
+		if err := messenger.PinMessage(pinnedChat, messageID, false); err != nil {
+			log.Printf("Failed to unpin the summary: %v", err)
+		}
+		if err := setSetting("pinned_summary.message_id", ""); err != nil {
+			sendMessage(chatID, "Failed to turn the pinned summary off.")
+			reportError("turning the pinned summary off", err)
+			return
+		}
+		pinnedSummary.Lock()
+		pinnedSummary.text = ""
+		pinnedSummary.Unlock()
+		sendMessage(chatID, "The summary is unpinned and no longer updated.")
+	default:
+		sendMessage(chatID, "Usage: /pin [on|off]")
+	}
+}
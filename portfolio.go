@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	PORTFOLIO (/portfolio) and NET WORTH (/networth)
+
+	Holdings keep the quantity and total cost basis per ticker; selling
+	reduces the cost basis at the average cost. Current prices come from a
+	PriceProvider: the quote endpoint in portfolio.price_url / PRICE_API_URL
+	when configured, and otherwise the last price entered with
+	/portfolio price.
+*/
+
+// PriceProvider returns the current price of one unit of ticker.
+type PriceProvider interface {
+	Price(ticker string) (float64, error)
+}
+
+// manualPrices serves the prices stored with /portfolio price.
+type manualPrices struct{}
+
+func (manualPrices) Price(ticker string) (float64, error) {
+	var price float64
+	err := db.QueryRow("SELECT price FROM prices WHERE ticker = ?", ticker).Scan(&price)
+	if err != nil {
+		return 0, fmt.Errorf("no price for %s", ticker)
+	}
+	return price, nil
+}
+
+// httpPrices fetches quotes from a JSON endpoint answering {"price": 123.4}.
+type httpPrices struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+func (h httpPrices) Price(ticker string) (float64, error) {
+	u := strings.ReplaceAll(h.urlTemplate, "{ticker}", url.QueryEscape(ticker))
+	resp, err := h.client.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("price request for %s: HTTP %d", ticker, resp.StatusCode)
+	}
+	var quote struct {
+		Price float64 `json:"price"`
+	}
+	if err := json.Unmarshal(body, &quote); err != nil || quote.Price <= 0 {
+		return 0, fmt.Errorf("invalid price response for %s", ticker)
+	}
+	return quote.Price, nil
+}
+
+// fallbackPrices asks each provider in turn until one knows the price.
+type fallbackPrices []PriceProvider
+
+func (f fallbackPrices) Price(ticker string) (float64, error) {
+	var lastErr error
+	for _, p := range f {
+		price, err := p.Price(ticker)
+		if err == nil {
+			return price, nil
+		}
+		lastErr = err
+	}
+	return 0, lastErr
+}
+
+// priceProvider returns the provider configured for this instance.
+func priceProvider() PriceProvider {
+	cfg := currentConfig()
+	if cfg != nil && cfg.PriceURL != "" {
+		return fallbackPrices{httpPrices{urlTemplate: cfg.PriceURL, client: &http.Client{Timeout: 10 * time.Second}}, manualPrices{}}
+	}
+	return manualPrices{}
+}
+
+type holding struct {
+	Ticker    string
+	Quantity  float64
+	CostBasis float64
+	Price     float64
+	HasPrice  bool
+}
+
+func (h holding) Value() float64 {
+	if !h.HasPrice {
+		return h.CostBasis // best guess until a price is known
+	}
+	return h.Quantity * h.Price
+}
+
+// loadHoldings returns the holdings with their current prices.
+func loadHoldings() ([]holding, error) {
+	rows, err := db.Query("SELECT ticker, quantity, cost_basis FROM holdings WHERE quantity > 0 ORDER BY ticker")
+	if err != nil {
+		return nil, err
+	}
+	var holdings []holding
+	for rows.Next() {
+		var h holding
+		if err := rows.Scan(&h.Ticker, &h.Quantity, &h.CostBasis); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		holdings = append(holdings, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	provider := priceProvider()
+	for i := range holdings {
+		price, err := provider.Price(holdings[i].Ticker)
+		if err != nil {
+			log.Printf("Failed to get price for %s: %v", holdings[i].Ticker, err)
+			continue
+		}
+		holdings[i].Price, holdings[i].HasPrice = price, true
+	}
+	return holdings, nil
+}
+
+// portfolioValue returns the current market value of all holdings.
+func portfolioValue() (float64, error) {
+	holdings, err := loadHoldings()
+	if err != nil {
+		return 0, err
+	}
+	total := 0.0
+	for _, h := range holdings {
+		total += h.Value()
+	}
+	return total, nil
+}
+
+func setPrice(ticker string, price float64) error {
+	_, err := db.Exec(`INSERT INTO prices (ticker, price, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(ticker) DO UPDATE SET price = excluded.price, updated_at = CURRENT_TIMESTAMP`, ticker, price)
+	return err
+}
+
+const portfolioUsage = "Usage:\n/portfolio\n/portfolio buy <ticker> <quantity> <price>\n/portfolio sell <ticker> <quantity> <price>\n/portfolio price <ticker> <price>\n/portfolio remove <ticker>"
+
+// handlePortfolioCommand implements /portfolio and its buy, sell, price and
+// remove subcommands.
+func handlePortfolioCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		showPortfolio(chatID)
+		return
+	}
+
+	action := strings.ToLower(fields[0])
+	if len(fields) < 2 {
+		sendMessage(chatID, portfolioUsage)
+		return
+	}
+	ticker := strings.ToUpper(fields[1])
+	numbers := make([]float64, 0, 2)
+	for _, f := range fields[2:] {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil || n <= 0 {
+			sendMessage(chatID, fmt.Sprintf("Invalid number %q.\n\n%s", f, portfolioUsage))
+			return
+		}
+		numbers = append(numbers, n)
+	}
+
+	switch {
+	case action == "buy" && len(numbers) == 2:
+		buyHolding(chatID, ticker, numbers[0], numbers[1])
+	case action == "sell" && len(numbers) == 2:
+		sellHolding(chatID, ticker, numbers[0], numbers[1])
+	case action == "price" && len(numbers) == 1:
+		if err := setPrice(ticker, numbers[0]); err != nil {
+			sendMessage(chatID, "Failed to save price.")
+			reportError("saving the price of "+ticker, err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Price of %s set to %s.", ticker, formatMoney(moneyFromFloat(numbers[0]))))
+	case action == "remove" && len(numbers) == 0:
+		res, err := db.Exec("DELETE FROM holdings WHERE ticker = ?", ticker)
+		if err != nil {
+			sendMessage(chatID, "Failed to remove holding.")
+			reportError("removing holding "+ticker, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("No holding for %s.", ticker))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Removed %s from the portfolio.", ticker))
+	default:
+		sendMessage(chatID, portfolioUsage)
+	}
+}
+
+func buyHolding(chatID int64, ticker string, quantity float64, price float64) {
+	_, err := db.Exec(`INSERT INTO holdings (ticker, quantity, cost_basis) VALUES (?, ?, ?)
+		ON CONFLICT(ticker) DO UPDATE SET quantity = quantity + excluded.quantity, cost_basis = cost_basis + excluded.cost_basis, updated_at = CURRENT_TIMESTAMP`,
+		ticker, quantity, quantity*price)
+	if err == nil {
+		err = setPrice(ticker, price)
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to save holding.")
+		reportError("buying "+ticker, err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Bought %g %s at %s (%s).", quantity, ticker, formatMoney(moneyFromFloat(price)), formatMoney(moneyFromFloat(quantity*price))))
+}
+
+func sellHolding(chatID int64, ticker string, quantity float64, price float64) {
+	var held, cost float64
+	err := db.QueryRow("SELECT quantity, cost_basis FROM holdings WHERE ticker = ?", ticker).Scan(&held, &cost)
+	if err != nil || held <= 0 {
+		sendMessage(chatID, fmt.Sprintf("No holding for %s.", ticker))
+		return
+	}
+	if quantity > held {
+		sendMessage(chatID, fmt.Sprintf("You only hold %g %s.", held, ticker))
+		return
+	}
+
+	soldCost := cost * quantity / held
+	_, err = db.Exec("UPDATE holdings SET quantity = ?, cost_basis = ?, updated_at = CURRENT_TIMESTAMP WHERE ticker = ?",
+		held-quantity, cost-soldCost, ticker)
+	if err == nil {
+		err = setPrice(ticker, price)
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to save holding.")
+		reportError("selling "+ticker, err)
+		return
+	}
+	gain := quantity*price - soldCost
+	sendMessage(chatID, fmt.Sprintf("Sold %g %s at %s. Realized gain/loss: %s.", quantity, ticker, formatMoney(moneyFromFloat(price)), formatSignedMoney(moneyFromFloat(gain))))
+}
+
+func showPortfolio(chatID int64) {
+	holdings, err := loadHoldings()
+	if err != nil {
+		sendMessage(chatID, "Failed to load portfolio.")
+		reportError("loading holdings", err)
+		return
+	}
+	if len(holdings) == 0 {
+		sendMessage(chatID, "Your portfolio is empty. Add a holding with /portfolio buy <ticker> <quantity> <price>.")
+		return
+	}
+
+	totalValue, totalCost := 0.0, 0.0
+	for _, h := range holdings {
+		totalValue += h.Value()
+		totalCost += h.CostBasis
+	}
+	sort.Slice(holdings, func(i, j int) bool { return holdings[i].Value() > holdings[j].Value() })
+
+	var sb strings.Builder
+	sb.WriteString("💼 Portfolio\n\n")
+	for _, h := range holdings {
+		gain := h.Value() - h.CostBasis
+		allocation := 0.0
+		if totalValue > 0 {
+			allocation = h.Value() / totalValue * 100
+		}
+		sb.WriteString(fmt.Sprintf("%s: %g × %s = %s\n", h.Ticker, h.Quantity, formatMoney(moneyFromFloat(h.Price)), formatMoney(moneyFromFloat(h.Value()))))
+		if !h.HasPrice {
+			sb.WriteString("  (no current price, valued at cost)\n")
+		}
+		sb.WriteString(fmt.Sprintf("  Cost %s, gain/loss %s (%+.1f%%), %.1f%% of portfolio\n", formatMoney(moneyFromFloat(h.CostBasis)), formatSignedMoney(moneyFromFloat(gain)), percentOf(gain, h.CostBasis), allocation))
+	}
+	totalGain := totalValue - totalCost
+	sb.WriteString(fmt.Sprintf("\nTotal value: %s\nTotal cost: %s\nGain/loss: %s (%+.1f%%)", formatMoney(moneyFromFloat(totalValue)), formatMoney(moneyFromFloat(totalCost)), formatSignedMoney(moneyFromFloat(totalGain)), percentOf(totalGain, totalCost)))
+	sendMessage(chatID, sb.String())
+}
+
+func percentOf(part float64, whole float64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return part / whole * 100
+}
+
+// showNetWorth adds the cash balance from the ledger and the portfolio value.
+func showNetWorth(chatID int64) {
+	cash, err := cashBalance()
+	if err != nil {
+		sendMessage(chatID, "Failed to compute net worth.")
+		reportError("summing transactions for net worth", err)
+		return
+	}
+	investments, err := portfolioValue()
+	if err != nil {
+		sendMessage(chatID, "Failed to compute net worth.")
+		reportError("valuing the portfolio", err)
+		return
+	}
+
+	sendMessage(chatID, fmt.Sprintf("🏦 Net worth\n\nCash (income - expenses): %s\nInvestments: %s\n\nTotal: %s", formatMoney(cash), formatMoney(moneyFromFloat(investments)), formatMoney(cash+moneyFromFloat(investments))))
+}
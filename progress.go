@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	PROGRESS BARS in messages, e.g. "▰▰▰▰▰▰▱▱▱▱ 64%".
+
+	Budgets use budgetProgress, which warns as spending nears the limit:
+	with the "blocks" style the bar is preceded by the budgetTier emoji,
+	with the "emoji" style the squares themselves turn from green to red.
+	Goals, where more is better, use the neutral progressBar. The width
+	and the style come from [display] in the config file:
+
+		[display]
+		bar_width = 10       # squares per bar, 3 to 30
+		bar_style = "blocks" # or "emoji"
+*/
+
+const (
+	defaultBarWidth = 10
+	minBarWidth     = 3
+	maxBarWidth     = 30
+)
+
+// barStyles holds the full and empty square of each style.
+var barStyles = map[string][2]string{
+	"blocks": {"▰", "▱"},
+	"emoji":  {"🟦", "⬜"},
+}
+
+// tierSquares are the full squares of the emoji style per budgetTier.
+var tierSquares = map[string]string{
+	"🟢": "🟩",
+	"🟡": "🟨",
+	"🟠": "🟧",
+	"🔴": "🟥",
+}
+
+func barSettings() (int, string) {
+	cfg := currentConfig()
+	width, style := defaultBarWidth, "blocks"
+	if cfg != nil {
+		if cfg.BarWidth > 0 {
+			width = cfg.BarWidth
+		}
+		if cfg.BarStyle != "" {
+			style = cfg.BarStyle
+		}
+	}
+	return width, style
+}
+
+// drawBar fills ratio of width squares, all of them from a ratio of 1.
+func drawBar(ratio float64, width int, full, empty string) string {
+	filled := int(ratio*float64(width) + 0.5)
+	filled = max(0, min(filled, width))
+	return strings.Repeat(full, filled) + strings.Repeat(empty, width-filled)
+}
+
+// progressBar renders progress towards a goal, e.g. "▰▰▰▱▱ 64%".
+func progressBar(ratio float64) string {
+	width, style := barSettings()
+	squares := barStyles[style]
+	return fmt.Sprintf("%s %.0f%%", drawBar(ratio, width, squares[0], squares[1]), ratio*100)
+}
+
+// budgetProgress renders spending at ratio of a budget with its warning
+// color, e.g. "🟠 ▰▰▰▰▰▰▰▰▰▱ 92%"; with the indicators off
+// (indicators.go) it is a plain bar, e.g. "▰▰▰▰▰▰▰▰▰▱ 92%".
+func budgetProgress(ratio float64) string {
+	width, style := barSettings()
+	if !indicatorsOn() {
+		squares := barStyles["blocks"]
+		return fmt.Sprintf("%s %.0f%%", drawBar(ratio, width, squares[0], squares[1]), ratio*100)
+	}
+	tier := budgetTier(ratio)
+	if style == "emoji" {
+		return fmt.Sprintf("%s %.0f%%", drawBar(ratio, width, tierSquares[tier], barStyles[style][1]), ratio*100)
+	}
+	return tier + " " + progressBar(ratio)
+}
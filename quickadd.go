@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+	QUICK ADD
+
+	Outside any flow, a message that starts with an amount logs a
+	transaction in one go: "25000 grab to office" is an expense,
+	"+5000000 salary" an income, "20USD lunch" an expense converted into
+	the ledger's currency (fx.go). The category comes from the first
+	matching rule (rules.go); without one, the bot asks for it with the
+	category buttons, the AI provider's suggestion first (ai.go).
+
+	/add takes the category instead of a description when it is given
+	arguments: "/add 20USD Food", "/add +5000000 Salary March", the
+	description being optional.
+*/
+
+const addUsage = "Usage:\n/add - add a transaction step by step\n/add <amount> <category> [description] - add it in one go, e.g. /add 20USD Food lunch"
+
+// parseQuickAdd splits a quick-add message into its parts, code being
+// the currency of an amount in another one. It returns false when the
+// message does not start with a positive amount.
+func parseQuickAdd(text string) (typ string, amount Money, code string, description string, ok bool) {
+	first, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+	typ = "expense"
+	if strings.HasPrefix(first, "+") {
+		typ, first = "income", first[1:]
+	}
+	amount, err := parseMoney(first)
+	if err != nil {
+		if amount, code, ok = parseForeignAmount(first); !ok {
+			return "", 0, "", "", false
+		}
+	}
+	if amount <= 0 {
+		return "", 0, "", "", false
+	}
+	return typ, amount, code, strings.TrimSpace(rest), true
+}
+
+// handleQuickAdd logs a quick-add message. It returns false when the
+// message is not one.
+func handleQuickAdd(message *TGMessage) bool {
+	typ, amount, code, description, ok := parseQuickAdd(message.Text)
+	if !ok {
+		return false
+	}
+	chatID := message.Chat.ID
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return true
+	}
+	if description == "" || len(description) > 100 {
+		sendMessage(chatID, "Add a description of 1 to 100 characters after the amount, e.g. 25000 lunch.")
+		return true
+	}
+	var foreign *foreignAmount
+	if code != "" {
+		var err error
+		if amount, foreign, err = convertForeign(amount, code, appClock.Now()); err != nil {
+			sendMessage(chatID, fmt.Sprintf("Cannot convert the amount: %v.", err))
+			return true
+		}
+	}
+
+	state := &TransactionState{
+		UserID:          message.From.ID,
+		TransactionType: typ,
+		Amount:          amount,
+		Description:     description,
+		Foreign:         foreign,
+	}
+	rules, err := loadRules()
+	if err != nil {
+		log.Printf("Failed to load the rules for a quick add: %v", err)
+	}
+	if r, ok := matchRule(rules, typ, amount, description); ok {
+		state.Category = r.Category
+		saveTransaction(chatID, state, 0, fmt.Sprintf("Categorized as %s by rule %d.", r.Category, r.ID))
+		return true
+	}
+
+	state.Step = "QUICK_CATEGORY"
+	userStates[state.UserID] = state
+	prompt := fmt.Sprintf("%s of %s: %s. Choose a category:", typ, formatMoney(amount), description)
+	cats := activeCategories()
+	buttons := make([][]InlineKeyboardButton, 0, len(cats)+1)
+	suggested, confidence, ok := suggestCategory(typ, description)
+	if ok {
+		prompt = fmt.Sprintf("%s of %s: %s.\n🤖 Looks like %s (confidence %.0f%%). Confirm it or choose another category:", typ, formatMoney(amount), description, suggested, confidence*100)
+		buttons = append(buttons, []InlineKeyboardButton{{Text: "✅ " + suggested, CallbackData: suggested}})
+	}
+	for _, category := range cats {
+		if ok && category == suggested {
+			continue
+		}
+		buttons = append(buttons, []InlineKeyboardButton{{Text: category, CallbackData: category}})
+	}
+	sendMessageWithKeyboard(chatID, prompt, buildKeyboard(buttons))
+	return true
+}
+
+func processQuickCategory(callback *CallbackQuery, state *TransactionState) {
+	category, ok := findCategory(callback.Data)
+	if !ok {
+		editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "Unknown category. Send the amount and description again.")
+		delete(userStates, state.UserID)
+		return
+	}
+	state.Category = category
+	editMessage(callback.Message.Chat.ID, callback.Message.MessageID, fmt.Sprintf("Selected category: %s.", category))
+	saveTransaction(callback.Message.Chat.ID, state, 0, "")
+}
+
+// handleAddArgs logs "/add <amount> <category> [description]".
+func handleAddArgs(message *TGMessage, args string) {
+	chatID := message.Chat.ID
+	if isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+	fields := strings.Fields(args)
+	typ := "expense"
+	if strings.HasPrefix(fields[0], "+") {
+		typ, fields[0] = "income", fields[0][1:]
+	}
+	if len(fields) > 1 {
+		// "20 USD Food"
+		if _, _, ok := parseForeignAmount(fields[0] + fields[1]); ok {
+			fields = append([]string{fields[0] + fields[1]}, fields[2:]...)
+		}
+	}
+	amount, foreign, err := parseEntryAmount(fields[0], appClock.Now())
+	if err == errNotAmount {
+		sendMessage(chatID, fmt.Sprintf("Invalid amount %q.\n\n%s", fields[0], addUsage))
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Cannot convert the amount: %v.", err))
+		return
+	}
+	fields = fields[1:]
+	category := ""
+	for i := len(fields); i > 0 && category == ""; i-- {
+		if name, ok := findCategory(strings.Join(fields[:i], " ")); ok {
+			category, fields = name, fields[i:]
+		}
+	}
+	if category == "" {
+		sendMessage(chatID, fmt.Sprintf("Unknown category. Available: %s\n\n%s", strings.Join(activeCategories(), ", "), addUsage))
+		return
+	}
+	description := strings.Join(fields, " ")
+	if len(description) > 100 {
+		sendMessage(chatID, "Description too long. Please keep it under 100 characters.")
+		return
+	}
+	saveTransaction(chatID, &TransactionState{
+		UserID:          message.From.ID,
+		TransactionType: typ,
+		Category:        category,
+		Amount:          amount,
+		Description:     description,
+		Foreign:         foreign,
+	}, 0, "")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	RECATEGORIZE (/recategorize [period])
+
+	Runs the rules (rules.go) again over the transactions of a period, this
+	month by default, e.g. after adding a rule. The preview counts the
+	changes per old → new category; Apply makes them in one database
+	transaction, after computing them again. Transactions in a closed
+	period (closing.go) are left alone.
+*/
+
+const recategorizeDateLayout = "20060102"
+
+// categoryChange is a transaction whose rule gives another category.
+type categoryChange struct {
+	ID       int64
+	From, To string
+}
+
+// proposedChanges applies the rules to the transactions created in
+// [from, to) and returns those that would change category, plus how many
+// matching ones are locked in a closed period.
+func proposedChanges(from time.Time, to time.Time) ([]categoryChange, int, error) {
+	rules, err := loadRules()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(rules) == 0 {
+		return nil, 0, nil
+	}
+	cutoff := closedBefore()
+	rows, err := db.Query("SELECT id, type, category, amount, description, created_at FROM transactions WHERE created_at >= ? AND created_at < ? AND "+ledgerScope()+" ORDER BY id",
+		from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var changes []categoryChange
+	locked := 0
+	for rows.Next() {
+		var id int64
+		var typ, category, createdAt string
+		var amount Money
+		var description sql.NullString
+		if err := rows.Scan(&id, &typ, &category, &amount, &description, &createdAt); err != nil {
+			return nil, 0, err
+		}
+		r, ok := matchRule(rules, typ, amount, description.String)
+		if !ok || r.Category == category {
+			continue
+		}
+		if closedAt(createdAt, cutoff) {
+			locked++
+			continue
+		}
+		changes = append(changes, categoryChange{ID: id, From: category, To: r.Category})
+	}
+	return changes, locked, rows.Err()
+}
+
+// summarizeChanges counts the changes per "old → new", largest first.
+func summarizeChanges(changes []categoryChange) string {
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.From+" → "+c.To]++
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf("• %s: %d\n", k, counts[k]))
+	}
+	return sb.String()
+}
+
+func handleRecategorizeCommand(chatID int64, args string) {
+	from, to, label, err := parsePeriod(args, appClock.Now())
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v. Usage: /recategorize [YYYY-MM | YYYY | YYYY-MM-DD YYYY-MM-DD]", err))
+		return
+	}
+	changes, locked, err := proposedChanges(from, to)
+	if err != nil {
+		sendMessage(chatID, "Failed to apply the rules.")
+		reportError("previewing a recategorization", err)
+		return
+	}
+	lockedNote := ""
+	if locked > 0 {
+		lockedNote = fmt.Sprintf("\n%d transaction(s) in a closed period are left alone.", locked)
+	}
+	if len(changes) == 0 {
+		sendMessage(chatID, fmt.Sprintf("The rules change no transactions in %s.%s", label, lockedNote))
+		return
+	}
+	text := fmt.Sprintf("📐 Recategorize %s with the rules\n\n%d transaction(s) would change:\n%s%s",
+		label, len(changes), summarizeChanges(changes), lockedNote)
+	period := from.Format(recategorizeDateLayout) + ":" + to.Format(recategorizeDateLayout)
+	keyboard := buildKeyboard([][]InlineKeyboardButton{{
+		{Text: "✅ Apply", CallbackData: "recat:apply:" + period},
+		{Text: "Cancel", CallbackData: "recat:cancel"},
+	}})
+	sendMessageWithKeyboard(chatID, strings.TrimRight(text, "\n"), keyboard)
+}
+
+// handleRecategorizeCallback handles recat:apply:<from>:<to> and
+// recat:cancel.
+func handleRecategorizeCallback(callback *CallbackQuery) {
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) == 2 && parts[1] == "cancel" {
+		_ = messenger.AnswerCallback(callback.ID, "")
+		editMessage(chatID, messageID, "Recategorization canceled; nothing was changed.")
+		return
+	}
+	if len(parts) != 4 || parts[1] != "apply" {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	from, err1 := time.ParseInLocation(recategorizeDateLayout, parts[2], appLocation())
+	to, err2 := time.ParseInLocation(recategorizeDateLayout, parts[3], appLocation())
+	if err1 != nil || err2 != nil || !from.Before(to) {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	changes, _, err := proposedChanges(from, to)
+	if err == nil {
+		err = applyCategoryChanges(changes)
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to recategorize; nothing was changed.")
+		reportError("recategorizing transactions", err)
+		return
+	}
+	editMessage(chatID, messageID, fmt.Sprintf("📐 Recategorized %d transaction(s):\n%s", len(changes), strings.TrimRight(summarizeChanges(changes), "\n")))
+	transactionsChanged()
+}
+
+// applyCategoryChanges makes all changes or none.
+func applyCategoryChanges(changes []categoryChange) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, c := range changes {
+		if _, err := tx.Exec("UPDATE transactions SET category = ? WHERE id = ?", c.To, c.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+	E-RECEIPTS FROM EMAIL (/receipts)
+
+	With [imap] set up (see the README), the bot checks a mailbox every few
+	minutes for e-receipts, from the senders listed in imap.from or from
+	anyone when the list is empty. The mailbox is opened read-only, so
+	nothing is marked read or moved. A mail whose text has a total
+	("Total", "Grand total", "Amount paid", "Total bayar", "Jumlah"...)
+	becomes a draft sent to the owner:
+
+		🧾 Receipt from Tokopedia, 14 Mar 2026 10:21
+		125,000.00 - Your order INV/20260314/123
+		[✅ Add as Shopping] [📂 Other category] [✖ Skip]
+
+	One tap adds it as an expense at the time of the mail, with the merchant
+	as description. The category suggested comes from the rules (rules.go);
+	without a matching rule the draft asks for one.
+
+	/receipts          pending drafts and the last check of the mailbox
+	/receipts check    check the mailbox now
+
+	Mails are read once: the last UID seen is kept in the settings, and the
+	first check after setting up, or after the mailbox was recreated,
+	only looks at the mails of the last day. Drafts belong to the
+	"receipts" group of /notify.
+*/
+
+const (
+	receiptsUsage     = "Usage:\n/receipts - pending drafts\n/receipts check - check the mailbox now"
+	receiptMaxPerPoll = 20
+)
+
+// receiptPollMu keeps the scheduled check and /receipts check apart.
+var receiptPollMu sync.Mutex
+
+// receipt is what was read from an e-receipt mail.
+type receipt struct {
+	MessageID string
+	From      string // the sender's address, lowercased
+	Merchant  string
+	Subject   string
+	Amount    Money
+	Date      time.Time
+}
+
+func receiptsConfigured() bool {
+	cfg := currentConfig()
+	return cfg != nil && cfg.IMAPHost != ""
+}
+
+// runReceiptPoller checks the mailbox at the configured interval, picking
+// up configuration reloads on the way.
+func runReceiptPoller() {
+	for {
+		interval := 5 * time.Minute
+		if receiptsConfigured() {
+			interval = time.Duration(currentConfig().IMAPInterval) * time.Second
+			if !isMaintenanceMode() {
+				if _, err := pollReceipts(); err != nil {
+					reportErrorTagged("checking the receipts mailbox", err, map[string]string{"job": "receipts"})
+				}
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// pollReceipts reads the mails that arrived since the last check, drafts
+// the receipts among them and returns how many it drafted.
+func pollReceipts() (int, error) {
+	receiptPollMu.Lock()
+	defer receiptPollMu.Unlock()
+
+	c, err := dialIMAP(currentConfig().IMAPHost)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	if err := c.Login(currentConfig().IMAPUser, currentConfig().IMAPPassword); err != nil {
+		return 0, err
+	}
+	defer c.Logout()
+	validity, err := c.Examine(currentConfig().IMAPMailbox)
+	if err != nil {
+		return 0, err
+	}
+
+	lastUID, _ := strconv.ParseInt(getSetting("receipts.imap_uid", "0"), 10, 64)
+	criteria := fmt.Sprintf("UID %d:*", lastUID+1)
+	if lastUID == 0 || getSetting("receipts.imap_uidvalidity", "") != strconv.FormatInt(validity, 10) {
+		lastUID = 0
+		criteria = "SINCE " + appClock.Now().AddDate(0, 0, -1).Format("02-Jan-2006")
+	}
+	uids, err := c.SearchUIDs(criteria)
+	if err != nil {
+		return 0, err
+	}
+	if err := setSetting("receipts.imap_uidvalidity", strconv.FormatInt(validity, 10)); err != nil {
+		return 0, err
+	}
+
+	drafted := 0
+	for _, uid := range uids {
+		// "UID n:*" also returns the last mail when it is older than n
+		if uid <= lastUID {
+			continue
+		}
+		if drafted >= receiptMaxPerPoll {
+			break
+		}
+		raw, err := c.FetchMessage(uid)
+		if err != nil {
+			return drafted, err
+		}
+		if r, ok := parseReceipt(raw); ok && receiptSenderAllowed(r.From) {
+			created, err := draftReceipt(r)
+			if err != nil {
+				return drafted, err
+			}
+			if created {
+				drafted++
+			}
+		}
+		lastUID = uid
+		if err := setSetting("receipts.imap_uid", strconv.FormatInt(uid, 10)); err != nil {
+			return drafted, err
+		}
+	}
+	if err := setSetting("receipts.last_check", appClock.Now().Format(dbTimeLayout)); err != nil {
+		return drafted, err
+	}
+	return drafted, nil
+}
+
+// receiptSenderAllowed tells whether mails from address may be receipts.
+func receiptSenderAllowed(address string) bool {
+	if len(currentConfig().IMAPFrom) == 0 {
+		return true
+	}
+	for _, from := range currentConfig().IMAPFrom {
+		if from != "" && strings.Contains(address, strings.ToLower(from)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseReceipt reads a mail and returns the receipt in it, if it has a
+// total.
+func parseReceipt(raw []byte) (receipt, bool) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return receipt{}, false
+	}
+	r := receipt{MessageID: strings.TrimSpace(msg.Header.Get("Message-Id")), Date: appClock.Now()}
+	r.Subject = msg.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(r.Subject); err == nil {
+		r.Subject = decoded
+	}
+	r.Subject = strings.TrimSpace(r.Subject)
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		r.From = strings.ToLower(from.Address)
+		r.Merchant = strings.TrimSpace(from.Name)
+	}
+	if r.Merchant == "" {
+		r.Merchant = merchantFromAddress(r.From)
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		r.Date = date.In(appLocation())
+	}
+
+	body := messageText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	r.Amount = receiptTotal(r.Subject + "\n" + body)
+	return r, r.Amount > 0
+}
+
+// merchantFromAddress names a sender without a display name after its
+// domain: "receipts@mail.grab.com" is "Grab".
+func merchantFromAddress(address string) string {
+	_, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return "Unknown merchant"
+	}
+	labels := strings.Split(domain, ".")
+	// drop the suffixes, "com" or "co.id"
+	for len(labels) > 1 && len(labels[len(labels)-1]) <= 3 {
+		labels = labels[:len(labels)-1]
+	}
+	name := []rune(labels[len(labels)-1])
+	if len(name) == 0 {
+		return "Unknown merchant"
+	}
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// messageText returns the text of a mail body: its text/plain part, or
+// else its HTML part stripped of the markup.
+func messageText(contentType, encoding string, body io.Reader) string {
+	plain, htmlText := messageParts(contentType, encoding, body, 0)
+	if strings.TrimSpace(plain) != "" {
+		return plain
+	}
+	return htmlToText(htmlText)
+}
+
+// messageParts walks a body down its multipart parts and returns the
+// first plain text and the first HTML found, decoded.
+func messageParts(contentType, encoding string, body io.Reader, depth int) (plain string, htmlText string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= 5 || params["boundary"] == "" {
+			return "", ""
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err != nil {
+				return plain, htmlText
+			}
+			p, h := messageParts(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if plain == "" {
+				plain = p
+			}
+			if htmlText == "" {
+				htmlText = h
+			}
+		}
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", ""
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	// a mail cut at imapMaxFetch ends early; what was read is enough
+	data, _ := io.ReadAll(body)
+	text := string(data)
+	switch strings.ToLower(params["charset"]) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	if mediaType == "text/html" {
+		return "", text
+	}
+	return text, ""
+}
+
+var (
+	mailHiddenPattern = regexp.MustCompile(`(?is)<(style|script|head)\b.*?</(style|script|head)\s*>`)
+	mailBreakPattern  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|h[1-6]|table)\s*>`)
+	mailTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacesPattern     = regexp.MustCompile(`[ \t\x{a0}]+`)
+)
+
+// htmlToText keeps the text of an HTML mail, a line per paragraph or
+// table row, so that "Total" and its amount stay on one line.
+func htmlToText(s string) string {
+	s = mailHiddenPattern.ReplaceAllString(s, "")
+	s = mailBreakPattern.ReplaceAllString(s, "\n")
+	s = mailTagPattern.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	return spacesPattern.ReplaceAllString(s, " ")
+}
+
+var (
+	receiptTotalPattern  = regexp.MustCompile(`(?i)\b(grand total|total|amount paid|amount charged|amount due|jumlah)\b`)
+	receiptAmountPattern = regexp.MustCompile(`\d[\d.,]*\d|\d`)
+)
+
+// receiptTotal finds the total of a receipt: the largest amount after a
+// total keyword, on the same line or the next one.
+func receiptTotal(text string) Money {
+	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+	var total Money
+	for i, line := range lines {
+		lower := strings.ToLower(line)
+		if strings.Contains(lower, "subtotal") || strings.Contains(lower, "sub total") {
+			continue
+		}
+		loc := receiptTotalPattern.FindStringIndex(line)
+		if loc == nil {
+			continue
+		}
+		amounts := receiptAmountPattern.FindAllString(line[loc[1]:], -1)
+		if len(amounts) == 0 && i+1 < len(lines) {
+			amounts = receiptAmountPattern.FindAllString(lines[i+1], -1)
+		}
+		for _, s := range amounts {
+			if amount, ok := parseReceiptAmount(s); ok && amount > total {
+				total = amount
+			}
+		}
+	}
+	return total
+}
+
+// parseReceiptAmount reads an amount written the local way, "125.000",
+// "125,000.00", "1.250.000,50" or "12.50": with both separators the last
+// one is the decimal point, with one kind it is a thousands separator
+// when repeated or followed by exactly three digits.
+func parseReceiptAmount(s string) (Money, bool) {
+	whole, frac := s, ""
+	if sep := strings.LastIndexAny(s, ".,"); sep >= 0 {
+		other := "."
+		if s[sep] == '.' {
+			other = ","
+		}
+		thousands := !strings.Contains(s, other) && (strings.Count(s, s[sep:sep+1]) > 1 || len(s)-sep-1 == 3)
+		if !thousands {
+			whole, frac = s[:sep], s[sep+1:]
+		}
+	}
+	if len(frac) > 2 {
+		return 0, false
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+	if frac != "" {
+		whole += "." + frac
+	}
+	amount, err := parseMoney(whole)
+	return amount, err == nil && amount > 0
+}
+
+// draftReceipt stores a receipt as a pending draft and sends it to the
+// owner; it returns false for a mail drafted before.
+func draftReceipt(r receipt) (bool, error) {
+	if r.MessageID != "" {
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(*) FROM receipt_drafts WHERE message_id = ?", r.MessageID).Scan(&exists); err != nil {
+			return false, err
+		}
+		if exists > 0 {
+			return false, nil
+		}
+	}
+	rules, err := loadRules()
+	if err != nil {
+		return false, err
+	}
+	var category sql.NullString
+	for _, description := range []string{r.Merchant, r.Subject} {
+		if rule, ok := matchRule(rules, "expense", r.Amount, description); ok {
+			category = sql.NullString{String: rule.Category, Valid: true}
+			break
+		}
+	}
+	if len(r.Merchant) > 100 {
+		r.Merchant = r.Merchant[:100]
+	}
+	res, err := db.Exec(`INSERT INTO receipt_drafts (message_id, merchant, subject, amount, occurred_at, category, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		sql.NullString{String: r.MessageID, Valid: r.MessageID != ""}, r.Merchant, r.Subject, r.Amount,
+		r.Date.Format(dbTimeLayout), category, appClock.Now().Format(dbTimeLayout))
+	if err != nil {
+		return false, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+
+	d := receiptDraft{ID: id, Merchant: r.Merchant, Subject: r.Subject, Amount: r.Amount, OccurredAt: r.Date.Format(dbTimeLayout), Category: category}
+	return true, enqueueNotification(ownerID(), "receipt_draft", fmt.Sprintf("receipt:%d", id), receiptDraftText(d), receiptKeyboard(id, category.String))
+}
+
+// receiptDraftText describes a draft, asking for a category when it has
+// none.
+func receiptDraftText(d receiptDraft) string {
+	text := fmt.Sprintf("🧾 Receipt from %s, %s\n%s", d.Merchant, formatCreatedAt(d.OccurredAt), formatMoney(d.Amount))
+	if d.Subject != "" {
+		text += " - " + d.Subject
+	}
+	if !d.Category.Valid {
+		text += "\n\nWhich category?"
+	}
+	return text
+}
+
+// receiptKeyboard offers to add a draft with its suggested category, or
+// lists the categories when there is none.
+func receiptKeyboard(id int64, category string) InlineKeyboardMarkup {
+	skip := InlineKeyboardButton{Text: "✖ Skip", CallbackData: fmt.Sprintf("receipt:skip:%d", id)}
+	if category != "" {
+		return buildKeyboard([][]InlineKeyboardButton{
+			{{Text: "✅ Add as " + category, CallbackData: fmt.Sprintf("receipt:add:%d", id)}},
+			{{Text: "📂 Other category", CallbackData: fmt.Sprintf("receipt:pick:%d", id)}, skip},
+		})
+	}
+	var rows [][]InlineKeyboardButton
+	var row []InlineKeyboardButton
+	for _, name := range activeCategories() {
+		data := fmt.Sprintf("receipt:cat:%d:%s", id, name)
+		if len(data) > 64 {
+			continue
+		}
+		row = append(row, InlineKeyboardButton{Text: name, CallbackData: data})
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, []InlineKeyboardButton{skip})
+	return buildKeyboard(rows)
+}
+
+// receiptDraft is a stored draft.
+type receiptDraft struct {
+	ID         int64
+	Merchant   string
+	Subject    string
+	Amount     Money
+	OccurredAt string
+	Category   sql.NullString
+	Status     string
+}
+
+func findReceiptDraft(id int64) (receiptDraft, error) {
+	d := receiptDraft{ID: id}
+	err := db.QueryRow("SELECT merchant, subject, amount, occurred_at, category, status FROM receipt_drafts WHERE id = ?", id).
+		Scan(&d.Merchant, &d.Subject, &d.Amount, &d.OccurredAt, &d.Category, &d.Status)
+	return d, err
+}
+
+func handleReceiptCallback(callback *CallbackQuery) {
+	parts := strings.SplitN(callback.Data, ":", 4)
+	if len(parts) < 3 {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	d, err := findReceiptDraft(id)
+	if err == sql.ErrNoRows {
+		_ = messenger.AnswerCallback(callback.ID, "This receipt is gone.")
+		return
+	}
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Failed to load the receipt.")
+		reportError("loading a receipt draft", err)
+		return
+	}
+	if d.Status != "pending" {
+		_ = messenger.AnswerCallback(callback.ID, "This receipt was already "+d.Status+".")
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+
+	switch parts[1] {
+	case "pick":
+		_ = messenger.AnswerCallback(callback.ID, "")
+		d.Category.Valid = false
+		editMessageWithKeyboard(chatID, messageID, receiptDraftText(d), receiptKeyboard(id, ""))
+	case "skip":
+		_ = messenger.AnswerCallback(callback.ID, "")
+		if _, err := db.Exec("UPDATE receipt_drafts SET status = 'skipped' WHERE id = ?", id); err != nil {
+			reportError("skipping a receipt draft", err)
+			return
+		}
+		editMessage(chatID, messageID, fmt.Sprintf("✖ Skipped the receipt from %s (%s).", d.Merchant, formatMoney(d.Amount)))
+	case "add", "cat":
+		category := d.Category.String
+		if parts[1] == "cat" && len(parts) == 4 {
+			category = parts[3]
+		}
+		if category == "" {
+			_ = messenger.AnswerCallback(callback.ID, "Pick a category first.")
+			return
+		}
+		name, ok := findCategory(category)
+		if !ok {
+			_ = messenger.AnswerCallback(callback.ID, fmt.Sprintf("The category %q no longer exists.", category))
+			return
+		}
+		if periodClosed(d.OccurredAt) && callback.From.ID != ownerID() {
+			_ = messenger.AnswerCallback(callback.ID, fmt.Sprintf("The books are closed through %s.", closedThrough(closedBefore())))
+			return
+		}
+		_ = messenger.AnswerCallback(callback.ID, "")
+		text, err := addReceiptDraft(d, name)
+		if err != nil {
+			reportError(fmt.Sprintf("adding receipt draft %d", id), err)
+			sendMessage(chatID, "Failed to add the receipt.")
+			return
+		}
+		editHTML(chatID, messageID, text)
+	default:
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+	}
+}
+
+// addReceiptDraft records a draft as an expense in category and returns
+// the confirmation.
+func addReceiptDraft(d receiptDraft, category string) (string, error) {
+	date, err := parseCreatedAt(d.OccurredAt)
+	if err != nil {
+		return "", err
+	}
+	id, roundup, err := insertTransaction("expense", category, 1, d.Amount, d.Merchant, date, false)
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.Exec("UPDATE receipt_drafts SET status = 'added', category = ?, transaction_id = ? WHERE id = ?", category, id, d.ID); err != nil {
+		return "", err
+	}
+	text := bold("🧾 Receipt added") + "\n" + transactionRecap(id, "expense", category, 1, d.Amount, d.Merchant)
+	if status, err := categoryBudgetStatus(category, date); err != nil {
+		reportError("computing the budget status", err)
+	} else if status != "" {
+		text += "\n\n" + escapeHTML(status)
+	}
+	if roundup != "" {
+		text += "\n\n" + escapeHTML(roundup)
+	}
+	transactionsChanged()
+	return text, nil
+}
+
+func loadPendingReceiptDrafts() ([]receiptDraft, error) {
+	rows, err := db.Query("SELECT id, merchant, subject, amount, occurred_at, category, status FROM receipt_drafts WHERE status = 'pending' ORDER BY occurred_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var drafts []receiptDraft
+	for rows.Next() {
+		var d receiptDraft
+		if err := rows.Scan(&d.ID, &d.Merchant, &d.Subject, &d.Amount, &d.OccurredAt, &d.Category, &d.Status); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
+
+func handleReceiptsCommand(chatID int64, args string) {
+	if !receiptsConfigured() {
+		sendMessage(chatID, "E-receipts are off: set [imap] host and user, and IMAP_PASSWORD in the environment.")
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		showReceiptDrafts(chatID)
+	case "check":
+		if isMaintenanceMode() {
+			sendMessage(chatID, "The bot is in read-only maintenance mode.")
+			return
+		}
+		drafted, err := pollReceipts()
+		if err != nil {
+			sendMessage(chatID, "Failed to check the mailbox: "+err.Error())
+			return
+		}
+		if drafted == 0 {
+			sendMessage(chatID, "No new receipts.")
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("%d new receipt(s), sent as drafts to add.", drafted))
+	default:
+		sendMessage(chatID, receiptsUsage)
+	}
+}
+
+func showReceiptDrafts(chatID int64) {
+	drafts, err := loadPendingReceiptDrafts()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the receipts.")
+		reportError("loading receipt drafts", err)
+		return
+	}
+	lines := []string{"🧾 Pending receipts:"}
+	for _, d := range drafts {
+		line := fmt.Sprintf("%s %s %s", formatCreatedAt(d.OccurredAt), d.Merchant, formatMoney(d.Amount))
+		if d.Category.Valid {
+			line += " (" + d.Category.String + ")"
+		}
+		lines = append(lines, line)
+	}
+	if len(drafts) == 0 {
+		lines = []string{"No pending receipts."}
+	}
+	lastCheck := "never"
+	if v := getSetting("receipts.last_check", ""); v != "" {
+		lastCheck = formatCreatedAt(v)
+	}
+	lines = append(lines, "", fmt.Sprintf("Mailbox %s last checked: %s.", currentConfig().IMAPMailbox, lastCheck), "", receiptsUsage)
+	sendMessage(chatID, strings.Join(lines, "\n"))
+}
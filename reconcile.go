@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	RECONCILIATION (/reconcile)
+
+	/reconcile 1250000 compares the balance on the bank statement (or in
+	the wallet) with the balance of the ledger, all income minus all
+	expenses. When they match, every transaction so far is marked
+	reconciled. When they do not, the bot shows the difference and offers
+	to book it as an adjustment, or to walk through the transactions not
+	reconciled yet, one at a time, to find the entry that is wrong. The
+	adjustment is flagged as an outlier so it does not skew averages and
+	forecasts. The ledger has a single balance; there are no separate
+	accounts.
+
+	Buttons are rec:<action>:<value>; the adjustment is computed again when
+	pressed, so a transaction fixed in the meantime is taken into account.
+*/
+
+const (
+	reconcileUsage     = "Usage: /reconcile <balance>, e.g. /reconcile 1250000"
+	adjustmentCategory = "Adjustment"
+)
+
+func unreconciledCount() (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE reconciled = 0 AND " + ledgerScope()).Scan(&n)
+	return n, err
+}
+
+// lastReconciliation returns when the ledger was last reconciled, or "".
+func lastReconciliation() (string, error) {
+	var at string
+	err := db.QueryRow("SELECT reconciled_at FROM reconciliations ORDER BY id DESC LIMIT 1").Scan(&at)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return at, err
+}
+
+// markReconciled records a reconciliation against statement and marks
+// every transaction of the active ledger reconciled.
+func markReconciled(statement Money, computed Money, adjustmentID int64) error {
+	scope := ledgerScope()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO reconciliations (reconciled_at, statement_balance, computed_balance, adjustment_id) VALUES (?, ?, ?, NULLIF(?, 0))",
+		appClock.Now().Format(dbTimeLayout), statement, computed, adjustmentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE transactions SET reconciled = 1 WHERE reconciled = 0 AND " + scope); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func handleReconcileCommand(chatID int64, args string) {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		showReconcileStatus(chatID)
+		return
+	}
+	statement, err := parseMoney(args)
+	if err != nil {
+		sendMessage(chatID, reconcileUsage)
+		return
+	}
+	computed, err := cashBalance()
+	if err != nil {
+		sendMessage(chatID, "Failed to compute the balance.")
+		reportError("computing the balance to reconcile", err)
+		return
+	}
+
+	diff := statement - computed
+	if diff == 0 {
+		if err := markReconciled(statement, computed, 0); err != nil {
+			sendMessage(chatID, "Failed to record the reconciliation.")
+			reportError("recording a reconciliation", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("✅ Balanced: the ledger matches %s. Every transaction so far is marked reconciled.", formatMoney(statement)))
+		return
+	}
+
+	open, err := unreconciledCount()
+	if err != nil {
+		sendMessage(chatID, "Failed to count the unreconciled transactions.")
+		reportError("counting unreconciled transactions", err)
+		return
+	}
+	hint := "the ledger is missing income or has an expense too many"
+	if diff < 0 {
+		hint = "the ledger is missing an expense or has income too many"
+	}
+	text := fmt.Sprintf("🧮 Reconciliation\n\nStatement: %s\nLedger: %s\nDifference: %s (%s)\n\n%d transaction(s) are not reconciled yet.",
+		formatMoney(statement), formatMoney(computed), formatSignedMoney(diff), hint, open)
+	value := statement.Plain()
+	rows := [][]InlineKeyboardButton{{{Text: fmt.Sprintf("➕ Book %s as an adjustment", formatSignedMoney(diff)), CallbackData: "rec:adjust:" + value}}}
+	if open > 0 {
+		rows = append(rows, []InlineKeyboardButton{{Text: "🔍 Review the entries", CallbackData: "rec:review:0"}})
+	}
+	sendMessageWithKeyboard(chatID, text, buildKeyboard(rows))
+}
+
+func showReconcileStatus(chatID int64) {
+	last, err := lastReconciliation()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the last reconciliation.")
+		reportError("loading the last reconciliation", err)
+		return
+	}
+	if last == "" {
+		sendMessage(chatID, "The ledger has never been reconciled.\n\n"+reconcileUsage)
+		return
+	}
+	open, err := unreconciledCount()
+	if err != nil {
+		sendMessage(chatID, "Failed to count the unreconciled transactions.")
+		reportError("counting unreconciled transactions", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("Last reconciled %s; %d transaction(s) since.\n\n%s", formatCreatedAt(last), open, reconcileUsage))
+}
+
+// handleReconcileCallback handles rec:adjust:<statement>,
+// rec:review:<after id>, rec:ok:<id> and rec:edit:<id>.
+func handleReconcileCallback(callback *CallbackQuery) {
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if parts[1] != "review" && isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+
+	if parts[1] == "adjust" {
+		statement, err := parseMoney(parts[2])
+		if err != nil {
+			_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+			return
+		}
+		_ = messenger.AnswerCallback(callback.ID, "")
+		bookAdjustment(chatID, messageID, statement)
+		return
+	}
+
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+	switch parts[1] {
+	case "ok":
+		if _, err := db.Exec("UPDATE transactions SET reconciled = 1 WHERE id = ?", id); err != nil {
+			sendMessage(chatID, "Failed to mark the transaction reconciled.")
+			reportError(fmt.Sprintf("reconciling transaction %d", id), err)
+			return
+		}
+		reviewNextUnreconciled(chatID, messageID, id)
+	case "review":
+		reviewNextUnreconciled(chatID, messageID, id)
+	case "edit":
+		startEditWithID(chatID, callback.From.ID, id)
+	}
+}
+
+// bookAdjustment adds the transaction that makes the ledger match
+// statement and records the reconciliation.
+func bookAdjustment(chatID int64, messageID int, statement Money) {
+	computed, err := cashBalance()
+	if err != nil {
+		sendMessage(chatID, "Failed to compute the balance.")
+		reportError("computing the balance to reconcile", err)
+		return
+	}
+	diff := statement - computed
+	if diff == 0 {
+		editMessage(chatID, messageID, "✅ The ledger already matches the statement; no adjustment is needed.")
+		return
+	}
+	typ := "income"
+	if diff < 0 {
+		typ = "expense"
+	}
+	if _, err := db.Exec("INSERT OR IGNORE INTO categories (name) VALUES (?)", adjustmentCategory); err != nil {
+		sendMessage(chatID, "Failed to book the adjustment.")
+		reportError("adding the adjustment category", err)
+		return
+	}
+	refreshCategories()
+	// an adjustment corrects the books: no spending to round up
+	id, err := store.AddTransaction(Transaction{Type: typ, Category: adjustmentCategory, Quantity: 1, Amount: diff.Abs(), Description: "Reconciliation adjustment", CreatedAt: appClock.Now(), IsOutlier: true, LedgerID: activeLedgerID()})
+	if err != nil {
+		sendMessage(chatID, "Failed to book the adjustment.")
+		reportError("booking a reconciliation adjustment", err)
+		return
+	}
+	if err := markReconciled(statement, computed, id); err != nil {
+		sendMessage(chatID, "Failed to record the reconciliation.")
+		reportError("recording a reconciliation", err)
+		return
+	}
+	editMessage(chatID, messageID, fmt.Sprintf("✅ Booked %s #%d of %s in %s; the ledger now matches %s and every transaction so far is marked reconciled.",
+		typ, id, formatMoney(diff.Abs()), adjustmentCategory, formatMoney(statement)))
+	transactionsChanged()
+}
+
+// reviewNextUnreconciled shows the first unreconciled transaction after
+// afterID in place of the previous one.
+func reviewNextUnreconciled(chatID int64, messageID int, afterID int64) {
+	var (
+		id          int64
+		typ         string
+		category    string
+		amount      Money
+		description sql.NullString
+		createdAt   string
+	)
+	err := db.QueryRow("SELECT id, type, category, amount, description, created_at FROM transactions WHERE reconciled = 0 AND id > ? AND "+ledgerScope()+" ORDER BY id LIMIT 1", afterID).
+		Scan(&id, &typ, &category, &amount, &description, &createdAt)
+	if err == sql.ErrNoRows {
+		editMessage(chatID, messageID, "🔍 No more unreconciled transactions. Run /reconcile again with the statement balance.")
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to load the next transaction.")
+		reportError("loading an unreconciled transaction", err)
+		return
+	}
+	var left int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE reconciled = 0 AND id >= ? AND "+ledgerScope(), id).Scan(&left); err != nil {
+		reportError("counting unreconciled transactions", err)
+	}
+
+	text := fmt.Sprintf("🔍 Unreconciled (%d left)\n\n#%d · %s\n%s · %s · %s", left, id, formatCreatedAt(createdAt), typ, category, formatMoney(amount))
+	if description.String != "" {
+		text += "\n" + description.String
+	}
+	idText := strconv.FormatInt(id, 10)
+	keyboard := buildKeyboard([][]InlineKeyboardButton{
+		{
+			{Text: "✅ Matches", CallbackData: "rec:ok:" + idText},
+			{Text: "⏭ Skip", CallbackData: "rec:review:" + idText},
+		},
+		{{Text: "✏️ Edit", CallbackData: "rec:edit:" + idText}},
+	})
+	editMessageWithKeyboard(chatID, messageID, text, keyboard)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	PANIC RECOVERY
+
+	Every update is dispatched through handleUpdate, which turns a panic in
+	a handler into a log entry with the stack, an apology to the user, a
+	note to the owner and a cleared conversation state, so one bad update
+	cannot take the bot down. Scheduled jobs are protected the same way.
+*/
+
+// activeUpdate holds the tags of the update being handled, for errors
+// reported while handling it.
+var activeUpdate struct {
+	sync.Mutex
+	tags map[string]string
+}
+
+// updateTags returns the error tracking tags of the update being handled.
+func updateTags() map[string]string {
+	activeUpdate.Lock()
+	defer activeUpdate.Unlock()
+	tags := make(map[string]string, len(activeUpdate.tags))
+	for k, v := range activeUpdate.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+func setActiveUpdate(tags map[string]string) {
+	activeUpdate.Lock()
+	activeUpdate.tags = tags
+	activeUpdate.Unlock()
+}
+
+// handleUpdate dispatches one update to its handler.
+func handleUpdate(update Update) {
+	setActiveUpdate(updateSourceTags(update))
+	defer setActiveUpdate(nil)
+	defer recoverUpdate(update)
+
+	if userID, _, _ := updateSource(update); userID != 0 {
+		lastUpdateAt[userID] = appClock.Now()
+	}
+	dispatchUpdate(update)
+}
+
+// updateSource returns the user and chat an update came from, and a short
+// description of it for logs.
+func updateSource(update Update) (int64, int64, string) {
+	var userID, chatID int64
+	what := fmt.Sprintf("update %d", update.UpdateID)
+	switch {
+	case update.Message != nil:
+		if update.Message.From != nil {
+			userID = update.Message.From.ID
+		}
+		if update.Message.Chat != nil {
+			chatID = update.Message.Chat.ID
+		}
+		if command, _ := parseCommand(update.Message.Text); command != "" {
+			what += " (/" + command + ")"
+		}
+	case update.EditedMessage != nil:
+		if update.EditedMessage.From != nil {
+			userID = update.EditedMessage.From.ID
+		}
+		if update.EditedMessage.Chat != nil {
+			chatID = update.EditedMessage.Chat.ID
+		}
+		what += " (edited message)"
+	case update.CallbackQuery != nil:
+		if update.CallbackQuery.From != nil {
+			userID = update.CallbackQuery.From.ID
+		}
+		if update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil {
+			chatID = update.CallbackQuery.Message.Chat.ID
+		}
+		what += fmt.Sprintf(" (button %q)", update.CallbackQuery.Data)
+	}
+	return userID, chatID, what
+}
+
+func updateSourceTags(update Update) map[string]string {
+	userID, _, _ := updateSource(update)
+	tags := map[string]string{"user_id": fmt.Sprint(userID)}
+	switch {
+	case update.Message != nil:
+		if command, _ := parseCommand(update.Message.Text); command != "" {
+			tags["command"] = command
+		}
+	case update.CallbackQuery != nil:
+		// the prefix names the flow; the rest may hold ids
+		tags["button"] = strings.SplitN(update.CallbackQuery.Data, ":", 2)[0]
+	}
+	return tags
+}
+
+func recoverUpdate(update Update) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	userID, chatID, what := updateSource(update)
+	stack := debug.Stack()
+	log.Printf("Panic while handling %s from user %d: %v\n%s", what, userID, p, stack)
+	errorReporter.CapturePanic(p, stack, updateSourceTags(update))
+
+	delete(userStates, userID)
+	if chatID != 0 {
+		sendMessage(chatID, "Sorry, something went wrong and the current action was canceled. Please try again.")
+	}
+	text := fmt.Sprintf("⚠️ Panic while handling %s from user %d: %v\nThe stack trace is in the server log.", what, userID, p)
+	if err := enqueueNotification(ownerID(), "panic", fmt.Sprintf("panic:%d:%d", update.UpdateID, time.Now().UnixNano()), text, nil); err != nil {
+		log.Printf("Failed to notify the owner of a panic: %v", err)
+	}
+}
+
+// runJobSafely runs a scheduled job, turning a panic into an error.
+func runJobSafely(job scheduledJob, now time.Time) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			stack := debug.Stack()
+			log.Printf("Panic in scheduled job %s: %v\n%s", job.name, p, stack)
+			errorReporter.CapturePanic(p, stack, map[string]string{"job": job.name})
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return job.run(now)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+	REFERENCES (/ref) and SEARCH (/search)
+
+	A transaction can carry any number of references: links to an online
+	order or an e-invoice, or plain invoice and order numbers.
+
+	/ref 42 https://shop.example/orders/981   attach a link
+	/ref 42 INV-2026-0193                     attach an invoice number
+	/ref 42                                   list the references of #42
+	/ref remove 7                             remove reference 7
+
+	They are listed in /view, and /search finds transactions by their
+	description, notes or references.
+*/
+
+const (
+	refUsage           = "Usage:\n/ref <id> <link or invoice number> - attach a reference\n/ref <id> - list the references of a transaction\n/ref remove <reference id> - remove a reference"
+	maxReferenceLength = 500
+	searchLimit        = 20
+)
+
+type transactionReference struct {
+	ID    int64
+	Kind  string // "link" or "number"
+	Value string
+}
+
+// referenceKind tells links from invoice and order numbers.
+func referenceKind(value string) string {
+	u, err := url.Parse(value)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return "link"
+	}
+	return "number"
+}
+
+func referenceIcon(kind string) string {
+	if kind == "link" {
+		return "🔗"
+	}
+	return "🧾"
+}
+
+func loadReferences(transactionID int64) ([]transactionReference, error) {
+	rows, err := db.Query("SELECT id, kind, value FROM transaction_references WHERE transaction_id = ? ORDER BY id", transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var refs []transactionReference
+	for rows.Next() {
+		var r transactionReference
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Value); err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+	return refs, rows.Err()
+}
+
+// referencesBlock formats refs for /view, or "" without references.
+func referencesBlock(refs []transactionReference) string {
+	if len(refs) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\nReferences:\n")
+	for _, r := range refs {
+		sb.WriteString(fmt.Sprintf("%s %s [%d]\n", referenceIcon(r.Kind), r.Value, r.ID))
+	}
+	return sb.String()
+}
+
+// transactionExists tells whether id is a live or an archived transaction.
+func transactionExists(id int64) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT (SELECT COUNT(*) FROM transactions WHERE id = ?) + (SELECT COUNT(*) FROM transactions_archive WHERE id = ?)", id, id).Scan(&n)
+	return n > 0, err
+}
+
+// handleRefCommand implements /ref.
+func handleRefCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) > 1 && isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+	if len(fields) == 2 && strings.EqualFold(fields[0], "remove") {
+		refID, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			sendMessage(chatID, refUsage)
+			return
+		}
+		res, err := db.Exec("DELETE FROM transaction_references WHERE id = ?", refID)
+		if err != nil {
+			sendMessage(chatID, "Failed to remove the reference.")
+			reportError("removing a reference", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("Reference %d not found.", refID))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Reference %d removed.", refID))
+		return
+	}
+	if len(fields) == 0 {
+		sendMessage(chatID, refUsage)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(fields[0], "#"), 10, 64)
+	if err != nil || id <= 0 {
+		sendMessage(chatID, refUsage)
+		return
+	}
+	exists, err := transactionExists(id)
+	if err != nil {
+		sendMessage(chatID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+	if !exists {
+		sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
+		return
+	}
+
+	value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args), fields[0]))
+	if value == "" {
+		refs, err := loadReferences(id)
+		if err != nil {
+			sendMessage(chatID, "Failed to load the references.")
+			reportError("loading references", err)
+			return
+		}
+		if len(refs) == 0 {
+			sendMessage(chatID, fmt.Sprintf("Transaction #%d has no references.\n\n%s", id, refUsage))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d\n%s", id, strings.TrimRight(referencesBlock(refs), "\n")))
+		return
+	}
+	if utf8.RuneCountInString(value) > maxReferenceLength {
+		sendMessage(chatID, fmt.Sprintf("Reference too long. Please keep it under %d characters.", maxReferenceLength))
+		return
+	}
+
+	kind := referenceKind(value)
+	res, err := db.Exec("INSERT INTO transaction_references (transaction_id, kind, value) VALUES (?, ?, ?)", id, kind, value)
+	if err != nil {
+		sendMessage(chatID, "Failed to attach the reference.")
+		reportError("attaching a reference", err)
+		return
+	}
+	refID, _ := res.LastInsertId()
+	sendMessage(chatID, fmt.Sprintf("%s Reference %d attached to transaction #%d.", referenceIcon(kind), refID, id))
+}
+
+// handleSearchCommand implements /search <text>, matching descriptions,
+// notes and references of live transactions, newest first.
+func handleSearchCommand(chatID int64, args string) {
+	query := strings.TrimSpace(args)
+	if query == "" {
+		sendMessage(chatID, "Usage: /search <text>, e.g. /search INV-2026 or /search laptop")
+		return
+	}
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := db.Query(`SELECT t.id, t.type, t.category, t.amount, t.description, t.created_at,
+			(SELECT r.value FROM transaction_references r WHERE r.transaction_id = t.id AND r.value LIKE ?1 ESCAPE '\' LIMIT 1)
+		FROM (SELECT * FROM transactions WHERE `+ledgerScope()+`) t
+		WHERE t.description LIKE ?1 ESCAPE '\' OR t.notes LIKE ?1 ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM transaction_references r WHERE r.transaction_id = t.id AND r.value LIKE ?1 ESCAPE '\')
+		ORDER BY t.created_at DESC, t.id DESC LIMIT ?2`, pattern, searchLimit+1)
+	if err != nil {
+		sendMessage(chatID, "Failed to search the transactions.")
+		reportError("searching transactions", err)
+		return
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var (
+			id          int64
+			typ         string
+			category    string
+			amount      Money
+			description sql.NullString
+			createdAt   string
+			reference   sql.NullString
+		)
+		if err := rows.Scan(&id, &typ, &category, &amount, &description, &createdAt, &reference); err != nil {
+			rows.Close()
+			sendMessage(chatID, "Failed to search the transactions.")
+			reportError("reading search results", err)
+			return
+		}
+		line := fmt.Sprintf("#%d · %s · %s %s %s", id, formatCreatedAt(createdAt), typ, category, formatMoney(amount))
+		if description.String != "" {
+			line += " · " + description.String
+		}
+		if reference.Valid {
+			line += fmt.Sprintf("\n   %s %s", referenceIcon(referenceKind(reference.String)), reference.String)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		sendMessage(chatID, "Failed to search the transactions.")
+		reportError("reading search results", err)
+		return
+	}
+
+	if len(lines) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No transactions match %q.", query))
+		return
+	}
+	header := fmt.Sprintf("🔎 %d transaction(s) matching %q", len(lines), query)
+	if len(lines) > searchLimit {
+		lines = lines[:searchLimit]
+		header = fmt.Sprintf("🔎 The %d newest transactions matching %q", searchLimit, query)
+	}
+	sendMessage(chatID, header+"\n\n"+strings.Join(lines, "\n")+"\n\nSee one in full with /view <id>.")
+}
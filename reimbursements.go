@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	REIMBURSEMENTS (/reimburse, /reimbursements)
+
+	An expense paid on someone else's behalf, by an employer or a friend,
+	can be flagged reimbursable with the payer who owes it:
+
+	/reimburse 42 Acme          expense #42 is owed by Acme
+	/reimburse 42 off           no longer reimbursable
+	/reimbursements             outstanding amounts grouped by payer
+
+	"Mark reimbursed" records the money coming back as income in the same
+	category and ledger as the expense, and links the pair: the expense
+	keeps the id of the income in reimbursement_id, and /view shows the
+	link on both.
+*/
+
+const reimburseUsage = "Usage:\n/reimburse <id> <payer> - flag an expense as reimbursable\n/reimburse <id> off - clear the flag\n/reimbursements - outstanding amounts by payer"
+
+// reimbursementTrigger makes an expense outstanding again when the income
+// that paid it back is deleted; moving it to the archive keeps the link.
+const reimbursementTrigger = `CREATE TRIGGER IF NOT EXISTS transactions_reimbursement_delete AFTER DELETE ON transactions
+	WHEN NOT EXISTS (SELECT 1 FROM transactions_archive WHERE id = OLD.id)
+	BEGIN
+		UPDATE transactions SET reimbursement_id = NULL WHERE reimbursement_id = OLD.id;
+	END`
+
+// reimbursementButtons caps the "Mark reimbursed" buttons under the list.
+const reimbursementButtons = 20
+
+type reimbursement struct {
+	ID          int64
+	Payer       string
+	Category    string
+	Amount      Money // quantity × amount
+	Description string
+	CreatedAt   string
+}
+
+// reimbursementLine describes the reimbursement side of transaction id for
+// its details, or "" when it has none.
+func reimbursementLine(id int64) string {
+	var payer sql.NullString
+	var paidBy sql.NullInt64
+	if err := db.QueryRow("SELECT reimbursable_by, reimbursement_id FROM transactions WHERE id = ?", id).Scan(&payer, &paidBy); err != nil {
+		return ""
+	}
+	if payer.Valid {
+		if paidBy.Valid {
+			return fmt.Sprintf("Reimbursed by %s: #%d", payer.String, paidBy.Int64)
+		}
+		return fmt.Sprintf("Reimbursable by %s: outstanding", payer.String)
+	}
+	var expenseID int64
+	if err := db.QueryRow("SELECT id FROM transactions WHERE reimbursement_id = ?", id).Scan(&expenseID); err == nil {
+		return fmt.Sprintf("Reimburses: #%d", expenseID)
+	}
+	return ""
+}
+
+// handleReimburseCommand implements /reimburse <id> <payer|off>.
+func handleReimburseCommand(chatID int64, userID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		sendMessage(chatID, reimburseUsage)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(fields[0], "#"), 10, 64)
+	if err != nil || id <= 0 {
+		sendMessage(chatID, reimburseUsage)
+		return
+	}
+	payer := strings.Join(fields[1:], " ")
+	if len([]rune(payer)) > 50 {
+		sendMessage(chatID, "A payer name has at most 50 characters.")
+		return
+	}
+
+	var typ, createdAt string
+	var paidBy sql.NullInt64
+	err = db.QueryRow("SELECT type, created_at, reimbursement_id FROM transactions WHERE id = ? AND "+ledgerScope(), id).Scan(&typ, &createdAt, &paidBy)
+	if err == sql.ErrNoRows {
+		sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+	if typ != "expense" {
+		sendMessage(chatID, "Only expenses can be reimbursable.")
+		return
+	}
+	if paidBy.Valid {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d was already reimbursed (#%d).", id, paidBy.Int64))
+		return
+	}
+	if !allowChange(chatID, userID, id, createdAt) {
+		return
+	}
+
+	if len(fields) == 2 && strings.EqualFold(payer, "off") {
+		if _, err := db.Exec("UPDATE transactions SET reimbursable_by = NULL WHERE id = ?", id); err != nil {
+			sendMessage(chatID, "Failed to update the transaction.")
+			reportError("clearing a reimbursable flag", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d is no longer reimbursable.", id))
+		return
+	}
+	if _, err := db.Exec("UPDATE transactions SET reimbursable_by = ? WHERE id = ?", payer, id); err != nil {
+		sendMessage(chatID, "Failed to update the transaction.")
+		reportError("flagging a transaction reimbursable", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("💸 Transaction #%d is reimbursable by %s. See /reimbursements.", id, payer))
+}
+
+// outstandingReimbursements returns the reimbursable expenses not yet paid
+// back, grouped by payer.
+func outstandingReimbursements() ([]reimbursement, error) {
+	rows, err := db.Query(`SELECT id, reimbursable_by, category, ` + lineTotal + `, COALESCE(description, ''), created_at
+		FROM transactions WHERE reimbursable_by IS NOT NULL AND reimbursement_id IS NULL AND type = 'expense' AND ` + ledgerScope() + `
+		ORDER BY reimbursable_by COLLATE NOCASE, created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []reimbursement
+	for rows.Next() {
+		var r reimbursement
+		if err := rows.Scan(&r.ID, &r.Payer, &r.Category, &r.Amount, &r.Description, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+func showReimbursements(chatID int64) {
+	outstanding, err := outstandingReimbursements()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the reimbursements.")
+		reportError("loading reimbursements", err)
+		return
+	}
+	if len(outstanding) == 0 {
+		sendMessage(chatID, "No outstanding reimbursements. Flag an expense with /reimburse <id> <payer>.")
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("💸 Outstanding reimbursements\n")
+	var total Money
+	var buttons [][]InlineKeyboardButton
+	for i, r := range outstanding {
+		if i == 0 || !strings.EqualFold(r.Payer, outstanding[i-1].Payer) {
+			var subtotal Money
+			for _, o := range outstanding[i:] {
+				if !strings.EqualFold(o.Payer, r.Payer) {
+					break
+				}
+				subtotal += o.Amount
+			}
+			sb.WriteString(fmt.Sprintf("\n%s: %s\n", r.Payer, formatMoney(subtotal)))
+		}
+		date := r.CreatedAt
+		if t, err := parseCreatedAt(r.CreatedAt); err == nil {
+			date = t.Format("2 Jan")
+		}
+		sb.WriteString(fmt.Sprintf("• #%d %s %s %s\n", r.ID, date, formatMoney(r.Amount), truncateRunes(r.Description, 40)))
+		total += r.Amount
+		if len(buttons) < reimbursementButtons {
+			buttons = append(buttons, []InlineKeyboardButton{{
+				Text:         truncateRunes(fmt.Sprintf("✅ #%d %s from %s", r.ID, formatMoney(r.Amount), r.Payer), 60),
+				CallbackData: fmt.Sprintf("reimb:paid:%d", r.ID),
+			}})
+		}
+	}
+	sb.WriteString(fmt.Sprintf("\nTotal owed: %s", formatMoney(total)))
+	sendMessageWithKeyboard(chatID, sb.String(), buildKeyboard(buttons))
+}
+
+// markReimbursed records the income paying back expense id and links the
+// two.
+func markReimbursed(id int64) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var r reimbursement
+	var payer sql.NullString
+	var paidBy sql.NullInt64
+	var ledgerID int64
+	err = tx.QueryRow("SELECT id, reimbursable_by, reimbursement_id, category, "+lineTotal+", COALESCE(description, ''), ledger_id FROM transactions WHERE id = ? AND type = 'expense'", id).
+		Scan(&r.ID, &payer, &paidBy, &r.Category, &r.Amount, &r.Description, &ledgerID)
+	if err == sql.ErrNoRows {
+		return fmt.Sprintf("Transaction #%d no longer exists.", id), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if paidBy.Valid {
+		return fmt.Sprintf("Transaction #%d was already reimbursed (#%d).", id, paidBy.Int64), nil
+	}
+	if !payer.Valid {
+		return fmt.Sprintf("Transaction #%d is no longer reimbursable.", id), nil
+	}
+	r.Payer = payer.String
+
+	description := fmt.Sprintf("Reimbursement of #%d · %s", r.ID, r.Payer)
+	incomeID, err := addTransactionTx(tx, Transaction{Type: "income", Category: r.Category, Quantity: 1, Amount: r.Amount, Description: truncateRunes(description, 100), CreatedAt: appClock.Now(), LedgerID: ledgerID})
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE transactions SET reimbursement_id = ? WHERE id = ?", incomeID, r.ID); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	transactionsChanged()
+	return fmt.Sprintf("✅ #%d reimbursed by %s: %s recorded as income under %s (#%d).", r.ID, r.Payer, formatMoney(r.Amount), r.Category, incomeID), nil
+}
+
+// handleReimbursementCallback handles the "Mark reimbursed" buttons:
+// reimb:paid:<id>.
+func handleReimbursementCallback(callback *CallbackQuery) {
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 || parts[1] != "paid" {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	text, err := markReimbursed(id)
+	if err != nil {
+		reportError(fmt.Sprintf("reimbursing transaction %d", id), err)
+		sendMessage(callback.Message.Chat.ID, "Failed to record the reimbursement.")
+		return
+	}
+	sendMessage(callback.Message.Chat.ID, text)
+}
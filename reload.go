@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+/*
+	HOT RELOAD
+
+	/reload (owner only) and SIGHUP re-read the configuration from the
+	same sources as on startup (config file, environment, flags) and
+	reload the categories from the database, without a restart: the
+	conversations in progress are kept.
+
+	A configuration with problems is rejected as a whole and the running
+	one stays in place. Most settings take effect right away: the allowed
+	users, locale, currency, time zone, week start, schedules, features,
+	price, AI, S3 and IMAP settings. The ones that shape the running process
+	(token, database, data directory, Sentry, replica interval) need a
+	restart; the reload keeps their running values and says so. The new
+	configuration replaces the running one in a single step (runningConfig
+	in config.go), so the API server and the background workers see either
+	the old one or the new one, never a mix.
+
+	A SIGHUP is handled by the update loop between two polls, so it
+	takes effect within a minute and never in the middle of a command.
+*/
+
+// configSource is where the running configuration was loaded from.
+var configSource struct {
+	path     string
+	dataFlag string
+}
+
+// reloadRequests asks the update loop to reload, on SIGHUP.
+var reloadRequests = make(chan struct{}, 1)
+
+// watchReloadSignal turns SIGHUP into a reload request.
+func watchReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			select {
+			case reloadRequests <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
+
+// reloadIfRequested reloads after a SIGHUP. It runs on the update loop.
+func reloadIfRequested() {
+	select {
+	case <-reloadRequests:
+	default:
+		return
+	}
+	summary, err := reloadConfig()
+	if err != nil {
+		log.Printf("reload status=failed error=%q", err.Error())
+		return
+	}
+	log.Printf("reload status=ok %s", strings.ReplaceAll(summary, "\n", " "))
+}
+
+// restartOnlySettings lists the settings a reload cannot change, with a
+// way to read them from a Config.
+var restartOnlySettings = []struct {
+	name  string
+	value func(*Config) string
+}{
+	{"token", func(c *Config) string { return c.Token }},
+	{"db.path", func(c *Config) string { return c.DBPath }},
+	{"db.key", func(c *Config) string { return c.DBKey }},
+	{"data_dir", func(c *Config) string { return c.DataDir }},
+	{"sentry.dsn", func(c *Config) string { return c.SentryDSN }},
+	{"sentry.environment", func(c *Config) string { return c.SentryEnv }},
+	{"s3.replica_interval", func(c *Config) string { return fmt.Sprint(c.S3ReplicaInterval) }},
+	{"api.listen", func(c *Config) string { return c.APIListen }},
+}
+
+// reloadConfig loads the configuration again and applies it, along with
+// the categories. It returns a summary of the result, or the problems
+// found, in which case nothing changed.
+func reloadConfig() (string, error) {
+	cfg, problems := loadConfig(configSource.path, configSource.dataFlag)
+	validateServeConfig(cfg, problems)
+	if len(problems.Problems) > 0 {
+		return "", fmt.Errorf("%d configuration problem(s) found:\n  - %s", len(problems.Problems), strings.Join(problems.Problems, "\n  - "))
+	}
+	cats, err := loadCategories(db)
+	if err != nil {
+		return "", fmt.Errorf("loading the categories: %w", err)
+	}
+	archived, err := archivedCategories()
+	if err != nil {
+		return "", fmt.Errorf("loading the categories: %w", err)
+	}
+
+	old := currentConfig()
+	var pending []string
+	for _, s := range restartOnlySettings {
+		if s.value(cfg) != s.value(old) {
+			pending = append(pending, s.name)
+		}
+	}
+	cfg.Token, cfg.DBPath, cfg.DBKey, cfg.DataDir = old.Token, old.DBPath, old.DBKey, old.DataDir
+	cfg.SentryDSN, cfg.SentryEnv, cfg.S3ReplicaInterval, cfg.APIListen = old.SentryDSN, old.SentryEnv, old.S3ReplicaInterval, old.APIListen
+
+	setRunningConfig(cfg, cfg.AllowedUsers[0], configuredLocation(cfg))
+	categoriesCache.set(cats, archived)
+	refreshCurrency()
+
+	summary := fmt.Sprintf("%d allowed user(s), %d categories, time zone %s, locale %s, amounts like %s.",
+		len(cfg.AllowedUsers), len(cats), appLocation(), cfg.Locale, formatMoney(123456789))
+	if len(pending) > 0 {
+		summary += "\nChanged but only applied after a restart: " + strings.Join(pending, ", ") + "."
+	}
+	return summary, nil
+}
+
+// handleReloadCommand implements /reload.
+func handleReloadCommand(chatID int64) {
+	summary, err := reloadConfig()
+	if err != nil {
+		sendMessage(chatID, "Reload failed, the running configuration is unchanged.\n\n"+err.Error())
+		return
+	}
+	log.Printf("reload status=ok %s", strings.ReplaceAll(summary, "\n", " "))
+	sendMessage(chatID, "🔄 Configuration reloaded: "+summary)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestReloadWhileWorkersRead(t *testing.T) {
+	newHarness(t)
+	saved := configSource
+	t.Cleanup(func() { configSource = saved })
+	configSource.path, configSource.dataFlag = "", filepath.Join(t.TempDir(), "ayunda.db")
+	t.Setenv("API_TOKEN", "123456:abcdefghijklmnopqrstuvwxyz0123456789")
+	t.Setenv("ALLOWED_USER_ID", strconv.FormatInt(harnessUserID, 10))
+	t.Setenv("TIMEZONE", "Asia/Jakarta")
+
+	// the API server, scheduler and outbox keep reading while /reload runs;
+	// go test -race reports it if the configuration is swapped unsafely
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			isAllowedUser(harnessUserID)
+			featureEnabled("error_alerts")
+			apiConfigured()
+			appClock.Now().In(appLocation())
+		}
+	}()
+	for range 5 {
+		if _, err := reloadConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if !isAllowedUser(harnessUserID) || ownerID() != harnessUserID {
+		t.Errorf("owner is %d after the reload, want %d", ownerID(), harnessUserID)
+	}
+	if got := appLocation().String(); got != "Asia/Jakarta" {
+		t.Errorf("time zone is %s after the reload, want Asia/Jakarta", got)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	CONTINUOUS REPLICATION
+
+	With s3.replica_interval (S3_REPLICA_INTERVAL) set to a number of
+	seconds, the bot keeps a replica of the database in the [s3] bucket,
+	at <prefix>replica/ayunda.db: every interval it checks whether anything
+	was written since the last upload and, if so, uploads a fresh
+	consistent copy. A crashed host loses at most the last interval of
+	changes; "ayunda replica -o restored.db" downloads the replica to start
+	again from it.
+
+	Unlike Litestream this ships whole snapshots, not WAL frames: the bot
+	holds a single connection and cannot keep the read transaction open
+	that WAL shipping needs to stop checkpoints. A personal ledger is a few
+	megabytes, so a snapshot every few seconds while it is being written to
+	is cheap, and idle periods upload nothing. The replica is sealed with
+	BACKUP_KEY when it is set, which costs a key derivation per upload.
+*/
+
+// replicaRetryDelay spaces the attempts after a failed upload.
+const replicaRetryDelay = time.Minute
+
+func replicaConfigured() bool {
+	return offsiteConfigured() && currentConfig().S3ReplicaInterval > 0
+}
+
+func replicaKey() string {
+	key := currentConfig().S3Prefix + "replica/ayunda.db"
+	if os.Getenv("BACKUP_KEY") != "" {
+		key += encryptedBackupSuffix
+	}
+	return key
+}
+
+// databaseChanges counts the rows written on the connection since it was
+// opened; the bot has only one.
+func databaseChanges() (int64, error) {
+	var n int64
+	err := db.QueryRow("SELECT total_changes()").Scan(&n)
+	return n, err
+}
+
+// uploadReplica uploads a consistent copy of the database as the replica.
+func uploadReplica() error {
+	dir, err := os.MkdirTemp(dataSubdir("backups"), "ayunda-replica-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ayunda.db")
+	if err := backupDB(path, os.Getenv("BACKUP_KEY")); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return newS3Client(currentConfig()).putFile(replicaKey(), path)
+}
+
+// runReplication uploads the replica whenever the database changed, for
+// as long as the bot runs.
+func runReplication() {
+	if !replicaConfigured() {
+		return
+	}
+	interval := time.Duration(currentConfig().S3ReplicaInterval) * time.Second
+	log.Printf("Replicating the database to %s/%s every %s", currentConfig().S3Bucket, replicaKey(), interval)
+	uploaded := int64(-1)
+	for {
+		changes, err := databaseChanges()
+		if err == nil && changes != uploaded {
+			start := time.Now()
+			if err = uploadReplica(); err == nil {
+				uploaded = changes
+				if dbKey != "" {
+					// sqlcipher_export writes through this connection too
+					uploaded, err = databaseChanges()
+				}
+				log.Printf("replica status=ok changes=%d duration=%s", changes, time.Since(start).Round(time.Millisecond))
+			}
+		}
+		if err != nil {
+			reportErrorTagged("replicating the database", err, map[string]string{"job": "replica"})
+			time.Sleep(replicaRetryDelay)
+			continue
+		}
+		time.Sleep(interval)
+	}
+}
+
+func cmdReplica(args []string) error {
+	fs := newCommandFlags("replica", "[-o restored.db]")
+	output := fs.String("o", "", "Download the replica to this file (decrypted with $BACKUP_KEY)")
+	if err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+	if !offsiteConfigured() {
+		return fmt.Errorf("no bucket configured: set [s3] bucket or S3_BUCKET")
+	}
+	client := newS3Client(currentConfig())
+	objects, err := client.listObjects(currentConfig().S3Prefix + "replica/")
+	if err != nil {
+		return err
+	}
+	var replica *s3Object
+	for i, o := range objects {
+		if strings.HasPrefix(strings.TrimPrefix(o.Key, currentConfig().S3Prefix+"replica/"), "ayunda.db") &&
+			(replica == nil || o.LastModified.After(replica.LastModified)) {
+			replica = &objects[i]
+		}
+	}
+	if replica == nil {
+		return fmt.Errorf("no replica in %s/%sreplica/", currentConfig().S3Bucket, currentConfig().S3Prefix)
+	}
+	fmt.Printf("Replica %s: %s, uploaded %s\n", replica.Key, formatBytes(replica.Size), replica.LastModified.In(appLocation()).Format("2 Jan 2006 15:04:05"))
+	if *output == "" {
+		return nil
+	}
+
+	if !strings.HasSuffix(replica.Key, encryptedBackupSuffix) {
+		if err := client.getFile(replica.Key, *output); err != nil {
+			return err
+		}
+		fmt.Printf("Downloaded to %s.\n", *output)
+		return nil
+	}
+	backupKey := os.Getenv("BACKUP_KEY")
+	if backupKey == "" {
+		return fmt.Errorf("the replica is encrypted: set BACKUP_KEY")
+	}
+	sealed := *output + encryptedBackupSuffix
+	if err := client.getFile(replica.Key, sealed); err != nil {
+		return err
+	}
+	defer os.Remove(sealed)
+	if err := decryptBackup(sealed, *output, backupKey); err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded and decrypted to %s.\n", *output)
+	return nil
+}
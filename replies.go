@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+	AMENDING a saved transaction from the chat
+
+	The confirmation sent when a transaction is saved is remembered in
+	transaction_messages. Replying to it with a number offers to correct the
+	amount, replying with other text offers to replace the description.
+	Editing the messages that gave the amount or the description of the last
+	transaction does the same (updates.go). Either way nothing changes until
+	the Update button is pressed.
+*/
+
+// pendingAmend is a change waiting for the user's confirmation.
+type pendingAmend struct {
+	TransactionID int64
+	Field         string // "amount" or "description"
+	Value         string
+}
+
+var pendingAmends = make(map[int64]*pendingAmend)
+
+// rememberTransactionMessage maps a message about a transaction to it, so
+// replies to the message can amend the transaction.
+func rememberTransactionMessage(chatID int64, messageID int, transactionID int64) {
+	if messageID == 0 {
+		return
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO transaction_messages (chat_id, message_id, transaction_id) VALUES (?, ?, ?)",
+		chatID, messageID, transactionID); err != nil {
+		log.Printf("Failed to remember message %d of transaction %d: %v", messageID, transactionID, err)
+	}
+}
+
+// handleTransactionReply handles a reply to the confirmation of a saved
+// transaction. It returns false when the replied message is not one.
+func handleTransactionReply(message *TGMessage) bool {
+	var id int64
+	err := db.QueryRow("SELECT transaction_id FROM transaction_messages WHERE chat_id = ? AND message_id = ?",
+		message.Chat.ID, message.ReplyToMessage.MessageID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		reportError("looking up a replied message", err)
+		return false
+	}
+
+	text := strings.TrimSpace(message.Text)
+	if amount, err := parseMoney(text); err == nil {
+		if amount <= 0 {
+			sendMessage(message.Chat.ID, "The amount must be a positive number; the transaction was not changed.")
+			return true
+		}
+		offerAmend(message.Chat.ID, message.From.ID, id, "amount", amount.Plain())
+		return true
+	}
+	if text == "" || len(text) > 100 {
+		sendMessage(message.Chat.ID, "Reply with a new amount, or a description of 1 to 100 characters.")
+		return true
+	}
+	offerAmend(message.Chat.ID, message.From.ID, id, "description", text)
+	return true
+}
+
+// offerAmend asks the user to confirm changing field of transaction id to
+// value.
+func offerAmend(chatID int64, userID int64, id int64, field string, value string) {
+	var amount Money
+	var description sql.NullString
+	var createdAt string
+	err := db.QueryRow("SELECT amount, description, created_at FROM transactions WHERE id = ?", id).Scan(&amount, &description, &createdAt)
+	if err == sql.ErrNoRows {
+		sendMessage(chatID, fmt.Sprintf("Transaction #%d no longer exists; nothing was changed.", id))
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to load the transaction.")
+		reportError(fmt.Sprintf("loading transaction %d to amend it", id), err)
+		return
+	}
+	current := description.String
+	if field == "amount" {
+		current = amount.Plain()
+	}
+	if value == current {
+		return
+	}
+	if !allowChange(chatID, userID, id, createdAt) {
+		return
+	}
+
+	pendingAmends[userID] = &pendingAmend{TransactionID: id, Field: field, Value: value}
+	idText := strconv.FormatInt(id, 10)
+	keyboard := buildKeyboard([][]InlineKeyboardButton{{
+		{Text: "✅ Update", CallbackData: "amend:yes:" + idText},
+		{Text: "Keep", CallbackData: "amend:no:" + idText},
+	}})
+	sendMessageWithKeyboard(chatID, fmt.Sprintf("✏️ Change the %s of transaction #%d from %q to %q?", field, id, current, value), keyboard)
+}
+
+// handleAmendCallback applies or drops the change offered by offerAmend.
+func handleAmendCallback(callback *CallbackQuery) {
+	parts := strings.Split(callback.Data, ":")
+	amend, ok := pendingAmends[callback.From.ID]
+	if len(parts) != 3 || !ok || parts[2] != strconv.FormatInt(amend.TransactionID, 10) {
+		_ = messenger.AnswerCallback(callback.ID, "This change is no longer pending.")
+		return
+	}
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	if parts[1] != "yes" {
+		delete(pendingAmends, callback.From.ID)
+		_ = messenger.AnswerCallback(callback.ID, "")
+		editMessage(chatID, messageID, fmt.Sprintf("Transaction #%d was kept as it was.", amend.TransactionID))
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	delete(pendingAmends, callback.From.ID)
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	var value interface{} = amend.Value
+	if amend.Field == "amount" {
+		value, _ = parseMoney(amend.Value)
+	}
+	// Field is one of two fixed column names
+	res, err := db.Exec("UPDATE transactions SET "+amend.Field+" = ? WHERE id = ?", value, amend.TransactionID)
+	if err != nil {
+		sendMessage(chatID, "Failed to update the transaction.")
+		reportError(fmt.Sprintf("amending transaction %d", amend.TransactionID), err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		editMessage(chatID, messageID, fmt.Sprintf("Transaction #%d no longer exists; nothing was changed.", amend.TransactionID))
+		return
+	}
+	editMessage(chatID, messageID, fmt.Sprintf("✏️ The %s of #%d is now %q.", amend.Field, amend.TransactionID, amend.Value))
+	transactionsChanged()
+}
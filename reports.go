@@ -0,0 +1,584 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	CUSTOM REPORTS (/report)
+
+	/report walks through a builder with buttons: period, categories,
+	types, grouping (category, week or payee, i.e. the description) and
+	output (text, chart or CSV). The result can be saved under a name in
+	saved_reports; "/report <name>" reruns it, with relative periods such
+	as "this week" resolved again on every run.
+
+	/report list          saved reports
+	/report delete <name> forget one
+*/
+
+const reportChartScript = "src/g_report_chart.py"
+
+// reportSpec describes a custom report; it is stored as JSON.
+type reportSpec struct {
+	Period     string   `json:"period"`
+	Categories []string `json:"categories,omitempty"`
+	Type       string   `json:"type"`     // expense, income or both
+	GroupBy    string   `json:"group_by"` // category, week or payee
+	Format     string   `json:"format"`   // text, chart or csv
+}
+
+// reportPeriods are the relative periods offered by the builder, resolved
+// when the report runs.
+var reportPeriods = []struct{ key, label string }{
+	{"this_week", "This week"},
+	{"last_week", "Last week"},
+	{"this_month", "This month"},
+	{"last_month", "Last month"},
+	{"last_30_days", "Last 30 days"},
+	{"this_year", "This year"},
+}
+
+var (
+	reportTypes   = []struct{ key, label string }{{"expense", "Expenses"}, {"income", "Income"}, {"both", "Both"}}
+	reportGroups  = []struct{ key, label string }{{"category", "Category"}, {"week", "Week"}, {"payee", "Payee"}}
+	reportFormats = []struct{ key, label string }{{"text", "Text"}, {"chart", "Chart"}, {"csv", "CSV"}}
+)
+
+var reportNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// resolveReportPeriod turns a relative period key, or anything
+// parsePeriod accepts, into [from, to) and a label.
+func resolveReportPeriod(period string, now time.Time) (time.Time, time.Time, string, error) {
+	today, tomorrow := dayBounds(now)
+	thisWeek, nextWeek := weekBounds(now, weekStartDay())
+	thisMonth, nextMonth := monthBounds(now)
+	switch period {
+	case "this_week":
+		return thisWeek, nextWeek, "this week (from " + thisWeek.Format("2 Jan") + ")", nil
+	case "last_week":
+		lastWeek := thisWeek.AddDate(0, 0, -7)
+		return lastWeek, thisWeek, "last week (" + lastWeek.Format("2 Jan") + " – " + thisWeek.AddDate(0, 0, -1).Format("2 Jan") + ")", nil
+	case "this_month":
+		return thisMonth, nextMonth, thisMonth.Format("January 2006"), nil
+	case "last_month":
+		lastMonth := thisMonth.AddDate(0, -1, 0)
+		return lastMonth, thisMonth, lastMonth.Format("January 2006"), nil
+	case "last_30_days":
+		return today.AddDate(0, 0, -29), tomorrow, "the last 30 days", nil
+	case "this_year":
+		year := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+		return year, year.AddDate(1, 0, 0), year.Format("2006"), nil
+	}
+	return parsePeriod(period, now)
+}
+
+func labelOf(options []struct{ key, label string }, key string) string {
+	for _, o := range options {
+		if o.key == key {
+			return o.label
+		}
+	}
+	return key
+}
+
+// describe summarizes a spec in one line, e.g.
+// "This month · Expenses · Food · by category · chart".
+func (spec reportSpec) describe() string {
+	categories := "all categories"
+	if len(spec.Categories) > 0 {
+		categories = strings.Join(spec.Categories, ", ")
+	}
+	return fmt.Sprintf("%s · %s · %s · by %s · %s", labelOf(reportPeriods, spec.Period), labelOf(reportTypes, spec.Type),
+		categories, spec.GroupBy, strings.ToLower(labelOf(reportFormats, spec.Format)))
+}
+
+func (spec reportSpec) hasCategory(name string) bool {
+	for _, c := range spec.Categories {
+		if strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return len(spec.Categories) == 0
+}
+
+type reportRow struct {
+	Key     string
+	Income  Money
+	Expense Money
+}
+
+type reportResult struct {
+	Title   string
+	Spec    reportSpec
+	Label   string
+	Rows    []reportRow
+	Income  Money
+	Expense Money
+}
+
+// runReport collects the rows of a report.
+func runReport(title string, spec reportSpec, now time.Time) (reportResult, error) {
+	result := reportResult{Title: title, Spec: spec}
+	from, to, label, err := resolveReportPeriod(spec.Period, now)
+	if err != nil {
+		return result, err
+	}
+	result.Label = label
+	entries, err := loadEntries(from, to)
+	if err != nil {
+		return result, err
+	}
+
+	first := weekStartDay()
+	rows := make(map[string]*reportRow)
+	var keys []string
+	for _, e := range entries {
+		if (spec.Type != "both" && e.Type != spec.Type) || !spec.hasCategory(e.Category) {
+			continue
+		}
+		var key, display string
+		switch spec.GroupBy {
+		case "week":
+			start, _ := weekBounds(e.CreatedAt, first)
+			key, display = start.Format(dateLayout), "Week of "+start.Format("2 Jan")
+		case "payee":
+			display = strings.TrimSpace(e.Description)
+			if display == "" {
+				display = "(no description)"
+			}
+			key = strings.ToLower(display)
+		default:
+			key, display = e.Category, e.Category
+		}
+		row, ok := rows[key]
+		if !ok {
+			row = &reportRow{Key: display}
+			rows[key] = row
+			keys = append(keys, key)
+		}
+		if e.Type == "income" {
+			row.Income += e.Amount
+			result.Income += e.Amount
+		} else {
+			row.Expense += e.Amount
+			result.Expense += e.Amount
+		}
+	}
+
+	// weeks read best in order, the rest largest first
+	if spec.GroupBy == "week" {
+		sort.Strings(keys)
+	} else {
+		sort.SliceStable(keys, func(i, j int) bool {
+			a, b := rows[keys[i]], rows[keys[j]]
+			return a.Income+a.Expense > b.Income+b.Expense
+		})
+	}
+	for _, key := range keys {
+		result.Rows = append(result.Rows, *rows[key])
+	}
+	return result, nil
+}
+
+func reportText(r reportResult) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📋 %s, %s\n%s\n\n", r.Title, r.Label, r.Spec.describe()))
+	if len(r.Rows) == 0 {
+		sb.WriteString("No matching transactions.")
+		return sb.String()
+	}
+	for _, row := range r.Rows {
+		switch r.Spec.Type {
+		case "income":
+			sb.WriteString(fmt.Sprintf("• %s: %s (%.0f%%)\n", row.Key, formatMoney(row.Income), percentOf(row.Income.Float(), r.Income.Float())))
+		case "expense":
+			sb.WriteString(fmt.Sprintf("• %s: %s (%.0f%%)\n", row.Key, formatMoney(row.Expense), percentOf(row.Expense.Float(), r.Expense.Float())))
+		default:
+			sb.WriteString(fmt.Sprintf("• %s: +%s / -%s\n", row.Key, formatMoney(row.Income), formatMoney(row.Expense)))
+		}
+	}
+	switch r.Spec.Type {
+	case "income":
+		sb.WriteString(fmt.Sprintf("\nTotal: %s", formatMoney(r.Income)))
+	case "expense":
+		sb.WriteString(fmt.Sprintf("\nTotal: %s", formatMoney(r.Expense)))
+	default:
+		sb.WriteString(fmt.Sprintf("\nIncome: %s\nExpense: %s\nNet: %s", formatMoney(r.Income), formatMoney(r.Expense), formatMoney(r.Income-r.Expense)))
+	}
+	return sb.String()
+}
+
+func writeReportCSV(w io.Writer, r reportResult) error {
+	cw := csv.NewWriter(w)
+	header := []string{r.Spec.GroupBy}
+	switch r.Spec.Type {
+	case "income", "expense":
+		header = append(header, r.Spec.Type)
+	default:
+		header = append(header, "income", "expense", "net")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		record := []string{row.Key}
+		switch r.Spec.Type {
+		case "income":
+			record = append(record, formatMoneyPlain(row.Income))
+		case "expense":
+			record = append(record, formatMoneyPlain(row.Expense))
+		default:
+			record = append(record, formatMoneyPlain(row.Income), formatMoneyPlain(row.Expense), formatMoneyPlain(row.Income-row.Expense))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// reportChart is the input of the report chart script.
+type reportChart struct {
+	Title      string        `json:"title"`
+	Labels     []string      `json:"labels"`
+	Series     []chartSeries `json:"series"`
+	Horizontal bool          `json:"horizontal"`
+}
+
+type chartSeries struct {
+	Name   string    `json:"name"`
+	Values []float64 `json:"values"`
+}
+
+func newReportChart(r reportResult) reportChart {
+	chart := reportChart{Title: r.Title + ", " + r.Label, Horizontal: r.Spec.GroupBy != "week"}
+	income := chartSeries{Name: "Income"}
+	expense := chartSeries{Name: "Expense"}
+	for _, row := range r.Rows {
+		chart.Labels = append(chart.Labels, row.Key)
+		income.Values = append(income.Values, row.Income.Float())
+		expense.Values = append(expense.Values, row.Expense.Float())
+	}
+	if r.Spec.Type != "expense" {
+		chart.Series = append(chart.Series, income)
+	}
+	if r.Spec.Type != "income" {
+		chart.Series = append(chart.Series, expense)
+	}
+	return chart
+}
+
+// sendReport runs a report and sends it in its format.
+func sendReport(chatID int64, title string, spec reportSpec) {
+	r, err := runReport(title, spec, appClock.Now())
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("Failed to run the report: %v", err))
+		return
+	}
+	if len(r.Rows) == 0 || spec.Format == "text" {
+		sendMessage(chatID, reportText(r))
+		return
+	}
+
+	switch spec.Format {
+	case "chart":
+		sendChart(chatID, reportChartScript, newReportChart(r), fmt.Sprintf("📋 %s, %s", r.Title, r.Label))
+	case "csv":
+		f, err := os.CreateTemp(dataSubdir("exports"), "report-*.csv")
+		if err != nil {
+			sendMessage(chatID, "Failed to write the report.")
+			reportError("creating a report file", err)
+			return
+		}
+		defer os.Remove(f.Name())
+		err = writeReportCSV(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to write the report.")
+			reportError("writing a report file", err)
+			return
+		}
+		if err := messenger.SendFile(chatID, f.Name(), fmt.Sprintf("📋 %s, %s", r.Title, r.Label)); err != nil {
+			reportError("sending a report file", err)
+		}
+	}
+}
+
+func loadSavedReport(name string) (reportSpec, error) {
+	var spec reportSpec
+	var raw string
+	if err := db.QueryRow("SELECT spec FROM saved_reports WHERE name = ?", name).Scan(&raw); err != nil {
+		return spec, err
+	}
+	err := json.Unmarshal([]byte(raw), &spec)
+	return spec, err
+}
+
+func handleReportCommand(chatID int64, userID int64, command string, args string) {
+	fields := strings.Fields(args)
+	switch {
+	case command == "reports" || (len(fields) == 1 && strings.EqualFold(fields[0], "list")):
+		listSavedReports(chatID)
+	case len(fields) == 0:
+		startReportBuilder(chatID, userID)
+	case len(fields) == 2 && strings.EqualFold(fields[0], "delete"):
+		res, err := db.Exec("DELETE FROM saved_reports WHERE name = ?", strings.ToLower(fields[1]))
+		if err != nil {
+			sendMessage(chatID, "Failed to delete the report.")
+			reportError("deleting a saved report", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("No saved report named %q.", fields[1]))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Report %q deleted.", strings.ToLower(fields[1])))
+	case len(fields) == 1:
+		name := strings.ToLower(fields[0])
+		spec, err := loadSavedReport(name)
+		if err == sql.ErrNoRows {
+			sendMessage(chatID, fmt.Sprintf("No saved report named %q. See /report list, or /report to build one.", fields[0]))
+			return
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to load the report.")
+			reportError("loading saved report "+name, err)
+			return
+		}
+		sendReport(chatID, name, spec)
+	default:
+		sendMessage(chatID, "Usage: /report [name | list | delete <name>]")
+	}
+}
+
+func listSavedReports(chatID int64) {
+	rows, err := db.Query("SELECT name, spec FROM saved_reports ORDER BY name")
+	if err != nil {
+		sendMessage(chatID, "Failed to load the saved reports.")
+		reportError("loading saved reports", err)
+		return
+	}
+	defer rows.Close()
+
+	var sb strings.Builder
+	for rows.Next() {
+		var name, raw string
+		if err := rows.Scan(&name, &raw); err != nil {
+			rows.Close()
+			reportError("reading saved reports", err)
+			break
+		}
+		var spec reportSpec
+		if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+			log.Printf("Failed to read saved report %q: %v", name, err)
+			sb.WriteString(fmt.Sprintf("• %s: unreadable, remove it with /report delete %s\n", name, name))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("• %s: %s\n", name, spec.describe()))
+	}
+	if sb.Len() == 0 {
+		sendMessage(chatID, "No saved reports. Build one with /report.")
+		return
+	}
+	sendMessage(chatID, "📋 Saved reports\n\n"+sb.String()+"\nRun one with /report <name>.")
+}
+
+// Builder
+
+func startReportBuilder(chatID int64, userID int64) {
+	userStates[userID] = &TransactionState{UserID: userID, Step: "REPORT_PERIOD", Report: &reportSpec{}}
+
+	var buttons [][]InlineKeyboardButton
+	for i := 0; i < len(reportPeriods); i += 2 {
+		buttons = append(buttons, []InlineKeyboardButton{
+			{Text: reportPeriods[i].label, CallbackData: "report:period:" + reportPeriods[i].key},
+			{Text: reportPeriods[i+1].label, CallbackData: "report:period:" + reportPeriods[i+1].key},
+		})
+	}
+	buttons = append(buttons, []InlineKeyboardButton{{Text: "Cancel", CallbackData: "report:cancel"}})
+	sendMessageWithKeyboard(chatID, "📋 New report\n\nChoose the period, or type one (2026-09, 2026 or 2026-09-01 2026-09-15):", buildKeyboard(buttons))
+}
+
+// processReportPeriodText takes a typed period instead of a button.
+func processReportPeriodText(message *TGMessage, state *TransactionState) {
+	period := strings.TrimSpace(message.Text)
+	if _, _, _, err := parsePeriod(period, appClock.Now()); err != nil || period == "" {
+		sendMessage(message.Chat.ID, "Invalid period. Type 2026-09, 2026 or 2026-09-01 2026-09-15, or choose a button.")
+		return
+	}
+	state.Report.Period = period
+	state.Step = "REPORT_CATEGORIES"
+	sendMessageWithKeyboard(message.Chat.ID, reportCategoriesPrompt(state.Report), reportCategoriesKeyboard(state.Report))
+}
+
+func reportCategoriesPrompt(spec *reportSpec) string {
+	return fmt.Sprintf("📋 New report\nPeriod: %s\n\nChoose the categories (none selected means all):", labelOf(reportPeriods, spec.Period))
+}
+
+func reportCategoriesKeyboard(spec *reportSpec) InlineKeyboardMarkup {
+	var buttons [][]InlineKeyboardButton
+	var row []InlineKeyboardButton
+	for _, c := range getCategories() {
+		mark := "⬜"
+		if len(spec.Categories) > 0 && spec.hasCategory(c) {
+			mark = "✅"
+		}
+		row = append(row, InlineKeyboardButton{Text: mark + " " + c, CallbackData: "report:cat:" + c})
+		if len(row) == 2 {
+			buttons = append(buttons, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		buttons = append(buttons, row)
+	}
+	buttons = append(buttons, []InlineKeyboardButton{
+		{Text: "Next ▶", CallbackData: "report:cats:done"},
+		{Text: "Cancel", CallbackData: "report:cancel"},
+	})
+	return buildKeyboard(buttons)
+}
+
+func reportOptionsKeyboard(action string, options []struct{ key, label string }) InlineKeyboardMarkup {
+	var row []InlineKeyboardButton
+	for _, o := range options {
+		row = append(row, InlineKeyboardButton{Text: o.label, CallbackData: "report:" + action + ":" + o.key})
+	}
+	return buildKeyboard([][]InlineKeyboardButton{row, {{Text: "Cancel", CallbackData: "report:cancel"}}})
+}
+
+func hasOption(options []struct{ key, label string }, key string) bool {
+	for _, o := range options {
+		if o.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// reportSteps maps each builder action to the step it belongs to.
+var reportSteps = map[string]string{
+	"period": "REPORT_PERIOD",
+	"cat":    "REPORT_CATEGORIES",
+	"cats":   "REPORT_CATEGORIES",
+	"type":   "REPORT_TYPE",
+	"group":  "REPORT_GROUP",
+	"format": "REPORT_FORMAT",
+	"save":   "REPORT_SAVE",
+	"done":   "REPORT_SAVE",
+}
+
+// handleReportCallback handles the builder buttons: report:<action>[:<value>].
+func handleReportCallback(callback *CallbackQuery) {
+	action, value, _ := strings.Cut(strings.TrimPrefix(callback.Data, "report:"), ":")
+	state, exists := userStates[callback.From.ID]
+	if !exists || state.Report == nil || (action != "cancel" && reportSteps[action] != state.Step) {
+		_ = messenger.AnswerCallback(callback.ID, "This report builder has expired. Start again with /report.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	chatID := callback.Message.Chat.ID
+	msgID := callback.Message.MessageID
+	spec := state.Report
+	switch action {
+	case "cancel":
+		delete(userStates, state.UserID)
+		editMessage(chatID, msgID, "Report canceled.")
+	case "period":
+		if !hasOption(reportPeriods, value) {
+			return
+		}
+		spec.Period = value
+		state.Step = "REPORT_CATEGORIES"
+		editMessageWithKeyboard(chatID, msgID, reportCategoriesPrompt(spec), reportCategoriesKeyboard(spec))
+	case "cat":
+		name, ok := findCategory(value)
+		if !ok {
+			return
+		}
+		if len(spec.Categories) > 0 && spec.hasCategory(name) {
+			kept := spec.Categories[:0]
+			for _, c := range spec.Categories {
+				if c != name {
+					kept = append(kept, c)
+				}
+			}
+			spec.Categories = kept
+		} else {
+			spec.Categories = append(spec.Categories, name)
+		}
+		editMessageWithKeyboard(chatID, msgID, reportCategoriesPrompt(spec), reportCategoriesKeyboard(spec))
+	case "cats":
+		state.Step = "REPORT_TYPE"
+		editMessageWithKeyboard(chatID, msgID, "📋 New report\n\nInclude which transactions?", reportOptionsKeyboard("type", reportTypes))
+	case "type":
+		if !hasOption(reportTypes, value) {
+			return
+		}
+		spec.Type = value
+		state.Step = "REPORT_GROUP"
+		editMessageWithKeyboard(chatID, msgID, "📋 New report\n\nGroup the totals by:", reportOptionsKeyboard("group", reportGroups))
+	case "group":
+		if !hasOption(reportGroups, value) {
+			return
+		}
+		spec.GroupBy = value
+		state.Step = "REPORT_FORMAT"
+		editMessageWithKeyboard(chatID, msgID, "📋 New report\n\nSend it as:", reportOptionsKeyboard("format", reportFormats))
+	case "format":
+		if !hasOption(reportFormats, value) {
+			return
+		}
+		spec.Format = value
+		state.Step = "REPORT_SAVE"
+		editMessage(chatID, msgID, "📋 Report built.")
+		sendReport(chatID, "Custom report", *spec)
+		sendMessageWithKeyboard(chatID, "Save this report to rerun it with /report <name>?", buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "💾 Save", CallbackData: "report:save"},
+			{Text: "Done", CallbackData: "report:done"},
+		}}))
+	case "save":
+		state.Step = "ENTER_REPORT_NAME"
+		editMessage(chatID, msgID, "Send a name for the report: lowercase letters, digits, - and _ (e.g. weekly-food).")
+	case "done":
+		delete(userStates, state.UserID)
+		editMessage(chatID, msgID, "Report not saved.")
+	}
+}
+
+func processReportName(message *TGMessage, state *TransactionState) {
+	name := strings.ToLower(strings.TrimSpace(message.Text))
+	if !reportNamePattern.MatchString(name) || name == "list" || name == "delete" {
+		sendMessage(message.Chat.ID, "Invalid name. Use up to 32 lowercase letters, digits, - and _, e.g. weekly-food.")
+		return
+	}
+	raw, err := json.Marshal(state.Report)
+	if err != nil {
+		sendMessage(message.Chat.ID, "Failed to save the report.")
+		reportError("encoding a report", err)
+		return
+	}
+	if _, err := db.Exec(`INSERT INTO saved_reports (name, spec) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET spec = excluded.spec`, name, string(raw)); err != nil {
+		sendMessage(message.Chat.ID, "Failed to save the report.")
+		reportError("saving a report", err)
+		return
+	}
+	delete(userStates, state.UserID)
+	sendMessage(message.Chat.ID, fmt.Sprintf("Report saved. Run it again with /report %s.", name))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+	RESUMING CONVERSATIONS
+
+	Conversations live in userStates, in memory, and a restart (an
+	upgrade, a crash, systemd's watchdog) would drop a half-finished /add
+	or /edit without a word. So the update loop keeps the conversation of
+	each user in
+	conversation_states after every update, while it is at a step of
+	/add or /edit, and on startup the bot writes to each user it finds
+	there:
+
+		⏸️ The bot restarted while you were in the middle of /add, at
+		the amount step. Continue where you left off?
+		[▶️ Continue] [✖️ Cancel]
+
+	Continue shows the question of that step again, with its keyboard,
+	and what was already chosen is kept; the user can also just answer
+	the step. Conversations idle for more than stateTTL are not resumed,
+	and other conversations (reports, imports, splits) are not kept.
+*/
+
+const (
+	resumeContinue = "resume:continue"
+	resumeCancel   = "resume:cancel"
+)
+
+// resumableSteps names the steps a conversation is kept at, as shown to
+// the user.
+var resumableSteps = map[string]string{
+	"SELECT_TYPE":            "type",
+	"SELECT_CATEGORY":        "category",
+	"ENTER_AMOUNT":           "amount",
+	"ENTER_DESCRIPTION":      "description",
+	"SELECT_EDIT_FIELD":      "field",
+	"SELECT_EDIT_TYPE":       "type",
+	"SELECT_EDIT_CATEGORY":   "category",
+	"ENTER_EDIT_AMOUNT":      "amount",
+	"ENTER_EDIT_QUANTITY":    "quantity",
+	"ENTER_EDIT_DESCRIPTION": "description",
+	"ENTER_EDIT_NOTES":       "notes",
+	"ENTER_EDIT_TAX":         "tax",
+	"SELECT_EDIT_DEDUCTIBLE": "deductible",
+	"SELECT_EDIT_IS_OUTLIER": "outlier",
+}
+
+// saveConversation keeps the conversation of the user an update came from
+// in the database, or forgets it once it ended or left /add and /edit.
+func saveConversation(update Update) {
+	userID, chatID, _ := updateSource(update)
+	if userID == 0 || chatID == 0 {
+		return
+	}
+	state, ok := userStates[userID]
+	if !ok || resumableSteps[state.Step] == "" {
+		if _, err := db.Exec("DELETE FROM conversation_states WHERE user_id = ?", userID); err != nil {
+			log.Printf("Forgetting the conversation of %d: %v", userID, err)
+		}
+		return
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("Saving the conversation of %d: %v", userID, err)
+		return
+	}
+	_, err = db.Exec(`INSERT INTO conversation_states (user_id, chat_id, state, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET chat_id = excluded.chat_id, state = excluded.state, updated_at = excluded.updated_at`,
+		userID, chatID, string(data), appClock.Now().UTC().Format(dbTimeLayout))
+	if err != nil {
+		log.Printf("Saving the conversation of %d: %v", userID, err)
+	}
+}
+
+// resumeConversations restores the conversations kept by the last run and
+// asks each user whether to continue theirs.
+func resumeConversations() {
+	type kept struct {
+		chatID int64
+		state  TransactionState
+	}
+	rows, err := db.Query("SELECT chat_id, state FROM conversation_states WHERE updated_at >= ?", utcCutoff(appClock.Now(), stateTTL))
+	if err != nil {
+		reportError("loading conversations", err)
+		return
+	}
+	var conversations []kept
+	for rows.Next() {
+		var c kept
+		var data string
+		if err := rows.Scan(&c.chatID, &data); err != nil {
+			log.Printf("Loading a conversation: %v", err)
+			continue
+		}
+		if err := json.Unmarshal([]byte(data), &c.state); err != nil || resumableSteps[c.state.Step] == "" {
+			log.Printf("Skipping a conversation that cannot be resumed: %v", err)
+			continue
+		}
+		conversations = append(conversations, c)
+	}
+	rows.Close()
+	if _, err := db.Exec("DELETE FROM conversation_states WHERE updated_at < ?", utcCutoff(appClock.Now(), stateTTL)); err != nil {
+		log.Printf("Forgetting old conversations: %v", err)
+	}
+
+	for _, c := range conversations {
+		state := c.state
+		keyboard := buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "▶️ Continue", CallbackData: resumeContinue},
+			{Text: "✖️ Cancel", CallbackData: resumeCancel},
+		}})
+		messageID, err := messenger.SendKeyboard(c.chatID, escapeHTML(resumeQuestion(&state)), keyboard)
+		if err != nil {
+			log.Printf("Offering to resume the conversation of %d: %v", state.UserID, err)
+			continue
+		}
+		state.PromptMessageID = messageID
+		userStates[state.UserID] = &state
+		lastUpdateAt[state.UserID] = appClock.Now()
+	}
+	if len(conversations) > 0 {
+		log.Printf("Offered to resume %d conversation(s)", len(conversations))
+	}
+}
+
+// resumeQuestion asks whether to continue a conversation, naming the
+// command and the step.
+func resumeQuestion(state *TransactionState) string {
+	flow := "/add"
+	if strings.Contains(state.Step, "EDIT") {
+		flow = fmt.Sprintf("/edit of #%d", state.EditID)
+	}
+	return fmt.Sprintf("⏸️ The bot restarted while you were in the middle of %s, at the %s step. Continue where you left off?", flow, resumableSteps[state.Step])
+}
+
+// stepPrompt returns the question of a step of /add or /edit and its
+// keyboard.
+func stepPrompt(state *TransactionState) (string, InlineKeyboardMarkup) {
+	switch state.Step {
+	case "SELECT_TYPE":
+		return typePrompt, typeKeyboard()
+	case "SELECT_CATEGORY":
+		return categoryPrompt(state), categoryKeyboard()
+	case "ENTER_AMOUNT":
+		return amountPrompt(state), backKeyboard()
+	case "ENTER_DESCRIPTION":
+		return descriptionPrompt, backKeyboard()
+	case "SELECT_EDIT_FIELD":
+		return editFieldMenu(state)
+	default:
+		return editValuePrompt(state.Step)
+	}
+}
+
+// handleResumeCallback handles the buttons of the question sent on
+// startup.
+func handleResumeCallback(callback *CallbackQuery) {
+	_ = messenger.AnswerCallback(callback.ID, "")
+	chatID, messageID := callback.Message.Chat.ID, callback.Message.MessageID
+	state, ok := userStates[callback.From.ID]
+	if !ok || state.PromptMessageID != messageID || resumableSteps[state.Step] == "" {
+		editMessage(chatID, messageID, "This conversation has already ended.")
+		return
+	}
+	if callback.Data == resumeCancel {
+		delete(userStates, state.UserID)
+		editMessage(chatID, messageID, "Canceled. Start again any time with /add or /edit.")
+		return
+	}
+	prompt, keyboard := stepPrompt(state)
+	editMessageWithKeyboard(chatID, messageID, prompt, keyboard)
+}
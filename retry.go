@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// APIError is a Telegram Bot API error response ("ok": false).
+type APIError struct {
+	Code        int
+	Description string
+	RetryAfter  int // seconds, set on 429 Too Many Requests
+}
+
+func (e *APIError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("telegram api error %d: %s (retry after %ds)", e.Code, e.Description, e.RetryAfter)
+	}
+	return fmt.Sprintf("telegram api error %d: %s", e.Code, e.Description)
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *APIError) Temporary() bool {
+	return e.Code == 429 || e.Code >= 500
+}
+
+// checkAPIResponse decodes the common response envelope and turns
+// "ok": false into an *APIError.
+func checkAPIResponse(data []byte, statusCode int) error {
+	var envelope struct {
+		OK          bool   `json:"ok"`
+		ErrorCode   int    `json:"error_code"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		if statusCode >= 500 {
+			return &APIError{Code: statusCode, Description: "invalid response from server"}
+		}
+		return fmt.Errorf("failed to parse telegram response (HTTP %d): %w", statusCode, err)
+	}
+	if envelope.OK {
+		return nil
+	}
+	code := envelope.ErrorCode
+	if code == 0 {
+		code = statusCode
+	}
+	return &APIError{Code: code, Description: envelope.Description, RetryAfter: envelope.Parameters.RetryAfter}
+}
+
+const (
+	maxSendAttempts = 5
+	baseRetryDelay  = time.Second
+	maxRetryDelay   = 30 * time.Second
+)
+
+// retryDelay returns how long to wait before the given (1-based) retry,
+// honoring Telegram's retry_after hint when present.
+func retryDelay(attempt int, err error) time.Duration {
+	if apiErr, ok := err.(*APIError); ok && apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second
+	}
+	delay := baseRetryDelay << (attempt - 1)
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// isRetryable reports whether a failed call should be retried. Network errors
+// and temporary API errors are retried, everything else is permanent.
+func isRetryable(err error) bool {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.Temporary()
+	}
+	return true
+}
+
+// tokenBucket allows short bursts while enforcing an average rate.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes one token, refilled at rate per second up to burst, and
+// returns how long the caller must wait before that token is available.
+func (b *tokenBucket) take(now time.Time, rate float64, burst float64) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// sendLimiter queues outgoing messages to stay within Telegram's limits:
+// about 30 messages per second overall, one per second in a private chat
+// and 20 per minute in a group, with small bursts allowed.
+type sendLimiter struct {
+	mu     sync.Mutex
+	global tokenBucket
+	chats  map[int64]*tokenBucket
+}
+
+const (
+	globalSendRate  = 30.0
+	privateSendRate = 1.0
+	groupSendRate   = 20.0 / 60
+	chatSendBurst   = 3.0
+)
+
+func newSendLimiter() *sendLimiter {
+	return &sendLimiter{chats: make(map[int64]*tokenBucket)}
+}
+
+// wait blocks until a message may be sent to chatID. The tokens are taken
+// under the mutex, which reserves the caller's turn, and the wait happens
+// after releasing it, so a throttled chat does not hold up the others.
+func (l *sendLimiter) wait(chatID int64) {
+	l.mu.Lock()
+
+	rate := privateSendRate
+	if chatID < 0 { // group and channel ids are negative
+		rate = groupSendRate
+	}
+	bucket, ok := l.chats[chatID]
+	if !ok {
+		bucket = &tokenBucket{}
+		l.chats[chatID] = bucket
+	}
+
+	now := time.Now()
+	delay := l.global.take(now, globalSendRate, globalSendRate)
+	if d := bucket.take(now, rate, chatSendBurst); d > delay {
+		delay = d
+	}
+
+	// forget chats that have been idle long enough to have a full bucket
+	if len(l.chats) > 1000 {
+		for id, b := range l.chats {
+			if now.Sub(b.last) > time.Minute {
+				delete(l.chats, id)
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
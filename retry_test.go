@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendLimiterThrottledChatDoesNotBlockOthers(t *testing.T) {
+	l := newSendLimiter()
+	const group, private = -100, 42
+	for i := 0; i < int(chatSendBurst); i++ {
+		l.wait(group)
+	}
+
+	// the group's burst is spent, so this waits about three seconds
+	waiting := make(chan struct{})
+	go func() {
+		close(waiting)
+		l.wait(group)
+	}()
+	<-waiting
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	l.wait(private)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the private chat waited %s behind the throttled group", elapsed)
+	}
+}
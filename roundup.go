@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	KEEP THE CHANGE (/roundup)
+
+	An optional rule that rounds every new expense up to the next multiple
+	of a unit and puts the difference towards a savings goal (goals.go), as
+	if the change went into a jar. It applies however the expense is added,
+	from the chat, a receipt, the API, the CLI, a bill or a subscription
+	(transactionAdded in main.go):
+
+	/roundup 5000 Emergency fund   round up to 5,000 into that goal
+	/roundup                       the rule and this month's round-ups
+	/roundup off
+
+	A 23,500 lunch adds 1,500 to the goal, and 3 × 7,500 of snacks adds
+	2,500; an amount already on a multiple adds nothing. The round-up is noted in the confirmation and recorded as
+	a contribution of the goal with the id of the expense, and stays as
+	recorded when the expense is edited or deleted. The roundup_summary job
+	(09:00 by default) sends how much last month's round-ups added up to,
+	the first time it runs in a month.
+
+	The rule lives in the roundup_unit and roundup_goal settings; removing
+	the goal turns it off.
+*/
+
+const roundupUsage = "Usage:\n/roundup <unit> <goal> - round expenses up to the unit into a goal\n/roundup - show the rule\n/roundup off"
+
+// roundupRule returns the unit and the goal of the round-up rule, and
+// whether it is on.
+func roundupRule() (Money, savingsGoal, bool) {
+	unit := getMoneySetting("roundup_unit", 0)
+	goalID, _ := strconv.ParseInt(getSetting("roundup_goal", ""), 10, 64)
+	if unit <= 0 || goalID == 0 {
+		return 0, savingsGoal{}, false
+	}
+	goal, err := findSavingsGoal(goalID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			reportError("loading the round-up goal", err)
+		}
+		return 0, savingsGoal{}, false
+	}
+	return unit, goal, true
+}
+
+// roundupAmount returns what rounds amount up to a multiple of unit.
+func roundupAmount(amount Money, unit Money) Money {
+	if unit <= 0 || amount <= 0 || amount%unit == 0 {
+		return 0
+	}
+	return unit - amount%unit
+}
+
+// applyRoundup puts the round-up of a new expense, of total quantity ×
+// amount, towards the goal of the rule and returns the note for the
+// confirmation, or "".
+func applyRoundup(transactionID int64, total Money) string {
+	unit, goal, ok := roundupRule()
+	if !ok {
+		return ""
+	}
+	change := roundupAmount(total, unit)
+	if change == 0 {
+		return ""
+	}
+	if err := addGoalContribution(goal.ID, change, "roundup", transactionID); err != nil {
+		reportError("saving a round-up", err)
+		return ""
+	}
+	return fmt.Sprintf("🪙 Rounded up: %s put towards %s.", formatMoney(change), goal.Name)
+}
+
+// roundupTotals returns the number and the sum of the round-ups made in
+// [from, to).
+func roundupTotals(from time.Time, to time.Time) (int, Money, error) {
+	var count int
+	var total Money
+	err := db.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM goal_contributions WHERE source = 'roundup' AND created_at >= ? AND created_at < ?",
+		from.Format(dbTimeLayout), to.Format(dbTimeLayout)).Scan(&count, &total)
+	return count, total, err
+}
+
+func handleRoundupCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		showRoundup(chatID)
+	case len(fields) == 1 && strings.EqualFold(fields[0], "off"):
+		if err := setSetting("roundup_unit", "0"); err != nil {
+			sendMessage(chatID, "Failed to update the rule.")
+			reportError("turning round-ups off", err)
+			return
+		}
+		sendMessage(chatID, "Round-ups are off.")
+	case len(fields) >= 2:
+		unit, err := parseMoney(fields[0])
+		if err != nil || unit <= 0 {
+			sendMessage(chatID, "Invalid unit.\n"+roundupUsage)
+			return
+		}
+		name := strings.Join(fields[1:], " ")
+		goal, err := findSavingsGoalByName(name)
+		if err == sql.ErrNoRows {
+			sendMessage(chatID, fmt.Sprintf("No goal named %q. Create it with /goals add %s <target>.", name, name))
+			return
+		}
+		if err == nil {
+			err = setSetting("roundup_goal", strconv.FormatInt(goal.ID, 10))
+		}
+		if err == nil {
+			err = setSetting("roundup_unit", unit.String())
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to update the rule.")
+			reportError("setting the round-up rule", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("🪙 Every expense is now rounded up to a multiple of %s, the change going to %s.", formatMoney(unit), goal.Name))
+	default:
+		sendMessage(chatID, roundupUsage)
+	}
+}
+
+func showRoundup(chatID int64) {
+	unit, goal, ok := roundupRule()
+	if !ok {
+		sendMessage(chatID, "Round-ups are off.\n\n"+roundupUsage)
+		return
+	}
+	now := appClock.Now()
+	monthStart, monthEnd := monthBounds(now)
+	count, total, err := roundupTotals(monthStart, monthEnd)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the round-ups.")
+		reportError("summing round-ups", err)
+		return
+	}
+	sendMessage(chatID, fmt.Sprintf("🪙 Expenses are rounded up to a multiple of %s, the change going to %s.\n\n%s: %d round-up(s), %s.\n%s",
+		formatMoney(unit), goal.Name, now.Format("January"), count, formatMoney(total), goalProgress(goal)))
+}
+
+// sendRoundupSummary queues the summary of last month's round-ups, once.
+func sendRoundupSummary(now time.Time) error {
+	thisMonth, _ := monthBounds(now)
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	count, total, err := roundupTotals(lastMonth, thisMonth)
+	if err != nil || count == 0 {
+		return err
+	}
+	text := fmt.Sprintf("🪙 Round-ups in %s: %d expense(s) rounded up, %s kept.", lastMonth.Format("January"), count, formatMoney(total))
+	goals, err := loadSavingsGoals()
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, g := range goals {
+		var fromRoundups Money
+		if err := db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM goal_contributions WHERE goal_id = ? AND source = 'roundup' AND created_at >= ? AND created_at < ?",
+			g.ID, lastMonth.Format(dbTimeLayout), thisMonth.Format(dbTimeLayout)).Scan(&fromRoundups); err != nil {
+			return err
+		}
+		if fromRoundups > 0 {
+			lines = append(lines, goalProgress(g))
+		}
+	}
+	if len(lines) > 0 {
+		text += "\n\n" + strings.Join(lines, "\n")
+	}
+	return enqueueNotification(ownerID(), "roundup_summary", "roundup:"+lastMonth.Format("2006-01"), text, nil)
+}
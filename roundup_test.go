@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoundupOfLineTotal(t *testing.T) {
+	h := newHarness(t)
+	h.send("/goals add Jar 1000000")
+	if reply := h.send("/roundup 5000 Jar"); !strings.Contains(reply, "Jar") {
+		t.Fatalf("setting the rule: %q", reply)
+	}
+
+	// 3 × 7,500 is 22,500, rounded up to 25,000
+	_, note, err := insertTransaction("expense", "Food", 3, 7500*moneyScale, "snacks", appClock.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2,500.00 put towards Jar"; !strings.Contains(note, want) {
+		t.Errorf("note is %q, want %q", note, want)
+	}
+	if _, note, err = insertTransaction("income", "Salary", 1, 1234*moneyScale, "", appClock.Now(), false); err != nil {
+		t.Fatal(err)
+	} else if note != "" {
+		t.Errorf("income rounded up: %q", note)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	CATEGORIZATION RULES (/rules)
+
+	A rule picks the category of a transaction from its description,
+	amount and type:
+
+	/rules add description contains grab -> Transportation
+	/rules add amount > 1000000 and type income -> Salary
+	/rules test description contains "gofood" -> Food
+	/rules                      list the rules
+	/rules delete 3
+
+	Conditions are joined with "and": description contains <text>
+	(case-insensitive; quote a text that itself has " and "), amount >,
+	>=, <, <= or = <number>, and type income|expense. The first rule that
+	matches wins. Rules are applied to quick-add messages ("25000 grab to
+	office", quickadd.go) and to rows of a bulk import that have no
+	category. /rules test is a dry run over the existing transactions.
+*/
+
+const rulesUsage = "Usage:\n/rules - list the rules\n/rules add <conditions> -> <category> - add a rule\n/rules test <conditions> -> <category> - preview a rule on the existing transactions\n/rules delete <id> - delete a rule\n\nConditions, joined with \"and\": description contains <text>, amount > <number> (or >=, <, <=, =), type income|expense"
+
+type ruleCondition struct {
+	Field  string // "description", "amount" or "type"
+	Op     string // "contains", ">", ">=", "<", "<=", "=" or "is"
+	Text   string
+	Number Money
+}
+
+type categoryRule struct {
+	ID         int64
+	Conditions []ruleCondition
+	Category   string
+}
+
+// String formats the rule the way it is written in /rules add.
+func (r categoryRule) String() string {
+	parts := make([]string, len(r.Conditions))
+	for i, c := range r.Conditions {
+		switch c.Field {
+		case "description":
+			parts[i] = `description contains "` + c.Text + `"`
+		case "amount":
+			parts[i] = fmt.Sprintf("amount %s %s", c.Op, c.Number.Plain())
+		default:
+			parts[i] = "type " + c.Text
+		}
+	}
+	return strings.Join(parts, " and ") + " → " + r.Category
+}
+
+// matches tells whether a transaction satisfies every condition.
+func (r categoryRule) matches(typ string, amount Money, description string) bool {
+	for _, c := range r.Conditions {
+		var ok bool
+		switch c.Field {
+		case "description":
+			ok = strings.Contains(strings.ToLower(description), strings.ToLower(c.Text))
+		case "type":
+			ok = typ == c.Text
+		case "amount":
+			switch c.Op {
+			case ">":
+				ok = amount > c.Number
+			case ">=":
+				ok = amount >= c.Number
+			case "<":
+				ok = amount < c.Number
+			case "<=":
+				ok = amount <= c.Number
+			case "=":
+				ok = amount == c.Number
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRuleConditions parses the conditions of a rule, e.g.
+// `amount > 1000 and type income`.
+func parseRuleConditions(s string) ([]ruleCondition, error) {
+	var conditions []ruleCondition
+	for _, part := range splitConditions(s) {
+		fields := strings.Fields(part)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("incomplete condition %q", strings.TrimSpace(part))
+		}
+		switch strings.ToLower(fields[0]) {
+		case "description":
+			if len(fields) < 3 || !strings.EqualFold(fields[1], "contains") {
+				return nil, fmt.Errorf("use: description contains <text>")
+			}
+			text := strings.TrimSpace(strings.Join(fields[2:], " "))
+			text = strings.Trim(text, `"'`)
+			if text == "" {
+				return nil, fmt.Errorf("description contains needs a text")
+			}
+			conditions = append(conditions, ruleCondition{Field: "description", Op: "contains", Text: text})
+		case "amount":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("use: amount > <number>")
+			}
+			switch fields[1] {
+			case ">", ">=", "<", "<=", "=":
+			default:
+				return nil, fmt.Errorf("unknown comparison %q, use >, >=, <, <= or =", fields[1])
+			}
+			n, err := parseMoney(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount %q", fields[2])
+			}
+			conditions = append(conditions, ruleCondition{Field: "amount", Op: fields[1], Number: n})
+		case "type":
+			typ := strings.ToLower(fields[len(fields)-1])
+			if (typ != "income" && typ != "expense") || len(fields) > 3 || (len(fields) == 3 && !strings.EqualFold(fields[1], "is")) {
+				return nil, fmt.Errorf("use: type income or type expense")
+			}
+			conditions = append(conditions, ruleCondition{Field: "type", Op: "is", Text: typ})
+		default:
+			return nil, fmt.Errorf("unknown field %q, use description, amount or type", fields[0])
+		}
+	}
+	return conditions, nil
+}
+
+// splitConditions splits s around every case-insensitive " and " that is
+// not inside double quotes.
+func splitConditions(s string) []string {
+	var parts []string
+	lower := strings.ToLower(s)
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(lower[i:], " and "):
+			parts = append(parts, s[start:i])
+			start = i + len(" and ")
+			i = start - 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseRule parses "<conditions> -> <category>"; the category must exist.
+func parseRule(s string) (categoryRule, error) {
+	s = strings.Replace(s, "→", "->", 1)
+	left, right, ok := strings.Cut(s, "->")
+	if !ok {
+		return categoryRule{}, fmt.Errorf("missing -> <category>")
+	}
+	category, ok := findCategory(strings.TrimSpace(right))
+	if !ok {
+		return categoryRule{}, fmt.Errorf("unknown category %q", strings.TrimSpace(right))
+	}
+	conditions, err := parseRuleConditions(left)
+	if err != nil {
+		return categoryRule{}, err
+	}
+	return categoryRule{Conditions: conditions, Category: category}, nil
+}
+
+// loadRules returns the rules in the order they are tried. Rules that no
+// longer parse, e.g. because their category was removed, are skipped.
+func loadRules() ([]categoryRule, error) {
+	rows, err := db.Query("SELECT id, conditions, category FROM rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []categoryRule
+	for rows.Next() {
+		var id int64
+		var conditions, category string
+		if err := rows.Scan(&id, &conditions, &category); err != nil {
+			return nil, err
+		}
+		r, err := parseRule(conditions + " -> " + category)
+		if err != nil {
+			continue
+		}
+		r.ID = id
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// matchRule returns the first of rules matching the transaction.
+func matchRule(rules []categoryRule, typ string, amount Money, description string) (categoryRule, bool) {
+	for _, r := range rules {
+		if r.matches(typ, amount, description) {
+			return r, true
+		}
+	}
+	return categoryRule{}, false
+}
+
+// conditionsText stores the conditions of r in the rules table.
+func conditionsText(r categoryRule) string {
+	text := r.String()
+	return text[:strings.LastIndex(text, " → ")]
+}
+
+// handleRulesCommand implements /rules.
+func handleRulesCommand(chatID int64, args string) {
+	sub, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	rest = strings.TrimSpace(rest)
+	sub = strings.ToLower(sub)
+	if (sub == "add" || sub == "delete" || sub == "remove") && isMaintenanceMode() {
+		sendMessage(chatID, "The bot is in read-only maintenance mode. Please try again later.")
+		return
+	}
+
+	switch sub {
+	case "":
+		showRules(chatID)
+	case "add", "test":
+		r, err := parseRule(rest)
+		if err != nil {
+			sendMessage(chatID, fmt.Sprintf("Invalid rule: %v.\n\n%s", err, rulesUsage))
+			return
+		}
+		preview, err := previewRule(r)
+		if err != nil {
+			sendMessage(chatID, "Failed to preview the rule.")
+			reportError("previewing a rule", err)
+			return
+		}
+		if sub == "test" {
+			sendMessage(chatID, fmt.Sprintf("🧪 Dry run: %s\n\n%s", r, preview))
+			return
+		}
+		res, err := db.Exec("INSERT INTO rules (conditions, category) VALUES (?, ?)", conditionsText(r), r.Category)
+		if err != nil {
+			sendMessage(chatID, "Failed to save the rule.")
+			reportError("saving a rule", err)
+			return
+		}
+		id, _ := res.LastInsertId()
+		sendMessage(chatID, fmt.Sprintf("Rule %d saved: %s\n\n%s", id, r, preview))
+	case "delete", "remove":
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			sendMessage(chatID, rulesUsage)
+			return
+		}
+		res, err := db.Exec("DELETE FROM rules WHERE id = ?", id)
+		if err != nil {
+			sendMessage(chatID, "Failed to delete the rule.")
+			reportError("deleting a rule", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("Rule %d not found.", id))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Rule %d deleted.", id))
+	default:
+		sendMessage(chatID, rulesUsage)
+	}
+}
+
+func showRules(chatID int64) {
+	rules, err := loadRules()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the rules.")
+		reportError("loading rules", err)
+		return
+	}
+	if len(rules) == 0 {
+		sendMessage(chatID, "No rules yet.\n\n"+rulesUsage)
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("📐 Rules, tried in this order:\n\n")
+	for _, r := range rules {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", r.ID, r))
+	}
+	sendMessage(chatID, strings.TrimRight(sb.String(), "\n"))
+}
+
+// previewRule describes what r would do to the live transactions.
+func previewRule(r categoryRule) (string, error) {
+	rows, err := db.Query("SELECT id, type, category, amount, description FROM transactions WHERE " + ledgerScope() + " ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var matched, changed int
+	var examples []string
+	for rows.Next() {
+		var id int64
+		var typ, category string
+		var amount Money
+		var description sql.NullString
+		if err := rows.Scan(&id, &typ, &category, &amount, &description); err != nil {
+			return "", err
+		}
+		if !r.matches(typ, amount, description.String) {
+			continue
+		}
+		matched++
+		if category != r.Category {
+			changed++
+		}
+		if len(examples) < 5 {
+			examples = append(examples, fmt.Sprintf("#%d %s %s (%s)", id, description.String, formatMoney(amount), category))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if matched == 0 {
+		return "It matches none of the existing transactions.", nil
+	}
+	return fmt.Sprintf("It matches %d existing transaction(s), %d of them in another category now:\n%s",
+		matched, changed, strings.Join(examples, "\n")), nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	S3-COMPATIBLE STORAGE
+
+	A small client for the calls the off-site backups and the replica
+	need: put, get, list and delete an object. It speaks the S3 REST API
+	with plain net/http and AWS Signature Version 4, like the Sentry and
+	Telegram clients, and uses path-style URLs (<endpoint>/<bucket>/<key>)
+	so the same code works with AWS S3, MinIO, Backblaze B2 and other
+	compatible services.
+*/
+
+type s3Client struct {
+	endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+type s3Object struct {
+	Key          string
+	LastModified time.Time
+	Size         int64
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func newS3Client(cfg *Config) *s3Client {
+	endpoint := cfg.S3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.S3Region + ".amazonaws.com"
+	}
+	return &s3Client{
+		endpoint:  strings.TrimRight(endpoint, "/"),
+		bucket:    cfg.S3Bucket,
+		region:    cfg.S3Region,
+		accessKey: cfg.S3AccessKey,
+		secretKey: cfg.S3SecretKey,
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// objectURL returns the URL of key, or of the bucket when key is "".
+func (c *s3Client) objectURL(key string) string {
+	u := c.endpoint + "/" + awsURIEncode(c.bucket, true)
+	if key != "" {
+		u += "/" + awsURIEncode(key, false)
+	}
+	return u
+}
+
+// putFile uploads the file at path as key.
+func (c *s3Client) putFile(key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.objectURL(key), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	_, err = c.do(req, hex.EncodeToString(h.Sum(nil)))
+	return err
+}
+
+// getFile downloads key to the file at path.
+func (c *s3Client) getFile(key string, path string) error {
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	c.sign(req, emptyPayloadHash, time.Now())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *s3Client) deleteObject(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req, emptyPayloadHash)
+	return err
+}
+
+// listObjects returns every object whose key starts with prefix.
+func (c *s3Client) listObjects(prefix string) ([]s3Object, error) {
+	var objects []s3Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, c.objectURL("")+"?"+awsQuery(query), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := c.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key          string
+				LastModified time.Time
+				Size         int64
+			}
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("reading the object list: %w", err)
+		}
+		for _, o := range result.Contents {
+			objects = append(objects, s3Object{Key: o.Key, LastModified: o.LastModified, Size: o.Size})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do signs and sends req, returning the response body. Errors include the
+// code and message S3 returns.
+func (c *s3Client) do(req *http.Request, payloadHash string) ([]byte, error) {
+	c.sign(req, payloadHash, time.Now())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("%s %s: %s (%s)", req.Method, req.URL.Path, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return body, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req. Every header
+// already set on req is signed, along with the host.
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, awsQuery(req.URL.Query()), canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + c.secretKey)
+	for _, part := range []string{day, c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsQuery encodes query parameters in the canonical form: sorted, with
+// spaces as %20.
+func awsQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode percent-encodes everything but the unreserved characters,
+// and "/" unless encodeSlash is set.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9', ch == '-', ch == '.', ch == '_', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	SAVINGS RATE (/savingsrate [target <percent>|off])
+
+	(income - expenses) / income for each of the last 12 months, this one
+	included, as a list and a line chart. An optional target, stored in the
+	savings_rate_target setting, is drawn as a line and marks the months
+	that reached it.
+*/
+
+const (
+	savingsRateScript = "src/g_savings_rate_chart.py"
+	savingsRateMonths = 12
+	savingsRateUsage  = "Usage: /savingsrate [target <percent>|off]"
+)
+
+// savingsRateChart is the input of the savings rate script.
+type savingsRateChart struct {
+	Title  string   `json:"title"`
+	Months []string `json:"months"`
+	// Rates are percentages, nil for months without income.
+	Rates  []*float64 `json:"rates"`
+	Target *float64   `json:"target"`
+}
+
+func savingsRateTarget() float64 {
+	return getFloatSetting("savings_rate_target", 0)
+}
+
+func handleSavingsRateCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		showSavingsRate(chatID)
+	case len(fields) == 2 && strings.EqualFold(fields[0], "target"):
+		value := "0"
+		if !strings.EqualFold(fields[1], "off") {
+			target, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "%"), 64)
+			if err != nil || target <= 0 || target > 100 {
+				sendMessage(chatID, "The target is a percentage between 0 and 100.\n"+savingsRateUsage)
+				return
+			}
+			value = strconv.FormatFloat(target, 'f', -1, 64)
+		}
+		if err := setSetting("savings_rate_target", value); err != nil {
+			sendMessage(chatID, "Failed to update the savings target.")
+			reportError("setting the savings rate target", err)
+			return
+		}
+		if value == "0" {
+			sendMessage(chatID, "Savings target removed.")
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Savings target set to %s%% of income.", value))
+	default:
+		sendMessage(chatID, savingsRateUsage)
+	}
+}
+
+func showSavingsRate(chatID int64) {
+	now := appClock.Now()
+	monthStart, _ := monthBounds(now)
+	target := savingsRateTarget()
+
+	chart := savingsRateChart{Title: "Savings rate, last 12 months"}
+	if target > 0 {
+		chart.Target = &target
+	}
+	var sb strings.Builder
+	sb.WriteString("💰 Savings rate\n\n")
+	var totalIncome, totalExpense Money
+	hit, counted := 0, 0
+	for i := savingsRateMonths - 1; i >= 0; i-- {
+		month := monthStart.AddDate(0, -i, 0)
+		income, expense, err := monthTotals(month)
+		if err != nil {
+			sendMessage(chatID, "Failed to compute the savings rate.")
+			reportError("computing the savings rate", err)
+			return
+		}
+		totalIncome += income
+		totalExpense += expense
+		chart.Months = append(chart.Months, month.Format("Jan 06"))
+		if income <= 0 {
+			chart.Rates = append(chart.Rates, nil)
+			if expense > 0 {
+				sb.WriteString(fmt.Sprintf("%s: no income\n", month.Format("Jan 2006")))
+			}
+			continue
+		}
+		rate := percentOf((income - expense).Float(), income.Float())
+		chart.Rates = append(chart.Rates, &rate)
+		mark := ""
+		if target > 0 {
+			counted++
+			mark = " ❌"
+			if rate >= target {
+				hit++
+				mark = " ✅"
+			}
+		}
+		sb.WriteString(fmt.Sprintf("%s: %.1f%%%s\n", month.Format("Jan 2006"), rate, mark))
+	}
+	if totalIncome <= 0 {
+		sendMessage(chatID, "No income in the last 12 months, so there is no savings rate.")
+		return
+	}
+
+	sb.WriteString(fmt.Sprintf("\n12 months: %.1f%% (saved %s of %s)", percentOf((totalIncome-totalExpense).Float(), totalIncome.Float()), formatMoney(totalIncome-totalExpense), formatMoney(totalIncome)))
+	if target > 0 {
+		sb.WriteString(fmt.Sprintf("\nTarget: %g%%, reached in %d of %d month(s)", target, hit, counted))
+		if last := chart.Rates[len(chart.Rates)-1]; last != nil {
+			sb.WriteString("\nThis month: " + progressBar(max(*last, 0)/target))
+		}
+	} else {
+		sb.WriteString("\nSet a goal with /savingsrate target 20")
+	}
+	sendMessage(chatID, sb.String())
+	sendChart(chatID, savingsRateScript, chart, "💰 Savings rate, last 12 months")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+/*
+	SCHEDULER for daily jobs.
+
+	Each job runs at most once per day, at or after its configured time of
+	day. Completed runs are recorded in job_runs, so a restart neither skips
+	a job that is still due today nor runs it twice. Jobs deliver their
+	messages through the outbox. Scheduled reports (schedules.go) keep
+	their own times and are checked on every tick, as are budget alerts
+	and the pinned summary (pinned.go).
+*/
+
+const schedulerInterval = time.Minute
+
+type scheduledJob struct {
+	name string
+	run  func(now time.Time) error
+}
+
+var scheduledJobs = []scheduledJob{
+	{"end_of_day", sendEndOfDaySummary},
+	{"bill_reminders", sendBillReminders},
+	{"invoice_reminders", sendInvoiceReminders},
+	{"subscriptions", processSubscriptions},
+	{"archive", archiveOldTransactions},
+	{"maintenance", runMaintenanceJob},
+	{"offsite_backup", runOffsiteBackup},
+	{"roundup_summary", sendRoundupSummary},
+}
+
+// defaultScheduleTimes holds the time of jobs that run unless disabled.
+var defaultScheduleTimes = map[string]string{
+	"bill_reminders":    "09:00",
+	"invoice_reminders": "09:00",
+	"subscriptions":     "09:00",
+	"archive":           "03:00",
+	"maintenance":       "04:00",
+	"offsite_backup":    "02:30",
+	"roundup_summary":   "09:00",
+}
+
+// scheduleTime returns the "HH:MM" a job runs at. The schedule.<name>
+// setting (changed from chat) overrides the config file, which overrides
+// the default; "off" disables the job.
+func scheduleTime(name string) (string, bool) {
+	cfg := currentConfig()
+	at := getSetting("schedule."+name, "")
+	if at == "" && cfg != nil {
+		at = cfg.Schedules[name]
+	}
+	if at == "" {
+		at = defaultScheduleTimes[name]
+	}
+	if at == "" || at == "off" {
+		return "", false
+	}
+	return at, true
+}
+
+func runScheduler() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+	for {
+		now := appClock.Now()
+		runDueJobs(now)
+		if err := runJobSafely(scheduledJob{"report_schedules", runReportSchedules}, now); err != nil {
+			reportErrorTagged("running scheduled reports", err, map[string]string{"job": "report_schedules"})
+		}
+		if err := runJobSafely(scheduledJob{"budget_alerts", checkBudgetAlerts}, now); err != nil {
+			reportErrorTagged("checking budget alerts", err, map[string]string{"job": "budget_alerts"})
+		}
+		if err := runJobSafely(scheduledJob{"allocation_alerts", checkAllocationAlerts}, now); err != nil {
+			reportErrorTagged("checking allocation alerts", err, map[string]string{"job": "allocation_alerts"})
+		}
+		if err := runJobSafely(scheduledJob{"pinned_summary", func(now time.Time) error { return refreshPinnedSummary(now, false) }}, now); err != nil {
+			reportErrorTagged("refreshing the pinned summary", err, map[string]string{"job": "pinned_summary"})
+		}
+		<-ticker.C
+	}
+}
+
+func runDueJobs(now time.Time) {
+	today := now.Format("2006-01-02")
+	for _, job := range scheduledJobs {
+		at, ok := scheduleTime(job.name)
+		if !ok {
+			continue
+		}
+		clock, err := time.Parse("15:04", at)
+		if err != nil {
+			log.Printf("Invalid time %q for job %s", at, job.name)
+			continue
+		}
+		due := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if now.Before(due) || jobRanOn(job.name, today) {
+			continue
+		}
+
+		if err := runJobSafely(job, now); err != nil {
+			reportErrorTagged("in scheduled job "+job.name, err, map[string]string{"job": job.name})
+			continue
+		}
+		if _, err := db.Exec("INSERT OR IGNORE INTO job_runs (job, run_date) VALUES (?, ?)", job.name, today); err != nil {
+			log.Printf("Failed to record run of job %s: %v", job.name, err)
+		}
+	}
+}
+
+func jobRanOn(job string, date string) bool {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM job_runs WHERE job = ? AND run_date = ?", job, date).Scan(&n); err != nil {
+		log.Printf("Failed to check runs of job %s: %v", job, err)
+		return true
+	}
+	return n > 0
+}
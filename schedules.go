@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	SCHEDULED REPORTS (/schedules)
+
+	Saved reports (/report) can be sent to the owner on a schedule:
+
+	/schedules add weekly-food every sunday 20:00
+	/schedules add spending daily 08:00
+	/schedules add month-end monthly 1 09:00
+	/schedules                       list them
+	/schedules pause|resume|delete <id>
+
+	The scheduler checks them every minute. Like the daily jobs, a report
+	still due today is sent after a restart, and at most once a day; a
+	monthly day past the end of the month means its last day. Text reports
+	go through the outbox, charts and CSV files are sent directly.
+*/
+
+const schedulesUsage = "Usage:\n/schedules add <report> [every] daily|<weekday>|monthly <day> HH:MM\n/schedules pause|resume|delete <id>"
+
+type reportSchedule struct {
+	ID         int64
+	Report     string
+	Frequency  string // daily, weekly or monthly
+	Day        int    // weekday (0 is Sunday) or day of the month
+	At         string // HH:MM
+	Paused     bool
+	LastRunDay sql.NullString
+}
+
+func (s reportSchedule) describe() string {
+	switch s.Frequency {
+	case "weekly":
+		return fmt.Sprintf("every %s at %s", time.Weekday(s.Day), s.At)
+	case "monthly":
+		return fmt.Sprintf("monthly on day %d at %s", s.Day, s.At)
+	}
+	return "daily at " + s.At
+}
+
+// dueOn tells whether the schedule has a run on day's date.
+func (s reportSchedule) dueOn(day time.Time) bool {
+	switch s.Frequency {
+	case "weekly":
+		return int(day.Weekday()) == s.Day
+	case "monthly":
+		return day.Day() == min(s.Day, daysInMonth(day))
+	}
+	return true
+}
+
+// parseScheduleSpec parses "[every] daily|<weekday>|monthly <day> HH:MM".
+func parseScheduleSpec(fields []string) (string, int, string, error) {
+	if len(fields) > 0 && strings.EqualFold(fields[0], "every") {
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return "", 0, "", fmt.Errorf("missing the day or the time")
+	}
+	at := fields[len(fields)-1]
+	if _, err := time.Parse("15:04", at); err != nil {
+		return "", 0, "", fmt.Errorf("invalid time %q, use HH:MM", at)
+	}
+	when := strings.ToLower(fields[0])
+	switch {
+	case len(fields) == 2 && (when == "daily" || when == "day"):
+		return "daily", 0, at, nil
+	case len(fields) == 3 && (when == "monthly" || when == "month"):
+		day, err := strconv.Atoi(fields[1])
+		if err != nil || day < 1 || day > 31 {
+			return "", 0, "", fmt.Errorf("invalid day of the month %q", fields[1])
+		}
+		return "monthly", day, at, nil
+	case len(fields) == 2:
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			name := strings.ToLower(d.String())
+			if when == name || when == name[:3] {
+				return "weekly", int(d), at, nil
+			}
+		}
+	}
+	return "", 0, "", fmt.Errorf("invalid schedule %q", strings.Join(fields, " "))
+}
+
+func loadReportSchedules() ([]reportSchedule, error) {
+	rows, err := db.Query("SELECT id, report, frequency, day, at, paused, last_run FROM report_schedules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []reportSchedule
+	for rows.Next() {
+		var s reportSchedule
+		if err := rows.Scan(&s.ID, &s.Report, &s.Frequency, &s.Day, &s.At, &s.Paused, &s.LastRunDay); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+func handleSchedulesCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		listReportSchedules(chatID)
+		return
+	}
+	switch action := strings.ToLower(fields[0]); {
+	case action == "add" && len(fields) >= 3:
+		addReportSchedule(chatID, strings.ToLower(fields[1]), fields[2:])
+	case (action == "pause" || action == "resume" || action == "delete") && len(fields) == 2:
+		id, err := strconv.ParseInt(strings.TrimPrefix(fields[1], "#"), 10, 64)
+		if err != nil {
+			sendMessage(chatID, schedulesUsage)
+			return
+		}
+		var res sql.Result
+		switch action {
+		case "pause":
+			res, err = db.Exec("UPDATE report_schedules SET paused = 1 WHERE id = ?", id)
+		case "resume":
+			res, err = db.Exec("UPDATE report_schedules SET paused = 0 WHERE id = ?", id)
+		default:
+			res, err = db.Exec("DELETE FROM report_schedules WHERE id = ?", id)
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to update the schedule.")
+			reportError(action+" report schedule", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("No schedule #%d. See /schedules.", id))
+			return
+		}
+		done := map[string]string{"pause": "paused", "resume": "resumed", "delete": "deleted"}[action]
+		sendMessage(chatID, fmt.Sprintf("Schedule #%d %s.", id, done))
+	default:
+		sendMessage(chatID, schedulesUsage)
+	}
+}
+
+func addReportSchedule(chatID int64, report string, spec []string) {
+	if _, err := loadSavedReport(report); err == sql.ErrNoRows {
+		sendMessage(chatID, fmt.Sprintf("No saved report named %q. See /report list.", report))
+		return
+	} else if err != nil {
+		sendMessage(chatID, "Failed to load the report.")
+		reportError("loading saved report "+report, err)
+		return
+	}
+	frequency, day, at, err := parseScheduleSpec(spec)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v.\n%s", err, schedulesUsage))
+		return
+	}
+	// a schedule added after its time today waits for the next occurrence
+	now := appClock.Now()
+	s := reportSchedule{Report: report, Frequency: frequency, Day: day, At: at}
+	var lastRun interface{}
+	if due, _ := time.ParseInLocation("15:04", at, appLocation()); s.dueOn(now) && now.Hour()*60+now.Minute() >= due.Hour()*60+due.Minute() {
+		lastRun = now.Format(dateLayout)
+	}
+	res, err := db.Exec("INSERT INTO report_schedules (report, frequency, day, at, last_run) VALUES (?, ?, ?, ?, ?)",
+		report, frequency, day, at, lastRun)
+	if err != nil {
+		sendMessage(chatID, "Failed to save the schedule.")
+		reportError("saving a report schedule", err)
+		return
+	}
+	s.ID, _ = res.LastInsertId()
+	sendMessage(chatID, fmt.Sprintf("Schedule #%d: %s %s.", s.ID, report, s.describe()))
+}
+
+func listReportSchedules(chatID int64) {
+	schedules, err := loadReportSchedules()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the schedules.")
+		reportError("loading report schedules", err)
+		return
+	}
+	if len(schedules) == 0 {
+		sendMessage(chatID, "No scheduled reports.\n"+schedulesUsage)
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("⏰ Scheduled reports\n\n")
+	for _, s := range schedules {
+		status := ""
+		if s.Paused {
+			status = " (paused)"
+		}
+		sb.WriteString(fmt.Sprintf("#%d %s, %s%s\n", s.ID, s.Report, s.describe(), status))
+	}
+	sb.WriteString("\n/schedules pause|resume|delete <id>")
+	sendMessage(chatID, sb.String())
+}
+
+// runReportSchedules sends the reports due by now that have not been sent
+// today.
+func runReportSchedules(now time.Time) error {
+	schedules, err := loadReportSchedules()
+	if err != nil {
+		return err
+	}
+	today := now.Format(dateLayout)
+	for _, s := range schedules {
+		if s.Paused || !s.dueOn(now) || s.LastRunDay.String == today {
+			continue
+		}
+		at, err := time.Parse("15:04", s.At)
+		if err != nil {
+			log.Printf("Invalid time %q for report schedule #%d", s.At, s.ID)
+			continue
+		}
+		if now.Before(time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())) {
+			continue
+		}
+
+		if err := sendScheduledReport(s, now); err != nil {
+			reportErrorTagged(fmt.Sprintf("sending scheduled report #%d", s.ID), err, map[string]string{"job": "report_schedules"})
+		}
+		// recorded even after a failure, so a broken report is not retried every minute
+		if _, err := db.Exec("UPDATE report_schedules SET last_run = ? WHERE id = ?", today, s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendScheduledReport(s reportSchedule, now time.Time) error {
+	spec, err := loadSavedReport(s.Report)
+	if err == sql.ErrNoRows {
+		text := fmt.Sprintf("⏰ Schedule #%d: the report %q no longer exists. Remove it with /schedules delete %d.", s.ID, s.Report, s.ID)
+		return enqueueNotification(ownerID(), "report", fmt.Sprintf("report:%d:%s", s.ID, now.Format(dateLayout)), text, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if spec.Format == "text" {
+		r, err := runReport(s.Report, spec, now)
+		if err != nil {
+			return err
+		}
+		return enqueueNotification(ownerID(), "report", fmt.Sprintf("report:%d:%s", s.ID, now.Format(dateLayout)), reportText(r), nil)
+	}
+	sendReport(ownerID(), s.Report, spec)
+	return nil
+}
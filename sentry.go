@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+	ERROR TRACKING
+
+	Panics and the errors passed to reportError also go to errorReporter,
+	tagged with the user and command of the update being handled (or the
+	scheduled job). With a Sentry DSN (sentry.dsn in the config file or
+	SENTRY_DSN) events are sent to Sentry's envelope endpoint with plain
+	net/http, like the Telegram client; without one nothing is sent.
+*/
+
+// ErrorReporter sends errors and panics to an error tracking service.
+type ErrorReporter interface {
+	CaptureError(err error, tags map[string]string)
+	CapturePanic(p interface{}, stack []byte, tags map[string]string)
+}
+
+type nopReporter struct{}
+
+func (nopReporter) CaptureError(error, map[string]string)               {}
+func (nopReporter) CapturePanic(interface{}, []byte, map[string]string) {}
+
+var errorReporter ErrorReporter = nopReporter{}
+
+// sentryMaxInFlight bounds the events being sent at once; more are dropped
+// rather than piling up goroutines during an error storm.
+const sentryMaxInFlight = 4
+
+type sentryReporter struct {
+	dsn         string
+	endpoint    string
+	key         string
+	environment string
+	client      *http.Client
+	inFlight    chan struct{}
+}
+
+// parseSentryDSN checks a DSN of the form https://<key>@<host>/<project>
+// and returns the envelope endpoint and the public key.
+func parseSentryDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN, expected https://<key>@<host>/<project>")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	project := path[i+1:]
+	if project == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: no project id")
+	}
+	prefix := ""
+	if i >= 0 {
+		prefix = "/" + path[:i]
+	}
+	return fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project), u.User.Username(), nil
+}
+
+func newSentryReporter(dsn string, environment string) (*sentryReporter, error) {
+	endpoint, key, err := parseSentryDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sentryReporter{
+		dsn:         dsn,
+		endpoint:    endpoint,
+		key:         key,
+		environment: environment,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		inFlight:    make(chan struct{}, sentryMaxInFlight),
+	}, nil
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	User        map[string]string `json:"user,omitempty"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+func (r *sentryReporter) CaptureError(err error, tags map[string]string) {
+	r.capture("error", sentryException{Type: fmt.Sprintf("%T", err), Value: err.Error()}, nil, tags)
+}
+
+func (r *sentryReporter) CapturePanic(p interface{}, stack []byte, tags map[string]string) {
+	r.capture("fatal", sentryException{Type: "panic", Value: fmt.Sprint(p)}, map[string]string{"stack": string(stack)}, tags)
+}
+
+func (r *sentryReporter) capture(level string, exception sentryException, extra map[string]string, tags map[string]string) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Environment: r.environment,
+		Tags:        make(map[string]string),
+		Extra:       extra,
+	}
+	event.ServerName, _ = os.Hostname()
+	event.Exception.Values = []sentryException{exception}
+	for k, v := range tags {
+		if k == "user_id" {
+			event.User = map[string]string{"id": v}
+			continue
+		}
+		event.Tags[k] = v
+	}
+
+	select {
+	case r.inFlight <- struct{}{}:
+	default:
+		log.Printf("Dropped Sentry event %s: too many in flight", event.EventID)
+		return
+	}
+	go func() {
+		defer func() { <-r.inFlight }()
+		if err := r.send(event); err != nil {
+			log.Printf("Failed to send Sentry event %s: %v", event.EventID, err)
+		}
+	}()
+}
+
+// send posts one event as an envelope: a header line, an item header line
+// and the event.
+func (r *sentryReporter) send(event sentryEvent) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, part := range []interface{}{
+		map[string]string{"event_id": event.EventID, "dsn": r.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339)},
+		map[string]string{"type": "event"},
+		event,
+	} {
+		if err := enc.Encode(part); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=ayunda/1.0", r.key))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sentry returned %s", resp.Status)
+	}
+	return nil
+}
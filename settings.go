@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+)
+
+// getSetting returns the value stored under key, or def when it is not set.
+func getSetting(key string, def string) string {
+	var value string
+	err := db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to read setting %s: %v", key, err)
+		}
+		return def
+	}
+	return value
+}
+
+func setSetting(key string, value string) error {
+	_, err := db.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+func getBoolSetting(key string, def bool) bool {
+	value := getSetting(key, "")
+	if value == "" {
+		return def
+	}
+	return parseBool(value)
+}
+
+func getFloatSetting(key string, def float64) float64 {
+	value := getSetting(key, "")
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid value for setting %s: %q", key, value)
+		return def
+	}
+	return f
+}
+
+// getMoneySetting reads an amount stored in major units, e.g. "150000".
+func getMoneySetting(key string, def Money) Money {
+	value := getSetting(key, "")
+	if value == "" {
+		return def
+	}
+	m, err := parseMoney(value)
+	if err != nil {
+		log.Printf("Invalid value for setting %s: %q", key, value)
+		return def
+	}
+	return m
+}
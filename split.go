@@ -0,0 +1,512 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	GROUP MODE: Splitwise-style expense splitting.
+
+	The owner enables a group chat with /groupmode. From then on any member of
+	that group can use /split, /balances and /settleup. Members are discovered
+	as they talk in the group, since the Bot API has no way to list them.
+*/
+
+func isGroupChat(chat *TGChat) bool {
+	return chat != nil && (chat.Type == "group" || chat.Type == "supergroup")
+}
+
+// handleGroupMessage handles every message coming from a group chat.
+// Only split commands are available there; everything else is ignored so the
+// bot stays quiet in normal group conversation.
+func handleGroupMessage(message *TGMessage, command string, args string) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	rememberGroupMember(chatID, message.From)
+
+	if command == "groupmode" {
+		if userID != ownerID() {
+			sendMessage(chatID, "Only the bot owner can enable group mode.")
+			return
+		}
+		toggleGroupMode(chatID, userID, strings.TrimSpace(args))
+		return
+	}
+
+	if command == "" || !isSplitGroup(chatID) {
+		return
+	}
+
+	if isMaintenanceMode() && !maintenanceMayRun(command, args) {
+		sendMessage(chatID, maintenanceRefusal)
+		return
+	}
+
+	switch command {
+	case "split":
+		startSplit(message, args)
+	case "balances":
+		showBalances(chatID)
+	case "settleup":
+		showSettleUp(chatID)
+	}
+}
+
+func toggleGroupMode(chatID int64, userID int64, arg string) {
+	if strings.ToLower(arg) == "off" {
+		if _, err := db.Exec("DELETE FROM split_groups WHERE chat_id = ?", chatID); err != nil {
+			log.Printf("Failed to disable group mode: %v", err)
+			sendMessage(chatID, "Failed to disable group mode.")
+			return
+		}
+		sendMessage(chatID, "Group mode disabled for this chat.")
+		return
+	}
+	if _, err := db.Exec("INSERT OR IGNORE INTO split_groups (chat_id, enabled_by) VALUES (?, ?)", chatID, userID); err != nil {
+		log.Printf("Failed to enable group mode: %v", err)
+		sendMessage(chatID, "Failed to enable group mode.")
+		return
+	}
+	sendMessage(chatID, "Group mode enabled. Members who have written in this chat can now be included in splits.\n\n/split <amount> <description> - split an expense you paid\n/balances - who owes whom\n/settleup - simplify and record settlements")
+}
+
+func isSplitGroup(chatID int64) bool {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM split_groups WHERE chat_id = ?", chatID).Scan(&n); err != nil {
+		log.Printf("Failed to check group mode: %v", err)
+		return false
+	}
+	return n > 0
+}
+
+func displayName(user *TGUser) string {
+	if user.UserName != "" {
+		return "@" + user.UserName
+	}
+	if user.FirstName != "" {
+		return user.FirstName
+	}
+	return strconv.FormatInt(user.ID, 10)
+}
+
+func rememberGroupMember(chatID int64, user *TGUser) {
+	_, err := db.Exec(`INSERT INTO group_members (chat_id, user_id, name, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(chat_id, user_id) DO UPDATE SET name = excluded.name, updated_at = CURRENT_TIMESTAMP`,
+		chatID, user.ID, displayName(user))
+	if err != nil {
+		log.Printf("Failed to remember group member: %v", err)
+	}
+}
+
+type groupMember struct {
+	UserID int64
+	Name   string
+}
+
+func loadGroupMembers(chatID int64) ([]groupMember, error) {
+	rows, err := db.Query("SELECT user_id, name FROM group_members WHERE chat_id = ? ORDER BY name", chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []groupMember
+	for rows.Next() {
+		var m groupMember
+		if err := rows.Scan(&m.UserID, &m.Name); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+func memberNames(chatID int64) map[int64]string {
+	names := make(map[int64]string)
+	members, err := loadGroupMembers(chatID)
+	if err != nil {
+		log.Printf("Failed to load group members: %v", err)
+		return names
+	}
+	for _, m := range members {
+		names[m.UserID] = m.Name
+	}
+	return names
+}
+
+func nameOf(names map[int64]string, userID int64) string {
+	if n, ok := names[userID]; ok {
+		return n
+	}
+	return strconv.FormatInt(userID, 10)
+}
+
+// startSplit parses "/split <amount> <description>" and shows the member picker.
+func startSplit(message *TGMessage, args string) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	amount, err := parseMoney(parts[0])
+	if err != nil || amount <= 0 {
+		sendMessage(chatID, "Usage: /split <amount> <description>")
+		return
+	}
+	description := ""
+	if len(parts) > 1 {
+		description = strings.TrimSpace(parts[1])
+	}
+	if len(description) > 100 {
+		sendMessage(chatID, "Description too long. Please keep it under 100 characters.")
+		return
+	}
+
+	members, err := loadGroupMembers(chatID)
+	if err != nil {
+		log.Printf("Failed to load group members: %v", err)
+		sendMessage(chatID, "Failed to load group members.")
+		return
+	}
+	if len(members) < 2 {
+		sendMessage(chatID, "I only know one member of this group so far. Ask the others to say something in the chat first.")
+		return
+	}
+
+	state := &TransactionState{
+		UserID:       userID,
+		Step:         "SELECT_SPLIT_MEMBERS",
+		Amount:       amount,
+		Description:  description,
+		SplitChatID:  chatID,
+		SplitMembers: make(map[int64]bool),
+	}
+	for _, m := range members {
+		state.SplitMembers[m.UserID] = true
+	}
+	userStates[userID] = state
+
+	sendMessageWithKeyboard(chatID, splitPrompt(state), splitKeyboard(state, members))
+}
+
+func splitPrompt(state *TransactionState) string {
+	return fmt.Sprintf("Split %s for \"%s\".\nSelect who shares this expense:", formatMoney(state.Amount), state.Description)
+}
+
+func splitKeyboard(state *TransactionState, members []groupMember) InlineKeyboardMarkup {
+	buttons := make([][]InlineKeyboardButton, 0, len(members)+1)
+	for _, m := range members {
+		mark := "⬜"
+		if state.SplitMembers[m.UserID] {
+			mark = "✅"
+		}
+		buttons = append(buttons, []InlineKeyboardButton{
+			{Text: fmt.Sprintf("%s %s", mark, m.Name), CallbackData: fmt.Sprintf("split:toggle:%d", m.UserID)},
+		})
+	}
+	buttons = append(buttons, []InlineKeyboardButton{
+		{Text: "Done", CallbackData: "split:done"},
+		{Text: "Cancel", CallbackData: "split:cancel"},
+	})
+	return buildKeyboard(buttons)
+}
+
+// handleSplitCallback handles every callback whose data starts with "split:".
+// Member selection belongs to whoever started the split; settlement buttons
+// can only be confirmed by the two people involved.
+func handleSplitCallback(callback *CallbackQuery) {
+	if callback.Message == nil || !isSplitGroup(callback.Message.Chat.ID) {
+		_ = messenger.AnswerCallback(callback.ID, "")
+		return
+	}
+	chatID := callback.Message.Chat.ID
+	msgID := callback.Message.MessageID
+	parts := strings.Split(callback.Data, ":")
+
+	if len(parts) >= 2 && parts[1] == "settle" {
+		processSettleCallback(callback, parts)
+		return
+	}
+
+	state, exists := userStates[callback.From.ID]
+	if !exists || state.Step != "SELECT_SPLIT_MEMBERS" || state.SplitChatID != chatID {
+		_ = messenger.AnswerCallback(callback.ID, "Only the person who started this split can change it.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	switch {
+	case len(parts) == 3 && parts[1] == "toggle":
+		memberID, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return
+		}
+		state.SplitMembers[memberID] = !state.SplitMembers[memberID]
+		members, err := loadGroupMembers(chatID)
+		if err != nil {
+			log.Printf("Failed to load group members: %v", err)
+			return
+		}
+		editMessageWithKeyboard(chatID, msgID, splitPrompt(state), splitKeyboard(state, members))
+	case len(parts) == 2 && parts[1] == "done":
+		saveSplit(chatID, msgID, state)
+	case len(parts) == 2 && parts[1] == "cancel":
+		editMessage(chatID, msgID, "Split canceled.")
+		delete(userStates, state.UserID)
+	}
+}
+
+// splitShares divides amount equally between members, assigning any rounding
+// remainder to the first member so the shares always add up to the total.
+func splitShares(amount Money, members []int64) map[int64]Money {
+	shares := make(map[int64]Money, len(members))
+	if len(members) == 0 {
+		return shares
+	}
+	each := amount / Money(len(members))
+	for _, m := range members {
+		shares[m] = each
+	}
+	shares[members[0]] += amount - each*Money(len(members))
+	return shares
+}
+
+func saveSplit(chatID int64, msgID int, state *TransactionState) {
+	var selected []int64
+	for id, on := range state.SplitMembers {
+		if on {
+			selected = append(selected, id)
+		}
+	}
+	if len(selected) == 0 {
+		editMessage(chatID, msgID, "No members selected. Split canceled.")
+		delete(userStates, state.UserID)
+		return
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i] < selected[j] })
+	shares := splitShares(state.Amount, selected)
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin split transaction: %v", err)
+		editMessage(chatID, msgID, "Failed to save split.")
+		delete(userStates, state.UserID)
+		return
+	}
+	defer tx.Rollback()
+
+	currentTime := appClock.Now()
+	res, err := tx.Exec("INSERT INTO split_expenses (chat_id, payer_id, amount, description, created_at) VALUES (?, ?, ?, ?, ?)",
+		chatID, state.UserID, state.Amount, state.Description, currentTime.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Printf("Failed to insert split expense: %v", err)
+		editMessage(chatID, msgID, "Failed to save split.")
+		delete(userStates, state.UserID)
+		return
+	}
+	expenseID, _ := res.LastInsertId()
+	for _, id := range selected {
+		if _, err := tx.Exec("INSERT INTO split_shares (expense_id, user_id, share) VALUES (?, ?, ?)", expenseID, id, shares[id]); err != nil {
+			log.Printf("Failed to insert split share: %v", err)
+			editMessage(chatID, msgID, "Failed to save split.")
+			delete(userStates, state.UserID)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit split: %v", err)
+		editMessage(chatID, msgID, "Failed to save split.")
+		delete(userStates, state.UserID)
+		return
+	}
+
+	names := memberNames(chatID)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Split #%d saved: %s paid %s for \"%s\".\n\n", expenseID, nameOf(names, state.UserID), formatMoney(state.Amount), state.Description))
+	for _, id := range selected {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", nameOf(names, id), formatMoney(shares[id])))
+	}
+	editMessage(chatID, msgID, sb.String())
+	delete(userStates, state.UserID)
+}
+
+// groupBalances returns the net position of every member of the group:
+// positive means the member is owed money, negative means they owe.
+func groupBalances(chatID int64) (map[int64]Money, error) {
+	balances := make(map[int64]Money)
+	queries := []string{
+		// paid for shared expenses
+		"SELECT payer_id, SUM(amount) FROM split_expenses WHERE chat_id = ? GROUP BY payer_id",
+		// own shares of expenses
+		"SELECT s.user_id, -SUM(s.share) FROM split_shares s JOIN split_expenses e ON e.id = s.expense_id WHERE e.chat_id = ? GROUP BY s.user_id",
+		// settlements paid out
+		"SELECT from_user, SUM(amount) FROM split_settlements WHERE chat_id = ? GROUP BY from_user",
+		// settlements received
+		"SELECT to_user, -SUM(amount) FROM split_settlements WHERE chat_id = ? GROUP BY to_user",
+	}
+	for _, q := range queries {
+		rows, err := db.Query(q, chatID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var userID int64
+			var total Money
+			if err := rows.Scan(&userID, &total); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			balances[userID] += total
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return balances, nil
+}
+
+type settlement struct {
+	From   int64
+	To     int64
+	Amount Money
+}
+
+// simplifyDebts reduces the balances to a minimal-ish list of transfers by
+// repeatedly matching the largest debtor with the largest creditor.
+func simplifyDebts(balances map[int64]Money) []settlement {
+	type entry struct {
+		id  int64
+		amt Money
+	}
+	var debtors, creditors []entry
+	for id, b := range balances {
+		if b < 0 {
+			debtors = append(debtors, entry{id, -b})
+		} else if b > 0 {
+			creditors = append(creditors, entry{id, b})
+		}
+	}
+	byAmount := func(s []entry) {
+		sort.Slice(s, func(i, j int) bool {
+			if s[i].amt == s[j].amt {
+				return s[i].id < s[j].id
+			}
+			return s[i].amt > s[j].amt
+		})
+	}
+
+	var result []settlement
+	for len(debtors) > 0 && len(creditors) > 0 {
+		byAmount(debtors)
+		byAmount(creditors)
+		pay := min(debtors[0].amt, creditors[0].amt)
+		result = append(result, settlement{From: debtors[0].id, To: creditors[0].id, Amount: pay})
+		debtors[0].amt -= pay
+		creditors[0].amt -= pay
+		if debtors[0].amt == 0 {
+			debtors = debtors[1:]
+		}
+		if creditors[0].amt == 0 {
+			creditors = creditors[1:]
+		}
+	}
+	return result
+}
+
+func showBalances(chatID int64) {
+	balances, err := groupBalances(chatID)
+	if err != nil {
+		log.Printf("Failed to compute balances: %v", err)
+		sendMessage(chatID, "Failed to compute balances.")
+		return
+	}
+	names := memberNames(chatID)
+
+	var ids []int64
+	for id, b := range balances {
+		if b != 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		sendMessage(chatID, "Everyone is settled up.")
+		return
+	}
+	sort.Slice(ids, func(i, j int) bool { return balances[ids[i]] > balances[ids[j]] })
+
+	var sb strings.Builder
+	sb.WriteString("Group balances:\n\n")
+	for _, id := range ids {
+		if balances[id] > 0 {
+			sb.WriteString(fmt.Sprintf("%s is owed %s\n", nameOf(names, id), formatMoney(balances[id])))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s owes %s\n", nameOf(names, id), formatMoney(-balances[id])))
+		}
+	}
+	sb.WriteString("\nUse /settleup to see who should pay whom.")
+	sendMessage(chatID, sb.String())
+}
+
+func showSettleUp(chatID int64) {
+	balances, err := groupBalances(chatID)
+	if err != nil {
+		log.Printf("Failed to compute balances: %v", err)
+		sendMessage(chatID, "Failed to compute balances.")
+		return
+	}
+	plan := simplifyDebts(balances)
+	if len(plan) == 0 {
+		sendMessage(chatID, "Everyone is settled up.")
+		return
+	}
+	names := memberNames(chatID)
+
+	var sb strings.Builder
+	sb.WriteString("Suggested settlements:\n\n")
+	buttons := make([][]InlineKeyboardButton, 0, len(plan))
+	for _, s := range plan {
+		line := fmt.Sprintf("%s pays %s %s", nameOf(names, s.From), nameOf(names, s.To), formatMoney(s.Amount))
+		sb.WriteString(line + "\n")
+		buttons = append(buttons, []InlineKeyboardButton{
+			{Text: "Record: " + line, CallbackData: fmt.Sprintf("split:settle:%d:%d:%d", s.From, s.To, int64(s.Amount))},
+		})
+	}
+	sb.WriteString("\nTap a settlement once the money has changed hands.")
+	sendMessageWithKeyboard(chatID, sb.String(), buildKeyboard(buttons))
+}
+
+// processSettleCallback records a settlement from "split:settle:<from>:<to>:<cents>".
+func processSettleCallback(callback *CallbackQuery, parts []string) {
+	chatID := callback.Message.Chat.ID
+	if len(parts) != 5 {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid settlement.")
+		return
+	}
+	from, err1 := strconv.ParseInt(parts[2], 10, 64)
+	to, err2 := strconv.ParseInt(parts[3], 10, 64)
+	cents, err3 := strconv.ParseInt(parts[4], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || cents <= 0 {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid settlement.")
+		return
+	}
+	if callback.From.ID != from && callback.From.ID != to {
+		_ = messenger.AnswerCallback(callback.ID, "Only the payer or the receiver can record this settlement.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	amount := Money(cents)
+	if _, err := db.Exec("INSERT INTO split_settlements (chat_id, from_user, to_user, amount) VALUES (?, ?, ?, ?)", chatID, from, to, amount); err != nil {
+		log.Printf("Failed to record settlement: %v", err)
+		sendMessage(chatID, "Failed to record settlement.")
+		return
+	}
+	names := memberNames(chatID)
+	editMessage(chatID, callback.Message.MessageID, fmt.Sprintf("Settlement recorded: %s paid %s %s.\nRun /settleup again to see what is left.", nameOf(names, from), nameOf(names, to), formatMoney(amount)))
+}
@@ -0,0 +1,49 @@
+import json
+import os
+
+import matplotlib
+
+# Applies the chart theme the bot passes as JSON in the CHART_THEME
+# environment variable:
+#   {"background": "light" or "dark", "palette": ["#4A90D9", ...],
+#    "font": "DejaVu Sans"}
+# An empty palette keeps the chart's own colors, an empty font
+# matplotlib's default. Without the variable the charts look as before.
+
+# ================== BACKGROUNDS ==================
+# background, text, muted text (ticks, grid), empty cells
+BACKGROUNDS = {
+    "light": ("#FFFFFF", "#222222", "#808080", "#EBEDF0"),
+    # Telegram's night mode background, so the image blends in
+    "dark": ("#17212B", "#E6E9ED", "#8B98A5", "#2B3A4A"),
+}
+
+
+class Theme:
+    def __init__(self, settings):
+        self.name = settings.get("background") or "light"
+        self.background, self.text, self.muted, self.empty = BACKGROUNDS.get(self.name, BACKGROUNDS["light"])
+        self.palette = settings.get("palette") or []
+        self.font = settings.get("font") or ""
+
+    def colors(self, defaults):
+        """Returns as many colors as defaults, taken from the palette if any."""
+        if not self.palette:
+            return list(defaults)
+        return [self.palette[i % len(self.palette)] for i in range(len(defaults))]
+
+
+def apply_theme():
+    """Sets the matplotlib defaults of the theme and returns it."""
+    raw = os.environ.get("CHART_THEME")
+    theme = Theme(json.loads(raw) if raw else {})
+    rc = matplotlib.rcParams
+    for key in ("figure.facecolor", "axes.facecolor", "savefig.facecolor"):
+        rc[key] = theme.background
+    for key in ("text.color", "axes.labelcolor", "axes.edgecolor", "xtick.color", "ytick.color"):
+        rc[key] = theme.text
+    rc["grid.color"] = theme.muted
+    if theme.font:
+        # a font that is not installed falls back to the default, with a warning
+        rc["font.family"] = theme.font
+    return theme
@@ -0,0 +1,63 @@
+import json
+import sys
+
+import matplotlib
+
+matplotlib.use("Agg")
+import matplotlib.pyplot as plt
+
+from chart_theme import apply_theme
+
+# Draws /forecast <category>: the monthly totals of a category as a line,
+# then the forecast as a dashed line inside its confidence band. The bot
+# passes the data as JSON on stdin:
+#   {"title": ..., "months": ["Jan 26", ...], "actual": [total, ...],
+#    "forecast": [total, ...], "low": [...], "high": [...]}
+# where months covers the actual months followed by the forecast ones, and
+# the path of the PNG to write as the only argument.
+
+# ================== INPUT ==================
+if len(sys.argv) != 2:
+    sys.exit("usage: g_category_forecast_chart.py OUTPUT.png < data.json")
+
+IMAGE_PATH = sys.argv[1]
+data = json.load(sys.stdin)
+months = data["months"]
+actual = data["actual"]
+forecast = data["forecast"]
+low = data["low"]
+high = data["high"]
+
+# ================== COLORS ==================
+theme = apply_theme()
+LINE_COLOR, FORECAST_COLOR = theme.colors(["#4A90D9", "#F5A623"])
+BAND_COLOR = FORECAST_COLOR
+
+# ================== FIGURE ==================
+fig, ax = plt.subplots(figsize=(10, 5))
+
+xs = list(range(len(actual)))
+ax.plot(xs, actual, color=LINE_COLOR, linewidth=2, marker="o", label="Actual", zorder=3)
+
+# the forecast starts from the last actual month so the lines connect
+fx = [len(actual) - 1] + [len(actual) + i for i in range(len(forecast))]
+ax.plot(fx, [actual[-1]] + forecast, color=FORECAST_COLOR, linewidth=2, linestyle="--", marker="o", label="Forecast", zorder=3)
+ax.fill_between(fx, [actual[-1]] + low, [actual[-1]] + high, color=BAND_COLOR, alpha=0.2, label="Likely range")
+
+for x, y in zip(fx[1:], forecast):
+    ax.annotate(f"{y:,.0f}", (x, y), textcoords="offset points", xytext=(0, 8), ha="center", fontsize=8)
+
+ax.set_xticks(range(len(months)))
+ax.set_xticklabels(months, fontsize=8, rotation=45, ha="right")
+ax.set_ylim(bottom=0)
+ax.yaxis.set_major_formatter(matplotlib.ticker.FuncFormatter(lambda v, _: f"{v:,.0f}"))
+ax.set_title(data["title"], fontsize=12)
+ax.legend(loc="best", frameon=False)
+ax.spines["top"].set_visible(False)
+ax.spines["right"].set_visible(False)
+ax.grid(axis="y", alpha=0.3)
+
+# ================== SAVE PNG ==================
+plt.tight_layout()
+plt.savefig(IMAGE_PATH, dpi=200, bbox_inches="tight")
+plt.close()
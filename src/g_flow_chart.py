@@ -0,0 +1,127 @@
+import json
+import sys
+
+import matplotlib
+
+matplotlib.use("Agg")
+import matplotlib.pyplot as plt
+from matplotlib.path import Path
+from matplotlib.patches import PathPatch, Rectangle
+
+from chart_theme import apply_theme
+
+# Draws the /flow diagram: income sources -> budget pool -> expense
+# categories. The bot passes the data as JSON on stdin:
+#   {"title": ..., "pool": ..., "total": ...,
+#    "sources": [{"name": ..., "amount": ...}], "sinks": [...]}
+# and the path of the PNG to write as the only argument.
+
+# ================== INPUT ==================
+if len(sys.argv) != 2:
+    sys.exit("usage: g_flow_chart.py OUTPUT.png < data.json")
+
+IMAGE_PATH = sys.argv[1]
+data = json.load(sys.stdin)
+sources = data["sources"]
+sinks = data["sinks"]
+total = data["total"]
+
+# ================== COLORS (PASTEL) ==================
+theme = apply_theme()
+pastel_colors = theme.colors([
+    "#FFB3BA", "#FFDFBA", "#FFFFBA",
+    "#BAFFC9", "#BAE1FF", "#D7BAFF",
+    "#FFC6E5", "#C6FFF3"
+])
+SPECIAL_COLORS = {"Saved": "#8FD19E", "Savings": "#C8C8C8"}
+POOL_COLOR = pastel_colors[4]
+
+# ================== LAYOUT ==================
+NODE_WIDTH = 0.025
+GAP = 0.025
+X_SOURCE = 0.22
+X_POOL = 0.5 - NODE_WIDTH / 2
+X_SINK = 0.78 - NODE_WIDTH
+TOP = 0.92
+BOTTOM = 0.04
+
+# one scale for both columns, so a band is as thick on both ends
+most_nodes = max(len(sources), len(sinks), 1)
+scale = (TOP - BOTTOM - GAP * (most_nodes - 1)) / total
+
+
+def stack(nodes):
+    """Returns (top, bottom) of each node, centered in the plot area."""
+    height = sum(n["amount"] for n in nodes) * scale + GAP * (len(nodes) - 1)
+    y = (TOP + BOTTOM + height) / 2
+    spans = []
+    for n in nodes:
+        h = n["amount"] * scale
+        spans.append((y, y - h))
+        y -= h + GAP
+    return spans
+
+
+def color_of(i, name):
+    return SPECIAL_COLORS.get(name, pastel_colors[i % len(pastel_colors)])
+
+
+def band(ax, x0, top0, bottom0, x1, top1, bottom1, color):
+    """Draws a ribbon from one node edge to another."""
+    mid = (x0 + x1) / 2
+    verts = [
+        (x0, top0), (mid, top0), (mid, top1), (x1, top1),
+        (x1, bottom1), (mid, bottom1), (mid, bottom0), (x0, bottom0),
+        (x0, top0),
+    ]
+    codes = [
+        Path.MOVETO, Path.CURVE4, Path.CURVE4, Path.CURVE4,
+        Path.LINETO, Path.CURVE4, Path.CURVE4, Path.CURVE4,
+        Path.CLOSEPOLY,
+    ]
+    ax.add_patch(PathPatch(Path(verts, codes), facecolor=color, edgecolor="none", alpha=0.6))
+
+
+def label(n):
+    return f"{n['name']}\n{n['amount']:,.0f} ({n['amount'] / total * 100:.0f}%)"
+
+
+# ================== FIGURE ==================
+fig, ax = plt.subplots(figsize=(10, 6))
+ax.set_xlim(0, 1)
+ax.set_ylim(0, 1)
+ax.axis("off")
+
+pool_top = (TOP + BOTTOM + total * scale) / 2
+ax.add_patch(Rectangle((X_POOL, pool_top - total * scale), NODE_WIDTH, total * scale, color=POOL_COLOR))
+ax.text(
+    X_POOL + NODE_WIDTH / 2, pool_top + 0.015,
+    f"{data['pool']}\n{total:,.0f}",
+    ha="center", va="bottom", fontsize=10, fontweight="bold"
+)
+
+# --- Sources into the pool ---
+y = pool_top
+for i, (n, (top, bottom)) in enumerate(zip(sources, stack(sources))):
+    color = color_of(i, n["name"])
+    ax.add_patch(Rectangle((X_SOURCE, bottom), NODE_WIDTH, top - bottom, color=color))
+    h = n["amount"] * scale
+    band(ax, X_SOURCE + NODE_WIDTH, top, bottom, X_POOL, y, y - h, color)
+    y -= h
+    ax.text(X_SOURCE - 0.01, (top + bottom) / 2, label(n), ha="right", va="center", fontsize=9)
+
+# --- Pool out to the sinks ---
+y = pool_top
+for i, (n, (top, bottom)) in enumerate(zip(sinks, stack(sinks))):
+    color = color_of(i, n["name"])
+    ax.add_patch(Rectangle((X_SINK, bottom), NODE_WIDTH, top - bottom, color=color))
+    h = n["amount"] * scale
+    band(ax, X_POOL + NODE_WIDTH, y, y - h, X_SINK, top, bottom, color)
+    y -= h
+    ax.text(X_SINK + NODE_WIDTH + 0.01, (top + bottom) / 2, label(n), ha="left", va="center", fontsize=9)
+
+ax.set_title(data["title"], fontsize=12)
+
+# ================== SAVE PNG ==================
+plt.savefig(IMAGE_PATH, dpi=200, bbox_inches="tight")
+plt.close()
@@ -0,0 +1,82 @@
+import json
+import sys
+from datetime import date, timedelta
+
+import matplotlib
+
+matplotlib.use("Agg")
+import matplotlib.pyplot as plt
+from matplotlib.patches import Rectangle
+import numpy as np
+
+from chart_theme import apply_theme
+
+# Draws the /heatmap calendar: one square per day, one column per week,
+# colored by the day's expenses. The bot passes the data as JSON on stdin:
+#   {"title": ..., "start": "YYYY-MM-DD", "weekdays": ["Mon", ...],
+#    "days": [amount or null, ...]}
+# and the path of the PNG to write as the only argument.
+
+# ================== INPUT ==================
+if len(sys.argv) != 2:
+    sys.exit("usage: g_heatmap_chart.py OUTPUT.png < data.json")
+
+IMAGE_PATH = sys.argv[1]
+data = json.load(sys.stdin)
+start = date.fromisoformat(data["start"])
+days = data["days"]
+weeks = (len(days) + 6) // 7
+
+# ================== COLORS ==================
+theme = apply_theme()
+EMPTY_COLOR = theme.empty
+LEVEL_COLORS = ["#FFE0B2", "#FFB74D", "#F57C00", "#BF360C"]
+
+# quartiles of the days with spending, so one huge day does not wash out the rest
+spent = np.array([d for d in days if d])
+bounds = np.percentile(spent, [25, 50, 75]) if len(spent) else []
+
+
+def color_of(amount):
+    if not amount:
+        return EMPTY_COLOR
+    return LEVEL_COLORS[int(np.searchsorted(bounds, amount, side="left"))]
+
+
+# ================== FIGURE ==================
+fig, ax = plt.subplots(figsize=(max(6, weeks * 0.22 + 1.5), 2.6))
+ax.set_xlim(-0.5, weeks)
+ax.set_ylim(8.6, -1.2)
+ax.set_aspect("equal")
+ax.axis("off")
+
+for i, amount in enumerate(days):
+    if amount is None:
+        continue
+    week, row = divmod(i, 7)
+    ax.add_patch(Rectangle((week + 0.05, row + 0.05), 0.9, 0.9, color=color_of(amount), linewidth=0))
+
+# --- Weekday labels on every other row ---
+for row in range(0, 7, 2):
+    ax.text(-0.3, row + 0.5, data["weekdays"][row], ha="right", va="center", fontsize=7, color=theme.muted)
+
+# --- Month labels above the week holding the 1st ---
+for week in range(weeks):
+    for row in range(7):
+        i = week * 7 + row
+        day = start + timedelta(days=i)
+        if i < len(days) and days[i] is not None and day.day == 1:
+            ax.text(week, -0.4, day.strftime("%b"), ha="left", va="bottom", fontsize=7, color=theme.muted)
+
+# --- Legend ---
+x = weeks - len(LEVEL_COLORS) - 3
+ax.text(x - 0.2, 7.85, "Less", ha="right", va="center", fontsize=7, color=theme.muted)
+for j, color in enumerate([EMPTY_COLOR] + LEVEL_COLORS):
+    ax.add_patch(Rectangle((x + j + 0.05, 7.4), 0.9, 0.9, color=color, linewidth=0))
+ax.text(x + len(LEVEL_COLORS) + 1.2, 7.85, "More", ha="left", va="center", fontsize=7, color=theme.muted)
+
+ax.set_title(data["title"], fontsize=10, loc="left")
+
+# ================== SAVE PNG ==================
+plt.savefig(IMAGE_PATH, dpi=200, bbox_inches="tight")
+plt.close()
@@ -0,0 +1,69 @@
+import json
+import sys
+
+import matplotlib
+
+matplotlib.use("Agg")
+import matplotlib.pyplot as plt
+
+from chart_theme import apply_theme
+
+# Draws a custom /report as a bar chart, one bar per group and series.
+# The bot passes the data as JSON on stdin:
+#   {"title": ..., "labels": [...], "horizontal": true,
+#    "series": [{"name": "Expense", "values": [...]}, ...]}
+# and the path of the PNG to write as the only argument.
+
+# ================== INPUT ==================
+if len(sys.argv) != 2:
+    sys.exit("usage: g_report_chart.py OUTPUT.png < data.json")
+
+IMAGE_PATH = sys.argv[1]
+data = json.load(sys.stdin)
+labels = data["labels"]
+series = data["series"]
+horizontal = data["horizontal"]
+
+# ================== COLORS ==================
+theme = apply_theme()
+INCOME_COLOR, EXPENSE_COLOR, OTHER_COLOR = theme.colors(["#8FD19E", "#FFB3BA", "#BAE1FF"])
+SERIES_COLORS = {"Income": INCOME_COLOR, "Expense": EXPENSE_COLOR}
+
+# ================== FIGURE ==================
+n = len(labels)
+if horizontal:
+    fig, ax = plt.subplots(figsize=(10, max(3, 0.4 * n + 1.5)))
+else:
+    fig, ax = plt.subplots(figsize=(max(6, 0.6 * n + 2), 5))
+
+width = 0.8 / len(series)
+for j, s in enumerate(series):
+    # the first label on top for horizontal bars, on the left otherwise
+    positions = [(n - 1 - i if horizontal else i) + (j - (len(series) - 1) / 2) * width for i in range(n)]
+    color = SERIES_COLORS.get(s["name"], OTHER_COLOR)
+    if horizontal:
+        bars = ax.barh(positions, s["values"], height=width, color=color, label=s["name"])
+    else:
+        bars = ax.bar(positions, s["values"], width=width, color=color, label=s["name"])
+    ax.bar_label(bars, labels=[f"{v:,.0f}" if v else "" for v in s["values"]], fontsize=8, padding=2)
+
+ticks = [n - 1 - i for i in range(n)] if horizontal else list(range(n))
+if horizontal:
+    ax.set_yticks(ticks)
+    ax.set_yticklabels(labels, fontsize=9)
+    ax.grid(axis="x", alpha=0.3)
+else:
+    ax.set_xticks(ticks)
+    ax.set_xticklabels(labels, fontsize=9, rotation=30, ha="right")
+    ax.grid(axis="y", alpha=0.3)
+
+if len(series) > 1:
+    ax.legend(frameon=False)
+ax.spines["top"].set_visible(False)
+ax.spines["right"].set_visible(False)
+ax.set_title(data["title"], fontsize=12)
+
+# ================== SAVE PNG ==================
+plt.tight_layout()
+plt.savefig(IMAGE_PATH, dpi=200, bbox_inches="tight")
+plt.close()
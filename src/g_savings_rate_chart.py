@@ -0,0 +1,63 @@
+import json
+import sys
+
+import matplotlib
+
+matplotlib.use("Agg")
+import matplotlib.pyplot as plt
+
+from chart_theme import apply_theme
+
+# Draws the /savingsrate trend: the savings rate of each month as a line,
+# with the target as a dashed line. The bot passes the data as JSON on
+# stdin:
+#   {"title": ..., "months": ["Jan 26", ...], "rates": [percent or null, ...],
+#    "target": percent or null}
+# and the path of the PNG to write as the only argument.
+
+# ================== INPUT ==================
+if len(sys.argv) != 2:
+    sys.exit("usage: g_savings_rate_chart.py OUTPUT.png < data.json")
+
+IMAGE_PATH = sys.argv[1]
+data = json.load(sys.stdin)
+months = data["months"]
+rates = data["rates"]
+target = data["target"]
+
+# ================== COLORS ==================
+theme = apply_theme()
+LINE_COLOR = theme.colors(["#4A90D9"])[0]
+TARGET_COLOR = "#8FD19E"
+MISS_COLOR = "#FF6F61"
+
+# ================== FIGURE ==================
+fig, ax = plt.subplots(figsize=(10, 5))
+
+xs = [i for i, r in enumerate(rates) if r is not None]
+ys = [rates[i] for i in xs]
+ax.plot(xs, ys, color=LINE_COLOR, linewidth=2, marker="o", zorder=3)
+
+if target is not None:
+    ax.axhline(target, color=TARGET_COLOR, linestyle="--", linewidth=1.5, label=f"Target {target:g}%")
+    # months below the target get a red marker
+    missed = [i for i in xs if rates[i] < target]
+    ax.scatter(missed, [rates[i] for i in missed], color=MISS_COLOR, zorder=4)
+    ax.legend(loc="best", frameon=False)
+
+for x, y in zip(xs, ys):
+    ax.annotate(f"{y:.0f}%", (x, y), textcoords="offset points", xytext=(0, 8), ha="center", fontsize=8)
+
+ax.axhline(0, color=theme.muted, linewidth=0.8)
+ax.set_xticks(range(len(months)))
+ax.set_xticklabels(months, fontsize=9)
+ax.set_ylabel("Saved, % of income")
+ax.set_title(data["title"], fontsize=12)
+ax.spines["top"].set_visible(False)
+ax.spines["right"].set_visible(False)
+ax.grid(axis="y", alpha=0.3)
+
+# ================== SAVE PNG ==================
+plt.tight_layout()
+plt.savefig(IMAGE_PATH, dpi=200, bbox_inches="tight")
+plt.close()
@@ -5,6 +5,7 @@ from collections import defaultdict
 import calendar
 from dotenv import load_dotenv
 import os
+import sys
 import requests
 
 # ================== CONFIG ==================
@@ -16,6 +17,11 @@ TELEGRAM_BOT_TOKEN = os.getenv("API_TOKEN")
 TELEGRAM_USER_ID = os.getenv("ALLOWED_USER_ID")
 DB_PATH = os.getenv("DB_PATH")
 
+# plain sqlite3 cannot open a database encrypted with SQLCipher; the
+# charts the bot draws get their data from the bot instead
+if os.getenv("DB_KEY"):
+    sys.exit("g_stack_a_chart.py cannot read a database encrypted with DB_KEY; use /trend in the bot instead.")
+
 IMAGE_PATH = "expense_compare_month.png"
 
 # ================== DATE LOGIC ==================
@@ -55,7 +61,7 @@ QUERY = """
 SELECT
     CAST(strftime('%d', created_at) AS INTEGER) as day,
     category,
-    SUM(amount)
+    SUM(ROUND(quantity * amount)) / 100.0
 FROM transactions
 WHERE type = 'expense'
 AND created_at >= ?
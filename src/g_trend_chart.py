@@ -0,0 +1,76 @@
+import json
+import sys
+
+import matplotlib
+
+matplotlib.use("Agg")
+import matplotlib.pyplot as plt
+import numpy as np
+
+from chart_theme import apply_theme
+
+# Draws /trend: the monthly expenses of each category as a stacked area,
+# or as grouped bars. The bot passes the data as JSON on stdin:
+#   {"title": ..., "style": "area" or "bars", "months": ["Jan 26", ...],
+#    "series": [{"name": ..., "values": [total per month, ...]}, ...]}
+# largest series first, and the path of the PNG to write as the only
+# argument.
+
+# ================== INPUT ==================
+if len(sys.argv) != 2:
+    sys.exit("usage: g_trend_chart.py OUTPUT.png < data.json")
+
+IMAGE_PATH = sys.argv[1]
+data = json.load(sys.stdin)
+months = data["months"]
+series = data["series"]
+
+# ================== COLORS (PASTEL) ==================
+theme = apply_theme()
+# at most seven categories and Other, which stays gray
+pastel_colors = theme.colors([
+    "#FFB3BA", "#FFDFBA", "#FFFFBA",
+    "#BAFFC9", "#BAE1FF", "#D7BAFF",
+    "#FFC6E5",
+][:len(series)])
+colors = [("#C8C8C8" if s["name"] == "Other" else pastel_colors[i % len(pastel_colors)]) for i, s in enumerate(series)]
+bars = data["style"] == "bars"
+
+# ================== FIGURE ==================
+fig, ax = plt.subplots(figsize=(max(8, len(months) * 0.6 + 3), 5))
+xs = np.arange(len(months))
+
+if bars:
+    width = 0.8 / len(series)
+    for i, s in enumerate(series):
+        ax.bar(xs - 0.4 + width * (i + 0.5), s["values"], width, color=colors[i], label=s["name"])
+else:
+    ax.stackplot(xs, [s["values"] for s in series], labels=[s["name"] for s in series], colors=colors, alpha=0.9)
+    # the month totals over the stack
+    totals = np.sum([s["values"] for s in series], axis=0)
+    for x, total in zip(xs, totals):
+        ax.annotate(f"{total:,.0f}", (x, total), textcoords="offset points", xytext=(0, 4), ha="center", fontsize=7, color=theme.muted)
+
+ax.set_xticks(xs)
+ax.set_xticklabels(months, fontsize=8, rotation=45, ha="right")
+if bars:
+    ax.set_xlim(-0.5, len(months) - 0.5)
+else:
+    ax.set_xlim(0, len(months) - 1)
+ax.set_ylim(bottom=0)
+ax.yaxis.set_major_formatter(matplotlib.ticker.FuncFormatter(lambda v, _: f"{v:,.0f}"))
+ax.set_title(data["title"], fontsize=12)
+ax.spines["top"].set_visible(False)
+ax.spines["right"].set_visible(False)
+ax.grid(axis="y", alpha=0.3)
+
+# the legend of a stack lists the top layer first
+handles, labels = ax.get_legend_handles_labels()
+if not bars:
+    handles, labels = handles[::-1], labels[::-1]
+ax.legend(handles, labels, loc="upper left", bbox_to_anchor=(1.01, 1), frameon=False, fontsize=8)
+
+# ================== SAVE PNG ==================
+plt.tight_layout()
+plt.savefig(IMAGE_PATH, dpi=200, bbox_inches="tight")
+plt.close()
@@ -1,56 +1,48 @@
-import sqlite3
-from dotenv import load_dotenv
-import matplotlib.pyplot as plt
-from matplotlib.table import Table
-from datetime import datetime, timedelta
-import numpy as np
-import os
-import requests
-
-# ================== CONFIG ==================
+import json
+import sys
 
-load_dotenv()  # load variables from .env
+import matplotlib
 
-TELEGRAM_BOT_TOKEN = os.getenv("TELEGRAM_BOT_TOKEN")
-TELEGRAM_USER_ID = os.getenv("TELEGRAM_USER_ID")
-DB_PATH = os.getenv("DB_PATH")
-
-IMAGE_PATH = "expense_last_7_days.png"
+matplotlib.use("Agg")
+import matplotlib.pyplot as plt
+import numpy as np
 
-# ================== DATABASE ==================
-conn = sqlite3.connect(DB_PATH)
-cursor = conn.cursor()
+from chart_theme import apply_theme
 
-start_dt = (datetime.now() - timedelta(days=7)).strftime("%Y-%m-%d %H:%M:%S")
-end_dt = datetime.now().strftime("%Y-%m-%d %H:%M:%S")
+# Draws /get_weekly_expense_piechart: the expenses of the last 7 days by
+# category as a donut, next to a table with this month's use of each
+# category's budget. The bot passes the data as JSON on stdin:
+#   {"title": ..., "slices": [{"category": ..., "total": ...,
+#    "budget_use": 0.8 or null, "over_budget": false}, ...]}
+# largest slice first, and the path of the PNG to write as the only
+# argument.
 
-query = """
-SELECT category, SUM(amount) as total
-FROM transactions
-WHERE type = 'expense'
-AND created_at >= ?
-AND created_at < ?
-GROUP BY category
-ORDER BY total DESC
-"""
-cursor.execute(query, (start_dt, end_dt))
-data = cursor.fetchall()
-conn.close()
+# ================== INPUT ==================
+if len(sys.argv) != 2:
+    sys.exit("usage: g_w_e_piechart.py OUTPUT.png < data.json")
 
-if not data:
-    print("No expense data for the last 7 days")
-    exit()
+IMAGE_PATH = sys.argv[1]
+data = json.load(sys.stdin)
+slices = data["slices"]
 
-categories = [row[0] for row in data]
-totals = np.array([row[1] for row in data])
+categories = [s["category"] for s in slices]
+totals = np.array([s["total"] for s in slices])
 grand_total = totals.sum()
 percentages = (totals / grand_total) * 100
+over_budget = [s["over_budget"] for s in slices]
 
 # ================== COLORS (PASTEL) ==================
-pastel_colors = [
+theme = apply_theme()
+pastel_colors = theme.colors([
     "#FFB3BA", "#FFDFBA", "#FFFFBA",
     "#BAFFC9", "#BAE1FF", "#D7BAFF",
     "#FFC6E5", "#C6FFF3"
+])
+OVER_BUDGET_COLOR = "#E53935"
+
+slice_colors = [
+    OVER_BUDGET_COLOR if over else pastel_colors[i % len(pastel_colors)]
+    for i, over in enumerate(over_budget)
 ]
 
 # ================== FIGURE ==================
@@ -62,37 +54,53 @@ fig, (ax_pie, ax_table) = plt.subplots(
 # ================== PIE CHART (DONUT) ==================
 wedges, _ = ax_pie.pie(
     totals,
-    colors=pastel_colors[:len(totals)],
+    colors=slice_colors,
     startangle=90,
     wedgeprops=dict(width=0.4)
 )
 
+# --- Over-budget labels, outside their slices ---
+for wedge, category, over in zip(wedges, categories, over_budget):
+    if not over:
+        continue
+    angle = np.deg2rad((wedge.theta1 + wedge.theta2) / 2)
+    x, y = np.cos(angle), np.sin(angle)
+    ax_pie.annotate(
+        f"{category}\nover budget",
+        xy=(0.8 * x, 0.8 * y),
+        xytext=(1.25 * x, 1.25 * y),
+        ha="center", va="center",
+        fontsize=8, fontweight="bold", color=OVER_BUDGET_COLOR,
+        arrowprops=dict(arrowstyle="-", color=OVER_BUDGET_COLOR)
+    )
+
 # --- Center text ---
 ax_pie.text(
     0, 0.05,
     f"{grand_total:,.0f}",
     ha="center", va="center",
-    fontsize=18, fontweight="bold", color="black"
+    fontsize=18, fontweight="bold", color=theme.text
 )
 ax_pie.text(
     0, -0.15,
     "Total",
     ha="center", va="center",
-    fontsize=10, color="gray"
+    fontsize=10, color=theme.muted
 )
 
-ax_pie.set_title("Expenses in the Last 7 Days", fontsize=12)
+ax_pie.set_title(data["title"], fontsize=12)
 ax_pie.axis("equal")
 
 # ================== TABLE ==================
 ax_table.axis("off")
 
+# the month's spending against the budget, for categories with one
 table_data = [
-    ["■", c, f"{t:,.0f}", f"{p:.1f}%"]
-    for c, t, p in zip(categories, totals, percentages)
+    ["■", s["category"], f"{t:,.0f}", f"{p:.1f}%", f"{s['budget_use'] * 100:.0f}%" if s["budget_use"] is not None else ""]
+    for s, t, p in zip(slices, totals, percentages)
 ]
 
-col_labels = ["", "Category", "Total", "%"]
+col_labels = ["", "Category", "Total", "%", "Budget"]
 
 table = ax_table.table(
     cellText=table_data,
@@ -107,42 +115,28 @@ table.set_fontsize(11)
 table.scale(1.5, 1.4)  # wider table
 
 for (row, col), cell in table.get_celld().items():
+    # cells are white by default, whatever the background
+    cell.set_facecolor(theme.empty if row == 0 else theme.background)
+    cell.set_text_props(color=theme.text)
     if row == 0:
         cell.set_text_props(weight="bold")
-        cell.set_facecolor("#F2F2F2")
     if col == 0 and row > 0:
-        cell.set_text_props(color=pastel_colors[row-1], fontsize=16, ha="center")
-    if col in (2, 3):
+        cell.set_text_props(color=slice_colors[row-1], fontsize=16, ha="center")
+    if col in (2, 3, 4):
         cell.set_text_props(ha="right")
-    cell.set_edgecolor("white")
+    if col in (1, 4) and row > 0 and over_budget[row-1]:
+        cell.set_text_props(color=OVER_BUDGET_COLOR, weight="bold")
+    cell.set_edgecolor(theme.background)
     if col == 0:
         cell.set_width(0.05)
     elif col == 1:
-        cell.set_width(0.4)
+        cell.set_width(0.35)
     elif col == 2:
-        cell.set_width(0.25)
-    elif col == 3:
-        cell.set_width(0.2)
+        cell.set_width(0.22)
+    elif col in (3, 4):
+        cell.set_width(0.16)
 
 # ================== SAVE PNG ==================
 plt.tight_layout()
 plt.savefig(IMAGE_PATH, dpi=200, bbox_inches="tight")
 plt.close()
-
-# ================== SEND TO TELEGRAM ==================
-url = f"https://api.telegram.org/bot{TELEGRAM_BOT_TOKEN}/sendPhoto"
-
-with open(IMAGE_PATH, "rb") as photo:
-    res = requests.post(
-        url,
-        data={
-            "chat_id": TELEGRAM_USER_ID,
-            "caption": "📊 Expenses in the Last 7 Days"
-        },
-        files={"photo": photo}
-    )
-
-if res.status_code == 200:
-    print("Successfully sent chart to Telegram")
-else:
-    print("Failed to send chart to Telegram:", res.text)
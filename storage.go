@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+/*
+	STORAGE
+
+	The core records, transactions, categories and budgets, are read and
+	written through the Storage interface rather than with SQL in the
+	handlers:
+
+	  - sqlStorage is the SQLite database, the store of every normal run
+	  - memoryStorage keeps everything in memory (memstore.go); --dry-run
+	    runs a headless command on a copy of the records loaded into one,
+	    so nothing is written, and it can stand in for the database for
+	    any code that only needs a Storage
+
+	The interface covers what the add, edit, delete and budget flows, the
+	category cache and the headless add and list commands do. Reports
+	still query the database, most through the monthly aggregates
+	(aggregates.go), as do the side tables (bills, invoices, splits,
+	audit history...). A transaction that must be committed together with
+	a side table (a bill marked paid, a CSV import) is added with
+	addTransactionTx, the same insert inside the caller's database
+	transaction; nothing else writes new rows to the transactions table.
+
+	Transactions are always those of the ledger scope (ledgers.go): the
+	active ledger, or all of them when combined.
+*/
+
+// Transaction is a stored transaction.
+type Transaction struct {
+	ID          int64
+	Type        string
+	Category    string
+	Quantity    float64
+	Amount      Money
+	Description string
+	Notes       string
+	CreatedAt   time.Time
+	IsOutlier   bool
+	LedgerID    int64
+}
+
+// TransactionFilter selects transactions; zero fields select everything.
+type TransactionFilter struct {
+	Type     string
+	Category string // matched case-insensitively
+	Since    time.Time
+	Limit    int
+}
+
+// Storage reads and writes the core records.
+type Storage interface {
+	// AddTransaction stores t, without its ID, and returns the new ID.
+	AddTransaction(t Transaction) (int64, error)
+	// Transaction returns a transaction, or sql.ErrNoRows.
+	Transaction(id int64) (Transaction, error)
+	// SetTransactionField changes one of the editableFields of a
+	// transaction and reports whether it exists.
+	SetTransactionField(id int64, field string, value interface{}) (bool, error)
+	// DeleteTransaction removes a transaction and reports whether it
+	// existed.
+	DeleteTransaction(id int64) (bool, error)
+	// Transactions returns the matching transactions, newest first.
+	Transactions(filter TransactionFilter) ([]Transaction, error)
+	// Total sums the line totals (quantity × amount) of one type, in one
+	// category or all of them when category is "", created in [from, to).
+	Total(typ string, category string, from time.Time, to time.Time) (Money, error)
+
+	// Categories returns the category names, sorted.
+	Categories() ([]string, error)
+
+	// CategoryBudgets returns the category budgets, sorted by category.
+	CategoryBudgets() ([]categoryBudget, error)
+	// CategoryBudget returns the budget of a category, 0 when it has none.
+	CategoryBudget(category string) (Money, error)
+	SetCategoryBudget(category string, amount Money) error
+	RemoveCategoryBudget(category string) error
+}
+
+// store is where the core records are kept.
+var store Storage = sqlStorage{}
+
+// editableFields are the fields SetTransactionField changes.
+var editableFields = map[string]bool{
+	"type": true, "category": true, "quantity": true, "amount": true, "description": true, "is_outlier": true,
+}
+
+// lineTotal is what a transaction counts for in every total: its quantity
+// times its amount, rounded like Money.Mul. Aggregate queries sum it
+// rather than the amount, so "3 × 7,500" counts as 22,500 everywhere.
+const lineTotal = "CAST(ROUND(quantity * amount) AS INTEGER)"
+
+// transactionColumns are the columns scanTransaction reads.
+const transactionColumns = "id, type, category, quantity, amount, description, notes, created_at, is_outlier, ledger_id"
+
+// insertTransactionQuery adds a transaction, with insertTransactionArgs.
+const insertTransactionQuery = "INSERT INTO transactions (type, category, quantity, amount, description, notes, created_at, is_outlier, ledger_id) VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?)"
+
+func insertTransactionArgs(t Transaction) []interface{} {
+	return []interface{}{t.Type, t.Category, t.Quantity, t.Amount, t.Description, t.Notes, t.CreatedAt.Format(dbTimeLayout), t.IsOutlier, t.LedgerID}
+}
+
+// sqlStorage keeps the records in the database.
+type sqlStorage struct{}
+
+func (sqlStorage) AddTransaction(t Transaction) (int64, error) {
+	res, err := execCached(insertTransactionQuery, insertTransactionArgs(t)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// addTransactionTx is AddTransaction inside tx, for a transaction that is
+// committed together with changes to other tables. The statement is not
+// cached: preparing it would need the connection tx holds.
+func addTransactionTx(tx *sql.Tx, t Transaction) (int64, error) {
+	res, err := tx.Exec(insertTransactionQuery, insertTransactionArgs(t)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (sqlStorage) Transaction(id int64) (Transaction, error) {
+	return scanTransaction(queryRowCached("SELECT "+transactionColumns+" FROM transactions WHERE id = ? AND "+ledgerScope(), id))
+}
+
+func (sqlStorage) SetTransactionField(id int64, field string, value interface{}) (bool, error) {
+	if !editableFields[field] {
+		return false, fmt.Errorf("field %q cannot be changed", field)
+	}
+	res, err := execCached("UPDATE transactions SET "+field+" = ? WHERE id = ?", value, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (sqlStorage) DeleteTransaction(id int64) (bool, error) {
+	res, err := execCached("DELETE FROM transactions WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (sqlStorage) Transactions(filter TransactionFilter) ([]Transaction, error) {
+	query := "SELECT " + transactionColumns + " FROM transactions WHERE " + ledgerScope()
+	var params []interface{}
+	if filter.Type != "" {
+		query += " AND type = ?"
+		params = append(params, filter.Type)
+	}
+	if filter.Category != "" {
+		query += " AND category = ? COLLATE NOCASE"
+		params = append(params, filter.Category)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND created_at >= ?"
+		params = append(params, filter.Since.Format(dbTimeLayout))
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		params = append(params, filter.Limit)
+	}
+
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var transactions []Transaction
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+func (sqlStorage) Total(typ string, category string, from time.Time, to time.Time) (Money, error) {
+	query := "SELECT COALESCE(SUM(" + lineTotal + "), 0) FROM transactions WHERE type = ? AND created_at >= ? AND created_at < ? AND " + ledgerScope()
+	params := []interface{}{typ, from.Format(dbTimeLayout), to.Format(dbTimeLayout)}
+	if category != "" {
+		query += " AND category = ?"
+		params = append(params, category)
+	}
+	var total Money
+	err := db.QueryRow(query, params...).Scan(&total)
+	return total, err
+}
+
+func (sqlStorage) Categories() ([]string, error) {
+	return loadCategories(db)
+}
+
+func (sqlStorage) CategoryBudgets() ([]categoryBudget, error) {
+	rows, err := db.Query("SELECT category, amount FROM budgets ORDER BY category")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var budgets []categoryBudget
+	for rows.Next() {
+		var b categoryBudget
+		if err := rows.Scan(&b.Category, &b.Amount); err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
+
+func (sqlStorage) CategoryBudget(category string) (Money, error) {
+	var budget Money
+	err := db.QueryRow("SELECT amount FROM budgets WHERE category = ?", category).Scan(&budget)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return budget, err
+}
+
+func (sqlStorage) SetCategoryBudget(category string, amount Money) error {
+	_, err := db.Exec(`INSERT INTO budgets (category, amount) VALUES (?, ?)
+		ON CONFLICT(category) DO UPDATE SET amount = excluded.amount, updated_at = CURRENT_TIMESTAMP`, category, amount)
+	return err
+}
+
+func (sqlStorage) RemoveCategoryBudget(category string) error {
+	_, err := db.Exec("DELETE FROM budgets WHERE category = ?", category)
+	return err
+}
+
+// scanTransaction reads a row of transactionColumns.
+func scanTransaction(row interface{ Scan(...interface{}) error }) (Transaction, error) {
+	var (
+		t           Transaction
+		description sql.NullString
+		notes       sql.NullString
+		createdAt   string
+		isOutlier   sql.NullBool
+	)
+	if err := row.Scan(&t.ID, &t.Type, &t.Category, &t.Quantity, &t.Amount, &description, &notes, &createdAt, &isOutlier, &t.LedgerID); err != nil {
+		return Transaction{}, err
+	}
+	t.Description = description.String
+	t.Notes = notes.String
+	t.IsOutlier = isOutlier.Bool
+	var err error
+	if t.CreatedAt, err = parseCreatedAt(createdAt); err != nil {
+		return Transaction{}, fmt.Errorf("transaction %d: %w", t.ID, err)
+	}
+	return t, nil
+}
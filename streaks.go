@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	STREAKS (/streaks)
+
+	Runs of good habits, shown by /streaks and at the end of the
+	end-of-day summary:
+
+	- logging: days in a row with at least one transaction;
+	- daily budget: days in a row spending no more than the daily budget
+	  (the monthly budget, /budget, over the days of that month);
+	- savings: complete months in a row reaching the savings rate target
+	  (/savingsrate target).
+
+	Today only counts once it qualifies, so a streak is not lost before the
+	day is over; spending over today's budget does end it. Each streak earns
+	a badge at the streakBadges lengths, and the longest run so far is kept
+	in the streak_best.<name> settings.
+*/
+
+// streakLookbackDays bounds how far back a daily streak is counted.
+const streakLookbackDays = 400
+
+// streakLookbackMonths bounds how far back the savings streak is counted.
+const streakLookbackMonths = 36
+
+type streakBadge struct {
+	Min   int
+	Badge string
+}
+
+// streakBadges are the badges of each unit, longest last.
+var streakBadges = map[string][]streakBadge{
+	"day":   {{3, "🥉"}, {7, "🥈"}, {30, "🥇"}, {100, "🏆"}},
+	"month": {{3, "🥉"}, {6, "🥈"}, {12, "🥇"}, {24, "🏆"}},
+}
+
+type streak struct {
+	Name    string // settings key suffix
+	Label   string // e.g. "under the daily budget"
+	Icon    string
+	Unit    string // "day" or "month"
+	Current int
+	Best    int
+}
+
+// badge returns the badge earned with n units in a row, or "".
+func (s streak) badge(n int) string {
+	badge := ""
+	for _, b := range streakBadges[s.Unit] {
+		if n >= b.Min {
+			badge = b.Badge
+		}
+	}
+	return badge
+}
+
+// newBadge tells whether the current run just reached a badge.
+func (s streak) newBadge() bool {
+	for _, b := range streakBadges[s.Unit] {
+		if s.Current == b.Min {
+			return true
+		}
+	}
+	return false
+}
+
+func pluralUnit(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// dayStreak counts the days in a row, back from today, for which ok is
+// true. Today is skipped rather than ending the run when pending says so.
+func dayStreak(today time.Time, first time.Time, ok func(day time.Time) bool, pending bool) int {
+	n := 0
+	day := today
+	if pending {
+		day = day.AddDate(0, 0, -1)
+	}
+	for i := 0; i < streakLookbackDays && !day.Before(first); i++ {
+		if !ok(day) {
+			break
+		}
+		n++
+		day = day.AddDate(0, 0, -1)
+	}
+	return n
+}
+
+// computeStreaks returns the streaks that apply at now.
+func computeStreaks(now time.Time) ([]streak, error) {
+	today, tomorrow := dayBounds(now)
+	from := today.AddDate(0, 0, -streakLookbackDays)
+
+	var firstText string
+	if err := db.QueryRow("SELECT COALESCE(MIN(created_at), '') FROM transactions WHERE " + ledgerScope()).Scan(&firstText); err != nil {
+		return nil, err
+	}
+	if firstText == "" {
+		return nil, nil
+	}
+	firstAt, err := parseCreatedAt(firstText)
+	if err != nil {
+		return nil, err
+	}
+	first, _ := dayBounds(firstAt.In(now.Location()))
+
+	logged := make(map[string]bool)
+	rows, err := db.Query("SELECT DISTINCT date(created_at) FROM transactions WHERE created_at >= ? AND created_at < ? AND "+ledgerScope(),
+		from.Format(dbTimeLayout), tomorrow.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		logged[day] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	streaks := []streak{{
+		Name: "logging", Label: "logging transactions", Icon: "📝", Unit: "day",
+		Current: dayStreak(today, first, func(day time.Time) bool { return logged[day.Format(dateLayout)] }, !logged[today.Format(dateLayout)]),
+	}}
+
+	if budget := monthlyBudget(); budget > 0 {
+		spent, err := dailyExpenses(from, tomorrow, false)
+		if err != nil {
+			return nil, err
+		}
+		under := func(day time.Time) bool {
+			return spent[day.Format(dateLayout)] <= budget.Div(daysInMonth(day))
+		}
+		streaks = append(streaks, streak{
+			Name: "budget", Label: "under the daily budget", Icon: "💸", Unit: "day",
+			Current: dayStreak(today, first, under, false),
+		})
+	}
+
+	if target := savingsRateTarget(); target > 0 {
+		month, _ := monthBounds(now)
+		firstMonth, _ := monthBounds(first)
+		n := 0
+		for i := 1; i <= streakLookbackMonths; i++ {
+			m := month.AddDate(0, -i, 0)
+			if m.Before(firstMonth) {
+				break
+			}
+			income, expense, err := monthTotals(m)
+			if err != nil {
+				return nil, err
+			}
+			if income <= 0 || percentOf(float64(income-expense), float64(income)) < target {
+				break
+			}
+			n++
+		}
+		streaks = append(streaks, streak{
+			Name: "savings", Label: fmt.Sprintf("reaching the %g%% savings target", target), Icon: "💰", Unit: "month",
+			Current: n,
+		})
+	}
+
+	for i := range streaks {
+		s := &streaks[i]
+		s.Best, _ = strconv.Atoi(getSetting("streak_best."+s.Name, "0"))
+		if s.Current > s.Best {
+			s.Best = s.Current
+			if !isMaintenanceMode() {
+				if err := setSetting("streak_best."+s.Name, strconv.Itoa(s.Best)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return streaks, nil
+}
+
+// streaksText formats the streaks, or "" when there are none yet.
+func streaksText(streaks []streak) string {
+	var sb strings.Builder
+	var earned []string
+	for _, s := range streaks {
+		line := fmt.Sprintf("• %s %s %s", s.Icon, pluralUnit(s.Current, s.Unit), s.Label)
+		if badge := s.badge(s.Current); badge != "" {
+			line += " " + badge
+		}
+		if s.Best > s.Current {
+			line += fmt.Sprintf(" (best %s)", pluralUnit(s.Best, s.Unit))
+		}
+		sb.WriteString(line + "\n")
+		if s.newBadge() {
+			earned = append(earned, fmt.Sprintf("🎉 New badge %s: %s %s!", s.badge(s.Current), pluralUnit(s.Current, s.Unit), s.Label))
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	text := "🔥 Streaks\n" + sb.String()
+	if len(earned) > 0 {
+		text += strings.Join(earned, "\n") + "\n"
+	}
+	return strings.TrimRight(text, "\n")
+}
+
+// withStreaks appends the streaks to a digest in HTML; a failure leaves
+// it as is.
+func withStreaks(text string, now time.Time) string {
+	streaks, err := computeStreaks(now)
+	if err != nil {
+		reportError("computing streaks", err)
+		return text
+	}
+	if block := streaksText(streaks); block != "" {
+		return strings.TrimRight(text, "\n") + "\n\n" + escapeHTML(block)
+	}
+	return text
+}
+
+func showStreaks(chatID int64) {
+	streaks, err := computeStreaks(appClock.Now())
+	if err != nil {
+		sendMessage(chatID, "Failed to compute the streaks.")
+		reportError("computing streaks", err)
+		return
+	}
+	text := streaksText(streaks)
+	if text == "" {
+		sendMessage(chatID, "No streaks yet. Log a transaction to start one.")
+		return
+	}
+	hints := []string{}
+	if monthlyBudget() <= 0 {
+		hints = append(hints, "/budget <amount> to track days under the daily budget")
+	}
+	if savingsRateTarget() <= 0 {
+		hints = append(hints, "/savingsrate target 20 to track months reaching a savings target")
+	}
+	if len(hints) > 0 {
+		text += "\n\nSet " + strings.Join(hints, ", and ") + "."
+	}
+	sendMessage(chatID, text)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	SUBSCRIPTIONS (/subscriptions)
+
+	Subscriptions renew automatically, so on the renewal date the charge is
+	recorded as an expense and the renewal moves on by one billing cycle. A
+	few days before each renewal an alert is queued, leaving time to cancel
+	with the provider; its "Cancelled it" button stops tracking the
+	subscription.
+*/
+
+const defaultSubscriptionAlertDays = 3
+
+// subscriptionCycles maps a billing cycle to its length and the number of
+// renewals per year.
+var subscriptionCycles = map[string]struct {
+	years, months, days int
+	perYear             float64
+}{
+	"weekly":    {0, 0, 7, 52},
+	"monthly":   {0, 1, 0, 12},
+	"quarterly": {0, 3, 0, 4},
+	"yearly":    {1, 0, 0, 1},
+}
+
+type subscription struct {
+	ID          int64
+	Name        string
+	Amount      Money
+	Cycle       string
+	Category    string
+	AlertDays   int
+	NextRenewal time.Time
+}
+
+func (s subscription) annualCost() Money {
+	return s.Amount.Mul(subscriptionCycles[s.Cycle].perYear)
+}
+
+func nextRenewalAfter(t time.Time, cycle string) time.Time {
+	c := subscriptionCycles[cycle]
+	return t.AddDate(c.years, c.months, c.days)
+}
+
+func loadSubscriptions() ([]subscription, error) {
+	rows, err := db.Query("SELECT id, name, amount, cycle, category, alert_days, next_renewal FROM subscriptions ORDER BY next_renewal, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []subscription
+	for rows.Next() {
+		var s subscription
+		var next string
+		if err := rows.Scan(&s.ID, &s.Name, &s.Amount, &s.Cycle, &s.Category, &s.AlertDays, &next); err != nil {
+			return nil, err
+		}
+		if s.NextRenewal, err = time.ParseInLocation(dateLayout, next, appLocation()); err != nil {
+			return nil, fmt.Errorf("subscription %d: invalid next_renewal %q", s.ID, next)
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+const subscriptionUsage = "Usage:\n/subscriptions - list subscriptions\n/subscription add <name> <amount> <weekly|monthly|quarterly|yearly> <next renewal YYYY-MM-DD> [alert days before]\n/subscription remove <name>"
+
+// handleSubscriptionCommand implements /subscription and /subscriptions.
+func handleSubscriptionCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || strings.EqualFold(fields[0], "list") {
+		showSubscriptions(chatID)
+		return
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "add":
+		addSubscription(chatID, fields[1:])
+	case "remove", "cancel":
+		name := strings.Join(fields[1:], " ")
+		res, err := db.Exec("DELETE FROM subscriptions WHERE name = ? COLLATE NOCASE", name)
+		if err != nil {
+			sendMessage(chatID, "Failed to remove the subscription.")
+			reportError(fmt.Sprintf("removing subscription %q", name), err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("No subscription named %q.", name))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Subscription %s removed. Remember to cancel it with the provider too.", name))
+	default:
+		sendMessage(chatID, subscriptionUsage)
+	}
+}
+
+// addSubscription parses "<name...> <amount> <cycle> <date> [alert days]".
+func addSubscription(chatID int64, fields []string) {
+	cycleAt := -1
+	for i, f := range fields {
+		if _, ok := subscriptionCycles[strings.ToLower(f)]; ok {
+			cycleAt = i
+		}
+	}
+	if cycleAt < 2 || cycleAt+1 >= len(fields) || len(fields) > cycleAt+3 {
+		sendMessage(chatID, subscriptionUsage)
+		return
+	}
+
+	name := strings.Join(fields[:cycleAt-1], " ")
+	amount, err := parseMoney(fields[cycleAt-1])
+	if err != nil || amount <= 0 {
+		sendMessage(chatID, "Invalid amount. Please enter a positive number.")
+		return
+	}
+	cycle := strings.ToLower(fields[cycleAt])
+	next, err := time.ParseInLocation(dateLayout, fields[cycleAt+1], appLocation())
+	if err != nil {
+		sendMessage(chatID, "Invalid renewal date. Use YYYY-MM-DD.")
+		return
+	}
+	alertDays := defaultSubscriptionAlertDays
+	if len(fields) == cycleAt+3 {
+		alertDays, err = strconv.Atoi(fields[cycleAt+2])
+		if err != nil || alertDays < 0 || alertDays > 60 {
+			sendMessage(chatID, "Invalid number of alert days (0-60).")
+			return
+		}
+	}
+
+	// move a past renewal date forward to the next one to come
+	today, _ := dayBounds(appClock.Now())
+	for next.Before(today) {
+		next = nextRenewalAfter(next, cycle)
+	}
+	category, ok := findCategory(name)
+	if !ok {
+		category = defaultBillCategory
+	}
+
+	_, err = db.Exec("INSERT INTO subscriptions (name, amount, cycle, category, alert_days, next_renewal) VALUES (?, ?, ?, ?, ?, ?)",
+		name, amount, cycle, category, alertDays, next.Format(dateLayout))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			sendMessage(chatID, fmt.Sprintf("A subscription named %q already exists.", name))
+			return
+		}
+		sendMessage(chatID, "Failed to save the subscription.")
+		reportError("adding a subscription", err)
+		return
+	}
+	s := subscription{Amount: amount, Cycle: cycle}
+	sendMessage(chatID, fmt.Sprintf("Subscription %s added: %s %s (%s per year), next renewal %s. You will be alerted %d day(s) before.",
+		name, formatMoney(amount), cycle, formatMoney(s.annualCost()), next.Format("2 Jan 2006"), alertDays))
+}
+
+func showSubscriptions(chatID int64) {
+	subs, err := loadSubscriptions()
+	if err != nil {
+		sendMessage(chatID, "Failed to load subscriptions.")
+		reportError("loading subscriptions", err)
+		return
+	}
+	if len(subs) == 0 {
+		sendMessage(chatID, "No subscriptions yet.\n\n"+subscriptionUsage)
+		return
+	}
+
+	sort.SliceStable(subs, func(i, j int) bool { return subs[i].annualCost() > subs[j].annualCost() })
+	var sb strings.Builder
+	sb.WriteString("🔁 Subscriptions\n\n")
+	var total Money
+	for _, s := range subs {
+		sb.WriteString(fmt.Sprintf("• %s: %s %s = %s/year, renews %s\n", s.Name, formatMoney(s.Amount), s.Cycle, formatMoney(s.annualCost()), s.NextRenewal.Format("2 Jan 2006")))
+		total += s.annualCost()
+	}
+	sb.WriteString(fmt.Sprintf("\nTotal: %s per year (%s per month)", formatMoney(total), formatMoney(total.Div(12))))
+	sendMessage(chatID, sb.String())
+}
+
+// processSubscriptions records due renewals and queues an alert for each
+// subscription renewing within its alert window.
+func processSubscriptions(now time.Time) error {
+	subs, err := loadSubscriptions()
+	if err != nil {
+		return err
+	}
+	today, _ := dayBounds(now)
+	for _, s := range subs {
+		for !s.NextRenewal.After(today) {
+			if err := renewSubscription(&s); err != nil {
+				return err
+			}
+		}
+
+		days := int(s.NextRenewal.Sub(today).Hours() / 24)
+		if days > s.AlertDays {
+			continue
+		}
+		renewal := s.NextRenewal.Format(dateLayout)
+		text := fmt.Sprintf("🔁 %s renews in %d day(s), on %s, for %s (%s per year). Cancel now if you no longer use it.",
+			s.Name, days, s.NextRenewal.Format("2 Jan"), formatMoney(s.Amount), formatMoney(s.annualCost()))
+		keyboard := buildKeyboard([][]InlineKeyboardButton{{
+			{Text: "❌ Cancelled it", CallbackData: fmt.Sprintf("sub:cancel:%d", s.ID)},
+		}})
+		key := fmt.Sprintf("subscription:%d:%s", s.ID, renewal)
+		if err := enqueueNotification(ownerID(), "subscription_alert", key, text, keyboard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renewSubscription records the charge for the current renewal and moves
+// the subscription to the next cycle.
+func renewSubscription(s *subscription) error {
+	ledgerID := activeLedgerID()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t := Transaction{Type: "expense", Category: s.Category, Quantity: 1, Amount: s.Amount, Description: s.Name, CreatedAt: s.NextRenewal, LedgerID: ledgerID}
+	transactionID, err := addTransactionTx(tx, t)
+	if err != nil {
+		return err
+	}
+	next := nextRenewalAfter(s.NextRenewal, s.Cycle)
+	if _, err := tx.Exec("UPDATE subscriptions SET next_renewal = ? WHERE id = ?", next.Format(dateLayout), s.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Recorded renewal of subscription %s (%.2f)", s.Name, s.Amount)
+	transactionAdded(transactionID, t)
+	s.NextRenewal = next
+	return nil
+}
+
+// handleSubscriptionCallback handles the "Cancelled it" button: sub:cancel:<id>.
+func handleSubscriptionCallback(callback *CallbackQuery) {
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 || parts[1] != "cancel" {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM subscriptions WHERE id = ?", id).Scan(&name); err != nil {
+		editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "This subscription was already removed.")
+		return
+	}
+	if _, err := db.Exec("DELETE FROM subscriptions WHERE id = ?", id); err != nil {
+		reportError(fmt.Sprintf("removing subscription %d", id), err)
+		sendMessage(callback.Message.Chat.ID, "Failed to remove the subscription.")
+		return
+	}
+	editMessage(callback.Message.Chat.ID, callback.Message.MessageID, fmt.Sprintf("🗑️ %s removed; no more renewals will be recorded.", name))
+}
+
+// upcomingSubscriptions feeds the renewals left this month into the forecast.
+func upcomingSubscriptions(now time.Time) ([]upcomingEntry, error) {
+	subs, err := loadSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	_, monthEnd := monthBounds(now)
+	var upcoming []upcomingEntry
+	for _, s := range subs {
+		for r := s.NextRenewal; r.Before(monthEnd); r = nextRenewalAfter(r, s.Cycle) {
+			day := r.Day()
+			if r.Before(now) {
+				day = now.Day()
+			}
+			upcoming = append(upcoming, upcomingEntry{Label: s.Name + " (subscription)", Type: "expense", Amount: s.Amount, Day: day})
+		}
+	}
+	return upcoming, nil
+}
+
+// addSubscriptionKeys adds the recurring-pattern keys of subscription charges.
+func addSubscriptionKeys(keys map[string]bool) {
+	subs, err := loadSubscriptions()
+	if err != nil {
+		log.Printf("Failed to load subscriptions: %v", err)
+		return
+	}
+	for _, s := range subs {
+		keys[recurringPattern{Type: "expense", Category: s.Category, Description: s.Name}.key()] = true
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	BUDGET SUGGESTIONS (/suggestbudgets [months])
+
+	Proposes a budget for each expense category from the last 3 to 6
+	complete months (6 by default): the median monthly spending plus
+	suggestionBuffer, rounded up to two significant digits. Months without
+	spending count as zero, so a category bought from only now and then
+	gets no suggestion. Each suggestion has a button that sets it, and
+	"Apply all" sets them all at once; /budget shows or changes them later.
+*/
+
+const (
+	minSuggestionMonths     = 3
+	maxSuggestionMonths     = 6
+	defaultSuggestionMonths = 6
+	suggestionBuffer        = 0.10
+)
+
+type budgetSuggestion struct {
+	Category string
+	Median   Money
+	Amount   Money
+}
+
+// median returns the middle of values, which it sorts.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// roundUpNice rounds x up to two significant digits, e.g. 563200 to 570000.
+func roundUpNice(x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	step := math.Pow(10, math.Floor(math.Log10(x))-1)
+	if step < 1 {
+		step = 1
+	}
+	// the epsilon keeps 200000 * 1.1 at 220000
+	return math.Ceil(x/step-1e-9) * step
+}
+
+// suggestBudgets computes the suggestions from the months complete
+// months before now's month.
+func suggestBudgets(now time.Time, months int) ([]budgetSuggestion, error) {
+	thisMonth, _ := monthBounds(now)
+	from := thisMonth.AddDate(0, -months, 0)
+	rows, err := db.Query(`SELECT category, strftime('%Y-%m', created_at) AS month, SUM(`+lineTotal+`) FROM transactions
+		WHERE type = 'expense' AND created_at >= ? AND created_at < ? AND `+ledgerScope()+`
+		GROUP BY category, month`, from.Format(dbTimeLayout), thisMonth.Format(dbTimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	spent := make(map[string]map[string]Money)
+	for rows.Next() {
+		var category, month string
+		var total Money
+		if err := rows.Scan(&category, &month, &total); err != nil {
+			return nil, err
+		}
+		if spent[category] == nil {
+			spent[category] = make(map[string]Money)
+		}
+		spent[category][month] = total
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var suggestions []budgetSuggestion
+	for category, byMonth := range spent {
+		values := make([]float64, 0, months)
+		for m := from; m.Before(thisMonth); m = m.AddDate(0, 1, 0) {
+			values = append(values, byMonth[m.Format("2006-01")].Float())
+		}
+		mid := median(values)
+		if mid <= 0 {
+			continue
+		}
+		suggestions = append(suggestions, budgetSuggestion{
+			Category: category,
+			Median:   moneyFromFloat(mid),
+			Amount:   moneyFromFloat(roundUpNice(mid * (1 + suggestionBuffer))),
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Amount != suggestions[j].Amount {
+			return suggestions[i].Amount > suggestions[j].Amount
+		}
+		return suggestions[i].Category < suggestions[j].Category
+	})
+	return suggestions, nil
+}
+
+func handleSuggestBudgetsCommand(chatID int64, args string) {
+	months := defaultSuggestionMonths
+	if arg := strings.TrimSpace(args); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < minSuggestionMonths || n > maxSuggestionMonths {
+			sendMessage(chatID, fmt.Sprintf("Usage: /suggestbudgets [months], %d to %d months of history (default %d)", minSuggestionMonths, maxSuggestionMonths, defaultSuggestionMonths))
+			return
+		}
+		months = n
+	}
+	suggestions, err := suggestBudgets(appClock.Now(), months)
+	if err != nil {
+		sendMessage(chatID, "Failed to compute budget suggestions.")
+		reportError("suggesting budgets", err)
+		return
+	}
+	if len(suggestions) == 0 {
+		sendMessage(chatID, fmt.Sprintf("Not enough spending in the last %d months to suggest budgets.", months))
+		return
+	}
+	budgets, err := loadCategoryBudgets()
+	if err != nil {
+		sendMessage(chatID, "Failed to load budgets.")
+		reportError("loading budgets", err)
+		return
+	}
+	current := make(map[string]Money)
+	for _, b := range budgets {
+		current[b.Category] = b.Amount
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("💡 Budget suggestions from the last %d months (median + %.0f%%)\n\n", months, suggestionBuffer*100))
+	var rows [][]InlineKeyboardButton
+	for _, s := range suggestions {
+		budget := "no budget yet"
+		if amount, ok := current[s.Category]; ok {
+			budget = fmt.Sprintf("now %s", formatMoney(amount))
+		}
+		sb.WriteString(fmt.Sprintf("• %s: %s (median %s, %s)\n", s.Category, formatMoney(s.Amount), formatMoney(s.Median), budget))
+		// Telegram limits button data to 64 bytes; long names only get "Apply all"
+		if data := fmt.Sprintf("sugg:%.0f:%s", s.Amount, s.Category); len(data) <= 64 {
+			rows = append(rows, []InlineKeyboardButton{{Text: fmt.Sprintf("Set %s to %.0f", s.Category, s.Amount), CallbackData: data}})
+		}
+	}
+	rows = append(rows, []InlineKeyboardButton{{Text: "✅ Apply all", CallbackData: fmt.Sprintf("sugg:all:%d", months)}})
+	sendMessageWithKeyboard(chatID, strings.TrimRight(sb.String(), "\n"), buildKeyboard(rows))
+}
+
+// handleSuggestionCallback handles sugg:<amount>:<category> and
+// sugg:all:<months>.
+func handleSuggestionCallback(callback *CallbackQuery) {
+	parts := strings.SplitN(callback.Data, ":", 3)
+	if len(parts) != 3 {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	chatID := callback.Message.Chat.ID
+
+	if parts[1] == "all" {
+		months, err := strconv.Atoi(parts[2])
+		if err != nil || months < minSuggestionMonths || months > maxSuggestionMonths {
+			_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+			return
+		}
+		suggestions, err := suggestBudgets(appClock.Now(), months)
+		if err != nil {
+			_ = messenger.AnswerCallback(callback.ID, "Failed to compute budget suggestions.")
+			reportError("suggesting budgets", err)
+			return
+		}
+		for _, s := range suggestions {
+			if err := setCategoryBudget(s.Category, s.Amount); err != nil {
+				_ = messenger.AnswerCallback(callback.ID, "Failed to update budget.")
+				reportError("setting the budget for "+s.Category, err)
+				return
+			}
+		}
+		_ = messenger.AnswerCallback(callback.ID, "")
+		editMessage(chatID, callback.Message.MessageID, fmt.Sprintf("✅ %d budget(s) set from the last %d months. See them with /budget.", len(suggestions), months))
+		return
+	}
+
+	amount, err := parseMoney(parts[1])
+	category, ok := findCategory(parts[2])
+	if err != nil || amount <= 0 || !ok {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if err := setCategoryBudget(category, amount); err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Failed to update budget.")
+		reportError("setting the budget for "+category, err)
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, fmt.Sprintf("Budget for %s set to %s per month.", category, formatMoney(amount)))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	SYSTEMD SUPERVISION
+
+	With the "systemd" feature on ([features] systemd = true) and the bot
+	run as a Type=notify service, it tells systemd when it is ready to
+	serve and, when the unit sets WatchdogSec=, keeps pinging the watchdog
+	for as long as it is healthy:
+
+		[Service]
+		Type=notify
+		NotifyAccess=main
+		WatchdogSec=5min
+		Restart=on-failure
+
+	Healthy means the update loop completed a getUpdates round-trip with
+	Telegram within updateLoopStallAfter. A loop stuck in a handler, or a
+	connection that stopped working, stops the pings, and systemd restarts
+	the bot once WatchdogSec runs out. The unit status shows how long ago
+	the last round-trip was.
+
+	Outside systemd (no NOTIFY_SOCKET) everything here does nothing.
+*/
+
+// updateLoopStallAfter is how long the update loop may go without a
+// successful getUpdates before the bot stops pinging the watchdog. It
+// leaves room for a long poll, a slow handler and a few failed retries.
+const updateLoopStallAfter = 3 * time.Minute
+
+// lastPollAt holds when the update loop last heard back from Telegram,
+// in Unix nanoseconds.
+var lastPollAt atomic.Int64
+
+// markPolled records a successful getUpdates round-trip.
+func markPolled(now time.Time) {
+	lastPollAt.Store(now.UnixNano())
+}
+
+// updateLoopHealthy reports whether the last round-trip is recent enough,
+// and how long ago it was.
+func updateLoopHealthy(now time.Time) (bool, time.Duration) {
+	since := now.Sub(time.Unix(0, lastPollAt.Load()))
+	return since < updateLoopStallAfter, since
+}
+
+// sdNotify sends a state such as "READY=1" to the service manager. It
+// does nothing when the bot was not started by systemd.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the WatchdogSec= of the unit, or 0 when the
+// watchdog is off or meant for another process.
+func watchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// startSystemd reports the bot ready and starts the watchdog pings, when
+// the feature is on. It is called right before the update loop starts.
+func startSystemd() {
+	if !featureEnabled("systemd") || os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	markPolled(time.Now())
+	if err := sdNotify("READY=1\nSTATUS=Polling Telegram for updates"); err != nil {
+		log.Printf("systemd notify failed: %v", err)
+		return
+	}
+	interval, err := watchdogInterval()
+	if err != nil {
+		log.Printf("systemd watchdog disabled: %v", err)
+		return
+	}
+	if interval == 0 {
+		log.Println("Reported ready to systemd")
+		return
+	}
+	log.Printf("Reported ready to systemd, watchdog every %s", interval/2)
+	go runWatchdog(interval / 2)
+}
+
+// runWatchdog pings the watchdog every period while the update loop is
+// healthy.
+func runWatchdog(period time.Duration) {
+	stalled := false
+	for range time.Tick(period) {
+		healthy, since := updateLoopHealthy(time.Now())
+		since = since.Round(time.Second)
+		if !healthy {
+			if !stalled {
+				log.Printf("watchdog status=stalled last_poll=%s ago; no longer pinging systemd", since)
+				stalled = true
+			}
+			sdNotify(fmt.Sprintf("STATUS=Update loop stalled, last Telegram round-trip %s ago", since))
+			continue
+		}
+		if stalled {
+			log.Printf("watchdog status=recovered last_poll=%s ago", since)
+			stalled = false
+		}
+		if err := sdNotify(fmt.Sprintf("WATCHDOG=1\nSTATUS=Polling Telegram, last round-trip %s ago", since)); err != nil {
+			log.Printf("systemd watchdog ping failed: %v", err)
+		}
+	}
+}
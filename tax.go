@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+	TAX (/taxreport)
+
+	For business use a transaction can be flagged tax-deductible and carry
+	the VAT or other tax included in it, both set from the edit flow (/edit).
+	Tax on income is tax collected, tax on expenses is tax paid.
+
+	/taxreport [period] totals the deductible expenses by category and the
+	tax collected and paid, with the difference due; /taxreport csv [period]
+	sends the transactions behind it as a CSV file for an accountant.
+*/
+
+const taxReportUsage = "Usage: /taxreport [csv] [YYYY-MM | YYYY | YYYY-MM-DD YYYY-MM-DD]"
+
+// transactionTax returns the tax fields of a transaction.
+func transactionTax(id int64) (deductible bool, tax Money) {
+	var amount NullMoney
+	if err := queryRowCached("SELECT deductible, tax_amount FROM transactions WHERE id = ?", id).Scan(&deductible, &amount); err != nil {
+		return false, 0
+	}
+	return deductible, amount.Money
+}
+
+// taxLine formats the tax fields for the transaction details, or "" when
+// the transaction has none.
+func taxLine(deductible bool, tax Money) string {
+	line := ""
+	if deductible {
+		line += "\nTax-deductible: yes"
+	}
+	if tax > 0 {
+		line += fmt.Sprintf("\nTax: %s", formatMoney(tax))
+	}
+	return line
+}
+
+// parseTaxAmount reads the tax of a transaction of amount: a number, or a
+// rate such as "11%" of the amount.
+func parseTaxAmount(text string, amount Money) (Money, error) {
+	text = strings.TrimSpace(text)
+	if rate, ok := strings.CutSuffix(text, "%"); ok {
+		r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil || r < 0 || r > 100 {
+			return 0, fmt.Errorf("invalid rate %q", text)
+		}
+		return amount.Mul(r / 100), nil
+	}
+	tax, err := parseMoney(text)
+	if err != nil || tax < 0 {
+		return 0, fmt.Errorf("invalid tax %q", text)
+	}
+	return tax, nil
+}
+
+// processEditTax stores the tax typed during the edit flow; "-" clears it.
+func processEditTax(message *TGMessage, state *TransactionState) {
+	text := strings.TrimSpace(message.Text)
+	var tax Money
+	if text != "-" {
+		var err error
+		tax, err = parseTaxAmount(text, state.Amount)
+		if err != nil || tax > state.Amount {
+			sendMessage(message.Chat.ID, "Invalid tax. Enter an amount no larger than the transaction, a rate such as 11%, or - to clear it.")
+			return
+		}
+	}
+	reply := fmt.Sprintf("Transaction %d updated: tax set to %s", state.EditID, formatMoney(tax))
+	if tax == 0 {
+		reply = fmt.Sprintf("Transaction %d updated: tax cleared.", state.EditID)
+	}
+	if _, err := execCached("UPDATE transactions SET tax_amount = NULLIF(?, 0) WHERE id = ?", tax, state.EditID); err != nil {
+		reportError("updating a transaction tax", err)
+		reply = "Failed to update the transaction tax."
+	}
+	if state.PromptMessageID != 0 {
+		editMessage(message.Chat.ID, state.PromptMessageID, reply)
+	} else {
+		sendMessage(message.Chat.ID, reply)
+	}
+	delete(userStates, state.UserID)
+}
+
+// processEditDeductible stores the answer to "Tax-deductible?".
+func processEditDeductible(callback *CallbackQuery, state *TransactionState) {
+	chatID, msgID := callback.Message.Chat.ID, callback.Message.MessageID
+	if callback.Data == "edit_cancel" {
+		editMessage(chatID, msgID, "Edit canceled.")
+		delete(userStates, state.UserID)
+		return
+	}
+	value, ok := strings.CutPrefix(callback.Data, "deductible:")
+	if !ok {
+		editMessage(chatID, msgID, "Invalid selection.")
+		delete(userStates, state.UserID)
+		return
+	}
+	deductible := value == "true"
+	if _, err := execCached("UPDATE transactions SET deductible = ? WHERE id = ?", deductible, state.EditID); err != nil {
+		reportError("updating a transaction deductible flag", err)
+		editMessage(chatID, msgID, "Failed to update the tax-deductible flag.")
+		delete(userStates, state.UserID)
+		return
+	}
+	editMessage(chatID, msgID, fmt.Sprintf("Transaction %d updated: tax-deductible set to %v", state.EditID, deductible))
+	delete(userStates, state.UserID)
+}
+
+// taxRow is a transaction in the tax report.
+type taxRow struct {
+	ID          int64
+	Type        string
+	Category    string
+	Amount      Money
+	Description string
+	CreatedAt   string
+	Deductible  bool
+	Tax         Money
+}
+
+// taxRows returns the deductible or taxed transactions in [from, to).
+func taxRows(from, to string) ([]taxRow, error) {
+	rows, err := db.Query(`SELECT id, type, category, `+lineTotal+`, COALESCE(description, ''), created_at, deductible, COALESCE(tax_amount, 0)
+		FROM transactions WHERE (deductible = 1 OR tax_amount > 0) AND created_at >= ? AND created_at < ? AND `+ledgerScope()+`
+		ORDER BY created_at, id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []taxRow
+	for rows.Next() {
+		var r taxRow
+		if err := rows.Scan(&r.ID, &r.Type, &r.Category, &r.Amount, &r.Description, &r.CreatedAt, &r.Deductible, &r.Tax); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+func writeTaxCSV(w io.Writer, rows []taxRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "date", "type", "category", "description", "amount", "tax", "deductible"}); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		date := r.CreatedAt
+		if t, err := parseCreatedAt(r.CreatedAt); err == nil {
+			date = t.Format(dateLayout)
+		}
+		deductible := "no"
+		if r.Deductible {
+			deductible = "yes"
+		}
+		if err := cw.Write([]string{
+			strconv.FormatInt(r.ID, 10), date, r.Type, r.Category, r.Description,
+			r.Amount.String(), r.Tax.String(), deductible,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func handleTaxReportCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	asCSV := len(fields) > 0 && strings.EqualFold(fields[0], "csv")
+	if asCSV {
+		fields = fields[1:]
+	}
+	from, to, label, err := parsePeriod(strings.Join(fields, " "), appClock.Now())
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v. %s", err, taxReportUsage))
+		return
+	}
+	rows, err := taxRows(from.Format(dbTimeLayout), to.Format(dbTimeLayout))
+	if err != nil {
+		sendMessage(chatID, "Failed to build the tax report.")
+		reportError("loading the tax report", err)
+		return
+	}
+	if len(rows) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No tax-deductible or taxed transactions in %s. Flag them from /edit.", label))
+		return
+	}
+
+	var deductible, collected, paid Money
+	var deductibleCount int
+	byCategory := make(map[string]Money)
+	var order []string
+	for _, r := range rows {
+		if r.Type == "expense" {
+			paid += r.Tax
+			if r.Deductible {
+				deductible += r.Amount
+				deductibleCount++
+				if _, ok := byCategory[r.Category]; !ok {
+					order = append(order, r.Category)
+				}
+				byCategory[r.Category] += r.Amount
+			}
+		} else {
+			collected += r.Tax
+		}
+	}
+
+	if asCSV {
+		file := exportFile{"tax-report-*.csv", func(w io.Writer) error { return writeTaxCSV(w, rows) }}
+		caption := fmt.Sprintf("🧾 Tax report, %s: %d transaction(s)", label, len(rows))
+		if err := sendExportFile(chatID, file, caption); err != nil {
+			sendMessage(chatID, "Failed to send the tax report.")
+			reportError("sending the tax report", err)
+		}
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🧾 Tax report, %s\n\n", label))
+	sb.WriteString(fmt.Sprintf("Deductible expenses: %s (%d transaction(s))\n", formatMoney(deductible), deductibleCount))
+	for _, c := range order {
+		sb.WriteString(fmt.Sprintf("• %s: %s\n", c, formatMoney(byCategory[c])))
+	}
+	sb.WriteString(fmt.Sprintf("\nTax collected on income: %s\nTax paid on expenses: %s\n", formatMoney(collected), formatMoney(paid)))
+	if collected >= paid {
+		sb.WriteString(fmt.Sprintf("Tax due: %s\n", formatMoney(collected-paid)))
+	} else {
+		sb.WriteString(fmt.Sprintf("Tax credit: %s\n", formatMoney(paid-collected)))
+	}
+	sb.WriteString("\nSend /taxreport csv " + strings.Join(fields, " "))
+	sendMessage(chatID, strings.TrimSpace(sb.String())+" for a CSV file for your accountant.")
+}
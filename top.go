@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	LARGEST EXPENSES (/top [n] [period] [archive])
+
+	Lists the n largest single expenses of a period (parsePeriod: this
+	month by default), each with its share of everything spent in it.
+*/
+
+const (
+	topDefaultCount = 10
+	topMaxCount     = 50
+	topUsage        = "Usage: /top [n] [YYYY-MM | YYYY | YYYY-MM-DD YYYY-MM-DD] [archive]"
+)
+
+// parseTopArgs splits "/top [n] [period]"; a leading number of up to three
+// digits is the count, so "/top 2025" is still a year.
+func parseTopArgs(args string) (int, string, error) {
+	fields := strings.Fields(args)
+	n := topDefaultCount
+	if len(fields) > 0 && len(fields[0]) < 4 {
+		v, err := strconv.Atoi(fields[0])
+		if err != nil || v <= 0 {
+			return 0, "", fmt.Errorf("invalid count %q", fields[0])
+		}
+		n, fields = v, fields[1:]
+	}
+	if n > topMaxCount {
+		n = topMaxCount
+	}
+	return n, strings.Join(fields, " "), nil
+}
+
+type topExpense struct {
+	ID          int64
+	Category    string
+	Amount      Money
+	Description string
+	CreatedAt   string
+}
+
+func largestExpenses(from time.Time, to time.Time, n int, withArchive bool) ([]topExpense, Money, error) {
+	columns := "id, type, category, " + lineTotal + " AS amount, description, created_at, ledger_id"
+	source := "(SELECT " + columns + " FROM transactions)"
+	if withArchive {
+		source = "(SELECT " + columns + " FROM transactions UNION ALL SELECT " + columns + " FROM transactions_archive)"
+	}
+	var total Money
+	err := db.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM `+transactionSource(withArchive)+`
+		WHERE type = 'expense' AND created_at >= ? AND created_at < ?`,
+		from.Format(dbTimeLayout), to.Format(dbTimeLayout)).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`SELECT id, category, amount, description, created_at FROM `+source+`
+		WHERE type = 'expense' AND created_at >= ? AND created_at < ? AND `+ledgerScope()+`
+		ORDER BY amount DESC, created_at LIMIT ?`,
+		from.Format(dbTimeLayout), to.Format(dbTimeLayout), n)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var expenses []topExpense
+	for rows.Next() {
+		var e topExpense
+		var description sql.NullString
+		if err := rows.Scan(&e.ID, &e.Category, &e.Amount, &description, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		e.Description = description.String
+		expenses = append(expenses, e)
+	}
+	return expenses, total, rows.Err()
+}
+
+func showTop(chatID int64, args string) {
+	rest, withArchive := splitArchiveArg(args)
+	n, period, err := parseTopArgs(rest)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v.\n%s", err, topUsage))
+		return
+	}
+	from, to, label, err := parsePeriod(period, appClock.Now())
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v.\n%s", err, topUsage))
+		return
+	}
+
+	expenses, total, err := largestExpenses(from, to, n, withArchive)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the transactions.")
+		reportError("loading the largest expenses", err)
+		return
+	}
+	if len(expenses) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No expenses in %s.", label))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🏆 %s\nTotal spent: %s\n\n", bold(fmt.Sprintf("Largest expenses, %s (top %d)", label, len(expenses))), bold(formatMoney(total))))
+	var shown Money
+	for i, e := range expenses {
+		shown += e.Amount
+		sb.WriteString(fmt.Sprintf("%d. %s (%.1f%%) · %s · %s · %s\n", i+1, moneyHTML(e.Amount), percentOf(e.Amount.Float(), total.Float()), escapeHTML(e.Category), formatCreatedAt(e.CreatedAt), idHTML(e.ID)))
+		if e.Description != "" {
+			sb.WriteString("    " + escapeHTML(e.Description) + "\n")
+		}
+	}
+	sb.WriteString(fmt.Sprintf("\nThese make up %.1f%% of the spending.", percentOf(shown.Float(), total.Float())))
+	sendHTML(chatID, sb.String())
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	CATEGORY TREND (/trend [months] [bars])
+
+	Monthly expenses per category over the last months, this one
+	included, drawn by src/g_trend_chart.py as a stacked area (or grouped
+	bars with "bars") to spot a category creeping up. The
+	trendMaxCategories largest categories get a series of their own, the
+	rest are summed as Other. The caption names the categories that grew
+	the most: the last complete month against the average of the complete
+	months before it.
+*/
+
+const (
+	trendScript        = "src/g_trend_chart.py"
+	trendDefaultMonths = 6
+	trendMaxMonths     = 24
+	trendMaxCategories = 7
+	trendUsage         = "Usage: /trend [months] [bars], e.g. /trend 12 (2 to 24 months, 6 by default)"
+)
+
+// trendChart is the input of the trend script.
+type trendChart struct {
+	Title  string        `json:"title"`
+	Style  string        `json:"style"` // "area" or "bars"
+	Months []string      `json:"months"`
+	Series []trendSeries `json:"series"` // largest first
+}
+
+type trendSeries struct {
+	Name   string    `json:"name"`
+	Values []float64 `json:"values"` // one per month
+}
+
+// monthlyCategoryExpenses returns the expenses of each category in each
+// month of [from, to), by category then month (YYYY-MM).
+func monthlyCategoryExpenses(from time.Time, to time.Time) (map[string]map[string]Money, error) {
+	rows, err := db.Query("SELECT category, month, SUM(total) FROM monthly_aggregates WHERE type = 'expense' AND month >= ? AND month < ? AND "+ledgerScope()+" GROUP BY category, month",
+		from.Format("2006-01"), to.Format("2006-01"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]map[string]Money)
+	for rows.Next() {
+		var category, month string
+		var total Money
+		if err := rows.Scan(&category, &month, &total); err != nil {
+			return nil, err
+		}
+		if totals[category] == nil {
+			totals[category] = make(map[string]Money)
+		}
+		totals[category][month] = total
+	}
+	return totals, rows.Err()
+}
+
+// parseTrendArgs reads the number of months and the chart style.
+func parseTrendArgs(args string) (int, string, error) {
+	months, style := trendDefaultMonths, "area"
+	for _, field := range strings.Fields(strings.ToLower(args)) {
+		if field == "bars" || field == "area" {
+			style = field
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 2 || n > trendMaxMonths {
+			return 0, "", fmt.Errorf("invalid argument %q", field)
+		}
+		months = n
+	}
+	return months, style, nil
+}
+
+func showTrend(chatID int64, args string) {
+	count, style, err := parseTrendArgs(args)
+	if err != nil {
+		sendMessage(chatID, trendUsage)
+		return
+	}
+	now := appClock.Now()
+	thisMonth, nextMonth := monthBounds(now)
+	from := thisMonth.AddDate(0, -(count - 1), 0)
+	totals, err := monthlyCategoryExpenses(from, nextMonth)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the monthly totals.")
+		reportError("loading monthly totals by category", err)
+		return
+	}
+	if len(totals) == 0 {
+		sendMessage(chatID, fmt.Sprintf("No expenses in the last %d months.", count))
+		return
+	}
+
+	var months []time.Time
+	for m := from; m.Before(nextMonth); m = m.AddDate(0, 1, 0) {
+		months = append(months, m)
+	}
+	sum := func(category string) Money {
+		var total Money
+		for _, amount := range totals[category] {
+			total += amount
+		}
+		return total
+	}
+	categories := make([]string, 0, len(totals))
+	for category := range totals {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if a, b := sum(categories[i]), sum(categories[j]); a != b {
+			return a > b
+		}
+		return categories[i] < categories[j]
+	})
+
+	chart := trendChart{
+		Title: fmt.Sprintf("Monthly spending by category, %s – %s", from.Format("Jan 2006"), thisMonth.Format("Jan 2006")),
+		Style: style,
+	}
+	for _, m := range months {
+		label := m.Format("Jan 06")
+		if m.Equal(thisMonth) {
+			label += "*"
+		}
+		chart.Months = append(chart.Months, label)
+	}
+	var other *trendSeries
+	for i, category := range categories {
+		if i == trendMaxCategories && len(categories) > trendMaxCategories+1 {
+			chart.Series = append(chart.Series, trendSeries{Name: "Other", Values: make([]float64, len(months))})
+			other = &chart.Series[len(chart.Series)-1]
+		}
+		values := make([]float64, len(months))
+		for j, m := range months {
+			values[j] = totals[category][m.Format("2006-01")].Float()
+		}
+		if other != nil {
+			for j := range values {
+				other.Values[j] += values[j]
+			}
+			continue
+		}
+		chart.Series = append(chart.Series, trendSeries{Name: category, Values: values})
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📊 %s\n* %s so far", chart.Title, thisMonth.Format("January")))
+	if growth := trendGrowth(categories, totals, months[:len(months)-1]); growth != "" {
+		sb.WriteString("\n\n" + growth)
+	}
+	sendChart(chatID, trendScript, chart, sb.String())
+}
+
+// trendGrowth lists the categories that grew the most in the last of the
+// complete months against the average of the ones before, or "" with
+// fewer than two months or no growth.
+func trendGrowth(categories []string, totals map[string]map[string]Money, complete []time.Time) string {
+	if len(complete) < 2 {
+		return ""
+	}
+	last := complete[len(complete)-1]
+	type growth struct {
+		category       string
+		latest, before Money
+		change         float64
+	}
+	var grown []growth
+	for _, category := range categories {
+		var before Money
+		for _, m := range complete[:len(complete)-1] {
+			before += totals[category][m.Format("2006-01")]
+		}
+		before = before.Div(len(complete) - 1)
+		latest := totals[category][last.Format("2006-01")]
+		if before > 0 && latest > before {
+			grown = append(grown, growth{category, latest, before, percentOf((latest - before).Float(), before.Float())})
+		}
+	}
+	if len(grown) == 0 {
+		return ""
+	}
+	sort.SliceStable(grown, func(i, j int) bool { return grown[i].change > grown[j].change })
+	lines := []string{fmt.Sprintf("Growing in %s against the average before:", last.Format("January"))}
+	for _, g := range grown[:min(3, len(grown))] {
+		lines = append(lines, fmt.Sprintf("• %s: %s, +%.0f%% on an average of %s", g.category, formatMoney(g.latest), g.change, formatMoney(g.before)))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+/*
+	UPDATE TYPES beyond messages and buttons
+
+	edited_message      editing the message that gave the amount or the
+	                    description of the last logged transaction offers
+	                    to update it, behind a confirm button (replies.go)
+	my_chat_member      a user blocking or unblocking the bot in a private
+	                    chat is recorded in user_activity (see /users)
+	pre_checkout_query  refused right away, the bot sells nothing; Telegram
+	                    would otherwise keep the user waiting
+	successful_payment  logged and ignored
+
+	Every other type (channel posts, polls, reactions, ...) is logged and
+	ignored.
+*/
+
+// loggedEntry remembers which messages of the user produced their last
+// transaction.
+type loggedEntry struct {
+	TransactionID        int64
+	AmountMessageID      int
+	DescriptionMessageID int
+}
+
+// lastLogged holds the last transaction logged by each user in this run.
+var lastLogged = make(map[int64]*loggedEntry)
+
+// dispatchUpdate routes an update to the handler of its type.
+func dispatchUpdate(update Update) {
+	switch {
+	case update.Message != nil && update.Message.SuccessfulPayment != nil:
+		p := update.Message.SuccessfulPayment
+		log.Printf("Ignoring update %d: payment of %d %s (charge %s)", update.UpdateID, p.TotalAmount, p.Currency, p.TelegramPaymentChargeID)
+	case update.Message != nil:
+		handleMessage(update.Message)
+	case update.CallbackQuery != nil:
+		handleCallbackQuery(update.CallbackQuery)
+	case update.EditedMessage != nil:
+		handleEditedMessage(update.EditedMessage)
+	case update.MyChatMember != nil:
+		handleMyChatMember(update.MyChatMember)
+	case update.PreCheckoutQuery != nil:
+		refusePreCheckout(update.PreCheckoutQuery)
+	default:
+		kind := update.Kind
+		if kind == "" {
+			kind = "unknown type"
+		}
+		log.Printf("Ignoring update %d (%s)", update.UpdateID, kind)
+	}
+}
+
+// handleEditedMessage offers to apply an edit of the message that held
+// the amount or the description of the user's last transaction. Edits of
+// any other message are ignored, as they were already handled in their
+// original form.
+func handleEditedMessage(message *TGMessage) {
+	if message.From == nil || message.Chat == nil || isGroupChat(message.Chat) || !isAllowedUser(message.From.ID) {
+		return
+	}
+	entry, ok := lastLogged[message.From.ID]
+	if !ok {
+		return
+	}
+	chatID := message.Chat.ID
+	text := strings.TrimSpace(message.Text)
+
+	var field, value string
+	switch message.MessageID {
+	case entry.AmountMessageID:
+		amount, err := parseMoney(text)
+		if err != nil || amount <= 0 {
+			sendMessage(chatID, "The edited amount is not a positive number; the transaction was not changed.")
+			return
+		}
+		field, value = "amount", amount.Plain()
+	case entry.DescriptionMessageID:
+		if text == "" || len(text) > 100 {
+			sendMessage(chatID, "The edited description must be 1 to 100 characters; the transaction was not changed.")
+			return
+		}
+		field, value = "description", text
+	default:
+		return
+	}
+	offerAmend(chatID, message.From.ID, entry.TransactionID, field, value)
+}
+
+// handleMyChatMember records a user blocking (kicked) or unblocking
+// (member) the bot in their private chat.
+func handleMyChatMember(update *TGChatMemberUpdated) {
+	if update.Chat == nil || update.From == nil {
+		return
+	}
+	status := update.NewChatMember.Status
+	if update.Chat.Type != "private" {
+		log.Printf("Bot is now %s in chat %d (%s)", status, update.Chat.ID, update.Chat.Title)
+		return
+	}
+	var err error
+	switch status {
+	case "kicked":
+		log.Printf("User %d blocked the bot", update.From.ID)
+		_, err = db.Exec(`INSERT INTO user_activity (user_id, name, chat_id, message_count, blocked_at) VALUES (?, ?, ?, 0, CURRENT_TIMESTAMP)
+			ON CONFLICT(user_id) DO UPDATE SET blocked_at = CURRENT_TIMESTAMP`,
+			update.From.ID, displayName(update.From), update.Chat.ID)
+	case "member":
+		log.Printf("User %d unblocked the bot", update.From.ID)
+		_, err = db.Exec("UPDATE user_activity SET blocked_at = NULL WHERE user_id = ?", update.From.ID)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to record the chat member status of user %d: %v", update.From.ID, err)
+	}
+}
+
+func refusePreCheckout(query *TGPreCheckoutQuery) {
+	log.Printf("Refusing payment of %d %s (%s)", query.TotalAmount, query.Currency, query.InvoicePayload)
+	if botClient == nil {
+		return
+	}
+	if err := botClient.AnswerPreCheckoutQuery(query.ID, false, "This bot does not sell anything."); err != nil {
+		log.Printf("Failed to answer pre-checkout query %s: %v", query.ID, err)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	TRANSACTION VIEWER (/view <id>)
+
+	Shows every field of one transaction and its change history, with
+	buttons to edit, delete or duplicate it. The history comes from
+	transaction_audit, filled by triggers with the values before (and
+	after) each update or delete, so changes made by any code path are
+	recorded. Archived transactions can be viewed but not changed.
+*/
+
+const auditColumns = `json_object('type', %[1]s.type, 'category', %[1]s.category, 'quantity', %[1]s.quantity, 'amount', %[1]s.amount,
+	'description', %[1]s.description, 'created_at', %[1]s.created_at, 'is_outlier', %[1]s.is_outlier, 'notes', %[1]s.notes)`
+
+var auditTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS transactions_audit_update AFTER UPDATE ON transactions
+	WHEN OLD.type IS NOT NEW.type OR OLD.category IS NOT NEW.category OR OLD.quantity IS NOT NEW.quantity OR OLD.amount IS NOT NEW.amount
+		OR OLD.description IS NOT NEW.description OR OLD.created_at IS NOT NEW.created_at OR OLD.is_outlier IS NOT NEW.is_outlier OR OLD.notes IS NOT NEW.notes
+	BEGIN
+		INSERT INTO transaction_audit (transaction_id, action, old_values, new_values)
+		VALUES (OLD.id, 'update', ` + fmt.Sprintf(auditColumns, "OLD") + `, ` + fmt.Sprintf(auditColumns, "NEW") + `);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_audit_delete AFTER DELETE ON transactions BEGIN
+		INSERT INTO transaction_audit (transaction_id, action, old_values)
+		VALUES (OLD.id, 'delete', ` + fmt.Sprintf(auditColumns, "OLD") + `);
+	END`,
+}
+
+// auditFields lists the audited fields in display order.
+var auditFields = []string{"type", "category", "quantity", "amount", "description", "created_at", "is_outlier", "notes"}
+
+const viewHistoryLimit = 10
+
+type auditEntry struct {
+	Action    string
+	Old, New  map[string]interface{}
+	ChangedAt string
+}
+
+func loadAuditHistory(id int64) ([]auditEntry, error) {
+	rows, err := db.Query("SELECT action, old_values, COALESCE(new_values, ''), changed_at FROM transaction_audit WHERE transaction_id = ? ORDER BY id DESC LIMIT ?", id, viewHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []auditEntry
+	for rows.Next() {
+		var e auditEntry
+		var oldJSON, newJSON string
+		if err := rows.Scan(&e.Action, &oldJSON, &newJSON, &e.ChangedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(oldJSON), &e.Old)
+		if newJSON != "" {
+			json.Unmarshal([]byte(newJSON), &e.New)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// describeChange lists the fields an update changed, e.g.
+// "amount 25000 → 30000".
+func describeChange(e auditEntry) string {
+	if e.Action == "delete" {
+		return "deleted"
+	}
+	var changes []string
+	for _, field := range auditFields {
+		before, after := formatAuditValue(field, e.Old[field]), formatAuditValue(field, e.New[field])
+		if before != after {
+			changes = append(changes, fmt.Sprintf("%s %s → %s", field, before, after))
+		}
+	}
+	return strings.Join(changes, ", ")
+}
+
+// formatAuditValue shows the value of field in an audit entry; amounts
+// are recorded in minor units.
+func formatAuditValue(field string, v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "(none)"
+	case float64:
+		if field == "amount" {
+			return formatMoney(Money(math.Round(v)))
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		if v == "" {
+			return "(none)"
+		}
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatChangedAt shows an audit timestamp (stored in UTC) in local time.
+func formatChangedAt(s string) string {
+	t, err := time.Parse(dbTimeLayout, s)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s)
+	}
+	if err != nil {
+		return s
+	}
+	return t.In(appLocation()).Format("2 Jan 2006 15:04")
+}
+
+// parseCreatedAt reads a created_at value scanned as text, which holds
+// local wall-clock time.
+func parseCreatedAt(s string) (time.Time, error) {
+	t, err := time.ParseInLocation(dbTimeLayout, s, appLocation())
+	if err != nil {
+		var utc time.Time
+		if utc, err = time.Parse(time.RFC3339, s); err == nil {
+			// the driver labels the wall-clock time as UTC
+			t = time.Date(utc.Year(), utc.Month(), utc.Day(), utc.Hour(), utc.Minute(), utc.Second(), 0, appLocation())
+		}
+	}
+	return t, err
+}
+
+// formatCreatedAt shows a created_at value without converting it.
+func formatCreatedAt(s string) string {
+	t, err := parseCreatedAt(s)
+	if err != nil {
+		return s
+	}
+	return t.Format("2 Jan 2006 15:04")
+}
+
+func showTransaction(chatID int64, args string) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(args), "#"), 10, 64)
+	if err != nil || id <= 0 {
+		sendMessage(chatID, "Usage: /view <id>")
+		return
+	}
+	viewTransaction(chatID, id)
+}
+
+// viewTransaction sends the details of transaction id.
+func viewTransaction(chatID int64, id int64) {
+	archived := false
+	row := queryRowCached(selectTransactionByID(), id)
+	var (
+		rid         int64
+		typ         string
+		category    string
+		quantity    float64
+		amount      Money
+		description sql.NullString
+		createdAt   string
+		isOutlier   sql.NullBool
+	)
+	err := row.Scan(&rid, &typ, &category, &quantity, &amount, &description, &createdAt, &isOutlier)
+	if err == sql.ErrNoRows {
+		archived = true
+		err = db.QueryRow("SELECT id, type, category, quantity, amount, description, created_at, is_outlier FROM transactions_archive WHERE id = ? AND "+ledgerScope(), id).
+			Scan(&rid, &typ, &category, &quantity, &amount, &description, &createdAt, &isOutlier)
+	}
+	history, historyErr := loadAuditHistory(id)
+	if historyErr != nil {
+		reportError("loading transaction history", historyErr)
+	}
+	if err == sql.ErrNoRows {
+		text := fmt.Sprintf("Transaction with ID %d not found.", id)
+		if len(history) > 0 && history[0].Action == "delete" {
+			text = fmt.Sprintf("Transaction %d was deleted on %s.", id, formatChangedAt(history[0].ChangedAt))
+		}
+		sendMessage(chatID, text)
+		return
+	}
+	if err != nil {
+		sendMessage(chatID, "Failed to retrieve transaction.")
+		reportError("loading a transaction", err)
+		return
+	}
+
+	notes := ""
+	if archived {
+		db.QueryRow("SELECT COALESCE(notes, '') FROM transactions_archive WHERE id = ?", id).Scan(&notes)
+	} else {
+		notes = transactionNotes(id)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🧾 " + bold("Transaction") + " " + idHTML(id))
+	if archived {
+		sb.WriteString(" (archived)")
+	}
+	sb.WriteString("\n\n")
+	sb.WriteString(fmt.Sprintf("Type: %s%s\nCategory: %s\nQuantity: %.2f\nAmount: %s\n", typeIcons()[typ], escapeHTML(typ), bold(category), quantity, moneyHTML(amount)))
+	if quantity != 1 {
+		sb.WriteString(fmt.Sprintf("Total: %s\n", bold(formatMoney(amount.Mul(quantity)))))
+	}
+	table := "transactions"
+	if archived {
+		table = "transactions_archive"
+	}
+	if line := originalAmountLine(table, id); line != "" {
+		sb.WriteString(escapeHTML(line) + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("Description: %s\nDate: %s\n", escapeHTML(description.String), formatCreatedAt(createdAt)))
+	if isOutlier.Valid && isOutlier.Bool {
+		sb.WriteString("Outlier: yes\n")
+	}
+	if lat, lon, ok, err := transactionLocation(id); err != nil {
+		reportError("loading the location of a transaction", err)
+	} else if ok {
+		sb.WriteString(fmt.Sprintf("Location: 📍 %s\n", escapeHTML(formatLocation(lat, lon))))
+	}
+	if !archived {
+		if tax := taxLine(transactionTax(id)); tax != "" {
+			sb.WriteString(escapeHTML(strings.TrimPrefix(tax, "\n")) + "\n")
+		}
+		if line := paymentMethodLine(id); line != "" {
+			sb.WriteString(escapeHTML(line) + "\n")
+		}
+		if line := reimbursementLine(id); line != "" {
+			sb.WriteString(escapeHTML(line) + "\n")
+		}
+	}
+	if notes != "" {
+		sb.WriteString("\n" + bold("Notes:") + "\n" + escapeHTML(notes) + "\n")
+	}
+	if refs, err := loadReferences(id); err != nil {
+		reportError("loading references", err)
+	} else {
+		sb.WriteString(escapeHTML(referencesBlock(refs)))
+	}
+	if len(history) > 0 {
+		sb.WriteString("\n" + bold("History:") + "\n")
+		for _, e := range history {
+			sb.WriteString(escapeHTML(fmt.Sprintf("• %s: %s", formatChangedAt(e.ChangedAt), describeChange(e))) + "\n")
+		}
+	}
+	text := strings.TrimRight(sb.String(), "\n")
+
+	if archived {
+		sendHTML(chatID, text)
+		return
+	}
+	buttons := append(transactionButtons(id), InlineKeyboardButton{Text: "📄 Duplicate", CallbackData: fmt.Sprintf("view:duplicate:%d", id)})
+	sendHTMLWithKeyboard(chatID, text, buildKeyboard([][]InlineKeyboardButton{buttons}))
+}
+
+// transactionButtons are the Edit and Delete buttons of a transaction,
+// handled by handleViewCallback.
+func transactionButtons(id int64) []InlineKeyboardButton {
+	return []InlineKeyboardButton{
+		{Text: "✏️ Edit", CallbackData: fmt.Sprintf("view:edit:%d", id)},
+		{Text: "🗑️ Delete", CallbackData: fmt.Sprintf("view:delete:%d", id)},
+	}
+}
+
+// transactionRecap describes a transaction in one line of HTML, e.g.
+// "💸 #214 expense Food 25.00 'lunch'" with the id and amount in
+// monospace.
+func transactionRecap(id int64, typ, category string, quantity float64, amount Money, description string) string {
+	recap := fmt.Sprintf("%s%s %s %s %s", typeIcons()[typ], idHTML(id), escapeHTML(typ), bold(category), moneyHTML(amount))
+	if quantity != 1 {
+		recap = fmt.Sprintf("%s%s %s %s %g × %s", typeIcons()[typ], idHTML(id), escapeHTML(typ), bold(category), quantity, moneyHTML(amount))
+	}
+	if description != "" {
+		recap += " '" + escapeHTML(description) + "'"
+	}
+	return recap
+}
+
+// handleViewCallback handles the buttons of /view: view:<action>:<id>.
+func handleViewCallback(callback *CallbackQuery) {
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		_ = messenger.AnswerCallback(callback.ID, "Invalid button.")
+		return
+	}
+	if isMaintenanceMode() {
+		_ = messenger.AnswerCallback(callback.ID, "The bot is in read-only maintenance mode.")
+		return
+	}
+	_ = messenger.AnswerCallback(callback.ID, "")
+
+	chatID := callback.Message.Chat.ID
+	switch parts[1] {
+	case "edit":
+		startEditWithID(chatID, callback.From.ID, id)
+	case "delete":
+		startDeleteWithID(chatID, callback.From.ID, id)
+	case "duplicate":
+		newID, roundup, err := duplicateTransaction(id)
+		if err == sql.ErrNoRows {
+			sendMessage(chatID, fmt.Sprintf("Transaction with ID %d not found.", id))
+			return
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to duplicate the transaction.")
+			reportError(fmt.Sprintf("duplicating transaction %d", id), err)
+			return
+		}
+		text := fmt.Sprintf("Transaction %d duplicated as #%d, dated now.", id, newID)
+		if roundup != "" {
+			text += "\n" + roundup
+		}
+		sendMessage(chatID, text)
+		viewTransaction(chatID, newID)
+	}
+}
+
+// duplicateTransaction copies transaction id, dated now, and returns the
+// id of the copy with the notes for the confirmation (transactionAdded).
+func duplicateTransaction(id int64) (int64, string, error) {
+	t, err := store.Transaction(id)
+	if err != nil {
+		return 0, "", err
+	}
+	t.CreatedAt = appClock.Now()
+	newID, err := store.AddTransaction(t)
+	if err != nil {
+		return 0, "", err
+	}
+	// the tax and payment details live outside the Storage records
+	if _, err := db.Exec(`UPDATE transactions SET (deductible, tax_amount, payment_method) =
+		(SELECT deductible, tax_amount, payment_method FROM transactions WHERE id = ?) WHERE id = ?`, id, newID); err != nil {
+		return 0, "", err
+	}
+	return newID, transactionAdded(newID, t), nil
+}
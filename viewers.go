@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+	VIEWERS (/grantviewer, owner only)
+
+	The owner can let a second Telegram account, a partner for instance,
+	see the reports without touching the books:
+
+	/grantviewer 123456789     give the account read-only access
+	/revokeviewer 123456789
+	/viewers                   the viewers and their latest requests
+
+	A viewer can run the report commands of viewerCommands and nothing
+	else: no command that adds, edits or deletes, no settings, no flows,
+	no buttons but "Show more". Every message a viewer sends is logged in
+	viewer_queries, refused ones included, for /viewers to show, and kept
+	for 90 days (maintenance.go). The ID of an account appears in /users
+	once it has written to the bot. Users in allowed_users have full
+	access and need no grant.
+*/
+
+const viewerQueryLogSize = 10
+
+// viewerCommands are the commands a viewer may run; false marks those
+// that may only run without arguments, their arguments changing settings.
+var viewerCommands = map[string]bool{
+	"summary":                     true,
+	"get_latest_report":           true,
+	"get_weekly_expense":          true,
+	"get_weekly_expense_piechart": true,
+	"forecast":                    true,
+	"networth":                    true,
+	"week":                        true,
+	"flow":                        true,
+	"trend":                       true,
+	"heatmap":                     true,
+	"top":                         true,
+	"insights":                    true,
+	"taxreport":                   true,
+	"burnrate":                    true,
+	"streaks":                     true,
+	"reports":                     true,
+	"report":                      true, // see viewerMayRun
+	"categories":                  true, // see viewerMayRun
+	"savingsrate":                 false,
+	"goals":                       false,
+}
+
+// viewerCallbackPrefixes are the buttons of those reports a viewer may press.
+var viewerCallbackPrefixes = []string{"latest:"}
+
+const viewerHelp = "👀 You have read-only access to the reports:\n" +
+	"/summary, /get_latest_report, /get_weekly_expense, /get_weekly_expense_piechart, " +
+	"/week, /flow, /trend, /heatmap, /top, /insights, /forecast, /burnrate, /networth, " +
+	"/taxreport, /streaks, /savingsrate, /goals, /categories, /reports and /report <name>."
+
+func isViewer(userID int64) bool {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM viewers WHERE user_id = ?", userID).Scan(&n); err != nil {
+		reportError("checking a viewer", err)
+		return false
+	}
+	return n > 0
+}
+
+// viewerMayRun tells whether a viewer may run command with args.
+func viewerMayRun(command string, args string) bool {
+	withArgs, ok := viewerCommands[command]
+	if !ok {
+		return false
+	}
+	fields := strings.Fields(args)
+	switch command {
+	case "report":
+		// running a saved report or listing them; not building or deleting
+		return len(fields) == 1
+	case "categories":
+		// reading the notes and the archive, not writing them
+		if len(fields) == 0 {
+			return true
+		}
+		switch strings.ToLower(fields[0]) {
+		case "note", "archive", "unarchive":
+			return false
+		}
+		return true
+	}
+	return withArgs || len(fields) == 0
+}
+
+// allowViewerMessage logs a viewer's message and tells whether it may go
+// on to the command handlers; otherwise it has answered already.
+func allowViewerMessage(message *TGMessage, command string, args string) bool {
+	allowed := viewerMayRun(command, args)
+	text := message.Text
+	if text == "" {
+		text = "(no text)"
+	}
+	log.Printf("viewer user=%d %s allowed=%t", message.From.ID, strconv.Quote(text), allowed)
+	if _, err := db.Exec("INSERT INTO viewer_queries (user_id, query, allowed, created_at) VALUES (?, ?, ?, ?)",
+		message.From.ID, text, allowed, appClock.Now().Format(dbTimeLayout)); err != nil {
+		reportError("logging a viewer query", err)
+	}
+	if allowed {
+		return true
+	}
+	if command == "" || command == "start" || command == "help" {
+		sendMessage(message.Chat.ID, viewerHelp)
+		return false
+	}
+	sendMessage(message.Chat.ID, "Viewers can only see the reports.\n\n"+viewerHelp)
+	return false
+}
+
+// viewerMayPress tells whether a viewer may press a button with data.
+func viewerMayPress(data string) bool {
+	for _, prefix := range viewerCallbackPrefixes {
+		if strings.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleGrantViewerCommand(chatID int64, userID int64, args string) {
+	id, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil || id <= 0 {
+		sendMessage(chatID, "Usage: /grantviewer <user id>\nThe ID of an account appears in /users once it has written to the bot.")
+		return
+	}
+	if isAllowedUser(id) {
+		sendMessage(chatID, fmt.Sprintf("User %d is in allowed_users and already has full access.", id))
+		return
+	}
+	if _, err := db.Exec("INSERT OR IGNORE INTO viewers (user_id, granted_by, granted_at) VALUES (?, ?, ?)",
+		id, userID, appClock.Now().Format(dbTimeLayout)); err != nil {
+		sendMessage(chatID, "Failed to grant access.")
+		reportError("granting viewer access", err)
+		return
+	}
+	log.Printf("viewer access granted to user %d by %d", id, userID)
+	sendMessage(chatID, fmt.Sprintf("👀 User %d can now see the reports, read-only. Revoke it with /revokeviewer %d.", id, id))
+}
+
+func handleRevokeViewerCommand(chatID int64, args string) {
+	id, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		sendMessage(chatID, "Usage: /revokeviewer <user id>")
+		return
+	}
+	res, err := db.Exec("DELETE FROM viewers WHERE user_id = ?", id)
+	if err != nil {
+		sendMessage(chatID, "Failed to revoke access.")
+		reportError("revoking viewer access", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		sendMessage(chatID, fmt.Sprintf("User %d is not a viewer. See them with /viewers.", id))
+		return
+	}
+	log.Printf("viewer access revoked for user %d", id)
+	sendMessage(chatID, fmt.Sprintf("User %d can no longer see the reports.", id))
+}
+
+type viewer struct {
+	UserID    int64
+	Name      string
+	GrantedAt string
+}
+
+type viewerQuery struct {
+	UserID    int64
+	Query     string
+	Allowed   bool
+	CreatedAt string
+}
+
+func loadViewers() ([]viewer, error) {
+	rows, err := db.Query(`SELECT v.user_id, COALESCE(a.name, ''), v.granted_at FROM viewers v
+		LEFT JOIN user_activity a ON a.user_id = v.user_id ORDER BY v.granted_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var viewers []viewer
+	for rows.Next() {
+		var v viewer
+		if err := rows.Scan(&v.UserID, &v.Name, &v.GrantedAt); err != nil {
+			return nil, err
+		}
+		viewers = append(viewers, v)
+	}
+	return viewers, rows.Err()
+}
+
+func loadViewerQueries(limit int) ([]viewerQuery, error) {
+	rows, err := db.Query("SELECT user_id, query, allowed, created_at FROM viewer_queries ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var queries []viewerQuery
+	for rows.Next() {
+		var q viewerQuery
+		if err := rows.Scan(&q.UserID, &q.Query, &q.Allowed, &q.CreatedAt); err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+func showViewers(chatID int64) {
+	viewers, err := loadViewers()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the viewers.")
+		reportError("loading viewers", err)
+		return
+	}
+	if len(viewers) == 0 {
+		sendMessage(chatID, "No viewers. Give an account read-only access to the reports with /grantviewer <user id>.")
+		return
+	}
+	queries, err := loadViewerQueries(viewerQueryLogSize)
+	if err != nil {
+		sendMessage(chatID, "Failed to load the viewer log.")
+		reportError("loading viewer queries", err)
+		return
+	}
+	lines := []string{"👀 Viewers:"}
+	for _, v := range viewers {
+		name := v.Name
+		if name == "" {
+			name = "has not written yet"
+		}
+		lines = append(lines, fmt.Sprintf("%d (%s), since %s", v.UserID, name, formatCreatedAt(v.GrantedAt)))
+	}
+	if len(queries) > 0 {
+		lines = append(lines, "", "Latest requests:")
+		for _, q := range queries {
+			mark := "✅"
+			if !q.Allowed {
+				mark = "🚫"
+			}
+			lines = append(lines, fmt.Sprintf("%s %s %d: %s", mark, formatCreatedAt(q.CreatedAt), q.UserID, q.Query))
+		}
+	}
+	sendMessage(chatID, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	WEBHOOKS (/webhook, owner only)
+
+	Every transaction created, updated or deleted is POSTed as JSON to the
+	registered URLs, to feed automations like n8n or Home Assistant:
+
+	/webhook                              list the webhooks and their deliveries
+	/webhook add https://n8n.example/hook register a URL, its secret is shown once
+	/webhook test 2                       send a ping
+	/webhook remove 2
+
+	The body is {"event": "transaction.created", "delivery_id": 12,
+	"occurred_at": ..., "transaction": {...}}, with the transaction as the
+	HTTP API (api.go) returns it plus its notes and ledger; updates also
+	carry "previous", and deletes the values before the deletion. The
+	X-Ayunda-Signature-256 header is "sha256=" and the hex HMAC-SHA256 of
+	the body with the secret of the webhook, for the receiver to check.
+
+	Like the audit history (view.go), the events are recorded by triggers,
+	so changes made by any code path are sent, and moving transactions to
+	the archive is not a deletion. Deliveries are queued in
+	webhook_deliveries and sent in the background like the outbox: a 2xx
+	answer delivers them, network errors, 408, 429 and 5xx are retried with
+	a growing delay, anything else or webhookMaxAttempts failures give up.
+*/
+
+const (
+	webhookPollInterval = 5 * time.Second
+	webhookBatchSize    = 20
+	webhookMaxAttempts  = 10
+	webhookTimeout      = 10 * time.Second
+	webhookUsage        = "Usage:\n/webhook - list the webhooks\n/webhook add <url> - send transaction changes to a URL\n/webhook test <id> - send a ping\n/webhook remove <id> - stop sending to a webhook"
+)
+
+// webhookColumns snapshots a transaction for a delivery.
+const webhookColumns = `json_object('id', %[1]s.id, 'type', %[1]s.type, 'category', %[1]s.category, 'quantity', %[1]s.quantity, 'amount', %[1]s.amount,
+	'description', %[1]s.description, 'created_at', %[1]s.created_at, 'notes', %[1]s.notes, 'ledger_id', %[1]s.ledger_id)`
+
+var webhookTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS transactions_webhook_insert AFTER INSERT ON transactions BEGIN
+		INSERT INTO webhook_deliveries (webhook_id, event, new_values)
+		SELECT id, 'transaction.created', ` + fmt.Sprintf(webhookColumns, "NEW") + ` FROM webhooks;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_webhook_update AFTER UPDATE ON transactions
+	WHEN OLD.type IS NOT NEW.type OR OLD.category IS NOT NEW.category OR OLD.quantity IS NOT NEW.quantity OR OLD.amount IS NOT NEW.amount
+		OR OLD.description IS NOT NEW.description OR OLD.created_at IS NOT NEW.created_at OR OLD.notes IS NOT NEW.notes OR OLD.ledger_id IS NOT NEW.ledger_id
+	BEGIN
+		INSERT INTO webhook_deliveries (webhook_id, event, old_values, new_values)
+		SELECT id, 'transaction.updated', ` + fmt.Sprintf(webhookColumns, "OLD") + `, ` + fmt.Sprintf(webhookColumns, "NEW") + ` FROM webhooks;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_webhook_delete AFTER DELETE ON transactions
+	WHEN NOT EXISTS (SELECT 1 FROM transactions_archive WHERE id = OLD.id)
+	BEGIN
+		INSERT INTO webhook_deliveries (webhook_id, event, old_values)
+		SELECT id, 'transaction.deleted', ` + fmt.Sprintf(webhookColumns, "OLD") + ` FROM webhooks;
+	END`,
+}
+
+// webhookTransaction is a transaction in a webhook payload.
+type webhookTransaction struct {
+	apiTransaction
+	Notes    string `json:"notes,omitempty"`
+	LedgerID int64  `json:"ledger_id"`
+}
+
+// webhookSnapshot is a transaction as the triggers store it, amounts in
+// minor units.
+type webhookSnapshot struct {
+	ID          int64   `json:"id"`
+	Type        string  `json:"type"`
+	Category    string  `json:"category"`
+	Quantity    float64 `json:"quantity"`
+	Amount      int64   `json:"amount"`
+	Description string  `json:"description"`
+	CreatedAt   string  `json:"created_at"`
+	Notes       string  `json:"notes"`
+	LedgerID    int64   `json:"ledger_id"`
+}
+
+func (s webhookSnapshot) transaction() *webhookTransaction {
+	date := s.CreatedAt
+	if t, err := parseCreatedAt(s.CreatedAt); err == nil {
+		date = t.Format(dbTimeLayout)
+	}
+	return &webhookTransaction{
+		apiTransaction: apiTransaction{ID: s.ID, Type: s.Type, Category: s.Category, Quantity: s.Quantity, Amount: Money(s.Amount), Description: s.Description, Date: date},
+		Notes:          s.Notes,
+		LedgerID:       s.LedgerID,
+	}
+}
+
+type webhookPayload struct {
+	Event       string              `json:"event"`
+	DeliveryID  int64               `json:"delivery_id"`
+	OccurredAt  string              `json:"occurred_at"`
+	Transaction *webhookTransaction `json:"transaction,omitempty"`
+	Previous    *webhookTransaction `json:"previous,omitempty"`
+}
+
+type webhookDelivery struct {
+	ID        int64
+	URL       string
+	Secret    string
+	Event     string
+	OldValues sql.NullString
+	NewValues sql.NullString
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// body builds the JSON payload of the delivery.
+func (d webhookDelivery) body() ([]byte, error) {
+	payload := webhookPayload{Event: d.Event, DeliveryID: d.ID, OccurredAt: d.CreatedAt.UTC().Format(time.RFC3339)}
+	snapshot := func(values sql.NullString) (*webhookTransaction, error) {
+		if !values.Valid {
+			return nil, nil
+		}
+		var s webhookSnapshot
+		if err := json.Unmarshal([]byte(values.String), &s); err != nil {
+			return nil, err
+		}
+		return s.transaction(), nil
+	}
+	var err error
+	switch d.Event {
+	case "transaction.deleted":
+		payload.Transaction, err = snapshot(d.OldValues)
+	case "transaction.updated":
+		if payload.Previous, err = snapshot(d.OldValues); err == nil {
+			payload.Transaction, err = snapshot(d.NewValues)
+		}
+	default:
+		payload.Transaction, err = snapshot(d.NewValues)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+// webhookSignature signs a body the way receivers check it.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// postWebhook sends one delivery and returns the HTTP status, and whether
+// a failure may succeed when retried.
+func postWebhook(d webhookDelivery) (int, bool, error) {
+	body, err := d.body()
+	if err != nil {
+		return 0, false, fmt.Errorf("building the payload: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ayunda-webhook")
+	req.Header.Set("X-Ayunda-Event", d.Event)
+	req.Header.Set("X-Ayunda-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Ayunda-Signature-256", webhookSignature(d.Secret, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+	retry := resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return resp.StatusCode, retry, fmt.Errorf("HTTP %s", resp.Status)
+}
+
+// runWebhooks delivers pending webhook deliveries until the process exits.
+func runWebhooks() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deliverWebhooks()
+	}
+}
+
+func pendingWebhookDeliveries() ([]webhookDelivery, error) {
+	rows, err := db.Query(`SELECT d.id, w.url, w.secret, d.event, d.old_values, d.new_values, d.attempts, d.created_at
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ? ORDER BY d.id LIMIT ?`,
+		time.Now().UTC().Format(dbTimeLayout), webhookBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.ID, &d.URL, &d.Secret, &d.Event, &d.OldValues, &d.NewValues, &d.Attempts, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+func deliverWebhooks() {
+	deliveries, err := pendingWebhookDeliveries()
+	if err != nil {
+		log.Printf("Failed to load webhook deliveries: %v", err)
+		return
+	}
+	for _, d := range deliveries {
+		status, retry, sendErr := postWebhook(d)
+		attempts := d.Attempts + 1
+		lastStatus := sql.NullInt64{Int64: int64(status), Valid: status != 0}
+		if sendErr == nil {
+			if _, err := db.Exec("UPDATE webhook_deliveries SET status = 'delivered', delivered_at = CURRENT_TIMESTAMP, attempts = ?, last_status = ?, last_error = NULL WHERE id = ?",
+				attempts, lastStatus, d.ID); err != nil {
+				log.Printf("Failed to mark webhook delivery %d as delivered: %v", d.ID, err)
+			}
+			continue
+		}
+
+		state := "pending"
+		if !retry || attempts >= webhookMaxAttempts {
+			state = "failed"
+			log.Printf("Giving up on webhook delivery %d (%s) to %s: %v", d.ID, d.Event, d.URL, sendErr)
+		} else {
+			log.Printf("Webhook delivery %d (%s) to %s failed, will retry: %v", d.ID, d.Event, d.URL, sendErr)
+		}
+		next := time.Now().UTC().Add(outboxBackoff(attempts, sendErr))
+		if _, err := db.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status = ?, last_error = ? WHERE id = ?",
+			state, attempts, next.Format(dbTimeLayout), lastStatus, sendErr.Error(), d.ID); err != nil {
+			log.Printf("Failed to update webhook delivery %d: %v", d.ID, err)
+		}
+	}
+}
+
+// validWebhookURL checks that s is an absolute http(s) URL.
+func validWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+func handleWebhookCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		showWebhooks(chatID)
+		return
+	}
+	switch strings.ToLower(fields[0]) {
+	case "add":
+		if len(fields) != 2 || !validWebhookURL(fields[1]) {
+			sendMessage(chatID, "Give an http(s) URL.\n\n"+webhookUsage)
+			return
+		}
+		key := make([]byte, 24)
+		if _, err := rand.Read(key); err != nil {
+			sendMessage(chatID, "Failed to register the webhook.")
+			reportError("generating a webhook secret", err)
+			return
+		}
+		secret := hex.EncodeToString(key)
+		res, err := db.Exec("INSERT INTO webhooks (url, secret, created_at) VALUES (?, ?, ?)", fields[1], secret, appClock.Now().Format(dbTimeLayout))
+		if err != nil {
+			sendMessage(chatID, "Failed to register the webhook.")
+			reportError("registering a webhook", err)
+			return
+		}
+		id, _ := res.LastInsertId()
+		sendHTML(chatID, fmt.Sprintf("🪝 Webhook %d registered: new, edited and deleted transactions are sent to %s.\n\nSigning secret, shown only now:\n%s\n\nCheck the %s header, the HMAC-SHA256 of the body with it. Try it with /webhook test %d.",
+			id, escapeHTML(fields[1]), mono(secret), mono("X-Ayunda-Signature-256"), id))
+	case "test", "remove", "delete":
+		if len(fields) != 2 {
+			sendMessage(chatID, webhookUsage)
+			return
+		}
+		id, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			sendMessage(chatID, webhookUsage)
+			return
+		}
+		var res sql.Result
+		if strings.EqualFold(fields[0], "test") {
+			res, err = db.Exec("INSERT INTO webhook_deliveries (webhook_id, event) SELECT id, 'ping' FROM webhooks WHERE id = ?", id)
+		} else {
+			res, err = db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+		}
+		if err != nil {
+			sendMessage(chatID, "Failed to update the webhook.")
+			reportError("changing a webhook", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			sendMessage(chatID, fmt.Sprintf("Webhook %d not found. See them with /webhook.", id))
+			return
+		}
+		if strings.EqualFold(fields[0], "test") {
+			sendMessage(chatID, fmt.Sprintf("Ping queued for webhook %d; /webhook shows how it went.", id))
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Webhook %d removed.", id))
+	default:
+		sendMessage(chatID, webhookUsage)
+	}
+}
+
+// webhookStatus is a webhook with the count of its deliveries.
+type webhookStatus struct {
+	ID                         int64
+	URL                        string
+	Delivered, Pending, Failed int
+	LastError                  string // of the latest failed attempt
+}
+
+func loadWebhooks() ([]webhookStatus, error) {
+	rows, err := db.Query(`SELECT w.id, w.url,
+			COALESCE(SUM(d.status = 'delivered'), 0), COALESCE(SUM(d.status = 'pending'), 0), COALESCE(SUM(d.status = 'failed'), 0),
+			(SELECT COALESCE(last_error, '') FROM webhook_deliveries WHERE webhook_id = w.id AND status IN ('pending', 'failed') AND attempts > 0 ORDER BY id DESC LIMIT 1)
+		FROM webhooks w LEFT JOIN webhook_deliveries d ON d.webhook_id = w.id GROUP BY w.id ORDER BY w.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var webhooks []webhookStatus
+	for rows.Next() {
+		var w webhookStatus
+		var lastError sql.NullString
+		if err := rows.Scan(&w.ID, &w.URL, &w.Delivered, &w.Pending, &w.Failed, &lastError); err != nil {
+			return nil, err
+		}
+		w.LastError = lastError.String
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func showWebhooks(chatID int64) {
+	webhooks, err := loadWebhooks()
+	if err != nil {
+		sendMessage(chatID, "Failed to load the webhooks.")
+		reportError("loading webhooks", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		sendMessage(chatID, "No webhooks yet.\n\n"+webhookUsage)
+		return
+	}
+	lines := []string{"🪝 Webhooks:"}
+	for _, w := range webhooks {
+		lines = append(lines, fmt.Sprintf("%d. %s\n   %d delivered, %d pending, %d failed", w.ID, w.URL, w.Delivered, w.Pending, w.Failed))
+		if w.LastError != "" {
+			lines = append(lines, "   last error: "+w.LastError)
+		}
+	}
+	sendMessage(chatID, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	WEEKLY REPORTS (/week)
+
+	Weeks start on Monday or Sunday (week_start in the config file, or
+	/weekstart from chat). /week accepts an offset from the current week
+	("-1" is last week) or an ISO week such as "2026-W41"; ISO weeks start on
+	Monday, so with a Sunday week start the week begins the day before.
+
+	/get_weekly_expense is the rolling week instead: the expenses of the
+	last seven days up to today, in the configured timezone, day by day
+	and by category, against the seven days before.
+	/get_weekly_expense_piechart draws the same seven days by category
+	with src/g_w_e_piechart.py, in red the categories over their budget.
+	Budgets are monthly, so a category is over when this month's spending
+	so far is, whatever the seven days show.
+*/
+
+const weeklyPieScript = "src/g_w_e_piechart.py"
+
+// weeklyPieChart is the input of the weekly pie script.
+type weeklyPieChart struct {
+	Title  string           `json:"title"`
+	Slices []weeklyPieSlice `json:"slices"` // largest first
+}
+
+type weeklyPieSlice struct {
+	Category string  `json:"category"`
+	Total    float64 `json:"total"`
+	// BudgetUse is this month's spending over the budget, nil without one
+	BudgetUse  *float64 `json:"budget_use"`
+	OverBudget bool     `json:"over_budget"`
+}
+
+// weekStartDay returns the configured first day of the week.
+func weekStartDay() time.Weekday {
+	cfg := currentConfig()
+	start := getSetting("week_start", "")
+	if start == "" && cfg != nil {
+		start = cfg.WeekStart
+	}
+	if strings.EqualFold(start, "sunday") {
+		return time.Sunday
+	}
+	return time.Monday
+}
+
+// weekBounds returns the first instant of the week containing t and of the
+// following week.
+func weekBounds(t time.Time, first time.Weekday) (time.Time, time.Time) {
+	day, _ := dayBounds(t)
+	back := (int(day.Weekday()) - int(first) + 7) % 7
+	start := day.AddDate(0, 0, -back)
+	return start, start.AddDate(0, 0, 7)
+}
+
+var isoWeekPattern = regexp.MustCompile(`^(\d{4})-?[Ww](\d{1,2})$`)
+
+// parseWeekArg resolves a /week argument to the start of that week.
+func parseWeekArg(arg string, now time.Time, first time.Weekday) (time.Time, error) {
+	current, _ := weekBounds(now, first)
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return current, nil
+	}
+
+	if m := isoWeekPattern.FindStringSubmatch(arg); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		week, _ := strconv.Atoi(m[2])
+		// January 4th is always in ISO week 1
+		jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, now.Location())
+		monday := jan4.AddDate(0, 0, -((int(jan4.Weekday())+6)%7)+(week-1)*7)
+		if y, w := monday.ISOWeek(); week < 1 || y != year || w != week {
+			return time.Time{}, fmt.Errorf("%d has no ISO week %d", year, week)
+		}
+		if first == time.Sunday {
+			return monday.AddDate(0, 0, -1), nil
+		}
+		return monday, nil
+	}
+
+	offset, err := strconv.Atoi(arg)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid week %q", arg)
+	}
+	return current.AddDate(0, 0, 7*offset), nil
+}
+
+func showWeek(chatID int64, args string) {
+	now := appClock.Now()
+	first := weekStartDay()
+	start, err := parseWeekArg(args, now, first)
+	if err != nil {
+		sendMessage(chatID, fmt.Sprintf("%v. Usage: /week, /week -1 or /week 2026-W41", err))
+		return
+	}
+	text, err := weekReportText(start)
+	if err != nil {
+		sendMessage(chatID, "Failed to build the weekly report.")
+		reportError("building the weekly report", err)
+		return
+	}
+	sendHTML(chatID, text)
+}
+
+// weekReportText summarizes the seven days starting at start, in HTML.
+func weekReportText(start time.Time) (string, error) {
+	end := start.AddDate(0, 0, 7)
+	entries, err := loadEntries(start, end)
+	if err != nil {
+		return "", err
+	}
+
+	previous, err := loadEntries(start.AddDate(0, 0, -7), start)
+	if err != nil {
+		return "", err
+	}
+	var previousIncome, previousExpense Money
+	for _, e := range previous {
+		if e.Type == "income" {
+			previousIncome += e.Amount
+		} else {
+			previousExpense += e.Amount
+		}
+	}
+
+	var income, expense Money
+	byCategory := make(map[string]Money)
+	byDay := make(map[string]Money)
+	for _, e := range entries {
+		if e.Type == "income" {
+			income += e.Amount
+			continue
+		}
+		expense += e.Amount
+		byCategory[e.Category] += e.Amount
+		byDay[e.CreatedAt.Format(dateLayout)] += e.Amount
+	}
+
+	// ISO week of the Monday inside the week, so Sunday-start weeks keep their number
+	year, week := start.AddDate(0, 0, 3).ISOWeek()
+	var sb strings.Builder
+	sb.WriteString("🗓️ " + bold(fmt.Sprintf("Week %d-W%02d (%s – %s)", year, week, start.Format("2 Jan"), end.AddDate(0, 0, -1).Format("2 Jan 2006"))) + "\n\n")
+	icons := typeIcons()
+	sb.WriteString(withTrend(icons["income"]+"Income: "+moneyHTML(income), income, previousIncome, "last week") + "\n")
+	sb.WriteString(withTrend(icons["expense"]+"Expense: "+moneyHTML(expense), expense, previousExpense, "last week") + "\n")
+	sb.WriteString("Balance: " + bold(formatMoney(income-expense)) + "\n")
+
+	if len(byCategory) > 0 {
+		names := make([]string, 0, len(byCategory))
+		for name := range byCategory {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return byCategory[names[i]] > byCategory[names[j]] })
+		sb.WriteString("\n" + bold("By category:") + "\n")
+		for _, name := range names {
+			sb.WriteString(fmt.Sprintf("• %s: %s (%.0f%%)\n", escapeHTML(name), moneyHTML(byCategory[name]), percentOf(byCategory[name].Float(), expense.Float())))
+		}
+	}
+
+	sb.WriteString("\n" + bold("By day:") + "\n")
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		sb.WriteString(fmt.Sprintf("%s %s: %s\n", d.Format("Mon"), d.Format("02/01"), moneyHTML(byDay[d.Format(dateLayout)])))
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// lastSevenDaysText summarizes the expenses of the seven days ending with
+// now's day, against the seven days before, in HTML.
+func lastSevenDaysText(now time.Time) (string, error) {
+	_, end := dayBounds(now)
+	start := end.AddDate(0, 0, -7)
+	entries, err := loadEntries(start.AddDate(0, 0, -7), end)
+	if err != nil {
+		return "", err
+	}
+
+	var expense, previousExpense Money
+	byCategory := make(map[string]Money)
+	previousByCategory := make(map[string]Money)
+	byDay := make(map[string]Money)
+	// created_at is wall-clock time, so compare days rather than instants
+	firstDay := start.Format(dateLayout)
+	for _, e := range entries {
+		if e.Type != "expense" {
+			continue
+		}
+		day := e.CreatedAt.Format(dateLayout)
+		if day < firstDay {
+			previousExpense += e.Amount
+			previousByCategory[e.Category] += e.Amount
+			continue
+		}
+		expense += e.Amount
+		byCategory[e.Category] += e.Amount
+		byDay[day] += e.Amount
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📅 " + bold(fmt.Sprintf("Expenses of the last 7 days (%s – %s)", start.Format("2 Jan"), now.Format("2 Jan 2006"))) + "\n\n")
+	sb.WriteString(bold("By day:") + "\n")
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		sb.WriteString(fmt.Sprintf("%s %s: %s\n", d.Format("Mon"), d.Format("02/01"), moneyHTML(byDay[d.Format(dateLayout)])))
+	}
+
+	if len(byCategory) > 0 {
+		names := make([]string, 0, len(byCategory))
+		for name := range byCategory {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if byCategory[names[i]] != byCategory[names[j]] {
+				return byCategory[names[i]] > byCategory[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		sb.WriteString("\n" + bold("By category:") + "\n")
+		for _, name := range names {
+			line := fmt.Sprintf("• %s: %s (%.0f%%)", escapeHTML(name), moneyHTML(byCategory[name]), percentOf(byCategory[name].Float(), expense.Float()))
+			sb.WriteString(withTrend(line, byCategory[name], previousByCategory[name], "the week before") + "\n")
+		}
+	}
+
+	sb.WriteString("\n" + withTrend("Total: "+bold(formatMoney(expense)), expense, previousExpense, "the week before") + "\n")
+	sb.WriteString("The week before: " + moneyHTML(previousExpense))
+	return sb.String(), nil
+}
+
+// handleWeekStartCommand implements /weekstart [monday|sunday].
+func handleWeekStartCommand(chatID int64, args string) {
+	arg := strings.ToLower(strings.TrimSpace(args))
+	switch arg {
+	case "":
+		sendMessage(chatID, fmt.Sprintf("Weeks start on %s. Use /weekstart monday or /weekstart sunday to change it.", weekStartDay()))
+	case "monday", "sunday":
+		if err := setSetting("week_start", arg); err != nil {
+			sendMessage(chatID, "Failed to save the setting.")
+			reportError("setting the week start", err)
+			return
+		}
+		sendMessage(chatID, fmt.Sprintf("Weeks now start on %s.", weekStartDay()))
+	default:
+		sendMessage(chatID, "Usage: /weekstart monday or /weekstart sunday")
+	}
+}
+
+// weeklyPie returns the expenses of the seven days ending with now's day
+// by category, with this month's use of each category's budget.
+func weeklyPie(now time.Time) (weeklyPieChart, error) {
+	chart := weeklyPieChart{Title: "Expenses in the Last 7 Days"}
+	_, end := dayBounds(now)
+	entries, err := loadEntries(end.AddDate(0, 0, -7), end)
+	if err != nil {
+		return chart, err
+	}
+	byCategory := make(map[string]Money)
+	for _, e := range entries {
+		if e.Type == "expense" {
+			byCategory[e.Category] += e.Amount
+		}
+	}
+
+	budgets, err := loadCategoryBudgets()
+	if err != nil {
+		return chart, err
+	}
+	spent, err := categorySpending(now)
+	if err != nil {
+		return chart, err
+	}
+	use := make(map[string]float64)
+	for _, b := range budgets {
+		if b.Amount > 0 {
+			use[b.Category] = float64(spent[b.Category]) / float64(b.Amount)
+		}
+	}
+
+	names := make([]string, 0, len(byCategory))
+	for name := range byCategory {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return byCategory[names[i]] > byCategory[names[j]] })
+	for _, name := range names {
+		slice := weeklyPieSlice{Category: name, Total: byCategory[name].Float()}
+		if u, ok := use[name]; ok {
+			slice.BudgetUse = &u
+			slice.OverBudget = u > 1
+		}
+		chart.Slices = append(chart.Slices, slice)
+	}
+	return chart, nil
+}
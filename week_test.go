@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestWeeklyPieMarksCategoriesOverBudget(t *testing.T) {
+	newHarness(t)
+	now := appClock.Now()
+	for _, e := range []struct {
+		category string
+		amount   Money
+		daysAgo  int
+	}{
+		{"Food", 400000, 1},
+		{"Food", 300000, 20}, // this month, before the seven days
+		{"Transportation", 500000, 2},
+		{"Shopping", 100000, 3},
+	} {
+		if _, _, err := insertTransaction("expense", e.category, 1, e.amount*moneyScale, "", now.AddDate(0, 0, -e.daysAgo), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setCategoryBudget("Food", 600000*moneyScale); err != nil {
+		t.Fatal(err)
+	}
+	if err := setCategoryBudget("Shopping", 200000*moneyScale); err != nil {
+		t.Fatal(err)
+	}
+
+	chart, err := weeklyPie(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		category string
+		total    float64
+		use      float64 // 0 without a budget
+		over     bool
+	}{
+		{"Transportation", 500000, 0, false},
+		{"Food", 400000, 700000.0 / 600000, true},
+		{"Shopping", 100000, 0.5, false},
+	}
+	if len(chart.Slices) != len(want) {
+		t.Fatalf("got %d slices, want %d: %+v", len(chart.Slices), len(want), chart.Slices)
+	}
+	for i, w := range want {
+		got := chart.Slices[i]
+		use := 0.0
+		if got.BudgetUse != nil {
+			use = *got.BudgetUse
+		}
+		if got.Category != w.category || got.Total != w.total || use != w.use || got.OverBudget != w.over {
+			t.Errorf("slice %d is %s %.2f use %.3f over %t, want %s %.2f use %.3f over %t",
+				i, got.Category, got.Total, use, got.OverBudget, w.category, w.total, w.use, w.over)
+		}
+	}
+}